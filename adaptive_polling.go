@@ -0,0 +1,74 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+)
+
+// DefaultAdaptivePollingMinInterval is the interval after a walk that found new files close to the head
+var DefaultAdaptivePollingMinInterval = 500 * time.Millisecond
+
+// WithAdaptivePolling replaces the fixed polling interval: after a walk that found new one-block files, while the
+// head drift (see HeadDrift) is at most `maxDrift` (0 to ignore it), the merger walks again after `min`; after a walk
+// that found nothing, the interval doubles up to `max`. While catching up (new files but a larger drift), the
+// polling interval is used. The boundary wait still applies when shorter, see WithBoundaryWait.
+func WithAdaptivePolling(min, max, maxDrift time.Duration) Option {
+	return func(m *Merger) {
+		if min == 0 || max < min {
+			m.adaptivePolling = nil
+			return
+		}
+		m.adaptivePolling = &adaptivePoller{min: min, max: max, maxDrift: maxDrift, delay: min}
+	}
+}
+
+type adaptivePoller struct {
+	sync.Mutex
+	min      time.Duration
+	max      time.Duration
+	maxDrift time.Duration
+	delay    time.Duration
+}
+
+// observe is called after each walk with the number of one-block files not seen before
+func (p *adaptivePoller) observe(newFiles int, drift time.Duration, driftKnown bool, base time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	switch {
+	case newFiles != 0 && (p.maxDrift == 0 || (driftKnown && drift <= p.maxDrift)):
+		p.delay = p.min
+	case newFiles != 0:
+		p.delay = base
+	default:
+		p.delay *= 2
+		if p.delay < p.min {
+			p.delay = p.min
+		}
+	}
+	if p.delay > p.max {
+		p.delay = p.max
+	}
+	metrics.PollingInterval.SetFloat64(p.delay.Seconds())
+}
+
+func (p *adaptivePoller) current() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.delay
+}
@@ -0,0 +1,35 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptivePoller(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, 5*time.Second, 0,
+		WithAdaptivePolling(500*time.Millisecond, time.Minute, 10*time.Minute),
+	)
+	p := m.adaptivePolling
+	assert.Equal(t, 500*time.Millisecond, m.pollDelay(0))
+
+	var delays []time.Duration
+	for i := 0; i < 9; i++ {
+		p.observe(0, 0, false, m.timeBetweenPolling)
+		delays = append(delays, p.current())
+	}
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second,
+		time.Minute, time.Minute, time.Minute,
+	}, delays, "backs off up to the max while idle")
+	assert.Equal(t, time.Minute, m.pollDelay(0))
+
+	p.observe(3, time.Hour, true, m.timeBetweenPolling)
+	assert.Equal(t, 5*time.Second, p.current(), "catching up")
+
+	p.observe(3, time.Minute, true, m.timeBetweenPolling)
+	assert.Equal(t, 500*time.Millisecond, p.current(), "new files close to the head")
+
+	assert.Nil(t, NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, 5*time.Second, 0, WithAdaptivePolling(time.Minute, time.Second, 0)).adaptivePolling)
+}
@@ -0,0 +1,267 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// WithAdminListenAddr serves the admin API (pause, resume, trigger, reload) on its own listener,
+// so that network policies can restrict it independently of the public gRPC endpoints. Disabled if empty.
+func WithAdminListenAddr(addr string) Option {
+	return func(m *Merger) {
+		m.adminListenAddr = addr
+	}
+}
+
+// Pause stops the main loop from walking one-block files until Resume is called. A bundle already merging completes.
+func (m *Merger) Pause() {
+	if atomic.CompareAndSwapUint32(&m.paused, 0, 1) {
+		m.logger.Info("merging paused")
+	}
+}
+
+// errPausing stops the walk once the bundle containing the PauseAt block is merged
+var errPausing = errors.New("pausing")
+
+// PauseAt pauses the main loop once the bundle containing `blockNum` is merged, the bundles after it are left for
+// after Resume. Used to quiesce the merger right before a planned chain upgrade at a known height.
+func (m *Merger) PauseAt(blockNum uint64) {
+	atomic.StoreUint64(&m.pauseAt, blockNum+1)
+	m.logger.Info("merging will pause after the bundle containing block", zap.Uint64("pause_at_block", blockNum))
+	m.Trigger()
+}
+
+// PauseAtBlock returns the block passed to PauseAt, false when no pause is pending
+func (m *Merger) PauseAtBlock() (uint64, bool) {
+	target := atomic.LoadUint64(&m.pauseAt)
+	if target == 0 {
+		return 0, false
+	}
+	return target - 1, true
+}
+
+// pauseAtReached pauses the merger if the bundler moved past the bundle containing the PauseAt block,
+// it is called from the main loop after each one-block file
+func (m *Merger) pauseAtReached() bool {
+	blockNum, ok := m.PauseAtBlock()
+	if !ok || m.bundler.baseBlockNum <= blockNum {
+		return false
+	}
+	if !atomic.CompareAndSwapUint64(&m.pauseAt, blockNum+1, 0) {
+		return false // changed by another PauseAt in the meantime
+	}
+	m.logger.Info("bundle containing the pause block merged", zap.Uint64("pause_at_block", blockNum), zap.Uint64("base_block_num", m.bundler.baseBlockNum))
+	m.Pause()
+	return true
+}
+
+func (m *Merger) Resume() {
+	atomic.StoreUint64(&m.pauseAt, 0)
+	if atomic.CompareAndSwapUint32(&m.paused, 1, 0) {
+		m.logger.Info("merging resumed")
+		m.Trigger()
+	}
+}
+
+func (m *Merger) IsPaused() bool {
+	return atomic.LoadUint32(&m.paused) == 1
+}
+
+// Trigger starts the next cycle of the main loop right away instead of waiting for TimeBetweenPolling
+func (m *Merger) Trigger() {
+	select {
+	case m.triggerCh <- struct{}{}:
+	default: // a cycle is already triggered
+	}
+}
+
+// Reload makes the next cycle reset the bundler from the merged blocks store, dropping its in-memory state
+func (m *Merger) Reload() {
+	atomic.StoreUint32(&m.reloadRequested, 1)
+	m.Trigger()
+}
+
+type adminStatus struct {
+	State            MergerState           `json:"state"`
+	Paused           bool                  `json:"paused"`
+	BaseBlockNum     uint64                `json:"base_block_num"`
+	DeadLetters      []*DeletionFailure    `json:"dead_letters,omitempty"`
+	DeadDownloads    []*DownloadFailure    `json:"dead_downloads,omitempty"`
+	Divergences      []*ObservedDivergence `json:"observed_divergences,omitempty"`
+	Readers          []ReaderLiveness      `json:"readers"`
+	ETA              *ETA                  `json:"eta,omitempty"`
+	ChainState       ChainState            `json:"chain_state"`
+	WalkResumeName   string                `json:"walk_resume_name,omitempty"`
+	PendingDeletions []*PendingDeletion    `json:"pending_deletions,omitempty"`
+	ArrivalState     ArrivalState          `json:"arrival_state"`
+	PauseAtBlock     *uint64               `json:"pause_at_block,omitempty"`
+	CoverageGaps     []CoverageRange       `json:"coverage_gaps,omitempty"`
+	BlockHole        *BlockHole            `json:"block_hole,omitempty"`
+	MergedWatermark  *MergedWatermark      `json:"merged_watermark,omitempty"`
+	Bundle           *BundleStatus         `json:"bundle"`
+	FlushedBundle    *uint64               `json:"flushed_bundle,omitempty"`
+	PoisonRanges     []*PoisonRange        `json:"poison_ranges,omitempty"`
+	Identity         InstanceIdentity      `json:"identity"`
+}
+
+func (m *Merger) adminHandler() http.Handler {
+	action := func(f func()) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			f()
+			m.writeAdminStatus(w)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", action(m.Pause))
+	mux.HandleFunc("/pause-at", m.pauseAtHandler)
+	mux.HandleFunc("/resume", action(m.Resume))
+	mux.HandleFunc("/trigger", action(m.Trigger))
+	mux.HandleFunc("/reload", action(m.Reload))
+	mux.HandleFunc("/force-flush", m.forceFlushHandler)
+	mux.HandleFunc("/retry-poison-range", m.retryPoisonRangeHandler)
+	mux.HandleFunc("/one-block-files", m.inspectHandler)
+	mux.HandleFunc("/forkdb-diffs", m.forkDBDiffsHandler)
+	mux.HandleFunc("/confirm-deletion", m.confirmDeletionHandler)
+	mux.HandleFunc("/statz", m.statzHandler)
+	mux.HandleFunc("/livez", m.livezHandler)
+	mux.HandleFunc("/progress", m.progressHandler)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		m.writeAdminStatus(w)
+	})
+	return mux
+}
+
+func (m *Merger) writeAdminStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.collectAdminStatus())
+}
+
+func (m *Merger) collectAdminStatus() *adminStatus {
+	status := &adminStatus{
+		State:            m.State(),
+		Paused:           m.IsPaused(),
+		BaseBlockNum:     m.bundler.BaseBlockNum(),
+		Readers:          m.ReadersLiveness(),
+		ETA:              m.ETA(),
+		ChainState:       m.ChainState(),
+		WalkResumeName:   m.WalkResumeName(),
+		PendingDeletions: m.PendingDeletions(),
+		ArrivalState:     m.ArrivalState(),
+		CoverageGaps:     m.CoverageGaps(),
+		BlockHole:        m.BlockHole(),
+		MergedWatermark:  m.MergedWatermark(),
+		Bundle:           m.BundleStatus(),
+		PoisonRanges:     m.PoisonRanges(),
+		Identity:         m.Identity(),
+	}
+	if blockNum, ok := m.PauseAtBlock(); ok {
+		status.PauseAtBlock = &blockNum
+	}
+	if baseBlock, ok := m.FlushedBundle(); ok {
+		status.FlushedBundle = &baseBlock
+	}
+	if deleter, ok := m.io.(interface{ DeadLetters() []*DeletionFailure }); ok {
+		status.DeadLetters = deleter.DeadLetters()
+	}
+	if downloader, ok := m.io.(interface{ DownloadDeadLetters() []*DownloadFailure }); ok {
+		status.DeadDownloads = downloader.DownloadDeadLetters()
+	}
+	if observer, ok := m.io.(interface{ ObservedDivergences() []*ObservedDivergence }); ok {
+		status.Divergences = observer.ObservedDivergences()
+	}
+	return status
+}
+
+func (m *Merger) pauseAtHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	blockNum, err := strconv.ParseUint(r.URL.Query().Get("block"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid block: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.PauseAt(blockNum)
+	m.writeAdminStatus(w)
+}
+
+func (m *Merger) forceFlushHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, _, err := m.ForceFlush(r.Context()); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrNothingToFlush) {
+			code = http.StatusConflict
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	m.writeAdminStatus(w)
+}
+
+func (m *Merger) retryPoisonRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	blockNum, err := strconv.ParseUint(r.URL.Query().Get("block"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid block: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := m.RetryPoisonRange(r.Context(), blockNum); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrNotPoisoned) {
+			code = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	m.writeAdminStatus(w)
+}
+
+func (m *Merger) startAdminServer() {
+	if m.adminListenAddr == "" {
+		return
+	}
+
+	srv := &http.Server{Addr: m.adminListenAddr, Handler: m.adminHandler()}
+	m.OnTerminated(func(_ error) {
+		srv.Shutdown(context.Background())
+	})
+
+	m.logger.Info("starting admin server", zap.String("listen_addr", m.adminListenAddr))
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.Shutdown(err)
+		}
+	}()
+}
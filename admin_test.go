@@ -0,0 +1,94 @@
+package merger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerger_AdminHandler(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	handler := m.adminHandler()
+
+	call := func(method, path string) (int, *adminStatus) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		status := &adminStatus{}
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), status))
+		}
+		return rec.Code, status
+	}
+
+	code, status := call(http.MethodPost, "/pause")
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Paused)
+	assert.EqualValues(t, 100, status.BaseBlockNum)
+	assert.True(t, m.IsPaused())
+
+	code, _ = call(http.MethodGet, "/resume")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	assert.True(t, m.IsPaused())
+
+	code, status = call(http.MethodPost, "/resume")
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, status.Paused)
+
+	code, _ = call(http.MethodPost, "/reload")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 1, m.reloadRequested)
+}
+
+func TestMerger_TriggerInterruptsPolling(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Hour, 0)
+
+	done := make(chan struct{})
+	go func() {
+		m.sleepUntilNextPoll(time.Now(), m.timeBetweenPolling)
+		close(done)
+	}()
+	m.Trigger()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("trigger did not interrupt polling sleep")
+	}
+}
+
+func TestMerger_PauseAt(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	handler := m.adminHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/pause-at?block=abc", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/pause-at?block=150", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	status := &adminStatus{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), status))
+	require.NotNil(t, status.PauseAtBlock)
+	assert.EqualValues(t, 150, *status.PauseAtBlock)
+
+	assert.False(t, m.pauseAtReached())
+	assert.False(t, m.IsPaused())
+
+	m.bundler.baseBlockNum = 200 // bundle 100 containing block 150 was merged
+	assert.True(t, m.pauseAtReached())
+	assert.True(t, m.IsPaused())
+	_, pending := m.PauseAtBlock()
+	assert.False(t, pending)
+
+	m.PauseAt(250)
+	m.Resume()
+	_, pending = m.PauseAtBlock()
+	assert.False(t, pending)
+	assert.False(t, m.IsPaused())
+}
@@ -16,11 +16,15 @@ package merger
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"github.com/sadiq1971/merger/metrics"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sadiq1971/merger"
+	"github.com/sadiq1971/merger/metrics"
 	"github.com/streamingfast/bstream"
 	"github.com/streamingfast/dgrpc"
 	"github.com/streamingfast/dmetrics"
@@ -30,24 +34,16 @@ import (
 	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-type Config struct {
-	StorageOneBlockFilesPath     string
-	StorageMergedBlocksFilesPath string
-	StorageForkedBlocksFilesPath string
-
-	GRPCListenAddr string
-
-	PruneForkedBlocksAfter uint64
-
-	TimeBetweenPruning time.Duration
-	TimeBetweenPolling time.Duration
-	StopBlock          uint64
-}
-
 type App struct {
 	*shutter.Shutter
 	config         *Config
 	readinessProbe pbhealth.HealthClient
+	localHealth    pbhealth.HealthServer // used instead of readinessProbe when the gRPC API is served over TLS
+	merger         *merger.Merger        // nil until the merger is initiated
+
+	// chain is set when the App builds one of the chains of a MultiApp, which runs the merger
+	chain       string
+	deleterPool *merger.DeleterPool
 }
 
 func New(config *Config) *App {
@@ -58,19 +54,48 @@ func New(config *Config) *App {
 }
 
 func (a *App) Run() error {
-	zlog.Info("running merger", zap.Reflect("config", a.config))
+	zlog.Info("running merger", zap.Reflect("config", a.config.redacted()))
+
+	if a.config.ValidateOnly {
+		return a.runValidateOnly()
+	}
 
 	dmetrics.Register(metrics.MetricSet)
 
+	if a.config.readOnly() {
+		if err := a.config.validateObserverMode(); err != nil {
+			return err
+		}
+	}
+
 	oneBlockStoreStore, err := dstore.NewDBinStore(a.config.StorageOneBlockFilesPath)
 	if err != nil {
 		return fmt.Errorf("failed to init source archive store: %w", err)
 	}
+	if fsStore, ok := merger.NewFSStore(oneBlockStoreStore); ok {
+		oneBlockStoreStore = fsStore
+	}
+	oneBlockStoreStore, err = a.scopeStore(oneBlockStoreStore, a.config.OneBlocksStoreScopePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to scope source archive store: %w", err)
+	}
 
-	mergedBlocksStore, err := dstore.NewDBinStore(a.config.StorageMergedBlocksFilesPath)
+	mergedBlocksPath, replicaPaths, err := a.config.mergedBlocksDestinations()
+	if err != nil {
+		return err
+	}
+	mergedBlocksStore, err := a.newMergedBlocksStore(mergedBlocksPath)
 	if err != nil {
 		return fmt.Errorf("failed to init destination archive store: %w", err)
 	}
+	var replicaStores []dstore.Store
+	for _, path := range replicaPaths {
+		replicaStore, err := a.newMergedBlocksStore(path)
+		if err != nil {
+			return fmt.Errorf("failed to init merged blocks replica store: %w", err)
+		}
+		replicaStores = append(replicaStores, replicaStore)
+	}
 
 	var forkedBlocksStore dstore.Store
 	if a.config.StorageForkedBlocksFilesPath != "" {
@@ -78,10 +103,253 @@ func (a *App) Run() error {
 		if err != nil {
 			return fmt.Errorf("failed to init destination archive store: %w", err)
 		}
+		forkedBlocksStore, err = a.scopeStore(forkedBlocksStore, "")
+		if err != nil {
+			return fmt.Errorf("failed to scope forked blocks store: %w", err)
+		}
+	}
+
+	if a.config.NormalizeOneBlockFiles {
+		precision, err := merger.ParseTimestampPrecision(a.config.OneBlockTimestampPrecision)
+		if err != nil {
+			return err
+		}
+		report, err := merger.NormalizeOneBlockFiles(context.Background(), zlog, oneBlockStoreStore, merger.NormalizeOptions{
+			DryRun:    a.config.NormalizeOneBlockFilesDryRun,
+			Precision: precision,
+			Suffix:    a.config.NormalizeOneBlockFilesSuffix,
+		})
+		if err != nil {
+			return fmt.Errorf("normalizing one-block files: %w", err)
+		}
+		if a.config.NormalizeOneBlockFilesDryRun {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			err := encoder.Encode(report)
+			a.Shutdown(err)
+			return err
+		}
 	}
 
 	bundleSize := uint64(5)
 
+	logFields, logSamplings, err := a.config.logOptions()
+	if err != nil {
+		return err
+	}
+	if a.chain != "" {
+		logFields = append(logFields, zap.String("chain", a.chain))
+	}
+
+	spanTracer, err := merger.ParseTracingExporter(a.config.TracingExporter, zlog)
+	if err != nil {
+		return err
+	}
+
+	ioOptions := []merger.DStoreIOOption{merger.WithIOLogFields(logFields...), merger.WithIOSpanTracer(spanTracer)}
+	if a.deleterPool != nil {
+		ioOptions = append(ioOptions, merger.WithDeleterPool(a.deleterPool))
+	}
+	for component, sampling := range logSamplings {
+		ioOptions = append(ioOptions, merger.WithIOLogSampling(component, sampling))
+	}
+	for _, spec := range a.config.StorageMergedBlocksFilesRanges {
+		low, high, storeURL, err := parseMergedBlocksStoreRange(spec, bundleSize)
+		if err != nil {
+			return err
+		}
+		store, err := a.newMergedBlocksStore(storeURL)
+		if err != nil {
+			return fmt.Errorf("failed to init destination archive store for range %q: %w", spec, err)
+		}
+		ioOptions = append(ioOptions, merger.WithMergedBlocksStoreRange(low, high, store))
+	}
+
+	if _, err := merger.ParseTimestampPrecision(a.config.OneBlockTimestampPrecision); err != nil {
+		return err
+	}
+
+	if a.config.ExistingBundlePolicy != "" {
+		existingBundlePolicy, err := merger.ParseExistingBundlePolicy(a.config.ExistingBundlePolicy)
+		if err != nil {
+			return err
+		}
+		ioOptions = append(ioOptions, merger.WithExistingBundlePolicy(existingBundlePolicy))
+	}
+	if a.config.OneBlockChecksumsStorePath != "" {
+		checksumPolicy, err := merger.ParseChecksumPolicy(a.config.OneBlockChecksumPolicy)
+		if err != nil {
+			return err
+		}
+		checksumStore, err := a.newSimpleStore("one-block checksums", a.config.OneBlockChecksumsStorePath)
+		if err != nil {
+			return err
+		}
+		ioOptions = append(ioOptions, merger.WithChecksums(checksumStore, checksumPolicy))
+	}
+
+	if a.config.MergedFilesCacheSize != 0 {
+		ioOptions = append(ioOptions, merger.WithMergedFilesCacheSize(a.config.MergedFilesCacheSize))
+	}
+	if a.config.PrefixBoundedListing {
+		ioOptions = append(ioOptions, merger.WithPrefixBoundedListing())
+	}
+	if a.config.OneBlockCacheBytes != 0 {
+		ioOptions = append(ioOptions, merger.WithOneBlockCacheBytes(a.config.OneBlockCacheBytes))
+	}
+
+	if a.config.BlockTimeMaxGap > 0 || a.config.BlockTimeBurstInterval > 0 {
+		ioOptions = append(ioOptions, merger.WithBlockTimeAnalysis(a.config.BlockTimeMaxGap, a.config.BlockTimeBurstInterval))
+	}
+	ioOptions = append(ioOptions, a.pipelineIOOptions()...)
+
+	intentsOption, err := a.mergeIntentsOption()
+	if err != nil {
+		return err
+	}
+	if intentsOption != nil {
+		ioOptions = append(ioOptions, intentsOption)
+	}
+	if len(a.config.ValidationPolicies) != 0 {
+		validationPolicies, err := merger.ParseValidationPolicies(a.config.ValidationPolicies)
+		if err != nil {
+			return err
+		}
+		ioOptions = append(ioOptions, merger.WithValidationPolicies(validationPolicies))
+	}
+	deadLettersOption, err := a.downloadDeadLettersOption()
+	if err != nil {
+		return err
+	}
+	if deadLettersOption != nil {
+		ioOptions = append(ioOptions, deadLettersOption)
+	}
+	if a.config.VerifyAfterMerge {
+		ioOptions = append(ioOptions, merger.WithVerifyAfterMerge())
+	}
+	if policy := a.config.storeRetryPolicy(); policy != nil {
+		ioOptions = append(ioOptions, merger.WithRetryPolicy(*policy))
+	}
+	if a.config.MergedBlocksCursor {
+		ioOptions = append(ioOptions, merger.WithMergedCursor())
+	}
+	if a.config.BundleStats || a.config.BundleStatsStorePath != "" {
+		var statsStore dstore.Store
+		if a.config.BundleStatsStorePath != "" {
+			if statsStore, err = a.newSimpleStore("bundle stats", a.config.BundleStatsStorePath); err != nil {
+				return err
+			}
+		}
+		ioOptions = append(ioOptions, merger.WithBundleStats(statsStore))
+	}
+	if len(replicaStores) != 0 {
+		ioOptions = append(ioOptions, merger.WithMergedBlocksReplicas(replicaStores...))
+	}
+	if len(a.config.ProtocolUpgrades) != 0 {
+		var upgrades []merger.ProtocolUpgrade
+		for _, spec := range a.config.ProtocolUpgrades {
+			upgrade, err := merger.ParseProtocolUpgrade(spec)
+			if err != nil {
+				return err
+			}
+			upgrades = append(upgrades, upgrade)
+		}
+		var tagsStore dstore.Store
+		if a.config.ProtocolUpgradeTagsStorePath != "" {
+			if tagsStore, err = a.newSimpleStore("protocol upgrade tags", a.config.ProtocolUpgradeTagsStorePath); err != nil {
+				return err
+			}
+		}
+		ioOptions = append(ioOptions, merger.WithProtocolUpgrades(tagsStore, upgrades...))
+	}
+	suffixCanonicalization, err := merger.ParseSuffixCanonicalization(a.config.SuffixCanonicalization)
+	if err != nil {
+		return err
+	}
+	if suffixCanonicalization != merger.SuffixKeep {
+		ioOptions = append(ioOptions, merger.WithSuffixCanonicalization(suffixCanonicalization, a.config.CanonicalSuffix))
+	}
+	if a.config.BlockRewriter != nil {
+		ioOptions = append(ioOptions, merger.WithBlockRewriter(a.config.BlockRewriter))
+	}
+	switch {
+	case a.config.PressureProbeURL != "":
+		ioOptions = append(ioOptions, merger.WithPressureSignal(merger.NewHTTPPressureSignal(a.config.PressureProbeURL, 2*time.Second), a.config.PressureMaxDefer))
+	case a.config.PressureFilePath != "":
+		ioOptions = append(ioOptions, merger.WithPressureSignal(&merger.FilePressureSignal{Path: a.config.PressureFilePath}, a.config.PressureMaxDefer))
+	}
+
+	if a.config.MergedBundleFormat != "" {
+		bundleWriter, err := merger.ParseBundleFormat(a.config.MergedBundleFormat)
+		if err != nil {
+			return err
+		}
+		ioOptions = append(ioOptions, merger.WithBundleWriter(bundleWriter))
+	}
+	if a.config.MergedCompressionLevel != 0 {
+		ioOptions = append(ioOptions, merger.WithMergedCompressionLevel(a.config.MergedCompressionLevel))
+	}
+
+	var belowLowestBlockPolicy merger.BelowLowestBlockPolicy
+	if a.config.BelowLowestBlockPolicy != "" {
+		belowLowestBlockPolicy, err = merger.ParseBelowLowestBlockPolicy(a.config.BelowLowestBlockPolicy)
+		if err != nil {
+			return err
+		}
+	}
+	if belowLowestBlockPolicy == merger.BelowLowestBlockArchive {
+		if a.config.StorageArchiveFilesPath == "" {
+			return fmt.Errorf("archiving one-block files below the first streamable block requires an archive store")
+		}
+		archiveStore, err := dstore.NewDBinStore(a.config.StorageArchiveFilesPath)
+		if err != nil {
+			return fmt.Errorf("failed to init archive store: %w", err)
+		}
+		archiveStore, err = a.scopeStore(archiveStore, "")
+		if err != nil {
+			return fmt.Errorf("failed to scope archive store: %w", err)
+		}
+		ioOptions = append(ioOptions, merger.WithArchiveStore(archiveStore))
+	}
+
+	var hubHandoff *merger.HubHandoff
+	if a.config.ForkableHub != nil {
+		hubHandoff = merger.NewHubHandoff(merger.DefaultHubHandoffSize)
+		ioOptions = append(ioOptions, merger.WithHubHandoff(hubHandoff))
+	}
+
+	if a.config.MergedFileNamesCompatibility {
+		ioOptions = append(ioOptions, merger.WithMergedFileNamesCompatibility())
+	}
+	if a.config.BootstrapBundles > 0 {
+		ioOptions = append(ioOptions, merger.WithBootstrapBundles(a.config.BootstrapBundles, a.config.BootstrapConcurrency))
+		if a.config.MergedFilesCacheSize == 0 && a.config.BootstrapBundles > merger.DefaultMergedFilesCacheSize {
+			ioOptions = append(ioOptions, merger.WithMergedFilesCacheSize(a.config.BootstrapBundles))
+		}
+	}
+	if a.config.OneBlockDeletionRate > 0 {
+		ioOptions = append(ioOptions, merger.WithDeletionRate(a.config.OneBlockDeletionRate))
+	}
+	if a.config.DedupOneBlockPayloads {
+		var hasher merger.ObjectHasher
+		if s3Hasher, err := merger.NewS3ObjectHasher(a.config.StorageOneBlockFilesPath, oneBlockStoreStore); err != nil {
+			zlog.Info("cannot hash one-block files from their metadata, their copies are downloaded", zap.Error(err))
+		} else {
+			hasher = s3Hasher
+		}
+		ioOptions = append(ioOptions, merger.WithPayloadDedup(hasher))
+	}
+	if fsStore, ok := merger.AsFSStore(oneBlockStoreStore); ok {
+		ioOptions = append(ioOptions, merger.WithBulkDelete(fsStore))
+	} else if a.config.OneBlockBulkDelete {
+		bulkDeleter, err := merger.NewS3BulkDeleter(a.config.StorageOneBlockFilesPath, oneBlockStoreStore)
+		if err != nil {
+			zlog.Info("cannot bulk delete one-block files, deleting them one by one", zap.Error(err))
+		} else {
+			ioOptions = append(ioOptions, merger.WithBulkDelete(bulkDeleter))
+		}
+	}
+
 	// we are setting the backoff here for dstoreIO
 	io := merger.NewDStoreIO(
 		zlog,
@@ -91,50 +359,332 @@ func (a *App) Run() error {
 		forkedBlocksStore,
 		5,
 		500*time.Millisecond,
-		bundleSize)
+		bundleSize,
+		ioOptions...,
+	)
 
+	if a.config.EstimateCleanup {
+		estimate, err := merger.EstimateCleanup(context.Background(), io, mergedBlocksStore)
+		if err != nil {
+			return fmt.Errorf("estimating cleanup: %w", err)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(estimate)
+		a.Shutdown(err)
+		return err
+	}
+
+	libNumEncoding, err := merger.ParseLibNumEncoding(a.config.OneBlockLibNumEncoding)
+	if err != nil {
+		return err
+	}
+
+	var backfillStart, backfillStop uint64
+	if a.config.BackfillRange != "" {
+		backfillStart, backfillStop, err = parseBlockRange("backfill range", a.config.BackfillRange)
+		if err != nil {
+			return err
+		}
+	}
+	var expectedMergedRanges []merger.CoverageRange
+	for _, spec := range a.config.ExpectedMergedRanges {
+		low, high, err := parseBlockRange("expected merged range", spec)
+		if err != nil {
+			return err
+		}
+		expectedMergedRanges = append(expectedMergedRanges, merger.CoverageRange{InclusiveLowBlock: low, ExclusiveHighBlock: high})
+	}
+
+	mergerOptions := []merger.Option{
+		merger.WithLogFields(logFields...), // first, the options below log with it
+		merger.WithSpanTracer(spanTracer),
+		merger.WithLibNumEncoding(libNumEncoding),
+		merger.WithForkDBSoftLimit(a.config.ForkDBSoftLimitBytes),
+		merger.WithMaxForkedBlockAge(a.config.MaxForkedBlockAgeBlocks, a.config.MaxForkedBlockAge),
+		merger.WithCompletionReportPath(a.config.CompletionReportPath),
+		merger.WithStateFile(a.config.StateFilePath),
+		merger.WithAdminListenAddr(a.config.AdminListenAddr),
+		merger.WithProgressListenAddr(a.config.ProgressListenAddr),
+	}
+	for component, sampling := range logSamplings {
+		mergerOptions = append(mergerOptions, merger.WithLogSampling(component, sampling))
+	}
+	if a.config.DeleteAfterMergedConfirmations > 1 || a.config.DeleteAfterMergedDelay != 0 {
+		mergerOptions = append(mergerOptions, merger.WithDeletionDeferral(a.config.DeleteAfterMergedConfirmations, a.config.DeleteAfterMergedDelay))
+	}
+	mergerOptions = append(mergerOptions, a.healthOptions()...)
+	if a.config.StartBlock != 0 {
+		if err := checkStartBlockAlignment(a.config.StartBlock, bundleSize, a.config.AllowStartBlockRealignment); err != nil {
+			return err
+		}
+		mergerOptions = append(mergerOptions, merger.WithStartBlock(a.config.StartBlock))
+	}
+	if a.config.ForceStartBlock != 0 {
+		if err := checkForceStartBlock(a.config, bundleSize); err != nil {
+			return err
+		}
+		mergerOptions = append(mergerOptions, merger.WithForceStartBlock(a.config.ForceStartBlock))
+	}
+	if a.config.SkipBootstrap {
+		mergerOptions = append(mergerOptions, merger.WithSkipBootstrap())
+	}
+	if a.config.LeftoverBootstrap {
+		mergerOptions = append(mergerOptions, merger.WithLeftoverBootstrap())
+	}
+	if a.config.IrreversibleConfirmations >= bundleSize {
+		return fmt.Errorf("irreversible confirmations (%d) must be lower than the bundle size (%d)", a.config.IrreversibleConfirmations, bundleSize)
+	}
+	mergerOptions = append(mergerOptions, merger.WithIrreversibleConfirmations(a.config.IrreversibleConfirmations))
+	bundleCompletion, err := merger.ParseBundleCompletion(a.config.BundleCompletion, a.config.BundleCompletionTimeout)
+	if err != nil {
+		return err
+	}
+	mergerOptions = append(mergerOptions, merger.WithBundleCompletion(bundleCompletion))
+	duplicatePolicy, err := merger.ParseDuplicatePolicy(a.config.DuplicatePolicy)
+	if err != nil {
+		return err
+	}
+	mergerOptions = append(mergerOptions, merger.WithDuplicatePolicy(duplicatePolicy))
+	if a.config.BundleIndexStorePath != "" {
+		indexStore, err := a.newSimpleStore("bundle index", a.config.BundleIndexStorePath)
+		if err != nil {
+			return err
+		}
+		mergerOptions = append(mergerOptions, merger.WithIndexWriter(merger.NewStoreIndexWriter(indexStore)))
+	}
+	mergerOptions = append(mergerOptions, a.pipelineOptions()...)
+	if a.config.GRPCTLSCertFile != "" {
+		tlsOption, err := merger.GRPCTLSServerOption(a.config.GRPCTLSCertFile, a.config.GRPCTLSKeyFile, a.config.GRPCTLSClientCAFile)
+		if err != nil {
+			return err
+		}
+		mergerOptions = append(mergerOptions, merger.WithGRPCServerOptions(tlsOption))
+	}
+	if a.config.GRPCAuthToken != "" {
+		mergerOptions = append(mergerOptions, merger.WithGRPCAuthToken(a.config.GRPCAuthToken))
+	}
+	if a.config.GRPCRateLimit > 0 {
+		mergerOptions = append(mergerOptions, merger.WithGRPCRateLimit(a.config.GRPCRateLimit, a.config.GRPCRateLimitBurst))
+	}
+	if a.config.GRPCRequestLogging {
+		mergerOptions = append(mergerOptions, merger.WithGRPCRequestLogging())
+	}
+	if a.config.BoundaryWait > 0 {
+		mergerOptions = append(mergerOptions, merger.WithBoundaryWait(a.config.BoundaryWait))
+	}
+	if a.config.AdaptivePollingMaxInterval != 0 {
+		minInterval := a.config.AdaptivePollingMinInterval
+		if minInterval == 0 {
+			minInterval = merger.DefaultAdaptivePollingMinInterval
+		}
+		if a.config.AdaptivePollingMaxInterval < minInterval {
+			return fmt.Errorf("adaptive polling max interval (%s) must be at least the min interval (%s)", a.config.AdaptivePollingMaxInterval, minInterval)
+		}
+		mergerOptions = append(mergerOptions, merger.WithAdaptivePolling(minInterval, a.config.AdaptivePollingMaxInterval, a.config.AdaptivePollingMaxDrift))
+	}
+	if a.config.ForkDBDiffsHistory > 0 {
+		mergerOptions = append(mergerOptions, merger.WithForkDBDiffs(a.config.ForkDBDiffsHistory))
+	}
+	if belowLowestBlockPolicy != "" {
+		mergerOptions = append(mergerOptions, merger.WithBelowLowestBlockPolicy(belowLowestBlockPolicy))
+	}
+	if a.config.ExpectedBlockInterval != 0 {
+		mergerOptions = append(mergerOptions, merger.WithChainHaltDetection(a.config.ExpectedBlockInterval, a.config.ChainHaltMissedBlocks, a.config.ChainHaltQuorum))
+	}
+	if a.config.MaxBundleOpenDuration != 0 {
+		mergerOptions = append(mergerOptions, merger.WithMaxBundleOpenDuration(a.config.MaxBundleOpenDuration))
+	}
+	if a.config.HoleGracePeriod != 0 {
+		mergerOptions = append(mergerOptions, merger.WithHoleDetection(a.config.HoleGracePeriod, a.config.SkipHoles))
+	}
+	if a.config.WalkBudget > 0 {
+		mergerOptions = append(mergerOptions, merger.WithWalkBudget(a.config.WalkBudget))
+	}
+	if a.config.MaxDeletedFilesPerCycle > 0 {
+		var confirmationStore dstore.Store
+		if a.config.DeletionConfirmationStorePath != "" {
+			if confirmationStore, err = a.newSimpleStore("deletion confirmation", a.config.DeletionConfirmationStorePath); err != nil {
+				return err
+			}
+		}
+		mergerOptions = append(mergerOptions, merger.WithDeleteSafetyValve(a.config.MaxDeletedFilesPerCycle, confirmationStore))
+	}
+	notifierOption, err := a.oneBlockNotifierOption(oneBlockStoreStore)
+	if err != nil {
+		return err
+	}
+	if notifierOption != nil {
+		mergerOptions = append(mergerOptions, notifierOption)
+	}
+	if len(expectedMergedRanges) > 0 {
+		mergerOptions = append(mergerOptions, merger.WithCoverageManifest(expectedMergedRanges, a.config.CoverageCheckInterval))
+	}
+	if a.config.ArrivalDropRatio > 0 || a.config.ArrivalFloodRatio > 0 {
+		mergerOptions = append(mergerOptions, merger.WithArrivalAnomalyDetection(a.config.ArrivalDropRatio, a.config.ArrivalFloodRatio, a.config.ArrivalFloodMaxFilesPerCycle))
+	}
+	if a.config.ShutdownGracePeriod != 0 {
+		mergerOptions = append(mergerOptions, merger.WithShutdownGracePeriod(a.config.ShutdownGracePeriod))
+	}
+	if a.config.ProbeStartBlock {
+		mergerOptions = append(mergerOptions, merger.WithStartBlockProbing())
+	}
+	mergerOptions = append(mergerOptions, merger.WithInstanceIdentity(a.config.MergeIntentsInstanceID, a.config.hash()))
+	if a.config.MergedBlocksRetention != 0 || a.config.MergedBlocksRetentionBlocks != 0 {
+		mergerOptions = append(mergerOptions, merger.WithMergedBlocksRetention(a.config.MergedBlocksRetention, a.config.MergedBlocksRetentionBlocks))
+	}
+	if a.config.DiagnosticsStorePath != "" {
+		diagnosticsStore, err := a.newSimpleStore("diagnostics", a.config.DiagnosticsStorePath)
+		if err != nil {
+			return err
+		}
+		mergerOptions = append(mergerOptions, merger.WithDiagnostics(diagnosticsStore, a.config.DiagnosticsInterval, a.config.redacted()))
+	}
+	if a.config.MergedWatermarkStorePath != "" {
+		watermarkStore, err := a.newSimpleStore("merged watermark", a.config.MergedWatermarkStorePath)
+		if err != nil {
+			return err
+		}
+		mergerOptions = append(mergerOptions, merger.WithMergedWatermark(watermarkStore))
+	}
+	if a.config.PoisonRangesStorePath != "" {
+		poisonStore, err := a.newSimpleStore("poison ranges", a.config.PoisonRangesStorePath)
+		if err != nil {
+			return err
+		}
+		maxAttempts := a.config.PoisonRangeMaxAttempts
+		if maxAttempts == 0 {
+			maxAttempts = 3
+		}
+		mergerOptions = append(mergerOptions, merger.WithPoisonRanges(poisonStore, maxAttempts))
+	}
+	if a.config.CompareLinearBundler {
+		linearBundler := merger.NewLinearBundler(bundleSize)
+		linearBundler.IrreversibleConfirmations = a.config.IrreversibleConfirmations
+		mergerOptions = append(mergerOptions, merger.WithShadowBundler(linearBundler, nil))
+	}
+	mergerOptions = append(mergerOptions, a.readOnlyOptions(io)...)
+	if a.config.CapturePath != "" {
+		capture, err := os.Create(a.config.CapturePath)
+		if err != nil {
+			return fmt.Errorf("failed to create capture file: %w", err)
+		}
+		a.OnTerminated(func(_ error) { capture.Close() })
+		mergerOptions = append(mergerOptions, merger.WithCapture(capture)) // last, to capture what the merger sees
+	}
+
+	grpcListenAddr := a.config.GRPCListenAddr
+	if a.chain != "" {
+		grpcListenAddr = "" // served by the MultiApp
+	}
 	m := merger.NewMerger(
 		zlog,
-		a.config.GRPCListenAddr,
+		grpcListenAddr,
 		io,
 		bstream.GetProtocolFirstStreamableBlock,
 		bundleSize,
 		a.config.PruneForkedBlocksAfter,
 		a.config.TimeBetweenPruning,
 		a.config.TimeBetweenPolling,
-		a.config.StopBlock,
+		exclusiveStopBlock(a.config.StopBlock, bundleSize),
+		mergerOptions...,
 	)
 	zlog.Info("merger initiated")
 
-	gs, err := dgrpc.NewInternalClient(a.config.GRPCListenAddr)
-	if err != nil {
-		return fmt.Errorf("cannot create readiness probe")
+	if a.config.BackfillRange != "" {
+		go func() {
+			a.Shutdown(m.Backfill(context.Background(), backfillStart, backfillStop, a.config.BackfillConcurrency))
+		}()
+		return nil
+	}
+
+	a.merger = m
+	if a.chain != "" {
+		a.OnTerminating(m.Shutdown)
+		m.OnTerminated(a.Shutdown)
+		return nil // run by the MultiApp
+	}
+	if a.config.GRPCTLSCertFile != "" {
+		a.localHealth = m // the internal client only speaks plain-text
+	} else {
+		gs, err := dgrpc.NewInternalClient(a.config.GRPCListenAddr)
+		if err != nil {
+			return fmt.Errorf("cannot create readiness probe")
+		}
+		a.readinessProbe = pbhealth.NewHealthClient(gs)
 	}
-	a.readinessProbe = pbhealth.NewHealthClient(gs)
 
 	a.OnTerminating(m.Shutdown)
 	m.OnTerminated(a.Shutdown)
 
 	go m.Run()
+	if hubHandoff != nil {
+		go a.subscribeToHub(hubHandoff)
+	}
 
 	zlog.Info("merger running")
 	return nil
 }
 
-func (a *App) IsReady() bool {
-	if a.readinessProbe == nil {
-		return false
+// subscribeToHub feeds the hub handoff once the hub is ready
+func (a *App) subscribeToHub(handoff *merger.HubHandoff) {
+	fh := a.config.ForkableHub
+	select {
+	case <-fh.Ready:
+	case <-a.Terminating():
+		return
 	}
-
-	resp, err := a.readinessProbe.Check(context.Background(), &pbhealth.HealthCheckRequest{})
+	src, err := handoff.Subscribe(fh, fh.LowestBlockNum())
 	if err != nil {
-		zlog.Info("merger readiness probe error", zap.Error(err))
-		return false
+		zlog.Warn("cannot receive blocks from the hub, one-block files will be downloaded", zap.Error(err))
+		return
+	}
+	a.OnTerminating(src.Shutdown)
+	zlog.Info("receiving blocks from the hub", zap.Uint64("lowest_block_num", fh.LowestBlockNum()))
+}
+
+// parseBlockRange parses `<inclusive_start>:<exclusive_stop>`, `name` describes the range in errors
+func parseBlockRange(name, spec string) (start, stop uint64, err error) {
+	startStr, stopStr, found := strings.Cut(spec, ":")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid %s %q, expected <start_block>:<stop_block>", name, spec)
+	}
+	if start, err = strconv.ParseUint(startStr, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid start block in %s %q: %w", name, spec, err)
+	}
+	if stop, err = strconv.ParseUint(stopStr, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid stop block in %s %q: %w", name, spec, err)
+	}
+	if stop <= start {
+		return 0, 0, fmt.Errorf("%s %q is empty", name, spec)
 	}
+	return
+}
 
-	if resp.Status == pbhealth.HealthCheckResponse_SERVING {
-		return true
+func checkStartBlockAlignment(startBlock, bundleSize uint64, allowRealignment bool) error {
+	if startBlock%bundleSize == 0 || allowRealignment {
+		return nil
 	}
+	return fmt.Errorf("start block %d is not aligned on bundle size %d, set AllowStartBlockRealignment to produce one shorter bundle from %d to %d", startBlock, bundleSize, startBlock, (startBlock/bundleSize+1)*bundleSize)
+}
 
-	return false
+func checkForceStartBlock(c *Config, bundleSize uint64) error {
+	if c.ForceStartBlock == 0 {
+		return nil
+	}
+	if c.StartBlock != 0 {
+		return fmt.Errorf("StartBlock and ForceStartBlock are exclusive")
+	}
+	if c.ForceStartBlock%bundleSize != 0 {
+		return fmt.Errorf("force start block %d is not aligned on bundle size %d", c.ForceStartBlock, bundleSize)
+	}
+	return nil
+}
+
+// exclusiveStopBlock is the base of the bundle following the one containing `stopBlock`, 0 when unset
+func exclusiveStopBlock(stopBlock, bundleSize uint64) uint64 {
+	if stopBlock == 0 {
+		return 0
+	}
+	return stopBlock - stopBlock%bundleSize + bundleSize
 }
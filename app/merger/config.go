@@ -0,0 +1,456 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"time"
+
+	"github.com/sadiq1971/merger"
+	"github.com/streamingfast/bstream/hub"
+	"go.uber.org/zap"
+)
+
+type Config struct {
+	StorageOneBlockFilesPath     string
+	StorageMergedBlocksFilesPath string
+	StorageForkedBlocksFilesPath string
+
+	// StorageMergedBlocksFilesPaths lists the destinations of the merged files, replacing StorageMergedBlocksFilesPath:
+	// the first one is written by the merge, the others are replicas the merged files are mirrored to asynchronously
+	StorageMergedBlocksFilesPaths []string
+
+	// StorageMergedBlocksFilesRanges routes bundles of a given block range to a different store,
+	// each entry formatted as `<inclusive_low>:<exclusive_high>=<store_url>` (high of 0 is unbounded)
+	StorageMergedBlocksFilesRanges []string
+
+	// MergedFilesCacheSize is the number of parsed merged files kept in memory (0 uses the default)
+	MergedFilesCacheSize int
+
+	// PrefixBoundedListing lists the one-block files from the current bundle through block-number prefixes, so that a
+	// deletion backlog below it is not listed on every walk, for stores without native start offset (S3, local)
+	PrefixBoundedListing bool
+
+	// OneBlockCacheBytes keeps up to that many bytes of downloaded one-block file data in memory, so that downloading
+	// them again does not hit the one-block store (0 disables the cache)
+	OneBlockCacheBytes uint64
+
+	// OneBlockDeletionRate limits one-block files deletion to this many files per second (0 means no limit)
+	OneBlockDeletionRate float64
+
+	// OneBlockBulkDelete deletes the one-block files with bulk requests of up to 1000 files, when
+	// OneBlockDeletionRate is not set. Only S3 stores support it, other stores keep deleting them one by one.
+	// Local one-block stores always delete them in batches.
+	OneBlockBulkDelete bool
+
+	// DedupOneBlockPayloads does not download again the one-block files uploaded byte-identical by several producers
+	// when archiving them (S3 stores only, compared by ETag), and adds the sha256 of each merged block payload to the
+	// bundle stats
+	DedupOneBlockPayloads bool
+
+	// OneBlockTimestampPrecision is the timestamp precision (none, second, millisecond, nanosecond) used in the
+	// names of the one-block files renamed by the merger. Any precision is accepted when reading.
+	OneBlockTimestampPrecision string
+
+	// OneBlockLibNumEncoding is how the chain writes the lib field of the one-block filenames: absolute (default),
+	// delta (distance below the block number) or unknown-sentinel (0 means unknown)
+	OneBlockLibNumEncoding string
+
+	// ValidationPolicies sets the policy of the bundle validation checks (codec, linkage, timestamp_monotonicity, continuity),
+	// each entry formatted as `<check>=<policy>` with policy one of off, warn (log and continue) or enforce (fail the merge)
+	ValidationPolicies []string
+
+	// SuffixCanonicalization is what becomes of the producer information inside the merged blocks: keep (default), strip or
+	// normalize (replaced with CanonicalSuffix, "normalized" if empty), so that the bundles of different fleets are identical.
+	// It requires a block canonicalizer registered for the chain.
+	SuffixCanonicalization string
+	CanonicalSuffix        string
+
+	// BlockRewriter, set by the chain-specific binary, rewrites each decoded block before it is written in its bundle,
+	// e.g. to merge light bundles without traces from the same one-block files as the full ones
+	BlockRewriter merger.BlockRewriter `json:"-"`
+
+	// MergeIntentsStorePath receives an intent before each bundle is merged, so that instances failing over each other do
+	// not merge the same bundle twice (outside of the merged blocks store, best-effort: not a lock). MergeIntentsInstanceID identifies this instance
+	// (hostname by default), also in its reported identity, and intents older than MergeIntentStaleAfter (twice the write timeout by default) are stale.
+	MergeIntentsStorePath  string
+	MergeIntentsInstanceID string
+	MergeIntentStaleAfter  time.Duration
+
+	// BundleStats exports the block sizes, compression ratio and transaction count (when the chain registered a transaction
+	// counter) of each merged bundle to metrics, and to `<base block>.json` files in BundleStatsStorePath if set
+	BundleStats          bool
+	BundleStatsStorePath string
+
+	// BundleIndexStorePath, when set, receives a `<base block>.index.json` file per merged bundle listing its blocks
+	// (number, ID, parent, LIB) and the forked blocks of its range, for cursor resolution without the bundle
+	BundleIndexStorePath string
+
+	// ProtocolUpgrades (`<name>:<height>`) are the heights from which the chain encodes its blocks differently, the
+	// merged files are split at each of them. The merged files on either side are tagged in `<merged file name>.json`
+	// files in ProtocolUpgradeTagsStorePath if set.
+	ProtocolUpgrades             []string
+	ProtocolUpgradeTagsStorePath string
+
+	// ProbeStartBlock finds the last merged bundle on startup by probing the merged files at growing distances, instead
+	// of listing them all from StartBlock. The merged files must not have holes.
+	ProbeStartBlock bool
+
+	// MergedBlocksCursor writes `.merged-blocks-cursor.json` (last merged block number, ID and time) to the merged
+	// blocks store after each merged file, so that its readers find the head without listing the store
+	MergedBlocksCursor bool
+
+	// StoreRetryAttempts, StoreRetryInitialBackoff (doubling up to StoreRetryMaxBackoff) and StoreRetryJitter (0 to 1)
+	// retry the downloads, uploads, listings and deletes of the stores, each attempt bounded by the Store*Timeout
+	// (0 for the defaults). StoreCircuitBreakerThreshold consecutive failed operations suspend them, and make the
+	// merger not ready, probing the stores again every StoreCircuitBreakerCooldown. When all are zero, only the
+	// uploads are retried, 5 times.
+	StoreRetryAttempts           int
+	StoreRetryInitialBackoff     time.Duration
+	StoreRetryMaxBackoff         time.Duration
+	StoreRetryJitter             float64
+	StoreDownloadTimeout         time.Duration
+	StoreUploadTimeout           time.Duration
+	StoreListTimeout             time.Duration
+	StoreDeleteTimeout           time.Duration
+	StoreCircuitBreakerThreshold int
+	StoreCircuitBreakerCooldown  time.Duration
+
+	// VerifyAfterMerge reads each merged file back after uploading it, its one-block files are only deleted if it holds all of them
+	VerifyAfterMerge bool
+
+	GRPCListenAddr string
+
+	// AdminListenAddr is where the admin HTTP API (pause, resume, trigger, reload) is served, separately from GRPCListenAddr (disabled if empty)
+	AdminListenAddr string
+
+	// ProgressListenAddr is where the progress of the merger is served as JSON on `/progress`, read-only unlike the admin
+	// API, for the dashboards (disabled if empty). It is also served on the admin API and by the Progress gRPC service.
+	ProgressListenAddr string
+
+	PruneForkedBlocksAfter uint64
+
+	TimeBetweenPruning time.Duration
+	TimeBetweenPolling time.Duration
+	// StopBlock, when set, merges up to the bundle containing it (included) then shuts the app down with
+	// merger.ErrStopBlockReached, for bounded reprocessing jobs: the launcher should exit successfully on it
+	StopBlock uint64
+
+	// ForkDBSoftLimitBytes triggers early purging of forked blocks when the bundler's forkdb goes above it (0 disables)
+	ForkDBSoftLimitBytes uint64
+
+	// MaxForkedBlockAgeBlocks and MaxForkedBlockAge keep the blocks that are not irreversible when their bundle is closed,
+	// but may still belong to a late-arriving canonical chain, until they are that many blocks below the bundle being
+	// collected or kept that long. The blocks definitively forked below the LIB are purged right away. With both at 0,
+	// all the blocks that are not irreversible are purged when their bundle is closed.
+	MaxForkedBlockAgeBlocks uint64
+	MaxForkedBlockAge       time.Duration
+
+	// DeleteAfterMergedConfirmations keeps the one-block files of a merged bundle until that many more bundles are
+	// merged, and DeleteAfterMergedDelay for at least that long after its merge, so that a bad merge can be redone from
+	// the original files. With both at 0 (or 1 confirmation), they are pruned once the next bundle is merged.
+	DeleteAfterMergedConfirmations uint64
+	DeleteAfterMergedDelay         time.Duration
+
+	// CompletionReportPath is where the JSON report is written when StopBlock is reached (stdout if empty)
+	CompletionReportPath string
+
+	// DegradedProbeInterval is how often a read-only merged blocks store is probed while the merger is degraded (defaults to 30s)
+	DegradedProbeInterval time.Duration
+
+	// ReadinessMaxHeadDrift makes the merger not ready while the block time of the last merged block is older than
+	// that, 0 disables it. LivenessTimeout makes it not live (see IsLive) when its main loop did not start a cycle for
+	// that long, 0 disables it: it must exceed TimeBetweenPolling.
+	ReadinessMaxHeadDrift time.Duration
+	LivenessTimeout       time.Duration
+
+	// CompareLinearBundler runs the linear bundler in shadow mode and reports where its bundles diverge from the forkdb-based bundler
+	CompareLinearBundler bool
+
+	// CapturePath records every call of the merger to its stores in that file (JSON lines), to reproduce its cycles with
+	// merger.Replay. Resumable walks and the other optional store capabilities are disabled while capturing.
+	CapturePath string
+
+	// ObserverMode verifies the merger writing to the same stores instead of merging: the bundles are computed from the
+	// one-block files then compared with its merged files, waiting up to ObserverMergedWait (a minute by default) for
+	// each. Nothing is written nor deleted in the stores, the divergences are in the admin status and metrics.
+	ObserverMode       bool
+	ObserverMergedWait time.Duration
+
+	// ShadowMode validates this merger against the merged files already in the stores, e.g. before the cutover to a new
+	// version: the bundles are computed from StartBlock and the block IDs of each one are compared with its merged file,
+	// a missing merged file being a divergence. Nothing is written nor deleted, the divergences are reported like in
+	// ObserverMode.
+	ShadowMode bool
+
+	// IrreversibleConfirmations is how many blocks the LIB must be above a bundle boundary before that bundle is merged (must be lower than the bundle size)
+	IrreversibleConfirmations uint64
+
+	// BundleCompletion decides when a bundle is complete: "lib" (default, the LIB is IrreversibleConfirmations above the
+	// boundary), "highest-linkable" (the blocks linking to the last irreversible one are above the boundary) or
+	// "timestamp" (also after BundleCompletionTimeout without a new irreversible block), for chains skipping block numbers
+	BundleCompletion        string
+	BundleCompletionTimeout time.Duration
+
+	// MergeBatchBundles holds complete bundles until that many are ready, or the oldest one waited MergeBatchMaxWait, then
+	// merges them together, for stores favoring fewer, larger upload sessions (0 or 1 merges each bundle right away)
+	MergeBatchBundles int
+	MergeBatchMaxWait time.Duration
+
+	// MergePipelineDepth lets that many closed bundles wait for their merge while the next ones are collected and
+	// downloaded, overlapping downloads and uploads when catching up (0 or 1 waits for each merge before the next bundle)
+	MergePipelineDepth int
+
+	// CatchUpMergeParallelism merges that many bundles at the same time once CatchUpMinBacklogBundles closed bundles
+	// wait for their merge, when far behind (0 or 1 merges one bundle at a time). It enlarges MergePipelineDepth if needed.
+	CatchUpMergeParallelism  int
+	CatchUpMinBacklogBundles int
+
+	// StateFilePath is where all-time cumulative counters are persisted across restarts (disabled if empty)
+	StateFilePath string
+
+	// ShutdownGracePeriod is how long the bundle being merged gets to complete on shutdown before it is cancelled (0 uses the default)
+	ShutdownGracePeriod time.Duration
+
+	// ValidateOnly makes Run perform the preflight checks (see App.ValidateOnly), print their results and exit without merging
+	ValidateOnly bool
+
+	// NormalizeOneBlockFiles renames non-conforming one-block files (bad padding, other timestamp precision, missing suffix)
+	// to their canonical name before merging. With NormalizeOneBlockFilesDryRun, the report is printed and the merger exits without renaming.
+	NormalizeOneBlockFiles       bool
+	NormalizeOneBlockFilesDryRun bool
+	// NormalizeOneBlockFilesSuffix is given to the one-block files without suffix (defaults to "normalized")
+	NormalizeOneBlockFilesSuffix string
+
+	// BlockTimeMaxGap and BlockTimeBurstInterval enable the analysis of block times at merge time, logging
+	// blocks produced more than BlockTimeMaxGap or less than BlockTimeBurstInterval after their parent (0 disables a check)
+	BlockTimeMaxGap        time.Duration
+	BlockTimeBurstInterval time.Duration
+
+	// PrefetchConcurrency and PrefetchByteBudget control the download of one-block files before merging a bundle (0 uses the defaults)
+	PrefetchConcurrency int
+	PrefetchByteBudget  uint64
+	// BundleReadAhead is how many one-block files left out of the prefetch are downloaded ahead while writing a merged file (0 uses the default)
+	BundleReadAhead int
+	// MaxConcurrentDownloads caps the downloads from the stores running at once, shared by the prefetch, the read-ahead,
+	// the verification, the archiving and the replication (0 means no limit)
+	MaxConcurrentDownloads int
+	// MaxBundleMemoryBytes streams the one-block files into the merged file with at most this many bytes of blocks
+	// downloaded ahead, instead of holding whole bundles in memory (0 disables streaming)
+	MaxBundleMemoryBytes uint64
+
+	// PressureProbeURL (under pressure unless it answers 2xx) or PressureFilePath (under pressure while it exists) signal
+	// that the co-located serving stack is saturated: merges are deferred, for at most PressureMaxDefer (0 means no limit)
+	PressureProbeURL string
+	PressureFilePath string
+	PressureMaxDefer time.Duration
+
+	// StartBlock forces the block where merging starts (defaults to the first streamable block). If it is not aligned
+	// on the bundle size, AllowStartBlockRealignment must be set to confirm that one shorter alignment bundle is produced.
+	StartBlock                 uint64
+	AllowStartBlockRealignment bool
+
+	// ForceStartBlock, a bundle boundary, pins the merger there to merge again a corrupted tail of merged bundles: the
+	// merged files from there are overwritten instead of skipped. Remove it once the tail is repaired. SkipBootstrap
+	// starts the forkdb without the last block of the previous merged bundle, from the one-block file on the boundary.
+	ForceStartBlock uint64
+	SkipBootstrap   bool
+
+	// LeftoverBootstrap replays the one-block files left since the last merged bundle when the bundler starts from
+	// the merged files, so that the forked blocks not moved yet when the merger stopped are moved with the next bundle
+	LeftoverBootstrap bool
+
+	// MergedCompressionLevel is the zstd level (1 to 22) used to compress merged files, 0 leaves compression to the store at its default level
+	MergedCompressionLevel int
+
+	// MergedBundleFormat is how merged files are laid out: "dbin" (the default, read by every consumer) or
+	// "block-range", a framed protobuf file ending with an index of its blocks for random access (requires an
+	// uncompressed merged store and readers supporting the format)
+	MergedBundleFormat string
+
+	// ScopeStores guarantees that walks, deletions and writes never touch objects outside of each store's own folder,
+	// making it safe to share a bucket with reader or relayer outputs. Implied by the scope prefixes below.
+	ScopeStores bool
+	// OneBlocksStoreScopePrefix and MergedBlocksStoreScopePrefix root the one-block and merged blocks stores (including range stores) at a sub-folder of their URL
+	OneBlocksStoreScopePrefix    string
+	MergedBlocksStoreScopePrefix string
+
+	// ExpectedBlockInterval enables chain halt detection: once the head did not move for ChainHaltMissedBlocks intervals,
+	// the merger goes idle if at least ChainHaltQuorum readers agree on that head, otherwise readers are reported as stalled
+	ExpectedBlockInterval time.Duration
+	ChainHaltMissedBlocks uint64
+	ChainHaltQuorum       int
+
+	// MaxBundleOpenDuration flushes the partial current bundle once it has been open that long, then again as long as
+	// it stays open and gets new blocks, for low-traffic chains. The partial merged file is replaced once complete.
+	MaxBundleOpenDuration time.Duration
+
+	// HoleGracePeriod enables hole detection: a block missing from the chain for that long is reported in the logs,
+	// `merger_block_hole` and the admin status. SkipHoles then links the blocks above the hole to the last block
+	// below it, for the chains that legitimately miss blocks.
+	HoleGracePeriod time.Duration
+	SkipHoles       bool
+
+	// EstimateCleanup prints how many one-block files are already covered by merged bundles (and would be deleted) then exits, nothing is deleted
+	EstimateCleanup bool
+
+	// BelowLowestBlockPolicy handles the one-block files below the first streamable block: "ignore" (only report them),
+	// "delete" or "archive" (to StorageArchiveFilesPath). Empty leaves them alone without reporting.
+	BelowLowestBlockPolicy  string
+	StorageArchiveFilesPath string
+
+	// ExistingBundlePolicy is what happens when the merged file of a bundle exists before it is written, e.g. by
+	// another merger pointed at the same stores: "overwrite" (the default), "skip", "fail" or "compare" (skip when it
+	// holds the same blocks, fail otherwise)
+	ExistingBundlePolicy string
+
+	// OneBlockChecksumsStorePath, when set, holds the `<one-block filename>.sha256` checksums written by the producers:
+	// each downloaded one-block file is verified against its checksum, if any. OneBlockChecksumPolicy is what happens on a
+	// mismatch: "skip" the corrupted copy for another one (the default), "fail" the merge, or only "warn"
+	OneBlockChecksumsStorePath string
+	OneBlockChecksumPolicy     string
+
+	// DuplicatePolicy picks the copy merged when several producers wrote one-block files for the same block: "first_seen"
+	// (the default), "newest" (newest timestamp in the filename) or "suffixes:<suffix>,<suffix>..." (by producer preference)
+	DuplicatePolicy string
+
+	// GRPCAuthToken, when set, is required as `authorization: bearer <token>` on every gRPC call but the health checks
+	GRPCAuthToken string
+	// GRPCTLSCertFile and GRPCTLSKeyFile serve the gRPC API over TLS, GRPCTLSClientCAFile additionally requires client certificates signed by its CAs
+	GRPCTLSCertFile     string
+	GRPCTLSKeyFile      string
+	GRPCTLSClientCAFile string
+	// GRPCRateLimit rejects gRPC calls above this many per second (with bursts of GRPCRateLimitBurst), 0 disables the limit
+	GRPCRateLimit      float64
+	GRPCRateLimitBurst int
+	GRPCRequestLogging bool
+
+	// ForkDBDiffsHistory logs the forkdb changes after each cycle and keeps that many of them on the admin API (`/forkdb-diffs`), 0 disables it
+	ForkDBDiffsHistory int
+
+	// BoundaryWait is how long to wait before walking again when the bundle only waits for its boundary to become irreversible (instead of TimeBetweenPolling), 0 disables it
+	BoundaryWait time.Duration
+
+	// AdaptivePollingMaxInterval makes TimeBetweenPolling adaptive: AdaptivePollingMinInterval after a walk that found new
+	// files while the head drift is at most AdaptivePollingMaxDrift (0 ignores the drift), doubling up to the max
+	// interval after each walk that found nothing. 0 keeps the fixed TimeBetweenPolling.
+	AdaptivePollingMinInterval time.Duration
+	AdaptivePollingMaxInterval time.Duration
+	AdaptivePollingMaxDrift    time.Duration
+
+	// DiagnosticsStorePath receives a `.tar.gz` with the bundler snapshot, the last cycles and this config (secrets redacted)
+	// every DiagnosticsInterval and when the merger stops on an error, empty disables it
+	DiagnosticsStorePath string
+	DiagnosticsInterval  time.Duration
+
+	// MergedWatermarkStorePath receives `merged-watermark.json`, the block below which everything is merged, for the
+	// readers to prune their local copies. It must not be the one-block files nor the merged blocks store, empty disables it.
+	MergedWatermarkStorePath string
+
+	// PoisonRangesStorePath records the bundles failing with data errors, a bundle that failed PoisonRangeMaxAttempts
+	// times (3 by default) across restarts is skipped until retried with `/retry-poison-range` on the admin server. It
+	// must not be the one-block files nor the merged blocks store, empty disables it: the merger then fails on the bundle forever.
+	PoisonRangesStorePath  string
+	PoisonRangeMaxAttempts int
+
+	// DownloadDeadLetterMaxAttempts gives up on a one-block file whose download failed that many times, merging its
+	// bundle without it when its blocks still link together (0 retries it forever). The given up files are listed as
+	// `dead_downloads` by the admin server and, when DownloadDeadLetterStorePath is set, copied there, e.g. to a
+	// `dead-letter/` prefix next to (not below) the one-block files.
+	DownloadDeadLetterMaxAttempts int
+	DownloadDeadLetterStorePath   string
+
+	// WalkBudget stops walking the one-block files after that long in a cycle, checking the merged files before resuming the walk, 0 disables it
+	WalkBudget time.Duration
+
+	// ForkableHub, when running in the same binary (firehose single-binary deployments), hands its blocks to the merger
+	// so that they are not downloaded again when merging. To bootstrap the hub from the merger, build it with
+	// merger.Merger.HubOneBlocksSourceFactory() instead.
+	ForkableHub *hub.ForkableHub `json:"-"`
+
+	// MaxDeletedFilesPerCycle holds any cycle deleting more one-block files than that until the operator confirms it,
+	// through the admin API or by writing the token of the deletion to `merger-confirm-deletion` in
+	// DeletionConfirmationStorePath (optional), 0 disables it
+	MaxDeletedFilesPerCycle       int
+	DeletionConfirmationStorePath string
+
+	// BootstrapBundles fetches that many merged bundles, BootstrapConcurrency at once, when the merger starts, 0 disables it
+	BootstrapBundles     int
+	BootstrapConcurrency int
+
+	// MergedFileNamesCompatibility recognizes merged files named with older naming schemes (other padding, extensions)
+	// when looking for the next bundle to merge, while migrating an old archive
+	MergedFileNamesCompatibility bool
+
+	// OneBlockNotificationsSQSQueueURL (S3 event notifications, with OneBlockNotificationsSQSRegion) or
+	// OneBlockNotificationsPubSubSubscription (GCS notifications, `projects/<project>/subscriptions/<subscription>`)
+	// push the new one-block files to the merger, the one-block store then only being walked every
+	// OneBlockReconciliationInterval. Without them, a local one-block store (`file://` URL or plain path) is watched with
+	// inotify on linux.
+	OneBlockNotificationsSQSQueueURL        string
+	OneBlockNotificationsSQSRegion          string
+	OneBlockNotificationsPubSubSubscription string
+	OneBlockReconciliationInterval          time.Duration
+
+	// ArrivalDropRatio and ArrivalFloodRatio enable the detection of one-block files arriving much slower (readers outage)
+	// or much faster (readers replaying) than usual, ArrivalFloodMaxFilesPerCycle caps the new files handled per cycle during a flood
+	ArrivalDropRatio             float64
+	ArrivalFloodRatio            float64
+	ArrivalFloodMaxFilesPerCycle int
+
+	// BackfillRange (`<start_block>:<stop_block>`) merges the missing bundles of that historical range with
+	// BackfillConcurrency bundlers in parallel, then exits, instead of following the chain
+	BackfillRange       string
+	BackfillConcurrency int
+
+	// ExpectedMergedRanges (`<start_block>:<stop_block>`) are the block ranges that must be covered by merged files,
+	// checked every CoverageCheckInterval (hourly by default) to catch their accidental deletion
+	ExpectedMergedRanges  []string
+	CoverageCheckInterval time.Duration
+
+	// MergedBlocksRetention and MergedBlocksRetentionBlocks delete the merged bundles older than that age, or further
+	// than that many blocks behind the head, from the merged blocks store (0 disables either limit). Set StateFilePath
+	// so that a restarted merger starts above the pruned bundles.
+	MergedBlocksRetention       time.Duration
+	MergedBlocksRetentionBlocks uint64
+
+	// LogFields (`<key>=<value>`, e.g. `chain=eth`) are added to every log of the merger, and LogSampling
+	// (`<walk|merge>=<first>/<thereafter>`) samples the logs of a component per second
+	LogFields   []string
+	LogSampling []string
+
+	// TracingExporter exports the spans of the merge cycle (walks, listings, downloads, merges, deletions): empty or
+	// `none` disables tracing, `log` logs each span with its duration
+	TracingExporter string
+}
+
+// logOptions parses LogFields and LogSampling
+func (c *Config) logOptions() (fields []zap.Field, samplings map[merger.LogComponent]merger.LogSampling, err error) {
+	for _, spec := range c.LogFields {
+		field, err := merger.ParseLogField(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		fields = append(fields, field)
+	}
+	samplings = make(map[merger.LogComponent]merger.LogSampling)
+	for _, spec := range c.LogSampling {
+		component, sampling, err := merger.ParseLogSampling(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		samplings[component] = sampling
+	}
+	return fields, samplings, nil
+}
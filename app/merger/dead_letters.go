@@ -0,0 +1,41 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+
+	"github.com/sadiq1971/merger"
+	"github.com/streamingfast/dstore"
+)
+
+// downloadDeadLettersOption gives up on the one-block files failing to download DownloadDeadLetterMaxAttempts times,
+// copying them to DownloadDeadLetterStorePath if set, nil when it is 0
+func (a *App) downloadDeadLettersOption() (merger.DStoreIOOption, error) {
+	if a.config.DownloadDeadLetterMaxAttempts == 0 {
+		return nil, nil
+	}
+	var deadLetterStore dstore.Store
+	if a.config.DownloadDeadLetterStorePath != "" {
+		var err error
+		if deadLetterStore, err = dstore.NewDBinStore(a.config.DownloadDeadLetterStorePath); err != nil {
+			return nil, fmt.Errorf("failed to init download dead-letter store: %w", err)
+		}
+		if deadLetterStore, err = a.scopeStore(deadLetterStore, ""); err != nil {
+			return nil, fmt.Errorf("failed to scope download dead-letter store: %w", err)
+		}
+	}
+	return merger.WithDownloadDeadLetters(a.config.DownloadDeadLetterMaxAttempts, deadLetterStore), nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+
+	"github.com/sadiq1971/merger"
+	"go.uber.org/zap"
+	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthOptions set when the merger is degraded, not ready or not live
+func (a *App) healthOptions() (out []merger.Option) {
+	if a.config.DegradedProbeInterval != 0 {
+		out = append(out, merger.WithDegradedProbeInterval(a.config.DegradedProbeInterval))
+	}
+	if a.config.ReadinessMaxHeadDrift != 0 {
+		out = append(out, merger.WithReadinessMaxDrift(a.config.ReadinessMaxHeadDrift))
+	}
+	if a.config.LivenessTimeout != 0 {
+		out = append(out, merger.WithLivenessTimeout(a.config.LivenessTimeout))
+	}
+	return out
+}
+
+func (a *App) IsReady() bool {
+	var resp *pbhealth.HealthCheckResponse
+	var err error
+	switch {
+	case a.localHealth != nil:
+		resp, err = a.localHealth.Check(context.Background(), &pbhealth.HealthCheckRequest{})
+	case a.readinessProbe != nil:
+		resp, err = a.readinessProbe.Check(context.Background(), &pbhealth.HealthCheckRequest{})
+	default:
+		return false
+	}
+	if err != nil {
+		zlog.Info("merger readiness probe error", zap.Error(err))
+		return false
+	}
+
+	if resp.Status == pbhealth.HealthCheckResponse_SERVING {
+		return true
+	}
+
+	return false
+}
+
+// IsLive returns false once the main loop of the merger stopped making progress (see LivenessTimeout), for the
+// orchestration to restart it
+func (a *App) IsLive() bool {
+	if a.merger == nil {
+		return true // not initiated yet, readiness covers it
+	}
+	return a.merger.IsLive()
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sadiq1971/merger"
+)
+
+// mergeIntentsOption records an intent in MergeIntentsStorePath before each merge (best-effort, not a lock), nil when
+// it is not set
+func (a *App) mergeIntentsOption() (merger.DStoreIOOption, error) {
+	if a.config.MergeIntentsStorePath == "" {
+		return nil, nil
+	}
+	intentsStore, err := a.newSimpleStore("merge intents", a.config.MergeIntentsStorePath)
+	if err != nil {
+		return nil, err
+	}
+	instanceID := a.config.MergeIntentsInstanceID
+	if instanceID == "" {
+		if instanceID, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("cannot get hostname for merge intents, set MergeIntentsInstanceID: %w", err)
+		}
+	}
+	staleAfter := a.config.MergeIntentStaleAfter
+	if staleAfter == 0 {
+		staleAfter = 2 * merger.WriteObjectTimeout
+	}
+	return merger.WithMergeIntents(intentsStore, instanceID, staleAfter), nil
+}
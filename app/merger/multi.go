@@ -0,0 +1,107 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/sadiq1971/merger"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type MultiConfig struct {
+	// GRPCListenAddr serves the health of the chains, see merger.MultiMerger.Check. The GRPCListenAddr of the chains
+	// is ignored.
+	GRPCListenAddr string
+
+	// DeletionThreads are the deletion workers shared by the chains, DefaultFilesDeleteThreads if 0
+	DeletionThreads int
+
+	// Chains are the configurations of the mergers, by chain name, each with its own source and destination stores.
+	// Backfill, validation-only and normalization dry-run configurations are not supported.
+	Chains map[string]*Config
+}
+
+// MultiApp runs the mergers of several chains in one process, see merger.MultiMerger
+type MultiApp struct {
+	*shutter.Shutter
+	config *MultiConfig
+	multi  *merger.MultiMerger // nil until the mergers are initiated
+}
+
+func NewMulti(config *MultiConfig) *MultiApp {
+	return &MultiApp{
+		Shutter: shutter.New(),
+		config:  config,
+	}
+}
+
+func (a *MultiApp) Run() error {
+	if len(a.config.Chains) == 0 {
+		return fmt.Errorf("no chain configured")
+	}
+	var names []string
+	for name := range a.config.Chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	zlog.Info("running multi-chain merger", zap.Strings("chains", names))
+
+	pool := merger.NewDeleterPool(a.config.DeletionThreads)
+	mergers := make(map[string]*merger.Merger)
+	for _, name := range names {
+		chain := &App{Shutter: shutter.New(), config: a.config.Chains[name], chain: name, deleterPool: pool}
+		if err := chain.Run(); err != nil {
+			return fmt.Errorf("chain %q: %w", name, err)
+		}
+		if chain.merger == nil {
+			return fmt.Errorf("chain %q: only merging is supported in a multi-chain merger", name)
+		}
+		mergers[name] = chain.merger
+	}
+
+	a.multi = merger.NewMultiMerger(zlog, a.config.GRPCListenAddr, mergers)
+	a.OnTerminating(a.multi.Shutdown)
+	a.multi.OnTerminated(a.Shutdown)
+	go a.multi.Run()
+
+	zlog.Info("multi-chain merger running")
+	return nil
+}
+
+// IsReady returns true once every chain is ready, see merger.MultiMerger.Check
+func (a *MultiApp) IsReady() bool {
+	if a.multi == nil {
+		return false
+	}
+	resp, err := a.multi.Check(context.Background(), &pbhealth.HealthCheckRequest{})
+	if err != nil {
+		zlog.Info("merger readiness probe error", zap.Error(err))
+		return false
+	}
+	return resp.Status == pbhealth.HealthCheckResponse_SERVING
+}
+
+// IsLive returns false once the main loop of any chain stopped making progress
+func (a *MultiApp) IsLive() bool {
+	if a.multi == nil {
+		return true
+	}
+	return a.multi.IsLive()
+}
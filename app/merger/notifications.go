@@ -0,0 +1,56 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sadiq1971/merger"
+	"github.com/sadiq1971/merger/notifier"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// oneBlockNotifierOption pushes the new one-block files of `oneBlockStore` to the merger, from the configured queue or
+// subscription, or from inotify on a local store. It is nil when they can only be discovered by walks.
+func (a *App) oneBlockNotifierOption(oneBlockStore dstore.Store) (merger.Option, error) {
+	notifierKeys := notifier.Keys{Prefix: strings.Trim(oneBlockStore.BaseURL().Path, "/"), Extension: "dbin.zst"}
+	switch {
+	case a.config.OneBlockNotificationsSQSQueueURL != "":
+		sqsNotifier, err := notifier.NewSQS(zlog, a.config.OneBlockNotificationsSQSQueueURL, a.config.OneBlockNotificationsSQSRegion, notifierKeys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init sqs notifier: %w", err)
+		}
+		return merger.WithOneBlockNotifier(sqsNotifier, a.config.OneBlockReconciliationInterval), nil
+	case a.config.OneBlockNotificationsPubSubSubscription != "":
+		pubSubNotifier, err := notifier.NewPubSub(context.Background(), zlog, a.config.OneBlockNotificationsPubSubSubscription, notifierKeys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init pubsub notifier: %w", err)
+		}
+		return merger.WithOneBlockNotifier(pubSubNotifier, a.config.OneBlockReconciliationInterval), nil
+	}
+	fsStore, ok := merger.AsFSStore(oneBlockStore)
+	if !ok {
+		return nil, nil
+	}
+	inotifyNotifier, err := notifier.NewInotify(zlog, fsStore.Dir(), notifierKeys)
+	if err != nil {
+		zlog.Info("cannot watch the one-block files directory, discovering them by walks only", zap.Error(err))
+		return nil, nil
+	}
+	return merger.WithOneBlockNotifier(inotifyNotifier, a.config.OneBlockReconciliationInterval), nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"github.com/sadiq1971/merger"
+)
+
+// pipelineIOOptions bound the downloads of the one-block files of the bundles being merged
+func (a *App) pipelineIOOptions() (out []merger.DStoreIOOption) {
+	if a.config.PrefetchConcurrency != 0 || a.config.PrefetchByteBudget != 0 {
+		concurrency, byteBudget := a.config.PrefetchConcurrency, a.config.PrefetchByteBudget
+		if concurrency == 0 {
+			concurrency = merger.ParallelOneBlockDownload
+		}
+		if byteBudget == 0 {
+			byteBudget = merger.DefaultPrefetchByteBudget
+		}
+		out = append(out, merger.WithPrefetch(concurrency, byteBudget))
+	}
+	if a.config.BundleReadAhead != 0 {
+		out = append(out, merger.WithBundleReadAhead(a.config.BundleReadAhead))
+	}
+	if a.config.MaxConcurrentDownloads != 0 {
+		out = append(out, merger.WithMaxConcurrentDownloads(a.config.MaxConcurrentDownloads))
+	}
+	if a.config.MaxBundleMemoryBytes != 0 {
+		out = append(out, merger.WithMaxBundleMemory(a.config.MaxBundleMemoryBytes))
+	}
+	return out
+}
+
+// pipelineOptions batch the merges of the closed bundles, or overlap them with the collection of the next ones
+func (a *App) pipelineOptions() (out []merger.Option) {
+	if a.config.MergeBatchBundles > 1 {
+		out = append(out, merger.WithMergeBatching(a.config.MergeBatchBundles, a.config.MergeBatchMaxWait))
+	}
+	if a.config.MergePipelineDepth > 1 {
+		out = append(out, merger.WithMergePipelineDepth(a.config.MergePipelineDepth))
+	}
+	if a.config.CatchUpMergeParallelism > 1 {
+		out = append(out, merger.WithCatchUpMerges(a.config.CatchUpMergeParallelism, a.config.CatchUpMinBacklogBundles))
+	}
+	return out
+}
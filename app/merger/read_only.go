@@ -0,0 +1,96 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sadiq1971/merger"
+)
+
+// readOnlyOptions compare the bundles with the merged files of `io` instead of writing them, in ObserverMode or
+// ShadowMode
+func (a *App) readOnlyOptions(io merger.IOInterface) (out []merger.Option) {
+	if a.config.ObserverMode {
+		mergedWait := a.config.ObserverMergedWait
+		if mergedWait == 0 {
+			mergedWait = time.Minute
+		}
+		out = append(out, merger.WithObserverMode(io.(merger.MergedFilesReader), mergedWait))
+	}
+	if a.config.ShadowMode {
+		out = append(out, merger.WithShadowMode(io.(merger.MergedFilesReader)))
+	}
+	return out
+}
+
+// readOnly tells if the merger only reads the stores, in ObserverMode or ShadowMode
+func (c *Config) readOnly() bool {
+	return c.ObserverMode || c.ShadowMode
+}
+
+// validateObserverMode rejects the options writing to the stores, an observer (or a shadow merger) may not have write
+// permissions
+func (c *Config) validateObserverMode() error {
+	if c.ObserverMode && c.ShadowMode {
+		return fmt.Errorf("observer and shadow modes are exclusive")
+	}
+	var writers []string
+	if c.MergeIntentsStorePath != "" {
+		writers = append(writers, "MergeIntentsStorePath")
+	}
+	if c.BundleStatsStorePath != "" {
+		writers = append(writers, "BundleStatsStorePath")
+	}
+	if c.BundleIndexStorePath != "" {
+		writers = append(writers, "BundleIndexStorePath")
+	}
+	if c.MaxBundleOpenDuration != 0 {
+		writers = append(writers, "MaxBundleOpenDuration")
+	}
+	if c.ProtocolUpgradeTagsStorePath != "" {
+		writers = append(writers, "ProtocolUpgradeTagsStorePath")
+	}
+	if c.MergedBlocksCursor {
+		writers = append(writers, "MergedBlocksCursor")
+	}
+	if c.MergedWatermarkStorePath != "" {
+		writers = append(writers, "MergedWatermarkStorePath")
+	}
+	if c.PoisonRangesStorePath != "" {
+		writers = append(writers, "PoisonRangesStorePath")
+	}
+	if c.DownloadDeadLetterStorePath != "" {
+		writers = append(writers, "DownloadDeadLetterStorePath")
+	}
+	if len(c.StorageMergedBlocksFilesPaths) > 1 {
+		writers = append(writers, "StorageMergedBlocksFilesPaths")
+	}
+	if c.MergedBlocksRetention != 0 || c.MergedBlocksRetentionBlocks != 0 {
+		writers = append(writers, "MergedBlocksRetention")
+	}
+	if c.BackfillRange != "" {
+		writers = append(writers, "BackfillRange")
+	}
+	if c.NormalizeOneBlockFiles {
+		writers = append(writers, "NormalizeOneBlockFiles")
+	}
+	if len(writers) != 0 {
+		return fmt.Errorf("a read-only merger cannot write to the stores, unset %s", strings.Join(writers, ", "))
+	}
+	return nil
+}
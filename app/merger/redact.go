@@ -0,0 +1,86 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+const redactedValue = "REDACTED"
+
+// redacted returns a copy of the config that is safe to write outside of the pod: the gRPC token is blanked
+// and store URLs lose their credentials and query parameters (which may hold keys)
+func (c *Config) redacted() *Config {
+	out := *c
+	if out.GRPCAuthToken != "" {
+		out.GRPCAuthToken = redactedValue
+	}
+	out.StorageOneBlockFilesPath = redactURL(out.StorageOneBlockFilesPath)
+	out.StorageMergedBlocksFilesPath = redactURL(out.StorageMergedBlocksFilesPath)
+	out.StorageForkedBlocksFilesPath = redactURL(out.StorageForkedBlocksFilesPath)
+	out.StorageArchiveFilesPath = redactURL(out.StorageArchiveFilesPath)
+	out.DiagnosticsStorePath = redactURL(out.DiagnosticsStorePath)
+	out.DeletionConfirmationStorePath = redactURL(out.DeletionConfirmationStorePath)
+	out.BundleStatsStorePath = redactURL(out.BundleStatsStorePath)
+	out.BundleIndexStorePath = redactURL(out.BundleIndexStorePath)
+	out.OneBlockChecksumsStorePath = redactURL(out.OneBlockChecksumsStorePath)
+	out.ProtocolUpgradeTagsStorePath = redactURL(out.ProtocolUpgradeTagsStorePath)
+	out.MergedWatermarkStorePath = redactURL(out.MergedWatermarkStorePath)
+	out.PoisonRangesStorePath = redactURL(out.PoisonRangesStorePath)
+	out.DownloadDeadLetterStorePath = redactURL(out.DownloadDeadLetterStorePath)
+	out.MergeIntentsStorePath = redactURL(out.MergeIntentsStorePath)
+	out.PressureProbeURL = redactURL(out.PressureProbeURL)
+	out.StorageMergedBlocksFilesPaths = nil
+	for _, path := range c.StorageMergedBlocksFilesPaths {
+		out.StorageMergedBlocksFilesPaths = append(out.StorageMergedBlocksFilesPaths, redactURL(path))
+	}
+	out.StorageMergedBlocksFilesRanges = nil
+	for _, rng := range c.StorageMergedBlocksFilesRanges {
+		if idx := strings.Index(rng, "="); idx != -1 {
+			rng = rng[:idx+1] + redactURL(rng[idx+1:])
+		}
+		out.StorageMergedBlocksFilesRanges = append(out.StorageMergedBlocksFilesRanges, rng)
+	}
+	return &out
+}
+
+// hash identifies the configuration in the identity of the instance, it is computed on the redacted config so that
+// rotating credentials does not change it
+func (c *Config) hash() string {
+	data, err := json.Marshal(c.redacted())
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+func redactURL(in string) string {
+	u, err := url.Parse(in)
+	if err != nil {
+		return redactedValue
+	}
+	if u.User != nil {
+		u.User = url.User(redactedValue)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redactedValue
+	}
+	return u.String()
+}
@@ -0,0 +1,58 @@
+package merger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigRedacted(t *testing.T) {
+	config := &Config{
+		StorageOneBlockFilesPath:       "s3://user:secret@bucket/one-blocks?region=us-east-1&secret_key=abc",
+		StorageMergedBlocksFilesPath:   "gs://bucket/merged",
+		StorageMergedBlocksFilesRanges: []string{"0:1000=s3://bucket/archive?secret_key=abc"},
+		StorageMergedBlocksFilesPaths:  []string{"gs://bucket/merged", "s3://bucket/dr?secret_key=abc"},
+		MergeIntentsStorePath:          "s3://bucket/intents?secret_key=abc",
+		PressureProbeURL:               "https://probe.internal/pressure?token=abc",
+		GRPCAuthToken:                  "token",
+	}
+	redacted := config.redacted()
+
+	assert.Equal(t, "s3://REDACTED@bucket/one-blocks?REDACTED", redacted.StorageOneBlockFilesPath)
+	assert.Equal(t, "gs://bucket/merged", redacted.StorageMergedBlocksFilesPath)
+	assert.Equal(t, []string{"0:1000=s3://bucket/archive?REDACTED"}, redacted.StorageMergedBlocksFilesRanges)
+	assert.Equal(t, []string{"gs://bucket/merged", "s3://bucket/dr?REDACTED"}, redacted.StorageMergedBlocksFilesPaths)
+	assert.Equal(t, "s3://bucket/intents?REDACTED", redacted.MergeIntentsStorePath)
+	assert.Equal(t, "https://probe.internal/pressure?REDACTED", redacted.PressureProbeURL)
+	assert.Equal(t, "REDACTED", redacted.GRPCAuthToken)
+	assert.Equal(t, "token", config.GRPCAuthToken, "original config is untouched")
+}
+
+func TestConfigMergedBlocksDestinations(t *testing.T) {
+	primary, replicas, err := (&Config{StorageMergedBlocksFilesPath: "gs://bucket/merged"}).mergedBlocksDestinations()
+	assert.NoError(t, err)
+	assert.Equal(t, "gs://bucket/merged", primary)
+	assert.Empty(t, replicas)
+
+	primary, replicas, err = (&Config{StorageMergedBlocksFilesPaths: []string{"gs://bucket/merged", "s3://bucket/dr"}}).mergedBlocksDestinations()
+	assert.NoError(t, err)
+	assert.Equal(t, "gs://bucket/merged", primary)
+	assert.Equal(t, []string{"s3://bucket/dr"}, replicas)
+
+	_, _, err = (&Config{StorageMergedBlocksFilesPath: "gs://bucket/other", StorageMergedBlocksFilesPaths: []string{"gs://bucket/merged"}}).mergedBlocksDestinations()
+	assert.Error(t, err)
+}
+
+func TestConfigHash(t *testing.T) {
+	config := &Config{StorageMergedBlocksFilesPath: "gs://bucket/merged", GRPCAuthToken: "token"}
+	hash := config.hash()
+	assert.Len(t, hash, 12)
+
+	rotated := *config
+	rotated.GRPCAuthToken = "other-token"
+	assert.Equal(t, hash, rotated.hash(), "credentials do not change the hash")
+
+	changed := *config
+	changed.StorageMergedBlocksFilesPath = "gs://bucket/other"
+	assert.NotEqual(t, hash, changed.hash())
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sadiq1971/merger"
+	"github.com/streamingfast/dstore"
+)
+
+// newMergedBlocksStore leaves compression to the merger when a compression level is set, merged files are still `.dbin.zst`
+func (a *App) newMergedBlocksStore(url string) (store dstore.Store, err error) {
+	if a.config.MergedCompressionLevel != 0 {
+		store, err = dstore.NewStore(url, "dbin.zst", "", false)
+	} else {
+		store, err = dstore.NewDBinStore(url)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a.scopeStore(store, a.config.MergedBlocksStoreScopePrefix)
+}
+
+// scopeStore wraps the store in a merger.NewScopedStore when scoping is enabled
+func (a *App) scopeStore(store dstore.Store, prefix string) (dstore.Store, error) {
+	if !a.config.ScopeStores && a.config.OneBlocksStoreScopePrefix == "" && a.config.MergedBlocksStoreScopePrefix == "" {
+		return store, nil
+	}
+	return merger.NewScopedStore(store, prefix)
+}
+
+// newSimpleStore opens the `name` store (e.g. "bundle index") at `path`, scoped like the other stores
+func (a *App) newSimpleStore(name, path string) (dstore.Store, error) {
+	store, err := dstore.NewSimpleStore(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init %s store: %w", name, err)
+	}
+	store, err = a.scopeStore(store, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to scope %s store: %w", name, err)
+	}
+	return store, nil
+}
+
+// mergedBlocksDestinations returns the merged blocks store written by the merge, and its replicas
+func (c *Config) mergedBlocksDestinations() (primary string, replicas []string, err error) {
+	if len(c.StorageMergedBlocksFilesPaths) == 0 {
+		return c.StorageMergedBlocksFilesPath, nil, nil
+	}
+	primary = c.StorageMergedBlocksFilesPaths[0]
+	if c.StorageMergedBlocksFilesPath != "" && c.StorageMergedBlocksFilesPath != primary {
+		return "", nil, fmt.Errorf("merged blocks store %q is not the first of the merged blocks stores %q", c.StorageMergedBlocksFilesPath, c.StorageMergedBlocksFilesPaths)
+	}
+	return primary, c.StorageMergedBlocksFilesPaths[1:], nil
+}
+
+// storeRetryPolicy returns nil when no retry setting is set, the merger then keeps its fixed upload retries
+func (c *Config) storeRetryPolicy() *merger.RetryPolicy {
+	policy := merger.RetryPolicy{
+		MaxAttempts:             c.StoreRetryAttempts,
+		InitialBackoff:          c.StoreRetryInitialBackoff,
+		MaxBackoff:              c.StoreRetryMaxBackoff,
+		Jitter:                  c.StoreRetryJitter,
+		DownloadTimeout:         c.StoreDownloadTimeout,
+		UploadTimeout:           c.StoreUploadTimeout,
+		ListTimeout:             c.StoreListTimeout,
+		DeleteTimeout:           c.StoreDeleteTimeout,
+		CircuitBreakerThreshold: c.StoreCircuitBreakerThreshold,
+		CircuitBreakerCooldown:  c.StoreCircuitBreakerCooldown,
+	}
+	if policy == (merger.RetryPolicy{}) {
+		return nil
+	}
+	return &policy
+}
+
+func (c *Config) validateStoreRetryPolicy() error {
+	policy := c.storeRetryPolicy()
+	if policy == nil {
+		return nil
+	}
+	if policy.MaxAttempts < 0 || policy.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("store retry attempts and circuit breaker threshold cannot be negative")
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return fmt.Errorf("store retry jitter %v is not between 0 and 1", policy.Jitter)
+	}
+	for _, d := range []time.Duration{policy.InitialBackoff, policy.MaxBackoff, policy.DownloadTimeout, policy.UploadTimeout, policy.ListTimeout, policy.DeleteTimeout, policy.CircuitBreakerCooldown} {
+		if d < 0 {
+			return fmt.Errorf("store retry durations cannot be negative")
+		}
+	}
+	if policy.MaxBackoff != 0 && policy.InitialBackoff > policy.MaxBackoff {
+		return fmt.Errorf("store retry initial backoff %s is above the max backoff %s", policy.InitialBackoff, policy.MaxBackoff)
+	}
+	return nil
+}
+
+// parseMergedBlocksStoreRange parses `<inclusive_low>:<exclusive_high>=<store_url>`
+func parseMergedBlocksStoreRange(spec string, bundleSize uint64) (low, high uint64, storeURL string, err error) {
+	blockRange, storeURL, found := strings.Cut(spec, "=")
+	if !found || storeURL == "" {
+		return 0, 0, "", fmt.Errorf("invalid merged blocks store range %q, expected <low>:<high>=<store_url>", spec)
+	}
+	lowStr, highStr, found := strings.Cut(blockRange, ":")
+	if !found {
+		return 0, 0, "", fmt.Errorf("invalid merged blocks store range %q, expected <low>:<high>=<store_url>", spec)
+	}
+	if low, err = strconv.ParseUint(lowStr, 10, 64); err != nil {
+		return 0, 0, "", fmt.Errorf("invalid low block in merged blocks store range %q: %w", spec, err)
+	}
+	if high, err = strconv.ParseUint(highStr, 10, 64); err != nil {
+		return 0, 0, "", fmt.Errorf("invalid high block in merged blocks store range %q: %w", spec, err)
+	}
+	if low%bundleSize != 0 || high%bundleSize != 0 {
+		return 0, 0, "", fmt.Errorf("merged blocks store range %q must be aligned on bundle size %d", spec, bundleSize)
+	}
+	if high != 0 && high <= low {
+		return 0, 0, "", fmt.Errorf("merged blocks store range %q is empty", spec)
+	}
+	return
+}
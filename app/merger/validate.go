@@ -0,0 +1,271 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sadiq1971/merger"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// maxValidatedMergedFiles bounds how many merged files are listed when checking their alignment
+const maxValidatedMergedFiles = 1000
+
+var validationTimeout = 30 * time.Second
+
+type ValidationCheck struct {
+	Name string
+	Err  error
+}
+
+// ValidationReport holds the result of every preflight check, in the order they were run
+type ValidationReport struct {
+	Checks []*ValidationCheck
+}
+
+func (r *ValidationReport) add(name string, err error) {
+	r.Checks = append(r.Checks, &ValidationCheck{Name: name, Err: err})
+}
+
+func (r *ValidationReport) Failed() (out []*ValidationCheck) {
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			out = append(out, check)
+		}
+	}
+	return
+}
+
+func (r *ValidationReport) String() string {
+	var lines []string
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			lines = append(lines, fmt.Sprintf("[FAIL] %s: %s", check.Name, check.Err))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("[ OK ] %s", check.Name))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateOnly runs all preflight checks (configuration, stores reachability and permissions, merged files
+// alignment) without starting the merger. The returned error summarizes the failed checks, if any.
+func (a *App) ValidateOnly() (*ValidationReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+	defer cancel()
+
+	report := &ValidationReport{}
+	bundleSize := uint64(5)
+
+	_, err := merger.ParseTimestampPrecision(a.config.OneBlockTimestampPrecision)
+	report.add("one-block timestamp precision", err)
+
+	_, err = merger.ParseLibNumEncoding(a.config.OneBlockLibNumEncoding)
+	report.add("one-block lib num encoding", err)
+
+	_, err = merger.ParseValidationPolicies(a.config.ValidationPolicies)
+	report.add("validation policies", err)
+
+	_, err = merger.ParseSuffixCanonicalization(a.config.SuffixCanonicalization)
+	report.add("suffix canonicalization", err)
+
+	if a.config.ExistingBundlePolicy != "" {
+		_, err = merger.ParseExistingBundlePolicy(a.config.ExistingBundlePolicy)
+		report.add("existing bundle policy", err)
+	}
+	if a.config.MergedBundleFormat != "" {
+		_, err = merger.ParseBundleFormat(a.config.MergedBundleFormat)
+		report.add("merged bundle format", err)
+	}
+
+	_, err = merger.ParseDuplicatePolicy(a.config.DuplicatePolicy)
+	report.add("duplicate policy", err)
+
+	_, err = merger.ParseChecksumPolicy(a.config.OneBlockChecksumPolicy)
+	report.add("one-block checksum policy", err)
+
+	if a.config.IrreversibleConfirmations >= bundleSize {
+		err = fmt.Errorf("irreversible confirmations (%d) must be lower than the bundle size (%d)", a.config.IrreversibleConfirmations, bundleSize)
+	} else {
+		err = nil
+	}
+	report.add("irreversible confirmations", err)
+	_, err = merger.ParseBundleCompletion(a.config.BundleCompletion, a.config.BundleCompletionTimeout)
+	report.add("bundle completion", err)
+
+	report.add("start block alignment", checkStartBlockAlignment(a.config.StartBlock, bundleSize, a.config.AllowStartBlockRealignment))
+	report.add("force start block", checkForceStartBlock(a.config, bundleSize))
+
+	if a.config.BackfillRange != "" {
+		_, _, err = parseBlockRange("backfill range", a.config.BackfillRange)
+		report.add("backfill range", err)
+	}
+	for _, spec := range a.config.ExpectedMergedRanges {
+		_, _, err = parseBlockRange("expected merged range", spec)
+		report.add(fmt.Sprintf("expected merged range %q", spec), err)
+	}
+	for _, spec := range a.config.ProtocolUpgrades {
+		_, err = merger.ParseProtocolUpgrade(spec)
+		report.add(fmt.Sprintf("protocol upgrade %q", spec), err)
+	}
+	report.add("store retry policy", a.config.validateStoreRetryPolicy())
+	if a.config.MaxConcurrentDownloads < 0 {
+		report.add("max concurrent downloads", fmt.Errorf("max concurrent downloads cannot be negative"))
+	}
+	if a.config.DeleteAfterMergedDelay < 0 {
+		report.add("delete after merged delay", fmt.Errorf("delete after merged delay cannot be negative"))
+	}
+	if a.config.MaxForkedBlockAge < 0 {
+		report.add("max forked block age", fmt.Errorf("max forked block age cannot be negative"))
+	}
+	if a.config.MergePipelineDepth < 0 {
+		report.add("merge pipeline depth", fmt.Errorf("merge pipeline depth cannot be negative"))
+	}
+	if a.config.CatchUpMergeParallelism < 0 || a.config.CatchUpMinBacklogBundles < 0 {
+		report.add("catch-up merges", fmt.Errorf("catch-up merge parallelism and min backlog cannot be negative"))
+	}
+	if a.config.LivenessTimeout != 0 && a.config.LivenessTimeout <= a.config.TimeBetweenPolling {
+		report.add("liveness timeout", fmt.Errorf("liveness timeout %s must exceed the polling interval %s", a.config.LivenessTimeout, a.config.TimeBetweenPolling))
+	}
+	if a.config.readOnly() {
+		report.add("observer mode", a.config.validateObserverMode())
+	}
+	_, _, err = a.config.logOptions()
+	report.add("log options", err)
+	_, err = merger.ParseTracingExporter(a.config.TracingExporter, zlog)
+	report.add("tracing exporter", err)
+
+	a.validateStore(ctx, report, "one-block store", a.config.StorageOneBlockFilesPath, nil)
+	mergedBlocksPath, replicaPaths, err := a.config.mergedBlocksDestinations()
+	report.add("merged blocks stores", err)
+	a.validateStore(ctx, report, "merged blocks store", mergedBlocksPath, func(store dstore.Store) error {
+		return validateMergedFilesAlignment(ctx, store, bundleSize)
+	})
+	for _, path := range replicaPaths {
+		a.validateStore(ctx, report, fmt.Sprintf("merged blocks replica %q", redactURL(path)), path, nil)
+	}
+	if a.config.StorageForkedBlocksFilesPath != "" {
+		a.validateStore(ctx, report, "forked blocks store", a.config.StorageForkedBlocksFilesPath, nil)
+	}
+	if a.config.MergedWatermarkStorePath != "" {
+		a.validateStore(ctx, report, "merged watermark store", a.config.MergedWatermarkStorePath, nil)
+	}
+	if a.config.PoisonRangesStorePath != "" {
+		a.validateStore(ctx, report, "poison ranges store", a.config.PoisonRangesStorePath, nil)
+	}
+	if a.config.PoisonRangeMaxAttempts < 0 {
+		report.add("poison range max attempts", fmt.Errorf("poison range max attempts cannot be negative"))
+	}
+	if a.config.DownloadDeadLetterMaxAttempts < 0 {
+		report.add("download dead-letter max attempts", fmt.Errorf("download dead-letter max attempts cannot be negative"))
+	}
+	if a.config.DownloadDeadLetterStorePath != "" {
+		a.validateStore(ctx, report, "download dead-letter store", a.config.DownloadDeadLetterStorePath, nil)
+	}
+	for _, spec := range a.config.StorageMergedBlocksFilesRanges {
+		_, _, storeURL, err := parseMergedBlocksStoreRange(spec, bundleSize)
+		report.add(fmt.Sprintf("merged blocks store range %q", spec), err)
+		if err != nil {
+			continue
+		}
+		a.validateStore(ctx, report, fmt.Sprintf("merged blocks store for range %q", spec), storeURL, func(store dstore.Store) error {
+			return validateMergedFilesAlignment(ctx, store, bundleSize)
+		})
+	}
+
+	failed := report.Failed()
+	if len(failed) == 0 {
+		return report, nil
+	}
+	var names []string
+	for _, check := range failed {
+		names = append(names, check.Name)
+	}
+	return report, fmt.Errorf("%d validation check(s) failed: %s", len(failed), strings.Join(names, ", "))
+}
+
+// validateStore checks that the store can be opened, listed and written to (a probe object is written then deleted)
+func (a *App) validateStore(ctx context.Context, report *ValidationReport, name, url string, extraCheck func(dstore.Store) error) {
+	store, err := dstore.NewDBinStore(url)
+	report.add(name+" configuration", err)
+	if err != nil {
+		return
+	}
+
+	err = store.Walk(ctx, "", func(string) error { return dstore.StopIteration })
+	report.add(name+" reachability", err)
+	if err != nil {
+		return
+	}
+
+	if !a.config.readOnly() { // an observer or a shadow merger only reads
+		report.add(name+" write and delete permissions", probeWriteAndDelete(ctx, store))
+	}
+
+	if extraCheck != nil {
+		report.add(name+" merged files alignment", extraCheck(store))
+	}
+}
+
+func probeWriteAndDelete(ctx context.Context, store dstore.Store) error {
+	filename := fmt.Sprintf(".merger-validate-%d", time.Now().UnixNano())
+	if err := store.WriteObject(ctx, filename, strings.NewReader("probe")); err != nil {
+		return fmt.Errorf("writing probe object: %w", err)
+	}
+	if err := store.DeleteObject(ctx, filename); err != nil {
+		return fmt.Errorf("deleting probe object %q: %w", filename, err)
+	}
+	return nil
+}
+
+// validateMergedFilesAlignment checks that existing merged files are named after a block aligned on the bundle size
+func validateMergedFilesAlignment(ctx context.Context, store dstore.Store, bundleSize uint64) error {
+	seen := 0
+	return store.Walk(ctx, "", func(filename string) error {
+		if strings.HasPrefix(filename, ".") {
+			return nil
+		}
+		seen++
+		if seen > maxValidatedMergedFiles {
+			return dstore.StopIteration
+		}
+		blockNum, err := strconv.ParseUint(filename, 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected file %q in merged blocks store", filename)
+		}
+		if blockNum%bundleSize != 0 {
+			return fmt.Errorf("merged file %q is not aligned on bundle size %d", filename, bundleSize)
+		}
+		return nil
+	})
+}
+
+func (a *App) runValidateOnly() error {
+	report, err := a.ValidateOnly()
+	fmt.Println(report.String())
+	if err != nil {
+		zlog.Error("merger configuration is invalid", zap.Error(err))
+	} else {
+		zlog.Info("merger configuration is valid")
+	}
+	a.Shutdown(err)
+	return err
+}
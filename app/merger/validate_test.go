@@ -0,0 +1,47 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_ValidateOnly(t *testing.T) {
+	oneBlocks := t.TempDir()
+	merged := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(merged, "0000000100.dbin.zst"), []byte{}, 0644))
+
+	app := New(&Config{
+		StorageOneBlockFilesPath:     oneBlocks,
+		StorageMergedBlocksFilesPath: merged,
+	})
+	report, err := app.ValidateOnly()
+	require.NoError(t, err, report.String())
+	assert.Empty(t, report.Failed())
+}
+
+func TestApp_ValidateOnly_Failures(t *testing.T) {
+	merged := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(merged, "0000000102.dbin.zst"), []byte{}, 0644))
+
+	app := New(&Config{
+		StorageOneBlockFilesPath:     t.TempDir(),
+		StorageMergedBlocksFilesPath: merged,
+		OneBlockTimestampPrecision:   "minute",
+		StopBlock:                    103,
+	})
+	report, err := app.ValidateOnly()
+	require.Error(t, err)
+
+	var failed []string
+	for _, check := range report.Failed() {
+		failed = append(failed, check.Name)
+	}
+	assert.Equal(t, []string{
+		"one-block timestamp precision",
+		"merged blocks store merged files alignment",
+	}, failed)
+}
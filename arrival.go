@@ -0,0 +1,131 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// ArrivalState tells if the one-block files arrive at their usual rate
+type ArrivalState string
+
+const (
+	ArrivalNormal ArrivalState = "normal"
+	ArrivalDrop   ArrivalState = "drop"  // much fewer files than usual: readers outage
+	ArrivalFlood  ArrivalState = "flood" // much more files than usual: readers replaying old blocks
+)
+
+// ArrivalWarmupCycles is how many cycles measure the usual arrival rate before anomalies are reported
+var ArrivalWarmupCycles = 10
+
+// ArrivalBaselineWeight is the weight of each cycle in the usual arrival rate (exponential moving average)
+var ArrivalBaselineWeight = 0.1
+
+// WithArrivalAnomalyDetection compares the rate at which new one-block files are found on each cycle to their usual rate:
+// below `dropRatio` times the usual rate is a drop, above `floodRatio` times is a flood. Anomalies are logged when they
+// start and end, and counted in `merger_arrival_anomalies`. During a flood, at most `floodMaxFilesPerCycle` new files are
+// handled per cycle (0 means no limit), the walk continuing on the next cycle, to keep the memory of the bundler in check.
+func WithArrivalAnomalyDetection(dropRatio, floodRatio float64, floodMaxFilesPerCycle int) Option {
+	return func(m *Merger) {
+		m.arrival = &arrivalMonitor{
+			dropRatio:             dropRatio,
+			floodRatio:            floodRatio,
+			floodMaxFilesPerCycle: floodMaxFilesPerCycle,
+			state:                 ArrivalNormal,
+		}
+	}
+}
+
+type arrivalMonitor struct {
+	sync.Mutex
+	dropRatio             float64
+	floodRatio            float64
+	floodMaxFilesPerCycle int
+
+	lastCycle time.Time
+	cycles    int
+	baseline  float64 // files per second
+	state     ArrivalState
+}
+
+// throttled tells if the walk must stop after `newFiles` new one-block files during a flood
+func (a *arrivalMonitor) throttled(newFiles int) bool {
+	if a == nil {
+		return false
+	}
+	a.Lock()
+	defer a.Unlock()
+	return a.state == ArrivalFlood && a.floodMaxFilesPerCycle > 0 && newFiles >= a.floodMaxFilesPerCycle
+}
+
+// observeCycle is called at the end of each cycle with the number of one-block files seen for the first time
+func (a *arrivalMonitor) observeCycle(now time.Time, newFiles int, logger *zap.Logger) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.lastCycle.IsZero() {
+		a.lastCycle = now // the first walk finds the whole backlog, not new files
+		return
+	}
+	elapsed := now.Sub(a.lastCycle).Seconds()
+	a.lastCycle = now
+	if elapsed <= 0 {
+		return
+	}
+	rate := float64(newFiles) / elapsed
+	metrics.OneBlockArrivalRate.SetFloat64(rate)
+
+	a.cycles++
+	if a.cycles == 1 {
+		a.baseline = rate
+	}
+
+	state := ArrivalNormal
+	if a.cycles > ArrivalWarmupCycles {
+		switch {
+		case rate < a.baseline*a.dropRatio:
+			state = ArrivalDrop
+		case a.floodRatio > 0 && rate > a.baseline*a.floodRatio:
+			state = ArrivalFlood
+		}
+	}
+	a.baseline += ArrivalBaselineWeight * (rate - a.baseline)
+	metrics.OneBlockArrivalBaseline.SetFloat64(a.baseline)
+
+	if state == a.state {
+		return
+	}
+	if state == ArrivalNormal {
+		logger.Info("one-block files arrival back to normal", zap.String("previous_state", string(a.state)), zap.Float64("rate", rate), zap.Float64("usual_rate", a.baseline))
+	} else {
+		metrics.ArrivalAnomalies.Inc(string(state))
+		logger.Warn("one-block files arrival anomaly", zap.String("state", string(state)), zap.Float64("rate", rate), zap.Float64("usual_rate", a.baseline))
+	}
+	a.state = state
+}
+
+// ArrivalState returns the state of the one-block files arrival, normal when the detection is disabled
+func (m *Merger) ArrivalState() ArrivalState {
+	if m.arrival == nil {
+		return ArrivalNormal
+	}
+	m.arrival.Lock()
+	defer m.arrival.Unlock()
+	return m.arrival.state
+}
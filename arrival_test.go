@@ -0,0 +1,44 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArrivalAnomalyDetection(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithArrivalAnomalyDetection(0.2, 5, 50))
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// first walk finds the backlog
+	m.arrival.observeCycle(t0, 1000, testLogger)
+	now := t0
+	cycle := func(newFiles int) {
+		now = now.Add(time.Second)
+		m.arrival.observeCycle(now, newFiles, testLogger)
+	}
+	for i := 0; i < ArrivalWarmupCycles+5; i++ {
+		cycle(2)
+	}
+	assert.Equal(t, ArrivalNormal, m.ArrivalState())
+	assert.False(t, m.arrival.throttled(100))
+
+	cycle(0)
+	assert.Equal(t, ArrivalDrop, m.ArrivalState())
+
+	cycle(2)
+	assert.Equal(t, ArrivalNormal, m.ArrivalState())
+
+	cycle(200)
+	assert.Equal(t, ArrivalFlood, m.ArrivalState())
+	assert.False(t, m.arrival.throttled(49))
+	assert.True(t, m.arrival.throttled(50))
+}
+
+func TestArrivalAnomalyDetectionDisabled(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	assert.Nil(t, m.arrival)
+	assert.False(t, m.arrival.throttled(1000))
+	assert.Equal(t, ArrivalNormal, m.ArrivalState())
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// WithMaxBundleOpenDuration flushes the partial current bundle (see ForceFlush) once it has been the current bundle
+// for `maxOpen`, then again every `maxOpen` while new irreversible blocks come in, so that the consumers of low-traffic
+// chains do not wait hours for the merged files. The partial merged file has the name of its bundle and is replaced
+// when the bundle is complete, the bundle boundaries do not move. A `maxOpen` of 0 disables it.
+func WithMaxBundleOpenDuration(maxOpen time.Duration) Option {
+	return func(m *Merger) {
+		if maxOpen == 0 {
+			m.autoFlush = nil
+			return
+		}
+		m.autoFlush = &autoFlusher{maxOpen: maxOpen}
+	}
+}
+
+type autoFlusher struct {
+	maxOpen time.Duration
+
+	baseBlock uint64
+	since     time.Time // when baseBlock became the current bundle, or was last flushed
+	flushed   int       // block count of the last flush of baseBlock
+}
+
+// evaluateAutoFlush is called after each walk of the one-block files
+func (m *Merger) evaluateAutoFlush(ctx context.Context) {
+	f := m.autoFlush
+	now := m.clock.Now()
+	status := m.BundleStatus()
+	if f.since.IsZero() || status.InclusiveLowBlock != f.baseBlock {
+		f.baseBlock = status.InclusiveLowBlock
+		f.since = now
+		f.flushed = 0
+		return
+	}
+	if now.Sub(f.since) < f.maxOpen || status.BlockCount == f.flushed {
+		return
+	}
+
+	baseBlock, blockCount, err := m.ForceFlush(ctx)
+	if err == ErrNothingToFlush {
+		return
+	}
+	if err != nil {
+		m.logger.Warn("cannot flush partial bundle open for too long", zap.Uint64("base_block_num", baseBlock), zap.Duration("max_open", f.maxOpen), zap.Error(err))
+		return
+	}
+	if baseBlock != f.baseBlock {
+		return // the bundle was closed in the meantime
+	}
+	f.since = now
+	f.flushed = blockCount
+	metrics.AutoFlushedBundles.Inc()
+}
@@ -0,0 +1,51 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerger_AutoFlush(t *testing.T) {
+	var flushes []int
+	io := &TestMergerIO{
+		MergeAndStoreFunc: func(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+			flushes = append(flushes, len(oneBlockFiles))
+			return nil
+		},
+	}
+	m := NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0, WithMaxBundleOpenDuration(time.Hour))
+	clock := &fakeClock{now: time.Date(2021, 7, 28, 10, 0, 0, 0, time.UTC)}
+	m.clock = clock
+
+	m.evaluateAutoFlush(context.Background())
+	m.bundler.irreversibleBlocks = []*bstream.OneBlockFile{
+		mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-suffix"),
+	}
+	clock.now = clock.now.Add(59 * time.Minute)
+	m.evaluateAutoFlush(context.Background())
+	assert.Empty(t, flushes, "open for less than the max duration")
+
+	clock.now = clock.now.Add(time.Minute)
+	m.evaluateAutoFlush(context.Background())
+	assert.Equal(t, []int{1}, flushes)
+	flushed, ok := m.FlushedBundle()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(100), flushed)
+
+	clock.now = clock.now.Add(2 * time.Hour)
+	m.evaluateAutoFlush(context.Background())
+	assert.Equal(t, []int{1}, flushes, "no new block since the last flush")
+
+	m.bundler.irreversibleBlocks = append(m.bundler.irreversibleBlocks, mustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-suffix"))
+	m.evaluateAutoFlush(context.Background())
+	assert.Equal(t, []int{1, 2}, flushes)
+
+	m.bundler.baseBlockNum = 200
+	clock.now = clock.now.Add(2 * time.Hour)
+	m.evaluateAutoFlush(context.Background())
+	assert.Equal(t, []int{1, 2}, flushes, "a new bundle was just opened")
+}
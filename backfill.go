@@ -0,0 +1,156 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// BackfillBundlesPerRange is how many consecutive bundles a backfill bundler merges before picking the next range
+var BackfillBundlesPerRange uint64 = 100
+
+// backfillRanges splits [startBlock, stopBlock) in ranges of BackfillBundlesPerRange bundles, aligned on the bundle size
+func backfillRanges(startBlock, stopBlock, bundleSize uint64) (out [][2]uint64) {
+	rangeSize := BackfillBundlesPerRange * bundleSize
+	for low := toBaseNum(startBlock, bundleSize); low < stopBlock; low += rangeSize {
+		high := low + rangeSize
+		if high > stopBlock {
+			high = toBaseNum(stopBlock+bundleSize-1, bundleSize)
+		}
+		out = append(out, [2]uint64{low, high})
+	}
+	return
+}
+
+// Backfill merges the bundles between startBlock and stopBlock (exclusive) then returns, instead of following the chain
+// like Run. The block range is split in independent ranges of BackfillBundlesPerRange bundles, merged by `concurrency`
+// bundlers at the same time against the io of the merger. Each range starts at its first missing bundle (see
+// NextBundle), so an interrupted backfill continues where it stopped. One-block files are not deleted, this is left
+// to the pruning of the live merger.
+func (m *Merger) Backfill(ctx context.Context, startBlock, stopBlock uint64, concurrency int) error {
+	if stopBlock <= startBlock {
+		return fmt.Errorf("invalid backfill range: stop block %d is not above start block %d", stopBlock, startBlock)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ranges := backfillRanges(startBlock, stopBlock, m.bundler.bundleSize)
+	m.logger.Info("backfilling merged bundles",
+		zap.Uint64("start_block", startBlock),
+		zap.Uint64("stop_block", stopBlock),
+		zap.Int("ranges", len(ranges)),
+		zap.Int("concurrency", concurrency),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t0 := time.Now()
+	var lock sync.Mutex
+	var done int
+	var firstErr error
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, r := range ranges {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(low, high uint64) {
+			defer func() { <-sem; wg.Done() }()
+			start := time.Now()
+			err := m.backfillRange(ctx, low, high)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("backfilling range [%d, %d): %w", low, high, err)
+					cancel()
+				}
+				return
+			}
+			done++
+			m.logger.Info("backfilled range",
+				zap.Uint64("low_block", low),
+				zap.Uint64("high_block", high),
+				zap.Duration("duration", time.Since(start)),
+				zap.Int("done", done),
+				zap.Int("ranges", len(ranges)),
+			)
+		}(r[0], r[1])
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.logger.Info("backfill completed", zap.Int("ranges", len(ranges)), zap.Duration("duration", time.Since(t0)))
+	return nil
+}
+
+// backfillRange merges the missing bundles of [low, high) with its own bundler, linked on the last merged bundle before them
+func (m *Merger) backfillRange(ctx context.Context, low, high uint64) error {
+	base, lib, err := m.io.NextBundle(ctx, low)
+	if err != nil && !errors.Is(err, ErrHoleFound) { // a hole is expected, it is what we are filling
+		return fmt.Errorf("looking for the next bundle: %w", err)
+	}
+	if base >= high {
+		m.logger.Debug("backfill range already merged", zap.Uint64("low_block", low), zap.Uint64("high_block", high))
+		return nil
+	}
+
+	bundler := NewBundler(base, high, m.bundler.firstStreamableBlock, m.bundler.bundleSize, m.io)
+	bundler.irreversibleConfirmations = m.bundler.irreversibleConfirmations
+	if lib != nil {
+		bundler.Reset(base, lib)
+	}
+
+	var libNums *libNumInterpreter
+	if m.libNumEncoding != LibNumAbsolute {
+		libNums = newLibNumInterpreter(m.libNumEncoding)
+	}
+	var handlerErr error
+	err = m.io.WalkOneBlockFiles(ctx, base, func(obf *bstream.OneBlockFile) error {
+		libNums.interpret(obf)
+		handlerErr = bundler.HandleBlockFile(obf)
+		return handlerErr
+	})
+	merged := bundler.BaseBlockNum() // waits for the last merge to complete
+	select {
+	case mergeErr := <-bundler.bundleError:
+		return mergeErr
+	default:
+	}
+	if errors.Is(handlerErr, ErrStopBlockReached) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("walking one-block files from %d: %w", base, err)
+	}
+	return fmt.Errorf("not enough irreversible one-block files, merged up to %d", merged)
+}
@@ -0,0 +1,74 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfillRanges(t *testing.T) {
+	defer func(v uint64) { BackfillBundlesPerRange = v }(BackfillBundlesPerRange)
+	BackfillBundlesPerRange = 2
+
+	assert.Equal(t, [][2]uint64{{100, 104}, {104, 108}, {108, 110}}, backfillRanges(101, 109, 2))
+	assert.Equal(t, [][2]uint64{{100, 104}}, backfillRanges(100, 104, 2))
+}
+
+func TestMerger_Backfill(t *testing.T) {
+	defer func(v uint64) { BackfillBundlesPerRange = v }(BackfillBundlesPerRange)
+	BackfillBundlesPerRange = 2
+
+	chain := func(highest uint64) (out []*bstream.OneBlockFile) {
+		for num := uint64(100); num <= highest; num++ {
+			out = append(out, mustNewOneBlockFile(fmt.Sprintf("%010d-%015da-%015da-%d-suffix", num, num, num-1, num-2)))
+		}
+		return
+	}
+	newIO := func(blocks []*bstream.OneBlockFile, merged *[]uint64) *TestMergerIO {
+		var lock sync.Mutex
+		return &TestMergerIO{
+			NextBundleFunc: func(ctx context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+				if lowestBaseBlock == 104 { // already merged up to 108
+					return 108, bstream.NewBlockRef("000000000000107a", 107), nil
+				}
+				return lowestBaseBlock, nil, nil
+			},
+			WalkOneBlockFilesFunc: func(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+				for _, obf := range blocks {
+					if obf.Num < inclusiveLowerBlock {
+						continue
+					}
+					if err := callback(obf); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			MergeAndStoreFunc: func(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+				lock.Lock()
+				defer lock.Unlock()
+				*merged = append(*merged, inclusiveLowerBlock)
+				return nil
+			},
+		}
+	}
+
+	var merged []uint64
+	m := NewMerger(testLogger, "", newIO(chain(115), &merged), 100, 2, 100, time.Second, time.Second, 0)
+	require.NoError(t, m.Backfill(context.Background(), 100, 110, 2))
+	sort.Slice(merged, func(i, j int) bool { return merged[i] < merged[j] })
+	assert.Equal(t, []uint64{100, 102, 108}, merged)
+
+	merged = nil
+	m = NewMerger(testLogger, "", newIO(chain(109), &merged), 100, 2, 100, time.Second, time.Second, 0)
+	assert.Error(t, m.Backfill(context.Background(), 100, 110, 2))
+
+	assert.Error(t, m.Backfill(context.Background(), 110, 100, 2))
+}
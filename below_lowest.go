@@ -0,0 +1,201 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// BelowLowestBlockPolicy is what happens to the one-block files below the first streamable block, that the merger never merges
+type BelowLowestBlockPolicy string
+
+const (
+	BelowLowestBlockIgnore  BelowLowestBlockPolicy = "ignore"  // left in place, only reported
+	BelowLowestBlockDelete  BelowLowestBlockPolicy = "delete"  // deleted
+	BelowLowestBlockArchive BelowLowestBlockPolicy = "archive" // copied to the archive store (see WithArchiveStore), then deleted
+)
+
+func ParseBelowLowestBlockPolicy(in string) (BelowLowestBlockPolicy, error) {
+	switch policy := BelowLowestBlockPolicy(in); policy {
+	case BelowLowestBlockIgnore, BelowLowestBlockDelete, BelowLowestBlockArchive:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid policy %q for one-block files below the first streamable block, expecting one of: ignore, delete, archive", in)
+}
+
+// ArchiveIOInterface copies one-block files to an archive store before deleting them
+type ArchiveIOInterface interface {
+	ArchiveOneBlockFiles(ctx context.Context, oneBlockFiles []*bstream.OneBlockFile) error
+}
+
+// WithBelowLowestBlockPolicy handles the one-block files below the first streamable block every time old files are pruned.
+// Files are counted in `merger_below_lowest_block_files` by action, ignored files once per process.
+func WithBelowLowestBlockPolicy(policy BelowLowestBlockPolicy) Option {
+	return func(m *Merger) {
+		m.belowLowestBlockPolicy = policy
+	}
+}
+
+// WithArchiveStore is where ArchiveOneBlockFiles copies one-block files
+func WithArchiveStore(store dstore.Store) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.archiveStore = store
+	}
+}
+
+// ArchiveOneBlockFiles copies every file of each one-block file to the archive store, then deletes the ones that were copied
+func (s *DStoreIO) ArchiveOneBlockFiles(ctx context.Context, oneBlockFiles []*bstream.OneBlockFile) error {
+	if s.archiveStore == nil {
+		return errors.New("no archive store configured")
+	}
+
+	var archived []*bstream.OneBlockFile
+	var firstErr error
+	for _, obf := range oneBlockFiles {
+		if err := s.archiveOneBlockFile(ctx, obf); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		archived = append(archived, obf)
+	}
+	if err := s.od.Delete(archived); err != nil {
+		return err
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d one-block files not archived, first error: %w", len(oneBlockFiles)-len(archived), len(oneBlockFiles), firstErr)
+	}
+	return nil
+}
+
+func (s *DStoreIO) archiveOneBlockFile(ctx context.Context, obf *bstream.OneBlockFile) error {
+	if s.payloadDedup && s.objectHasher != nil && len(obf.Filenames) > 1 {
+		return s.archiveDedupedOneBlockFile(ctx, obf)
+	}
+	for name := range obf.Filenames {
+		release, err := s.downloads.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		reader, err := s.oneBlocksStore.OpenObject(ctx, name)
+		if err != nil {
+			release()
+			return fmt.Errorf("archiving %q: %w", name, err)
+		}
+		err = s.archiveStore.WriteObject(ctx, name, reader)
+		reader.Close()
+		release()
+		if err != nil {
+			return fmt.Errorf("archiving %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (m *Merger) startBelowLowestBlockHandler() {
+	if m.belowLowestBlockPolicy == "" || m.firstStreamableBlock == 0 {
+		return
+	}
+	m.logger.Info("handling one-block files below the first streamable block",
+		zap.String("policy", string(m.belowLowestBlockPolicy)),
+		zap.Uint64("first_streamable_block", m.firstStreamableBlock),
+	)
+	go func() {
+		for {
+			if err := m.handleBelowLowestBlock(context.Background()); err != nil {
+				m.logger.Warn("cannot handle one-block files below the first streamable block", zap.Error(err))
+			}
+			select {
+			case <-m.Terminating():
+				return
+			case <-time.After(m.timeBetweenPruning):
+			}
+		}
+	}()
+}
+
+func (m *Merger) handleBelowLowestBlock(ctx context.Context) error {
+	var found []*bstream.OneBlockFile
+	err := m.io.WalkOneBlockFiles(ctx, 0, func(obf *bstream.OneBlockFile) error {
+		if obf.Num >= m.firstStreamableBlock {
+			return ErrStopBlockReached
+		}
+		found = append(found, obf)
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrStopBlockReached) {
+		return err
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	if m.belowLowestBlockPolicy != BelowLowestBlockIgnore {
+		if found = m.allowDeletion(ctx, "below_lowest_block", found); len(found) == 0 {
+			return nil
+		}
+	}
+
+	switch m.belowLowestBlockPolicy {
+	case BelowLowestBlockIgnore:
+		var unreported int
+		for _, obf := range found {
+			if !m.reportedBelowLowestBlock[obf.CanonicalName] {
+				m.reportedBelowLowestBlock[obf.CanonicalName] = true
+				unreported += len(obf.Filenames)
+			}
+		}
+		if unreported != 0 {
+			metrics.BelowLowestBlockFiles.AddInt(unreported, string(BelowLowestBlockIgnore))
+			m.logger.Warn("one-block files found below the first streamable block, ignoring them",
+				zap.Int("new_files", unreported),
+				zap.Stringer("lowest", found[0]),
+				zap.Uint64("first_streamable_block", m.firstStreamableBlock),
+			)
+		}
+		return nil
+
+	case BelowLowestBlockDelete:
+		if err := m.deleteOneBlockFiles(found); err != nil {
+			return err
+		}
+
+	case BelowLowestBlockArchive:
+		archiver, ok := m.io.(ArchiveIOInterface)
+		if !ok {
+			return errors.New("io cannot archive one-block files")
+		}
+		if err := archiver.ArchiveOneBlockFiles(ctx, found); err != nil {
+			return err
+		}
+	}
+
+	var count int
+	for _, obf := range found {
+		count += len(obf.Filenames)
+	}
+	metrics.BelowLowestBlockFiles.AddInt(count, string(m.belowLowestBlockPolicy))
+	m.logger.Info("handled one-block files below the first streamable block", zap.String("policy", string(m.belowLowestBlockPolicy)), zap.Int("files", count))
+	return nil
+}
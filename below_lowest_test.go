@@ -0,0 +1,72 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleBelowLowestBlock(t *testing.T) {
+	var deleted []*bstream.OneBlockFile
+	io := &TestMergerIO{
+		WalkOneBlockFilesFunc: func(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+			for _, name := range []string{
+				"0000000098-0000000000000098a-0000000000000097a-96-suffix",
+				"0000000099-0000000000000099a-0000000000000098a-97-suffix",
+				"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+				"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+			} {
+				if err := callback(mustNewOneBlockFile(name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		DeleteAsyncFunc: func(oneBlockFiles []*bstream.OneBlockFile) error {
+			deleted = append(deleted, oneBlockFiles...)
+			return nil
+		},
+	}
+
+	m := NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0, WithBelowLowestBlockPolicy(BelowLowestBlockIgnore))
+	require.NoError(t, m.handleBelowLowestBlock(context.Background()))
+	require.NoError(t, m.handleBelowLowestBlock(context.Background()))
+	assert.Len(t, m.reportedBelowLowestBlock, 2)
+	assert.Empty(t, deleted)
+
+	m = NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0, WithBelowLowestBlockPolicy(BelowLowestBlockDelete))
+	require.NoError(t, m.handleBelowLowestBlock(context.Background()))
+	require.Len(t, deleted, 2)
+	assert.Equal(t, uint64(98), deleted[0].Num)
+	assert.Equal(t, uint64(99), deleted[1].Num)
+
+	m = NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0, WithBelowLowestBlockPolicy(BelowLowestBlockArchive))
+	assert.Error(t, m.handleBelowLowestBlock(context.Background()), "TestMergerIO cannot archive")
+}
+
+func TestArchiveOneBlockFiles(t *testing.T) {
+	oneBlocksStore := dstore.NewMockStore(nil)
+	oneBlocksStore.SetFile("0000000098-0000000000000098a-0000000000000097a-96-suffix", []byte("data"))
+	archiveStore := dstore.NewMockStore(nil)
+
+	io := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 1, 0, 100, WithArchiveStore(archiveStore)).(*DStoreIO)
+	require.NoError(t, io.ArchiveOneBlockFiles(context.Background(), []*bstream.OneBlockFile{
+		mustNewOneBlockFile("0000000098-0000000000000098a-0000000000000097a-96-suffix"),
+	}))
+
+	exists, err := archiveStore.FileExists(context.Background(), "0000000098-0000000000000098a-0000000000000097a-96-suffix")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// the archived files are deleted by the deletion workers, they are done once the queue is empty and they are closed
+	require.Eventually(t, func() bool { return len(io.od.toProcess) == 0 }, time.Second, 10*time.Millisecond)
+	io.Close()
+	exists, err = oneBlocksStore.FileExists(context.Background(), "0000000098-0000000000000098a-0000000000000097a-96-suffix")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
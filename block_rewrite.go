@@ -0,0 +1,77 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/streamingfast/bstream"
+)
+
+// BlockRewriter is given each decoded block being merged and returns the block written in the bundle in its place,
+// e.g. with its payload stripped of traces or some fields redacted. Unlike a BlockTransformer working on the raw
+// one-block file data, it sees the block as decoded by bstream.GetBlockReaderFactory, and the result is encoded with
+// bstream.GetBlockWriterFactory. The block must keep its number, ID and parent.
+type BlockRewriter func(block *bstream.Block) (*bstream.Block, error)
+
+// WithBlockRewriter rewrites every block before it is written in its bundle, to produce lighter or redacted merged
+// files from the same one-block files. The rewritten blocks are counted in `merger_rewritten_blocks`.
+func WithBlockRewriter(rewriter BlockRewriter) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.blockRewriter = rewriter
+	}
+}
+
+// rewrite is called by the BundleReader on each one-block file data once canonicalized
+func (r *BundleReader) rewrite(filename string, data []byte) ([]byte, error) {
+	if r.rewriter == nil {
+		return data, nil
+	}
+	blockReader, err := bstream.GetBlockReaderFactory.New(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create block reader for one-block file %q: %w", filename, err)
+	}
+	blk, err := blockReader.Read()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading one-block file %q: %w", filename, err)
+	}
+	if blk == nil {
+		return nil, fmt.Errorf("reading one-block file %q: no block", filename)
+	}
+
+	// the rewriter may modify the block in place
+	original := blk.String()
+	num, id, previousID := blk.Number, blk.Id, blk.PreviousId
+	rewritten, err := r.rewriter(blk)
+	if err != nil {
+		return nil, fmt.Errorf("rewriting one-block file %q: %w", filename, err)
+	}
+	if rewritten.Number != num || rewritten.Id != id || rewritten.PreviousId != previousID {
+		return nil, fmt.Errorf("rewriting one-block file %q: block %s became %s", filename, original, rewritten)
+	}
+
+	buf := &bytes.Buffer{}
+	blockWriter, err := bstream.GetBlockWriterFactory.New(buf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create block writer: %w", err)
+	}
+	if err := blockWriter.Write(rewritten); err != nil {
+		return nil, fmt.Errorf("writing rewritten one-block file %q: %w", filename, err)
+	}
+	r.rewritten++
+	return buf.Bytes(), nil
+}
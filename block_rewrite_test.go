@@ -0,0 +1,40 @@
+package merger
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleReader_RewritesBlocks(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 0
+	bundle := func() []*bstream.OneBlockFile {
+		return []*bstream.OneBlockFile{
+			{CanonicalName: "o1", MemoizeData: []byte(`{"id":"0000000000000100a","prev":"0000000000000099a","num":100,"libnum":98}` + "\n")},
+			{CanonicalName: "o2", MemoizeData: []byte(`{"id":"0000000000000101a","prev":"0000000000000100a","num":101,"libnum":99}` + "\n")},
+		}
+	}
+
+	r := NewBundleReader(context.Background(), testLogger, testTracer, bundle(), nil)
+	r.rewriter = func(block *bstream.Block) (*bstream.Block, error) {
+		block.LibNum = 0
+		return block, nil
+	}
+	content, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"0000000000000100a","prev":"0000000000000099a","num":100,"libnum":0}`+"\n"+
+		`{"id":"0000000000000101a","prev":"0000000000000100a","num":101,"libnum":0}`+"\n", string(content))
+	assert.Equal(t, 2, r.rewritten)
+
+	r = NewBundleReader(context.Background(), testLogger, testTracer, bundle(), nil)
+	r.rewriter = func(block *bstream.Block) (*bstream.Block, error) {
+		block.Id = "other"
+		return block, nil
+	}
+	_, err = ioutil.ReadAll(r)
+	assert.Error(t, err, "the block identity cannot change")
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"go.uber.org/zap"
+)
+
+type blockTimeAnalysis struct {
+	maxGap        time.Duration
+	burstInterval time.Duration
+}
+
+// WithBlockTimeAnalysis reads the time of every block of a bundle once it is merged, feeds the
+// `merger_inter_block_time_seconds` histogram and logs the blocks produced more than `maxGap` after their
+// parent (gaps) or less than `burstInterval` after it, including going back in time (bursts). 0 disables a check.
+func WithBlockTimeAnalysis(maxGap, burstInterval time.Duration) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.blockTimeAnalysis = &blockTimeAnalysis{maxGap: maxGap, burstInterval: burstInterval}
+	}
+}
+
+type BlockTimeAnomaly struct {
+	BlockNum uint64
+	// Interval is the time between this block and the previous one in the bundle
+	Interval time.Duration
+}
+
+type BlockTimeStats struct {
+	Intervals int
+	Min       time.Duration
+	Max       time.Duration
+	Mean      time.Duration
+	Gaps      []*BlockTimeAnomaly
+	Bursts    []*BlockTimeAnomaly
+}
+
+func (a *blockTimeAnalysis) compute(blockNums []uint64, blockTimes []time.Time) *BlockTimeStats {
+	stats := &BlockTimeStats{}
+	var total time.Duration
+	for i := 1; i < len(blockTimes); i++ {
+		interval := blockTimes[i].Sub(blockTimes[i-1])
+		if stats.Intervals == 0 || interval < stats.Min {
+			stats.Min = interval
+		}
+		if stats.Intervals == 0 || interval > stats.Max {
+			stats.Max = interval
+		}
+		stats.Intervals++
+		total += interval
+
+		if a.maxGap > 0 && interval > a.maxGap {
+			stats.Gaps = append(stats.Gaps, &BlockTimeAnomaly{BlockNum: blockNums[i], Interval: interval})
+		}
+		if interval < 0 || (a.burstInterval > 0 && interval < a.burstInterval) {
+			stats.Bursts = append(stats.Bursts, &BlockTimeAnomaly{BlockNum: blockNums[i], Interval: interval})
+		}
+	}
+	if stats.Intervals != 0 {
+		stats.Mean = total / time.Duration(stats.Intervals)
+	}
+	return stats
+}
+
+// analyzeBlockTimes is called after a bundle is written, with the block times collected by its BundleReader
+func (s *DStoreIO) analyzeBlockTimes(baseBlock uint64, blockNums []uint64, blockTimes []time.Time) {
+	stats := s.blockTimeAnalysis.compute(blockNums, blockTimes)
+	for i := 1; i < len(blockTimes); i++ {
+		metrics.InterBlockTime.ObserveDuration(blockTimes[i].Sub(blockTimes[i-1]))
+	}
+	metrics.BlockTimeAnomalies.AddInt(len(stats.Gaps), "gap")
+	metrics.BlockTimeAnomalies.AddInt(len(stats.Bursts), "burst")
+
+	fields := []zap.Field{
+		zap.String("filename", fileNameForBlocksBundle(baseBlock)),
+		zap.Duration("min_interval", stats.Min),
+		zap.Duration("max_interval", stats.Max),
+		zap.Duration("mean_interval", stats.Mean),
+	}
+	if len(stats.Gaps) == 0 && len(stats.Bursts) == 0 {
+		s.logger.Debug("bundle block times", fields...)
+		return
+	}
+	s.logger.Warn("block time anomalies found in bundle", append(fields,
+		zap.Uint64s("gaps_before_blocks", anomalyBlockNums(stats.Gaps)),
+		zap.Uint64s("bursts_before_blocks", anomalyBlockNums(stats.Bursts)),
+	)...)
+}
+
+func anomalyBlockNums(anomalies []*BlockTimeAnomaly) (out []uint64) {
+	for _, anomaly := range anomalies {
+		out = append(out, anomaly.BlockNum)
+	}
+	return
+}
@@ -0,0 +1,37 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockTimeAnalysis_Compute(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	blockNums := []uint64{100, 101, 102, 103, 104, 105}
+	blockTimes := []time.Time{
+		start,
+		start.Add(1 * time.Second),
+		start.Add(11 * time.Second), // gap
+		start.Add(11*time.Second + 100*time.Millisecond), // burst
+		start.Add(12 * time.Second),
+		start.Add(11 * time.Second), // back in time
+	}
+
+	a := &blockTimeAnalysis{maxGap: 5 * time.Second, burstInterval: 500 * time.Millisecond}
+	stats := a.compute(blockNums, blockTimes)
+	assert.Equal(t, 5, stats.Intervals)
+	assert.Equal(t, -1*time.Second, stats.Min)
+	assert.Equal(t, 10*time.Second, stats.Max)
+	assert.Equal(t, 2200*time.Millisecond, stats.Mean)
+	assert.Equal(t, []*BlockTimeAnomaly{{BlockNum: 102, Interval: 10 * time.Second}}, stats.Gaps)
+	assert.Equal(t, []*BlockTimeAnomaly{
+		{BlockNum: 103, Interval: 100 * time.Millisecond},
+		{BlockNum: 105, Interval: -1 * time.Second},
+	}, stats.Bursts)
+
+	stats = (&blockTimeAnalysis{}).compute(blockNums, blockTimes)
+	assert.Empty(t, stats.Gaps)
+	assert.Equal(t, []*BlockTimeAnomaly{{BlockNum: 105, Interval: -1 * time.Second}}, stats.Bursts)
+}
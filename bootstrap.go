@@ -0,0 +1,99 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WithBootstrapBundles fetches and decodes the last `count` merged bundles, `concurrency` of them at once, the first time
+// NextBundle finds merged files: they land in the merged files cache (see WithMergedFilesCacheSize, which should hold
+// at least `count` files), so that bootstrapping does not read them one after the other from high-latency stores.
+func WithBootstrapBundles(count, concurrency int) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.bootstrapBundles = count
+		s.bootstrapConcurrency = concurrency
+	}
+}
+
+// bootstrapBaseBlocks returns the base blocks of the `count` bundles ending with the one at `lastBaseBlock`, highest first
+func bootstrapBaseBlocks(lastBaseBlock, bundleSize uint64, count int) (out []uint64) {
+	for base := lastBaseBlock; len(out) < count; base -= bundleSize {
+		out = append(out, base)
+		if base < bundleSize {
+			break
+		}
+	}
+	return
+}
+
+// fetchBootstrapBundles is best effort: bundles that cannot be fetched are read again when needed
+func (s *DStoreIO) fetchBootstrapBundles(ctx context.Context, lastBaseBlock uint64) {
+	baseBlocks := bootstrapBaseBlocks(lastBaseBlock, s.bundleSize, s.bootstrapBundles)
+	if s.mergedFilesCache.size < len(baseBlocks) {
+		s.logger.Warn("merged files cache is smaller than the number of bootstrap bundles, some of them will be fetched again",
+			zap.Int("cache_size", s.mergedFilesCache.size),
+			zap.Int("bootstrap_bundles", len(baseBlocks)),
+		)
+	}
+
+	concurrency := s.bootstrapConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	s.logger.Info("fetching merged bundles to bootstrap",
+		zap.Uint64("lowest_base_block", baseBlocks[len(baseBlocks)-1]),
+		zap.Uint64("highest_base_block", baseBlocks[0]),
+		zap.Int("bundles", len(baseBlocks)),
+		zap.Int("concurrency", concurrency),
+	)
+
+	t0 := time.Now()
+	var lock sync.Mutex
+	var done int
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, baseBlock := range baseBlocks {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(baseBlock uint64) {
+			defer func() { <-sem; wg.Done() }()
+			start := time.Now()
+			f, err := s.fetchMergedFile(ctx, baseBlock)
+
+			lock.Lock()
+			defer lock.Unlock()
+			done++
+			if err != nil {
+				s.logger.Warn("cannot fetch merged bundle to bootstrap", zap.Uint64("base_block", baseBlock), zap.Error(err))
+				return
+			}
+			s.logger.Info("fetched merged bundle to bootstrap",
+				zap.Uint64("base_block", baseBlock),
+				zap.Int("blocks", len(f.oneBlockFiles)),
+				zap.Duration("duration", time.Since(start)),
+				zap.Int("fetched", done),
+				zap.Int("bundles", len(baseBlocks)),
+			)
+		}(baseBlock)
+	}
+	wg.Wait()
+
+	s.logger.Info("merged bundles fetched to bootstrap", zap.Int("bundles", len(baseBlocks)), zap.Duration("duration", time.Since(t0)))
+}
@@ -0,0 +1,61 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootstrapBaseBlocks(t *testing.T) {
+	assert.Equal(t, []uint64{500, 400, 300}, bootstrapBaseBlocks(500, 100, 3))
+	assert.Equal(t, []uint64{100, 0}, bootstrapBaseBlocks(100, 100, 3))
+	assert.Equal(t, []uint64{0}, bootstrapBaseBlocks(0, 100, 3))
+}
+
+func TestDStoreIO_BootstrapBundles(t *testing.T) {
+	var lock sync.Mutex
+	opened := map[string]int{}
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	for _, base := range []uint64{100, 200, 300} {
+		mergedBlocksStore.SetFile(fileNameForBlocksBundle(base), nil)
+	}
+	mergedBlocksStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		lock.Lock()
+		opened[name]++
+		lock.Unlock()
+		num, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		last := num + 99
+		content := fmt.Sprintf(`{"id":"%016da","prev":"%016da","num":%d,"libnum":%d}`+"\n", last, last-1, last, last-1)
+		return ioutil.NopCloser(bytes.NewReader([]byte(content))), nil
+	}
+
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedBlocksStore, nil, 0, 0, 100,
+		WithBootstrapBundles(3, 2),
+	).(*DStoreIO)
+
+	base, lib, err := mio.NextBundle(context.Background(), 100)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(400), base)
+	assert.Equal(t, uint64(399), lib.Num())
+	assert.Equal(t, map[string]int{
+		fileNameForBlocksBundle(100): 1,
+		fileNameForBlocksBundle(200): 1,
+		fileNameForBlocksBundle(300): 1,
+	}, opened, "last block read from the cache")
+
+	_, _, err = mio.NextBundle(context.Background(), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, opened[fileNameForBlocksBundle(300)], "bootstrap only happens once")
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// BulkDeleteBatchSize is the maximum number of objects deleted in one bulk request (the S3 limit)
+const BulkDeleteBatchSize = 1000
+
+// BulkDeleter deletes many objects of a store in one request, dstore has no such primitive
+type BulkDeleter interface {
+	// DeleteObjects deletes up to BulkDeleteBatchSize objects, it returns the error of each object that could not be
+	// deleted. An error means that the request itself failed, none of the objects may have been deleted.
+	DeleteObjects(ctx context.Context, names []string) (failed map[string]error, err error)
+}
+
+// WithBulkDelete deletes the one-block files with `deleter`, in batches of BulkDeleteBatchSize sent one at a time,
+// falling back to deleting them one by one when a batch fails. It is not used with WithDeletionRate, which spreads single deletions.
+func WithBulkDelete(deleter BulkDeleter) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.bulkDeleter = deleter
+	}
+}
+
+// processBulkDeletions deletes the queued files with bulk requests of up to BulkDeleteBatchSize files
+func (od *oneBlockFilesDeleter) processBulkDeletions() {
+	defer od.workers.Done()
+	for {
+		var file string
+		select {
+		case file = <-od.toProcess:
+		case <-od.closing:
+			return
+		}
+		od.Lock() // Delete queues all the files of a call at once, they are batched together
+		batch := []string{file}
+	batching:
+		for len(batch) < BulkDeleteBatchSize {
+			select {
+			case file = <-od.toProcess:
+				batch = append(batch, file)
+			default:
+				break batching
+			}
+		}
+		od.Unlock()
+		metrics.DeletionQueueDepth.SetInt(len(od.toProcess), od.queue)
+		od.bulkDelete(batch)
+	}
+}
+
+// bulkDelete deletes `files` in one request, falling back to deleting them one by one when it fails. The files that
+// could not be deleted go through the retry queue.
+func (od *oneBlockFilesDeleter) bulkDelete(files []string) {
+	var batch []string
+	for _, file := range files {
+		if _, scoped := od.store.(*scopedStore); scoped && !inScope(file, false) {
+			od.deleteObject(file) // rejected by the scoped store
+			continue
+		}
+		batch = append(batch, file)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), od.deleteTimeout)
+	defer cancel()
+	failed, err := od.bulk.DeleteObjects(ctx, batch)
+	if err != nil {
+		od.logger.Warn("cannot bulk delete one-block files, deleting them one by one", zap.Int("number_of_files", len(batch)), zap.Error(err))
+		od.breaker.record(false)
+		for _, file := range batch {
+			od.deleteObject(file)
+		}
+		return
+	}
+	od.breaker.record(true)
+
+	var deleted int
+	for _, file := range batch {
+		if failure := failed[file]; failure != nil && !errors.Is(failure, dstore.ErrNotFound) {
+			od.recordFailure(file, failure)
+			continue
+		}
+		deleted++
+		od.clearFailure(file)
+	}
+	metrics.BulkDeletedFiles.AddInt(deleted)
+}
+
+// S3BulkDeleter deletes the objects of an S3 store with DeleteObjects requests
+type S3BulkDeleter struct {
+	service *s3.S3
+	bucket  string
+	store   dstore.Store
+}
+
+// NewS3BulkDeleter creates a BulkDeleter for `store`, opened from `storeURL` (`s3://bucket/path?region=...`)
+func NewS3BulkDeleter(storeURL string, store dstore.Store) (*S3BulkDeleter, error) {
+	service, bucket, err := newS3Service(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("bulk deletion: %w", err)
+	}
+	return &S3BulkDeleter{service: service, bucket: bucket, store: store}, nil
+}
+
+// newS3Service opens its own S3 client for the store at `storeURL`, dstore does not expose the one of its stores
+func newS3Service(storeURL string) (*s3.S3, string, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid store url") // the url may hold credentials
+	}
+	if u.Scheme != "s3" {
+		return nil, "", fmt.Errorf("only supported on s3 stores, not %q", u.Scheme)
+	}
+	awsConfig, bucket, _, err := dstore.ParseS3URL(u)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid s3 url: %w", err)
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, "", fmt.Errorf("error fetching AWS session info from env: %w", err)
+	}
+	return s3.New(sess), bucket, nil
+}
+
+func (d *S3BulkDeleter) DeleteObjects(ctx context.Context, names []string) (map[string]error, error) {
+	byKey := make(map[string]string, len(names))
+	objects := make([]*s3.ObjectIdentifier, 0, len(names))
+	for _, name := range names {
+		key := d.store.ObjectPath(name)
+		byKey[key] = name
+		objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+	}
+	out, err := d.service.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(d.bucket),
+		Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[string]error)
+	for _, e := range out.Errors {
+		name, ok := byKey[aws.StringValue(e.Key)]
+		if !ok {
+			continue
+		}
+		if aws.StringValue(e.Code) == s3.ErrCodeNoSuchKey {
+			failed[name] = dstore.ErrNotFound
+			continue
+		}
+		failed[name] = fmt.Errorf("%s: %s", aws.StringValue(e.Code), aws.StringValue(e.Message))
+	}
+	return failed, nil
+}
@@ -0,0 +1,131 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBulkDeleter struct {
+	sync.Mutex
+	store      *dstore.MockStore
+	batches    []int
+	failing    map[string]bool
+	requestErr error
+
+	started, release chan struct{} // when set, the first request waits for release
+}
+
+func (d *testBulkDeleter) DeleteObjects(ctx context.Context, names []string) (map[string]error, error) {
+	if d.release != nil {
+		close(d.started)
+		<-d.release
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.batches = append(d.batches, len(names))
+	if d.requestErr != nil {
+		return nil, d.requestErr
+	}
+	failed := make(map[string]error)
+	for _, name := range names {
+		if d.failing[name] {
+			failed[name] = errors.New("AccessDenied")
+			continue
+		}
+		d.store.DeleteObject(ctx, name)
+	}
+	return failed, nil
+}
+
+func TestDStoreIO_BulkDelete(t *testing.T) {
+	oneBlocksStore := dstore.NewMockStore(nil)
+	var names []string
+	var oneBlockFiles []*bstream.OneBlockFile
+	for i := 0; i < BulkDeleteBatchSize+10; i++ {
+		name := fmt.Sprintf("%010d-%016xa-%016xa-%d-suffix", 100+i, 100+i, 99+i, 98+i)
+		oneBlocksStore.SetFile(name, nil)
+		names = append(names, name)
+		oneBlockFiles = append(oneBlockFiles, mustNewOneBlockFile(name))
+	}
+	failing := names[3]
+	deleter := &testBulkDeleter{store: oneBlocksStore, failing: map[string]bool{failing: true}}
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithBulkDelete(deleter)).(*DStoreIO)
+
+	require.NoError(t, mio.DeleteAsync(oneBlockFiles))
+	require.Eventually(t, func() bool {
+		mio.od.failuresLock.Lock()
+		defer mio.od.failuresLock.Unlock()
+		return len(mio.od.failures) == 1
+	}, time.Second, 10*time.Millisecond, "left to the retry queue")
+	require.Eventually(t, func() bool {
+		deleter.Lock()
+		defer deleter.Unlock()
+		return len(deleter.batches) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int{BulkDeleteBatchSize, 10}, deleter.batches)
+	mio.Close() // the retries of the failing file also use the store
+
+	exists, err := oneBlocksStore.FileExists(context.Background(), names[0])
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = oneBlocksStore.FileExists(context.Background(), failing)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestDStoreIO_BulkDeleteFallback(t *testing.T) {
+	oneBlocksStore := dstore.NewMockStore(nil)
+	name := "0000000100-0000000000000100a-0000000000000099a-98-suffix"
+	oneBlocksStore.SetFile(name, nil)
+	deleter := &testBulkDeleter{store: oneBlocksStore, requestErr: errors.New("NotImplemented")}
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithBulkDelete(deleter)).(*DStoreIO)
+
+	require.NoError(t, mio.DeleteAsync([]*bstream.OneBlockFile{mustNewOneBlockFile(name)}))
+	require.Eventually(t, func() bool {
+		deleter.Lock()
+		defer deleter.Unlock()
+		return len(deleter.batches) == 1
+	}, time.Second, 10*time.Millisecond)
+	mio.Close()
+
+	exists, err := oneBlocksStore.FileExists(context.Background(), name)
+	require.NoError(t, err)
+	assert.False(t, exists, "deleted one by one")
+}
+
+func TestDStoreIO_CloseWaitsForDeletions(t *testing.T) {
+	oneBlocksStore := dstore.NewMockStore(nil)
+	name := "0000000100-0000000000000100a-0000000000000099a-98-suffix"
+	oneBlocksStore.SetFile(name, nil)
+	deleter := &testBulkDeleter{store: oneBlocksStore, started: make(chan struct{}), release: make(chan struct{})}
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithBulkDelete(deleter)).(*DStoreIO)
+
+	require.NoError(t, mio.DeleteAsync([]*bstream.OneBlockFile{mustNewOneBlockFile(name)}))
+	<-deleter.started
+	closed := make(chan struct{})
+	go func() {
+		mio.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("closed while a deletion is in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(deleter.release)
+	<-closed
+	exists, err := oneBlocksStore.FileExists(context.Background(), name)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Error(t, mio.DeleteAsync([]*bstream.OneBlockFile{mustNewOneBlockFile(name)}), "closed")
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/streamingfast/bstream"
+)
+
+// BundleState is what a BundleCompletion decides on
+type BundleState struct {
+	BaseBlockNum       uint64
+	ExclusiveHighBlock uint64
+	Confirmations      uint64 // see WithIrreversibleConfirmations
+
+	// Above is the irreversible block at or above ExclusiveHighBlock just received, nil when evaluated after a walk
+	Above *bstream.OneBlockFile
+	// LastIrreversible is the highest irreversible block of the bundle, nil if none, received at LastIrreversibleAt
+	LastIrreversible   *bstream.OneBlockFile
+	LastIrreversibleAt time.Time
+	// Children are the one-block files seen linking to LastIrreversible, irreversible or not
+	Children []*bstream.OneBlockFile
+	Now      time.Time
+}
+
+// BundleCompletion decides when the current bundle is complete: it is then merged with its irreversible blocks, the
+// blocks received below its boundary afterwards are never merged. It is evaluated on each irreversible block at or
+// above the boundary and after each walk of the one-block files.
+type BundleCompletion interface {
+	Complete(state *BundleState) bool
+}
+
+// CompleteByLIB completes the bundle once the LIB is Confirmations blocks above its boundary (the default)
+type CompleteByLIB struct{}
+
+func (CompleteByLIB) Complete(s *BundleState) bool {
+	return s.Above != nil && s.Above.Num >= s.ExclusiveHighBlock+s.Confirmations
+}
+
+// CompleteByHighestLinkable also completes the bundle as soon as every block seen linking to its last irreversible
+// block is above its boundary, without waiting for the LIB to pass it. This suits the chains skipping block numbers
+// (e.g. Solana skipped slots) whose LIB moves slowly, but only when a block number is never reused by a fork.
+type CompleteByHighestLinkable struct{}
+
+func (CompleteByHighestLinkable) Complete(s *BundleState) bool {
+	if s.Above != nil {
+		return true
+	}
+	if s.LastIrreversible == nil || len(s.Children) == 0 {
+		return false
+	}
+	for _, child := range s.Children {
+		if child.Num < s.ExclusiveHighBlock {
+			return false
+		}
+	}
+	return true
+}
+
+// CompleteByTimestamp also completes the bundle when no block became irreversible in it for Timeout, for the chains
+// that can go a long time without a block above the boundary. A block below the boundary arriving later is lost.
+type CompleteByTimestamp struct {
+	Timeout time.Duration
+}
+
+func (c CompleteByTimestamp) Complete(s *BundleState) bool {
+	if s.Above != nil {
+		return true
+	}
+	return s.LastIrreversible != nil && s.Now.Sub(s.LastIrreversibleAt) >= c.Timeout
+}
+
+// ParseBundleCompletion parses the bundle completion strategy: lib (the default), highest-linkable or timestamp, which
+// takes `timeout`
+func ParseBundleCompletion(in string, timeout time.Duration) (BundleCompletion, error) {
+	switch in {
+	case "", "lib":
+		return CompleteByLIB{}, nil
+	case "highest-linkable":
+		return CompleteByHighestLinkable{}, nil
+	case "timestamp":
+		if timeout <= 0 {
+			return nil, fmt.Errorf("timestamp bundle completion requires a positive timeout, got %s", timeout)
+		}
+		return CompleteByTimestamp{Timeout: timeout}, nil
+	}
+	return nil, fmt.Errorf("invalid bundle completion %q, expected one of lib, highest-linkable or timestamp", in)
+}
+
+// WithBundleCompletion replaces the rule deciding when a bundle is complete, see BundleCompletion
+func WithBundleCompletion(completion BundleCompletion) Option {
+	return func(m *Merger) {
+		m.bundler.completion = completion
+	}
+}
+
+// bundleState must be called from the thread calling HandleBlockFile
+func (b *Bundler) bundleState(above *bstream.OneBlockFile) *BundleState {
+	b.Lock()
+	state := &BundleState{
+		BaseBlockNum:       b.baseBlockNum,
+		ExclusiveHighBlock: b.baseBlockNum + b.bundleSize,
+		Confirmations:      b.irreversibleConfirmations,
+		Above:              above,
+		LastIrreversibleAt: b.lastIrreversibleAt,
+		Now:                b.now(),
+	}
+	if length := len(b.irreversibleBlocks); length != 0 && b.irreversibleBlocks[length-1].Num >= b.baseBlockNum {
+		state.LastIrreversible = b.irreversibleBlocks[length-1]
+	}
+	b.Unlock()
+
+	if state.LastIrreversible != nil {
+		for _, obf := range b.seenBlockFiles {
+			if obf.PreviousID == state.LastIrreversible.ID {
+				state.Children = append(state.Children, obf)
+			}
+		}
+	}
+	return state
+}
+
+// evaluateCompletion closes the current bundle without a block above its boundary when the completion strategy allows
+// it, it must be called from the thread calling HandleBlockFile
+func (b *Bundler) evaluateCompletion() error {
+	state := b.bundleState(nil)
+	if state.LastIrreversible == nil || !b.completion.Complete(state) {
+		return nil
+	}
+	return b.closeBundle(nil)
+}
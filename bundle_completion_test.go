@@ -0,0 +1,110 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleCompletion(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := mustNewOneBlockFile("0000000198-0000000000000198a-0000000000000197a-196-suffix")
+	tests := []struct {
+		name       string
+		completion BundleCompletion
+		state      BundleState
+		expect     bool
+	}{
+		{"lib above confirmations", CompleteByLIB{}, BundleState{ExclusiveHighBlock: 200, Confirmations: 2, Above: mustNewOneBlockFile("0000000202-0000000000000202a-0000000000000201a-200-suffix")}, true},
+		{"lib within confirmations", CompleteByLIB{}, BundleState{ExclusiveHighBlock: 200, Confirmations: 2, Above: mustNewOneBlockFile("0000000201-0000000000000201a-0000000000000200a-199-suffix")}, false},
+		{"lib after walk", CompleteByLIB{}, BundleState{ExclusiveHighBlock: 200, LastIrreversible: last, LastIrreversibleAt: now.Add(-time.Hour), Now: now}, false},
+		{"linkable children above", CompleteByHighestLinkable{}, BundleState{ExclusiveHighBlock: 200, LastIrreversible: last, Children: []*bstream.OneBlockFile{
+			mustNewOneBlockFile("0000000201-0000000000000201a-0000000000000198a-196-suffix"),
+		}}, true},
+		{"linkable child below", CompleteByHighestLinkable{}, BundleState{ExclusiveHighBlock: 200, LastIrreversible: last, Children: []*bstream.OneBlockFile{
+			mustNewOneBlockFile("0000000201-0000000000000201a-0000000000000198a-196-suffix"),
+			mustNewOneBlockFile("0000000199-0000000000000199b-0000000000000198a-196-suffix"),
+		}}, false},
+		{"linkable no child", CompleteByHighestLinkable{}, BundleState{ExclusiveHighBlock: 200, LastIrreversible: last}, false},
+		{"timestamp elapsed", CompleteByTimestamp{Timeout: time.Minute}, BundleState{ExclusiveHighBlock: 200, LastIrreversible: last, LastIrreversibleAt: now.Add(-2 * time.Minute), Now: now}, true},
+		{"timestamp not elapsed", CompleteByTimestamp{Timeout: time.Minute}, BundleState{ExclusiveHighBlock: 200, LastIrreversible: last, LastIrreversibleAt: now.Add(-time.Second), Now: now}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expect, test.completion.Complete(&test.state))
+		})
+	}
+}
+
+func TestParseBundleCompletion(t *testing.T) {
+	completion, err := ParseBundleCompletion("", 0)
+	require.NoError(t, err)
+	assert.Equal(t, CompleteByLIB{}, completion)
+
+	completion, err = ParseBundleCompletion("timestamp", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, CompleteByTimestamp{Timeout: time.Minute}, completion)
+
+	_, err = ParseBundleCompletion("timestamp", 0)
+	assert.Error(t, err)
+	_, err = ParseBundleCompletion("height", 0)
+	assert.Error(t, err)
+}
+
+func newCompletionTestBundler(completion BundleCompletion, merged *[]uint64) *Bundler {
+	b := newTestBundler(func(inclusiveLowerBlock uint64) error {
+		*merged = append(*merged, inclusiveLowerBlock)
+		return nil
+	})
+	b.completion = completion
+	return b
+}
+
+func TestBundler_CompleteByHighestLinkable(t *testing.T) {
+	for _, test := range []struct {
+		completion BundleCompletion
+		expect     []uint64
+	}{
+		{CompleteByLIB{}, nil},
+		{CompleteByHighestLinkable{}, []uint64{100}},
+	} {
+		var merged []uint64
+		b := newCompletionTestBundler(test.completion, &merged)
+		for _, name := range []string{
+			"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+			"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+			"0000000103-0000000000000103a-0000000000000101a-100-suffix", // 102 skipped
+			"0000000104-0000000000000104a-0000000000000103a-101-suffix",
+		} {
+			require.NoError(t, b.HandleBlockFile(mustNewOneBlockFile(name)))
+		}
+		require.NoError(t, b.evaluateCompletion())
+		b.inProcess.Lock()
+		b.inProcess.Unlock()
+		assert.Equal(t, test.expect, merged)
+	}
+}
+
+func TestBundler_CompleteByTimestamp(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var merged []uint64
+	b := newCompletionTestBundler(CompleteByTimestamp{Timeout: time.Minute}, &merged)
+	b.now = func() time.Time { return now }
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100} {
+		require.NoError(t, b.HandleBlockFile(obf))
+	}
+
+	now = now.Add(30 * time.Second)
+	require.NoError(t, b.evaluateCompletion())
+	assert.EqualValues(t, 100, b.BaseBlockNum())
+
+	now = now.Add(time.Minute)
+	require.NoError(t, b.evaluateCompletion())
+	b.inProcess.Lock()
+	b.inProcess.Unlock()
+	assert.Equal(t, []uint64{100}, merged)
+	assert.EqualValues(t, 102, b.BaseBlockNum())
+}
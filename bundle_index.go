@@ -0,0 +1,129 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// BundleIndex lists the blocks of a merged bundle and the forked blocks seen in its range, so that cursors and fork
+// checks can be resolved without downloading the bundle
+type BundleIndex struct {
+	BaseBlock uint64         `json:"base_block"`
+	Blocks    []IndexedBlock `json:"blocks"`
+}
+
+// IndexedBlock is a block of a BundleIndex, Forked for the blocks moved to the forked blocks store instead of merged
+type IndexedBlock struct {
+	Num        uint64 `json:"num"`
+	ID         string `json:"id"`
+	PreviousID string `json:"previous_id"`
+	LibNum     uint64 `json:"lib_num"`
+	Forked     bool   `json:"forked,omitempty"`
+}
+
+// IndexWriter receives the index of each merged bundle
+type IndexWriter interface {
+	WriteIndex(ctx context.Context, index *BundleIndex) error
+}
+
+// WithIndexWriter gives the BundleIndex of each bundle to `writer` once the bundle is merged, from the merging
+// goroutine. Failing to write an index is logged and counted in `merger_bundle_index_errors`, it does not fail the merge.
+func WithIndexWriter(writer IndexWriter) Option {
+	return func(m *Merger) {
+		m.bundler.onIndex = func(index *BundleIndex) {
+			ctx, cancel := context.WithTimeout(context.Background(), WriteObjectTimeout)
+			defer cancel()
+			if err := writer.WriteIndex(ctx, index); err != nil {
+				metrics.BundleIndexErrors.Inc()
+				m.logger.Warn("cannot write bundle index", zap.Uint64("base_block", index.BaseBlock), zap.Error(err))
+			}
+		}
+	}
+}
+
+// buildBundleIndex indexes the blocks of `bundle` within its range, by block number, the merged block first
+func buildBundleIndex(bundle *pendingBundle, bundleSize uint64) *BundleIndex {
+	index := &BundleIndex{BaseBlock: bundle.baseBlockNum}
+	add := func(obf *bstream.OneBlockFile, forked bool) {
+		if obf.Num < bundle.baseBlockNum || obf.Num >= bundle.baseBlockNum+bundleSize {
+			return
+		}
+		index.Blocks = append(index.Blocks, IndexedBlock{Num: obf.Num, ID: obf.ID, PreviousID: obf.PreviousID, LibNum: obf.LibNum, Forked: forked})
+	}
+	for _, obf := range bundle.blocks {
+		add(obf, false)
+	}
+	for _, obf := range bundle.forked {
+		add(obf, true)
+	}
+	sort.SliceStable(index.Blocks, func(i, j int) bool {
+		if index.Blocks[i].Num != index.Blocks[j].Num {
+			return index.Blocks[i].Num < index.Blocks[j].Num
+		}
+		return !index.Blocks[i].Forked && index.Blocks[j].Forked
+	})
+	return index
+}
+
+type storeIndexWriter struct {
+	store dstore.Store
+}
+
+// NewStoreIndexWriter writes the index of each bundle to `<base block>.index.json` in `store`. The store must not be
+// inside the merged blocks store, whose listing only expects merged files.
+func NewStoreIndexWriter(store dstore.Store) IndexWriter {
+	return &storeIndexWriter{store: store}
+}
+
+func (w *storeIndexWriter) WriteIndex(ctx context.Context, index *BundleIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("encoding bundle index: %w", err)
+	}
+	return w.store.WriteObject(ctx, bundleIndexFilename(index.BaseBlock), bytes.NewReader(data))
+}
+
+func bundleIndexFilename(baseBlock uint64) string {
+	return fileNameForBlocksBundle(baseBlock) + ".index.json"
+}
+
+// ReadBundleIndex reads the index of the bundle starting at `baseBlock` written by NewStoreIndexWriter
+func ReadBundleIndex(ctx context.Context, store dstore.Store, baseBlock uint64) (*BundleIndex, error) {
+	reader, err := store.OpenObject(ctx, bundleIndexFilename(baseBlock))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading bundle index %d: %w", baseBlock, err)
+	}
+	index := &BundleIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("decoding bundle index %d: %w", baseBlock, err)
+	}
+	return index, nil
+}
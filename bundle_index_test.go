@@ -0,0 +1,42 @@
+package merger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildBundleIndex(t *testing.T) {
+	forked101 := bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix")
+	lingering := bstream.MustNewOneBlockFile("0000000099-0000000000000099b-0000000000000098a-97-suffix")
+	index := buildBundleIndex(&pendingBundle{
+		baseBlockNum: 100,
+		blocks:       []*bstream.OneBlockFile{block100, block101, block102Final100},
+		forked:       []*bstream.OneBlockFile{forked101, lingering},
+	}, 2)
+
+	assert.Equal(t, &BundleIndex{
+		BaseBlock: 100,
+		Blocks: []IndexedBlock{
+			{Num: 100, ID: "0000000000000100a", PreviousID: "0000000000000099a", LibNum: 98},
+			{Num: 101, ID: "0000000000000101a", PreviousID: "0000000000000100a", LibNum: 99},
+			{Num: 101, ID: "0000000000000101b", PreviousID: "0000000000000100a", LibNum: 99, Forked: true},
+		},
+	}, index)
+}
+
+func TestStoreIndexWriter(t *testing.T) {
+	store := dstore.NewMockStore(nil)
+	index := &BundleIndex{BaseBlock: 100, Blocks: []IndexedBlock{{Num: 100, ID: "0000000000000100a", PreviousID: "0000000000000099a", LibNum: 98}}}
+	require.NoError(t, NewStoreIndexWriter(store).WriteIndex(context.Background(), index))
+
+	read, err := ReadBundleIndex(context.Background(), store, 100)
+	require.NoError(t, err)
+	assert.Equal(t, index, read)
+	_, err = ReadBundleIndex(context.Background(), store, 200)
+	assert.Error(t, err)
+}
@@ -0,0 +1,184 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// TransactionCounter returns the number of transactions in the data of a one-block file, header included
+type TransactionCounter func(data []byte) (int, error)
+
+var transactionCounters = struct {
+	sync.RWMutex
+	byContentType map[string]TransactionCounter
+}{byContentType: map[string]TransactionCounter{}}
+
+// RegisterTransactionCounter provides the transaction count of the blocks with the `contentType` codec to the bundle
+// statistics. Like RegisterBlockCanonicalizer, it is meant to be called by the chain-specific binaries.
+func RegisterTransactionCounter(contentType string, counter TransactionCounter) {
+	transactionCounters.Lock()
+	defer transactionCounters.Unlock()
+	transactionCounters.byContentType[contentType] = counter
+}
+
+func transactionCounter(contentType string) TransactionCounter {
+	transactionCounters.RLock()
+	defer transactionCounters.RUnlock()
+	return transactionCounters.byContentType[contentType]
+}
+
+// BundleStats summarizes a merged bundle
+type BundleStats struct {
+	BaseBlock    uint64  `json:"base_block"`
+	BlockCount   int     `json:"block_count"`
+	MinBlockSize int     `json:"min_block_size"`
+	MaxBlockSize int     `json:"max_block_size"`
+	AvgBlockSize float64 `json:"avg_block_size"`
+	// TotalBytes is the size of the blocks, StoredBytes the size of the merged file (smaller when compressed)
+	TotalBytes       uint64  `json:"total_bytes"`
+	StoredBytes      uint64  `json:"stored_bytes"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	// TransactionCount is only set when a transaction counter is registered for the chain
+	TransactionCount *uint64 `json:"transaction_count,omitempty"`
+	// PayloadHashes are the sha256 of the one-block file payload of each block, by canonical name, only set with
+	// WithPayloadDedup
+	PayloadHashes map[string]string `json:"payload_hashes,omitempty"`
+}
+
+// WithBundleStats computes the BundleStats of each merged bundle, exports them to the `merger_bundle_*` metrics
+// and, when `store` is not nil, writes them to `<base block>.json` in `store`. The store must not be inside the
+// merged blocks store, whose listing only expects merged files.
+func WithBundleStats(store dstore.Store) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.bundleStats = true
+		s.bundleStatsStore = store
+	}
+}
+
+// bundleStatsCollector is fed the one-block files data by the BundleReader
+type bundleStatsCollector struct {
+	stats      BundleStats
+	txCount    uint64
+	txCountErr bool // the transactions of a block could not be counted, no total is given
+
+	hashPayloads bool
+}
+
+// observePayload hashes the payload of the one-block file `name`, as downloaded
+func (c *bundleStatsCollector) observePayload(name string, payload []byte) {
+	if !c.hashPayloads {
+		return
+	}
+	if c.stats.PayloadHashes == nil {
+		c.stats.PayloadHashes = make(map[string]string)
+	}
+	c.stats.PayloadHashes[name] = payloadHash(payload)
+}
+
+func (c *bundleStatsCollector) observe(data []byte) {
+	size := len(data)
+	if c.stats.BlockCount == 0 || size < c.stats.MinBlockSize {
+		c.stats.MinBlockSize = size
+	}
+	if size > c.stats.MaxBlockSize {
+		c.stats.MaxBlockSize = size
+	}
+	c.stats.BlockCount++
+	c.stats.TotalBytes += uint64(size)
+
+	if c.txCountErr {
+		return
+	}
+	codec, err := SniffBlockCodec(data)
+	if err != nil {
+		c.txCountErr = true
+		return
+	}
+	counter := transactionCounter(codec.ContentType)
+	if counter == nil {
+		c.txCountErr = true
+		return
+	}
+	count, err := counter(data)
+	if err != nil {
+		c.txCountErr = true
+		return
+	}
+	c.txCount += uint64(count)
+}
+
+func (c *bundleStatsCollector) result(baseBlock, storedBytes uint64) *BundleStats {
+	stats := c.stats
+	stats.BaseBlock = baseBlock
+	stats.StoredBytes = storedBytes
+	if stats.BlockCount != 0 {
+		stats.AvgBlockSize = float64(stats.TotalBytes) / float64(stats.BlockCount)
+	}
+	if stats.TotalBytes != 0 {
+		stats.CompressionRatio = float64(storedBytes) / float64(stats.TotalBytes)
+	}
+	if !c.txCountErr && stats.BlockCount != 0 {
+		txCount := c.txCount
+		stats.TransactionCount = &txCount
+	}
+	return &stats
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	count uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddUint64(&r.count, uint64(n))
+	return n, err
+}
+
+func (s *DStoreIO) exportBundleStats(ctx context.Context, stats *BundleStats) {
+	metrics.BundleBlockSize.SetInt(stats.MinBlockSize, "min")
+	metrics.BundleBlockSize.SetInt(stats.MaxBlockSize, "max")
+	metrics.BundleBlockSize.SetFloat64(stats.AvgBlockSize, "avg")
+	metrics.BundleCompressionRatio.SetFloat64(stats.CompressionRatio)
+	if stats.TransactionCount != nil {
+		metrics.BundleTransactions.SetUint64(*stats.TransactionCount)
+		metrics.MergedTransactions.AddUint64(*stats.TransactionCount)
+	}
+
+	if s.bundleStatsStore == nil {
+		return
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		s.logger.Warn("cannot encode bundle stats", zap.Uint64("base_block", stats.BaseBlock), zap.Error(err))
+		return
+	}
+	writeCtx, cancel := context.WithTimeout(ctx, WriteObjectTimeout)
+	defer cancel()
+	if err := s.bundleStatsStore.WriteObject(writeCtx, fileNameForBlocksBundle(stats.BaseBlock)+".json", bytes.NewReader(data)); err != nil {
+		s.logger.Warn("cannot write bundle stats", zap.Uint64("base_block", stats.BaseBlock), zap.Error(err))
+	}
+}
@@ -0,0 +1,70 @@
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleStatsCollector(t *testing.T) {
+	RegisterTransactionCounter("TXC", func(data []byte) (int, error) {
+		return len(data) - dbinHeaderLen, nil // one transaction per payload byte
+	})
+
+	c := &bundleStatsCollector{}
+	c.observe(dbinData("TXC", "01", 0x1))
+	c.observe(dbinData("TXC", "01", 0x1, 0x2, 0x3))
+	stats := c.result(100, 12)
+	assert.Equal(t, 2, stats.BlockCount)
+	assert.Equal(t, 11, stats.MinBlockSize)
+	assert.Equal(t, 13, stats.MaxBlockSize)
+	assert.Equal(t, float64(12), stats.AvgBlockSize)
+	assert.Equal(t, uint64(24), stats.TotalBytes)
+	assert.Equal(t, 0.5, stats.CompressionRatio)
+	require.NotNil(t, stats.TransactionCount)
+	assert.Equal(t, uint64(4), *stats.TransactionCount)
+
+	c = &bundleStatsCollector{}
+	c.observe(dbinData("TXC", "01", 0x1))
+	c.observe(dbinData("ETH", "01", 0x1))
+	assert.Nil(t, c.result(100, 22).TransactionCount, "no total when a block cannot be counted")
+	assert.Nil(t, c.result(100, 22).PayloadHashes)
+
+	c = &bundleStatsCollector{hashPayloads: true}
+	c.observePayload("0000000100-0000000000000100a-0000000000000099a-98", []byte("payload"))
+	assert.Equal(t, map[string]string{
+		"0000000100-0000000000000100a-0000000000000099a-98": "239f59ed55e737c77147cf55ad0c1b030b6d7ee748a7426952f9b852d5a935e5",
+	}, c.result(100, 22).PayloadHashes)
+}
+
+func TestMergerIO_BundleStats(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = dbinHeaderLen
+	oneBlocksStore := dstore.NewMockStore(nil)
+	oneBlocksStore.SetFile("0000000100-0000000000000100a-0000000000000099a-98-suffix", dbinData("ETH", "01", 0x1, 0x2))
+	oneBlocksStore.SetFile("0000000101-0000000000000101a-0000000000000100a-99-suffix", dbinData("ETH", "01", 0x3))
+	statsStore := dstore.NewMockStore(nil)
+
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithBundleStats(statsStore))
+	require.NoError(t, mio.MergeAndStore(context.Background(), 100, []*bstream.OneBlockFile{
+		mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-suffix"),
+		mustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-suffix"),
+	}))
+
+	reader, err := statsStore.OpenObject(context.Background(), "0000000100.json")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	stats := &BundleStats{}
+	require.NoError(t, json.Unmarshal(data, stats))
+	assert.Equal(t, uint64(100), stats.BaseBlock)
+	assert.Equal(t, 2, stats.BlockCount)
+	assert.Equal(t, uint64(23), stats.TotalBytes)
+	assert.Equal(t, uint64(13), stats.StoredBytes, "the header of the second block is not stored")
+	assert.Nil(t, stats.TransactionCount)
+}
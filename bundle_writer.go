@@ -0,0 +1,361 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/streamingfast/bstream"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// BundleWriter is the format of the merged files. The merger reads them back (bootstrap, verification, continuity)
+// with the same BundleWriter, so that all the merged files of a store must have the same format.
+type BundleWriter interface {
+	// WriteBundle writes the merged file to `w` from `dbin`, the blocks of the bundle as written by the bstream
+	// block writers
+	WriteBundle(w io.Writer, dbin io.Reader) error
+	// ReadBundle calls `f` with each block of a merged file written by WriteBundle, in order
+	ReadBundle(r io.Reader, f func(block *bstream.Block) error) error
+}
+
+// BundleFormat names a BundleWriter in the configuration
+type BundleFormat string
+
+const (
+	// BundleFormatDBin is the concatenation of the blocks as written by the bstream block writers (default)
+	BundleFormatDBin BundleFormat = "dbin"
+	// BundleFormatBlockRange is the framed protobuf block-range file with an index footer, see BlockRangeBundleWriter
+	BundleFormatBlockRange BundleFormat = "block-range"
+)
+
+func ParseBundleFormat(in string) (BundleWriter, error) {
+	switch BundleFormat(in) {
+	case "", BundleFormatDBin:
+		return DBinBundleWriter{}, nil
+	case BundleFormatBlockRange:
+		return BlockRangeBundleWriter{}, nil
+	}
+	return nil, fmt.Errorf("invalid bundle format %q, expected %q or %q", in, BundleFormatDBin, BundleFormatBlockRange)
+}
+
+// WithBundleWriter writes the merged files in the format of `writer` instead of dbin. The readers of the merged blocks
+// stores (firehose, other mergers) must support that format.
+func WithBundleWriter(writer BundleWriter) DStoreIOOption {
+	return func(s *DStoreIO) {
+		if _, dbin := writer.(DBinBundleWriter); dbin {
+			writer = nil // no need to copy the content through a pipe
+		}
+		s.bundleWriter = writer
+	}
+}
+
+func (s *DStoreIO) bundleFormat() BundleWriter {
+	if s.bundleWriter == nil {
+		return DBinBundleWriter{}
+	}
+	return s.bundleWriter
+}
+
+// bundleContentReader streams the merged file written by `writer` from the dbin content `in`
+func bundleContentReader(in io.Reader, writer BundleWriter) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writer.WriteBundle(pw, in))
+	}()
+	return pr
+}
+
+// DBinBundleWriter writes the dbin content as-is
+type DBinBundleWriter struct{}
+
+func (DBinBundleWriter) WriteBundle(w io.Writer, dbin io.Reader) error {
+	_, err := io.Copy(w, dbin)
+	return err
+}
+
+func (DBinBundleWriter) ReadBundle(r io.Reader, f func(block *bstream.Block) error) error {
+	blkReader, err := bstream.GetBlockReaderFactory.New(r)
+	if err != nil {
+		return err
+	}
+	for {
+		block, err := blkReader.Read()
+		if block != nil {
+			if err := f(block); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// blockRangeMagic starts and ends the block-range files
+const blockRangeMagic = "SFBR"
+
+// blockRangeFooterLen is the size of the footer: the index length (uint64, little-endian) then blockRangeMagic
+const blockRangeFooterLen = 8 + len(blockRangeMagic)
+
+// BlockRangeBundleWriter writes framed protobuf block-range files, for the batch processors needing random access to
+// the blocks of a bundle:
+//
+//	"SFBR"
+//	for each block: uvarint length, then the sf.bstream.v1.Block protobuf
+//	the BlockRangeIndex protobuf, see proto/merger/blockrange/v1/blockrange.proto
+//	the length of the index (uint64, little-endian), then "SFBR"
+//
+// The index holds, for each block, the offset and length of its protobuf. See ReadBlockRangeIndex and
+// ReadBlockRangeBlock.
+type BlockRangeBundleWriter struct{}
+
+// BlockRangeEntry is the index entry of a block in a block-range file
+type BlockRangeEntry struct {
+	Num        uint64
+	ID         string
+	PreviousID string
+	LibNum     uint64
+	// Offset and Length locate the sf.bstream.v1.Block protobuf of the block in the file
+	Offset uint64
+	Length uint64
+}
+
+func (BlockRangeBundleWriter) WriteBundle(w io.Writer, dbin io.Reader) error {
+	blkReader, err := bstream.GetBlockReaderFactory.New(dbin)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, blockRangeMagic); err != nil {
+		return err
+	}
+	offset := uint64(len(blockRangeMagic))
+
+	var entries []BlockRangeEntry
+	for {
+		block, err := blkReader.Read()
+		if block != nil {
+			pb, err := block.ToProto()
+			if err != nil {
+				return fmt.Errorf("block %d to proto: %w", block.Number, err)
+			}
+			data, err := proto.Marshal(pb)
+			if err != nil {
+				return fmt.Errorf("marshalling block %d: %w", block.Number, err)
+			}
+			prefix := protowire.AppendVarint(nil, uint64(len(data)))
+			if _, err := w.Write(prefix); err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			offset += uint64(len(prefix))
+			entries = append(entries, BlockRangeEntry{
+				Num:        block.Number,
+				ID:         block.Id,
+				PreviousID: block.PreviousId,
+				LibNum:     block.LibNum,
+				Offset:     offset,
+				Length:     uint64(len(data)),
+			})
+			offset += uint64(len(data))
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+	}
+
+	index := encodeBlockRangeIndex(entries)
+	footer := make([]byte, blockRangeFooterLen)
+	binary.LittleEndian.PutUint64(footer, uint64(len(index)))
+	copy(footer[8:], blockRangeMagic)
+	if _, err := w.Write(index); err != nil {
+		return err
+	}
+	_, err = w.Write(footer)
+	return err
+}
+
+func (BlockRangeBundleWriter) ReadBundle(r io.Reader, f func(block *bstream.Block) error) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	file := bytes.NewReader(data)
+	entries, err := ReadBlockRangeIndex(file, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		block, err := ReadBlockRangeBlock(file, entry)
+		if err != nil {
+			return err
+		}
+		if err := f(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadBlockRangeIndex reads the index of the block-range file `r` of `size` bytes
+func ReadBlockRangeIndex(r io.ReaderAt, size int64) ([]BlockRangeEntry, error) {
+	if size < int64(len(blockRangeMagic)+blockRangeFooterLen) {
+		return nil, fmt.Errorf("not a block-range file: only %d bytes", size)
+	}
+	footer := make([]byte, blockRangeFooterLen)
+	if _, err := r.ReadAt(footer, size-int64(blockRangeFooterLen)); err != nil {
+		return nil, fmt.Errorf("reading footer: %w", err)
+	}
+	if string(footer[8:]) != blockRangeMagic {
+		return nil, fmt.Errorf("not a block-range file: invalid footer")
+	}
+	indexLen := binary.LittleEndian.Uint64(footer[:8])
+	indexEnd := size - int64(blockRangeFooterLen)
+	if indexLen > uint64(indexEnd-int64(len(blockRangeMagic))) {
+		return nil, fmt.Errorf("corrupted block-range file: index of %d bytes", indexLen)
+	}
+	index := make([]byte, indexLen)
+	if _, err := r.ReadAt(index, indexEnd-int64(indexLen)); err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	return decodeBlockRangeIndex(index)
+}
+
+// ReadBlockRangeBlock reads the block of `entry` from the block-range file `r`
+func ReadBlockRangeBlock(r io.ReaderAt, entry BlockRangeEntry) (*bstream.Block, error) {
+	data := make([]byte, entry.Length)
+	if _, err := r.ReadAt(data, int64(entry.Offset)); err != nil {
+		return nil, fmt.Errorf("reading block %d: %w", entry.Num, err)
+	}
+	return bstream.NewBlockFromBytes(data)
+}
+
+// BlockRangeIndex and BlockRangeEntry protobuf field numbers
+const (
+	blockRangeIndexEntries protowire.Number = 1
+
+	blockRangeEntryNum        protowire.Number = 1
+	blockRangeEntryID         protowire.Number = 2
+	blockRangeEntryPreviousID protowire.Number = 3
+	blockRangeEntryLibNum     protowire.Number = 4
+	blockRangeEntryOffset     protowire.Number = 5
+	blockRangeEntryLength     protowire.Number = 6
+)
+
+func encodeBlockRangeIndex(entries []BlockRangeEntry) []byte {
+	var out []byte
+	for _, e := range entries {
+		var entry []byte
+		entry = protowire.AppendTag(entry, blockRangeEntryNum, protowire.VarintType)
+		entry = protowire.AppendVarint(entry, e.Num)
+		entry = protowire.AppendTag(entry, blockRangeEntryID, protowire.BytesType)
+		entry = protowire.AppendString(entry, e.ID)
+		entry = protowire.AppendTag(entry, blockRangeEntryPreviousID, protowire.BytesType)
+		entry = protowire.AppendString(entry, e.PreviousID)
+		entry = protowire.AppendTag(entry, blockRangeEntryLibNum, protowire.VarintType)
+		entry = protowire.AppendVarint(entry, e.LibNum)
+		entry = protowire.AppendTag(entry, blockRangeEntryOffset, protowire.VarintType)
+		entry = protowire.AppendVarint(entry, e.Offset)
+		entry = protowire.AppendTag(entry, blockRangeEntryLength, protowire.VarintType)
+		entry = protowire.AppendVarint(entry, e.Length)
+
+		out = protowire.AppendTag(out, blockRangeIndexEntries, protowire.BytesType)
+		out = protowire.AppendBytes(out, entry)
+	}
+	return out
+}
+
+var errInvalidBlockRangeIndex = errors.New("corrupted block-range file: invalid index")
+
+func decodeBlockRangeIndex(data []byte) (entries []BlockRangeEntry, err error) {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, errInvalidBlockRangeIndex
+		}
+		data = data[n:]
+		if num != blockRangeIndexEntries || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, data); n < 0 {
+				return nil, errInvalidBlockRangeIndex
+			}
+			data = data[n:]
+			continue
+		}
+		raw, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, errInvalidBlockRangeIndex
+		}
+		data = data[n:]
+		entry, err := decodeBlockRangeEntry(raw)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func decodeBlockRangeEntry(data []byte) (entry BlockRangeEntry, err error) {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return entry, errInvalidBlockRangeIndex
+		}
+		data = data[n:]
+		switch {
+		case typ == protowire.VarintType && (num == blockRangeEntryNum || num == blockRangeEntryLibNum || num == blockRangeEntryOffset || num == blockRangeEntryLength):
+			var v uint64
+			v, n = protowire.ConsumeVarint(data)
+			switch num {
+			case blockRangeEntryNum:
+				entry.Num = v
+			case blockRangeEntryLibNum:
+				entry.LibNum = v
+			case blockRangeEntryOffset:
+				entry.Offset = v
+			case blockRangeEntryLength:
+				entry.Length = v
+			}
+		case typ == protowire.BytesType && (num == blockRangeEntryID || num == blockRangeEntryPreviousID):
+			var v string
+			v, n = protowire.ConsumeString(data)
+			if num == blockRangeEntryID {
+				entry.ID = v
+			} else {
+				entry.PreviousID = v
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return entry, errInvalidBlockRangeIndex
+		}
+		data = data[n:]
+	}
+	return entry, nil
+}
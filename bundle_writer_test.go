@@ -0,0 +1,55 @@
+package merger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockRangeBundleWriter(t *testing.T) {
+	dbin := `{"id":"0000000000000100a","prev":"0000000000000099a","num":100,"libnum":98}` + "\n" +
+		`{"id":"0000000000000101a","prev":"0000000000000100a","num":101,"libnum":99}` + "\n"
+
+	writer, err := ParseBundleFormat("block-range")
+	require.NoError(t, err)
+	var file bytes.Buffer
+	require.NoError(t, writer.WriteBundle(&file, strings.NewReader(dbin)))
+
+	entries, err := ReadBlockRangeIndex(bytes.NewReader(file.Bytes()), int64(file.Len()))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, uint64(101), entries[1].Num)
+	assert.Equal(t, "0000000000000101a", entries[1].ID)
+	assert.Equal(t, "0000000000000100a", entries[1].PreviousID)
+	assert.Equal(t, uint64(99), entries[1].LibNum)
+
+	block, err := ReadBlockRangeBlock(bytes.NewReader(file.Bytes()), entries[1])
+	require.NoError(t, err)
+	assert.Equal(t, "0000000000000101a", block.ID())
+	payload, err := block.Payload.Get()
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"0000000000000101a","prev":"0000000000000100a","num":101,"libnum":99}`, strings.TrimSpace(string(payload)))
+
+	var read []uint64
+	require.NoError(t, writer.ReadBundle(bytes.NewReader(file.Bytes()), func(block *bstream.Block) error {
+		read = append(read, block.Num())
+		return nil
+	}))
+	assert.Equal(t, []uint64{100, 101}, read)
+
+	_, err = ReadBlockRangeIndex(strings.NewReader(dbin), int64(len(dbin)))
+	assert.Error(t, err, "not a block-range file")
+}
+
+func TestParseBundleFormat(t *testing.T) {
+	writer, err := ParseBundleFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, DBinBundleWriter{}, writer)
+
+	_, err = ParseBundleFormat("parquet")
+	assert.Error(t, err)
+}
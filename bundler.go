@@ -43,10 +43,66 @@ type Bundler struct {
 	stopBlock                  uint64
 	enforceNextBlockOnBoundary bool
 	firstStreamableBlock       uint64
+	// alignmentStartBlock is an unaligned start block forced by the operator, the first bundle then starts there
+	alignmentStartBlock uint64
 
-	seenBlockFiles     map[string]*bstream.OneBlockFile
+	seenBlockFiles map[string]*bstream.OneBlockFile
+	// duplicateFilenames are the filenames of the copies not merged by canonical name, see WithDuplicatePolicy
+	duplicateFilenames map[string]map[string]bool
+	duplicatePolicy    DuplicatePolicy
 	irreversibleBlocks []*bstream.OneBlockFile
+	lib                bstream.BlockRef                 // given to the forkdb on the last Reset, if any
+	placeholders       map[string]*bstream.OneBlockFile // blocks standing for skipped holes by canonical name, see skipHole
 	forkable           *forkable.Forkable
+
+	// irreversibleConfirmations is how many irreversible blocks are required above a boundary before closing its bundle
+	irreversibleConfirmations uint64
+	heldBlocks                []*bstream.OneBlockFile
+
+	// pending are the complete bundles not merged yet, see WithMergeBatching
+	batch   *mergeBatch
+	pending []*pendingBundle
+	// pipeline queues the bundles to merge while the next ones are collected, nil unless WithMergePipelineDepth
+	pipeline *mergePipeline
+	// catchUp merges several queued bundles at once when far behind, nil unless WithCatchUpMerges
+	catchUp *catchUpMerges
+
+	poison *poisonRanges // nil unless WithPoisonRanges
+
+	forkedAge *forkedBlockAge // nil unless WithMaxForkedBlockAge
+
+	trace *bundlerTrace // nil unless TraceDecisions
+
+	// completion decides when the current bundle is complete, lastIrreversibleAt is when its last block became irreversible
+	completion         BundleCompletion
+	lastIrreversibleAt time.Time
+	now                func() time.Time
+
+	forkDBSoftLimit uint64
+
+	// onMerged is called after each successful MergeAndStore, from the merging goroutine
+	onMerged func(lowBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile)
+	// onIndex is called after onMerged with the index of the bundle, see WithIndexWriter
+	onIndex func(index *BundleIndex)
+	// beforeMerge is called before each MergeAndStore, from the merging goroutine
+	beforeMerge func(baseBlockNum uint64) error
+	// onIrreversible is called for every block that becomes irreversible, from the thread that calls HandleBlockFile
+	onIrreversible func(obf *bstream.OneBlockFile)
+
+	// streaming is set when the io streams the one-block files data while merging, it is then not pre-downloaded
+	streaming bool
+	// preDownloads tracks the pre-downloads of the irreversible blocks, they are not tied to a merge
+	preDownloads sync.WaitGroup
+
+	degradedProbeInterval time.Duration
+	onDegraded            func(reason string)
+	degraded              bool // guarded by the bundler lock, the merger stays not ready while set
+	// failedBase is the base of the bundle whose merge failed, the bundles above it are dropped. BaseBlockNum reports
+	// it until Reset, so that the one-block files of the bundles not stored are not pruned. Guarded by the bundler lock.
+	failedBase  *uint64
+	terminating <-chan struct{}
+	// ctx is passed to MergeAndStore, the merger cancels it when the shutdown grace period elapses
+	ctx context.Context
 }
 
 func NewBundler(startBlock, stopBlock, firstStreamableBlock, bundleSize uint64, io IOInterface) *Bundler {
@@ -57,42 +113,130 @@ func NewBundler(startBlock, stopBlock, firstStreamableBlock, bundleSize uint64,
 		firstStreamableBlock: firstStreamableBlock,
 		stopBlock:            stopBlock,
 		seenBlockFiles:       make(map[string]*bstream.OneBlockFile),
+		duplicateFilenames:   make(map[string]map[string]bool),
+		duplicatePolicy:      DuplicateFirstSeen,
+		ctx:                  context.Background(),
+		completion:           CompleteByLIB{},
+		now:                  time.Now,
+	}
+	if streamer, ok := io.(streamingIO); ok {
+		b.streaming = streamer.streamsBundles()
 	}
 	b.Reset(toBaseNum(startBlock, bundleSize), nil)
 	return b
 }
 
-// BaseBlockNum can be called from a different thread
+// BaseBlockNum can be called from a different thread, it is the base of the lowest bundle not stored yet
 func (b *Bundler) BaseBlockNum() uint64 {
-	b.inProcess.Lock()
-	defer b.inProcess.Unlock()
-	// while inProcess is locked, all blocks below b.baseBlockNum are actually merged
+	if b.pipeline == nil {
+		b.inProcess.Lock()
+		defer b.inProcess.Unlock()
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.failedBase != nil {
+		return *b.failedBase
+	}
+	if b.pipeline != nil {
+		// the pipeline is rarely empty while catching up, its oldest bundle is reported instead of waiting for it
+		if base, ok := b.pipeline.oldestBase(); ok {
+			return base
+		}
+	}
+	// while inProcess is locked, all blocks below b.baseBlockNum are actually merged, but the pending bundles
+	if len(b.pending) != 0 {
+		return b.pending[0].baseBlockNum
+	}
 	return b.baseBlockNum
 }
 
 func (b *Bundler) HandleBlockFile(obf *bstream.OneBlockFile) error {
+	if obf.Num < b.alignmentStartBlock {
+		b.trace.event("skip %s", traceBlock(obf))
+		return nil // the operator asked to start later than this block
+	}
+	if b.trace != nil {
+		if _, seen := b.seenBlockFiles[obf.CanonicalName]; seen {
+			b.trace.event("handle %s duplicate", traceBlock(obf))
+		} else {
+			b.trace.event("handle %s", traceBlock(obf))
+		}
+	}
+	held, seen := b.seenBlockFiles[obf.CanonicalName]
+	if seen && !b.handled(obf) {
+		b.handleDuplicate(held, obf)
+	}
+	if seen {
+		obf = held // the copy in the forkdb, see handleDuplicate
+	}
 	b.seenBlockFiles[obf.CanonicalName] = obf
-	return b.forkable.ProcessBlock(obf.ToBstreamBlock(), obf) // forkable will call our own b.ProcessBlock() on irreversible blocks only
+	err := b.forkable.ProcessBlock(obf.ToBstreamBlock(), obf) // forkable will call our own b.ProcessBlock() on irreversible blocks only
+	if err != nil {
+		b.trace.event("error %s: %s", traceBlock(obf), err)
+		if !seen {
+			delete(b.seenBlockFiles, obf.CanonicalName) // not in the forkdb, handled again on the next walk
+		}
+	}
+	return err
+}
+
+// handled tells if the one-block file, under the same filenames, is already held by the bundler, so that a walk can
+// skip it instead of feeding the forkable with it again
+func (b *Bundler) handled(obf *bstream.OneBlockFile) bool {
+	seen, ok := b.seenBlockFiles[obf.CanonicalName]
+	if !ok {
+		return false
+	}
+	for filename := range obf.Filenames {
+		if !seen.Filenames[filename] && !b.duplicateFilenames[obf.CanonicalName][filename] {
+			return false
+		}
+	}
+	return true
 }
 
 func (b *Bundler) forkedBlocksInCurrentBundle() (out []*bstream.OneBlockFile) {
 	highBoundary := b.baseBlockNum + b.bundleSize
 
 	// remove irreversible blocks from map (they will be merged and deleted soon)
+	var irreversibleHeights map[uint64]bool
+	if b.forkedAge != nil {
+		irreversibleHeights = make(map[uint64]bool, len(b.irreversibleBlocks))
+	}
 	for _, block := range b.irreversibleBlocks {
 		delete(b.seenBlockFiles, block.CanonicalName)
+		if irreversibleHeights != nil {
+			irreversibleHeights[block.Num] = true
+		}
 	}
 
 	// identify and then delete remaining blocks from map, return them as forks
+	var purged []*bstream.OneBlockFile
+	now := b.now()
 	for name, block := range b.seenBlockFiles {
+		if block.Num < highBoundary && b.forkedAge.keeps(block, irreversibleHeights, b.baseBlockNum, highBoundary, now) {
+			continue // may belong to a late-arriving canonical chain, see WithMaxForkedBlockAge
+		}
 		if block.Num < b.baseBlockNum {
 			delete(b.seenBlockFiles, name) // too old, just cleaning up the map of lingering old blocks
+			purged = append(purged, block)
 		}
 		if block.Num < highBoundary {
 			out = append(out, block)
 			delete(b.seenBlockFiles, name)
 		}
 	}
+	for name := range b.duplicateFilenames {
+		if _, ok := b.seenBlockFiles[name]; !ok {
+			delete(b.duplicateFilenames, name)
+		}
+	}
+	b.forkedAge.forget(b.seenBlockFiles)
+	if b.trace != nil {
+		for _, block := range sortedByNum(purged) {
+			b.trace.event("purge %s", traceBlock(block))
+		}
+	}
 	return
 }
 
@@ -105,14 +249,26 @@ func (b *Bundler) Reset(nextBase uint64, lib bstream.BlockRef) {
 	if lib != nil {
 		options = append(options, forkable.WithInclusiveLIB(lib))
 		b.enforceNextBlockOnBoundary = false // we don't need to check first block because we know it will be linked to lib
+		b.alignmentStartBlock = 0
 	} else {
 		b.enforceNextBlockOnBoundary = true
 	}
 	b.forkable = forkable.New(b, options...)
+	b.lib = lib
+	b.placeholders = nil
+	if b.trace != nil {
+		libName := "none"
+		if lib != nil {
+			libName = fmt.Sprintf("%d:%s", lib.Num(), lib.ID())
+		}
+		b.trace.event("reset base=%d lib=%s", nextBase, libName)
+	}
 
 	b.Lock()
 	b.baseBlockNum = nextBase
+	b.failedBase = nil
 	b.irreversibleBlocks = nil
+	b.heldBlocks = nil
 	b.Unlock()
 }
 
@@ -131,24 +287,52 @@ func readBlockTime(data []byte) (time.Time, error) {
 
 func (b *Bundler) ProcessBlock(_ *bstream.Block, obj interface{}) error {
 	obf := obj.(bstream.ObjectWrapper).WrappedObject().(*bstream.OneBlockFile)
+	if _, ok := b.placeholders[obf.CanonicalName]; ok {
+		return nil // a skipped hole, there is nothing to merge
+	}
 	if obf.Num < b.baseBlockNum {
 		// we may be receiving an inclusive LIB just before our bundle, ignore it
+		b.trace.event("ignore %s", traceBlock(obf))
 		return nil
 	}
 
+	if b.enforceNextBlockOnBoundary {
+		if b.alignmentStartBlock != 0 {
+			if obf.Num < b.alignmentStartBlock {
+				return nil
+			}
+			if obf.Num == b.alignmentStartBlock {
+				b.enforceNextBlockOnBoundary = false
+				b.alignmentStartBlock = 0 // only the first bundle is shorter, the next ones are on canonical boundaries
+			}
+		}
+	}
 	if b.enforceNextBlockOnBoundary {
 		if obf.Num != b.baseBlockNum && obf.Num != b.firstStreamableBlock {
 			return fmt.Errorf("expecting to start at block %d but got block %d (and we have no previous blockID to align with..). First streamable block is configured to be: %d", b.baseBlockNum, obf.Num, b.firstStreamableBlock)
 		}
 		b.enforceNextBlockOnBoundary = false
 	}
+	if b.onIrreversible != nil {
+		b.onIrreversible(obf)
+	}
 
 	if obf.Num < b.baseBlockNum+b.bundleSize {
 		b.Lock()
-		metrics.AppReadiness.SetReady()
+		if !b.degraded {
+			metrics.AppReadiness.SetReady()
+		}
 		b.irreversibleBlocks = append(b.irreversibleBlocks, obf)
+		b.lastIrreversibleAt = b.now()
+		b.trace.event("irreversible %s", traceBlock(obf))
 		metrics.HeadBlockNumber.SetUint64(obf.Num)
+		if b.streaming {
+			b.Unlock()
+			return nil
+		}
+		b.preDownloads.Add(1)
 		go func() {
+			defer b.preDownloads.Done()
 			// this pre-downloads the data
 			data, err := obf.Data(context.Background(), b.io.DownloadOneBlockFile)
 			if err != nil {
@@ -163,34 +347,62 @@ func (b *Bundler) ProcessBlock(_ *bstream.Block, obj interface{}) error {
 		return nil
 	}
 
+	if !b.completion.Complete(b.bundleState(obf)) {
+		// by default, not enough confirmations above the boundary to close the bundle yet
+		b.Lock()
+		b.heldBlocks = append(b.heldBlocks, obf)
+		b.Unlock()
+		b.trace.event("hold %s", traceBlock(obf))
+		return nil
+	}
+	return b.closeBundle(obf)
+}
+
+// closeBundle merges the current bundle (or queues it, see WithMergeBatching) and starts the next one with the held
+// blocks and `above`, if not nil
+func (b *Bundler) closeBundle(above *bstream.OneBlockFile) error {
 	select {
 	case err := <-b.bundleError:
+		if b.pipeline != nil {
+			b.Lock()
+			b.pipeline.failed = false
+			b.Unlock()
+		}
 		return err
 	default:
 	}
 
-	forkedBlocks := b.forkedBlocksInCurrentBundle()
-	blocksToBundle := b.irreversibleBlocks
-	baseBlockNum := b.baseBlockNum
-	b.inProcess.Lock()
-	go func() {
-		defer b.inProcess.Unlock()
-		if err := b.io.MergeAndStore(context.Background(), baseBlockNum, blocksToBundle); err != nil {
-			b.bundleError <- err
-			return
-		}
-		if forkableIO, ok := b.io.(ForkAwareIOInterface); ok {
-			forkableIO.MoveForkedBlocks(context.Background(), forkedBlocks)
-		}
-		// we do not delete bundled blocks here, they get pruned later. keeping the blocks from the last bundle is useful for bootstrapping
-	}()
+	bundle := &pendingBundle{
+		baseBlockNum: b.baseBlockNum,
+		blocks:       b.irreversibleBlocks,
+		forked:       b.forkedBlocksInCurrentBundle(),
+		completedAt:  b.now(),
+	}
+	if b.trace != nil {
+		b.trace.event("close base=%d blocks=%s forked=%s", bundle.baseBlockNum, traceBlocks(bundle.blocks), traceBlocks(sortedByNum(bundle.forked)))
+	}
+	b.Lock()
+	b.pending = append(b.pending, bundle)
+	pending := len(b.pending)
+	b.Unlock()
+	if b.batch == nil || pending >= b.batch.bundles || (b.stopBlock != 0 && bundle.baseBlockNum+b.bundleSize >= b.stopBlock) {
+		b.flushPendingMerges()
+	}
 
 	b.Lock()
 	// we keep the last block of the bundle, only deleting it on next merge, to facilitate joining to one-block-filled hub
 	lastBlock := b.irreversibleBlocks[len(b.irreversibleBlocks)-1]
-	b.irreversibleBlocks = []*bstream.OneBlockFile{lastBlock, obf}
+	// blocks held for confirmations belong to the next bundle
+	b.irreversibleBlocks = append([]*bstream.OneBlockFile{lastBlock}, b.heldBlocks...)
+	if above != nil {
+		b.irreversibleBlocks = append(b.irreversibleBlocks, above)
+	}
+	b.heldBlocks = nil
 	b.baseBlockNum += b.bundleSize
 	b.Unlock()
+	if b.trace != nil {
+		b.trace.event("open base=%d blocks=%s", b.baseBlockNum, traceBlocks(b.irreversibleBlocks))
+	}
 	if b.stopBlock != 0 && b.baseBlockNum >= b.stopBlock {
 		return ErrStopBlockReached
 	}
@@ -198,6 +410,20 @@ func (b *Bundler) ProcessBlock(_ *bstream.Block, obj interface{}) error {
 	return nil
 }
 
+// knownBlockFiles returns the one-block files of the current bundle by canonical name, it can be called from a different thread
+func (b *Bundler) knownBlockFiles() map[string]*bstream.OneBlockFile {
+	b.Lock()
+	defer b.Unlock()
+	out := make(map[string]*bstream.OneBlockFile, len(b.irreversibleBlocks)+len(b.heldBlocks))
+	for _, obf := range b.irreversibleBlocks {
+		out[obf.CanonicalName] = obf
+	}
+	for _, obf := range b.heldBlocks {
+		out[obf.CanonicalName] = obf
+	}
+	return out
+}
+
 // String can be called from a different thread
 func (b *Bundler) String() string {
 	b.Lock()
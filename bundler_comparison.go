@@ -0,0 +1,186 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// ShadowBundler is an alternative bundler implementation that can be run side by side with the Bundler
+// (see WithShadowBundler). It receives the same one-block files, but its decisions are only compared, never merged.
+type ShadowBundler interface {
+	Reset(nextBase uint64, lib bstream.BlockRef)
+	// HandleBlockFile returns the bundles that this block allowed to close, if any
+	HandleBlockFile(obf *bstream.OneBlockFile) ([]*BundleDecision, error)
+}
+
+// BundleDecision is the content of a bundle, as decided by a bundler
+type BundleDecision struct {
+	BaseBlockNum uint64
+	// BlockIDs are the IDs of the blocks in [BaseBlockNum, BaseBlockNum+bundleSize), in block order
+	BlockIDs []string
+}
+
+func (d *BundleDecision) String() string {
+	if d == nil {
+		return "<none>"
+	}
+	return fmt.Sprintf("%d:%v", d.BaseBlockNum, d.BlockIDs)
+}
+
+type BundleDivergence struct {
+	BaseBlockNum uint64
+	Primary      *BundleDecision
+	Shadow       *BundleDecision
+}
+
+// pendingDecisionsWindow is how many bundles a decision waits for the other bundler's before being dropped
+const pendingDecisionsWindow = 10
+
+// WithShadowBundler runs `shadow` side by side with the bundler and compares their decisions for every merged bundle.
+// Divergences are logged, counted in `merger_bundler_divergences` and passed to `onDivergence` (optional), without affecting output.
+func WithShadowBundler(shadow ShadowBundler, onDivergence func(*BundleDivergence)) Option {
+	return func(m *Merger) {
+		c := &bundleComparator{
+			shadow:       shadow,
+			primary:      make(map[uint64]*BundleDecision),
+			shadowed:     make(map[uint64]*BundleDecision),
+			onDivergence: onDivergence,
+		}
+		onMerged := m.bundler.onMerged
+		m.bundler.onMerged = func(lowBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile) {
+			if onMerged != nil {
+				onMerged(lowBlockNum, oneBlockFiles)
+			}
+			c.recordPrimary(primaryDecision(lowBlockNum, m.bundler.bundleSize, oneBlockFiles))
+		}
+		m.comparator = c
+	}
+}
+
+// primaryDecision drops the last block of the previous bundle, that the Bundler keeps in irreversibleBlocks
+func primaryDecision(lowBlockNum, bundleSize uint64, oneBlockFiles []*bstream.OneBlockFile) *BundleDecision {
+	d := &BundleDecision{BaseBlockNum: lowBlockNum}
+	for _, obf := range oneBlockFiles {
+		if obf.Num >= lowBlockNum && obf.Num < lowBlockNum+bundleSize {
+			d.BlockIDs = append(d.BlockIDs, obf.ID)
+		}
+	}
+	return d
+}
+
+type bundleComparator struct {
+	sync.Mutex
+	logger     *zap.Logger // set by applySettings
+	shadow     ShadowBundler
+	bundleSize uint64 // set by applySettings
+
+	primary      map[uint64]*BundleDecision
+	shadowed     map[uint64]*BundleDecision
+	onDivergence func(*BundleDivergence)
+}
+
+func (c *bundleComparator) reset(nextBase uint64, lib bstream.BlockRef) {
+	c.Lock()
+	defer c.Unlock()
+	c.shadow.Reset(nextBase, lib)
+}
+
+// handleBlockFile feeds the shadow bundler, its errors are only logged
+func (c *bundleComparator) handleBlockFile(obf *bstream.OneBlockFile) {
+	c.Lock()
+	defer c.Unlock()
+	decisions, err := c.shadow.HandleBlockFile(obf)
+	if err != nil {
+		c.logger.Warn("shadow bundler failed handling block file", zap.Stringer("block", obf), zap.Error(err))
+		return
+	}
+	for _, d := range decisions {
+		c.record(d, true)
+	}
+}
+
+func (c *bundleComparator) recordPrimary(d *BundleDecision) {
+	c.Lock()
+	defer c.Unlock()
+	c.record(d, false)
+}
+
+// record compares `d` with the other bundler's decision for the same bundle, or keeps it until that one comes in
+func (c *bundleComparator) record(d *BundleDecision, fromShadow bool) {
+	own, other := c.primary, c.shadowed
+	if fromShadow {
+		own, other = c.shadowed, c.primary
+	}
+
+	counterpart, found := other[d.BaseBlockNum]
+	if !found {
+		own[d.BaseBlockNum] = d
+		c.dropStale(d.BaseBlockNum)
+		return
+	}
+	delete(other, d.BaseBlockNum)
+
+	divergence := &BundleDivergence{BaseBlockNum: d.BaseBlockNum, Primary: d, Shadow: counterpart}
+	if fromShadow {
+		divergence.Primary, divergence.Shadow = counterpart, d
+	}
+	if sameBlockIDs(divergence.Primary.BlockIDs, divergence.Shadow.BlockIDs) {
+		return
+	}
+
+	metrics.BundlerDivergences.Inc()
+	c.logger.Warn("shadow bundler diverged from bundler",
+		zap.Uint64("base_block_num", d.BaseBlockNum),
+		zap.Stringer("primary", divergence.Primary),
+		zap.Stringer("shadow", divergence.Shadow),
+	)
+	if c.onDivergence != nil {
+		c.onDivergence(divergence)
+	}
+}
+
+// dropStale forgets decisions that never got a counterpart, ie. bundles skipped by one side after a reset
+func (c *bundleComparator) dropStale(latestBase uint64) {
+	window := pendingDecisionsWindow * c.bundleSize
+	if latestBase < window {
+		return
+	}
+	for _, decisions := range []map[uint64]*BundleDecision{c.primary, c.shadowed} {
+		for base := range decisions {
+			if base < latestBase-window {
+				c.logger.Debug("dropping unmatched bundle decision", zap.Uint64("base_block_num", base))
+				delete(decisions, base)
+			}
+		}
+	}
+}
+
+func sameBlockIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinearBundler(t *testing.T) {
+	b := NewLinearBundler(2)
+	b.Reset(100, nil)
+
+	var decisions []*BundleDecision
+	for _, blk := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102, block105Final103, block106Final104} {
+		out, err := b.HandleBlockFile(blk)
+		require.NoError(t, err)
+		decisions = append(decisions, out...)
+	}
+
+	assert.Equal(t, []*BundleDecision{
+		{BaseBlockNum: 100, BlockIDs: []string{"0000000000000100a", "0000000000000101a"}},
+		{BaseBlockNum: 102, BlockIDs: []string{"0000000000000102a", "0000000000000103a"}},
+	}, decisions)
+	assert.EqualValues(t, 104, b.baseBlockNum)
+}
+
+func TestLinearBundler_IgnoresForks(t *testing.T) {
+	b := NewLinearBundler(2)
+	b.Reset(100, nil)
+
+	fork101 := bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix")
+	var decisions []*BundleDecision
+	for _, blk := range []*bstream.OneBlockFile{block100, fork101, block101, block102Final100, block103Final101, block104Final102} {
+		out, err := b.HandleBlockFile(blk)
+		require.NoError(t, err)
+		decisions = append(decisions, out...)
+	}
+
+	require.Len(t, decisions, 1)
+	assert.Equal(t, []string{"0000000000000100a", "0000000000000101a"}, decisions[0].BlockIDs)
+}
+
+type testShadowBundler struct {
+	decisions map[uint64]*BundleDecision // returned when receiving the block with this number
+}
+
+func (s *testShadowBundler) Reset(uint64, bstream.BlockRef) {}
+
+func (s *testShadowBundler) HandleBlockFile(obf *bstream.OneBlockFile) ([]*BundleDecision, error) {
+	if d, ok := s.decisions[obf.Num]; ok {
+		return []*BundleDecision{d}, nil
+	}
+	return nil, nil
+}
+
+func TestMerger_ShadowBundlerComparison(t *testing.T) {
+	tests := []struct {
+		name             string
+		shadow           ShadowBundler
+		expectDivergence bool
+	}{
+		{
+			name:   "linear",
+			shadow: NewLinearBundler(2),
+		},
+		{
+			name: "diverging",
+			shadow: &testShadowBundler{decisions: map[uint64]*BundleDecision{
+				104: {BaseBlockNum: 100, BlockIDs: []string{"0000000000000100a"}},
+			}},
+			expectDivergence: true,
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			var divergences []*BundleDivergence
+			m := NewMerger(testLogger, "6969", &TestMergerIO{}, 100, 2, 100, time.Second, time.Second, 0,
+				WithShadowBundler(c.shadow, func(d *BundleDivergence) { divergences = append(divergences, d) }),
+			)
+
+			for _, blk := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102} {
+				require.NoError(t, m.bundler.HandleBlockFile(blk))
+				m.comparator.handleBlockFile(blk)
+			}
+			m.bundler.inProcess.Lock()
+			m.bundler.inProcess.Unlock()
+
+			m.comparator.Lock()
+			defer m.comparator.Unlock()
+			assert.Empty(t, m.comparator.primary)
+			assert.Empty(t, m.comparator.shadowed)
+			if !c.expectDivergence {
+				assert.Empty(t, divergences)
+				return
+			}
+			require.Len(t, divergences, 1)
+			assert.EqualValues(t, 100, divergences[0].BaseBlockNum)
+			assert.Equal(t, []string{"0000000000000100a", "0000000000000101a"}, divergences[0].Primary.BlockIDs)
+		})
+	}
+}
+
+func TestLinearBundler_IrreversibleConfirmations(t *testing.T) {
+	b := NewLinearBundler(2)
+	b.IrreversibleConfirmations = 1
+	b.Reset(100, nil)
+
+	var decisions []*BundleDecision
+	for _, blk := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102} {
+		out, err := b.HandleBlockFile(blk)
+		require.NoError(t, err)
+		decisions = append(decisions, out...)
+	}
+	assert.Empty(t, decisions)
+
+	out, err := b.HandleBlockFile(block105Final103)
+	require.NoError(t, err)
+	require.Len(t, out, 1)
+	assert.EqualValues(t, 100, out[0].BaseBlockNum)
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"github.com/streamingfast/bstream"
+)
+
+// LinearBundler decides bundles without a forkdb: it follows the chain back from the highest block
+// it has seen and closes a bundle as soon as a block above the boundary is irreversible according
+// to that head's LIB. It is meant to be run as a ShadowBundler until it proves equivalent.
+type LinearBundler struct {
+	bundleSize   uint64
+	baseBlockNum uint64
+
+	// IrreversibleConfirmations mirrors WithIrreversibleConfirmations
+	IrreversibleConfirmations uint64
+
+	blocks map[string]*bstream.OneBlockFile // by block ID
+	head   *bstream.OneBlockFile
+}
+
+func NewLinearBundler(bundleSize uint64) *LinearBundler {
+	return &LinearBundler{
+		bundleSize: bundleSize,
+		blocks:     make(map[string]*bstream.OneBlockFile),
+	}
+}
+
+func (b *LinearBundler) Reset(nextBase uint64, _ bstream.BlockRef) {
+	b.baseBlockNum = nextBase
+	b.blocks = make(map[string]*bstream.OneBlockFile)
+	b.head = nil
+}
+
+func (b *LinearBundler) HandleBlockFile(obf *bstream.OneBlockFile) (out []*BundleDecision, err error) {
+	if obf.Num < b.baseBlockNum {
+		return nil, nil
+	}
+	if _, found := b.blocks[obf.ID]; found {
+		return nil, nil
+	}
+	b.blocks[obf.ID] = obf
+	if b.head == nil || obf.Num > b.head.Num {
+		b.head = obf
+	}
+
+	for {
+		decision := b.nextDecision()
+		if decision == nil {
+			return out, nil
+		}
+		out = append(out, decision)
+	}
+}
+
+// nextDecision returns the current bundle if it can be closed, advancing the base block
+func (b *LinearBundler) nextDecision() *BundleDecision {
+	boundary := b.baseBlockNum + b.bundleSize
+	if b.head == nil || b.head.LibNum < boundary+b.IrreversibleConfirmations {
+		return nil
+	}
+
+	var chain []*bstream.OneBlockFile // from head, descending
+	for blk := b.head; blk != nil && blk.Num >= b.baseBlockNum; blk = b.blocks[blk.PreviousID] {
+		chain = append(chain, blk)
+	}
+
+	closed := false
+	decision := &BundleDecision{BaseBlockNum: b.baseBlockNum}
+	for i := len(chain) - 1; i >= 0; i-- {
+		blk := chain[i]
+		if blk.Num < boundary {
+			decision.BlockIDs = append(decision.BlockIDs, blk.ID)
+			continue
+		}
+		if blk.Num >= boundary+b.IrreversibleConfirmations && blk.Num <= b.head.LibNum {
+			closed = true
+			break
+		}
+	}
+	if !closed {
+		return nil
+	}
+
+	for id, blk := range b.blocks {
+		if blk.Num < boundary {
+			delete(b.blocks, id)
+		}
+	}
+	b.baseBlockNum = boundary
+	return decision
+}
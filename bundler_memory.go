@@ -0,0 +1,107 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+)
+
+// oneBlockFileOverhead approximates the size of the OneBlockFile struct, its Filenames map and the forkdb link pointing to it
+const oneBlockFileOverhead = 256
+
+// approxOneBlockFileSize locks `obf`, its data is memoized by the download goroutines
+func approxOneBlockFileSize(obf *bstream.OneBlockFile) uint64 {
+	obf.Lock()
+	dataSize := len(obf.MemoizeData)
+	obf.Unlock()
+
+	size := oneBlockFileOverhead + len(obf.CanonicalName) + len(obf.ID) + len(obf.PreviousID) + dataSize
+	for filename := range obf.Filenames {
+		size += len(filename)
+	}
+	return uint64(size)
+}
+
+// forkDBMemoryUsage approximates the memory held by the blocks known to the forkdb, including their memoized data.
+// It must be called from the thread that calls HandleBlockFile.
+func (b *Bundler) forkDBMemoryUsage() (out uint64) {
+	for _, obf := range b.seenBlockFiles {
+		out += approxOneBlockFileSize(obf)
+	}
+
+	b.Lock()
+	irreversibleBlocks := b.irreversibleBlocks
+	b.Unlock()
+	for _, obf := range irreversibleBlocks {
+		if _, ok := b.seenBlockFiles[obf.CanonicalName]; ok {
+			continue
+		}
+		out += approxOneBlockFileSize(obf)
+	}
+	return out
+}
+
+// checkForkDBMemory updates the memory gauge and, when above the soft limit, purges the fork branches that can no longer
+// become irreversible instead of waiting for the next merge to do it.
+// It must be called from the thread that calls HandleBlockFile.
+func (b *Bundler) checkForkDBMemory() {
+	usage := b.forkDBMemoryUsage()
+	metrics.ForkDBMemoryBytes.SetUint64(usage)
+	if b.forkDBSoftLimit == 0 || usage <= b.forkDBSoftLimit {
+		return
+	}
+
+	purged := b.purgeDeepForks()
+	if len(purged) == 0 {
+		return
+	}
+	metrics.ForkDBPurgedBlocks.AddInt(len(purged))
+	metrics.ForkDBMemoryBytes.SetUint64(b.forkDBMemoryUsage())
+
+	if forkableIO, ok := b.io.(ForkAwareIOInterface); ok {
+		go forkableIO.MoveForkedBlocks(context.Background(), purged)
+	}
+}
+
+// purgeDeepForks removes from the seen blocks every block that is not irreversible but sits at or below the highest
+// irreversible block: those are definitely forked.
+func (b *Bundler) purgeDeepForks() (out []*bstream.OneBlockFile) {
+	b.Lock()
+	if len(b.irreversibleBlocks) == 0 {
+		b.Unlock()
+		return nil
+	}
+	highestIrreversible := b.irreversibleBlocks[len(b.irreversibleBlocks)-1].Num
+	irreversible := make(map[string]bool, len(b.irreversibleBlocks))
+	for _, obf := range b.irreversibleBlocks {
+		irreversible[obf.CanonicalName] = true
+	}
+	b.Unlock()
+
+	for name, obf := range b.seenBlockFiles {
+		if obf.Num > highestIrreversible || irreversible[name] {
+			continue
+		}
+		delete(b.seenBlockFiles, name)
+		obf.Lock()
+		obf.MemoizeData = nil
+		obf.Unlock()
+		out = append(out, obf)
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package merger
+
+import (
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundler_ForkDBMemoryUsage(t *testing.T) {
+	b := NewBundler(100, 0, 2, 100, nil)
+	assert.Zero(t, b.forkDBMemoryUsage())
+
+	withData := bstream.MustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-suffix")
+	withData.MemoizeData = make([]byte, 1000)
+	b.seenBlockFiles[block100.CanonicalName] = block100
+	b.irreversibleBlocks = []*bstream.OneBlockFile{block100, withData}
+
+	expected := approxOneBlockFileSize(block100) + approxOneBlockFileSize(withData)
+	assert.Equal(t, expected, b.forkDBMemoryUsage())
+	assert.True(t, expected > 1000)
+}
+
+func TestBundler_PurgeDeepForks(t *testing.T) {
+	forked101 := bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix")
+	forked103 := bstream.MustNewOneBlockFile("0000000103-0000000000000103b-0000000000000102a-101-suffix")
+
+	b := NewBundler(100, 0, 2, 100, nil)
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, forked101, block102Final100, forked103} {
+		b.seenBlockFiles[obf.CanonicalName] = obf
+	}
+	b.irreversibleBlocks = []*bstream.OneBlockFile{block100, block101, block102Final100}
+
+	purged := b.purgeDeepForks()
+	require.Len(t, purged, 1)
+	assert.Equal(t, forked101, purged[0])
+	assert.Len(t, b.seenBlockFiles, 4)
+	assert.Contains(t, b.seenBlockFiles, forked103.CanonicalName) // may still become irreversible
+}
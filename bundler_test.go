@@ -6,7 +6,7 @@ import (
 
 	"context"
 	"testing"
-	//	"time"
+	"time"
 
 	//	"github.com/streamingfast/bstream"
 	//"github.com/streamingfast/sadia1971/bundle"
@@ -24,8 +24,13 @@ var block104Final102 = bstream.MustNewOneBlockFile("0000000104-0000000000000104a
 var block105Final103 = bstream.MustNewOneBlockFile("0000000105-0000000000000105a-0000000000000104a-103-suffix")
 var block106Final104 = bstream.MustNewOneBlockFile("0000000106-0000000000000106a-0000000000000105a-104-suffix")
 
-func init() {
-	bstream.GetBlockReaderFactory = bstream.TestBlockReaderFactory
+// newTestBundler returns a bundler merging every 2 blocks from block 100, calling `mergeAndStore` for each bundle
+func newTestBundler(mergeAndStore func(inclusiveLowerBlock uint64) error) *Bundler {
+	return NewBundler(100, 0, 100, 2, &TestMergerIO{
+		MergeAndStoreFunc: func(_ context.Context, inclusiveLowerBlock uint64, _ []*bstream.OneBlockFile) error {
+			return mergeAndStore(inclusiveLowerBlock)
+		},
+	})
 }
 
 func TestNewBundler(t *testing.T) {
@@ -135,3 +140,118 @@ func TestBundlerMergeKeepOne(t *testing.T) {
 		})
 	}
 }
+
+func TestBundlerIrreversibleConfirmations(t *testing.T) {
+	var merged []uint64
+	b := NewBundler(100, 200, 2, 2, &TestMergerIO{
+		MergeAndStoreFunc: func(_ context.Context, inclusiveLowerBlock uint64, _ []*bstream.OneBlockFile) (err error) {
+			merged = append(merged, inclusiveLowerBlock)
+			return nil
+		},
+	}) // merge every 2 blocks
+	b.irreversibleConfirmations = 1
+	b.irreversibleBlocks = []*bstream.OneBlockFile{block100, block101}
+
+	for _, blk := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102} {
+		require.NoError(t, b.HandleBlockFile(blk))
+	}
+	b.inProcess.Lock()
+	b.inProcess.Unlock()
+	assert.Empty(t, merged, "LIB 102 is not one block above boundary 102")
+	assert.Equal(t, []*bstream.OneBlockFile{block102Final100}, b.heldBlocks)
+
+	require.NoError(t, b.HandleBlockFile(block105Final103))
+	b.inProcess.Lock()
+	b.inProcess.Unlock()
+	assert.Equal(t, []uint64{100}, merged)
+	assert.Equal(t, []*bstream.OneBlockFile{block101, block102Final100, block103Final101}, b.irreversibleBlocks)
+	assert.Nil(t, b.heldBlocks)
+	assert.EqualValues(t, 102, b.baseBlockNum)
+}
+
+func TestBundlerStartBlockRealignment(t *testing.T) {
+	merged := map[uint64][]*bstream.OneBlockFile{}
+	mio := &TestMergerIO{
+		MergeAndStoreFunc: func(_ context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) (err error) {
+			merged[inclusiveLowerBlock] = oneBlockFiles
+			return nil
+		},
+	}
+	m := NewMerger(testLogger, "", mio, 2, 2, 100, time.Second, time.Second, 0, WithStartBlock(101))
+	b := m.bundler
+	assert.EqualValues(t, 100, b.baseBlockNum)
+
+	for _, blk := range []*bstream.OneBlockFile{block99, block100, block101, block102Final100, block103Final101, block104Final102} {
+		require.NoError(t, b.HandleBlockFile(blk))
+	}
+	b.inProcess.Lock()
+	b.inProcess.Unlock()
+
+	assert.Equal(t, map[uint64][]*bstream.OneBlockFile{100: {block101}}, merged, "shorter alignment bundle")
+	assert.EqualValues(t, 102, b.baseBlockNum)
+	assert.Zero(t, b.alignmentStartBlock)
+}
+
+func TestBundlerHandled(t *testing.T) {
+	b := NewBundler(100, 0, 100, 100, &TestMergerIO{})
+	assert.False(t, b.handled(block100))
+
+	require.NoError(t, b.HandleBlockFile(block100))
+	assert.True(t, b.handled(block100))
+	assert.True(t, b.handled(bstream.MustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-suffix")), "listed again")
+	assert.False(t, b.handled(bstream.MustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-other")), "copy of another reader")
+	assert.False(t, b.handled(block101))
+}
+
+func TestBundlerDuplicatePolicy(t *testing.T) {
+	copies := func() (*bstream.OneBlockFile, *bstream.OneBlockFile, *bstream.OneBlockFile) {
+		return mustNewOneBlockFile("0000000100-20210728T105016.0-0000000000000100a-0000000000000099a-98-backup"),
+			mustNewOneBlockFile("0000000100-20210728T105016.5-0000000000000100a-0000000000000099a-98-canonical"),
+			mustNewOneBlockFile("0000000100-20210728T105015.0-0000000000000100a-0000000000000099a-98-other")
+	}
+
+	tests := []struct {
+		name     string
+		policy   DuplicatePolicy
+		expected string
+	}{
+		{"first seen", DuplicateFirstSeen, "0000000100-20210728T105016.0-0000000000000100a-0000000000000099a-98-backup"},
+		{"newest", DuplicateNewest, "0000000100-20210728T105016.5-0000000000000100a-0000000000000099a-98-canonical"},
+		{"suffixes", DuplicatePreferSuffixes("canonical", "backup"), "0000000100-20210728T105016.5-0000000000000100a-0000000000000099a-98-canonical"},
+		{"suffixes without match", DuplicatePreferSuffixes("unknown"), "0000000100-20210728T105016.0-0000000000000100a-0000000000000099a-98-backup"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewBundler(100, 0, 100, 100, &TestMergerIO{})
+			b.duplicatePolicy = test.policy
+			first, second, third := copies()
+
+			require.NoError(t, b.HandleBlockFile(first))
+			require.NoError(t, b.HandleBlockFile(second))
+			require.NoError(t, b.HandleBlockFile(third))
+
+			held := b.seenBlockFiles[first.CanonicalName]
+			assert.Same(t, first, held, "the copy in the forkdb is kept")
+			assert.Equal(t, map[string]bool{test.expected: true}, held.Filenames)
+			assert.True(t, b.handled(first))
+			assert.True(t, b.handled(second))
+			assert.True(t, b.handled(third))
+		})
+	}
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	policy, err := ParseDuplicatePolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, DuplicateFirstSeen, policy)
+
+	policy, err = ParseDuplicatePolicy("suffixes:canonical,backup")
+	require.NoError(t, err)
+	assert.Equal(t, DuplicatePreferSuffixes("canonical", "backup"), policy)
+	assert.Equal(t, "suffixes:canonical,backup", policy.String())
+
+	_, err = ParseDuplicatePolicy("suffixes:")
+	assert.Error(t, err)
+	_, err = ParseDuplicatePolicy("latest")
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/streamingfast/bstream"
+)
+
+// TraceDecisions writes the decisions of the bundler to `w`, one per line, nil stops tracing. The format is stable,
+// blocks are written `<num>:<id>`:
+//
+//	reset base=<base block> lib=<block|none>
+//	handle <block> [duplicate]           a one-block file given to HandleBlockFile, already seen or not
+//	skip <block>                         below the start block forced by the operator
+//	error <block>: <error>               returned by HandleBlockFile
+//	ignore <block>                       irreversible but below the current bundle
+//	irreversible <block>                 irreversible, added to the current bundle
+//	hold <block>                         irreversible above the bundle, held until the bundle is complete
+//	purge <block>                        a one-block file left below the current bundle, forgotten
+//	close base=<base block> blocks=[<block>...] forked=[<block>...]
+//	open base=<base block> blocks=[<block>...]
+//
+// Forked blocks are sorted by number then id, the other lists are in bundler order.
+func (b *Bundler) TraceDecisions(w io.Writer) {
+	if w == nil {
+		b.trace = nil
+		return
+	}
+	b.trace = &bundlerTrace{w: w}
+}
+
+// bundlerTrace is nil-safe, nil unless TraceDecisions was called
+type bundlerTrace struct {
+	w io.Writer
+}
+
+func (t *bundlerTrace) event(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	fmt.Fprintf(t.w, format+"\n", args...)
+}
+
+func traceBlock(obf *bstream.OneBlockFile) string {
+	return fmt.Sprintf("%d:%s", obf.Num, obf.ID)
+}
+
+func traceBlocks(oneBlockFiles []*bstream.OneBlockFile) string {
+	out := make([]string, len(oneBlockFiles))
+	for i, obf := range oneBlockFiles {
+		out[i] = traceBlock(obf)
+	}
+	return "[" + strings.Join(out, " ") + "]"
+}
+
+func sortedByNum(oneBlockFiles []*bstream.OneBlockFile) []*bstream.OneBlockFile {
+	out := append([]*bstream.OneBlockFile(nil), oneBlockFiles...)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Num != out[j].Num {
+			return out[i].Num < out[j].Num
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// RunBundlerFixture feeds a bundler with the one-block files of `fixture` and writes its decisions to `trace`, see
+// TraceDecisions. Each line of the fixture is a one-block filename, a directive or a `#` comment:
+//
+//	bundle_size <size>       before the first block, 100 by default
+//	start_block <block>      before the first block, 0 by default
+//	reset <base block>       resets the bundler without LIB
+//
+// Merges are not performed. The goldens of the bundler tests (test_data/bundler) use this format, so that forks of
+// the bundler can be checked against them.
+func RunBundlerFixture(fixture io.Reader, trace io.Writer) error {
+	bundleSize, startBlock := uint64(100), uint64(0)
+	var b *Bundler
+	scanner := bufio.NewScanner(fixture)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		switch fields[0] {
+		case "bundle_size", "start_block", "reset":
+			if len(fields) != 2 {
+				return fmt.Errorf("line %d: expected `%s <number>`", line, fields[0])
+			}
+			num, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid number %q: %w", line, fields[1], err)
+			}
+			switch {
+			case fields[0] == "reset" && b == nil:
+				return fmt.Errorf("line %d: reset must come after the first block", line)
+			case fields[0] == "reset":
+				b.Reset(num, nil)
+			case b != nil:
+				return fmt.Errorf("line %d: %s must come before the first block", line, fields[0])
+			case fields[0] == "bundle_size" && num == 0:
+				return fmt.Errorf("line %d: bundle size cannot be 0", line)
+			case fields[0] == "bundle_size":
+				bundleSize = num
+			default:
+				startBlock = num
+			}
+			continue
+		}
+
+		obf, err := newOneBlockFile(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if b == nil {
+			b = NewBundler(startBlock, 0, startBlock, bundleSize, &fixtureIO{})
+			b.TraceDecisions(trace)
+		}
+		_ = b.HandleBlockFile(obf) // traced
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if b != nil {
+		b.inProcess.Lock() // wait for the last merge
+		b.inProcess.Unlock()
+	}
+	return nil
+}
+
+// fixtureIO streams the bundles, so that the bundler does not pre-download the one-block files
+type fixtureIO struct {
+	TestMergerIO
+}
+
+func (fixtureIO) streamsBundles() bool {
+	return true
+}
@@ -0,0 +1,55 @@
+package merger
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGoldens = flag.Bool("update", false, "rewrite the bundler goldens in test_data/bundler")
+
+func TestBundler_Goldens(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("test_data", "bundler", "*.fixture"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".fixture")
+		t.Run(name, func(t *testing.T) {
+			in, err := os.Open(fixture)
+			require.NoError(t, err)
+			defer in.Close()
+
+			trace := &bytes.Buffer{}
+			require.NoError(t, RunBundlerFixture(in, trace))
+
+			golden := strings.TrimSuffix(fixture, ".fixture") + ".golden"
+			if *updateGoldens {
+				require.NoError(t, ioutil.WriteFile(golden, trace.Bytes(), 0644))
+				return
+			}
+			expected, err := ioutil.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), trace.String(), "decisions differ from %s, run with -update if the change is intended", golden)
+		})
+	}
+}
+
+func TestRunBundlerFixture_Errors(t *testing.T) {
+	for _, fixture := range []string{
+		"reset 100",
+		"bundle_size 0",
+		"bundle_size two",
+		"0000000100-100a-99a-98-suffix\nbundle_size 2",
+		"not-a-block",
+	} {
+		assert.Error(t, RunBundlerFixture(strings.NewReader(fixture), ioutil.Discard), fixture)
+	}
+}
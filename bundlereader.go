@@ -18,47 +18,145 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/streamingfast/bstream"
 	"github.com/streamingfast/logging"
 	"go.uber.org/zap"
 )
 
+type oneBlockData struct {
+	name string
+	num  uint64
+	data []byte
+}
+
 type BundleReader struct {
 	ctx              context.Context
+	cancel           context.CancelFunc
 	readBuffer       []byte
 	readBufferOffset int
 	headerPassed     bool
-	oneBlockDataChan chan []byte
+	totalRead        uint64
+	oneBlockDataChan chan *oneBlockData
 	errChan          chan error
 
+	codec       *BlockCodec // of the first one-block file, nil until known
+	transformer BlockTransformer
+	transformed int
+	rewriter    BlockRewriter
+	rewritten   int
+
+	canonicalizeSuffix bool
+	canonicalSuffix    string
+
+	stats *bundleStatsCollector // nil unless bundle stats are enabled
+
+	// collectBlockTimes reads the time of each block going through, into blockNums and blockTimes
+	collectBlockTimes bool
+	blockNums         []uint64
+	blockTimes        []time.Time
+	blockTimesErr     error
+
+	validationPolicies ValidationPolicies
+	lastBlockTime      time.Time
+	lastBlock          *oneBlockData
+
+	budget       *byteBudget // streaming only, released as the blocks are read
+	bufferedSize int
+
 	logger *zap.Logger
 }
 
 func NewBundleReader(ctx context.Context, logger *zap.Logger, tracer logging.Tracer, oneBlockFiles []*bstream.OneBlockFile, oneBlockDownloader bstream.OneBlockDownloaderFunc) *BundleReader {
+	return NewParallelBundleReader(ctx, logger, tracer, oneBlockFiles, oneBlockDownloader, 1)
+}
+
+// NewParallelBundleReader downloads (and memoizes) the data of up to `concurrency` one-block files ahead of the reader,
+// the blocks are still read in order
+func NewParallelBundleReader(ctx context.Context, logger *zap.Logger, tracer logging.Tracer, oneBlockFiles []*bstream.OneBlockFile, oneBlockDownloader bstream.OneBlockDownloaderFunc, concurrency int) *BundleReader {
+	ctx, cancel := context.WithCancel(ctx)
 	r := &BundleReader{
 		ctx:              ctx,
+		cancel:           cancel,
 		logger:           logger,
-		oneBlockDataChan: make(chan []byte, 1),
+		oneBlockDataChan: make(chan *oneBlockData, 1),
 		errChan:          make(chan error, 1),
 	}
-	go r.downloadAll(oneBlockFiles, oneBlockDownloader)
+	go r.readAhead(oneBlockFiles, func(ctx context.Context, obf *bstream.OneBlockFile) ([]byte, error) {
+		return obf.Data(ctx, oneBlockDownloader)
+	}, concurrency)
 	return r
 }
 
-// downloadAll does not work in parallel: for performance, the oneBlockFiles' data should already have been memoized by calling Data() on them.
-func (r *BundleReader) downloadAll(oneBlockFiles []*bstream.OneBlockFile, oneBlockDownloader bstream.OneBlockDownloaderFunc) {
+type fetchedBlock struct {
+	data []byte
+	err  error
+}
+
+// readAhead fetches the data of up to `concurrency` one-block files at once and sends them in order to the reader.
+// With a byte budget, a fetch only starts while some of it is left. The fetches in flight are canceled when a fetch
+// fails or when the reader is closed.
+func (r *BundleReader) readAhead(oneBlockFiles []*bstream.OneBlockFile, fetch func(ctx context.Context, obf *bstream.OneBlockFile) ([]byte, error), concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+
+	results := make([]chan *fetchedBlock, len(oneBlockFiles))
+	for i := range results {
+		results[i] = make(chan *fetchedBlock, 1)
+	}
+
+	go func() {
+		sem := make(chan struct{}, concurrency)
+		for i, obf := range oneBlockFiles {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			if r.budget != nil && r.budget.wait(ctx) != nil {
+				return
+			}
+			go func(obf *bstream.OneBlockFile, out chan *fetchedBlock) {
+				defer func() { <-sem }()
+				data, err := fetch(ctx, obf)
+				if err == nil && r.budget != nil {
+					r.budget.acquire(len(data))
+				}
+				out <- &fetchedBlock{data: data, err: err}
+			}(obf, results[i])
+		}
+	}()
+
 	defer close(r.oneBlockDataChan)
-	for _, oneBlockFile := range oneBlockFiles {
-		data, err := oneBlockFile.Data(r.ctx, oneBlockDownloader)
-		if err != nil {
-			r.errChan <- err
+	for i, obf := range oneBlockFiles {
+		var block *fetchedBlock
+		select {
+		case block = <-results[i]:
+		case <-ctx.Done():
+			return
+		}
+		if block.err != nil {
+			r.errChan <- block.err
+			return
+		}
+		select {
+		case r.oneBlockDataChan <- &oneBlockData{name: obf.CanonicalName, num: obf.Num, data: block.data}:
+		case <-ctx.Done():
 			return
 		}
-		r.oneBlockDataChan <- data
 	}
 }
 
+// Close stops the read-ahead, canceling the downloads in flight
+func (r *BundleReader) Close() error {
+	r.cancel()
+	return nil
+}
+
 func (r *BundleReader) Read(p []byte) (bytesRead int, err error) {
 
 	if r.readBuffer == nil {
@@ -69,7 +167,30 @@ func (r *BundleReader) Read(p []byte) (bytesRead int, err error) {
 			if !ok {
 				return 0, io.EOF
 			}
-			data = d
+			data, err = r.checkCodec(d.name, d.data)
+			if err != nil {
+				return 0, err
+			}
+			data, err = r.canonicalize(d.name, data)
+			if err != nil {
+				return 0, err
+			}
+			data, err = r.rewrite(d.name, data)
+			if err != nil {
+				return 0, err
+			}
+			if r.stats != nil {
+				r.stats.observePayload(d.name, d.data)
+				r.stats.observe(data)
+			}
+			if r.collectBlockTimes {
+				r.collectBlockTime(d)
+			}
+			if err := r.checkTimestampMonotonicity(d); err != nil {
+				return 0, err
+			}
+			r.lastBlock = d
+			r.bufferedSize = len(d.data)
 		case err := <-r.errChan:
 			return 0, err
 		case <-r.ctx.Done():
@@ -95,9 +216,55 @@ func (r *BundleReader) Read(p []byte) (bytesRead int, err error) {
 	// there are still bytes to be read
 	bytesRead = copy(p, r.readBuffer[r.readBufferOffset:])
 	r.readBufferOffset += bytesRead
+	r.totalRead += uint64(bytesRead)
 	if r.readBufferOffset >= len(r.readBuffer) {
 		r.readBuffer = nil
+		if r.budget != nil {
+			r.budget.release(r.bufferedSize)
+		}
 	}
 
 	return bytesRead, nil
 }
+
+func (r *BundleReader) collectBlockTime(d *oneBlockData) {
+	if r.blockTimesErr != nil {
+		return
+	}
+	blockTime, err := readBlockTime(d.data)
+	if err != nil {
+		r.blockTimesErr = fmt.Errorf("reading time of block %s: %w", d.name, err)
+		return
+	}
+	r.blockNums = append(r.blockNums, d.num)
+	r.blockTimes = append(r.blockTimes, blockTime)
+}
+
+// lastBlockTimestamp returns the time of the last block read, nil if it cannot be decoded
+func (r *BundleReader) lastBlockTimestamp() *time.Time {
+	if r.lastBlock == nil {
+		return nil
+	}
+	blockTime, err := readBlockTime(r.lastBlock.data)
+	if err != nil {
+		return nil
+	}
+	return &blockTime
+}
+
+// checkTimestampMonotonicity applies the ValidationTimestampMonotonicity policy to the block going through
+func (r *BundleReader) checkTimestampMonotonicity(d *oneBlockData) error {
+	if r.validationPolicies.policy(ValidationTimestampMonotonicity) == ValidationOff {
+		return nil
+	}
+	blockTime, err := readBlockTime(d.data)
+	if err != nil {
+		return validationFailed(r.logger, r.validationPolicies, ValidationTimestampMonotonicity, fmt.Errorf("reading time of block %s: %w", d.name, err))
+	}
+	previous := r.lastBlockTime
+	r.lastBlockTime = blockTime
+	if blockTime.Before(previous) {
+		return validationFailed(r.logger, r.validationPolicies, ValidationTimestampMonotonicity, fmt.Errorf("block %s time %s is before the previous block time %s", d.name, blockTime, previous))
+	}
+	return nil
+}
@@ -248,3 +248,73 @@ func TestBundleReader_Read_EmptyListOfOneBlockFiles(t *testing.T) {
 	require.Equal(t, read, 0)
 	require.Errorf(t, err, "EOF")
 }
+
+func TestParallelBundleReader_ReadsAheadInOrder(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 0
+	bundle := NewDownloadBundle()
+	content := map[string][]byte{
+		"o1": {0x1, 0x2},
+		"o2": {0x3, 0x4},
+		"o3": {0x5, 0x6},
+	}
+
+	othersStarted := make(chan struct{}, 2)
+	downloadOneBlockFile := func(ctx context.Context, oneBlockFile *bstream.OneBlockFile) ([]byte, error) {
+		if oneBlockFile.CanonicalName != "o1" {
+			othersStarted <- struct{}{}
+			return content[oneBlockFile.CanonicalName], nil
+		}
+		for i := 0; i < 2; i++ {
+			select {
+			case <-othersStarted:
+			case <-time.After(time.Second):
+				return nil, fmt.Errorf("next blocks not downloaded ahead")
+			}
+		}
+		return content["o1"], nil
+	}
+
+	r := NewParallelBundleReader(context.Background(), testLogger, testTracer, bundle, downloadOneBlockFile, 3)
+	out, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x1, 0x2, 0x3, 0x4, 0x5, 0x6}, out)
+}
+
+func TestBundleReader_CancelsReadAhead(t *testing.T) {
+	stalledDownloader := func(started, canceled chan struct{}, failing uint64) bstream.OneBlockDownloaderFunc {
+		return func(ctx context.Context, obf *bstream.OneBlockFile) ([]byte, error) {
+			if obf.Num == failing {
+				<-started // fails once the other download is in flight
+				return nil, fmt.Errorf("download failed")
+			}
+			started <- struct{}{}
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}
+	}
+
+	t.Run("on error", func(t *testing.T) {
+		started, canceled := make(chan struct{}, 1), make(chan struct{})
+		r := NewParallelBundleReader(context.Background(), testLogger, testTracer, testPrefetchFiles(2), stalledDownloader(started, canceled, 100), 2)
+		_, err := r.Read(make([]byte, 4))
+		assert.EqualError(t, err, "download failed")
+		select {
+		case <-canceled:
+		case <-time.After(time.Second):
+			t.Fatal("the download in flight is not canceled")
+		}
+	})
+
+	t.Run("on close", func(t *testing.T) {
+		started, canceled := make(chan struct{}, 1), make(chan struct{})
+		r := NewParallelBundleReader(context.Background(), testLogger, testTracer, testPrefetchFiles(1), stalledDownloader(started, canceled, 0), 2)
+		<-started
+		require.NoError(t, r.Close())
+		select {
+		case <-canceled:
+		case <-time.After(time.Second):
+			t.Fatal("the download in flight is not canceled")
+		}
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SuffixCanonicalization is what becomes of the producer information (the one-block file suffix: reader
+// hostname, fleet, etc.) that some chains also write inside the block data
+type SuffixCanonicalization int
+
+const (
+	// SuffixKeep merges the blocks as produced (default)
+	SuffixKeep SuffixCanonicalization = iota
+	// SuffixStrip removes the producer information from the blocks
+	SuffixStrip
+	// SuffixNormalize replaces the producer information with the same value for all producers
+	SuffixNormalize
+)
+
+func ParseSuffixCanonicalization(in string) (SuffixCanonicalization, error) {
+	switch in {
+	case "", "keep":
+		return SuffixKeep, nil
+	case "strip":
+		return SuffixStrip, nil
+	case "normalize":
+		return SuffixNormalize, nil
+	}
+	return SuffixKeep, fmt.Errorf("invalid suffix canonicalization %q, expected one of keep, strip or normalize", in)
+}
+
+// BlockCanonicalizer rewrites the data of a one-block file, header included, replacing the producer information
+// inside the block with `suffix`, or removing it when `suffix` is empty
+type BlockCanonicalizer func(data []byte, suffix string) ([]byte, error)
+
+var blockCanonicalizers = struct {
+	sync.RWMutex
+	byContentType map[string]BlockCanonicalizer
+}{byContentType: map[string]BlockCanonicalizer{}}
+
+// RegisterBlockCanonicalizer provides the suffix canonicalization of the chain whose blocks have the `contentType`
+// codec. Like the bstream block reader factories, it is meant to be called by the chain-specific binaries.
+func RegisterBlockCanonicalizer(contentType string, canonicalizer BlockCanonicalizer) {
+	blockCanonicalizers.Lock()
+	defer blockCanonicalizers.Unlock()
+	blockCanonicalizers.byContentType[contentType] = canonicalizer
+}
+
+func blockCanonicalizer(contentType string) BlockCanonicalizer {
+	blockCanonicalizers.RLock()
+	defer blockCanonicalizers.RUnlock()
+	return blockCanonicalizers.byContentType[contentType]
+}
+
+// WithSuffixCanonicalization rewrites the blocks being merged with the canonicalizer registered for their chain, so
+// that the bundles written by different operator fleets are byte-identical for the same chain data. Normalized
+// blocks get `normalizedSuffix` (DefaultNormalizedSuffix if empty). Merging fails for the chains without canonicalizer.
+func WithSuffixCanonicalization(canonicalization SuffixCanonicalization, normalizedSuffix string) DStoreIOOption {
+	return func(s *DStoreIO) {
+		switch canonicalization {
+		case SuffixStrip:
+			s.canonicalizeSuffix = true
+			s.canonicalSuffix = ""
+		case SuffixNormalize:
+			if normalizedSuffix == "" {
+				normalizedSuffix = DefaultNormalizedSuffix
+			}
+			s.canonicalizeSuffix = true
+			s.canonicalSuffix = normalizedSuffix
+		default:
+			s.canonicalizeSuffix = false
+		}
+	}
+}
+
+// canonicalize is called by the BundleReader on each one-block file data once its codec is checked. Data without
+// a dbin header is left as is.
+func (r *BundleReader) canonicalize(filename string, data []byte) ([]byte, error) {
+	if !r.canonicalizeSuffix {
+		return data, nil
+	}
+	codec, err := SniffBlockCodec(data)
+	if err != nil {
+		return data, nil
+	}
+	canonicalizer := blockCanonicalizer(codec.ContentType)
+	if canonicalizer == nil {
+		return nil, fmt.Errorf("no block canonicalizer registered for %s blocks, cannot canonicalize one-block file %q", codec.ContentType, filename)
+	}
+	canonical, err := canonicalizer(data, r.canonicalSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("canonicalizing one-block file %q: %w", filename, err)
+	}
+	return canonical, nil
+}
@@ -0,0 +1,68 @@
+package merger
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSuffixCanonicalization(t *testing.T) {
+	for in, expected := range map[string]SuffixCanonicalization{"": SuffixKeep, "keep": SuffixKeep, "strip": SuffixStrip, "normalize": SuffixNormalize} {
+		canonicalization, err := ParseSuffixCanonicalization(in)
+		require.NoError(t, err)
+		assert.Equal(t, expected, canonicalization, in)
+	}
+	_, err := ParseSuffixCanonicalization("lowercase")
+	assert.Error(t, err)
+}
+
+func TestWithSuffixCanonicalization(t *testing.T) {
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), dstore.NewMockStore(nil), nil, 0, 0, 100,
+		WithSuffixCanonicalization(SuffixNormalize, ""),
+	).(*DStoreIO)
+	assert.True(t, mio.canonicalizeSuffix)
+	assert.Equal(t, DefaultNormalizedSuffix, mio.canonicalSuffix)
+
+	mio = NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), dstore.NewMockStore(nil), nil, 0, 0, 100,
+		WithSuffixCanonicalization(SuffixStrip, "ignored"),
+	).(*DStoreIO)
+	assert.True(t, mio.canonicalizeSuffix)
+	assert.Equal(t, "", mio.canonicalSuffix)
+}
+
+func TestBundleReader_CanonicalizesSuffix(t *testing.T) {
+	RegisterBlockCanonicalizer("TST", func(data []byte, suffix string) ([]byte, error) {
+		// the test payload is the block byte followed by the producer suffix
+		return append(dbinData("TST", "01", data[dbinHeaderLen]), suffix...), nil
+	})
+	bundle := func() []*bstream.OneBlockFile {
+		bstream.GetBlockWriterHeaderLen = dbinHeaderLen
+		return []*bstream.OneBlockFile{
+			{CanonicalName: "o1", MemoizeData: append(dbinData("TST", "01", 0x1), "fleet-a"...)},
+			{CanonicalName: "o2", MemoizeData: append(dbinData("TST", "01", 0x2), "fleet-b"...)},
+		}
+	}
+
+	r := NewBundleReader(context.Background(), testLogger, testTracer, bundle(), nil)
+	r.canonicalizeSuffix = true
+	r.canonicalSuffix = "ops"
+	content, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, append(append(dbinData("TST", "01", 0x1), "ops"...), append([]byte{0x2}, "ops"...)...), content)
+
+	r = NewBundleReader(context.Background(), testLogger, testTracer, bundle(), nil)
+	r.canonicalizeSuffix = true
+	content, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, dbinData("TST", "01", 0x1, 0x2), content)
+
+	r = NewBundleReader(context.Background(), testLogger, testTracer, codecTestBundle()[:1], nil)
+	r.canonicalizeSuffix = true
+	_, err = ioutil.ReadAll(r)
+	assert.Error(t, err, "no canonicalizer registered for ETH blocks")
+}
@@ -0,0 +1,213 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+const (
+	CaptureOpNextBundle   = "next_bundle"
+	CaptureOpWalk         = "walk"
+	CaptureOpMerge        = "merge"
+	CaptureOpDownload     = "download"
+	CaptureOpDelete       = "delete"
+	CaptureOpMoveForked   = "move_forked"
+	CaptureOpDeleteForked = "delete_forked"
+)
+
+// CaptureEvent is one call of the merger to its IO, written as a JSON line by WithCapture. Downloads only keep the
+// size and sha256 of the payload.
+type CaptureEvent struct {
+	Cycle int       `json:"cycle"` // incremented on each NextBundle, that starts a cycle
+	Time  time.Time `json:"time"`
+	Op    string    `json:"op"`
+
+	Block     uint64   `json:"block,omitempty"`      // lowest base block, inclusive lower block of a walk, base block of a merge
+	HighBlock uint64   `json:"high_block,omitempty"` // inclusive high boundary of the forked blocks deletion
+	Files     []string `json:"files,omitempty"`      // one-block files walked, merged, downloaded, deleted or moved
+
+	BaseBlock uint64 `json:"base_block,omitempty"`
+	LIBNum    uint64 `json:"lib_num,omitempty"`
+	LIBID     string `json:"lib_id,omitempty"`
+	Size      int    `json:"size,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	Error     string `json:"error,omitempty"` // error of the store, never the one returned by a walk callback
+}
+
+// WithCapture records every call of the merger to its IO in `w`, as JSON lines, so that the cycles can be replayed
+// with Replay. The optional IO capabilities (resumable walks, retention, merge intents...) are not available while
+// capturing: the walks always start at the bundle base. Forked blocks are still moved and deleted when the IO supports it,
+// the decision to do so is captured either way.
+func WithCapture(w io.Writer) Option {
+	return func(m *Merger) {
+		capture := &captureIO{
+			IOInterface: m.io,
+			writer:      bufio.NewWriter(w),
+			now:         func() time.Time { return m.clock.Now() },
+		}
+		capture.encoder = json.NewEncoder(capture.writer)
+		m.io = capture
+		m.bundler.io = capture
+	}
+}
+
+type captureIO struct {
+	IOInterface
+
+	sync.Mutex
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	now     func() time.Time
+	logger  *zap.Logger // set by applySettings
+	cycle   int
+	failed  bool
+}
+
+func (c *captureIO) record(event *CaptureEvent) {
+	c.Lock()
+	defer c.Unlock()
+	event.Cycle = c.cycle
+	event.Time = c.now()
+	err := c.encoder.Encode(event)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	if err != nil && !c.failed {
+		c.failed = true // logged once, the capture is incomplete anyway
+		c.logger.Warn("cannot write capture, replaying it will diverge", zap.Error(err))
+	}
+}
+
+func (c *captureIO) NextBundle(ctx context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+	c.Lock()
+	c.cycle++
+	c.Unlock()
+
+	baseBlock, lib, err := c.IOInterface.NextBundle(ctx, lowestBaseBlock)
+	event := &CaptureEvent{Op: CaptureOpNextBundle, Block: lowestBaseBlock, BaseBlock: baseBlock, Error: errorString(err)}
+	if lib != nil {
+		event.LIBNum, event.LIBID = lib.Num(), lib.ID()
+	}
+	c.record(event)
+	return baseBlock, lib, err
+}
+
+func (c *captureIO) WalkOneBlockFiles(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+	event := &CaptureEvent{Op: CaptureOpWalk, Block: inclusiveLowerBlock}
+	var callbackErr error
+	err := c.IOInterface.WalkOneBlockFiles(ctx, inclusiveLowerBlock, func(obf *bstream.OneBlockFile) error {
+		event.Files = append(event.Files, captureFileName(obf))
+		callbackErr = callback(obf)
+		return callbackErr
+	})
+	if err != nil && err != callbackErr {
+		event.Error = err.Error()
+	}
+	c.record(event)
+	return err
+}
+
+func (c *captureIO) MergeAndStore(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+	err := c.IOInterface.MergeAndStore(ctx, inclusiveLowerBlock, oneBlockFiles)
+	c.record(&CaptureEvent{Op: CaptureOpMerge, Block: inclusiveLowerBlock, Files: captureFileNames(oneBlockFiles), Error: errorString(err)})
+	return err
+}
+
+func (c *captureIO) DownloadOneBlockFile(ctx context.Context, oneBlockFile *bstream.OneBlockFile) ([]byte, error) {
+	data, err := c.IOInterface.DownloadOneBlockFile(ctx, oneBlockFile)
+	event := &CaptureEvent{Op: CaptureOpDownload, Files: []string{captureFileName(oneBlockFile)}, Size: len(data), Error: errorString(err)}
+	if err == nil {
+		sum := sha256.Sum256(data)
+		event.SHA256 = hex.EncodeToString(sum[:])
+	}
+	c.record(event)
+	return data, err
+}
+
+func (c *captureIO) DeleteAsync(oneBlockFiles []*bstream.OneBlockFile) error {
+	err := c.IOInterface.DeleteAsync(oneBlockFiles)
+	c.record(&CaptureEvent{Op: CaptureOpDelete, Files: captureFileNames(oneBlockFiles), Error: errorString(err)})
+	return err
+}
+
+func (c *captureIO) DeleteForkedBlocksAsync(inclusiveLowBoundary, inclusiveHighBoundary uint64) {
+	if forkableIO, ok := c.IOInterface.(ForkAwareIOInterface); ok {
+		forkableIO.DeleteForkedBlocksAsync(inclusiveLowBoundary, inclusiveHighBoundary)
+	}
+	c.record(&CaptureEvent{Op: CaptureOpDeleteForked, Block: inclusiveLowBoundary, HighBlock: inclusiveHighBoundary})
+}
+
+func (c *captureIO) MoveForkedBlocks(ctx context.Context, oneBlockFiles []*bstream.OneBlockFile) {
+	if forkableIO, ok := c.IOInterface.(ForkAwareIOInterface); ok {
+		forkableIO.MoveForkedBlocks(ctx, oneBlockFiles)
+	}
+	c.record(&CaptureEvent{Op: CaptureOpMoveForked, Files: captureFileNames(oneBlockFiles)})
+}
+
+// ReadCapture reads the events written by WithCapture
+func ReadCapture(r io.Reader) (events []*CaptureEvent, err error) {
+	decoder := json.NewDecoder(r)
+	for {
+		event := &CaptureEvent{}
+		if err := decoder.Decode(event); err != nil {
+			if err == io.EOF {
+				return events, nil
+			}
+			return nil, fmt.Errorf("reading capture event %d: %w", len(events), err)
+		}
+		events = append(events, event)
+	}
+}
+
+// captureFileName is the first of the filenames of `obf`, the walks yield one file per one-block file
+func captureFileName(obf *bstream.OneBlockFile) string {
+	var names []string
+	for name := range obf.Filenames {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return obf.CanonicalName
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+func captureFileNames(oneBlockFiles []*bstream.OneBlockFile) []string {
+	names := make([]string, 0, len(oneBlockFiles))
+	for _, obf := range oneBlockFiles {
+		names = append(names, captureFileName(obf))
+	}
+	return names
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,94 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func captureTestRun(t *testing.T) []*CaptureEvent {
+	t.Helper()
+	walks := [][]string{
+		{
+			"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+			"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+			"0000000102-0000000000000102a-0000000000000101a-100-suffix",
+		},
+		{
+			"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+			"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+			"0000000102-0000000000000102a-0000000000000101a-100-suffix",
+			"0000000103-0000000000000103a-0000000000000102a-101-suffix",
+			"0000000104-0000000000000104a-0000000000000103a-102-suffix",
+		},
+	}
+	var m *Merger
+	var cycle int
+	mio := &TestMergerIO{
+		NextBundleFunc: func(ctx context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+			cycle++
+			if cycle > len(walks) {
+				m.Shutdown(nil)
+			}
+			return lowestBaseBlock, nil, nil
+		},
+		WalkOneBlockFilesFunc: func(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+			if cycle > len(walks) {
+				return nil
+			}
+			for _, name := range walks[cycle-1] {
+				if err := callback(mustNewOneBlockFile(name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	var capture bytes.Buffer
+	m = NewMerger(testLogger, "", mio, 100, 2, 2, time.Second, time.Millisecond, 0, WithCapture(&capture))
+	require.NoError(t, m.run(context.Background()))
+
+	events, err := ReadCapture(&capture)
+	require.NoError(t, err)
+	return events
+}
+
+func TestReplay(t *testing.T) {
+	events := captureTestRun(t)
+
+	report, err := Replay(testLogger, events, 100, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Cycles)
+	assert.Empty(t, report.Divergences)
+	require.Len(t, report.Replayed, 2)
+	assert.Equal(t, CaptureOpMerge, report.Replayed[0].Op)
+	assert.Equal(t, uint64(100), report.Replayed[0].Block)
+	assert.Equal(t, []string{
+		"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+		"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+	}, report.Replayed[0].Files)
+	assert.Equal(t, CaptureOpMoveForked, report.Replayed[1].Op)
+}
+
+func TestReplayDivergence(t *testing.T) {
+	events := captureTestRun(t)
+	for _, event := range events {
+		if event.Op == CaptureOpWalk && event.Cycle == 2 {
+			event.Files = event.Files[:3] // the bundle does not close without the blocks confirming 102
+		}
+	}
+
+	report, err := Replay(testLogger, events, 100, 2)
+	require.NoError(t, err)
+	assert.Empty(t, report.Replayed)
+	require.Len(t, report.Divergences, 3)
+	assert.Contains(t, report.Divergences[0], "next bundle from block 100, captured from block 102")
+	assert.Contains(t, report.Divergences[1], "captured merge of block 100 not replayed")
+	assert.Contains(t, report.Divergences[2], "captured move_forked of block 0 not replayed")
+}
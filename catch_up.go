@@ -0,0 +1,97 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sync"
+
+	"github.com/sadiq1971/merger/metrics"
+)
+
+// WithCatchUpMerges merges up to `parallelism` bundles at the same time when the merger is far behind: once at least
+// `minBacklog` closed bundles wait in the merge pipeline, the bundler closing them faster than they are uploaded.
+// Each bundle is still written by its own MergeAndStore, and the merged callbacks (pruning, watermark, state) still
+// run in the order of the bundles. After a failed merge, the bundles written after it are reported merged too, but the
+// bundler base and the merged watermark stay at the failed one, the others are dropped. It enlarges
+// the merge pipeline (see WithMergePipelineDepth) to `minBacklog` + `parallelism` bundles when it is smaller. A parallelism of 1 or less disables it.
+func WithCatchUpMerges(parallelism, minBacklog int) Option {
+	return func(m *Merger) {
+		if parallelism <= 1 {
+			m.settings.catchUp = nil
+			return
+		}
+		if minBacklog < 2 {
+			minBacklog = 2
+		}
+		m.settings.catchUp = &catchUpMerges{parallelism: parallelism, minBacklog: minBacklog}
+	}
+}
+
+type catchUpMerges struct {
+	parallelism int
+	minBacklog  int
+}
+
+// batch returns how many of the `queued` bundles are merged at once
+func (c *catchUpMerges) batch(queued int) int {
+	if c == nil || queued < c.minBacklog {
+		return 1
+	}
+	if queued > c.parallelism {
+		return c.parallelism
+	}
+	return queued
+}
+
+// mergeBundles writes `bundles` in parallel then runs their callbacks in order. It returns how many of them are merged
+// before the first failed one, see mergeFailed.
+func (b *Bundler) mergeBundles(bundles []*pendingBundle) (int, error) {
+	if len(bundles) == 1 {
+		if err := b.mergeBundle(bundles[0]); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+	metrics.CatchUpParallelMerges.SetFloat64(float64(len(bundles)))
+	defer metrics.CatchUpParallelMerges.SetFloat64(0)
+
+	stored := make([]bool, len(bundles))
+	errs := make([]error, len(bundles))
+	var wg sync.WaitGroup
+	for i, bundle := range bundles {
+		wg.Add(1)
+		go func(i int, bundle *pendingBundle) {
+			defer wg.Done()
+			stored[i], errs[i] = b.storeBundle(bundle)
+		}(i, bundle)
+	}
+	wg.Wait()
+
+	for i, bundle := range bundles {
+		if errs[i] != nil {
+			b.mergeFailed(bundle.baseBlockNum)
+			for j := i + 1; j < len(bundles); j++ {
+				if stored[j] {
+					b.bundleMerged(bundles[j]) // its merged file is written, the failed one stays the base
+				}
+			}
+			return i, errs[i]
+		}
+		if stored[i] {
+			b.bundleMerged(bundle)
+		}
+	}
+	return len(bundles), nil
+}
@@ -0,0 +1,67 @@
+package merger
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundler_CatchUpMerges(t *testing.T) {
+	var lock sync.Mutex
+	var inFlight, maxInFlight int
+	b := newTestBundler(func(uint64) error {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		return nil
+	})
+	b.catchUp = &catchUpMerges{parallelism: 2, minBacklog: 2}
+	b.pipeline = &mergePipeline{slots: make(chan struct{}, 4)}
+	var merged []uint64
+	b.onMerged = func(lowBlockNum uint64, _ []*bstream.OneBlockFile) {
+		merged = append(merged, lowBlockNum) // called in order, from the single pipeline worker
+	}
+
+	block107Final105 := bstream.MustNewOneBlockFile("0000000107-0000000000000107a-0000000000000106a-105-suffix")
+	block108Final106 := bstream.MustNewOneBlockFile("0000000108-0000000000000108a-0000000000000107a-106-suffix")
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102, block105Final103, block106Final104, block107Final105, block108Final106} {
+		require.NoError(t, b.HandleBlockFile(obf))
+	}
+	b.inProcess.Lock()
+	b.inProcess.Unlock()
+
+	assert.Equal(t, []uint64{100, 102, 104}, merged)
+	assert.Equal(t, 2, maxInFlight)
+}
+
+func TestBundler_CatchUpMergesFailure(t *testing.T) {
+	b := newTestBundler(func(inclusiveLowerBlock uint64) error {
+		if inclusiveLowerBlock == 100 {
+			return fmt.Errorf("upload failed")
+		}
+		return nil
+	})
+	var merged []uint64
+	b.onMerged = func(lowBlockNum uint64, _ []*bstream.OneBlockFile) {
+		merged = append(merged, lowBlockNum)
+	}
+
+	done, err := b.mergeBundles([]*pendingBundle{{baseBlockNum: 100}, {baseBlockNum: 102}})
+	assert.EqualError(t, err, "merging bundle 100: upload failed")
+	assert.Equal(t, 0, done)
+	assert.Equal(t, []uint64{102}, merged, "the bundle written after the failed one is reported merged")
+	assert.EqualValues(t, 100, b.BaseBlockNum(), "the failed bundle stays the base")
+	assert.True(t, b.mergedAboveFailure(102))
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// ChainState tells apart a chain that stopped producing blocks from readers that stopped uploading them
+type ChainState string
+
+const (
+	ChainStateProducing      ChainState = "producing"
+	ChainStateIdle           ChainState = "idle"            // readers agree on a head that did not move: the chain halted
+	ChainStateReadersStalled ChainState = "readers_stalled" // the head did not move and readers disagree on it
+)
+
+// WithChainHaltDetection considers that no block was produced when the head did not move for `missedBlocks`
+// times the `expectedBlockInterval`. If at least `quorum` readers (one-block file suffixes) stopped at that
+// same head, the chain halted and the merger is idle: it stays healthy and `merger_chain_idle` is set, so that
+// staleness alerts can be silenced. Otherwise the readers are considered broken: `merger_readers_stalled` is
+// set and the merger reports NOT_SERVING. An `expectedBlockInterval` of 0 disables the detection.
+func WithChainHaltDetection(expectedBlockInterval time.Duration, missedBlocks uint64, quorum int) Option {
+	return func(m *Merger) {
+		if expectedBlockInterval == 0 {
+			m.chainHalt = nil
+			return
+		}
+		if missedBlocks == 0 {
+			missedBlocks = 1
+		}
+		if quorum < 1 {
+			quorum = 1
+		}
+		m.chainHalt = &chainHaltDetector{
+			threshold: expectedBlockInterval * time.Duration(missedBlocks),
+			quorum:    quorum,
+			state:     ChainStateProducing,
+		}
+	}
+}
+
+type chainHaltDetector struct {
+	sync.Mutex
+	threshold time.Duration
+	quorum    int
+	state     ChainState
+}
+
+// evaluate is called after each walk of the one-block files, it only logs state transitions
+func (d *chainHaltDetector) evaluate(readers []ReaderLiveness, now time.Time, logger *zap.Logger) {
+	d.Lock()
+	defer d.Unlock()
+
+	var head ReaderLiveness
+	for _, reader := range readers {
+		if reader.HeadBlockNum > head.HeadBlockNum || (reader.HeadBlockNum == head.HeadBlockNum && reader.HeadSeenAt.After(head.HeadSeenAt)) {
+			head = reader
+		}
+	}
+
+	state := ChainStateProducing
+	if len(readers) != 0 && now.Sub(head.HeadSeenAt) >= d.threshold {
+		atHead := 0
+		for _, reader := range readers {
+			if reader.HeadBlockNum == head.HeadBlockNum {
+				atHead++
+			}
+		}
+		state = ChainStateReadersStalled
+		if atHead >= d.quorum {
+			state = ChainStateIdle
+		}
+	}
+	if state == d.state {
+		return
+	}
+
+	fields := []zap.Field{zap.Uint64("head_block_num", head.HeadBlockNum), zap.Time("head_seen_at", head.HeadSeenAt)}
+	switch state {
+	case ChainStateProducing:
+		logger.Info("blocks are produced again", append(fields, zap.String("previous_state", string(d.state)))...)
+	case ChainStateIdle:
+		logger.Info("chain halted, no block produced, merger is idle", fields...)
+	case ChainStateReadersStalled:
+		logger.Warn("readers stopped uploading one-block files", fields...)
+	}
+	d.state = state
+
+	metrics.ChainIdle.SetFloat64(boolToFloat(state == ChainStateIdle))
+	metrics.ReadersStalled.SetFloat64(boolToFloat(state == ChainStateReadersStalled))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ChainState returns ChainStateProducing unless chain halt detection is enabled and the head stopped moving
+func (m *Merger) ChainState() ChainState {
+	if m.chainHalt == nil {
+		return ChainStateProducing
+	}
+	m.chainHalt.Lock()
+	defer m.chainHalt.Unlock()
+	return m.chainHalt.state
+}
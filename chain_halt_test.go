@@ -0,0 +1,39 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestChainHaltDetection(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithChainHaltDetection(time.Second, 3, 2))
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m.readers.observe(mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-reader1"), t0)
+	m.readers.observe(mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-reader2"), t0)
+
+	m.chainHalt.evaluate(m.ReadersLiveness(), t0.Add(2*time.Second), testLogger)
+	assert.Equal(t, ChainStateProducing, m.ChainState())
+
+	m.chainHalt.evaluate(m.ReadersLiveness(), t0.Add(3*time.Second), testLogger)
+	assert.Equal(t, ChainStateIdle, m.ChainState())
+	assert.Equal(t, pbhealth.HealthCheckResponse_SERVING, m.healthStatus())
+
+	m.readers.observe(mustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-reader1"), t0.Add(4*time.Second))
+	m.chainHalt.evaluate(m.ReadersLiveness(), t0.Add(4*time.Second), testLogger)
+	assert.Equal(t, ChainStateProducing, m.ChainState())
+
+	// reader1 is alone on the head that stopped moving
+	m.chainHalt.evaluate(m.ReadersLiveness(), t0.Add(10*time.Second), testLogger)
+	assert.Equal(t, ChainStateReadersStalled, m.ChainState())
+	assert.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, m.healthStatus())
+}
+
+func TestChainHaltDetectionDisabled(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	assert.Nil(t, m.chainHalt)
+	assert.Equal(t, ChainStateProducing, m.ChainState())
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// ChecksumPolicy is what happens when the data of a one-block file does not match its checksum, see WithChecksums
+type ChecksumPolicy string
+
+const (
+	// ChecksumWarn logs and counts the mismatch, the data is merged anyway
+	ChecksumWarn ChecksumPolicy = "warn"
+	// ChecksumSkip ignores the corrupted copy and uses another copy of the block (another suffix), the merge fails
+	// when no copy matches and is attempted again on the next cycle
+	ChecksumSkip ChecksumPolicy = "skip"
+	// ChecksumFail fails the merge on the first corrupted copy
+	ChecksumFail ChecksumPolicy = "fail"
+)
+
+func ParseChecksumPolicy(in string) (ChecksumPolicy, error) {
+	switch policy := ChecksumPolicy(in); policy {
+	case ChecksumWarn, ChecksumSkip, ChecksumFail:
+		return policy, nil
+	case "":
+		return ChecksumSkip, nil
+	}
+	return "", fmt.Errorf("invalid checksum policy %q, expecting one of: warn, skip, fail", in)
+}
+
+// ChecksumMismatchError is returned when the data of a one-block file does not match its checksum
+type ChecksumMismatchError struct {
+	Filename string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("one-block file %q does not match its checksum, truncated or corrupted upload: expected sha256 %s, got %s", e.Filename, e.Expected, e.Actual)
+}
+
+// WithChecksums verifies the data of each downloaded one-block file against the sha256 written by its producer in
+// `<filename>.sha256` (hex, optionally followed by other fields like the sha256sum output) in `store`, a store apart
+// from the one-block files store whose listing only expects one-block files. A one-block file without checksum is
+// merged as is. The outcomes are counted in `merger_one_block_checksums`.
+func WithChecksums(store dstore.Store, policy ChecksumPolicy) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.checksumStore = store
+		s.checksumPolicy = policy
+	}
+}
+
+func checksumFilename(filename string) string {
+	return filename + ".sha256"
+}
+
+// verifyChecksum returns a ChecksumMismatchError if `data` is not what the producer of `filename` wrote, unless the
+// checksum policy only warns
+func (s *DStoreIO) verifyChecksum(ctx context.Context, filename string, data []byte) error {
+	if s.checksumStore == nil {
+		return nil
+	}
+	expected, err := s.readChecksum(ctx, filename)
+	if errors.Is(err, dstore.ErrNotFound) {
+		metrics.OneBlockChecksums.Inc("missing")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading checksum of one-block file %q: %w", filename, err)
+	}
+	actual := payloadHash(data)
+	if actual == expected {
+		metrics.OneBlockChecksums.Inc("valid")
+		return nil
+	}
+	metrics.OneBlockChecksums.Inc("mismatch")
+	mismatch := &ChecksumMismatchError{Filename: filename, Expected: expected, Actual: actual}
+	if s.checksumPolicy == ChecksumWarn {
+		s.logger.Warn("one-block file checksum mismatch, merging it anyway", zap.Error(mismatch))
+		return nil
+	}
+	return mismatch
+}
+
+func (s *DStoreIO) readChecksum(ctx context.Context, filename string) (string, error) {
+	reader, err := s.checksumStore.OpenObject(ctx, checksumFilename(filename))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum file")
+	}
+	return strings.ToLower(fields[0]), nil
+}
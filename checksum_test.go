@@ -0,0 +1,75 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChecksumPolicy(t *testing.T) {
+	for in, expected := range map[string]ChecksumPolicy{"": ChecksumSkip, "warn": ChecksumWarn, "skip": ChecksumSkip, "fail": ChecksumFail} {
+		policy, err := ParseChecksumPolicy(in)
+		require.NoError(t, err)
+		assert.Equal(t, expected, policy, in)
+	}
+	_, err := ParseChecksumPolicy("ignore")
+	assert.Error(t, err)
+}
+
+func TestDStoreIO_DownloadVerifiesChecksums(t *testing.T) {
+	const truncated = "0000000100-0000000000000100a-0000000000000099a-98-truncated"
+	const complete = "0000000100-0000000000000100a-0000000000000099a-98-complete"
+	const unchecked = "0000000100-0000000000000100a-0000000000000099a-98-unchecked"
+	payload := []byte("complete block payload")
+
+	oneBlocksStore := dstore.NewMockStore(nil)
+	oneBlocksStore.SetFile(truncated, payload[:8])
+	oneBlocksStore.SetFile(complete, payload)
+	oneBlocksStore.SetFile(unchecked, payload[:8])
+	checksums := map[string]string{
+		checksumFilename(truncated): payloadHash(payload) + "  " + truncated + "\n",
+		checksumFilename(complete):  payloadHash(payload) + "\n",
+	}
+	checksumStore := dstore.NewMockStore(nil)
+	checksumStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		checksum, ok := checksums[name]
+		if !ok {
+			return nil, dstore.ErrNotFound
+		}
+		return ioutil.NopCloser(bytes.NewBufferString(checksum)), nil
+	}
+	download := func(policy ChecksumPolicy, filenames ...string) ([]byte, error) {
+		mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithChecksums(checksumStore, policy)).(*DStoreIO)
+		obf := mustNewOneBlockFile(filenames[0])
+		for _, filename := range filenames[1:] {
+			obf.Filenames[filename] = true
+		}
+		return mio.DownloadOneBlockFile(context.Background(), obf)
+	}
+
+	data, err := download(ChecksumSkip, truncated, complete)
+	require.NoError(t, err)
+	assert.Equal(t, payload, data, "the corrupted copy is skipped")
+
+	_, err = download(ChecksumSkip, truncated)
+	var mismatch *ChecksumMismatchError
+	assert.ErrorAs(t, err, &mismatch)
+
+	_, err = download(ChecksumFail, truncated)
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, truncated, mismatch.Filename)
+
+	data, err = download(ChecksumWarn, truncated)
+	require.NoError(t, err)
+	assert.Equal(t, payload[:8], data)
+
+	data, err = download(ChecksumFail, unchecked)
+	require.NoError(t, err)
+	assert.Equal(t, payload[:8], data, "no checksum to verify")
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+)
+
+// DefaultBundleSize is used by EstimateCleanup when the IO does not tell its bundle size
+var DefaultBundleSize uint64 = 100
+
+// CleanupEstimateRangeSize is the size of the block ranges in the breakdown of a CleanupEstimate
+var CleanupEstimateRangeSize uint64 = 100000
+
+// cleanupAgeBuckets are the upper bounds of the age breakdown of a CleanupEstimate, files without a timestamp in
+// their name have an unknown age
+var cleanupAgeBuckets = []struct {
+	label string
+	max   time.Duration
+}{
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"older", 0},
+}
+
+type CleanupRangeEstimate struct {
+	InclusiveLowBlock  uint64 `json:"inclusive_low_block"`
+	ExclusiveHighBlock uint64 `json:"exclusive_high_block"`
+	Files              int    `json:"files"`
+	CoveredFiles       int    `json:"covered_files"`
+}
+
+type CleanupAgeEstimate struct {
+	// MaxAge is the upper bound of the bucket ("1h", "1d", "7d", "30d"), "older" or "unknown"
+	MaxAge       string `json:"max_age"`
+	Files        int    `json:"files"`
+	CoveredFiles int    `json:"covered_files"`
+}
+
+// CleanupEstimate tells how many one-block files are already covered by a merged bundle, ie. would be deleted by the merger
+type CleanupEstimate struct {
+	BundleSize   uint64                  `json:"bundle_size"`
+	Files        int                     `json:"files"`
+	CoveredFiles int                     `json:"covered_files"`
+	ByRange      []*CleanupRangeEstimate `json:"by_range"`
+	ByAge        []*CleanupAgeEstimate   `json:"by_age"`
+}
+
+// EstimateCleanup walks all the one-block files and checks, for each of them, whether the merged bundle containing
+// it exists in `mergedStore`. Nothing is deleted: it is meant to be run before enabling deletion on a legacy bucket.
+func EstimateCleanup(ctx context.Context, io IOInterface, mergedStore dstore.Store) (*CleanupEstimate, error) {
+	bundleSize := DefaultBundleSize
+	if sized, ok := io.(interface{ BundleSize() uint64 }); ok {
+		bundleSize = sized.BundleSize()
+	}
+
+	estimate := &CleanupEstimate{BundleSize: bundleSize}
+	ages := make(map[string]*CleanupAgeEstimate)
+	for _, bucket := range cleanupAgeBuckets {
+		ages[bucket.label] = &CleanupAgeEstimate{MaxAge: bucket.label}
+		estimate.ByAge = append(estimate.ByAge, ages[bucket.label])
+	}
+	ages["unknown"] = &CleanupAgeEstimate{MaxAge: "unknown"}
+	estimate.ByAge = append(estimate.ByAge, ages["unknown"])
+
+	bundles := make(map[uint64]bool)
+	var currentRange *CleanupRangeEstimate
+	now := time.Now()
+
+	err := io.WalkOneBlockFiles(ctx, 0, func(obf *bstream.OneBlockFile) error {
+		baseBlock := obf.Num - obf.Num%bundleSize
+		covered, found := bundles[baseBlock]
+		if !found {
+			exists, err := mergedStore.FileExists(ctx, fileNameForBlocksBundle(baseBlock))
+			if err != nil {
+				return fmt.Errorf("checking merged bundle %d: %w", baseBlock, err)
+			}
+			bundles[baseBlock] = exists
+			covered = exists
+		}
+
+		rangeLow := obf.Num - obf.Num%CleanupEstimateRangeSize
+		if currentRange == nil || currentRange.InclusiveLowBlock != rangeLow {
+			currentRange = &CleanupRangeEstimate{InclusiveLowBlock: rangeLow, ExclusiveHighBlock: rangeLow + CleanupEstimateRangeSize}
+			estimate.ByRange = append(estimate.ByRange, currentRange)
+		}
+
+		for filename := range obf.Filenames {
+			age := ages[cleanupAgeLabel(filename, now)]
+			estimate.Files++
+			currentRange.Files++
+			age.Files++
+			if covered {
+				estimate.CoveredFiles++
+				currentRange.CoveredFiles++
+				age.CoveredFiles++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return estimate, nil
+}
+
+func cleanupAgeLabel(filename string, now time.Time) string {
+	_, blockTime, _, _, _, _, err := parseOneBlockFilename(filename)
+	if err != nil || blockTime.IsZero() {
+		return "unknown"
+	}
+	age := now.Sub(blockTime)
+	for _, bucket := range cleanupAgeBuckets {
+		if bucket.max == 0 || age < bucket.max {
+			return bucket.label
+		}
+	}
+	return "older"
+}
+
+// BundleSize is the number of blocks in each merged file
+func (s *DStoreIO) BundleSize() uint64 {
+	return s.bundleSize
+}
@@ -0,0 +1,45 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateCleanup(t *testing.T) {
+	recent := time.Now().Add(-time.Minute).UTC().Format("20060102T150405")
+	files := []string{
+		"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+		"0000000101-" + recent + "-0000000000000101a-0000000000000100a-99-suffix",
+		"0000000199-0000000000000199a-0000000000000198a-197-suffix",
+		"0000000200-0000000000000200a-0000000000000199a-198-suffix",
+	}
+	io := &TestMergerIO{
+		WalkOneBlockFilesFunc: func(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+			for _, f := range files {
+				if err := callback(mustNewOneBlockFile(f)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	mergedStore := dstore.NewMockStore(nil)
+	mergedStore.SetFile("0000000100", nil)
+
+	estimate, err := EstimateCleanup(context.Background(), io, mergedStore)
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, estimate.Files)
+	assert.Equal(t, 3, estimate.CoveredFiles)
+	assert.Equal(t, []*CleanupRangeEstimate{
+		{InclusiveLowBlock: 0, ExclusiveHighBlock: 100000, Files: 4, CoveredFiles: 3},
+	}, estimate.ByRange)
+	assert.Equal(t, &CleanupAgeEstimate{MaxAge: "1h", Files: 1, CoveredFiles: 1}, estimate.ByAge[0])
+	assert.Equal(t, &CleanupAgeEstimate{MaxAge: "unknown", Files: 3, CoveredFiles: 2}, estimate.ByAge[len(estimate.ByAge)-1])
+}
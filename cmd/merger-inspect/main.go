@@ -0,0 +1,112 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command merger-inspect prints the blocks of a merged bundle and checks their linkage, or diffs two bundles:
+//
+//	merger-inspect [-store <merged blocks store URL>] [-bundle-size 100] [-json] <bundle> [<other bundle>]
+//
+// A bundle is either its base block number, read from -store, or the path or URL of a merged file.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sadiq1971/merger/inspect"
+	"github.com/streamingfast/dstore"
+)
+
+var storeURL = flag.String("store", "", "merged blocks store URL, to read bundles given by base block number")
+var bundleSize = flag.Uint64("bundle-size", 100, "number of blocks of a bundle, 0 to skip the range check")
+var jsonOutput = flag.Bool("json", false, "output JSON instead of text")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <bundle> [<other bundle>]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	var bundles []*inspect.Bundle
+	for _, arg := range flag.Args() {
+		bundle, err := readBundle(ctx, arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		bundles = append(bundles, bundle)
+	}
+
+	if len(bundles) == 2 {
+		os.Exit(diff(bundles[0], bundles[1]))
+	}
+	os.Exit(show(bundles[0]))
+}
+
+func readBundle(ctx context.Context, arg string) (*inspect.Bundle, error) {
+	baseBlock, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return inspect.ReadBundleFile(ctx, arg)
+	}
+	if *storeURL == "" {
+		return nil, fmt.Errorf("-store is required to read bundle %d", baseBlock)
+	}
+	store, err := dstore.NewDBinStore(*storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("opening store %q: %w", *storeURL, err)
+	}
+	return inspect.ReadBundle(ctx, store, baseBlock)
+}
+
+// show prints the bundle and its linkage issues, exiting 1 if any
+func show(bundle *inspect.Bundle) int {
+	issues := inspect.CheckLinkage(bundle, *bundleSize)
+	if *jsonOutput {
+		json.NewEncoder(os.Stdout).Encode(map[string]interface{}{"bundle": bundle, "issues": issues})
+	} else {
+		inspect.Print(os.Stdout, bundle)
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+	}
+	if len(issues) != 0 {
+		return 1
+	}
+	return 0
+}
+
+// diff prints the differences between the bundles, exiting 1 if any
+func diff(left, right *inspect.Bundle) int {
+	differences := inspect.Diff(left, right)
+	if *jsonOutput {
+		json.NewEncoder(os.Stdout).Encode(differences)
+	} else {
+		for _, d := range differences {
+			fmt.Println(d)
+		}
+	}
+	if len(differences) != 0 {
+		return 1
+	}
+	return 0
+}
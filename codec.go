@@ -0,0 +1,107 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+var dbinMagic = []byte("dbin")
+
+// dbinHeaderLen is the length of a version 1 dbin header: magic, version, content type (3 bytes), content version (2 digits)
+const dbinHeaderLen = 10
+
+// BlockCodec is the content type and version of the payload of a one-block file, as found in its dbin header.
+// A merged file only has the header of its first block, so all the blocks of a bundle must share the same codec.
+type BlockCodec struct {
+	ContentType    string
+	ContentVersion int
+}
+
+func (c BlockCodec) String() string {
+	return fmt.Sprintf("%s/%02d", c.ContentType, c.ContentVersion)
+}
+
+// SniffBlockCodec reads the codec from the dbin header of one-block file data
+func SniffBlockCodec(data []byte) (BlockCodec, error) {
+	if len(data) < dbinHeaderLen || !bytes.Equal(data[:len(dbinMagic)], dbinMagic) {
+		return BlockCodec{}, fmt.Errorf("no dbin header found")
+	}
+	if data[4] != 1 {
+		return BlockCodec{}, fmt.Errorf("unsupported dbin version %d", data[4])
+	}
+	version, err := strconv.Atoi(string(data[8:10]))
+	if err != nil {
+		return BlockCodec{}, fmt.Errorf("invalid content version %q", data[8:10])
+	}
+	return BlockCodec{
+		ContentType:    string(data[5:8]),
+		ContentVersion: version,
+	}, nil
+}
+
+// BlockTransformer rewrites the data of a one-block file from codec `from` to codec `to`, header included.
+// It is used to merge one-block files written with a different codec than the first block of their bundle.
+type BlockTransformer func(data []byte, from, to BlockCodec) ([]byte, error)
+
+// WithBlockTransformer transcodes the one-block files whose codec differs from the first block of their bundle,
+// instead of refusing to merge the bundle
+func WithBlockTransformer(transformer BlockTransformer) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.blockTransformer = transformer
+	}
+}
+
+// CodecMismatchError is returned when merging a bundle made of one-block files written with different codecs
+type CodecMismatchError struct {
+	Filename string
+	Expected BlockCodec
+	Actual   BlockCodec
+}
+
+func (e *CodecMismatchError) Error() string {
+	return fmt.Sprintf("one-block file %q has codec %s, the bundle started with %s: mixing codecs breaks the decoders of merged files", e.Filename, e.Actual, e.Expected)
+}
+
+// checkCodec is called by the BundleReader on each one-block file data, header included. It returns the data to merge,
+// transcoded if needed. Data without a dbin header is left unchecked.
+func (r *BundleReader) checkCodec(filename string, data []byte) ([]byte, error) {
+	codec, err := SniffBlockCodec(data)
+	if err != nil {
+		return data, nil
+	}
+	if r.codec == nil {
+		r.codec = &codec
+		return data, nil
+	}
+	if codec == *r.codec {
+		return data, nil
+	}
+
+	if r.transformer == nil {
+		if err := validationFailed(r.logger, r.validationPolicies, ValidationCodec, &CodecMismatchError{Filename: filename, Expected: *r.codec, Actual: codec}); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	transformed, err := r.transformer(data, codec, *r.codec)
+	if err != nil {
+		return nil, fmt.Errorf("transforming one-block file %q from %s to %s: %w", filename, codec, *r.codec, err)
+	}
+	r.transformed++
+	return transformed, nil
+}
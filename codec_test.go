@@ -0,0 +1,61 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dbinData(contentType, contentVersion string, payload ...byte) []byte {
+	return append([]byte("dbin\x01"+contentType+contentVersion), payload...)
+}
+
+func TestSniffBlockCodec(t *testing.T) {
+	codec, err := SniffBlockCodec(dbinData("ETH", "02", 0x1))
+	require.NoError(t, err)
+	assert.Equal(t, BlockCodec{ContentType: "ETH", ContentVersion: 2}, codec)
+	assert.Equal(t, "ETH/02", codec.String())
+
+	_, err = SniffBlockCodec([]byte{0x1, 0x2})
+	assert.Error(t, err)
+	_, err = SniffBlockCodec(dbinData("ETH", "xx"))
+	assert.Error(t, err)
+}
+
+func codecTestBundle() []*bstream.OneBlockFile {
+	bstream.GetBlockWriterHeaderLen = dbinHeaderLen
+	return []*bstream.OneBlockFile{
+		{CanonicalName: "o1", MemoizeData: dbinData("ETH", "02", 0x1)},
+		{CanonicalName: "o2", MemoizeData: dbinData("ETH", "01", 0x2)},
+	}
+}
+
+func TestBundleReader_RefusesMixedCodecs(t *testing.T) {
+	r := NewBundleReader(context.Background(), testLogger, testTracer, codecTestBundle(), nil)
+
+	_, err := ioutil.ReadAll(r)
+	var mismatch *CodecMismatchError
+	require.True(t, errors.As(err, &mismatch), "got %v", err)
+	assert.Equal(t, "o2", mismatch.Filename)
+	assert.Equal(t, BlockCodec{ContentType: "ETH", ContentVersion: 2}, mismatch.Expected)
+	assert.Equal(t, BlockCodec{ContentType: "ETH", ContentVersion: 1}, mismatch.Actual)
+}
+
+func TestBundleReader_TransformsMixedCodecs(t *testing.T) {
+	r := NewBundleReader(context.Background(), testLogger, testTracer, codecTestBundle(), nil)
+	r.transformer = func(data []byte, from, to BlockCodec) ([]byte, error) {
+		assert.Equal(t, 1, from.ContentVersion)
+		assert.Equal(t, 2, to.ContentVersion)
+		return dbinData("ETH", "02", data[dbinHeaderLen]+0x10), nil
+	}
+
+	content, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, dbinData("ETH", "02", 0x1, 0x12), content)
+	assert.Equal(t, 1, r.transformed)
+}
@@ -0,0 +1,202 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// Defaults of NewMergerWithOptions
+var (
+	DefaultPruningDistanceToLIB uint64 = 50000
+	DefaultTimeBetweenPruning          = time.Minute
+	DefaultTimeBetweenPolling          = time.Second
+)
+
+// WithLogger replaces the no-op logger
+func WithLogger(logger *zap.Logger) Option {
+	return func(m *Merger) {
+		m.settings.logger = logger
+	}
+}
+
+// WithGRPC serves the merger gRPC services on `listenAddr`, none when empty
+func WithGRPC(listenAddr string) Option {
+	return func(m *Merger) {
+		m.grpcListenAddr = listenAddr
+	}
+}
+
+// WithFirstStreamableBlock sets the lowest block merged, its one-block files and the ones above are walked
+func WithFirstStreamableBlock(blockNum uint64) Option {
+	return func(m *Merger) {
+		m.settings.firstStreamableBlock = blockNum
+	}
+}
+
+// WithBundleSize sets how many blocks each merged file holds
+func WithBundleSize(bundleSize uint64) Option {
+	return func(m *Merger) {
+		m.settings.bundleSize = bundleSize
+	}
+}
+
+// WithPruning prunes the forked blocks more than `distanceToLIB` blocks below the bundle being collected, and the old
+// one-block files, every `interval`
+func WithPruning(distanceToLIB uint64, interval time.Duration) Option {
+	return func(m *Merger) {
+		m.pruningDistanceToLIB = distanceToLIB
+		m.timeBetweenPruning = interval
+	}
+}
+
+// WithPollInterval sets how long to wait between the walks of the one-block files
+func WithPollInterval(interval time.Duration) Option {
+	return func(m *Merger) {
+		m.timeBetweenPolling = interval
+	}
+}
+
+// WithStopBlock merges every bundle below `exclusiveStopBlock`, aligned on the bundle size, then shuts the merger
+// down with ErrStopBlockReached (0 merges forever)
+func WithStopBlock(exclusiveStopBlock uint64) Option {
+	return func(m *Merger) {
+		m.settings.stopBlock = exclusiveStopBlock
+	}
+}
+
+// OneBlockFilesDeleter deletes one-block files without blocking, see WithDeleter
+type OneBlockFilesDeleter interface {
+	DeleteAsync(oneBlockFiles []*bstream.OneBlockFile) error
+}
+
+// WithDeleter deletes the pruned one-block files, and the ones below the first streamable block, through `deleter`
+// instead of the IO. It is ignored in observer and shadow modes, which never delete anything.
+func WithDeleter(deleter OneBlockFilesDeleter) Option {
+	return func(m *Merger) {
+		m.settings.deleter = deleter
+	}
+}
+
+// deleteOneBlockFiles deletes through the deleter of WithDeleter, or the IO
+func (m *Merger) deleteOneBlockFiles(oneBlockFiles []*bstream.OneBlockFile) error {
+	if m.deleter != nil {
+		return m.deleter.DeleteAsync(oneBlockFiles)
+	}
+	return m.io.DeleteAsync(oneBlockFiles)
+}
+
+// mergerSettings are the settings of the bundler recorded by the options, applied by applySettings once all the
+// options have run, so that the bundler is built the same whatever their order. The logger is recorded too, for the
+// options holding it, and those reading the clock, to get it from applySettings.
+type mergerSettings struct {
+	logger      *zap.Logger                  // see WithLogger, a no-op logger when nil
+	logFields   []zap.Field                  // see WithLogFields
+	logSampling map[LogComponent]LogSampling // see WithLogSampling
+	deleter     OneBlockFilesDeleter         // see WithDeleter, the IO when nil
+
+	firstStreamableBlock uint64
+	bundleSize           uint64
+	stopBlock            uint64
+	startBlock           *uint64 // see WithStartBlock, the first streamable block when nil
+
+	pipelineDepth int            // see WithMergePipelineDepth
+	catchUp       *catchUpMerges // see WithCatchUpMerges
+}
+
+// applySettings sets up the logger, the bundler, and what depends on them or on the clock, from the recorded settings
+func (m *Merger) applySettings() {
+	s := m.settings
+	m.logger = zap.NewNop()
+	if s.logger != nil {
+		m.logger = s.logger
+	}
+	m.logger = m.logger.With(s.logFields...)
+	m.componentLoggers = make(componentLoggers)
+	for component, sampling := range s.logSampling {
+		m.componentLoggers[component] = sampling.apply(m.logger)
+	}
+	readOnly := m.setIOLogger()
+	m.deleter = nil
+	if !readOnly {
+		m.deleter = s.deleter
+	}
+
+	m.firstStreamableBlock = s.firstStreamableBlock
+	m.bundler.firstStreamableBlock = s.firstStreamableBlock
+	m.bundler.bundleSize = s.bundleSize
+	m.bundler.stopBlock = s.stopBlock
+	m.progress = newProgressTracker(s.bundleSize)
+
+	startBlock := s.firstStreamableBlock
+	if s.startBlock != nil {
+		startBlock = *s.startBlock
+	}
+	m.bundler.Reset(toBaseNum(startBlock, s.bundleSize), nil)
+	m.settingsErr = nil
+	if s.startBlock != nil && startBlock%s.bundleSize != 0 {
+		if m.forceStart {
+			m.settingsErr = fmt.Errorf("force start block %d is not aligned on bundle size %d", startBlock, s.bundleSize)
+		}
+		m.bundler.alignmentStartBlock = startBlock
+	}
+	m.stats.startBlock = startBlock
+
+	depth := s.pipelineDepth
+	m.bundler.catchUp = s.catchUp
+	if s.catchUp != nil && depth < s.catchUp.minBacklog+s.catchUp.parallelism {
+		depth = s.catchUp.minBacklog + s.catchUp.parallelism
+	}
+	m.bundler.pipeline = nil
+	if depth > 1 {
+		m.bundler.pipeline = &mergePipeline{slots: make(chan struct{}, depth)}
+	}
+
+	if m.poison != nil {
+		m.poison.bundleSize = s.bundleSize
+		m.poison.logger = m.logger
+	}
+	if m.deletionDeferral != nil {
+		m.deletionDeferral.since = m.clock.Now()
+	}
+	if m.comparator != nil {
+		m.comparator.logger = m.logger
+		m.comparator.bundleSize = s.bundleSize
+		m.comparator.shadow.Reset(m.bundler.baseBlockNum, nil)
+	}
+}
+
+// setIOLogger gives the logger to the IOs wrapped by WithCapture and WithObserverMode, it returns true when one of them
+// is an observer, which never deletes anything
+func (m *Merger) setIOLogger() (readOnly bool) {
+	for io := m.io; io != nil; {
+		switch wrapper := io.(type) {
+		case *captureIO:
+			wrapper.logger = m.logger
+			io = wrapper.IOInterface
+		case *observerIO:
+			wrapper.logger = m.logger
+			readOnly = true
+			io = wrapper.io
+		default:
+			io = nil
+		}
+	}
+	return readOnly
+}
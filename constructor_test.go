@@ -0,0 +1,98 @@
+package merger
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewMergerWithOptions(t *testing.T) {
+	m := NewMergerWithOptions(&TestMergerIO{},
+		WithLogger(testLogger),
+		WithGRPC(":9000"),
+		WithFirstStreamableBlock(2500),
+		WithBundleSize(1000),
+		WithPruning(10, time.Hour),
+		WithPollInterval(5*time.Second),
+		WithStopBlock(10000),
+	)
+	assert.Equal(t, ":9000", m.grpcListenAddr)
+	assert.Equal(t, uint64(1000), m.bundler.bundleSize)
+	assert.Equal(t, uint64(2000), m.bundler.BaseBlockNum())
+	assert.Equal(t, uint64(2500), m.bundler.firstStreamableBlock)
+	assert.Equal(t, uint64(10000), m.bundler.stopBlock)
+	assert.Equal(t, uint64(1000), m.progress.bundleSize)
+	assert.Equal(t, uint64(10), m.pruningDistanceToLIB)
+	assert.Equal(t, time.Hour, m.timeBetweenPruning)
+	assert.Equal(t, 5*time.Second, m.timeBetweenPolling)
+}
+
+func TestNewMergerWithOptions_Defaults(t *testing.T) {
+	m := NewMergerWithOptions(&TestMergerIO{})
+	assert.Equal(t, "", m.grpcListenAddr)
+	assert.Equal(t, DefaultBundleSize, m.bundler.bundleSize)
+	assert.Equal(t, uint64(0), m.bundler.stopBlock)
+	assert.Equal(t, DefaultTimeBetweenPolling, m.timeBetweenPolling)
+	assert.Equal(t, DefaultTimeBetweenPruning, m.timeBetweenPruning)
+}
+
+func TestNewMergerWithOptions_AnyOrder(t *testing.T) {
+	m := NewMergerWithOptions(&TestMergerIO{},
+		WithCatchUpMerges(3, 2),
+		WithMergePipelineDepth(2),
+		WithStartBlock(2700),
+		WithBundleSize(1000),
+		WithFirstStreamableBlock(2500),
+	)
+	assert.Equal(t, uint64(1000), m.bundler.bundleSize)
+	assert.Equal(t, uint64(2000), m.bundler.BaseBlockNum())
+	assert.Equal(t, uint64(2700), m.bundler.alignmentStartBlock)
+	assert.Equal(t, uint64(2500), m.bundler.firstStreamableBlock)
+	assert.Equal(t, uint64(2700), m.stats.startBlock)
+	assert.Equal(t, uint64(1000), m.progress.bundleSize)
+	assert.Equal(t, 5, cap(m.bundler.pipeline.slots), "enlarged by the catch-up merges")
+}
+
+type testDeleter struct {
+	deleted []*bstream.OneBlockFile
+}
+
+func (d *testDeleter) DeleteAsync(oneBlockFiles []*bstream.OneBlockFile) error {
+	d.deleted = append(d.deleted, oneBlockFiles...)
+	return nil
+}
+
+func TestNewMergerWithOptions_ReverseOrder(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	deleter := &testDeleter{}
+	m := NewMergerWithOptions(&TestMergerIO{},
+		WithDeleter(deleter),
+		WithDeletionDeferral(2, time.Minute),
+		WithPoisonRanges(dstore.NewMockStore(nil), 3),
+		WithCapture(&bytes.Buffer{}),
+		WithLogFields(zap.String("chain", "eth")),
+		WithLogger(zap.New(core)),
+	)
+	m.logger.Info("merger")
+	m.poison.logger.Info("poison")
+	m.io.(*captureIO).logger.Info("capture")
+	require.Equal(t, 3, logs.Len(), "the options holding the logger get the one of WithLogger")
+	for _, entry := range logs.All() {
+		assert.Equal(t, "eth", entry.ContextMap()["chain"], entry.Message)
+	}
+	assert.False(t, m.deletionDeferral.since.IsZero())
+
+	files := []*bstream.OneBlockFile{{CanonicalName: "0000000100-0000000000000100a-0000000000000099a-98-suffix"}}
+	require.NoError(t, m.deleteOneBlockFiles(files))
+	assert.Equal(t, files, deleter.deleted)
+
+	m = NewMergerWithOptions(&TestMergerIO{}, WithDeleter(deleter), WithObserverMode(nil, 0))
+	assert.Nil(t, m.deleter, "an observer never deletes anything")
+}
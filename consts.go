@@ -21,4 +21,8 @@ var WriteObjectTimeout = 5 * time.Minute
 var GetObjectTimeout = 5 * time.Minute
 var DeleteObjectTimeout = 5 * time.Minute
 
+// DeletionAttempts is how many times a one-block file deletion is attempted before giving up on it (see DeadLetters)
+var DeletionAttempts = 5
+var DeletionRetryInterval = 30 * time.Second
+
 const ParallelOneBlockDownload = 2
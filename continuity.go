@@ -0,0 +1,84 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// lastMergedBlock holds the last blocks of the merged files written by this merger, by the exclusive high block of
+// the file, for the continuity check. The bundles merged in parallel (see WithCatchUpMerges) are written in any order,
+// each of them is recorded.
+type lastMergedBlock struct {
+	sync.Mutex
+	blockIDs map[uint64]string
+}
+
+func (l *lastMergedBlock) set(exclusiveHighBlock uint64, obf *bstream.OneBlockFile) {
+	l.Lock()
+	defer l.Unlock()
+	if l.blockIDs == nil {
+		l.blockIDs = make(map[uint64]string)
+	}
+	l.blockIDs[exclusiveHighBlock] = obf.ID
+}
+
+// get forgets the blocks of the merged files below, they are read from the merged files if they are merged again
+func (l *lastMergedBlock) get(exclusiveHighBlock uint64) (string, bool) {
+	l.Lock()
+	defer l.Unlock()
+	blockID, ok := l.blockIDs[exclusiveHighBlock]
+	for high := range l.blockIDs {
+		if high < exclusiveHighBlock {
+			delete(l.blockIDs, high)
+		}
+	}
+	return blockID, ok
+}
+
+// checkContinuity verifies that `first`, the first block of the merged file starting at `inclusiveLowerBlock`, has
+// the last block of the previous merged file as parent. That block is the one last merged by this merger, or read
+// from the previous merged file. The check is skipped when there is no previous merged file.
+func (s *DStoreIO) checkContinuity(ctx context.Context, inclusiveLowerBlock uint64, first *bstream.OneBlockFile) error {
+	previousID, ok := s.lastMerged.get(inclusiveLowerBlock)
+	if !ok {
+		if inclusiveLowerBlock%s.bundleSize != 0 || inclusiveLowerBlock < s.bundleSize {
+			return nil // split at a protocol upgrade by another run, or first bundle
+		}
+		previousBase := inclusiveLowerBlock - s.bundleSize
+		exists, err := s.mergedFileExists(ctx, previousBase)
+		if err != nil {
+			return fmt.Errorf("checking previous merged file %d: %w", previousBase, err)
+		}
+		if !exists {
+			s.logger.Debug("no previous merged file, skipping continuity check", zap.Uint64("base_block", inclusiveLowerBlock))
+			return nil
+		}
+		last, _, err := s.readLastBlockFromMerged(ctx, previousBase)
+		if err != nil {
+			return fmt.Errorf("reading previous merged file %d: %w", previousBase, err)
+		}
+		previousID = last.ID()
+	}
+	if bstream.TruncateBlockID(first.PreviousID) != bstream.TruncateBlockID(previousID) {
+		return fmt.Errorf("merged file %d does not continue the previous one: its first block %s has parent %s, the previous merged file ends with %s", inclusiveLowerBlock, first, first.PreviousID, previousID)
+	}
+	return nil
+}
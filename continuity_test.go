@@ -0,0 +1,42 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDStoreIO_CheckContinuity(t *testing.T) {
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	mergedBlocksStore.FileExistsFunc = func(_ context.Context, name string) (bool, error) {
+		return name == fileNameForBlocksBundle(0), nil
+	}
+	mergedBlocksStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader([]byte(
+			`{"id":"0000000000000098a","prev":"0000000000000097a","num":98,"libnum":96}` + "\n" +
+				`{"id":"0000000000000099a","prev":"0000000000000098a","num":99,"libnum":97}` + "\n",
+		))), nil
+	}
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedBlocksStore, nil, 0, 0, 100).(*DStoreIO)
+	ctx := context.Background()
+
+	assert.NoError(t, mio.checkContinuity(ctx, 100, block100), "read from the previous merged file")
+	assert.Error(t, mio.checkContinuity(ctx, 100, mustNewOneBlockFile("0000000100-0000000000000100b-0000000000000099b-98-suffix")))
+	assert.NoError(t, mio.checkContinuity(ctx, 300, mustNewOneBlockFile("0000000300-0000000000000300a-0000000000000299a-298-suffix")), "no previous merged file")
+
+	mio.lastMerged.set(200, mustNewOneBlockFile("0000000199-0000000000000199a-0000000000000198a-197-suffix"))
+	assert.NoError(t, mio.checkContinuity(ctx, 200, mustNewOneBlockFile("0000000200-0000000000000200a-0000000000000199a-198-suffix")))
+	assert.Error(t, mio.checkContinuity(ctx, 200, mustNewOneBlockFile("0000000200-0000000000000200a-0000000000000199b-198-suffix")), "the last merged block is not the parent")
+
+	// merged in parallel, the later bundle is written first
+	mio.lastMerged.set(400, mustNewOneBlockFile("0000000399-0000000000000399a-0000000000000398a-397-suffix"))
+	mio.lastMerged.set(300, mustNewOneBlockFile("0000000299-0000000000000299a-0000000000000298a-297-suffix"))
+	assert.NoError(t, mio.checkContinuity(ctx, 300, mustNewOneBlockFile("0000000300-0000000000000300a-0000000000000299a-298-suffix")))
+	assert.NoError(t, mio.checkContinuity(ctx, 400, mustNewOneBlockFile("0000000400-0000000000000400a-0000000000000399a-398-suffix")))
+	assert.Error(t, mio.checkContinuity(ctx, 400, mustNewOneBlockFile("0000000400-0000000000000400a-0000000000000399b-398-suffix")))
+}
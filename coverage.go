@@ -0,0 +1,189 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// DefaultCoverageCheckInterval is how often the merged files are checked against the coverage manifest when no interval is given
+var DefaultCoverageCheckInterval = time.Hour
+
+// CoverageRange is a range of blocks, from InclusiveLowBlock to ExclusiveHighBlock
+type CoverageRange struct {
+	InclusiveLowBlock  uint64 `json:"inclusive_low_block"`
+	ExclusiveHighBlock uint64 `json:"exclusive_high_block"`
+}
+
+func (r CoverageRange) contains(other CoverageRange) bool {
+	return r.InclusiveLowBlock <= other.InclusiveLowBlock && other.ExclusiveHighBlock <= r.ExclusiveHighBlock
+}
+
+// CoverageIOInterface is implemented by the ios that can list the merged bundles
+type CoverageIOInterface interface {
+	// MissingMergedBundles returns the ranges of missing bundles between inclusiveLowBlock and exclusiveHighBlock, aligned on the bundle size
+	MissingMergedBundles(ctx context.Context, inclusiveLowBlock, exclusiveHighBlock uint64) ([]CoverageRange, error)
+}
+
+func (s *DStoreIO) MissingMergedBundles(ctx context.Context, inclusiveLowBlock, exclusiveHighBlock uint64) (out []CoverageRange, err error) {
+	next := toBaseNum(inclusiveLowBlock, s.bundleSize)
+	missingUpTo := func(end uint64) {
+		if next >= end {
+			return
+		}
+		if len(out) > 0 && out[len(out)-1].ExclusiveHighBlock == next {
+			out[len(out)-1].ExclusiveHighBlock = end
+		} else {
+			out = append(out, CoverageRange{InclusiveLowBlock: next, ExclusiveHighBlock: end})
+		}
+		next = end
+	}
+
+	for _, segment := range s.mergedStoreSegments(next) {
+		if segment.InclusiveLowBlock >= exclusiveHighBlock {
+			break
+		}
+		segmentEnd := exclusiveHighBlock
+		if segment.ExclusiveHighBlock != 0 && segment.ExclusiveHighBlock < segmentEnd {
+			segmentEnd = segment.ExclusiveHighBlock
+		}
+		err = s.walkMergedFiles(ctx, segment, next, func(num uint64) error {
+			if num >= segmentEnd {
+				return dstore.StopIteration
+			}
+			if s.isUpgradeSplit(num) {
+				return nil
+			}
+			missingUpTo(num)
+			next = num + s.bundleSize
+			return nil
+		})
+		if err != nil && !errors.Is(err, dstore.StopIteration) {
+			return nil, err
+		}
+		err = nil
+		missingUpTo(segmentEnd)
+	}
+	return out, nil
+}
+
+// WithCoverageManifest checks every `interval` (DefaultCoverageCheckInterval if 0) that the merged bundles cover all the
+// `expected` ranges. Missing bundles are reported in `merger_missing_merged_bundles`, and each gap appearing after the
+// first check (ex: merged files deleted by a bucket lifecycle rule) is logged as an error and counted in
+// `merger_coverage_regressions`. The io must implement CoverageIOInterface.
+func WithCoverageManifest(expected []CoverageRange, interval time.Duration) Option {
+	return func(m *Merger) {
+		if interval == 0 {
+			interval = DefaultCoverageCheckInterval
+		}
+		m.coverage = &coverageVerifier{
+			expected: expected,
+			interval: interval,
+		}
+	}
+}
+
+type coverageVerifier struct {
+	sync.Mutex
+	expected []CoverageRange
+	interval time.Duration
+
+	checked bool
+	gaps    []CoverageRange
+}
+
+func (m *Merger) startCoverageVerifier() {
+	if m.coverage == nil {
+		return
+	}
+	if _, ok := m.io.(CoverageIOInterface); !ok {
+		m.logger.Warn("io cannot list merged bundles, coverage manifest is not verified")
+		return
+	}
+	go func() {
+		for {
+			if err := m.verifyCoverage(context.Background()); err != nil {
+				m.logger.Warn("cannot verify merged files coverage", zap.Error(err))
+			}
+			select {
+			case <-m.Terminating():
+				return
+			case <-time.After(m.coverage.interval):
+			}
+		}
+	}()
+}
+
+// verifyCoverage lists the merged bundles of the expected ranges and reports the gaps
+func (m *Merger) verifyCoverage(ctx context.Context) error {
+	lister := m.io.(CoverageIOInterface)
+	var gaps []CoverageRange
+	for _, expected := range m.coverage.expected {
+		missing, err := lister.MissingMergedBundles(ctx, expected.InclusiveLowBlock, expected.ExclusiveHighBlock)
+		if err != nil {
+			return err
+		}
+		gaps = append(gaps, missing...)
+	}
+
+	var missingBundles uint64
+	for _, gap := range gaps {
+		missingBundles += (gap.ExclusiveHighBlock - gap.InclusiveLowBlock + m.bundler.bundleSize - 1) / m.bundler.bundleSize
+	}
+	metrics.MissingMergedBundles.SetUint64(missingBundles)
+
+	m.coverage.Lock()
+	defer m.coverage.Unlock()
+	for _, gap := range gaps {
+		if !m.coverage.checked {
+			m.logger.Warn("merged files do not cover the expected range", zap.Uint64("missing_low_block", gap.InclusiveLowBlock), zap.Uint64("missing_high_block", gap.ExclusiveHighBlock))
+			continue
+		}
+		if covered(m.coverage.gaps, gap) {
+			continue
+		}
+		metrics.CoverageRegressions.Inc()
+		m.logger.Error("merged files coverage regression, bundles that existed are now missing", zap.Uint64("missing_low_block", gap.InclusiveLowBlock), zap.Uint64("missing_high_block", gap.ExclusiveHighBlock))
+	}
+	m.coverage.checked = true
+	m.coverage.gaps = gaps
+	return nil
+}
+
+func covered(gaps []CoverageRange, gap CoverageRange) bool {
+	for _, known := range gaps {
+		if known.contains(gap) {
+			return true
+		}
+	}
+	return false
+}
+
+// CoverageGaps returns the ranges of the coverage manifest missing from the merged files on the last check
+func (m *Merger) CoverageGaps() []CoverageRange {
+	if m.coverage == nil {
+		return nil
+	}
+	m.coverage.Lock()
+	defer m.coverage.Unlock()
+	return m.coverage.gaps
+}
@@ -0,0 +1,59 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDStoreIO_MissingMergedBundles(t *testing.T) {
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	for _, name := range []string{"0000000100", "0000000200", "0000000400", "0000000700"} {
+		mergedBlocksStore.SetFile(name, nil)
+	}
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedBlocksStore, nil, 0, 0, 100).(*DStoreIO)
+
+	missing, err := mio.MissingMergedBundles(context.Background(), 100, 700)
+	require.NoError(t, err)
+	assert.Equal(t, []CoverageRange{{300, 400}, {500, 700}}, missing)
+
+	missing, err = mio.MissingMergedBundles(context.Background(), 0, 300)
+	require.NoError(t, err)
+	assert.Equal(t, []CoverageRange{{0, 100}}, missing)
+
+	missing, err = mio.MissingMergedBundles(context.Background(), 100, 300)
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
+type coverageTestMergerIO struct {
+	TestMergerIO
+	missing []CoverageRange
+}
+
+func (io *coverageTestMergerIO) MissingMergedBundles(_ context.Context, _, _ uint64) ([]CoverageRange, error) {
+	return io.missing, nil
+}
+
+func TestMerger_VerifyCoverage(t *testing.T) {
+	mio := &coverageTestMergerIO{missing: []CoverageRange{{300, 400}}}
+	m := NewMerger(testLogger, "", mio, 100, 100, 100, time.Second, time.Second, 0, WithCoverageManifest([]CoverageRange{{0, 1000}}, 0))
+	assert.Equal(t, DefaultCoverageCheckInterval, m.coverage.interval)
+
+	require.NoError(t, m.verifyCoverage(context.Background()))
+	assert.Equal(t, []CoverageRange{{300, 400}}, m.CoverageGaps())
+
+	mio.missing = []CoverageRange{{300, 400}, {600, 800}}
+	require.NoError(t, m.verifyCoverage(context.Background()))
+	assert.Equal(t, []CoverageRange{{300, 400}, {600, 800}}, m.CoverageGaps())
+	assert.False(t, covered([]CoverageRange{{300, 400}}, CoverageRange{600, 800}))
+	assert.True(t, covered([]CoverageRange{{200, 500}}, CoverageRange{300, 400}))
+
+	mio.missing = nil
+	require.NoError(t, m.verifyCoverage(context.Background()))
+	assert.Empty(t, m.CoverageGaps())
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// MergedCursorFilename is the name of the cursor in the merged blocks store. Like the probe files, it starts with a
+// dot so that the listings of merged files, which start from the first block number, never see it.
+const MergedCursorFilename = ".merged-blocks-cursor.json"
+
+// MergedCursor points to the highest block merged, so that readers of the merged blocks store find the head without
+// listing it
+type MergedCursor struct {
+	BaseBlock    uint64 `json:"base_block"` // of the merged file holding the last block
+	LastBlockNum uint64 `json:"last_block_num"`
+	LastBlockID  string `json:"last_block_id"`
+	// LastBlockTime is only set when the time of the last block could be decoded
+	LastBlockTime *time.Time `json:"last_block_time,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// WithMergedCursor writes a MergedCursor to MergedCursorFilename in the merged blocks store after each merged file
+// extending the merged blocks. The cursor is replaced by deleting it first (stores may not overwrite), readers
+// finding none should retry.
+func WithMergedCursor() DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.cursor = &mergedCursorWriter{}
+	}
+}
+
+type mergedCursorWriter struct {
+	sync.Mutex
+	loaded  bool
+	lastNum uint64
+}
+
+// ReadMergedCursor reads the cursor of a merged blocks store, it returns nil when there is none
+func ReadMergedCursor(ctx context.Context, store dstore.Store) (*MergedCursor, error) {
+	exists, err := store.FileExists(ctx, MergedCursorFilename)
+	if err != nil || !exists {
+		return nil, err
+	}
+	reader, err := store.OpenObject(ctx, MergedCursorFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading merged blocks cursor: %w", err)
+	}
+	cursor := &MergedCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, fmt.Errorf("decoding merged blocks cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// ReadCursor reads the cursor of the merged blocks store, it returns nil when there is none
+func (s *DStoreIO) ReadCursor(ctx context.Context) (*MergedCursor, error) {
+	return ReadMergedCursor(ctx, s.mergedBlocksStore)
+}
+
+// updateCursor moves the cursor to `last`, the last block of the merged file at `baseBlock`, unless the cursor is
+// already higher (backfilled or flushed bundles)
+func (s *DStoreIO) updateCursor(ctx context.Context, baseBlock uint64, last *bstream.OneBlockFile, lastBlockTime *time.Time) {
+	s.cursor.Lock()
+	defer s.cursor.Unlock()
+	if !s.cursor.loaded {
+		current, err := s.ReadCursor(ctx)
+		if err != nil {
+			s.logger.Warn("cannot read merged blocks cursor", zap.Error(err))
+			return
+		}
+		if current != nil {
+			s.cursor.lastNum = current.LastBlockNum
+		}
+		s.cursor.loaded = true
+	}
+	if last.Num <= s.cursor.lastNum {
+		return
+	}
+
+	data, err := json.Marshal(&MergedCursor{
+		BaseBlock:     baseBlock,
+		LastBlockNum:  last.Num,
+		LastBlockID:   last.ID,
+		LastBlockTime: lastBlockTime,
+		UpdatedAt:     time.Now(),
+	})
+	if err != nil {
+		s.logger.Warn("cannot encode merged blocks cursor", zap.Error(err))
+		return
+	}
+	writeCtx, cancel := context.WithTimeout(ctx, WriteObjectTimeout)
+	defer cancel()
+	if err := s.mergedBlocksStore.DeleteObject(writeCtx, MergedCursorFilename); err != nil && !errors.Is(err, dstore.ErrNotFound) {
+		s.logger.Warn("cannot replace merged blocks cursor", zap.Error(err))
+		return
+	}
+	if err := s.mergedBlocksStore.WriteObject(writeCtx, MergedCursorFilename, bytes.NewReader(data)); err != nil {
+		s.logger.Warn("cannot write merged blocks cursor", zap.Error(err))
+		return
+	}
+	s.cursor.lastNum = last.Num
+}
@@ -0,0 +1,51 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergerIO_MergedCursor(t *testing.T) {
+	oneBlocksStore := dstore.NewMockStore(nil)
+	oneBlocksStore.SetFile("0000000100-0000000000000100a-0000000000000099a-98-suffix", []byte(`{"id":"0000000000000100a","prev":"0000000000000099a","num":100,"libnum":98}`+"\n"))
+	oneBlocksStore.SetFile("0000000101-0000000000000101a-0000000000000100a-99-suffix", []byte(`{"id":"0000000000000101a","prev":"0000000000000100a","num":101,"libnum":99}`+"\n"))
+	oneBlocksStore.SetFile("0000000200-0000000000000200a-0000000000000199a-198-suffix", []byte(`{"id":"0000000000000200a","prev":"0000000000000199a","num":200,"libnum":198,"time":"2022-09-01T12:00:00"}`+"\n"))
+	mergedBlocksStore := dstore.NewMockStore(nil)
+
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, mergedBlocksStore, nil, 0, 0, 100, WithMergedCursor()).(*DStoreIO)
+	cursor, err := mio.ReadCursor(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, cursor)
+
+	require.NoError(t, mio.MergeAndStore(context.Background(), 200, []*bstream.OneBlockFile{
+		mustNewOneBlockFile("0000000200-0000000000000200a-0000000000000199a-198-suffix"),
+	}))
+	cursor, err = mio.ReadCursor(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(200), cursor.BaseBlock)
+	assert.Equal(t, uint64(200), cursor.LastBlockNum)
+	assert.Equal(t, "0000000000000200a", cursor.LastBlockID)
+	require.NotNil(t, cursor.LastBlockTime)
+	assert.Equal(t, time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC), cursor.LastBlockTime.UTC())
+
+	require.NoError(t, mio.MergeAndStore(context.Background(), 100, []*bstream.OneBlockFile{
+		mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-suffix"),
+		mustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-suffix"),
+	}))
+	cursor, err = ReadMergedCursor(context.Background(), mergedBlocksStore)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(200), cursor.LastBlockNum, "a lower merged file does not move the cursor back")
+
+	var walked []uint64
+	require.NoError(t, mio.walkMergedFiles(context.Background(), mio.mergedStoreSegments(0)[0], 0, func(num uint64) error {
+		walked = append(walked, num)
+		return nil
+	}))
+	assert.Equal(t, []uint64{100, 200}, walked, "the cursor is not a merged file")
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+var DefaultDegradedProbeInterval = 30 * time.Second
+
+// readOnlyErrorPattern matches the messages of the stores refusing a write, for the errors that are not typed (e.g.
+// wrapped with %v by dstore). HTTP status codes only match next to their context, a bare "403" or "forbidden" is
+// often part of a block number or a path.
+var readOnlyErrorPattern = regexp.MustCompile(`\b(permission denied|access ?denied|read-only file system|403 forbidden|error 403|status(?: ?code)?:? 403|http 403)\b`)
+
+// IsReadOnlyError returns true if err looks like the store refused a write because of permissions
+func IsReadOnlyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS) {
+		return true
+	}
+	var requestErr awserr.RequestFailure
+	if errors.As(err, &requestErr) && requestErr.StatusCode() == http.StatusForbidden {
+		return true
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == "AccessDenied" {
+		return true
+	}
+	return readOnlyErrorPattern.MatchString(strings.ToLower(err.Error()))
+}
+
+// WriteProber is implemented by IOInterfaces that can cheaply check if the merged blocks store accepts writes again
+type WriteProber interface {
+	ProbeWrite(ctx context.Context) error
+}
+
+const probeFilenamePrefix = ".merger-probe-"
+
+// ProbeWrite writes and deletes a tiny object in the merged blocks store, and in the store of each range (see
+// WithMergedBlocksStoreRange). Its name sorts before any merged file.
+func (s *DStoreIO) ProbeWrite(ctx context.Context) error {
+	filename := fmt.Sprintf("%s%d", probeFilenamePrefix, time.Now().UnixNano())
+	inCtx, cancel := context.WithTimeout(ctx, WriteObjectTimeout)
+	defer cancel()
+
+	stores := []dstore.Store{s.mergedBlocksStore}
+	for _, r := range s.mergedBlocksStoreRanges {
+		stores = append(stores, r.Store)
+	}
+	for _, store := range stores {
+		if err := store.WriteObject(inCtx, filename, strings.NewReader("probe")); err != nil {
+			return err
+		}
+		if err := store.DeleteObject(inCtx, filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeAndStore calls MergeAndStore. When the merged blocks store turns read-only or unavailable (see
+// IsDestinationOutage), instead of failing (and losing the forkdb state on restart), it reports the bundler as
+// degraded and keeps the bundle until a probe write succeeds. The walk keeps feeding the bundler until the next
+// bundle is complete.
+func (b *Bundler) mergeAndStore(baseBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+	err := b.io.MergeAndStore(b.ctx, baseBlockNum, oneBlockFiles)
+	if err == nil || b.degradedProbeInterval == 0 || !isHeldMergeError(err) {
+		return err
+	}
+
+	if IsReadOnlyError(err) {
+		b.setDegraded(fmt.Sprintf("merged blocks store is read-only: %s", err))
+	} else {
+		b.setDegraded(fmt.Sprintf("merged blocks store is unavailable: %s", err))
+	}
+	defer b.setDegraded("")
+	for {
+		select {
+		case <-b.terminating:
+			return err
+		case <-time.After(b.degradedProbeInterval):
+		}
+
+		if prober, ok := b.io.(WriteProber); ok {
+			if probeErr := prober.ProbeWrite(b.ctx); probeErr != nil {
+				continue
+			}
+		}
+
+		err = b.io.MergeAndStore(b.ctx, baseBlockNum, oneBlockFiles)
+		if err == nil || !isHeldMergeError(err) {
+			return err
+		}
+	}
+}
+
+// isHeldMergeError tells if the bundle is kept and merged again once the merged blocks store recovers
+func isHeldMergeError(err error) bool {
+	return IsReadOnlyError(err) || IsDestinationOutage(err)
+}
+
+// setDegraded is called under the bundler lock, so that ProcessBlock does not set the merger ready again in between
+func (b *Bundler) setDegraded(reason string) {
+	b.Lock()
+	defer b.Unlock()
+	b.degraded = reason != ""
+	if b.onDegraded != nil {
+		b.onDegraded(reason)
+	}
+}
+
+// DegradedReason returns why the merger is degraded, or an empty string if it is not
+func (m *Merger) DegradedReason() string {
+	m.degradedLock.Lock()
+	defer m.degradedLock.Unlock()
+	return m.degradedReason
+}
+
+func (m *Merger) setDegraded(reason string) {
+	m.degradedLock.Lock()
+	defer m.degradedLock.Unlock()
+	if reason == m.degradedReason {
+		return
+	}
+	if reason == "" {
+		m.logger.Info("leaving degraded mode, merged blocks store is writable again")
+		metrics.AppReadiness.SetReady()
+	} else {
+		m.logger.Warn("entering degraded mode", zap.String("reason", reason))
+		metrics.AppReadiness.SetNotReady()
+	}
+	m.degradedReason = reason
+}
@@ -0,0 +1,136 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestIsReadOnlyError(t *testing.T) {
+	assert.False(t, IsReadOnlyError(nil))
+	assert.False(t, IsReadOnlyError(errors.New("connection reset by peer")))
+	assert.True(t, IsReadOnlyError(fmt.Errorf("writing: %w", os.ErrPermission)))
+	assert.True(t, IsReadOnlyError(errors.New("googleapi: Error 403: Forbidden")))
+	assert.True(t, IsReadOnlyError(errors.New("AccessDenied: Access Denied")))
+	assert.True(t, IsReadOnlyError(errors.New("open /data/merged: read-only file system")))
+	assert.True(t, IsReadOnlyError(fmt.Errorf("writing: %w", syscall.EROFS)))
+	assert.True(t, IsReadOnlyError(fmt.Errorf("uploading: %w", awserr.NewRequestFailure(awserr.New("Forbidden", "forbidden", nil), 403, "req"))))
+	assert.True(t, IsReadOnlyError(errors.New("unexpected response: status code: 403")))
+
+	// block numbers and paths holding the markers
+	assert.False(t, IsReadOnlyError(errors.New("merging bundle 403: connection reset by peer")))
+	assert.False(t, IsReadOnlyError(errors.New("downloading 0000014030-0000000000014030a-0000000000014029a-14028-suffix: EOF")))
+	assert.False(t, IsReadOnlyError(errors.New("open /mnt/forbidden/0000000100: no such file or directory")))
+	assert.False(t, IsReadOnlyError(errors.New("writing gs://readonly-archive/0000000403: timeout")))
+	assert.False(t, IsReadOnlyError(errors.New("block #1403 (0000000000001403a) not found")))
+}
+
+func TestMerger_DegradedWhileMergedStoreReadOnly(t *testing.T) {
+	var lock sync.Mutex
+	readOnly := true
+	var merged []uint64
+	mio := &TestMergerIO{
+		MergeAndStoreFunc: func(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+			lock.Lock()
+			defer lock.Unlock()
+			if readOnly {
+				return errors.New("permission denied")
+			}
+			merged = append(merged, inclusiveLowerBlock)
+			return nil
+		},
+	}
+
+	m := NewMerger(testLogger, "6969", mio, 1, 100, 100, time.Second, time.Second, 0,
+		WithDegradedProbeInterval(10*time.Millisecond),
+	)
+	defer m.Shutdown(nil)
+
+	m.bundler.inProcess.Lock()
+	go func() {
+		defer m.bundler.inProcess.Unlock()
+		if err := m.bundler.mergeAndStore(100, []*bstream.OneBlockFile{block100}); err != nil {
+			m.bundler.bundleError <- err
+		}
+	}()
+
+	require.Eventually(t, func() bool { return m.DegradedReason() != "" }, time.Second, 5*time.Millisecond)
+	assert.Contains(t, m.DegradedReason(), "permission denied")
+	m.bundler.Lock()
+	assert.True(t, m.bundler.degraded, "ProcessBlock does not set the merger ready")
+	m.bundler.Unlock()
+	resp, err := m.Check(context.Background(), &pbhealth.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	lock.Lock()
+	readOnly = false
+	lock.Unlock()
+
+	require.Eventually(t, func() bool { return m.DegradedReason() == "" }, time.Second, 5*time.Millisecond)
+	m.bundler.inProcess.Lock()
+	m.bundler.inProcess.Unlock()
+	assert.Equal(t, []uint64{100}, merged)
+
+	resp, err = m.Check(context.Background(), &pbhealth.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, pbhealth.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func TestDStoreIO_ProbeWriteEveryMergedStore(t *testing.T) {
+	var written []string
+	newStore := func(name string) dstore.Store {
+		return dstore.NewMockStore(func(base string, f io.Reader) error {
+			written = append(written, name)
+			return nil
+		})
+	}
+	readOnly := dstore.NewMockStore(func(base string, f io.Reader) error {
+		return errors.New("permission denied")
+	})
+
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), newStore("default"), nil, 0, 0, 100,
+		WithMergedBlocksStoreRange(0, 1000, newStore("archive")),
+	).(*DStoreIO)
+	require.NoError(t, mio.ProbeWrite(context.Background()))
+	assert.Equal(t, []string{"default", "archive"}, written)
+
+	mio = NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), newStore("default"), nil, 0, 0, 100,
+		WithMergedBlocksStoreRange(0, 1000, readOnly),
+	).(*DStoreIO)
+	assert.Error(t, mio.ProbeWrite(context.Background()), "a read-only range store keeps the merger degraded")
+}
+
+func TestMerger_DegradedDisabled(t *testing.T) {
+	mio := &TestMergerIO{
+		MergeAndStoreFunc: func(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+			return errors.New("permission denied")
+		},
+	}
+	m := NewMerger(testLogger, "6969", mio, 1, 100, 100, time.Second, time.Second, 0,
+		WithDegradedProbeInterval(0),
+	)
+	err := m.bundler.mergeAndStore(100, []*bstream.OneBlockFile{block100})
+	require.Error(t, err)
+	assert.Empty(t, m.DegradedReason())
+}
+
+func TestIsHeldMergeError(t *testing.T) {
+	assert.True(t, isHeldMergeError(errors.New("AccessDenied: Access Denied")))
+	assert.True(t, isHeldMergeError(fmt.Errorf("write object error: %w", &StoreDomainError{Domain: StoreDomainDestination, Err: ErrCircuitOpen})))
+	assert.False(t, isHeldMergeError(&StoreDomainError{Domain: StoreDomainSource, Err: ErrCircuitOpen}), "one-block files are downloaded again on the next merge")
+	assert.False(t, isHeldMergeError(errors.New("connection reset by peer")))
+}
@@ -0,0 +1,226 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// ConfirmDeletionFilename is the file of the confirmation store that confirms a pending deletion, its content being the token of the deletion
+var ConfirmDeletionFilename = "merger-confirm-deletion"
+
+// PendingDeletion is a deletion held by the safety valve, until the operator confirms it with its Token
+type PendingDeletion struct {
+	Source       string    `json:"source"`
+	Files        int       `json:"files"`
+	LowestBlock  uint64    `json:"lowest_block"`
+	HighestBlock uint64    `json:"highest_block"`
+	Token        string    `json:"token"`
+	Since        time.Time `json:"since"`
+}
+
+// WithDeleteSafetyValve holds any deletion of more than `maxFiles` one-block files in a single cycle (old files pruning,
+// files below the first streamable block) until the operator confirms it, either through the admin API
+// (POST `/confirm-deletion?token=<token>`) or by writing the token to ConfirmDeletionFilename in `confirmStore` (nil disables
+// that). The pending deletion and its token are shown in the admin status. A confirmation allows the deletions of that source
+// up to the highest block of the pending deletion, the files above it are held again if they are too many.
+func WithDeleteSafetyValve(maxFiles int, confirmStore dstore.Store) Option {
+	return func(m *Merger) {
+		m.deleteValve = &deleteValve{
+			maxFiles:     maxFiles,
+			confirmStore: confirmStore,
+			pending:      make(map[string]*PendingDeletion),
+			approvedUpTo: make(map[string]uint64),
+		}
+	}
+}
+
+type deleteValve struct {
+	sync.Mutex
+	maxFiles     int
+	confirmStore dstore.Store
+	pending      map[string]*PendingDeletion // by source
+	approvedUpTo map[string]uint64           // by source
+}
+
+func deletionToken(source string, highestBlock uint64) string {
+	return fmt.Sprintf("%s:%d", source, highestBlock)
+}
+
+// allowDeletion returns the files that can be deleted by `source`, holding the others if they are too many
+func (m *Merger) allowDeletion(ctx context.Context, source string, files []*bstream.OneBlockFile) []*bstream.OneBlockFile {
+	v := m.deleteValve
+	if v == nil || len(files) == 0 {
+		return files
+	}
+
+	m.checkConfirmationFile(ctx)
+
+	v.Lock()
+	defer v.Unlock()
+	approvedUpTo, hasApproval := v.approvedUpTo[source]
+	var allowed, held []*bstream.OneBlockFile
+	for _, obf := range files {
+		if hasApproval && obf.Num <= approvedUpTo {
+			allowed = append(allowed, obf)
+			continue
+		}
+		held = append(held, obf)
+	}
+	if countFiles(held) <= v.maxFiles {
+		delete(v.pending, source)
+		metrics.DeletionsAwaitingConfirmation.SetFloat64(float64(v.pendingFiles()))
+		return append(allowed, held...)
+	}
+
+	pending := &PendingDeletion{
+		Source:      source,
+		Files:       countFiles(held),
+		LowestBlock: held[0].Num,
+		Since:       time.Now(),
+	}
+	for _, obf := range held {
+		if obf.Num < pending.LowestBlock {
+			pending.LowestBlock = obf.Num
+		}
+		if obf.Num > pending.HighestBlock {
+			pending.HighestBlock = obf.Num
+		}
+	}
+	pending.Token = deletionToken(source, pending.HighestBlock)
+	if previous := v.pending[source]; previous != nil && previous.Token == pending.Token {
+		pending.Since = previous.Since
+	} else {
+		m.logger.Warn("deletion held by the safety valve, waiting for the operator to confirm it",
+			zap.String("source", source),
+			zap.Int("files", pending.Files),
+			zap.Int("max_files", v.maxFiles),
+			zap.Uint64("lowest_block", pending.LowestBlock),
+			zap.Uint64("highest_block", pending.HighestBlock),
+			zap.String("token", pending.Token),
+		)
+	}
+	v.pending[source] = pending
+	metrics.DeletionsAwaitingConfirmation.SetFloat64(float64(v.pendingFiles()))
+	return allowed
+}
+
+// pendingFiles must be called with the lock held
+func (v *deleteValve) pendingFiles() (count int) {
+	for _, pending := range v.pending {
+		count += pending.Files
+	}
+	return
+}
+
+func countFiles(files []*bstream.OneBlockFile) (count int) {
+	for _, obf := range files {
+		count += len(obf.Filenames)
+	}
+	return
+}
+
+// ConfirmDeletion allows the pending deletion with that token to proceed on the next cycle
+func (m *Merger) ConfirmDeletion(token string) error {
+	v := m.deleteValve
+	if v == nil {
+		return errors.New("no delete safety valve configured")
+	}
+	v.Lock()
+	defer v.Unlock()
+	for source, pending := range v.pending {
+		if pending.Token != token {
+			continue
+		}
+		v.approvedUpTo[source] = pending.HighestBlock
+		delete(v.pending, source)
+		metrics.DeletionsAwaitingConfirmation.SetFloat64(float64(v.pendingFiles()))
+		m.logger.Info("deletion confirmed by the operator", zap.String("source", source), zap.String("token", token))
+		return nil
+	}
+	return fmt.Errorf("no pending deletion with token %q", token)
+}
+
+// PendingDeletions returns the deletions held by the safety valve
+func (m *Merger) PendingDeletions() (out []*PendingDeletion) {
+	v := m.deleteValve
+	if v == nil {
+		return nil
+	}
+	v.Lock()
+	defer v.Unlock()
+	for _, pending := range v.pending {
+		p := *pending
+		out = append(out, &p)
+	}
+	return
+}
+
+// checkConfirmationFile confirms the pending deletion whose token is found in the confirmation file, then removes the file
+func (m *Merger) checkConfirmationFile(ctx context.Context) {
+	store := m.deleteValve.confirmStore
+	if store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, GetObjectTimeout)
+	defer cancel()
+
+	reader, err := store.OpenObject(ctx, ConfirmDeletionFilename)
+	if err != nil {
+		if !errors.Is(err, dstore.ErrNotFound) {
+			m.logger.Warn("cannot read deletion confirmation file", zap.Error(err))
+		}
+		return
+	}
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		m.logger.Warn("cannot read deletion confirmation file", zap.Error(err))
+		return
+	}
+
+	token := strings.TrimSpace(string(content))
+	if err := m.ConfirmDeletion(token); err != nil {
+		m.logger.Debug("deletion confirmation file does not match a pending deletion", zap.Error(err))
+		return
+	}
+	if err := store.DeleteObject(ctx, ConfirmDeletionFilename); err != nil {
+		m.logger.Warn("cannot delete deletion confirmation file", zap.Error(err))
+	}
+}
+
+func (m *Merger) confirmDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := m.ConfirmDeletion(r.URL.Query().Get("token")); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	m.writeAdminStatus(w)
+}
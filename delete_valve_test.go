@@ -0,0 +1,63 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func valveTestFiles(from, to uint64) (out []*bstream.OneBlockFile) {
+	for num := from; num <= to; num++ {
+		out = append(out, mustNewOneBlockFile(fmt.Sprintf("%010d-%016xa-%016xa-%d-suffix", num, num, num-1, num-1)))
+	}
+	return
+}
+
+func TestDeleteSafetyValve(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithDeleteSafetyValve(3, nil))
+	ctx := context.Background()
+
+	assert.Len(t, m.allowDeletion(ctx, "old_files", valveTestFiles(100, 102)), 3, "under the limit")
+
+	assert.Empty(t, m.allowDeletion(ctx, "old_files", valveTestFiles(100, 104)), "over the limit, held")
+	pending := m.PendingDeletions()
+	require.Len(t, pending, 1)
+	assert.Equal(t, 5, pending[0].Files)
+	assert.Equal(t, uint64(100), pending[0].LowestBlock)
+	assert.Equal(t, "old_files:104", pending[0].Token)
+
+	assert.Error(t, m.ConfirmDeletion("old_files:105"))
+	require.NoError(t, m.ConfirmDeletion("old_files:104"))
+	assert.Empty(t, m.PendingDeletions())
+
+	assert.Len(t, m.allowDeletion(ctx, "old_files", valveTestFiles(100, 106)), 7, "confirmed files, and few enough others")
+	assert.Len(t, m.allowDeletion(ctx, "old_files", valveTestFiles(100, 108)), 5, "files above the confirmed ones held again")
+	assert.Empty(t, m.allowDeletion(ctx, "below_lowest_block", valveTestFiles(10, 20)), "confirmations are per source")
+	assert.Len(t, m.PendingDeletions(), 2)
+}
+
+func TestDeleteSafetyValveConfirmationFile(t *testing.T) {
+	store := dstore.NewMockStore(nil)
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithDeleteSafetyValve(1, store))
+	ctx := context.Background()
+
+	assert.Empty(t, m.allowDeletion(ctx, "old_files", valveTestFiles(100, 101)))
+
+	store.SetFile(ConfirmDeletionFilename, []byte("old_files:101\n"))
+	assert.Len(t, m.allowDeletion(ctx, "old_files", valveTestFiles(100, 101)), 2)
+	exists, err := store.FileExists(ctx, ConfirmDeletionFilename)
+	require.NoError(t, err)
+	assert.False(t, exists, "confirmation file is consumed")
+}
+
+func TestDeleteSafetyValveDisabled(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	assert.Len(t, m.allowDeletion(context.Background(), "old_files", valveTestFiles(100, 104)), 5)
+	assert.Error(t, m.ConfirmDeletion("old_files:104"))
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+// DeleterPool runs the deletions of the one-block and forked files of several DStoreIO (see WithDeleterPool) on a
+// fixed number of workers, e.g. for the chains of a MultiMerger, instead of DefaultFilesDeleteThreads workers for
+// each of their stores.
+type DeleterPool struct {
+	jobs chan pooledDeletion
+}
+
+type pooledDeletion struct {
+	od   *oneBlockFilesDeleter
+	file string
+}
+
+// NewDeleterPool starts `threads` deletion workers, they run for the lifetime of the process
+func NewDeleterPool(threads int) *DeleterPool {
+	if threads <= 0 {
+		threads = DefaultFilesDeleteThreads
+	}
+	p := &DeleterPool{jobs: make(chan pooledDeletion)}
+	for i := 0; i < threads; i++ {
+		go p.process()
+	}
+	return p
+}
+
+func (p *DeleterPool) process() {
+	for job := range p.jobs {
+		job.od.deleteObject(job.file)
+	}
+}
+
+// WithDeleterPool deletes the files on the workers of a pool shared with other DStoreIO. Each store keeps its own
+// queue, retries and deletion rate (see WithDeletionRate), only the workers are shared.
+func WithDeleterPool(pool *DeleterPool) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.deleterPool = pool
+	}
+}
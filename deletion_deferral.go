@@ -0,0 +1,107 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+)
+
+// WithDeletionDeferral keeps the one-block files of a merged bundle until `confirmations` more bundles are merged on
+// top of it and, with a `delay`, for at least that long after its merge: a safety window where a bad merge can be
+// redone from the original files. By default, the files of a bundle are pruned once the next bundle is merged
+// (1 confirmation, no delay). The bundles merged before a restart count their delay from the restart.
+func WithDeletionDeferral(confirmations uint64, delay time.Duration) Option {
+	return func(m *Merger) {
+		if confirmations == 0 {
+			confirmations = 1
+		}
+		m.deletionDeferral = &deletionDeferral{
+			confirmations: confirmations,
+			delay:         delay,
+			mergedAt:      make(map[uint64]time.Time),
+		}
+		if delay == 0 {
+			return
+		}
+		m.OnBundleMerged(func(lowBlockNum uint64, _ []*bstream.OneBlockFile) {
+			m.deletionDeferral.merged(lowBlockNum, m.clock.Now())
+		})
+	}
+}
+
+type deletionDeferral struct {
+	sync.Mutex
+	confirmations uint64
+	delay         time.Duration
+	since         time.Time            // of the bundles without a merge time, merged before the start, set by applySettings
+	mergedAt      map[uint64]time.Time // by base block, of the bundles still held by the delay
+}
+
+func (d *deletionDeferral) merged(baseBlock uint64, now time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	d.mergedAt[baseBlock] = now
+}
+
+// delayed lowers the exclusive pruning `target` to the end of the highest bundle below it merged more than delay ago
+func (d *deletionDeferral) delayed(target, bundleSize uint64, now time.Time) uint64 {
+	d.Lock()
+	defer d.Unlock()
+
+	var bases []uint64
+	for base := range d.mergedAt {
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] > bases[j] })
+
+	out := uint64(0)
+	for _, base := range bases {
+		if base+bundleSize <= target && !now.Before(d.mergedAt[base].Add(d.delay)) {
+			out = base + bundleSize
+			break
+		}
+	}
+	if out == 0 && !now.Before(d.since.Add(d.delay)) {
+		// the bundles merged before the start are old enough, up to the oldest one merged since
+		out = target
+		if len(bases) != 0 && bases[len(bases)-1] < out {
+			out = bases[len(bases)-1]
+		}
+	}
+
+	for _, base := range bases {
+		if base+bundleSize <= out {
+			delete(d.mergedAt, base)
+		}
+	}
+	return out
+}
+
+// oldFilesPruningTarget is the block below which the one-block files are pruned, 0 when none is
+func (m *Merger) oldFilesPruningTarget() uint64 {
+	d := m.deletionDeferral
+	if d == nil {
+		return m.pruningTarget(m.bundler.bundleSize)
+	}
+	target := m.pruningTarget(d.confirmations * m.bundler.bundleSize)
+	if target == 0 || d.delay == 0 {
+		return target
+	}
+	return d.delayed(target, m.bundler.bundleSize, m.clock.Now())
+}
@@ -0,0 +1,39 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletionDeferral_Confirmations(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	m.bundler.baseBlockNum = 1000
+	assert.Equal(t, uint64(900), m.oldFilesPruningTarget())
+
+	m = NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithDeletionDeferral(3, 0))
+	m.bundler.baseBlockNum = 1000
+	assert.Equal(t, uint64(700), m.oldFilesPruningTarget())
+}
+
+func TestDeletionDeferral_Delay(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: t0}
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithDeletionDeferral(1, time.Hour))
+	m.clock = clock
+	m.deletionDeferral.since = t0
+	m.bundler.baseBlockNum = 1000
+
+	assert.Equal(t, uint64(0), m.oldFilesPruningTarget(), "files from before the start are held for the delay too")
+
+	m.deletionDeferral.merged(800, t0.Add(10*time.Minute))
+	m.deletionDeferral.merged(900, t0.Add(50*time.Minute))
+
+	clock.now = t0.Add(65 * time.Minute)
+	assert.Equal(t, uint64(800), m.oldFilesPruningTarget(), "only the bundles merged before the start are old enough")
+
+	clock.now = t0.Add(75 * time.Minute)
+	assert.Equal(t, uint64(900), m.oldFilesPruningTarget())
+	assert.Len(t, m.deletionDeferral.mergedAt, 1)
+}
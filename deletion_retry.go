@@ -0,0 +1,120 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// maxDeadLetters bounds the dead-letter list, the oldest entries are dropped first
+const maxDeadLetters = 1000
+
+// DeletionFailure is a file that could not be deleted
+type DeletionFailure struct {
+	File      string    `json:"file"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	LastTry   time.Time `json:"last_try"`
+}
+
+func (od *oneBlockFilesDeleter) recordFailure(file string, err error) {
+	od.failuresLock.Lock()
+	defer od.failuresLock.Unlock()
+	if od.failures == nil {
+		od.failures = make(map[string]*DeletionFailure)
+	}
+
+	failure, found := od.failures[file]
+	if !found {
+		failure = &DeletionFailure{File: file}
+		od.failures[file] = failure
+	}
+	failure.Attempts++
+	failure.LastError = err.Error()
+	failure.LastTry = time.Now()
+
+	if failure.Attempts < od.retryAttempts {
+		metrics.DeletionRetries.Inc()
+		od.logger.Debug("cannot delete file, will retry", zap.String("file", file), zap.Int("attempts", failure.Attempts), zap.Error(err))
+		return
+	}
+
+	od.logger.Warn("cannot delete file, giving up", zap.String("file", file), zap.Int("attempts", failure.Attempts), zap.Error(err))
+	od.breaker.record(false)
+	delete(od.failures, file)
+	od.deadLetters = append(od.deadLetters, failure)
+	if len(od.deadLetters) > maxDeadLetters {
+		od.deadLetters = od.deadLetters[len(od.deadLetters)-maxDeadLetters:]
+	}
+	metrics.DeletionDeadLetters.Inc()
+}
+
+func (od *oneBlockFilesDeleter) clearFailure(file string) {
+	od.breaker.record(true)
+	od.failuresLock.Lock()
+	defer od.failuresLock.Unlock()
+	delete(od.failures, file)
+}
+
+// processRetries re-queues failed deletions every retryCooldown, without ever blocking on a full queue
+func (od *oneBlockFilesDeleter) processRetries() {
+	interval := od.retryCooldown
+	if interval <= 0 {
+		interval = DeletionRetryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-od.closing:
+			return
+		}
+		od.failuresLock.Lock()
+		var files []string
+		for file := range od.failures {
+			files = append(files, file)
+		}
+		od.failuresLock.Unlock()
+
+	queueing:
+		for _, file := range files {
+			select {
+			case od.toProcess <- file:
+			default:
+				break queueing // queue is full, next tick
+			}
+		}
+	}
+}
+
+func (od *oneBlockFilesDeleter) DeadLetters() []*DeletionFailure {
+	od.failuresLock.Lock()
+	defer od.failuresLock.Unlock()
+	return append([]*DeletionFailure{}, od.deadLetters...)
+}
+
+// DeadLetters returns the one-block files that could not be deleted after DeletionAttempts, most recent last
+func (s *DStoreIO) DeadLetters() []*DeletionFailure {
+	return s.od.DeadLetters()
+}
+
+// DeadLetters also includes the forked blocks that could not be deleted
+func (s *ForkAwareDStoreIO) DeadLetters() []*DeletionFailure {
+	return append(s.DStoreIO.DeadLetters(), s.forkOd.DeadLetters()...)
+}
@@ -0,0 +1,194 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// DefaultCycleLogSize is how many cycles are kept in the cycle log of the diagnostics dumps
+var DefaultCycleLogSize = 100
+
+// CycleLogEntry summarizes one cycle of the merger
+type CycleLogEntry struct {
+	Start          time.Time     `json:"start"`
+	Duration       time.Duration `json:"duration"`
+	BaseBlockNum   uint64        `json:"base_block_num"`
+	FilesWalked    int           `json:"files_walked"`
+	HighestWalked  uint64        `json:"highest_walked"`
+	SeenBlockFiles int           `json:"seen_block_files"`
+	OverBudget     bool          `json:"over_budget,omitempty"`
+	Error          string        `json:"error,omitempty"`
+}
+
+// BundlerSnapshot is the state of the bundler at the time of a diagnostics dump
+type BundlerSnapshot struct {
+	State              MergerState `json:"state"`
+	BaseBlockNum       uint64      `json:"base_block_num"`
+	BundleSize         uint64      `json:"bundle_size"`
+	IrreversibleBlocks []string    `json:"irreversible_blocks"`
+	HeldBlocks         []string    `json:"held_blocks,omitempty"`
+	DegradedReason     string      `json:"degraded_reason,omitempty"`
+}
+
+// WithDiagnostics dumps, every `interval` and when the merger stops on an error, a `.tar.gz` with the bundler
+// snapshot, the log of the last cycles and `config` into `store`, so that post-mortems of crashed pods don't
+// depend on container logs. `config` is written as JSON as-is: secrets must be redacted by the caller.
+// An interval of 0 only dumps on errors.
+func WithDiagnostics(store dstore.Store, interval time.Duration, config interface{}) Option {
+	return func(m *Merger) {
+		m.diagnostics = &diagnostics{
+			store:    store,
+			interval: interval,
+			config:   config,
+			cycles:   &cycleLog{max: DefaultCycleLogSize},
+		}
+	}
+}
+
+type diagnostics struct {
+	store    dstore.Store
+	interval time.Duration
+	config   interface{}
+	cycles   *cycleLog
+}
+
+type cycleLog struct {
+	sync.Mutex
+	entries []*CycleLogEntry
+	max     int
+}
+
+func (l *cycleLog) add(entry *CycleLogEntry) {
+	l.Lock()
+	defer l.Unlock()
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.max {
+		l.entries = l.entries[len(l.entries)-l.max:]
+	}
+}
+
+func (l *cycleLog) snapshot() []*CycleLogEntry {
+	l.Lock()
+	defer l.Unlock()
+	return append([]*CycleLogEntry(nil), l.entries...)
+}
+
+func (m *Merger) recordCycle(entry *CycleLogEntry) {
+	if m.diagnostics != nil {
+		m.diagnostics.cycles.add(entry)
+	}
+}
+
+func (m *Merger) bundlerSnapshot() *BundlerSnapshot {
+	b := m.bundler
+	b.Lock()
+	defer b.Unlock()
+	snapshot := &BundlerSnapshot{
+		State:          m.State(),
+		BaseBlockNum:   b.baseBlockNum,
+		BundleSize:     b.bundleSize,
+		DegradedReason: m.DegradedReason(),
+	}
+	for _, obf := range b.irreversibleBlocks {
+		snapshot.IrreversibleBlocks = append(snapshot.IrreversibleBlocks, obf.CanonicalName)
+	}
+	for _, obf := range b.heldBlocks {
+		snapshot.HeldBlocks = append(snapshot.HeldBlocks, obf.CanonicalName)
+	}
+	return snapshot
+}
+
+func (m *Merger) startDiagnosticsDumper() {
+	if m.diagnostics == nil || m.diagnostics.interval == 0 {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-m.Terminating():
+				return
+			case <-time.After(m.diagnostics.interval):
+			}
+			if err := m.dumpDiagnostics(context.Background(), "periodic", nil); err != nil {
+				m.logger.Warn("cannot dump diagnostics", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// dumpDiagnostics writes `<timestamp>-<reason>.tar.gz` to the diagnostics store
+func (m *Merger) dumpDiagnostics(ctx context.Context, reason string, cause error) error {
+	if m.diagnostics == nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	meta := map[string]interface{}{
+		"reason": reason,
+		"at":     now,
+	}
+	if cause != nil {
+		meta["error"] = cause.Error()
+	}
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range []struct {
+		name    string
+		content interface{}
+	}{
+		{"meta.json", meta},
+		{"bundler.json", m.bundlerSnapshot()},
+		{"cycles.json", m.diagnostics.cycles.snapshot()},
+		{"config.json", m.diagnostics.config},
+	} {
+		data, err := json.MarshalIndent(entry.content, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding %s: %w", entry.name, err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(data)), ModTime: now}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.tar.gz", now.Format("20060102T150405.000000000"), reason)
+	ctx, cancel := context.WithTimeout(ctx, WriteObjectTimeout)
+	defer cancel()
+	if err := m.diagnostics.store.WriteObject(ctx, name, buf); err != nil {
+		return fmt.Errorf("writing %q: %w", name, err)
+	}
+	m.logger.Info("diagnostics dumped", zap.String("name", name), zap.String("reason", reason))
+	return nil
+}
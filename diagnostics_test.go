@@ -0,0 +1,90 @@
+package merger
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCycleLogKeepsLastEntries(t *testing.T) {
+	l := &cycleLog{max: 2}
+	for i := 1; i <= 3; i++ {
+		l.add(&CycleLogEntry{FilesWalked: i})
+	}
+	entries := l.snapshot()
+	require.Len(t, entries, 2)
+	assert.Equal(t, 2, entries[0].FilesWalked)
+	assert.Equal(t, 3, entries[1].FilesWalked)
+}
+
+func TestMerger_DumpDiagnostics(t *testing.T) {
+	var written string
+	files := map[string]string{}
+	store := dstore.NewMockStore(nil)
+	store.WriteObjectFunc = func(ctx context.Context, base string, f io.Reader) error {
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		written = base
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			content, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			files[hdr.Name] = string(content)
+		}
+	}
+
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0,
+		WithDiagnostics(store, 0, map[string]string{"one_blocks": "gs://bucket/one"}),
+	)
+	m.recordCycle(&CycleLogEntry{FilesWalked: 1, HighestWalked: 100})
+
+	require.NoError(t, m.dumpDiagnostics(context.Background(), "fatal", errors.New("boom")))
+	assert.True(t, strings.HasSuffix(written, "-fatal.tar.gz"), written)
+	require.Len(t, files, 4)
+
+	assert.Contains(t, files["meta.json"], `"error": "boom"`)
+	assert.Contains(t, files["config.json"], "gs://bucket/one")
+
+	var cycles []*CycleLogEntry
+	require.NoError(t, json.Unmarshal([]byte(files["cycles.json"]), &cycles))
+	require.Len(t, cycles, 1)
+	assert.Equal(t, uint64(100), cycles[0].HighestWalked)
+
+	var snapshot BundlerSnapshot
+	require.NoError(t, json.Unmarshal([]byte(files["bundler.json"]), &snapshot))
+	assert.Equal(t, uint64(100), snapshot.BaseBlockNum)
+	assert.Equal(t, uint64(100), snapshot.BundleSize)
+}
+
+func TestMerger_DumpDiagnosticsDisabled(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	m.recordCycle(&CycleLogEntry{})
+	assert.NoError(t, m.dumpDiagnostics(context.Background(), "periodic", nil))
+}
@@ -0,0 +1,214 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// DownloadFailure is a one-block file whose download kept failing, e.g. on permissions or a corrupt object
+type DownloadFailure struct {
+	File      string    `json:"file"`
+	BlockNum  uint64    `json:"block_num"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	LastTry   time.Time `json:"last_try"`
+	Copied    bool      `json:"copied"` // to the dead-letter store
+}
+
+// WithDownloadDeadLetters gives up on a one-block file once its download failed `maxAttempts` times (after the
+// retries of the retry policy, if any), instead of retrying the bundle forever: the file moves to the dead-letter list
+// (see DownloadDeadLetters) and the bundle is merged without it, as long as its blocks still link together across it.
+// When they do not, or when no block links past it (the last block of the bundle, or the kept last block of the
+// previous one), the merge keeps failing on a data error, which WithPoisonRanges can isolate. When `copyStore` is set, each
+// dead-lettered object is copied there on a best-effort basis, under the same name.
+func WithDownloadDeadLetters(maxAttempts int, copyStore dstore.Store) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.downloadDeadLetters = &downloadDeadLetters{
+			maxAttempts: maxAttempts,
+			copyStore:   copyStore,
+			failures:    make(map[string]*DownloadFailure),
+			dead:        make(map[string]*DownloadFailure),
+		}
+	}
+}
+
+type downloadDeadLetters struct {
+	maxAttempts int
+	copyStore   dstore.Store
+
+	lock     sync.Mutex
+	failures map[string]*DownloadFailure // by canonical name, not given up on yet
+	dead     map[string]*DownloadFailure // by canonical name
+	order    []string                    // of the dead canonical names, oldest first
+}
+
+// recordDownload counts the failed downloads of oneBlockFile, dead-lettering it after maxAttempts, and forgets
+// them once it downloads. Cancellations are not failures of the file.
+func (s *DStoreIO) recordDownload(ctx context.Context, oneBlockFile *bstream.OneBlockFile, err error) {
+	d := s.downloadDeadLetters
+	if err == nil {
+		d.lock.Lock()
+		delete(d.failures, oneBlockFile.CanonicalName)
+		d.lock.Unlock()
+		return
+	}
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrCircuitOpen) {
+		return
+	}
+
+	d.lock.Lock()
+	failure, found := d.failures[oneBlockFile.CanonicalName]
+	if !found {
+		failure = &DownloadFailure{File: oneBlockFile.CanonicalName, BlockNum: oneBlockFile.Num}
+		d.failures[oneBlockFile.CanonicalName] = failure
+	}
+	failure.Attempts++
+	failure.LastError = err.Error()
+	failure.LastTry = time.Now()
+	if failure.Attempts < d.maxAttempts {
+		d.lock.Unlock()
+		s.logger.Debug("cannot download one-block file, will retry", zap.String("file", failure.File), zap.Int("attempts", failure.Attempts), zap.Error(err))
+		return
+	}
+	delete(d.failures, oneBlockFile.CanonicalName)
+	d.lock.Unlock()
+
+	if d.copyStore != nil {
+		failure.Copied = s.copyDeadLetter(oneBlockFile)
+	}
+
+	d.lock.Lock()
+	if _, found := d.dead[failure.File]; !found {
+		d.order = append(d.order, failure.File)
+	}
+	d.dead[failure.File] = failure
+	if len(d.order) > maxDeadLetters {
+		delete(d.dead, d.order[0])
+		d.order = d.order[1:]
+	}
+	d.lock.Unlock()
+
+	metrics.DownloadDeadLetters.Inc()
+	s.logger.Warn("cannot download one-block file, giving up on it",
+		zap.String("file", failure.File),
+		zap.Int("attempts", failure.Attempts),
+		zap.Bool("copied", failure.Copied),
+		zap.Error(err),
+	)
+}
+
+// copyDeadLetter copies any readable copy of oneBlockFile to the dead-letter store
+func (s *DStoreIO) copyDeadLetter(oneBlockFile *bstream.OneBlockFile) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), WriteObjectTimeout)
+	defer cancel()
+	for filename := range oneBlockFile.Filenames {
+		reader, err := s.oneBlocksStore.OpenObject(ctx, filename)
+		if err != nil {
+			s.logger.Debug("cannot open dead-lettered one-block file", zap.String("file_name", filename), zap.Error(err))
+			continue
+		}
+		err = s.downloadDeadLetters.copyStore.WriteObject(ctx, filename, reader)
+		reader.Close()
+		if err != nil {
+			s.logger.Debug("cannot copy dead-lettered one-block file", zap.String("file_name", filename), zap.Error(err))
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// skip removes the dead-lettered files from `files`, the ones of the bundle. It fails unless each of them is provably
+// replaced: by another block at the same height, or by remaining blocks on both sides of it, which link together
+// without it, with the kept last block of the previous bundle among `all`.
+func (d *downloadDeadLetters) skip(all, files []*bstream.OneBlockFile) ([]*bstream.OneBlockFile, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.dead) == 0 {
+		return files, nil
+	}
+
+	var skipped []string
+	var dead, linked []*bstream.OneBlockFile
+	for _, obf := range all {
+		if _, found := d.dead[obf.CanonicalName]; found {
+			skipped = append(skipped, obf.CanonicalName)
+			dead = append(dead, obf)
+			continue
+		}
+		linked = append(linked, obf)
+	}
+	if len(skipped) == 0 {
+		return files, nil
+	}
+	for _, obf := range dead {
+		if !replaced(obf, linked) {
+			return nil, fmt.Errorf("dead-lettered one-block file %s cannot be skipped: no remaining block links past it", obf.CanonicalName)
+		}
+	}
+	if err := validateLinkage(linked); err != nil {
+		return nil, fmt.Errorf("dead-lettered one-block files %v cannot be skipped: %w", skipped, err)
+	}
+
+	var out []*bstream.OneBlockFile
+	for _, obf := range files {
+		if _, found := d.dead[obf.CanonicalName]; !found {
+			out = append(out, obf)
+		}
+	}
+	return out, nil
+}
+
+// replaced returns true when `linked` holds another block at the height of `obf`, or blocks below and above it
+func replaced(obf *bstream.OneBlockFile, linked []*bstream.OneBlockFile) bool {
+	var below, above bool
+	for _, other := range linked {
+		switch {
+		case other.Num == obf.Num:
+			return true
+		case other.Num < obf.Num:
+			below = true
+		default:
+			above = true
+		}
+	}
+	return below && above
+}
+
+// DownloadDeadLetters returns the one-block files given up on after failing to download, oldest first
+func (s *DStoreIO) DownloadDeadLetters() []*DownloadFailure {
+	d := s.downloadDeadLetters
+	if d == nil {
+		return nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	out := make([]*DownloadFailure, 0, len(d.order))
+	for _, name := range d.order {
+		copied := *d.dead[name]
+		out = append(out, &copied)
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadDeadLetters(t *testing.T) {
+	var opened int
+	oneBlockStore := dstore.NewMockStore(nil)
+	oneBlockStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		if opened++; opened <= 2 {
+			return nil, errors.New("permission denied")
+		}
+		return ioutil.NopCloser(strings.NewReader("data")), nil
+	}
+	var copied []string
+	copyStore := dstore.NewMockStore(func(base string, f io.Reader) error {
+		copied = append(copied, base)
+		return nil
+	})
+	s := NewDStoreIO(testLogger, testTracer, oneBlockStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithDownloadDeadLetters(2, copyStore)).(*DStoreIO)
+
+	_, err := s.DownloadOneBlockFile(context.Background(), block101)
+	require.Error(t, err)
+	assert.Empty(t, s.DownloadDeadLetters())
+
+	_, err = s.DownloadOneBlockFile(context.Background(), block101)
+	require.Error(t, err)
+	deadLetters := s.DownloadDeadLetters()
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, block101.CanonicalName, deadLetters[0].File)
+	assert.EqualValues(t, 101, deadLetters[0].BlockNum)
+	assert.Equal(t, 2, deadLetters[0].Attempts)
+	assert.True(t, deadLetters[0].Copied)
+	assert.Equal(t, []string{"0000000101-0000000000000101a-0000000000000100a-99-suffix"}, copied)
+
+	// no block of the bundle links past the last one, it is not dropped
+	_, err = s.downloadDeadLetters.skip([]*bstream.OneBlockFile{block100, block101}, []*bstream.OneBlockFile{block100, block101})
+	assert.Error(t, err)
+
+	// block 102 links to it
+	_, err = s.downloadDeadLetters.skip([]*bstream.OneBlockFile{block100, block101, block102Final100}, []*bstream.OneBlockFile{block100, block101, block102Final100})
+	assert.Error(t, err)
+
+	// a fork block, the remaining ones link together without it
+	fork101 := mustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix")
+	block102 := mustNewOneBlockFile("0000000102-0000000000000102a-0000000000000101b-100-suffix")
+	files, err := s.downloadDeadLetters.skip([]*bstream.OneBlockFile{block100, block101, fork101, block102}, []*bstream.OneBlockFile{block100, block101, fork101, block102})
+	require.NoError(t, err)
+	assert.Equal(t, []*bstream.OneBlockFile{block100, fork101, block102}, files)
+}
+
+func TestDownloadDeadLetters_KeepsPreviousBundleHead(t *testing.T) {
+	s := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), dstore.NewMockStore(nil), nil, 0, 0, 100, WithDownloadDeadLetters(1, nil)).(*DStoreIO)
+	s.downloadDeadLetters.dead[block99.CanonicalName] = &DownloadFailure{File: block99.CanonicalName}
+
+	_, err := s.downloadDeadLetters.skip([]*bstream.OneBlockFile{block99, block100, block101}, []*bstream.OneBlockFile{block100, block101})
+	assert.Error(t, err, "no block of the previous bundle links to the first one")
+}
+
+func TestDownloadDeadLetters_IgnoresCancellation(t *testing.T) {
+	oneBlockStore := dstore.NewMockStore(nil)
+	oneBlockStore.OpenObjectFunc = func(ctx context.Context, name string) (io.ReadCloser, error) {
+		return nil, ctx.Err()
+	}
+	s := NewDStoreIO(testLogger, testTracer, oneBlockStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithDownloadDeadLetters(1, nil)).(*DStoreIO)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.DownloadOneBlockFile(ctx, block100)
+	require.Error(t, err)
+	assert.Empty(t, s.DownloadDeadLetters())
+}
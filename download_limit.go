@@ -0,0 +1,61 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+)
+
+// WithMaxConcurrentDownloads caps the downloads from the stores running at once, whatever asks for them: prefetch and
+// read-ahead of the bundles, merged files read back (verification, observer, bootstrap), archiving and replication.
+// Each feature keeps its own concurrency, this limit is shared by all of them to keep the total read parallelism
+// within the limits of the storage provider. The time waited for a slot is exported as
+// `merger_download_queue_wait_seconds`. 0 (the default) means no limit.
+func WithMaxConcurrentDownloads(max int) DStoreIOOption {
+	return func(s *DStoreIO) {
+		if max > 0 {
+			s.downloads = &downloadLimiter{slots: make(chan struct{}, max)}
+		}
+	}
+}
+
+// downloadLimiter is a semaphore shared by all the downloads of a DStoreIO, nil-safe
+type downloadLimiter struct {
+	slots chan struct{}
+}
+
+func noopRelease() {}
+
+// acquire waits for a download slot, the returned func releases it
+func (l *downloadLimiter) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return noopRelease, nil
+	}
+	start := time.Now()
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	metrics.DownloadQueueWait.ObserveSince(start)
+	metrics.DownloadsInFlight.Inc()
+	return func() {
+		metrics.DownloadsInFlight.Dec()
+		<-l.slots
+	}, nil
+}
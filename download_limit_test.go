@@ -0,0 +1,49 @@
+package merger
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDStoreIO_MaxConcurrentDownloads(t *testing.T) {
+	files := testPrefetchFiles(10)
+
+	var running, maxRunning int32
+	oneBlocksStore := dstore.NewMockStore(nil)
+	oneBlocksStore.OpenObjectFunc = func(_ context.Context, _ string) (io.ReadCloser, error) {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return ioutil.NopCloser(strings.NewReader("0123456789")), nil
+	}
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithMaxConcurrentDownloads(2)).(*DStoreIO)
+
+	require.NoError(t, PrefetchData(context.Background(), files, mio.DownloadOneBlockFile, 5, 0))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&maxRunning), "the prefetch concurrency is capped by the shared limit")
+
+	release, err := mio.downloads.acquire(context.Background())
+	require.NoError(t, err)
+	release2, err := mio.downloads.acquire(context.Background())
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = mio.DownloadOneBlockFile(ctx, files[0])
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "waiting for a slot is bounded by the context")
+	release()
+	release2()
+}
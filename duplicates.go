@@ -0,0 +1,131 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+)
+
+// DuplicatePolicy picks, among the one-block files written for the same block by several producers (one per
+// suffix), the one whose payload is merged. The other copies are not merged, they are deleted with the old files.
+type DuplicatePolicy struct {
+	name     string
+	suffixes []string // by preference, for DuplicatePreferSuffixes
+}
+
+var (
+	// DuplicateFirstSeen merges the copy listed first, the default
+	DuplicateFirstSeen = DuplicatePolicy{name: "first_seen"}
+	// DuplicateNewest merges the copy with the newest timestamp in its filename, a copy without timestamp never wins
+	DuplicateNewest = DuplicatePolicy{name: "newest"}
+)
+
+// DuplicatePreferSuffixes merges the copy of the producer coming first in `suffixes`, then the first one listed
+func DuplicatePreferSuffixes(suffixes ...string) DuplicatePolicy {
+	return DuplicatePolicy{name: "suffixes", suffixes: suffixes}
+}
+
+// ParseDuplicatePolicy parses `first_seen`, `newest` or `suffixes:<suffix>,<suffix>...`
+func ParseDuplicatePolicy(in string) (DuplicatePolicy, error) {
+	switch {
+	case in == "" || in == DuplicateFirstSeen.name:
+		return DuplicateFirstSeen, nil
+	case in == DuplicateNewest.name:
+		return DuplicateNewest, nil
+	case strings.HasPrefix(in, "suffixes:") && len(in) > len("suffixes:"):
+		return DuplicatePreferSuffixes(strings.Split(strings.TrimPrefix(in, "suffixes:"), ",")...), nil
+	}
+	return DuplicatePolicy{}, fmt.Errorf("invalid duplicate policy %q, expecting one of: first_seen, newest, suffixes:<suffix>,<suffix>...", in)
+}
+
+func (p DuplicatePolicy) String() string {
+	if p.name == "suffixes" {
+		return "suffixes:" + strings.Join(p.suffixes, ",")
+	}
+	return p.name
+}
+
+// WithDuplicatePolicy picks the copy merged when several producers wrote the same block, see DuplicatePolicy. The
+// copies found are counted in `merger_duplicate_one_block_files`.
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(m *Merger) {
+		m.bundler.duplicatePolicy = policy
+	}
+}
+
+// prefers tells if `candidate` must be merged instead of `current`, copies of the same block
+func (p DuplicatePolicy) prefers(candidate, current *bstream.OneBlockFile) bool {
+	switch p.name {
+	case DuplicateNewest.name:
+		candidateTime, currentTime := filenameTime(candidate), filenameTime(current)
+		return candidateTime.After(currentTime)
+	case "suffixes":
+		return p.rank(candidate) < p.rank(current)
+	}
+	return false
+}
+
+// rank is the position of the best suffix of `obf` in the preferred suffixes, len(suffixes) if none
+func (p DuplicatePolicy) rank(obf *bstream.OneBlockFile) int {
+	best := len(p.suffixes)
+	for filename := range obf.Filenames {
+		suffix := filename[strings.LastIndexByte(filename, '-')+1:]
+		for i, preferred := range p.suffixes {
+			if i < best && suffix == preferred {
+				best = i
+			}
+		}
+	}
+	return best
+}
+
+func filenameTime(obf *bstream.OneBlockFile) (newest time.Time) {
+	for filename := range obf.Filenames {
+		if _, blockTime, _, _, _, _, err := parseOneBlockFilename(filename); err == nil && blockTime.After(newest) {
+			newest = blockTime
+		}
+	}
+	return newest
+}
+
+// handleDuplicate is called with a copy of a block already held by the bundler, under other filenames. The held one-
+// block file is the one in the forkdb, its filenames become those of `candidate` if the policy prefers it, so that it
+// is the copy downloaded when merging.
+func (b *Bundler) handleDuplicate(held, candidate *bstream.OneBlockFile) {
+	if b.duplicateFilenames[held.CanonicalName] == nil {
+		b.duplicateFilenames[held.CanonicalName] = make(map[string]bool)
+	}
+	known := b.duplicateFilenames[held.CanonicalName]
+	for filename := range candidate.Filenames {
+		known[filename] = true
+	}
+	metrics.DuplicateOneBlockFiles.Inc()
+	if !b.duplicatePolicy.prefers(candidate, held) {
+		return
+	}
+	for filename := range held.Filenames {
+		known[filename] = true
+	}
+	held.Filenames = candidate.Filenames
+	held.MemoizeData = nil // downloaded from the previous copy
+	for filename := range held.Filenames {
+		delete(known, filename)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sadiq1971/merger/metrics"
+	"go.uber.org/zap"
+)
+
+type ErrorSeverity int
+
+const (
+	// ErrorSeverityFatal errors shut the merger down
+	ErrorSeverityFatal ErrorSeverity = iota
+	// ErrorSeverityTransient errors are reported and the operation is retried on the next polling cycle
+	ErrorSeverityTransient
+)
+
+func (s ErrorSeverity) String() string {
+	switch s {
+	case ErrorSeverityFatal:
+		return "fatal"
+	case ErrorSeverityTransient:
+		return "transient"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// StoreError wraps an error returned by an IOInterface call, Op being the name of the call
+type StoreError struct {
+	Op  string
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Op, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// BundleMergeError is the error of a bundle that failed to merge. It is always fatal, whatever the ErrorClasses: the
+// bundler has moved past that bundle by the time it is reported, it would never be merged if the merger kept going.
+type BundleMergeError struct {
+	BaseBlock uint64
+	Err       error
+}
+
+func (e *BundleMergeError) Error() string {
+	return fmt.Sprintf("merging bundle %d: %s", e.BaseBlock, e.Err)
+}
+
+func (e *BundleMergeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorClass gives a severity to the errors it matches
+type ErrorClass struct {
+	Name     string
+	Match    func(err error) bool
+	Severity ErrorSeverity
+}
+
+// StoreOpErrorClass matches the StoreErrors of the given IOInterface operations
+func StoreOpErrorClass(severity ErrorSeverity, ops ...string) *ErrorClass {
+	return &ErrorClass{
+		Name: fmt.Sprintf("store_ops_%v", ops),
+		Match: func(err error) bool {
+			var storeErr *StoreError
+			if !errors.As(err, &storeErr) {
+				return false
+			}
+			for _, op := range ops {
+				if storeErr.Op == op {
+					return true
+				}
+			}
+			return false
+		},
+		Severity: severity,
+	}
+}
+
+// DefaultErrorClasses treats failures to list the stores and deadlines exceeded as transient, everything else is fatal
+var DefaultErrorClasses = []*ErrorClass{
+	StoreOpErrorClass(ErrorSeverityTransient, "next_bundle", "walk_one_block_files"),
+	{
+		Name:     "deadline_exceeded",
+		Match:    func(err error) bool { return errors.Is(err, context.DeadlineExceeded) },
+		Severity: ErrorSeverityTransient,
+	},
+}
+
+// OnError registers a callback that is called on every error, whatever its severity, before it is handled.
+// Use ClassifyError to get its severity.
+func (m *Merger) OnError(f func(err error)) {
+	m.errorCallbacks = append(m.errorCallbacks, f)
+}
+
+// ClassifyError returns the severity of the first configured ErrorClass matching err, fatal if none matches. A
+// BundleMergeError is always fatal.
+func (m *Merger) ClassifyError(err error) ErrorSeverity {
+	var mergeErr *BundleMergeError
+	if errors.As(err, &mergeErr) {
+		return ErrorSeverityFatal
+	}
+	for _, class := range m.errorClasses {
+		if class.Match(err) {
+			return class.Severity
+		}
+	}
+	return ErrorSeverityFatal
+}
+
+// handleError reports the error and returns true if it is fatal
+func (m *Merger) handleError(err error) (fatal bool) {
+	severity := m.ClassifyError(err)
+	metrics.ErrorCount.Inc(severity.String())
+	m.activity.failed(err, m.clock.Now())
+	for _, f := range m.errorCallbacks {
+		f(err)
+	}
+
+	if severity == ErrorSeverityFatal {
+		return true
+	}
+	m.logger.Warn("transient error, will retry on next cycle", zap.Error(err))
+	return false
+}
@@ -0,0 +1,89 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerger_ClassifyError(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+
+	assert.Equal(t, ErrorSeverityTransient, m.ClassifyError(&StoreError{Op: "walk_one_block_files", Err: fmt.Errorf("boom")}))
+	assert.Equal(t, ErrorSeverityTransient, m.ClassifyError(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)))
+	assert.Equal(t, ErrorSeverityFatal, m.ClassifyError(fmt.Errorf("expecting to start at block 100")))
+
+	m = NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithErrorClasses(StoreOpErrorClass(ErrorSeverityFatal, "walk_one_block_files")))
+	assert.Equal(t, ErrorSeverityFatal, m.ClassifyError(&StoreError{Op: "walk_one_block_files", Err: fmt.Errorf("boom")}))
+}
+
+func TestMerger_TransientErrorsDoNotTerminate(t *testing.T) {
+	var walks int
+	io := &TestMergerIO{
+		WalkOneBlockFilesFunc: func(_ context.Context, _ uint64, _ func(*bstream.OneBlockFile) error) error {
+			walks++
+			if walks < 3 {
+				return fmt.Errorf("store unavailable")
+			}
+			return ErrStopBlockReached
+		},
+	}
+	m := NewMerger(testLogger, "", io, 100, 100, 100, time.Second, 0, 0)
+
+	var reported []error
+	m.OnError(func(err error) {
+		reported = append(reported, err)
+	})
+
+	require.NoError(t, m.run(context.Background()))
+	assert.Equal(t, 3, walks)
+	require.Len(t, reported, 2)
+	assert.Equal(t, ErrorSeverityTransient, m.ClassifyError(reported[0]))
+}
+
+func TestMerger_FailedMergeIsFatal(t *testing.T) {
+	var merged []uint64
+	var walks, merges int
+	io := &TestMergerIO{
+		WalkOneBlockFilesFunc: func(_ context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+			walks++
+			if walks > 3 {
+				return ErrStopBlockReached // the merger kept going past the failed bundle
+			}
+			for num := inclusiveLowerBlock; num <= 120; num++ {
+				obf := bstream.MustNewOneBlockFile(fmt.Sprintf("%010d-%016da-%016da-%d-suffix", num, num, num-1, num-2))
+				if err := callback(obf); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		MergeAndStoreFunc: func(_ context.Context, inclusiveLowerBlock uint64, _ []*bstream.OneBlockFile) error {
+			merges++
+			if merges == 1 {
+				return fmt.Errorf("upload: %w", context.DeadlineExceeded)
+			}
+			merged = append(merged, inclusiveLowerBlock)
+			return nil
+		},
+	}
+	m := NewMerger(testLogger, "", io, 100, 5, 100, time.Second, 0, 0)
+
+	err := m.run(context.Background())
+	require.Error(t, err)
+	var mergeErr *BundleMergeError
+	require.True(t, errors.As(err, &mergeErr))
+	assert.Equal(t, uint64(100), mergeErr.BaseBlock)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, ErrorSeverityFatal, m.ClassifyError(err))
+
+	m.bundler.inProcess.Lock()
+	m.bundler.inProcess.Unlock()
+	assert.Empty(t, merged, "no bundle is merged past the failed one")
+}
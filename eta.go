@@ -0,0 +1,128 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+)
+
+// ETAWindow is how far back block arrival and merge rates are measured
+var ETAWindow = 5 * time.Minute
+
+// ETA estimates, from recent block arrival and merge rates, when the current bundle completes and when the merger catches up to head.
+// Durations are -1 when they cannot be estimated (no progress measured yet, or merging slower than blocks arrive).
+type ETA struct {
+	HeadBlockNum  uint64        `json:"head_block_num"`
+	HeadLIBNum    uint64        `json:"head_lib_num"`
+	BaseBlockNum  uint64        `json:"base_block_num"`
+	BlockRate     float64       `json:"block_rate"` // blocks per second
+	MergeRate     float64       `json:"merge_rate"` // blocks per second
+	CurrentBundle time.Duration `json:"current_bundle"`
+	CatchUp       time.Duration `json:"catch_up"`
+}
+
+type progressSample struct {
+	at        time.Time
+	headNum   uint64
+	baseBlock uint64
+}
+
+type progressTracker struct {
+	sync.Mutex
+	bundleSize uint64
+	head       *bstream.OneBlockFile
+	samples    []progressSample
+	eta        *ETA
+}
+
+func newProgressTracker(bundleSize uint64) *progressTracker {
+	return &progressTracker{bundleSize: bundleSize}
+}
+
+// observeBlock is called for every one-block file walked
+func (p *progressTracker) observeBlock(obf *bstream.OneBlockFile) {
+	p.Lock()
+	defer p.Unlock()
+	if p.head == nil || obf.Num > p.head.Num {
+		p.head = obf
+	}
+}
+
+// sample is called after each walk, it recomputes the ETA
+func (p *progressTracker) sample(now time.Time, baseBlock uint64) {
+	p.Lock()
+	defer p.Unlock()
+	if p.head == nil {
+		return
+	}
+
+	p.samples = append(p.samples, progressSample{at: now, headNum: p.head.Num, baseBlock: baseBlock})
+	for len(p.samples) > 2 && now.Sub(p.samples[0].at) > ETAWindow {
+		p.samples = p.samples[1:]
+	}
+
+	eta := &ETA{
+		HeadBlockNum:  p.head.Num,
+		HeadLIBNum:    p.head.LibNum,
+		BaseBlockNum:  baseBlock,
+		CurrentBundle: -1,
+		CatchUp:       -1,
+	}
+	first := p.samples[0]
+	if elapsed := now.Sub(first.at).Seconds(); elapsed > 0 {
+		eta.BlockRate = float64(p.head.Num-first.headNum) / elapsed
+		if baseBlock > first.baseBlock {
+			eta.MergeRate = float64(baseBlock-first.baseBlock) / elapsed
+		}
+	}
+
+	boundary := baseBlock + p.bundleSize
+	switch {
+	case p.head.LibNum >= boundary:
+		eta.CurrentBundle = 0 // complete, waiting for the next walk
+	case eta.BlockRate > 0:
+		eta.CurrentBundle = secondsToDuration(float64(boundary-p.head.LibNum) / eta.BlockRate)
+	}
+
+	switch {
+	case p.head.LibNum < boundary:
+		eta.CatchUp = eta.CurrentBundle // merging is only waiting for blocks
+	case eta.MergeRate > eta.BlockRate:
+		eta.CatchUp = secondsToDuration(float64(p.head.LibNum-baseBlock) / (eta.MergeRate - eta.BlockRate))
+	}
+	p.eta = eta
+
+	metrics.BundleETA.SetFloat64(eta.CurrentBundle.Seconds())
+	metrics.CatchUpETA.SetFloat64(eta.CatchUp.Seconds())
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ETA returns the latest estimation, nil before the first walk of one-block files
+func (m *Merger) ETA() *ETA {
+	m.progress.Lock()
+	defer m.progress.Unlock()
+	if m.progress.eta == nil {
+		return nil
+	}
+	eta := *m.progress.eta
+	return &eta
+}
@@ -0,0 +1,44 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressTrackerETA(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	p := newProgressTracker(100)
+	p.observeBlock(mustNewOneBlockFile("0000000150-0000000000000150a-0000000000000149a-149-suffix"))
+	p.sample(t0, 100)
+	assert.Equal(t, time.Duration(-1), p.eta.CurrentBundle, "no rate measured yet")
+
+	p.observeBlock(mustNewOneBlockFile("0000000160-0000000000000160a-0000000000000159a-159-suffix"))
+	p.sample(t0.Add(10*time.Second), 100)
+	require.NotNil(t, p.eta)
+	assert.Equal(t, 1.0, p.eta.BlockRate)
+	assert.Equal(t, 41*time.Second, p.eta.CurrentBundle)
+	assert.Equal(t, 41*time.Second, p.eta.CatchUp)
+}
+
+func TestProgressTrackerCatchUpETA(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	p := newProgressTracker(100)
+	p.observeBlock(mustNewOneBlockFile("0000001000-0000000000001000a-0000000000000999a-999-suffix"))
+	p.sample(t0, 100)
+	p.observeBlock(mustNewOneBlockFile("0000001010-0000000000001010a-0000000000001009a-1009-suffix"))
+	p.sample(t0.Add(10*time.Second), 300)
+
+	assert.Equal(t, time.Duration(0), p.eta.CurrentBundle)
+	assert.Equal(t, 20.0, p.eta.MergeRate)
+	assert.InDelta(t, float64(709)/19, p.eta.CatchUp.Seconds(), 0.001)
+
+	// merging slower than blocks arrive: never catching up
+	p.observeBlock(mustNewOneBlockFile("0000001500-0000000000001500a-0000000000001499a-1499-suffix"))
+	p.sample(t0.Add(20*time.Second), 400)
+	assert.Equal(t, time.Duration(-1), p.eta.CatchUp)
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// ErrBundleExists is returned by MergeAndStore when the merged file of the bundle already exists and the
+// ExistingBundlePolicy forbids overwriting it
+var ErrBundleExists = errors.New("merged file already exists")
+
+// ExistingBundlePolicy is what MergeAndStore does when the merged file of a bundle already exists, e.g. written by
+// another merger pointed at the same stores by mistake
+type ExistingBundlePolicy string
+
+const (
+	ExistingBundleOverwrite ExistingBundlePolicy = "overwrite" // written again, the default
+	ExistingBundleSkip      ExistingBundlePolicy = "skip"      // left untouched, the bundle is considered merged
+	ExistingBundleFail      ExistingBundlePolicy = "fail"      // left untouched, the merge fails with ErrBundleExists
+	ExistingBundleCompare   ExistingBundlePolicy = "compare"   // skipped if it holds the same blocks, fails otherwise
+)
+
+func ParseExistingBundlePolicy(in string) (ExistingBundlePolicy, error) {
+	switch policy := ExistingBundlePolicy(in); policy {
+	case ExistingBundleOverwrite, ExistingBundleSkip, ExistingBundleFail, ExistingBundleCompare:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid policy %q for existing merged files, expecting one of: overwrite, skip, fail, compare", in)
+}
+
+// WithExistingBundlePolicy checks whether the merged file of each bundle exists before writing it, and applies
+// `policy` when it does. The merged files found are counted in `merger_existing_bundles` by action. The check and the
+// write are not atomic, two mergers can still both write a bundle they start merging at the same time.
+func WithExistingBundlePolicy(policy ExistingBundlePolicy) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.existingBundlePolicy = policy
+	}
+}
+
+// checkExistingBundle tells if the bundle must be skipped, failing with ErrBundleExists if it must not be written
+func (s *DStoreIO) checkExistingBundle(ctx context.Context, baseBlock uint64, oneBlockFiles []*bstream.OneBlockFile) (skip bool, err error) {
+	if s.existingBundlePolicy == "" || s.existingBundlePolicy == ExistingBundleOverwrite {
+		return false, nil
+	}
+	var exists bool
+	err = s.retryDownload(ctx, StoreDomainDestination, func(ctx context.Context) (err error) {
+		exists, err = s.mergedStoreFor(baseBlock).FileExists(ctx, s.mergedFileName(baseBlock))
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("checking if merged file %s exists: %w", fileNameForBlocksBundle(baseBlock), err)
+	}
+	if !exists {
+		return false, nil
+	}
+
+	policy := s.existingBundlePolicy
+	var reason string
+	if policy == ExistingBundleCompare {
+		reason, err = s.compareExistingBundle(ctx, baseBlock, oneBlockFiles)
+		if err != nil {
+			return false, err
+		}
+		policy = ExistingBundleSkip
+		if reason != "" {
+			policy = ExistingBundleFail
+		}
+	}
+
+	metrics.ExistingBundles.Inc(string(policy))
+	logger := s.componentLoggers.get(LogComponentMerge, s.logger)
+	if policy == ExistingBundleFail {
+		logger.Error("merged file already exists, not overwriting it", zap.Uint64("base_block", baseBlock), zap.String("reason", reason))
+		if reason != "" {
+			return false, fmt.Errorf("bundle %d: %w with other blocks: %s", baseBlock, ErrBundleExists, reason)
+		}
+		return false, fmt.Errorf("bundle %d: %w", baseBlock, ErrBundleExists)
+	}
+	logger.Warn("merged file already exists, skipping the bundle", zap.Uint64("base_block", baseBlock))
+	return true, nil
+}
+
+// compareExistingBundle returns why the existing merged file differs from the bundle, empty if it holds the same blocks
+func (s *DStoreIO) compareExistingBundle(ctx context.Context, baseBlock uint64, oneBlockFiles []*bstream.OneBlockFile) (string, error) {
+	s.mergedFilesCache.remove(baseBlock) // may have been written since it was cached
+	existing, err := s.FetchMergedOneBlockFiles(ctx, baseBlock)
+	if err != nil {
+		return "", fmt.Errorf("reading existing merged file %s: %w", fileNameForBlocksBundle(baseBlock), err)
+	}
+	if len(existing) != len(oneBlockFiles) {
+		return fmt.Sprintf("it holds %d blocks, expected %d", len(existing), len(oneBlockFiles)), nil
+	}
+	for i, obf := range existing {
+		if obf.Num != oneBlockFiles[i].Num || bstream.TruncateBlockID(obf.ID) != bstream.TruncateBlockID(oneBlockFiles[i].ID) {
+			return fmt.Sprintf("it holds block #%d (%s) at position %d, expected #%d (%s)", obf.Num, obf.ID, i, oneBlockFiles[i].Num, oneBlockFiles[i].ID), nil
+		}
+	}
+	return "", nil
+}
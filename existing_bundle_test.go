@@ -0,0 +1,64 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExistingBundlePolicy(t *testing.T) {
+	policy, err := ParseExistingBundlePolicy("compare")
+	require.NoError(t, err)
+	assert.Equal(t, ExistingBundleCompare, policy)
+
+	_, err = ParseExistingBundlePolicy("clobber")
+	assert.Error(t, err)
+}
+
+func TestDStoreIO_ExistingBundlePolicy(t *testing.T) {
+	bundle := []*bstream.OneBlockFile{block100, block101}
+	sameContent := []byte(
+		`{"id":"0000000000000100a","prev":"0000000000000099a","num":100,"libnum":98}` + "\n" +
+			`{"id":"0000000000000101a","prev":"0000000000000100a","num":101,"libnum":99}` + "\n",
+	)
+	otherContent := []byte(
+		`{"id":"0000000000000100b","prev":"0000000000000099a","num":100,"libnum":98}` + "\n" +
+			`{"id":"0000000000000101a","prev":"0000000000000100a","num":101,"libnum":99}` + "\n",
+	)
+
+	tests := []struct {
+		name     string
+		policy   ExistingBundlePolicy
+		existing []byte
+		skip     bool
+		exists   bool // ErrBundleExists expected
+	}{
+		{"overwrite", ExistingBundleOverwrite, sameContent, false, false},
+		{"skip missing", ExistingBundleSkip, nil, false, false},
+		{"skip", ExistingBundleSkip, otherContent, true, false},
+		{"fail", ExistingBundleFail, sameContent, false, true},
+		{"compare same", ExistingBundleCompare, sameContent, true, false},
+		{"compare other", ExistingBundleCompare, otherContent, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mergedBlocksStore := dstore.NewMockStore(nil)
+			if test.existing != nil {
+				mergedBlocksStore.SetFile("0000000100", test.existing)
+			}
+			mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedBlocksStore, nil, 0, 0, 100, WithExistingBundlePolicy(test.policy)).(*DStoreIO)
+
+			skip, err := mio.checkExistingBundle(context.Background(), 100, bundle)
+			assert.Equal(t, test.skip, skip)
+			assert.Equal(t, test.exists, errors.Is(err, ErrBundleExists), "error: %v", err)
+			if !test.exists {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
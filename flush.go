@@ -0,0 +1,162 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// ErrNothingToFlush is returned by ForceFlush when the current bundle has no irreversible block yet
+var ErrNothingToFlush = errors.New("no irreversible block in the current bundle")
+
+// MergedFileDeleterIOInterface is implemented by the IOs able to delete a merged file, a flushed partial bundle
+// is deleted before being merged again so that stores without overwrite do not keep it
+type MergedFileDeleterIOInterface interface {
+	DeleteMergedFile(ctx context.Context, baseBlock uint64) error
+}
+
+func (s *DStoreIO) DeleteMergedFile(ctx context.Context, baseBlock uint64) error {
+	for _, upgrade := range s.upgradesWithin(baseBlock) { // the bundle was split at protocol upgrades
+		s.mergedFilesCache.remove(upgrade.Height)
+		if err := s.mergedStoreFor(upgrade.Height).DeleteObject(ctx, fileNameForBlocksBundle(upgrade.Height)); err != nil && !errors.Is(err, dstore.ErrNotFound) {
+			return err
+		}
+	}
+	s.mergedFilesCache.remove(baseBlock)
+	return s.mergedStoreFor(baseBlock).DeleteObject(ctx, s.mergedFileName(baseBlock))
+}
+
+// ForceFlush merges the irreversible blocks of the current bundle right away, during a chain halt for example.
+// The bundler does not move to the next bundle: the flushed bundle is merged again, replacing the partial merged
+// file, once it is complete. The flushed bundle is kept in the state file, so that a restarted merger does not
+// take the partial merged file for a complete one. It returns the base of the flushed bundle and its block count.
+func (m *Merger) ForceFlush(ctx context.Context) (baseBlock uint64, blockCount int, err error) {
+	m.bundler.inProcess.Lock() // the bundle cannot be closed while we flush it
+	defer m.bundler.inProcess.Unlock()
+
+	m.bundler.Lock()
+	baseBlock = m.bundler.baseBlockNum
+	var blocks []*bstream.OneBlockFile
+	for _, obf := range m.bundler.irreversibleBlocks {
+		if obf.Num >= baseBlock {
+			blocks = append(blocks, obf)
+		}
+	}
+	m.bundler.Unlock()
+	if len(blocks) == 0 {
+		return baseBlock, 0, ErrNothingToFlush
+	}
+
+	if err := m.replaceFlushedBundle(baseBlock); err != nil {
+		return baseBlock, 0, err
+	}
+	if err := m.io.MergeAndStore(ctx, baseBlock, blocks); err != nil {
+		return baseBlock, 0, fmt.Errorf("merging partial bundle %d: %w", baseBlock, err)
+	}
+	m.counters.setFlushedBundle(&baseBlock)
+	if err := m.counters.save(); err != nil {
+		m.logger.Warn("cannot save state file", zap.Error(err))
+	}
+	m.logger.Info("partial bundle flushed",
+		zap.Uint64("base_block_num", baseBlock),
+		zap.Int("block_count", len(blocks)),
+		zap.Uint64("highest_block_num", blocks[len(blocks)-1].Num),
+	)
+	return baseBlock, len(blocks), nil
+}
+
+// FlushedBundle returns the base of the partial bundle flushed by ForceFlush, until it is merged completely
+func (m *Merger) FlushedBundle() (uint64, bool) {
+	m.counters.Lock()
+	defer m.counters.Unlock()
+	if m.counters.flushedBundle == nil {
+		return 0, false
+	}
+	return *m.counters.flushedBundle, true
+}
+
+// replaceFlushedBundle deletes the partial merged file of `baseBlock` if it was flushed, before it is merged again
+func (m *Merger) replaceFlushedBundle(baseBlock uint64) error {
+	flushed, ok := m.FlushedBundle()
+	if !ok || flushed != baseBlock {
+		return nil
+	}
+	deleter, ok := m.io.(MergedFileDeleterIOInterface)
+	if !ok {
+		return nil
+	}
+	if err := deleter.DeleteMergedFile(context.Background(), baseBlock); err != nil {
+		return fmt.Errorf("deleting flushed partial bundle %d: %w", baseBlock, err)
+	}
+	return nil
+}
+
+// clearFlushedBundle is called once a bundle is merged completely
+func (m *Merger) clearFlushedBundle(baseBlock uint64) {
+	if flushed, ok := m.FlushedBundle(); ok && flushed == baseBlock {
+		m.counters.setFlushedBundle(nil)
+	}
+}
+
+// skipsFlushedBundle tells if `base`, the next bundle found in the merged files, only follows the partial bundle flushed
+func (m *Merger) skipsFlushedBundle(base uint64) (uint64, bool) {
+	flushed, ok := m.FlushedBundle()
+	if !ok || base != flushed+m.bundler.bundleSize {
+		return 0, false
+	}
+	return flushed, true
+}
+
+// BundleStatus describes the bundle being filled
+type BundleStatus struct {
+	InclusiveLowBlock  uint64 `json:"inclusive_low_block"`
+	ExclusiveHighBlock uint64 `json:"exclusive_high_block"`
+	// BlockCount is the number of irreversible blocks in the bundle, HighestBlock the highest of them
+	BlockCount   int    `json:"block_count"`
+	HighestBlock uint64 `json:"highest_block,omitempty"`
+	// DriftSeconds is the time elapsed since the highest block, when its data was downloaded
+	DriftSeconds float64 `json:"drift_seconds,omitempty"`
+}
+
+// BundleStatus can be called from a different thread
+func (m *Merger) BundleStatus() *BundleStatus {
+	b := m.bundler
+	b.Lock()
+	defer b.Unlock()
+	status := &BundleStatus{InclusiveLowBlock: b.baseBlockNum, ExclusiveHighBlock: b.baseBlockNum + b.bundleSize}
+	var highest *bstream.OneBlockFile
+	for _, obf := range b.irreversibleBlocks {
+		if obf.Num >= b.baseBlockNum {
+			status.BlockCount++
+			highest = obf
+		}
+	}
+	if highest == nil {
+		return status
+	}
+	status.HighestBlock = highest.Num
+	if len(highest.MemoizeData) != 0 {
+		if blockTime, err := readBlockTime(highest.MemoizeData); err == nil {
+			status.DriftSeconds = m.clock.Now().Sub(blockTime).Seconds()
+		}
+	}
+	return status
+}
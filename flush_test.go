@@ -0,0 +1,90 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestMerger_ForceFlush(t *testing.T) {
+	var merged []uint64
+	io := &TestMergerIO{
+		MergeAndStoreFunc: func(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+			assert.Equal(t, uint64(100), inclusiveLowerBlock)
+			for _, obf := range oneBlockFiles {
+				merged = append(merged, obf.Num)
+			}
+			return nil
+		},
+	}
+	m := NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0)
+
+	_, _, err := m.ForceFlush(context.Background())
+	assert.ErrorIs(t, err, ErrNothingToFlush)
+
+	m.bundler.irreversibleBlocks = []*bstream.OneBlockFile{
+		mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-suffix"),
+		mustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-suffix"),
+	}
+	base, count, err := m.ForceFlush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), base)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []uint64{100, 101}, merged)
+	assert.Equal(t, uint64(100), m.bundler.BaseBlockNum(), "the bundler stays on the flushed bundle")
+
+	flushed, ok := m.FlushedBundle()
+	require.True(t, ok)
+	assert.Equal(t, uint64(100), flushed)
+	assert.Equal(t, &BundleStatus{InclusiveLowBlock: 100, ExclusiveHighBlock: 200, BlockCount: 2, HighestBlock: 101}, m.BundleStatus())
+
+	base, ok = m.skipsFlushedBundle(200)
+	assert.True(t, ok, "the partial merged file does not count as merged")
+	assert.Equal(t, uint64(100), base)
+	_, ok = m.skipsFlushedBundle(300)
+	assert.False(t, ok)
+
+	m.clearFlushedBundle(100)
+	_, ok = m.FlushedBundle()
+	assert.False(t, ok)
+}
+
+func TestMerger_GRPCAdmin(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	call := func(method string) (*structpb.Struct, error) {
+		for _, desc := range adminServiceDesc.Methods {
+			if desc.MethodName == method {
+				out, err := desc.Handler(m, context.Background(), func(interface{}) error { return nil }, nil)
+				if err != nil {
+					return nil, err
+				}
+				return out.(*structpb.Struct), nil
+			}
+		}
+		t.Fatalf("unknown method %s", method)
+		return nil, nil
+	}
+
+	out, err := call("Pause")
+	require.NoError(t, err)
+	assert.True(t, out.Fields["paused"].GetBoolValue())
+	assert.True(t, m.IsPaused())
+
+	out, err = call("Resume")
+	require.NoError(t, err)
+	assert.False(t, out.Fields["paused"].GetBoolValue())
+
+	out, err = call("Status")
+	require.NoError(t, err)
+	assert.Equal(t, float64(100), out.Fields["bundle"].GetStructValue().Fields["inclusive_low_block"].GetNumberValue())
+
+	_, err = call("ForceFlush")
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// LastMergedBlockReaderIOInterface is implemented by the IOs able to read the last block of a merged bundle
+type LastMergedBlockReaderIOInterface interface {
+	ReadLastMergedBlock(ctx context.Context, baseBlock uint64) (bstream.BlockRef, error)
+}
+
+func (s *DStoreIO) ReadLastMergedBlock(ctx context.Context, baseBlock uint64) (bstream.BlockRef, error) {
+	last, _, err := s.readLastBlockFromMerged(ctx, baseBlock)
+	return last, err
+}
+
+// WithForceStartBlock pins the merger to `startBlock`, a bundle boundary, to merge again a corrupted tail of merged
+// bundles: the merged files found from there are not trusted, they are neither skipped (see WithStartBlockProbing)
+// nor read for the next bundle, every bundle is merged again and overwritten. The forkdb starts from the last block of
+// the merged bundle below `startBlock`, unless WithSkipBootstrap. It is meant to be removed once the tail is repaired.
+// Run shuts the merger down with an error when `startBlock` is not aligned on the bundle size.
+func WithForceStartBlock(startBlock uint64) Option {
+	return func(m *Merger) {
+		WithStartBlock(startBlock)(m)
+		m.forceStart = true
+	}
+}
+
+// WithSkipBootstrap starts the forkdb without the last block of the previous merged bundle, it then expects the
+// one-block file of the first block of the bundle
+func WithSkipBootstrap() Option {
+	return func(m *Merger) {
+		m.skipBootstrap = true
+	}
+}
+
+// bootstrapForcedStart gives the forkdb the LIB found in the merged bundle below the forced start block
+func (m *Merger) bootstrapForcedStart(ctx context.Context) {
+	base := m.bundler.BaseBlockNum()
+	if m.skipBootstrap || base < m.bundler.bundleSize || base <= m.bundler.firstStreamableBlock {
+		return
+	}
+	reader, ok := m.io.(LastMergedBlockReaderIOInterface)
+	if !ok {
+		m.logger.Warn("io cannot read merged files, starting at the forced start block without bootstrap", zap.Uint64("start_block", base))
+		return
+	}
+	lib, err := reader.ReadLastMergedBlock(ctx, base-m.bundler.bundleSize)
+	if err != nil {
+		m.logger.Warn("cannot read the merged bundle below the forced start block, starting without bootstrap", zap.Uint64("start_block", base), zap.Error(err))
+		return
+	}
+	m.resetBundler(base, lib, fmt.Sprintf("forced start block %d", base))
+}
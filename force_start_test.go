@@ -0,0 +1,57 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type lastMergedBlockTestIO struct {
+	TestMergerIO
+	read []uint64
+}
+
+func (io *lastMergedBlockTestIO) ReadLastMergedBlock(_ context.Context, baseBlock uint64) (bstream.BlockRef, error) {
+	io.read = append(io.read, baseBlock)
+	return bstream.NewBlockRef("0000000000000399a", baseBlock+99), nil
+}
+
+func TestMerger_ForceStartBlock(t *testing.T) {
+	io := &lastMergedBlockTestIO{}
+	m := NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0, WithForceStartBlock(400))
+	assert.True(t, m.forceStart)
+	assert.Equal(t, uint64(400), m.bundler.BaseBlockNum())
+
+	m.bootstrapForcedStart(context.Background())
+	assert.Equal(t, []uint64{300}, io.read)
+	require.NotNil(t, m.bundler.lib)
+	assert.Equal(t, uint64(399), m.bundler.lib.Num())
+	assert.Equal(t, uint64(400), m.bundler.BaseBlockNum())
+
+	io = &lastMergedBlockTestIO{}
+	m = NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0, WithForceStartBlock(400), WithSkipBootstrap())
+	m.bootstrapForcedStart(context.Background())
+	assert.Empty(t, io.read)
+	assert.Nil(t, m.bundler.lib)
+
+	io = &lastMergedBlockTestIO{}
+	m = NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0, WithForceStartBlock(100))
+	m.bootstrapForcedStart(context.Background())
+	assert.Empty(t, io.read, "no merged bundle below the first streamable block")
+}
+
+func TestMerger_ForceStartBlockNotAligned(t *testing.T) {
+	io := &lastMergedBlockTestIO{}
+	m := NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0, WithForceStartBlock(450))
+	m.Run()
+	require.Error(t, m.Err())
+	assert.Contains(t, m.Err().Error(), "force start block 450 is not aligned on bundle size 100")
+	assert.Empty(t, io.read)
+
+	m = NewMergerWithOptions(&lastMergedBlockTestIO{}, WithForceStartBlock(450), WithBundleSize(150), WithFirstStreamableBlock(150))
+	assert.NoError(t, m.settingsErr, "the alignment is checked once every option has run")
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// ForkDBDiff is what changed in the bundler's forkdb during one cycle, the blocks are one-block file canonical names
+type ForkDBDiff struct {
+	At                   time.Time `json:"at"`
+	PreviousBaseBlockNum uint64    `json:"previous_base_block_num"`
+	BaseBlockNum         uint64    `json:"base_block_num"`
+	Added                []string  `json:"added,omitempty"`
+	Irreversible         []string  `json:"irreversible,omitempty"`
+	// Purged are the blocks dropped without ever becoming irreversible (forks), merged blocks are not listed
+	Purged         []string `json:"purged,omitempty"`
+	PreviousHead   string   `json:"previous_head,omitempty"`
+	Head           string   `json:"head,omitempty"`
+	BranchSwitched bool     `json:"branch_switched,omitempty"`
+}
+
+func (d *ForkDBDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Irreversible) == 0 && len(d.Purged) == 0 && d.PreviousBaseBlockNum == d.BaseBlockNum
+}
+
+// WithForkDBDiffs logs, after each cycle, the blocks added to the forkdb, the ones that became irreversible,
+// the forks purged and the head changes (including branch switches), so that reorgs can be reconstructed
+// after the fact. The last `history` diffs are served on the admin API at `/forkdb-diffs`.
+func WithForkDBDiffs(history int) Option {
+	return func(m *Merger) {
+		m.forkDBDiffs = newForkDBDiffer(history)
+		m.bundler.onIrreversible = m.forkDBDiffs.observeIrreversible
+	}
+}
+
+// forkDBDiffer only sees the bundler from the thread that calls HandleBlockFile, the lock protects the history
+type forkDBDiffer struct {
+	previous     map[string]*bstream.OneBlockFile
+	previousBase uint64
+	head         *bstream.OneBlockFile
+	irreversible map[string]bool
+	cycleIrr     []string
+
+	sync.Mutex
+	history    []*ForkDBDiff
+	maxHistory int
+}
+
+func newForkDBDiffer(maxHistory int) *forkDBDiffer {
+	return &forkDBDiffer{
+		previous:     make(map[string]*bstream.OneBlockFile),
+		irreversible: make(map[string]bool),
+		maxHistory:   maxHistory,
+	}
+}
+
+func (d *forkDBDiffer) observeIrreversible(obf *bstream.OneBlockFile) {
+	if d.irreversible[obf.CanonicalName] {
+		return
+	}
+	d.irreversible[obf.CanonicalName] = true
+	d.cycleIrr = append(d.cycleIrr, obf.CanonicalName)
+}
+
+// diff compares the seen blocks with the ones of the previous cycle
+func (d *forkDBDiffer) diff(now time.Time, seen map[string]*bstream.OneBlockFile, baseBlockNum uint64) *ForkDBDiff {
+	out := &ForkDBDiff{
+		At:                   now,
+		PreviousBaseBlockNum: d.previousBase,
+		BaseBlockNum:         baseBlockNum,
+		Irreversible:         d.cycleIrr,
+	}
+	d.cycleIrr = nil
+
+	var head *bstream.OneBlockFile
+	current := make(map[string]*bstream.OneBlockFile, len(seen))
+	byID := make(map[string]*bstream.OneBlockFile, len(seen))
+	for name, obf := range seen {
+		current[name] = obf
+		byID[obf.ID] = obf
+		if _, found := d.previous[name]; !found {
+			out.Added = append(out.Added, name)
+		}
+		if head == nil || obf.Num > head.Num || (obf.Num == head.Num && name > head.CanonicalName) {
+			head = obf
+		}
+	}
+	for name := range d.previous {
+		if _, found := current[name]; found {
+			continue
+		}
+		if !d.irreversible[name] {
+			out.Purged = append(out.Purged, name)
+		}
+		delete(d.irreversible, name)
+	}
+	sort.Strings(out.Added)
+	sort.Strings(out.Purged)
+
+	if d.head != nil {
+		out.PreviousHead = d.head.CanonicalName
+	}
+	if head != nil {
+		out.Head = head.CanonicalName
+		out.BranchSwitched = d.head != nil && !descendsFrom(head, d.head, byID)
+	}
+
+	d.previous = current
+	d.previousBase = baseBlockNum
+	if head != nil {
+		d.head = head
+	}
+	return out
+}
+
+// descendsFrom follows the parents of `block` known to the forkdb, an unknown parent is not considered a switch
+func descendsFrom(block, ancestor *bstream.OneBlockFile, byID map[string]*bstream.OneBlockFile) bool {
+	if block.Num < ancestor.Num {
+		return false
+	}
+	for block.Num > ancestor.Num {
+		parent, found := byID[block.PreviousID]
+		if !found {
+			return true
+		}
+		block = parent
+	}
+	return block.Num != ancestor.Num || block.ID == ancestor.ID
+}
+
+func (d *forkDBDiffer) record(diff *ForkDBDiff, logger *zap.Logger) {
+	if diff.empty() && !diff.BranchSwitched {
+		return
+	}
+	logger.Info("forkdb diff",
+		zap.Uint64("previous_base_block_num", diff.PreviousBaseBlockNum),
+		zap.Uint64("base_block_num", diff.BaseBlockNum),
+		zap.Strings("added", diff.Added),
+		zap.Strings("irreversible", diff.Irreversible),
+		zap.Strings("purged", diff.Purged),
+		zap.String("previous_head", diff.PreviousHead),
+		zap.String("head", diff.Head),
+		zap.Bool("branch_switched", diff.BranchSwitched),
+	)
+
+	d.Lock()
+	defer d.Unlock()
+	d.history = append(d.history, diff)
+	if len(d.history) > d.maxHistory {
+		d.history = d.history[len(d.history)-d.maxHistory:]
+	}
+}
+
+// ForkDBDiffs returns the last recorded forkdb diffs, oldest first, nil unless WithForkDBDiffs is used
+func (m *Merger) ForkDBDiffs() []*ForkDBDiff {
+	if m.forkDBDiffs == nil {
+		return nil
+	}
+	m.forkDBDiffs.Lock()
+	defer m.forkDBDiffs.Unlock()
+	return append([]*ForkDBDiff(nil), m.forkDBDiffs.history...)
+}
+
+func (m *Merger) forkDBDiffsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.ForkDBDiffs())
+}
@@ -0,0 +1,50 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForkDBDiffer(t *testing.T) {
+	b100a := mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-suffix")
+	b101a := mustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-suffix")
+	b101b := mustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix")
+	b102b := mustNewOneBlockFile("0000000102-0000000000000102b-0000000000000101b-100-suffix")
+	seen := func(blocks ...*bstream.OneBlockFile) map[string]*bstream.OneBlockFile {
+		out := make(map[string]*bstream.OneBlockFile)
+		for _, b := range blocks {
+			out[b.CanonicalName] = b
+		}
+		return out
+	}
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := newForkDBDiffer(10)
+
+	d.observeIrreversible(b100a)
+	diff := d.diff(t0, seen(b100a, b101a), 100)
+	assert.Equal(t, []string{b100a.CanonicalName, b101a.CanonicalName}, diff.Added)
+	assert.Equal(t, []string{b100a.CanonicalName}, diff.Irreversible)
+	assert.Equal(t, b101a.CanonicalName, diff.Head)
+	assert.False(t, diff.BranchSwitched)
+
+	diff = d.diff(t0, seen(b100a, b101a, b101b, b102b), 100)
+	assert.Equal(t, []string{b101b.CanonicalName, b102b.CanonicalName}, diff.Added)
+	assert.Equal(t, b101a.CanonicalName, diff.PreviousHead)
+	assert.Equal(t, b102b.CanonicalName, diff.Head)
+	assert.True(t, diff.BranchSwitched)
+
+	d.observeIrreversible(b101b)
+	diff = d.diff(t0, seen(b102b), 102)
+	assert.Empty(t, diff.Added)
+	assert.Equal(t, []string{b101a.CanonicalName}, diff.Purged, "merged irreversible blocks are not purged")
+	assert.Equal(t, uint64(100), diff.PreviousBaseBlockNum)
+	assert.False(t, diff.BranchSwitched)
+
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithForkDBDiffs(1))
+	m.forkDBDiffs.record(diff, testLogger)
+	m.forkDBDiffs.record(&ForkDBDiff{PreviousBaseBlockNum: 102, BaseBlockNum: 102}, testLogger) // empty, not recorded
+	assert.Equal(t, []*ForkDBDiff{diff}, m.ForkDBDiffs())
+}
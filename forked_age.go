@@ -0,0 +1,120 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// WithMaxForkedBlockAge changes what happens to the blocks that are not irreversible when their bundle is closed. By
+// default, they are all purged as forked blocks. With it, only the blocks definitively forked are: the ones at a
+// height where the bundle has an irreversible block, and the ones below the bundle (late arrivals for a bundle already
+// merged). The others, above the last irreversible block of the bundle or at a height it skips, may still be part of
+// a late-arriving canonical chain: they are kept, with a warning, until they are more than `maxAgeBlocks` below the
+// base of the bundle being collected, or kept for longer than `maxAge`. Their one-block files are not pruned meanwhile.
+// A limit of 0 is not checked, with both at 0 the blocks are purged right away.
+func WithMaxForkedBlockAge(maxAgeBlocks uint64, maxAge time.Duration) Option {
+	return func(m *Merger) {
+		if maxAgeBlocks == 0 && maxAge == 0 {
+			m.bundler.forkedAge = nil
+			return
+		}
+		m.bundler.forkedAge = &forkedBlockAge{
+			maxAgeBlocks: maxAgeBlocks,
+			maxAge:       maxAge,
+			kept:         make(map[string]time.Time),
+			onKept: func(obf *bstream.OneBlockFile) {
+				m.logger.Warn("keeping an old block that is not irreversible, it may belong to a late-arriving canonical chain",
+					zap.Uint64("block_num", obf.Num),
+					zap.String("block_id", obf.ID),
+					zap.Uint64("max_age_blocks", maxAgeBlocks),
+					zap.Duration("max_age", maxAge),
+				)
+			},
+		}
+	}
+}
+
+type forkedBlockAge struct {
+	sync.Mutex
+	maxAgeBlocks uint64
+	maxAge       time.Duration
+	kept         map[string]time.Time // since when, by canonical name
+	onKept       func(obf *bstream.OneBlockFile)
+}
+
+// keeps tells if `obf`, not irreversible when the bundle [base, nextBase) is closed, is kept instead of purged.
+// `irreversibleHeights` are the block numbers of the irreversible blocks of the bundle.
+func (a *forkedBlockAge) keeps(obf *bstream.OneBlockFile, irreversibleHeights map[uint64]bool, base, nextBase uint64, now time.Time) bool {
+	if a == nil {
+		return false
+	}
+	a.Lock()
+	defer a.Unlock()
+
+	since, kept := a.kept[obf.CanonicalName]
+	if !kept {
+		if obf.Num < base || irreversibleHeights[obf.Num] {
+			return false // definitively forked
+		}
+		if a.maxAgeBlocks != 0 && nextBase-obf.Num > a.maxAgeBlocks {
+			return false // already too old
+		}
+		a.kept[obf.CanonicalName] = now
+		metrics.KeptForkedBlocks.SetFloat64(float64(len(a.kept)))
+		if a.onKept != nil {
+			a.onKept(obf)
+		}
+		return true
+	}
+
+	if (a.maxAgeBlocks != 0 && nextBase > obf.Num && nextBase-obf.Num > a.maxAgeBlocks) || (a.maxAge != 0 && now.Sub(since) > a.maxAge) {
+		delete(a.kept, obf.CanonicalName)
+		metrics.KeptForkedBlocks.SetFloat64(float64(len(a.kept)))
+		return false
+	}
+	return true
+}
+
+// forget drops the kept blocks that the bundler does not hold anymore (merged after all)
+func (a *forkedBlockAge) forget(seen map[string]*bstream.OneBlockFile) {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	for name := range a.kept {
+		if _, ok := seen[name]; !ok {
+			delete(a.kept, name)
+		}
+	}
+	metrics.KeptForkedBlocks.SetFloat64(float64(len(a.kept)))
+}
+
+// holds tells the pruners to leave the one-block file of a kept block alone
+func (a *forkedBlockAge) holds(obf *bstream.OneBlockFile) bool {
+	if a == nil {
+		return false
+	}
+	a.Lock()
+	defer a.Unlock()
+	_, kept := a.kept[obf.CanonicalName]
+	return kept
+}
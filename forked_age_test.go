@@ -0,0 +1,45 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundler_MaxForkedBlockAge(t *testing.T) {
+	b := NewBundler(100, 0, 100, 10, &TestMergerIO{})
+	b.forkedAge = &forkedBlockAge{maxAgeBlocks: 20, kept: make(map[string]time.Time)}
+
+	forked101 := bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix")
+	late95 := bstream.MustNewOneBlockFile("0000000095-0000000000000095b-0000000000000094b-93-suffix")
+	above105 := bstream.MustNewOneBlockFile("0000000105-0000000000000105b-0000000000000104b-100-suffix")
+	b.irreversibleBlocks = []*bstream.OneBlockFile{block100, block101, block102Final100}
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100, forked101, late95, above105} {
+		b.seenBlockFiles[obf.CanonicalName] = obf
+	}
+
+	forked := b.forkedBlocksInCurrentBundle()
+	assert.ElementsMatch(t, []*bstream.OneBlockFile{forked101, late95}, forked, "definitively forked")
+	assert.True(t, b.forkedAge.holds(above105), "may belong to a late-arriving canonical chain")
+	assert.Contains(t, b.seenBlockFiles, above105.CanonicalName)
+
+	b.baseBlockNum = 110
+	b.irreversibleBlocks = nil
+	assert.Empty(t, b.forkedBlocksInCurrentBundle(), "15 blocks below the next bundle")
+
+	b.baseBlockNum = 120
+	assert.Equal(t, []*bstream.OneBlockFile{above105}, b.forkedBlocksInCurrentBundle(), "25 blocks below the next bundle")
+	assert.False(t, b.forkedAge.holds(above105))
+	assert.Empty(t, b.seenBlockFiles)
+}
+
+func TestBundler_ForkedBlocksPurgedByDefault(t *testing.T) {
+	b := NewBundler(100, 0, 100, 10, &TestMergerIO{})
+	above105 := bstream.MustNewOneBlockFile("0000000105-0000000000000105b-0000000000000104b-100-suffix")
+	b.irreversibleBlocks = []*bstream.OneBlockFile{block100, block101, block102Final100}
+	b.seenBlockFiles[above105.CanonicalName] = above105
+
+	assert.Equal(t, []*bstream.OneBlockFile{above105}, b.forkedBlocksInCurrentBundle())
+}
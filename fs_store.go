@@ -0,0 +1,131 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/streamingfast/dstore"
+)
+
+// FSStore lists and deletes the objects of a local dstore (`file://` URL or plain path) directly on its directory:
+// dstore walks local stores like object storage, with a stat call per file, which is slow on one-block stores holding
+// many files. Walks read the directory entries once, in name order, without stat; deletions are unlinks in batches
+// (FSStore is a BulkDeleter, see WithBulkDelete). Writes are left to dstore, which writes a `.tmp` file then renames it.
+type FSStore struct {
+	dstore.Store
+	dir       string
+	extension string // added by dstore to the object names, with its leading dot
+}
+
+// NewFSStore wraps `store` when it is a local store, it returns false otherwise
+func NewFSStore(store dstore.Store) (*FSStore, bool) {
+	if _, ok := store.(*dstore.LocalStore); !ok {
+		return nil, false
+	}
+	const probe = "probe"
+	objectPath := store.ObjectPath(probe)
+	dir := filepath.Dir(objectPath)
+	extension := strings.TrimPrefix(filepath.Base(objectPath), probe)
+	return &FSStore{Store: store, dir: dir, extension: extension}, true
+}
+
+// AsFSStore returns the FSStore under `store`, scoped or not
+func AsFSStore(store dstore.Store) (*FSStore, bool) {
+	if scoped, ok := store.(*scopedStore); ok {
+		store = scoped.Store
+	}
+	fsStore, ok := store.(*FSStore)
+	return fsStore, ok
+}
+
+// Dir is the directory holding the objects of the store
+func (s *FSStore) Dir() string {
+	return s.dir
+}
+
+// SubStore keeps the sub-folder stores (ex: scoped stores) on FSStore
+func (s *FSStore) SubStore(subFolder string) (dstore.Store, error) {
+	sub, err := s.Store.SubStore(subFolder)
+	if err != nil {
+		return nil, err
+	}
+	if fsSub, ok := NewFSStore(sub); ok {
+		return fsSub, nil
+	}
+	return sub, nil
+}
+
+func (s *FSStore) Walk(ctx context.Context, prefix string, f func(filename string) error) error {
+	return s.WalkFrom(ctx, prefix, "", f)
+}
+
+// WalkFrom lists the objects directly in the store directory, names in sub-folders fall back to the dstore walk
+func (s *FSStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) error) error {
+	if strings.Contains(prefix, "/") || strings.Contains(startingPoint, "/") {
+		return s.Store.WalkFrom(ctx, prefix, startingPoint, f)
+	}
+	entries, err := os.ReadDir(s.dir) // sorted by name
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") || !strings.HasSuffix(name, s.extension) {
+			continue // being written, or not an object of this store
+		}
+		name = strings.TrimSuffix(name, s.extension)
+		if !strings.HasPrefix(name, prefix) || name < startingPoint {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f(name); err != nil {
+			if errors.Is(err, dstore.StopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteObjects implements BulkDeleter, objects already gone are not failures
+func (s *FSStore) DeleteObjects(ctx context.Context, names []string) (map[string]error, error) {
+	var failed map[string]error
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return failed, err
+		}
+		if err := os.Remove(s.Store.ObjectPath(name)); err != nil && !os.IsNotExist(err) {
+			if failed == nil {
+				failed = make(map[string]error)
+			}
+			failed[name] = err
+		}
+	}
+	return failed, nil
+}
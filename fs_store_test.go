@@ -0,0 +1,60 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	local, err := dstore.NewDBinStore(dir)
+	require.NoError(t, err)
+
+	_, ok := NewFSStore(dstore.NewMockStore(nil))
+	assert.False(t, ok)
+	store, ok := NewFSStore(local)
+	require.True(t, ok)
+	assert.Equal(t, dir, store.Dir())
+
+	for _, name := range []string{"0000000102-b", "0000000100-a", "0000000101-a"} {
+		require.NoError(t, store.WriteObject(ctx, name, bytes.NewReader([]byte("x"))))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0000000103-a.dbin.zst.tmp"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "relayer"), 0755))
+
+	var walked []string
+	require.NoError(t, store.WalkFrom(ctx, "00000001", "0000000101", func(filename string) error {
+		walked = append(walked, filename)
+		if len(walked) == 1 {
+			return nil
+		}
+		return dstore.StopIteration
+	}))
+	assert.Equal(t, []string{"0000000101-a", "0000000102-b"}, walked)
+
+	failed, err := store.DeleteObjects(ctx, []string{"0000000100-a", "0000000101-a", "0000000199-missing"})
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+
+	walked = nil
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+		walked = append(walked, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"0000000102-b"}, walked)
+
+	scoped, err := NewScopedStore(store, "relayer")
+	require.NoError(t, err)
+	scopedFS, ok := AsFSStore(scoped)
+	require.True(t, ok)
+	assert.Equal(t, filepath.Join(dir, "relayer"), scopedFS.Dir())
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// AdminServiceName is the gRPC admin service, described in proto/merger/admin/v1/admin.proto. Every method takes
+// a google.protobuf.Empty and returns the admin status (same as the admin HTTP `/status`) as a google.protobuf.Struct.
+// It is served with the other gRPC services, behind the same auth token and rate limit.
+const AdminServiceName = "merger.admin.v1.Admin"
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: AdminServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		adminMethod("Status", func(ctx context.Context, m *Merger) error { return nil }),
+		adminMethod("Pause", func(ctx context.Context, m *Merger) error { m.Pause(); return nil }),
+		adminMethod("Resume", func(ctx context.Context, m *Merger) error { m.Resume(); return nil }),
+		adminMethod("ForceFlush", func(ctx context.Context, m *Merger) error {
+			_, _, err := m.ForceFlush(ctx)
+			if errors.Is(err, ErrNothingToFlush) {
+				return status.Error(codes.FailedPrecondition, err.Error())
+			}
+			return err
+		}),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "merger/admin/v1/admin.proto",
+}
+
+// adminMethod runs `action` then returns the admin status
+func adminMethod(name string, action func(ctx context.Context, m *Merger) error) grpc.MethodDesc {
+	call := func(ctx context.Context, m *Merger) (*structpb.Struct, error) {
+		if err := action(ctx, m); err != nil {
+			return nil, err
+		}
+		return m.adminStatusStruct()
+	}
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := &emptypb.Empty{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			m := srv.(*Merger)
+			if interceptor == nil {
+				return call(ctx, m)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + AdminServiceName + "/" + name}
+			return interceptor(ctx, in, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+				return call(ctx, m)
+			})
+		},
+	}
+}
+
+func (m *Merger) adminStatusStruct() (*structpb.Struct, error) {
+	data, err := json.Marshal(m.collectAdminStatus())
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
+
+// AdminClient calls the gRPC admin service of a merger
+type AdminClient struct {
+	conn grpc.ClientConnInterface
+}
+
+func NewAdminClient(conn grpc.ClientConnInterface) *AdminClient {
+	return &AdminClient{conn: conn}
+}
+
+func (c *AdminClient) invoke(ctx context.Context, method string, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	out := &structpb.Struct{}
+	if err := c.conn.Invoke(ctx, "/"+AdminServiceName+"/"+method, &emptypb.Empty{}, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *AdminClient) Status(ctx context.Context, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, "Status", opts...)
+}
+
+func (c *AdminClient) Pause(ctx context.Context, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, "Pause", opts...)
+}
+
+func (c *AdminClient) Resume(ctx context.Context, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, "Resume", opts...)
+}
+
+// ForceFlush merges the partial current bundle right away, see Merger.ForceFlush
+func (c *AdminClient) ForceFlush(ctx context.Context, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, "ForceFlush", opts...)
+}
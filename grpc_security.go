@@ -0,0 +1,187 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	dgrpcserver "github.com/streamingfast/dgrpc/server"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// healthMethodPrefix is never authenticated nor rate limited, so that probes keep working
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// WithGRPCServerOptions passes options to the gRPC server, ex: your own interceptors through
+// dgrpcserver.WithPostUnaryInterceptor and dgrpcserver.WithPostStreamInterceptor
+func WithGRPCServerOptions(opts ...dgrpcserver.Option) Option {
+	return func(m *Merger) {
+		m.grpcServerOptions = append(m.grpcServerOptions, opts...)
+	}
+}
+
+// WithGRPCAuthToken requires the `authorization: bearer <token>` metadata on every call but the health checks
+func WithGRPCAuthToken(token string) Option {
+	return withGRPCCheck(func(ctx context.Context, fullMethod string) error {
+		if strings.HasPrefix(fullMethod, healthMethodPrefix) {
+			return nil
+		}
+		if !validAuthToken(ctx, token) {
+			return status.Error(codes.Unauthenticated, "invalid or missing auth token")
+		}
+		return nil
+	})
+}
+
+func validAuthToken(ctx context.Context, token string) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		parts := strings.SplitN(value, " ", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "bearer") && subtle.ConstantTimeCompare([]byte(parts[1]), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// WithGRPCRateLimit rejects calls with RESOURCE_EXHAUSTED above `requestsPerSecond`, bursts of up to `burst`
+// calls are allowed. Health checks are not limited.
+func WithGRPCRateLimit(requestsPerSecond float64, burst int) Option {
+	limiter := newTokenBucket(requestsPerSecond, burst)
+	return withGRPCCheck(func(_ context.Context, fullMethod string) error {
+		if strings.HasPrefix(fullMethod, healthMethodPrefix) {
+			return nil
+		}
+		if !limiter.allow(time.Now()) {
+			return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+		return nil
+	})
+}
+
+// WithGRPCRequestLogging logs every call with its duration and status code
+func WithGRPCRequestLogging() Option {
+	return func(m *Merger) {
+		log := func(fullMethod string, start time.Time, err error) {
+			m.logger.Info("grpc request", zap.String("method", fullMethod), zap.Duration("duration", time.Since(start)), zap.Stringer("code", status.Code(err)))
+		}
+		m.grpcServerOptions = append(m.grpcServerOptions,
+			dgrpcserver.WithPostUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				start := time.Now()
+				resp, err := handler(ctx, req)
+				log(info.FullMethod, start, err)
+				return resp, err
+			}),
+			dgrpcserver.WithPostStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				start := time.Now()
+				err := handler(srv, ss)
+				log(info.FullMethod, start, err)
+				return err
+			}),
+		)
+	}
+}
+
+// GRPCTLSServerOption serves the gRPC API over TLS. With a `clientCAFile`, clients must present a certificate
+// signed by one of its CAs (mTLS). Pass the result to WithGRPCServerOptions.
+func GRPCTLSServerOption(certFile, keyFile, clientCAFile string) (dgrpcserver.Option, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading server certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.NoClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in client CA file %q", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return func(options *dgrpcserver.Options) {
+		options.IsPlainText = false
+		options.SecureTLSConfig = config
+	}, nil
+}
+
+// withGRPCCheck runs `check` before every unary and stream call, an error rejects the call
+func withGRPCCheck(check func(ctx context.Context, fullMethod string) error) Option {
+	return WithGRPCServerOptions(
+		dgrpcserver.WithPostUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := check(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		dgrpcserver.WithPostStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+}
+
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,54 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dgrpcserver "github.com/streamingfast/dgrpc/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCAuthToken(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithGRPCAuthToken("secret"))
+	options := dgrpcserver.NewOptions()
+	for _, opt := range m.grpcServerOptions {
+		opt(options)
+	}
+	require.Len(t, options.PostUnaryInterceptors, 1)
+	interceptor := options.PostUnaryInterceptors[0]
+
+	call := func(ctx context.Context, method string) error {
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		return err
+	}
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(call(context.Background(), "/sf.merger.v1.Merger/PreMergedBlocks")))
+	assert.Equal(t, codes.Unauthenticated, status.Code(call(withToken("wrong"), "/sf.merger.v1.Merger/PreMergedBlocks")))
+	assert.NoError(t, call(withToken("secret"), "/sf.merger.v1.Merger/PreMergedBlocks"))
+	assert.NoError(t, call(context.Background(), "/grpc.health.v1.Health/Check"))
+}
+
+func TestTokenBucket(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(2, 2)
+
+	assert.True(t, bucket.allow(t0))
+	assert.True(t, bucket.allow(t0))
+	assert.False(t, bucket.allow(t0))
+	assert.True(t, bucket.allow(t0.Add(500*time.Millisecond)))
+	assert.False(t, bucket.allow(t0.Add(500*time.Millisecond)))
+	assert.True(t, bucket.allow(t0.Add(10*time.Second)))
+	assert.True(t, bucket.allow(t0.Add(10*time.Second)))
+	assert.False(t, bucket.allow(t0.Add(10*time.Second)))
+}
@@ -20,18 +20,29 @@ import (
 	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-// Check is basic GRPC Healthcheck
+// Check is basic GRPC Healthcheck, the merger is NOT_SERVING while degraded (see DegradedReason), while
+// readers are stalled (see ChainState), while the store circuit breaker is open (see WithRetryPolicy) or while its
+// head drifts (see WithReadinessMaxDrift), an idle merger on a halted chain is SERVING
 func (m *Merger) Check(ctx context.Context, in *pbhealth.HealthCheckRequest) (*pbhealth.HealthCheckResponse, error) {
-	status := pbhealth.HealthCheckResponse_SERVING
 	return &pbhealth.HealthCheckResponse{
-		Status: status,
+		Status: m.healthStatus(),
 	}, nil
 }
 
+func (m *Merger) healthStatus() pbhealth.HealthCheckResponse_ServingStatus {
+	if m.DegradedReason() != "" || m.ChainState() == ChainStateReadersStalled || m.drifting() {
+		return pbhealth.HealthCheckResponse_NOT_SERVING
+	}
+	if breaker, ok := m.io.(CircuitBreakerIOInterface); ok && breaker.CircuitOpen() {
+		return pbhealth.HealthCheckResponse_NOT_SERVING
+	}
+	return pbhealth.HealthCheckResponse_SERVING
+}
+
 // Watch is basic GRPC Healthcheck as a stream
 func (m *Merger) Watch(req *pbhealth.HealthCheckRequest, stream pbhealth.Health_WatchServer) error {
 	err := stream.Send(&pbhealth.HealthCheckResponse{
-		Status: pbhealth.HealthCheckResponse_SERVING,
+		Status: m.healthStatus(),
 	})
 	if err != nil {
 		return err
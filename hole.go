@@ -0,0 +1,216 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// BlockHole is a block missing from the chain being bundled: the one-block file at ExclusiveHighBlock does not
+// link to any known block, its parent MissingParentID was never seen. The blocks from InclusiveLowBlock are missing
+// (none when the chain skips numbers).
+type BlockHole struct {
+	InclusiveLowBlock  uint64    `json:"inclusive_low_block"`
+	ExclusiveHighBlock uint64    `json:"exclusive_high_block"`
+	MissingParentID    string    `json:"missing_parent_id"`
+	Since              time.Time `json:"since"`
+}
+
+// WithHoleDetection reports a hole in the chain being bundled (which otherwise stalls the merger without any signal)
+// once it lasted `grace`: it is logged, set in `merger_block_hole` and in the admin status. With `skip`, the bundler
+// then links the blocks above the hole to the last block below it, for the chains that legitimately miss blocks: the
+// merged files do not hold the missing blocks. A `grace` of 0 disables the detection.
+func WithHoleDetection(grace time.Duration, skip bool) Option {
+	return func(m *Merger) {
+		if grace == 0 {
+			m.holes = nil
+			return
+		}
+		m.holes = &holeDetector{grace: grace, skip: skip}
+	}
+}
+
+type holeDetector struct {
+	sync.Mutex
+	grace time.Duration
+	skip  bool
+
+	candidate *BlockHole // seen, not reported before grace
+	reported  *BlockHole
+}
+
+// evaluate is called after each walk of the one-block files, from the thread feeding the bundler
+func (d *holeDetector) evaluate(b *Bundler, now time.Time, logger *zap.Logger) {
+	hole, below, above := b.findHole()
+
+	d.Lock()
+	defer d.Unlock()
+	if hole == nil {
+		if d.reported != nil {
+			logger.Info("block hole filled", zap.Uint64("inclusive_low_block", d.reported.InclusiveLowBlock), zap.Uint64("exclusive_high_block", d.reported.ExclusiveHighBlock))
+			metrics.BlockHole.SetUint64(0)
+		}
+		d.candidate, d.reported = nil, nil
+		return
+	}
+	if d.candidate == nil || d.candidate.MissingParentID != hole.MissingParentID {
+		hole.Since = now
+		d.candidate, d.reported = hole, nil
+		return
+	}
+	if now.Sub(d.candidate.Since) < d.grace {
+		return
+	}
+
+	if d.reported == nil {
+		d.reported = d.candidate
+		logger.Warn("block hole detected, the bundler cannot link the blocks above it",
+			zap.Uint64("inclusive_low_block", d.reported.InclusiveLowBlock),
+			zap.Uint64("exclusive_high_block", d.reported.ExclusiveHighBlock),
+			zap.String("missing_parent_id", d.reported.MissingParentID),
+			zap.Duration("since", now.Sub(d.reported.Since)),
+			zap.Bool("skip", d.skip),
+		)
+		metrics.BlockHole.SetUint64(1)
+		metrics.BlockHoleLowBlock.SetUint64(d.reported.InclusiveLowBlock)
+		metrics.BlockHoles.Inc()
+	}
+	if !d.skip {
+		return
+	}
+	if err := b.skipHole(below, above); err != nil {
+		logger.Warn("cannot skip block hole", zap.Error(err))
+		return
+	}
+	logger.Warn("block hole skipped, the merged files will not hold its blocks", zap.Uint64("inclusive_low_block", d.reported.InclusiveLowBlock), zap.Uint64("exclusive_high_block", d.reported.ExclusiveHighBlock))
+	metrics.SkippedBlockHoles.Inc()
+	metrics.BlockHole.SetUint64(0)
+	d.candidate, d.reported = nil, nil
+}
+
+// BlockHole returns the hole holding the bundler, if any was detected
+func (m *Merger) BlockHole() *BlockHole {
+	if m.holes == nil {
+		return nil
+	}
+	m.holes.Lock()
+	defer m.holes.Unlock()
+	return m.holes.reported
+}
+
+// findHole returns the lowest hole above the blocks linked to the bundle, with the highest linked block below it and
+// the lowest block above it. It must be called from the thread calling HandleBlockFile.
+func (b *Bundler) findHole() (hole *BlockHole, below, above *bstream.OneBlockFile) {
+	linked := make(map[string]*bstream.OneBlockFile)
+	b.Lock()
+	for _, obf := range b.irreversibleBlocks {
+		linked[obf.ID] = obf
+	}
+	for _, obf := range b.heldBlocks {
+		linked[obf.ID] = obf
+	}
+	b.Unlock()
+	for _, obf := range b.placeholders {
+		linked[obf.ID] = obf
+	}
+	var highest uint64
+	for _, obf := range linked {
+		if below == nil || obf.Num > below.Num {
+			below, highest = obf, obf.Num
+		}
+	}
+	if b.lib != nil && b.lib.Num() >= highest {
+		highest = b.lib.Num()
+		below = nil
+		linked[b.lib.ID()] = &bstream.OneBlockFile{ID: b.lib.ID(), Num: b.lib.Num()}
+	}
+
+	var seen []*bstream.OneBlockFile
+	for _, obf := range b.seenBlockFiles {
+		if obf.Num >= b.baseBlockNum {
+			seen = append(seen, obf)
+		}
+	}
+	if len(seen) == 0 {
+		return nil, nil, nil
+	}
+	sort.Slice(seen, func(i, j int) bool { return seen[i].Num < seen[j].Num })
+	if len(linked) == 0 {
+		for _, obf := range seen {
+			if obf.Num != seen[0].Num {
+				break
+			}
+			linked[obf.ID] = obf // starting without LIB, the first blocks link to nothing
+		}
+	}
+
+	var unlinked []*bstream.OneBlockFile
+	for _, obf := range seen {
+		if _, ok := linked[obf.PreviousID]; ok {
+			linked[obf.ID] = obf
+			if obf.Num >= highest {
+				below, highest = obf, obf.Num
+			}
+			continue
+		}
+		if _, ok := linked[obf.ID]; !ok {
+			unlinked = append(unlinked, obf)
+		}
+	}
+	for _, obf := range unlinked {
+		if obf.Num > highest {
+			above = obf
+			break
+		}
+	}
+	if above == nil {
+		return nil, nil, nil
+	}
+	return &BlockHole{
+		InclusiveLowBlock:  highest + 1,
+		ExclusiveHighBlock: above.Num,
+		MissingParentID:    above.PreviousID,
+	}, below, above
+}
+
+// skipHole feeds the forkdb a placeholder for the missing parent of `above`, child of `below`, never merged
+func (b *Bundler) skipHole(below, above *bstream.OneBlockFile) error {
+	if below == nil {
+		return fmt.Errorf("no block below the hole, the bundler starts from the LIB of the merged files")
+	}
+	if above.Num <= below.Num+1 {
+		return fmt.Errorf("no block number left for the missing parent %s of block %d", above.PreviousID, above.Num)
+	}
+	placeholder := &bstream.OneBlockFile{
+		CanonicalName: fmt.Sprintf("%010d-%s-%s-%d", above.Num-1, above.PreviousID, below.ID, below.LibNum),
+		Filenames:     map[string]bool{},
+		ID:            above.PreviousID,
+		PreviousID:    below.ID,
+		Num:           above.Num - 1,
+		LibNum:        below.LibNum,
+	}
+	if b.placeholders == nil {
+		b.placeholders = make(map[string]*bstream.OneBlockFile)
+	}
+	b.placeholders[placeholder.CanonicalName] = placeholder
+	return b.forkable.ProcessBlock(placeholder.ToBstreamBlock(), placeholder)
+}
@@ -0,0 +1,56 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundler_FindHole(t *testing.T) {
+	b := NewBundler(100, 0, 100, 100, &TestMergerIO{})
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block103Final101, block104Final102} {
+		require.NoError(t, b.HandleBlockFile(obf))
+	}
+
+	hole, below, above := b.findHole()
+	require.NotNil(t, hole)
+	assert.Equal(t, &BlockHole{InclusiveLowBlock: 102, ExclusiveHighBlock: 103, MissingParentID: "0000000000000102a"}, hole)
+	assert.Equal(t, block101, below)
+	assert.Equal(t, block103Final101, above)
+
+	require.NoError(t, b.HandleBlockFile(block102Final100))
+	hole, _, _ = b.findHole()
+	assert.Nil(t, hole)
+}
+
+func TestHoleDetector(t *testing.T) {
+	b := NewBundler(100, 0, 100, 100, &TestMergerIO{})
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block103Final101, block104Final102} {
+		require.NoError(t, b.HandleBlockFile(obf))
+	}
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithHoleDetection(time.Minute, false))
+	now := time.Now()
+
+	m.holes.evaluate(b, now, testLogger)
+	assert.Nil(t, m.BlockHole(), "within the grace period")
+	m.holes.evaluate(b, now.Add(2*time.Minute), testLogger)
+	require.NotNil(t, m.BlockHole())
+	assert.Equal(t, uint64(102), m.BlockHole().InclusiveLowBlock)
+	assert.Equal(t, now, m.BlockHole().Since)
+	assert.Equal(t, m.BlockHole(), m.collectAdminStatus().BlockHole)
+
+	m.holes.skip = true
+	m.holes.evaluate(b, now.Add(3*time.Minute), testLogger)
+	assert.Nil(t, m.BlockHole())
+	require.Len(t, b.placeholders, 1)
+	for _, placeholder := range b.placeholders {
+		assert.Equal(t, uint64(102), placeholder.Num)
+		assert.Equal(t, "0000000000000102a", placeholder.ID)
+		assert.Equal(t, "0000000000000101a", placeholder.PreviousID)
+	}
+	hole, _, _ := b.findHole()
+	assert.Nil(t, hole, "the blocks above link to the placeholder")
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/bstream/hub"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+)
+
+// DefaultHubHandoffSize is how many blocks received from the hub are kept until they are merged
+var DefaultHubHandoffSize = 1000
+
+// HubHandoff receives the blocks of a ForkableHub running in the same binary (ex: firehose single-binary deployments)
+// and hands them to the merger: with WithHubHandoff, the one-block files already received through the hub are not
+// downloaded again from the one-block store when merging. Blocks are kept until their bundle is merged, or until
+// `maxBlocks` newer ones were received.
+type HubHandoff struct {
+	sync.Mutex
+	maxBlocks int
+	blocks    map[string][]byte // one-block file data, by canonical name
+	blockNums map[string]uint64
+	order     []string
+}
+
+func NewHubHandoff(maxBlocks int) *HubHandoff {
+	return &HubHandoff{
+		maxBlocks: maxBlocks,
+		blocks:    make(map[string][]byte),
+		blockNums: make(map[string]uint64),
+	}
+}
+
+// Subscribe receives the blocks of the hub, forked ones included, starting at `startBlock`.
+// The hub must be ready (see hub.ForkableHub.Ready) and still hold `startBlock`.
+func (h *HubHandoff) Subscribe(fh *hub.ForkableHub, startBlock uint64) (bstream.Source, error) {
+	src := fh.SourceFromBlockNumWithForks(startBlock, h)
+	if src == nil {
+		return nil, fmt.Errorf("hub cannot serve blocks from %d", startBlock)
+	}
+	go src.Run()
+	return src, nil
+}
+
+// ProcessBlock implements bstream.Handler, it encodes the block like a one-block file
+func (h *HubHandoff) ProcessBlock(blk *bstream.Block, _ interface{}) error {
+	buf := &bytes.Buffer{}
+	blockWriter, err := bstream.GetBlockWriterFactory.New(buf)
+	if err != nil {
+		return fmt.Errorf("unable to create block writer: %w", err)
+	}
+	if err := blockWriter.Write(blk); err != nil {
+		return fmt.Errorf("writing block %s: %w", blk, err)
+	}
+
+	canonicalName := blockCanonicalName(blk)
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.blocks[canonicalName]; ok {
+		return nil
+	}
+	h.blocks[canonicalName] = buf.Bytes()
+	h.blockNums[canonicalName] = blk.Num()
+	h.order = append(h.order, canonicalName)
+	for h.maxBlocks > 0 && len(h.order) > h.maxBlocks {
+		h.remove(h.order[0])
+	}
+	return nil
+}
+
+func (h *HubHandoff) get(canonicalName string) ([]byte, bool) {
+	h.Lock()
+	defer h.Unlock()
+	data, ok := h.blocks[canonicalName]
+	return data, ok
+}
+
+// prune drops the blocks below `exclusiveHighBlock`, once their bundle is merged
+func (h *HubHandoff) prune(exclusiveHighBlock uint64) {
+	h.Lock()
+	defer h.Unlock()
+	for _, name := range append([]string(nil), h.order...) {
+		if h.blockNums[name] < exclusiveHighBlock {
+			h.remove(name)
+		}
+	}
+}
+
+// remove must be called with the lock held
+func (h *HubHandoff) remove(canonicalName string) {
+	delete(h.blocks, canonicalName)
+	delete(h.blockNums, canonicalName)
+	for i, name := range h.order {
+		if name == canonicalName {
+			h.order = append(h.order[:i], h.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// blockCanonicalName is the canonical name of the one-block file of `blk` (without suffix, see parseOneBlockFilename)
+func blockCanonicalName(blk *bstream.Block) string {
+	name := bstream.BlockFileNameWithSuffix(blk, "")
+	return strings.TrimSuffix(name, "-")
+}
+
+// WithHubHandoff makes DownloadOneBlockFile use the blocks received from a co-located ForkableHub when it has them
+func WithHubHandoff(h *HubHandoff) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.hubHandoff = h
+	}
+}
+
+// HubOneBlocksSourceFactory returns the one-blocks source factory to give to hub.NewForkableHub, so that a co-located
+// hub bootstraps from the one-block files known to the merger: the data memoized by the bundler is reused,
+// the rest is downloaded through the merger IO.
+func (m *Merger) HubOneBlocksSourceFactory() bstream.SourceFromNumFactory {
+	return func(startBlockNum uint64, handler bstream.Handler) bstream.Source {
+		return &hubSeedSource{
+			Shutter:    shutter.New(),
+			merger:     m,
+			startBlock: startBlockNum,
+			handler:    handler,
+		}
+	}
+}
+
+type hubSeedSource struct {
+	*shutter.Shutter
+	merger     *Merger
+	startBlock uint64
+	handler    bstream.Handler
+}
+
+func (s *hubSeedSource) Run() {
+	s.Shutdown(s.run())
+}
+
+func (s *hubSeedSource) run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.OnTerminating(func(_ error) { cancel() })
+
+	memoized := s.merger.bundler.knownBlockFiles()
+	var files []*bstream.OneBlockFile
+	err := s.merger.io.WalkOneBlockFiles(ctx, s.startBlock, func(obf *bstream.OneBlockFile) error {
+		if known, ok := memoized[obf.CanonicalName]; ok {
+			obf = known
+		}
+		files = append(files, obf)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walking one-block files from %d: %w", s.startBlock, err)
+	}
+
+	for _, obf := range files {
+		data, err := obf.Data(ctx, s.merger.io.DownloadOneBlockFile)
+		if err != nil {
+			return err
+		}
+		blockReader, err := bstream.GetBlockReaderFactory.New(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("unable to create block reader: %w", err)
+		}
+		blk, err := blockReader.Read()
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("block reader failed: %w", err)
+		}
+		if err := s.handler.ProcessBlock(blk, nil); err != nil {
+			return err
+		}
+	}
+	s.merger.logger.Info("hub seeded from one-block files", zap.Uint64("start_block", s.startBlock), zap.Int("count", len(files)))
+	return nil
+}
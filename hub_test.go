@@ -0,0 +1,84 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubHandoff(t *testing.T) {
+	h := NewHubHandoff(2)
+	for _, js := range []string{
+		`{"id":"0000000000000100a","prev":"0000000000000099a","num":100,"libnum":98}`,
+		`{"id":"0000000000000101a","prev":"0000000000000100a","num":101,"libnum":99}`,
+		`{"id":"0000000000000102a","prev":"0000000000000101a","num":102,"libnum":100}`,
+	} {
+		require.NoError(t, h.ProcessBlock(bstream.TestBlockFromJSON(js), nil))
+	}
+
+	_, ok := h.get("0000000100-000000000000100a-000000000000099a-98")
+	assert.False(t, ok, "evicted above max blocks")
+	data, ok := h.get("0000000101-000000000000101a-000000000000100a-99")
+	require.True(t, ok)
+	assert.Equal(t, `{"id":"0000000000000101a","prev":"0000000000000100a","num":101,"libnum":99}`+"\n", string(data))
+
+	h.prune(102)
+	_, ok = h.get("0000000101-000000000000101a-000000000000100a-99")
+	assert.False(t, ok, "pruned once merged")
+	_, ok = h.get("0000000102-000000000000102a-000000000000101a-100")
+	assert.True(t, ok)
+}
+
+func TestDStoreIO_DownloadFromHubHandoff(t *testing.T) {
+	h := NewHubHandoff(DefaultHubHandoffSize)
+	require.NoError(t, h.ProcessBlock(bstream.TestBlockFromJSON(`{"id":"0000000000000100a","prev":"0000000000000099a","num":100,"libnum":98}`), nil))
+
+	oneBlocksStore := dstore.NewMockStore(nil) // empty, downloads would fail
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithHubHandoff(h)).(*DStoreIO)
+
+	data, err := mio.DownloadOneBlockFile(context.Background(), mustNewOneBlockFile("0000000100-000000000000100a-000000000000099a-98-suffix"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, data)
+}
+
+func TestHubOneBlocksSourceFactory(t *testing.T) {
+	var downloaded []string
+	io := &TestMergerIO{
+		WalkOneBlockFilesFunc: func(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+			for _, name := range []string{
+				"0000000100-000000000000100a-000000000000099a-98-suffix",
+				"0000000101-000000000000101a-000000000000100a-99-suffix",
+			} {
+				if err := callback(mustNewOneBlockFile(name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		DownloadOneBlockFileFunc: func(ctx context.Context, obf *bstream.OneBlockFile) ([]byte, error) {
+			downloaded = append(downloaded, obf.CanonicalName)
+			return []byte(fmt.Sprintf(`{"id":"%s","prev":"%s","num":%d,"libnum":%d}`, obf.ID, obf.PreviousID, obf.Num, obf.LibNum)), nil
+		},
+	}
+	m := NewMerger(testLogger, "", io, 100, 100, 100, 0, 0, 0)
+
+	memoized := mustNewOneBlockFile("0000000100-000000000000100a-000000000000099a-98-suffix")
+	memoized.MemoizeData = []byte(`{"id":"000000000000100a","prev":"000000000000099a","num":100,"libnum":98}`)
+	m.bundler.irreversibleBlocks = []*bstream.OneBlockFile{memoized}
+
+	var received []uint64
+	src := m.HubOneBlocksSourceFactory()(100, bstream.HandlerFunc(func(blk *bstream.Block, _ interface{}) error {
+		received = append(received, blk.Num())
+		return nil
+	}))
+	src.Run()
+	require.NoError(t, src.Err())
+
+	assert.Equal(t, []uint64{100, 101}, received)
+	assert.Equal(t, []string{"0000000101-000000000000101a-000000000000100a-99"}, downloaded, "memoized data is reused")
+}
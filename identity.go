@@ -0,0 +1,113 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+)
+
+const modulePath = "github.com/sadiq1971/merger"
+
+// Version and Commit identify the build, set them with
+// `-ldflags "-X github.com/sadiq1971/merger.Version=<version> -X github.com/sadiq1971/merger.Commit=<commit>"`.
+// When empty, they are read from the build info of the binary.
+var (
+	Version string
+	Commit  string
+)
+
+// InstanceIdentity tells which instance, build and configuration of the merger is running
+type InstanceIdentity struct {
+	InstanceID string    `json:"instance_id"`
+	Hostname   string    `json:"hostname"`
+	Version    string    `json:"version"`
+	Commit     string    `json:"commit"`
+	ConfigHash string    `json:"config_hash,omitempty"`
+	StartTime  time.Time `json:"start_time"`
+}
+
+// WithInstanceIdentity sets the ID of this instance (the hostname by default) and the hash of its configuration,
+// reported with the build in the admin status, `/statz` and the `merger_build_info` metric
+func WithInstanceIdentity(instanceID, configHash string) Option {
+	return func(m *Merger) {
+		if instanceID != "" {
+			m.identity.InstanceID = instanceID
+		}
+		m.identity.ConfigHash = configHash
+	}
+}
+
+func newInstanceIdentity() InstanceIdentity {
+	hostname, _ := os.Hostname()
+	version, commit := buildVersion()
+	return InstanceIdentity{
+		InstanceID: hostname,
+		Hostname:   hostname,
+		Version:    version,
+		Commit:     commit,
+		StartTime:  time.Now(),
+	}
+}
+
+// buildVersion returns Version and Commit, falling back to the build info of the binary
+func buildVersion() (version, commit string) {
+	version, commit = Version, Commit
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if version == "" {
+			if info.Main.Path == modulePath {
+				version = info.Main.Version
+			}
+			for _, dep := range info.Deps {
+				if dep.Path == modulePath {
+					version = dep.Version
+				}
+			}
+		}
+		if commit == "" {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					commit = setting.Value
+				}
+			}
+		}
+	}
+	if version == "" {
+		version = "unknown"
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	return
+}
+
+// Identity returns the identity of this instance
+func (m *Merger) Identity() InstanceIdentity {
+	return m.identity
+}
+
+func (m *Merger) setBuildInfoMetric() {
+	metrics.BuildInfo.SetInt(1, m.identity.InstanceID, m.identity.Hostname, m.identity.Version, m.identity.Commit, m.identity.ConfigHash)
+}
+
+func (m *Merger) statzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.identity)
+}
@@ -0,0 +1,36 @@
+package merger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerger_Identity(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	identity := m.Identity()
+	assert.Equal(t, hostname, identity.InstanceID, "the instance ID defaults to the hostname")
+	assert.Equal(t, hostname, identity.Hostname)
+	assert.NotEmpty(t, identity.Version)
+	assert.NotEmpty(t, identity.Commit)
+	assert.False(t, identity.StartTime.IsZero())
+
+	m = NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithInstanceIdentity("merger-0", "abcdef012345"))
+	rec := httptest.NewRecorder()
+	m.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/statz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	statz := InstanceIdentity{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statz))
+	assert.Equal(t, "merger-0", statz.InstanceID)
+	assert.Equal(t, "abcdef012345", statz.ConfigHash)
+	assert.Equal(t, "merger-0", m.collectAdminStatus().Identity.InstanceID)
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sadiq1971/merger/mergertest"
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// InMemoryPipeline runs a merger over memory-backed stores fed by the mergertest chain generator: the full one-block to
+// merged flow, without any cloud store or credentials. It is meant for demos and for integration tests.
+type InMemoryPipeline struct {
+	OneBlocksStore    *MemoryStore
+	MergedBlocksStore *MemoryStore
+	ForkedBlocksStore *MemoryStore
+
+	// BlockSize is the payload size of the generated blocks, in bytes
+	BlockSize int
+	Logger    *zap.Logger
+
+	bundleSize uint64
+	opts       []Option
+}
+
+// NewInMemoryPipeline returns a pipeline merging bundles of `bundleSize` blocks, `opts` being passed to the merger
+func NewInMemoryPipeline(bundleSize uint64, opts ...Option) *InMemoryPipeline {
+	return &InMemoryPipeline{
+		OneBlocksStore:    NewMemoryStore(),
+		MergedBlocksStore: NewMemoryStore(),
+		ForkedBlocksStore: NewMemoryStore(),
+		BlockSize:         64,
+		Logger:            zap.NewNop(),
+		bundleSize:        bundleSize,
+		opts:              opts,
+	}
+}
+
+// Run generates the one-block files of `bundles` bundles from `startBlock` (aligned on the bundle size), with a fork
+// every `forkEvery` blocks (0 means never), and merges them, returning once the last bundle is merged or `ctx` is done.
+//
+// It points the bstream registry (block reader factory, payload setter and header length) at the dbin format of the
+// generated blocks; a caller sharing the process with other formats has to restore it.
+func (p *InMemoryPipeline) Run(ctx context.Context, startBlock, bundles, forkEvery uint64) error {
+	if startBlock%p.bundleSize != 0 {
+		return fmt.Errorf("start block %d is not aligned on the bundle size %d", startBlock, p.bundleSize)
+	}
+	if err := useGeneratedBlocksFormat(); err != nil {
+		return err
+	}
+
+	stopBlock := startBlock + bundles*p.bundleSize
+	// the last bundle is complete once the block above it is irreversible
+	count := stopBlock - startBlock + mergertest.LIBDistance + 1
+	if err := mergertest.GenerateChain(ctx, p.OneBlocksStore, startBlock, count, forkEvery, p.BlockSize); err != nil {
+		return fmt.Errorf("generating one-block files: %w", err)
+	}
+
+	mergerIO := NewDStoreIO(p.Logger, noopLoggingTracer{}, p.OneBlocksStore, p.MergedBlocksStore, p.ForkedBlocksStore, 3, 10*time.Millisecond, p.bundleSize)
+	m := NewMerger(p.Logger, "", mergerIO, startBlock, p.bundleSize, p.bundleSize, time.Hour, 10*time.Millisecond, stopBlock, p.opts...)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.Shutdown(ctx.Err())
+		case <-m.Terminated():
+		}
+	}()
+	m.Run()
+	// the pre-downloads read the block times with the generated blocks format, which the caller may change once we return
+	m.bundler.preDownloads.Wait()
+
+	if err := m.Err(); err != nil && !errors.Is(err, ErrStopBlockReached) {
+		return err
+	}
+	return nil
+}
+
+// MergedBundles returns the base block numbers of the merged files, in order
+func (p *InMemoryPipeline) MergedBundles(ctx context.Context) (out []uint64, err error) {
+	files, err := p.MergedBlocksStore.ListFiles(ctx, "", -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		var base uint64
+		if _, err := fmt.Sscanf(file, "%d", &base); err != nil {
+			return nil, fmt.Errorf("merged file %q: %w", file, err)
+		}
+		out = append(out, base)
+	}
+	return out, nil
+}
+
+// ReadBundle returns the blocks of the merged file starting at `baseBlockNum`
+func (p *InMemoryPipeline) ReadBundle(ctx context.Context, baseBlockNum uint64) (out []*bstream.Block, err error) {
+	reader, err := p.MergedBlocksStore.OpenObject(ctx, fileNameForBlocksBundle(baseBlockNum))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	blockReader, err := bstream.NewDBinBlockReader(reader, nil)
+	if err != nil {
+		return nil, fmt.Errorf("reading merged file %d: %w", baseBlockNum, err)
+	}
+	for {
+		block, err := blockReader.Read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading merged file %d: %w", baseBlockNum, err)
+		}
+		out = append(out, block)
+	}
+}
+
+func useGeneratedBlocksFormat() error {
+	header := bytes.NewBuffer(nil)
+	if _, err := bstream.NewDBinBlockWriter(header, mergertest.ContentType, mergertest.ContentVersion); err != nil {
+		return fmt.Errorf("writing dbin header: %w", err)
+	}
+	bstream.GetBlockWriterHeaderLen = header.Len()
+	bstream.GetBlockPayloadSetter = bstream.MemoryBlockPayloadSetter
+	bstream.GetBlockReaderFactory = bstream.BlockReaderFactoryFunc(func(reader io.Reader) (bstream.BlockReader, error) {
+		return bstream.NewDBinBlockReader(reader, nil)
+	})
+	return nil
+}
+
+type noopLoggingTracer struct{}
+
+func (noopLoggingTracer) Enabled() bool { return false }
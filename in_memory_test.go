@@ -0,0 +1,72 @@
+package merger
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sadiq1971/merger/mergertest"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryPipeline(t *testing.T) {
+	readerFactory, payloadSetter, headerLen := bstream.GetBlockReaderFactory, bstream.GetBlockPayloadSetter, bstream.GetBlockWriterHeaderLen
+	defer func() {
+		bstream.GetBlockReaderFactory, bstream.GetBlockPayloadSetter, bstream.GetBlockWriterHeaderLen = readerFactory, payloadSetter, headerLen
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p := NewInMemoryPipeline(100)
+	p.Logger = testLogger
+	require.NoError(t, p.Run(ctx, 100, 3, 7))
+
+	bundles, err := p.MergedBundles(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{100, 200, 300}, bundles)
+
+	blocks, err := p.ReadBundle(ctx, 200)
+	require.NoError(t, err)
+	ids := make(map[string]bool)
+	for _, block := range blocks {
+		assert.True(t, block.Number >= 200 && block.Number < 300, "block %d outside of its bundle", block.Number)
+		ids[block.Id] = true
+	}
+	for num := uint64(200); num < 300; num++ {
+		assert.True(t, ids[mergertest.BlockID(num, 'a')], "block %d missing", num)
+	}
+
+	assert.Error(t, p.Run(ctx, 150, 1, 0), "start block not aligned")
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	sub, err := store.SubStore("sub")
+	require.NoError(t, err)
+
+	require.NoError(t, store.WriteObject(ctx, "b", strings.NewReader("1")))
+	require.NoError(t, store.WriteObject(ctx, "a-error", strings.NewReader("2")))
+	require.NoError(t, sub.WriteObject(ctx, "c", strings.NewReader("3")))
+	require.NoError(t, store.WriteObject(ctx, "b", strings.NewReader("ignored")))
+
+	files, err := store.ListFiles(ctx, "", -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a-error", "b", "sub/c"}, files)
+
+	exists, err := store.FileExists(ctx, "sub/c")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = store.OpenObject(ctx, "missing")
+	assert.Equal(t, dstore.ErrNotFound, err)
+	assert.Equal(t, dstore.ErrNotFound, store.DeleteObject(ctx, "missing"))
+
+	require.NoError(t, sub.DeleteObject(ctx, "c"))
+	assert.Equal(t, 2, store.Len())
+}
@@ -15,6 +15,12 @@
 package merger
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/streamingfast/bstream"
 	"github.com/streamingfast/logging"
 )
 
@@ -23,3 +29,22 @@ var testLogger, testTracer = logging.PackageLogger("merger", "github.com/sadiq19
 func init() {
 	logging.InstantiateLoggers()
 }
+
+// TestMain sets the block factories once: the bundler pre-downloads the blocks in goroutines that read them, and may
+// outlive the test that started them
+func TestMain(m *testing.M) {
+	bstream.GetBlockWriterFactory = bstream.BlockWriterFactoryFunc(func(w io.Writer) (bstream.BlockWriter, error) {
+		return &jsonBlockWriter{w: w}, nil
+	})
+	bstream.GetBlockReaderFactory = bstream.TestBlockReaderFactory
+	os.Exit(m.Run())
+}
+
+type jsonBlockWriter struct {
+	w io.Writer
+}
+
+func (w *jsonBlockWriter) Write(blk *bstream.Block) error {
+	_, err := fmt.Fprintf(w.w, `{"id":%q,"prev":%q,"num":%d,"libnum":%d}`+"\n", blk.Id, blk.PreviousId, blk.Number, blk.LibNum)
+	return err
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/streamingfast/bstream"
+)
+
+var errStopInspecting = errors.New("stop inspecting")
+
+// OneBlockFileInfo is what the merger sees of a one-block file
+type OneBlockFileInfo struct {
+	Filename      string `json:"filename"`
+	CanonicalName string `json:"canonical_name"`
+	Num           uint64 `json:"num"`
+	ID            string `json:"id"`
+	PreviousID    string `json:"previous_id"`
+	LibNum        uint64 `json:"lib_num"`
+	// Payload is the raw file content, only filled when asked for
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// InspectOneBlockFiles returns the one-block files of block `blockNum` (one per fork and per reader), read through
+// the merger's own store access, so that operators can see exactly what it sees without credentials to the bucket.
+func (m *Merger) InspectOneBlockFiles(ctx context.Context, blockNum uint64, withPayload bool) ([]*OneBlockFileInfo, error) {
+	var oneBlockFiles []*bstream.OneBlockFile
+	err := m.io.WalkOneBlockFiles(ctx, blockNum, func(obf *bstream.OneBlockFile) error {
+		if obf.Num > blockNum {
+			return errStopInspecting
+		}
+		if obf.Num == blockNum {
+			oneBlockFiles = append(oneBlockFiles, obf)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopInspecting) {
+		return nil, err
+	}
+
+	out := []*OneBlockFileInfo{}
+	for _, obf := range oneBlockFiles {
+		for filename := range obf.Filenames {
+			info := &OneBlockFileInfo{
+				Filename:      filename,
+				CanonicalName: obf.CanonicalName,
+				Num:           obf.Num,
+				ID:            obf.ID,
+				PreviousID:    obf.PreviousID,
+				LibNum:        obf.LibNum,
+			}
+			if withPayload {
+				data, err := m.io.DownloadOneBlockFile(ctx, obf)
+				if err != nil {
+					return nil, err
+				}
+				info.Payload = data
+			}
+			out = append(out, info)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Filename < out[j].Filename })
+	return out, nil
+}
+
+// inspectHandler serves `GET /one-block-files?block=<num>[&payload=true]` on the admin listener
+func (m *Merger) inspectHandler(w http.ResponseWriter, r *http.Request) {
+	blockNum, err := strconv.ParseUint(r.URL.Query().Get("block"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid or missing 'block' parameter", http.StatusBadRequest)
+		return
+	}
+	withPayload, _ := strconv.ParseBool(r.URL.Query().Get("payload"))
+
+	infos, err := m.InspectOneBlockFiles(r.Context(), blockNum, withPayload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
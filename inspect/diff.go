@@ -0,0 +1,66 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inspect
+
+import (
+	"fmt"
+)
+
+// Difference is a block that differs between two bundles, see Diff
+type Difference struct {
+	BlockNum uint64 `json:"block_num"`
+	BlockID  string `json:"block_id"`
+	// Left and Right are the block in each bundle, nil when missing from it
+	Left  *Block `json:"left,omitempty"`
+	Right *Block `json:"right,omitempty"`
+}
+
+func (d *Difference) String() string {
+	switch {
+	case d.Right == nil:
+		return fmt.Sprintf("block %d (%s): only in the left bundle", d.BlockNum, d.BlockID)
+	case d.Left == nil:
+		return fmt.Sprintf("block %d (%s): only in the right bundle", d.BlockNum, d.BlockID)
+	}
+	return fmt.Sprintf("block %d (%s): previous %s/%s, lib %d/%d, timestamp %s/%s", d.BlockNum, d.BlockID,
+		d.Left.PreviousID, d.Right.PreviousID, d.Left.LibNum, d.Right.LibNum, d.Left.Timestamp, d.Right.Timestamp)
+}
+
+// Diff returns the blocks of `left` and `right` that are missing from the other bundle, or present in both with
+// different fields, in the order of `left` then of the blocks only in `right`
+func Diff(left, right *Bundle) (out []*Difference) {
+	rights := make(map[string]*Block)
+	for _, b := range right.Blocks {
+		rights[b.ID] = b
+	}
+	lefts := make(map[string]bool)
+	for _, l := range left.Blocks {
+		lefts[l.ID] = true
+		r := rights[l.ID]
+		if r == nil || !sameBlock(l, r) {
+			out = append(out, &Difference{BlockNum: l.Num, BlockID: l.ID, Left: l, Right: r})
+		}
+	}
+	for _, r := range right.Blocks {
+		if !lefts[r.ID] {
+			out = append(out, &Difference{BlockNum: r.Num, BlockID: r.ID, Right: r})
+		}
+	}
+	return out
+}
+
+func sameBlock(a, b *Block) bool {
+	return a.Num == b.Num && a.PreviousID == b.PreviousID && a.LibNum == b.LibNum && a.Timestamp.Equal(b.Timestamp)
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inspect reads merged bundles to print their blocks, check their linkage and diff them, for incident
+// investigation without going through bstream internals
+package inspect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+)
+
+// Block is what a merged bundle tells about one of its blocks
+type Block struct {
+	Num        uint64    `json:"num"`
+	ID         string    `json:"id"`
+	PreviousID string    `json:"previous_id"`
+	LibNum     uint64    `json:"lib_num"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// Bundle is the content of a merged file, blocks in file order
+type Bundle struct {
+	BaseBlock uint64   `json:"base_block"`
+	Blocks    []*Block `json:"blocks"`
+}
+
+// ReadBundle reads the merged file of the bundle starting at `baseBlock` from the merged blocks store
+func ReadBundle(ctx context.Context, store dstore.Store, baseBlock uint64) (*Bundle, error) {
+	reader, err := store.OpenObject(ctx, fmt.Sprintf("%010d", baseBlock))
+	if err != nil {
+		return nil, fmt.Errorf("opening merged file %010d: %w", baseBlock, err)
+	}
+	defer reader.Close()
+
+	bundle, err := readBundle(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading merged file %010d: %w", baseBlock, err)
+	}
+	bundle.BaseBlock = baseBlock
+	return bundle, nil
+}
+
+// ReadBundleFile reads the merged file at `fileURL` (a local path or a store URL, e.g.
+// `gs://bucket/merged/0000001000.dbin.zst`), its base block being its name
+func ReadBundleFile(ctx context.Context, fileURL string) (*Bundle, error) {
+	dir, name := path.Split(fileURL)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".zst"), ".dbin")
+	baseBlock, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("merged file name %q is not a block number", name)
+	}
+	if dir == "" {
+		dir = "."
+	}
+	store, err := dstore.NewDBinStore(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening store %q: %w", dir, err)
+	}
+	return ReadBundle(ctx, store, baseBlock)
+}
+
+func readBundle(reader io.Reader) (*Bundle, error) {
+	blockReader, err := bstream.NewDBinBlockReader(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &Bundle{}
+	for {
+		block, err := blockReader.Read()
+		if block != nil {
+			bundle.Blocks = append(bundle.Blocks, &Block{
+				Num:        block.Number,
+				ID:         block.Id,
+				PreviousID: block.PreviousId,
+				LibNum:     block.LibNum,
+				Timestamp:  block.Timestamp,
+			})
+		}
+		if err == io.EOF {
+			return bundle, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Print writes the blocks of `bundle`, one per line
+func Print(w io.Writer, bundle *Bundle) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NUM\tID\tPREVIOUS ID\tLIB NUM\tTIMESTAMP\n")
+	for _, b := range bundle.Blocks {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\n", b.Num, b.ID, b.PreviousID, b.LibNum, b.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,102 @@
+package inspect
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testBlock(num uint64, id, previousID string, libNum uint64) *bstream.Block {
+	block := &bstream.Block{Id: id, Number: num, PreviousId: previousID, LibNum: libNum, Timestamp: t0.Add(time.Duration(num) * time.Second)}
+	block, _ = bstream.MemoryBlockPayloadSetter(block, []byte{0x01})
+	return block
+}
+
+func writeBundle(t *testing.T, store dstore.Store, name string, blocks ...*bstream.Block) {
+	buffer := bytes.NewBuffer(nil)
+	writer, err := bstream.NewDBinBlockWriter(buffer, "TST", 1)
+	require.NoError(t, err)
+	for _, block := range blocks {
+		require.NoError(t, writer.Write(block))
+	}
+	require.NoError(t, store.WriteObject(context.Background(), name, buffer))
+}
+
+func TestReadBundle(t *testing.T) {
+	bstream.GetBlockPayloadSetter = bstream.MemoryBlockPayloadSetter
+	store := dstore.NewMockStore(nil)
+	writeBundle(t, store, "0000000100",
+		testBlock(100, "100a", "99a", 98),
+		testBlock(101, "101a", "100a", 99),
+		testBlock(102, "102b", "101a", 99),
+		testBlock(102, "102a", "101a", 100),
+	)
+
+	bundle, err := ReadBundle(context.Background(), store, 100)
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, bundle.BaseBlock)
+	require.Len(t, bundle.Blocks, 4)
+	assert.Equal(t, &Block{Num: 101, ID: "101a", PreviousID: "100a", LibNum: 99, Timestamp: t0.Add(101 * time.Second)}, bundle.Blocks[1])
+	assert.Empty(t, CheckLinkage(bundle, 100))
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, Print(buffer, bundle))
+	assert.Contains(t, buffer.String(), "102  102b  101a         99")
+
+	_, err = ReadBundle(context.Background(), store, 200)
+	assert.Error(t, err)
+}
+
+func TestCheckLinkage(t *testing.T) {
+	bundle := &Bundle{BaseBlock: 100, Blocks: []*Block{
+		{Num: 100, ID: "100a", PreviousID: "99a", LibNum: 98},
+		{Num: 102, ID: "102a", PreviousID: "101a", LibNum: 100},
+		{Num: 101, ID: "101a", PreviousID: "100a", LibNum: 102},
+		{Num: 200, ID: "200a", PreviousID: "101a", LibNum: 100},
+	}}
+
+	var problems []string
+	for _, issue := range CheckLinkage(bundle, 100) {
+		problems = append(problems, issue.String())
+	}
+	assert.Equal(t, []string{
+		"block 102 (102a): previous block 101a is not in the bundle",
+		"block 101 (101a): comes after block 102",
+		"block 101 (101a): LIB 102 is above the block",
+		"block 200 (200a): outside of the bundle range [100, 200)",
+	}, problems)
+
+	assert.Len(t, CheckLinkage(&Bundle{BaseBlock: 100}, 100), 1, "empty bundle")
+}
+
+func TestDiff(t *testing.T) {
+	left := &Bundle{BaseBlock: 100, Blocks: []*Block{
+		{Num: 100, ID: "100a", PreviousID: "99a", LibNum: 98},
+		{Num: 101, ID: "101a", PreviousID: "100a", LibNum: 99},
+		{Num: 101, ID: "101b", PreviousID: "100a", LibNum: 99},
+	}}
+	right := &Bundle{BaseBlock: 100, Blocks: []*Block{
+		{Num: 100, ID: "100a", PreviousID: "99a", LibNum: 98},
+		{Num: 101, ID: "101a", PreviousID: "100a", LibNum: 100},
+		{Num: 102, ID: "102a", PreviousID: "101a", LibNum: 100},
+	}}
+
+	var differences []string
+	for _, d := range Diff(left, right) {
+		differences = append(differences, d.String())
+	}
+	assert.Equal(t, []string{
+		"block 101 (101a): previous 100a/100a, lib 99/100, timestamp 0001-01-01 00:00:00 +0000 UTC/0001-01-01 00:00:00 +0000 UTC",
+		"block 101 (101b): only in the left bundle",
+		"block 102 (102a): only in the right bundle",
+	}, differences)
+	assert.Empty(t, Diff(left, left))
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inspect
+
+import (
+	"fmt"
+)
+
+// Issue is a problem found in a bundle by CheckLinkage
+type Issue struct {
+	BlockNum uint64 `json:"block_num"`
+	BlockID  string `json:"block_id"`
+	Problem  string `json:"problem"`
+}
+
+func (i *Issue) String() string {
+	return fmt.Sprintf("block %d (%s): %s", i.BlockNum, i.BlockID, i.Problem)
+}
+
+// CheckLinkage verifies that the blocks of `bundle` are within its range (`bundleSize` blocks from its base block),
+// in increasing order, with their LIB below them, and that each block links to a previous block of the bundle, except
+// those linking below the first block. An empty result means the bundle is consistent.
+func CheckLinkage(bundle *Bundle, bundleSize uint64) (issues []*Issue) {
+	report := func(b *Block, format string, args ...interface{}) {
+		issues = append(issues, &Issue{BlockNum: b.Num, BlockID: b.ID, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	if len(bundle.Blocks) == 0 {
+		return []*Issue{{BlockNum: bundle.BaseBlock, Problem: "empty bundle"}}
+	}
+	seen := make(map[string]uint64) // block ID to number
+	firstNum := bundle.Blocks[0].Num
+	for i, b := range bundle.Blocks {
+		if b.Num < bundle.BaseBlock || (bundleSize != 0 && b.Num >= bundle.BaseBlock+bundleSize) {
+			report(b, "outside of the bundle range [%d, %d)", bundle.BaseBlock, bundle.BaseBlock+bundleSize)
+		}
+		if i > 0 && b.Num < bundle.Blocks[i-1].Num {
+			report(b, "comes after block %d", bundle.Blocks[i-1].Num)
+		}
+		if b.LibNum > b.Num {
+			report(b, "LIB %d is above the block", b.LibNum)
+		}
+		if _, ok := seen[b.ID]; ok {
+			report(b, "duplicated")
+		}
+		if parentNum, ok := seen[b.PreviousID]; ok {
+			if parentNum >= b.Num {
+				report(b, "previous block %s is not below it (%d)", b.PreviousID, parentNum)
+			}
+		} else if b.Num != firstNum {
+			report(b, "previous block %s is not in the bundle", b.PreviousID)
+		}
+		seen[b.ID] = b.Num
+	}
+	return issues
+}
@@ -0,0 +1,72 @@
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerger_InspectOneBlockFiles(t *testing.T) {
+	files := []string{
+		"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+		"0000000101-0000000000000101a-0000000000000100a-99-reader1",
+		"0000000101-0000000000000101b-0000000000000100a-99-reader2",
+		"0000000102-0000000000000102a-0000000000000101a-100-suffix",
+	}
+	var walkedPast101 bool
+	mio := &TestMergerIO{
+		WalkOneBlockFilesFunc: func(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+			for _, filename := range files {
+				obf := mustNewOneBlockFile(filename)
+				if obf.Num < inclusiveLowerBlock {
+					continue
+				}
+				if obf.Num > 101 {
+					walkedPast101 = true
+				}
+				if err := callback(obf); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		DownloadOneBlockFileFunc: func(ctx context.Context, obf *bstream.OneBlockFile) ([]byte, error) {
+			return []byte(obf.ID), nil
+		},
+	}
+	m := NewMerger(testLogger, "", mio, 100, 100, 100, time.Second, time.Second, 0)
+
+	infos, err := m.InspectOneBlockFiles(context.Background(), 101, true)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, &OneBlockFileInfo{
+		Filename:      "0000000101-0000000000000101a-0000000000000100a-99-reader1",
+		CanonicalName: "0000000101-0000000000000101a-0000000000000100a-99",
+		Num:           101,
+		ID:            "0000000000000101a",
+		PreviousID:    "0000000000000100a",
+		LibNum:        99,
+		Payload:       []byte("0000000000000101a"),
+	}, infos[0])
+	assert.Equal(t, "0000000000000101b", infos[1].ID)
+	assert.True(t, walkedPast101)
+
+	rec := httptest.NewRecorder()
+	m.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/one-block-files?block=100", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var served []*OneBlockFileInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	require.Len(t, served, 1)
+	assert.Nil(t, served[0].Payload)
+
+	rec = httptest.NewRecorder()
+	m.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/one-block-files", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// MergeIntentPollInterval is how often the intent of another instance is checked again while waiting for it
+var MergeIntentPollInterval = 5 * time.Second
+
+// MergeIntent is written before merging a bundle and deleted once it is stored
+type MergeIntent struct {
+	BaseBlock  uint64    `json:"base_block"`
+	InstanceID string    `json:"instance_id"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// MergeIntentsIOInterface is implemented by the ios writing merge intents
+type MergeIntentsIOInterface interface {
+	// ResolveStaleMergeIntents removes the intents left by instances that stopped while merging
+	ResolveStaleMergeIntents(ctx context.Context) error
+}
+
+// WithMergeIntents writes an intent (instance ID and start time) to `store` before merging each bundle, and deletes it
+// once the bundle is stored. An instance about to merge a bundle with the intent of another instance waits for it to
+// be resolved, or to be older than `staleAfter`. If the merged file then exists and can be read, the bundle is
+// considered complete and is not merged again, otherwise it is merged (overwriting any partial file). The store must
+// not be inside the merged blocks store, whose listing only expects merged files.
+//
+// Intents are best-effort, not a lock: dstore has no conditional write, so two instances that check for an intent at
+// the same time both write theirs and merge the bundle. They write the same merged file, the intents only keep
+// instances failing over each other from merging in parallel for the whole duration of a merge.
+func WithMergeIntents(store dstore.Store, instanceID string, staleAfter time.Duration) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.intentsStore = store
+		s.instanceID = instanceID
+		s.intentStaleAfter = staleAfter
+	}
+}
+
+func (s *DStoreIO) readMergeIntent(ctx context.Context, name string) (*MergeIntent, error) {
+	exists, err := s.intentsStore.FileExists(ctx, name)
+	if err != nil || !exists {
+		return nil, err
+	}
+	reader, err := s.intentsStore.OpenObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	intent := &MergeIntent{}
+	if err := json.Unmarshal(data, intent); err != nil {
+		return nil, fmt.Errorf("decoding merge intent %q: %w", name, err)
+	}
+	return intent, nil
+}
+
+func (s *DStoreIO) stale(intent *MergeIntent) bool {
+	return intent.InstanceID == s.instanceID || time.Since(intent.StartedAt) >= s.intentStaleAfter
+}
+
+// acquireMergeIntent writes our intent to merge the bundle at baseBlock, `complete` is true if
+// another instance already merged it. Reading the intent then writing ours is not atomic, see WithMergeIntents.
+func (s *DStoreIO) acquireMergeIntent(ctx context.Context, baseBlock uint64) (complete bool, err error) {
+	name := fileNameForBlocksBundle(baseBlock)
+	var found *MergeIntent
+	for {
+		intent, err := s.readMergeIntent(ctx, name)
+		if err != nil {
+			return false, fmt.Errorf("reading merge intent: %w", err)
+		}
+		if intent == nil {
+			break
+		}
+		if found == nil {
+			s.logger.Info("another instance intends to merge the bundle, waiting for it",
+				zap.Uint64("base_block", baseBlock),
+				zap.String("instance_id", intent.InstanceID),
+				zap.Time("started_at", intent.StartedAt),
+			)
+		}
+		found = intent
+		if s.stale(intent) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(MergeIntentPollInterval):
+		}
+	}
+
+	if found != nil {
+		if s.mergedFileComplete(ctx, baseBlock) {
+			s.logger.Info("bundle already merged by another instance", zap.Uint64("base_block", baseBlock), zap.String("instance_id", found.InstanceID))
+			s.deleteMergeIntent(baseBlock)
+			return true, nil
+		}
+		s.logger.Warn("resolving stale merge intent by merging the bundle again", zap.Uint64("base_block", baseBlock), zap.String("instance_id", found.InstanceID))
+		// stores created without overwrite would keep the stale intent
+		s.deleteMergeIntent(baseBlock)
+	}
+
+	data, err := json.Marshal(&MergeIntent{BaseBlock: baseBlock, InstanceID: s.instanceID, StartedAt: time.Now().UTC()})
+	if err != nil {
+		return false, err
+	}
+	if err := s.intentsStore.WriteObject(ctx, name, bytes.NewReader(data)); err != nil {
+		return false, fmt.Errorf("writing merge intent: %w", err)
+	}
+	return false, nil
+}
+
+// mergedFileComplete tells if the merged file of the bundle exists and can be read entirely
+func (s *DStoreIO) mergedFileComplete(ctx context.Context, baseBlock uint64) bool {
+	s.mergedFilesCache.remove(baseBlock)
+	_, err := s.fetchMergedFile(ctx, baseBlock)
+	return err == nil
+}
+
+func (s *DStoreIO) deleteMergeIntent(baseBlock uint64) {
+	if err := s.intentsStore.DeleteObject(context.Background(), fileNameForBlocksBundle(baseBlock)); err != nil {
+		s.logger.Warn("cannot delete merge intent", zap.Uint64("base_block", baseBlock), zap.Error(err))
+	}
+}
+
+func (s *DStoreIO) ResolveStaleMergeIntents(ctx context.Context) error {
+	if s.intentsStore == nil {
+		return nil
+	}
+	var names []string
+	if err := s.intentsStore.Walk(ctx, "", func(filename string) error {
+		names = append(names, filename)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("listing merge intents: %w", err)
+	}
+
+	for _, name := range names {
+		baseBlock, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		intent, err := s.readMergeIntent(ctx, name)
+		if err != nil {
+			return err
+		}
+		if intent == nil || !s.stale(intent) {
+			continue
+		}
+		// a bundle with a readable merged file was completed, otherwise NextBundle finds it missing and it is merged again
+		s.logger.Info("removing stale merge intent",
+			zap.Uint64("base_block", baseBlock),
+			zap.String("instance_id", intent.InstanceID),
+			zap.Time("started_at", intent.StartedAt),
+			zap.Bool("merged_file_complete", s.mergedFileComplete(ctx, baseBlock)),
+		)
+		s.deleteMergeIntent(baseBlock)
+	}
+	return nil
+}
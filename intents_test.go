@@ -0,0 +1,110 @@
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setMergeIntent(t *testing.T, store *dstore.MockStore, baseBlock uint64, instanceID string, startedAt time.Time) {
+	data, err := json.Marshal(&MergeIntent{BaseBlock: baseBlock, InstanceID: instanceID, StartedAt: startedAt})
+	require.NoError(t, err)
+	store.SetFile(fileNameForBlocksBundle(baseBlock), data)
+}
+
+func newIntentsDStoreIO(mergedStore, intentsStore dstore.Store) *DStoreIO {
+	return NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedStore, nil, 0, 0, 100,
+		WithMergeIntents(intentsStore, "us", time.Minute),
+	).(*DStoreIO)
+}
+
+func TestDStoreIO_AcquireMergeIntent(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("no intent", func(t *testing.T) {
+		intents := dstore.NewMockStore(nil)
+		mio := newIntentsDStoreIO(dstore.NewMockStore(nil), intents)
+
+		complete, err := mio.acquireMergeIntent(ctx, 100)
+		require.NoError(t, err)
+		assert.False(t, complete)
+
+		intent, err := mio.readMergeIntent(ctx, "0000000100")
+		require.NoError(t, err)
+		require.NotNil(t, intent)
+		assert.Equal(t, "us", intent.InstanceID)
+		assert.Equal(t, uint64(100), intent.BaseBlock)
+
+		mio.deleteMergeIntent(100)
+		exists, err := intents.FileExists(ctx, "0000000100")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("stale intent without merged file", func(t *testing.T) {
+		intents := dstore.NewMockStore(nil)
+		setMergeIntent(t, intents, 100, "them", time.Now().Add(-time.Hour))
+		mio := newIntentsDStoreIO(dstore.NewMockStore(nil), intents)
+
+		complete, err := mio.acquireMergeIntent(ctx, 100)
+		require.NoError(t, err)
+		assert.False(t, complete)
+
+		intent, err := mio.readMergeIntent(ctx, "0000000100")
+		require.NoError(t, err)
+		require.NotNil(t, intent)
+		assert.Equal(t, "us", intent.InstanceID)
+	})
+
+	t.Run("stale intent with merged file", func(t *testing.T) {
+		merged := dstore.NewMockStore(nil)
+		merged.SetFile("0000000100", testMergedBundle(199))
+		intents := dstore.NewMockStore(nil)
+		setMergeIntent(t, intents, 100, "them", time.Now().Add(-time.Hour))
+		mio := newIntentsDStoreIO(merged, intents)
+
+		complete, err := mio.acquireMergeIntent(ctx, 100)
+		require.NoError(t, err)
+		assert.True(t, complete)
+
+		exists, err := intents.FileExists(ctx, "0000000100")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("waits for fresh intent", func(t *testing.T) {
+		defer func(interval time.Duration) { MergeIntentPollInterval = interval }(MergeIntentPollInterval)
+		MergeIntentPollInterval = time.Millisecond
+
+		merged := dstore.NewMockStore(nil)
+		intents := dstore.NewMockStore(nil)
+		setMergeIntent(t, intents, 100, "them", time.Now())
+		mio := newIntentsDStoreIO(merged, intents)
+
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err := mio.acquireMergeIntent(ctx, 100)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestDStoreIO_ResolveStaleMergeIntents(t *testing.T) {
+	intents := dstore.NewMockStore(nil)
+	setMergeIntent(t, intents, 100, "them", time.Now().Add(-time.Hour))
+	setMergeIntent(t, intents, 200, "us", time.Now())
+	setMergeIntent(t, intents, 300, "them", time.Now())
+	mio := newIntentsDStoreIO(dstore.NewMockStore(nil), intents)
+
+	require.NoError(t, mio.ResolveStaleMergeIntents(context.Background()))
+
+	for name, expected := range map[string]bool{"0000000100": false, "0000000200": false, "0000000300": true} {
+		exists, err := intents.FileExists(context.Background(), name)
+		require.NoError(t, err)
+		assert.Equal(t, expected, exists, name)
+	}
+}
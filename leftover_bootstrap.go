@@ -0,0 +1,87 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// WithLeftoverBootstrap replays, whenever the bundler is reset from the merged files (on start, after a reload or
+// merged bundles found ahead), the one-block files left below the new bundle since the last merged bundle, see
+// Bundler.Bootstrap. Without it, the forked blocks that were not moved yet when the merger stopped, e.g. between
+// storing a bundle and moving its forked blocks, are never walked again: they end up pruned with the old files
+// instead of moved to the forked blocks store.
+func WithLeftoverBootstrap() Option {
+	return func(m *Merger) {
+		m.leftoverBootstrap = true
+	}
+}
+
+// Bootstrap resets the bundler to `base` with the forkdb seeded from `lib`, the last block of the last merged bundle,
+// then replays `leftovers`, the surviving one-block files below `base`, in order: the ones linking to `lib` are
+// merged already, the others are held as forked blocks, handed out with the next closed bundle as they would have been
+// without a restart. Without `lib`, nothing is merged below `base` and the leftovers are ignored, like with Reset.
+func (b *Bundler) Bootstrap(base uint64, lib bstream.BlockRef, leftovers []*bstream.OneBlockFile) {
+	b.Reset(base, lib)
+	if lib == nil || len(leftovers) == 0 {
+		return
+	}
+
+	sorted := sortedByNum(leftovers)
+	canonical := map[string]bool{bstream.TruncateBlockID(lib.ID()): true}
+	for i := len(sorted) - 1; i >= 0; i-- { // from the top, following the previous IDs down from lib
+		obf := sorted[i]
+		if obf.Num >= base {
+			continue
+		}
+		if obf.Num <= lib.Num() && canonical[bstream.TruncateBlockID(obf.ID)] {
+			canonical[bstream.TruncateBlockID(obf.PreviousID)] = true
+		}
+	}
+	for _, obf := range sorted {
+		if obf.Num >= base || (obf.Num <= lib.Num() && canonical[bstream.TruncateBlockID(obf.ID)]) {
+			continue
+		}
+		if held, seen := b.seenBlockFiles[obf.CanonicalName]; seen {
+			if !b.handled(obf) {
+				b.handleDuplicate(held, obf)
+			}
+			continue
+		}
+		b.seenBlockFiles[obf.CanonicalName] = obf
+		b.trace.event("leftover %s", traceBlock(obf))
+	}
+}
+
+// walkLeftovers lists the one-block files from the bundle of `lib` up to `base`, nil when they cannot all be listed:
+// a partial list would not link down from lib
+func (m *Merger) walkLeftovers(base uint64, lib bstream.BlockRef) (out []*bstream.OneBlockFile) {
+	err := m.io.WalkOneBlockFiles(context.Background(), toBaseNum(lib.Num(), m.bundler.bundleSize), func(obf *bstream.OneBlockFile) error {
+		if obf.Num >= base {
+			return ErrStopBlockReached
+		}
+		out = append(out, obf)
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrStopBlockReached) {
+		m.logger.Warn("cannot list the one-block files left below the bundle, not replaying them", zap.Uint64("base_block_num", base), zap.Error(err))
+		return nil
+	}
+	return out
+}
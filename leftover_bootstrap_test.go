@@ -0,0 +1,167 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forkAwareTestIO records the merged bundles, as DStoreIO filters them, and the forked blocks moved after each of them
+type forkAwareTestIO struct {
+	TestMergerIO
+	bases  []uint64
+	merged map[uint64][]*bstream.OneBlockFile
+	moves  [][]*bstream.OneBlockFile
+}
+
+func newForkAwareTestIO() *forkAwareTestIO {
+	io := &forkAwareTestIO{merged: make(map[uint64][]*bstream.OneBlockFile)}
+	io.MergeAndStoreFunc = func(_ context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+		for _, obf := range oneBlockFiles {
+			if obf.Num >= inclusiveLowerBlock {
+				io.merged[inclusiveLowerBlock] = append(io.merged[inclusiveLowerBlock], obf)
+			}
+		}
+		io.bases = append(io.bases, inclusiveLowerBlock)
+		return nil
+	}
+	return io
+}
+
+func (io *forkAwareTestIO) DeleteForkedBlocksAsync(_, _ uint64) {}
+
+func (io *forkAwareTestIO) MoveForkedBlocks(_ context.Context, oneBlockFiles []*bstream.OneBlockFile) {
+	io.moves = append(io.moves, oneBlockFiles)
+}
+
+func canonicalNames(oneBlockFiles []*bstream.OneBlockFile) []string {
+	out := []string{}
+	for _, obf := range oneBlockFiles {
+		out = append(out, obf.CanonicalName)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestBundlerBootstrap_CrashAtEveryStep(t *testing.T) {
+	block101b := bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix")
+	block102b := bstream.MustNewOneBlockFile("0000000102-0000000000000102b-0000000000000101b-100-suffix")
+	block107Final105 := bstream.MustNewOneBlockFile("0000000107-0000000000000107a-0000000000000106a-105-suffix")
+	block108Final106 := bstream.MustNewOneBlockFile("0000000108-0000000000000108a-0000000000000107a-106-suffix")
+	chain := []*bstream.OneBlockFile{block100, block101, block101b, block102Final100, block102b, block103Final101,
+		block104Final102, block105Final103, block106Final104, block107Final105, block108Final106}
+
+	run := func(b *Bundler, files []*bstream.OneBlockFile) {
+		for _, obf := range files {
+			require.NoError(t, b.HandleBlockFile(obf))
+		}
+		b.inProcess.Lock()
+		b.inProcess.Unlock()
+	}
+
+	reference := newForkAwareTestIO()
+	run(NewBundler(100, 0, 100, 2, reference), chain)
+	var referenceForks []*bstream.OneBlockFile
+	for _, moved := range reference.moves {
+		referenceForks = append(referenceForks, moved...)
+	}
+	require.Len(t, reference.merged, 3)
+	require.Equal(t, []string{block101b.CanonicalName, block102b.CanonicalName}, canonicalNames(referenceForks))
+
+	for step := 0; step <= len(chain); step++ {
+		for _, movesLost := range []bool{false, true} {
+			t.Run(fmt.Sprintf("crash after %d files, forked blocks moves lost %t", step, movesLost), func(t *testing.T) {
+				before := newForkAwareTestIO()
+				run(NewBundler(100, 0, 100, 2, before), chain[:step])
+				if movesLost && len(before.moves) != 0 {
+					before.moves = before.moves[:len(before.moves)-1] // stopped between storing the bundle and moving its forked blocks
+				}
+
+				moved := make(map[string]bool)
+				var forks []*bstream.OneBlockFile
+				for _, files := range before.moves {
+					for _, obf := range files {
+						moved[obf.CanonicalName] = true
+						forks = append(forks, obf)
+					}
+				}
+				var stored []*bstream.OneBlockFile
+				for _, obf := range chain {
+					if !moved[obf.CanonicalName] {
+						stored = append(stored, obf)
+					}
+				}
+
+				// what NextBundle and walkLeftovers find in the stores
+				base := uint64(100)
+				var lib bstream.BlockRef
+				if len(before.bases) != 0 {
+					lastBase := before.bases[len(before.bases)-1]
+					blocks := before.merged[lastBase]
+					last := blocks[len(blocks)-1]
+					base, lib = lastBase+2, bstream.NewBlockRef(last.ID, last.Num)
+				}
+				var leftovers, walked []*bstream.OneBlockFile
+				for _, obf := range stored {
+					if obf.Num >= base {
+						walked = append(walked, obf)
+					} else if lib != nil && obf.Num >= toBaseNum(lib.Num(), 2) {
+						leftovers = append(leftovers, obf)
+					}
+				}
+
+				after := newForkAwareTestIO()
+				b := NewBundler(100, 0, 100, 2, after)
+				b.Bootstrap(base, lib, leftovers)
+				run(b, walked)
+
+				for base, blocks := range after.merged {
+					_, found := before.merged[base]
+					assert.False(t, found, "bundle %d merged twice", base)
+					before.merged[base] = blocks
+				}
+				assert.Equal(t, reference.merged, before.merged)
+
+				for _, files := range after.moves {
+					forks = append(forks, files...)
+				}
+				assert.Equal(t, canonicalNames(referenceForks), canonicalNames(forks))
+			})
+		}
+	}
+}
+
+func TestBundlerBootstrap_Leftovers(t *testing.T) {
+	block101b := bstream.MustNewOneBlockFile("0000000101-0000000000000101b-0000000000000100a-99-suffix")
+	b := NewBundler(100, 0, 100, 2, &TestMergerIO{})
+	b.Bootstrap(102, bstream.NewBlockRef("0000000000000101a", 101), []*bstream.OneBlockFile{block100, block101b, block101})
+
+	assert.Equal(t, uint64(102), b.baseBlockNum)
+	assert.Equal(t, map[string]*bstream.OneBlockFile{block101b.CanonicalName: block101b}, b.seenBlockFiles, "only the forked leftover is held")
+
+	b = NewBundler(100, 0, 100, 2, &TestMergerIO{})
+	b.Bootstrap(102, nil, []*bstream.OneBlockFile{block100, block101b, block101})
+	assert.Empty(t, b.seenBlockFiles)
+}
+
+func TestMerger_WalkLeftovers(t *testing.T) {
+	io := &TestMergerIO{
+		WalkOneBlockFilesFunc: func(_ context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+			assert.Equal(t, uint64(100), inclusiveLowerBlock)
+			for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100} {
+				if err := callback(obf); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	m := NewMerger(testLogger, "", io, 100, 2, 100, time.Second, time.Second, 0, WithLeftoverBootstrap())
+	assert.Equal(t, []*bstream.OneBlockFile{block100, block101}, m.walkLeftovers(102, bstream.NewBlockRef("0000000000000101a", 101)))
+}
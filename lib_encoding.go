@@ -0,0 +1,90 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+
+	"github.com/streamingfast/bstream"
+)
+
+// LibNumEncoding is how a chain writes the lib field of the one-block filenames
+type LibNumEncoding int
+
+const (
+	// LibNumAbsolute is the block number of the LIB (default)
+	LibNumAbsolute LibNumEncoding = iota
+	// LibNumDelta is the distance from the block number down to the LIB
+	LibNumDelta
+	// LibNumUnknownSentinel is the block number of the LIB, 0 meaning unknown: the highest LIB seen before is used
+	LibNumUnknownSentinel
+)
+
+func ParseLibNumEncoding(in string) (LibNumEncoding, error) {
+	switch in {
+	case "", "absolute":
+		return LibNumAbsolute, nil
+	case "delta":
+		return LibNumDelta, nil
+	case "unknown-sentinel":
+		return LibNumUnknownSentinel, nil
+	}
+	return LibNumAbsolute, fmt.Errorf("invalid lib num encoding %q, expected one of absolute, delta or unknown-sentinel", in)
+}
+
+// WithLibNumEncoding interprets the lib field of the one-block filenames with the given encoding, the one-block files
+// then carry the absolute LIB block number when handled by the bundler
+func WithLibNumEncoding(encoding LibNumEncoding) Option {
+	return func(m *Merger) {
+		m.libNumEncoding = encoding
+		if encoding != LibNumAbsolute {
+			m.libNums = newLibNumInterpreter(encoding)
+		}
+	}
+}
+
+// libNumInterpreter rewrites the LibNum of the one-block files, in the order they are walked
+type libNumInterpreter struct {
+	encoding   LibNumEncoding
+	highestLIB uint64
+}
+
+func newLibNumInterpreter(encoding LibNumEncoding) *libNumInterpreter {
+	return &libNumInterpreter{encoding: encoding}
+}
+
+func (i *libNumInterpreter) interpret(obf *bstream.OneBlockFile) {
+	if i == nil {
+		return
+	}
+	switch i.encoding {
+	case LibNumDelta:
+		if obf.LibNum > obf.Num {
+			obf.LibNum = 0
+		} else {
+			obf.LibNum = obf.Num - obf.LibNum
+		}
+	case LibNumUnknownSentinel:
+		if obf.LibNum != 0 {
+			if obf.LibNum > i.highestLIB {
+				i.highestLIB = obf.LibNum
+			}
+			return
+		}
+		if i.highestLIB < obf.Num {
+			obf.LibNum = i.highestLIB
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package merger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLibNumEncoding(t *testing.T) {
+	encoding, err := ParseLibNumEncoding("")
+	require.NoError(t, err)
+	assert.Equal(t, LibNumAbsolute, encoding)
+
+	encoding, err = ParseLibNumEncoding("delta")
+	require.NoError(t, err)
+	assert.Equal(t, LibNumDelta, encoding)
+
+	_, err = ParseLibNumEncoding("relative")
+	assert.Error(t, err)
+}
+
+func TestLibNumInterpreter(t *testing.T) {
+	libNums := func(i *libNumInterpreter, names ...string) (out []uint64) {
+		for _, name := range names {
+			obf := mustNewOneBlockFile(name)
+			i.interpret(obf)
+			out = append(out, obf.LibNum)
+		}
+		return
+	}
+
+	assert.Equal(t, []uint64{98, 100}, libNums(nil,
+		"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+		"0000000101-0000000000000101a-0000000000000100a-100-suffix",
+	))
+
+	assert.Equal(t, []uint64{98, 100, 0}, libNums(newLibNumInterpreter(LibNumDelta),
+		"0000000100-0000000000000100a-0000000000000099a-2-suffix",
+		"0000000101-0000000000000101a-0000000000000100a-1-suffix",
+		"0000000102-0000000000000102a-0000000000000101a-200-suffix",
+	))
+
+	assert.Equal(t, []uint64{0, 98, 98, 100}, libNums(newLibNumInterpreter(LibNumUnknownSentinel),
+		"0000000099-0000000000000099a-0000000000000098a-0-suffix",
+		"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+		"0000000101-0000000000000101a-0000000000000100a-0-suffix",
+		"0000000102-0000000000000102a-0000000000000101a-100-suffix",
+	))
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sync"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// MergerState is the step of its cycle the merger is at. A cycle goes:
+//
+//	StatePolling -> StateCheckingMerged -> [StateResetting] -> StateWalking -> StatePolling
+//
+// StateResetting only happens when merged bundles are found ahead of the bundler (pre-merged by another
+// merger, or a reload is requested). It is a synchronization point: it waits for the bundle being merged,
+// if any, and holds off the pruning of old one-block files, so that neither sees a half-reset bundler.
+type MergerState string
+
+const (
+	StateStarting       MergerState = "starting"
+	StatePolling        MergerState = "polling"         // waiting for the next cycle
+	StateCheckingMerged MergerState = "checking_merged" // looking for merged bundles ahead of the bundler
+	StateResetting      MergerState = "resetting"       // moving the bundler to the next bundle to create
+	StateWalking        MergerState = "walking"         // feeding one-block files to the bundler, merging bundles
+	StateStopped        MergerState = "stopped"
+)
+
+type lifecycle struct {
+	sync.Mutex
+	state MergerState
+
+	// resetLock is held for writing while the bundler is reset, and for reading while old one-block files are pruned
+	resetLock sync.RWMutex
+}
+
+func (m *Merger) setState(state MergerState) {
+	m.lifecycle.Lock()
+	defer m.lifecycle.Unlock()
+	m.lifecycle.state = state
+}
+
+// State returns the step of its cycle the merger is at
+func (m *Merger) State() MergerState {
+	m.lifecycle.Lock()
+	defer m.lifecycle.Unlock()
+	return m.lifecycle.state
+}
+
+// resetBundler moves the bundler to `base`, once the bundle being merged (if any) is stored and while pruning is held off
+func (m *Merger) resetBundler(base uint64, lib bstream.BlockRef, reason string) {
+	m.setState(StateResetting)
+
+	fields := []zap.Field{
+		zap.String("reason", reason),
+		zap.Uint64("previous_base_block_num", m.bundler.BaseBlockNum()),
+		zap.Uint64("new_base_block_num", base),
+	}
+	if lib != nil {
+		fields = append(fields, zap.Stringer("lib", lib))
+	}
+	m.logger.Info("resetting bundler base block num", fields...)
+
+	var leftovers []*bstream.OneBlockFile
+	if m.leftoverBootstrap && lib != nil {
+		leftovers = m.walkLeftovers(base, lib)
+	}
+
+	m.bundler.flushPendingMerges()
+	m.bundler.inProcess.Lock() // let the bundle being merged complete
+	m.bundler.inProcess.Unlock()
+
+	m.lifecycle.resetLock.Lock()
+	defer m.lifecycle.resetLock.Unlock()
+	m.bundler.Bootstrap(base, lib, leftovers)
+	m.walkResume.reset()
+	if m.comparator != nil {
+		m.comparator.reset(base, lib)
+	}
+}
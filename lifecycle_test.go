@@ -0,0 +1,59 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergerStates(t *testing.T) {
+	var states []MergerState
+	var m *Merger
+	io := &TestMergerIO{
+		NextBundleFunc: func(ctx context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+			states = append(states, m.State())
+			return 200, nil, nil
+		},
+		WalkOneBlockFilesFunc: func(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+			states = append(states, m.State())
+			m.Shutdown(nil)
+			return nil
+		},
+	}
+	m = NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Millisecond, 0)
+	assert.Equal(t, StateStarting, m.State())
+
+	require.NoError(t, m.run(context.Background()))
+	assert.Equal(t, []MergerState{StateCheckingMerged, StateWalking}, states)
+	assert.Equal(t, uint64(200), m.bundler.BaseBlockNum())
+	assert.Equal(t, StatePolling, m.State())
+}
+
+func TestResetBundlerWaitsForMergeAndPruning(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+
+	m.bundler.inProcess.Lock() // a bundle is being merged
+	m.lifecycle.resetLock.RLock()
+	done := make(chan struct{})
+	go func() {
+		m.resetBundler(200, nil, "test")
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return m.State() == StateResetting }, time.Second, time.Millisecond)
+	m.bundler.inProcess.Unlock()
+	select {
+	case <-done:
+		t.Fatal("reset should wait for the pruning to complete")
+	case <-time.After(10 * time.Millisecond):
+	}
+	assert.Equal(t, uint64(100), m.bundler.BaseBlockNum())
+
+	m.lifecycle.resetLock.RUnlock()
+	<-done
+	assert.Equal(t, uint64(200), m.bundler.BaseBlockNum())
+}
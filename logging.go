@@ -0,0 +1,127 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogComponent is a group of log entries that can be sampled on its own, see WithLogSampling
+type LogComponent string
+
+const (
+	// LogComponentWalk logs on each cycle of the walk of the one-block files and on each notified file
+	LogComponentWalk LogComponent = "walk"
+	// LogComponentMerge logs on each bundle merged and on each one-block file downloaded for it
+	LogComponentMerge LogComponent = "merge"
+)
+
+// LogSampling logs, per Tick, the First entries with the same level and message, then one in Thereafter
+type LogSampling struct {
+	Tick       time.Duration
+	First      int
+	Thereafter int
+}
+
+func (s LogSampling) apply(logger *zap.Logger) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewSamplerWithOptions(core, s.Tick, s.First, s.Thereafter)
+	}))
+}
+
+// ParseLogSampling parses `<component>=<first>/<thereafter>`, sampled per second
+func ParseLogSampling(in string) (LogComponent, LogSampling, error) {
+	name, spec, ok := strings.Cut(in, "=")
+	component := LogComponent(name)
+	if !ok || (component != LogComponentWalk && component != LogComponentMerge) {
+		return "", LogSampling{}, fmt.Errorf("invalid log sampling %q, expected `<walk|merge>=<first>/<thereafter>`", in)
+	}
+	first, thereafter, ok := strings.Cut(spec, "/")
+	if !ok {
+		return "", LogSampling{}, fmt.Errorf("invalid log sampling %q, expected `<walk|merge>=<first>/<thereafter>`", in)
+	}
+	sampling := LogSampling{Tick: time.Second}
+	var err error
+	if sampling.First, err = strconv.Atoi(first); err != nil || sampling.First < 0 {
+		return "", LogSampling{}, fmt.Errorf("invalid first entries in log sampling %q", in)
+	}
+	if sampling.Thereafter, err = strconv.Atoi(thereafter); err != nil || sampling.Thereafter < 0 {
+		return "", LogSampling{}, fmt.Errorf("invalid thereafter in log sampling %q", in)
+	}
+	return component, sampling, nil
+}
+
+// ParseLogField parses `<key>=<value>` into a string field
+func ParseLogField(in string) (zap.Field, error) {
+	key, value, ok := strings.Cut(in, "=")
+	if !ok || key == "" {
+		return zap.Field{}, fmt.Errorf("invalid log field %q, expected `<key>=<value>`", in)
+	}
+	return zap.String(key, value), nil
+}
+
+// componentLoggers holds the sampled loggers of the components, the others use the base logger
+type componentLoggers map[LogComponent]*zap.Logger
+
+func (c componentLoggers) get(component LogComponent, base *zap.Logger) *zap.Logger {
+	if logger, ok := c[component]; ok {
+		return logger
+	}
+	return base
+}
+
+func (c componentLoggers) with(fields []zap.Field) {
+	for component, logger := range c {
+		c[component] = logger.With(fields...)
+	}
+}
+
+// WithLogFields adds `fields` (chain, environment...) to every log of the merger
+func WithLogFields(fields ...zap.Field) Option {
+	return func(m *Merger) {
+		m.settings.logFields = append(m.settings.logFields, fields...)
+	}
+}
+
+// WithLogSampling samples the logs of `component`, to keep the walk logs of fast chains from drowning the others
+func WithLogSampling(component LogComponent, sampling LogSampling) Option {
+	return func(m *Merger) {
+		if m.settings.logSampling == nil {
+			m.settings.logSampling = make(map[LogComponent]LogSampling)
+		}
+		m.settings.logSampling[component] = sampling
+	}
+}
+
+// WithIOLogFields adds `fields` to every log of the DStoreIO, see WithLogFields
+func WithIOLogFields(fields ...zap.Field) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.logger = s.logger.With(fields...)
+		s.componentLoggers.with(fields)
+	}
+}
+
+// WithIOLogSampling samples the logs of `component` of the DStoreIO, see WithLogSampling
+func WithIOLogSampling(component LogComponent, sampling LogSampling) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.componentLoggers[component] = sampling.apply(s.logger)
+	}
+}
@@ -0,0 +1,47 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestParseLogSampling(t *testing.T) {
+	component, sampling, err := ParseLogSampling("walk=10/100")
+	require.NoError(t, err)
+	assert.Equal(t, LogComponentWalk, component)
+	assert.Equal(t, LogSampling{Tick: time.Second, First: 10, Thereafter: 100}, sampling)
+
+	for _, in := range []string{"walk", "bundler=1/1", "merge=1", "merge=a/1", "merge=1/-1"} {
+		_, _, err = ParseLogSampling(in)
+		assert.Error(t, err, in)
+	}
+
+	field, err := ParseLogField("chain=eth")
+	require.NoError(t, err)
+	assert.Equal(t, zap.String("chain", "eth"), field)
+	_, err = ParseLogField("=eth")
+	assert.Error(t, err)
+}
+
+func TestMerger_LogOptions(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	m := NewMerger(zap.New(core), "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0,
+		WithLogSampling(LogComponentWalk, LogSampling{Tick: time.Minute, First: 2, Thereafter: 0}),
+		WithLogFields(zap.String("chain", "eth")),
+	)
+	for i := 0; i < 5; i++ {
+		m.componentLoggers.get(LogComponentWalk, m.logger).Debug("walk spam")
+		m.componentLoggers.get(LogComponentMerge, m.logger).Info("merged")
+	}
+
+	assert.Equal(t, 2, logs.FilterMessage("walk spam").Len())
+	assert.Equal(t, 5, logs.FilterMessage("merged").Len())
+	for _, entry := range logs.All() {
+		assert.Equal(t, "eth", entry.ContextMap()["chain"])
+	}
+}
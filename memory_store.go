@@ -0,0 +1,195 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/streamingfast/dstore"
+)
+
+// MemoryStore is a dstore.Store holding its objects in memory, safe for concurrent use, see NewInMemoryPipeline.
+// Unlike dstore.MockStore, it has no special object names and its sub-stores share their objects with it.
+type MemoryStore struct {
+	files     *memoryFiles
+	prefix    string // of the objects of a sub-store, with its trailing slash
+	overwrite bool
+}
+
+type memoryFiles struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{files: &memoryFiles{objects: make(map[string][]byte)}}
+}
+
+func (s *MemoryStore) OpenObject(_ context.Context, name string) (io.ReadCloser, error) {
+	s.files.Lock()
+	defer s.files.Unlock()
+	data, ok := s.files.objects[s.prefix+name]
+	if !ok {
+		return nil, dstore.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemoryStore) FileExists(_ context.Context, base string) (bool, error) {
+	s.files.Lock()
+	defer s.files.Unlock()
+	_, ok := s.files.objects[s.prefix+base]
+	return ok, nil
+}
+
+func (s *MemoryStore) ObjectPath(base string) string {
+	return s.prefix + base
+}
+
+func (s *MemoryStore) ObjectURL(base string) string {
+	return "memory:///" + s.prefix + base
+}
+
+// WriteObject leaves an existing object alone unless overwriting is enabled, like the other stores
+func (s *MemoryStore) WriteObject(_ context.Context, base string, f io.Reader) error {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("reading object %q: %w", base, err)
+	}
+	s.files.Lock()
+	defer s.files.Unlock()
+	if _, exists := s.files.objects[s.prefix+base]; exists && !s.overwrite {
+		return nil
+	}
+	s.files.objects[s.prefix+base] = data
+	return nil
+}
+
+func (s *MemoryStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	if err := s.WriteObject(ctx, toBaseName, f); err != nil {
+		return err
+	}
+	return os.Remove(localFile)
+}
+
+func (s *MemoryStore) CopyObject(_ context.Context, src, dest string) error {
+	s.files.Lock()
+	defer s.files.Unlock()
+	data, ok := s.files.objects[s.prefix+src]
+	if !ok {
+		return dstore.ErrNotFound
+	}
+	s.files.objects[s.prefix+dest] = data
+	return nil
+}
+
+func (s *MemoryStore) Overwrite() bool {
+	return s.overwrite
+}
+
+func (s *MemoryStore) SetOverwrite(enabled bool) {
+	s.overwrite = enabled
+}
+
+func (s *MemoryStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) error) error {
+	return s.Walk(ctx, prefix, func(filename string) error {
+		if filename < startingPoint {
+			return nil
+		}
+		return f(filename)
+	})
+}
+
+// Walk lists the objects in name order, from a snapshot: `f` may write or delete objects
+func (s *MemoryStore) Walk(ctx context.Context, prefix string, f func(filename string) error) error {
+	s.files.Lock()
+	var names []string
+	for name := range s.files.objects {
+		if strings.HasPrefix(name, s.prefix+prefix) {
+			names = append(names, strings.TrimPrefix(name, s.prefix))
+		}
+	}
+	s.files.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f(name); err != nil {
+			if err == dstore.StopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListFiles(ctx context.Context, prefix string, max int) (out []string, err error) {
+	err = s.Walk(ctx, prefix, func(filename string) error {
+		if max >= 0 && len(out) >= max {
+			return dstore.StopIteration
+		}
+		out = append(out, filename)
+		return nil
+	})
+	return
+}
+
+func (s *MemoryStore) DeleteObject(_ context.Context, base string) error {
+	s.files.Lock()
+	defer s.files.Unlock()
+	if _, ok := s.files.objects[s.prefix+base]; !ok {
+		return dstore.ErrNotFound
+	}
+	delete(s.files.objects, s.prefix+base)
+	return nil
+}
+
+func (s *MemoryStore) BaseURL() *url.URL {
+	return &url.URL{Scheme: "memory", Path: "/" + strings.TrimSuffix(s.prefix, "/")}
+}
+
+func (s *MemoryStore) SubStore(subFolder string) (dstore.Store, error) {
+	return &MemoryStore{files: s.files, prefix: s.prefix + strings.Trim(subFolder, "/") + "/", overwrite: s.overwrite}, nil
+}
+
+// Len returns the number of objects in the store
+func (s *MemoryStore) Len() int {
+	s.files.Lock()
+	defer s.files.Unlock()
+	var count int
+	for name := range s.files.objects {
+		if strings.HasPrefix(name, s.prefix) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+)
+
+// WithMergeBatching holds the complete bundles until `bundles` of them are ready, or the oldest one waited `maxWait`,
+// then merges them one after the other, for the stores that favor fewer and larger upload sessions. The wait is
+// checked after each walk, so it is only as precise as the polling interval, a `maxWait` of 0 disables it. A bundle is always merged before the
+// stop block is reached, the bundler is reset or the merger shuts down. Meanwhile, the bundler base reported to the
+// pruners is the one of the oldest bundle held, its one-block files are not deleted.
+func WithMergeBatching(bundles int, maxWait time.Duration) Option {
+	return func(m *Merger) {
+		if bundles <= 1 {
+			m.bundler.batch = nil
+			return
+		}
+		m.bundler.batch = &mergeBatch{bundles: bundles, maxWait: maxWait}
+		m.OnTerminating(func(_ error) { m.bundler.flushPendingMerges() }) // before waiting for the merges in progress
+	}
+}
+
+type mergeBatch struct {
+	bundles int
+	maxWait time.Duration
+}
+
+type pendingBundle struct {
+	baseBlockNum uint64
+	blocks       []*bstream.OneBlockFile
+	forked       []*bstream.OneBlockFile
+	completedAt  time.Time
+}
+
+// flushDueMerges merges the pending bundles once the oldest one waited long enough
+func (b *Bundler) flushDueMerges() {
+	if b.batch == nil || b.batch.maxWait == 0 {
+		return
+	}
+	b.Lock()
+	due := len(b.pending) != 0 && b.now().Sub(b.pending[0].completedAt) >= b.batch.maxWait
+	b.Unlock()
+	if due {
+		b.flushPendingMerges()
+	}
+}
+
+// flushPendingMerges merges the pending bundles in order, in the background. It stops at the first error, reported
+// to the next HandleBlockFile. With WithMergePipelineDepth, they are queued to the merge pipeline instead.
+func (b *Bundler) flushPendingMerges() {
+	b.Lock()
+	empty := len(b.pending) == 0
+	b.Unlock()
+	if empty {
+		return
+	}
+	if b.pipeline != nil {
+		b.queuePendingMerges()
+		return
+	}
+
+	b.inProcess.Lock()
+	select {
+	case err := <-b.bundleError:
+		// the previous merge failed, the next bundles must not be merged past it
+		b.bundleError <- err
+		b.inProcess.Unlock()
+		return
+	default:
+	}
+	b.Lock()
+	bundles := b.pending
+	b.pending = nil
+	b.Unlock()
+	metrics.MergeBatchSize.SetFloat64(float64(len(bundles)))
+	go func() {
+		defer b.inProcess.Unlock()
+		for _, bundle := range bundles {
+			if err := b.mergeBundle(bundle); err != nil {
+				b.mergeFailed(bundle.baseBlockNum)
+				b.bundleError <- err
+				return
+			}
+		}
+	}()
+}
+
+// mergeFailed records the base of the bundle whose merge failed, see failedBase
+func (b *Bundler) mergeFailed(baseBlockNum uint64) {
+	b.Lock()
+	defer b.Unlock()
+	if b.failedBase == nil {
+		b.failedBase = &baseBlockNum
+	}
+}
+
+// mergedAboveFailure returns true when a bundle below `baseBlockNum` failed to merge, it can be called from a
+// different thread
+func (b *Bundler) mergedAboveFailure(baseBlockNum uint64) bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.failedBase != nil && *b.failedBase < baseBlockNum
+}
+
+func (b *Bundler) mergeBundle(bundle *pendingBundle) error {
+	stored, err := b.storeBundle(bundle)
+	if err != nil || !stored {
+		return err
+	}
+	b.bundleMerged(bundle)
+	return nil
+}
+
+// storeBundle writes the merged file of `bundle`, it returns false when the bundle is skipped
+func (b *Bundler) storeBundle(bundle *pendingBundle) (bool, error) {
+	if b.poison.isolated(bundle.baseBlockNum) {
+		return false, nil // its one-block files are kept for a retry, see WithPoisonRanges
+	}
+	if b.beforeMerge != nil {
+		if err := b.beforeMerge(bundle.baseBlockNum); err != nil {
+			return false, err
+		}
+	}
+	if err := b.mergeAndStore(bundle.baseBlockNum, bundle.blocks); err != nil {
+		if err = b.poison.failed(bundle.baseBlockNum, err); err != nil {
+			err = &BundleMergeError{BaseBlock: bundle.baseBlockNum, Err: err}
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// bundleMerged runs the callbacks of a stored bundle, in the order of the bundles
+func (b *Bundler) bundleMerged(bundle *pendingBundle) {
+	if b.onMerged != nil {
+		b.onMerged(bundle.baseBlockNum, bundle.blocks)
+	}
+	if b.onIndex != nil {
+		b.onIndex(buildBundleIndex(bundle, b.bundleSize))
+	}
+	if forkableIO, ok := b.io.(ForkAwareIOInterface); ok {
+		forkableIO.MoveForkedBlocks(context.Background(), bundle.forked)
+	}
+	// we do not delete bundled blocks here, they get pruned later. keeping the blocks from the last bundle is useful for bootstrapping
+}
@@ -0,0 +1,76 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBatchTestBundler(merged *[]uint64) *Bundler {
+	b := newTestBundler(func(inclusiveLowerBlock uint64) error {
+		*merged = append(*merged, inclusiveLowerBlock)
+		return nil
+	})
+	b.batch = &mergeBatch{bundles: 2, maxWait: time.Minute}
+	return b
+}
+
+func TestBundler_MergeBatching(t *testing.T) {
+	var merged []uint64
+	b := newBatchTestBundler(&merged)
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102} {
+		require.NoError(t, b.HandleBlockFile(obf))
+	}
+	assert.Empty(t, merged, "waiting for a second bundle")
+	assert.EqualValues(t, 100, b.BaseBlockNum(), "the held bundle is not merged")
+
+	for _, obf := range []*bstream.OneBlockFile{block105Final103, block106Final104} {
+		require.NoError(t, b.HandleBlockFile(obf))
+	}
+	assert.EqualValues(t, 104, b.BaseBlockNum())
+	assert.Equal(t, []uint64{100, 102}, merged)
+}
+
+func TestBundler_MergeBatchingMaxWait(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var merged []uint64
+	b := newBatchTestBundler(&merged)
+	b.now = func() time.Time { return now }
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102} {
+		require.NoError(t, b.HandleBlockFile(obf))
+	}
+
+	now = now.Add(30 * time.Second)
+	b.flushDueMerges()
+	assert.EqualValues(t, 100, b.BaseBlockNum())
+
+	now = now.Add(time.Minute)
+	b.flushDueMerges()
+	assert.EqualValues(t, 102, b.BaseBlockNum())
+	assert.Equal(t, []uint64{100}, merged)
+}
+
+func TestMerger_OnBundleMerged(t *testing.T) {
+	var calls []string
+	mio := &TestMergerIO{
+		MergeAndStoreFunc: func(_ context.Context, inclusiveLowerBlock uint64, _ []*bstream.OneBlockFile) error {
+			calls = append(calls, fmt.Sprintf("store %d", inclusiveLowerBlock))
+			return nil
+		},
+	}
+	m := NewMerger(testLogger, "", mio, 100, 2, 100, time.Second, time.Second, 0)
+	m.OnBundleMerged(func(lowBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile) {
+		calls = append(calls, fmt.Sprintf("merged %d with %d files", lowBlockNum, len(oneBlockFiles)))
+	})
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102} {
+		require.NoError(t, m.bundler.HandleBlockFile(obf))
+	}
+	m.bundler.inProcess.Lock()
+	m.bundler.inProcess.Unlock()
+	assert.Equal(t, []string{"store 100", "merged 100 with 2 files"}, calls)
+}
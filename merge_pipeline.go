@@ -0,0 +1,133 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"github.com/sadiq1971/merger/metrics"
+)
+
+// WithMergePipelineDepth lets up to `depth` closed bundles wait for their merge, or be merging, while the bundler keeps
+// collecting and pre-downloading the blocks of the next ones. Without it, closing a bundle waits for the merge of the
+// previous one, so catching up alternates between downloading and uploading. The bundles are still merged one after
+// the other, in order: a failed merge drops the queued bundles and is reported to the next HandleBlockFile, as without
+// the pipeline, and BaseBlockNum stays at the failed bundle so that the one-block files of the dropped ones are kept. The blocks of the queued bundles stay in memory until they are merged, the depth bounds them. A depth
+// of 1 or less keeps a single merge at a time.
+func WithMergePipelineDepth(depth int) Option {
+	return func(m *Merger) {
+		m.settings.pipelineDepth = depth
+	}
+}
+
+type mergePipeline struct {
+	slots chan struct{} // one per bundle queued or merging
+
+	// guarded by the bundler lock
+	queued  []*pendingBundle // the first ones are merging
+	running bool             // a worker holds inProcess and merges the queue
+	failed  bool             // a merge failed, the bundles are dropped until the error is reported by closeBundle
+}
+
+// pipelineQueued returns the number of bundles merging or waiting for their merge, it can be called from a different
+// thread
+func (b *Bundler) pipelineQueued() int {
+	b.Lock()
+	defer b.Unlock()
+	if b.pipeline == nil {
+		return 0
+	}
+	return len(b.pipeline.queued)
+}
+
+// oldestBase must be called with the bundler lock held
+func (p *mergePipeline) oldestBase() (uint64, bool) {
+	if len(p.queued) == 0 {
+		return 0, false
+	}
+	return p.queued[0].baseBlockNum, true
+}
+
+// queuePendingMerges moves the pending bundles to the pipeline, blocking while it is full
+func (b *Bundler) queuePendingMerges() {
+	for {
+		b.Lock()
+		empty := len(b.pending) == 0
+		b.Unlock()
+		if empty {
+			return
+		}
+
+		b.pipeline.slots <- struct{}{}
+		b.Lock()
+		bundle := b.pending[0]
+		b.pending = b.pending[1:]
+		if b.pipeline.failed {
+			b.Unlock()
+			<-b.pipeline.slots
+			continue
+		}
+		b.pipeline.queued = append(b.pipeline.queued, bundle)
+		start := !b.pipeline.running
+		b.pipeline.running = true
+		metrics.MergePipelineQueued.SetFloat64(float64(len(b.pipeline.queued)))
+		b.Unlock()
+
+		if start {
+			b.inProcess.Lock()
+			go b.runPipeline()
+		}
+	}
+}
+
+// runPipeline merges the queued bundles in order, several at once when catching up (see WithCatchUpMerges), until the
+// queue is empty or a merge fails
+func (b *Bundler) runPipeline() {
+	defer b.inProcess.Unlock()
+	for {
+		b.Lock()
+		if len(b.pipeline.queued) == 0 {
+			b.pipeline.running = false
+			b.Unlock()
+			return
+		}
+		bundles := b.pipeline.queued[:b.catchUp.batch(len(b.pipeline.queued))]
+		b.Unlock()
+
+		merged, err := b.mergeBundles(bundles)
+		if err != nil {
+			b.mergeFailed(bundles[merged].baseBlockNum)
+		}
+
+		b.Lock()
+		if err != nil {
+			dropped := len(b.pipeline.queued)
+			b.pipeline.queued = nil
+			b.pipeline.running = false
+			b.pipeline.failed = true
+			b.Unlock()
+			for i := 0; i < dropped; i++ {
+				<-b.pipeline.slots
+			}
+			metrics.MergePipelineQueued.SetFloat64(0)
+			b.bundleError <- err
+			return
+		}
+		b.pipeline.queued = b.pipeline.queued[merged:]
+		metrics.MergePipelineQueued.SetFloat64(float64(len(b.pipeline.queued)))
+		b.Unlock()
+		for i := 0; i < merged; i++ {
+			<-b.pipeline.slots
+		}
+	}
+}
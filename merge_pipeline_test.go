@@ -0,0 +1,102 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPipelineTestBundler(release chan error) (*Bundler, func() []uint64) {
+	var lock sync.Mutex
+	var merged []uint64
+	b := newTestBundler(func(inclusiveLowerBlock uint64) error {
+		if err := <-release; err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		merged = append(merged, inclusiveLowerBlock)
+		return nil
+	})
+	b.pipeline = &mergePipeline{slots: make(chan struct{}, 2)}
+	return b, func() []uint64 {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]uint64(nil), merged...)
+	}
+}
+
+func TestBundler_MergePipeline(t *testing.T) {
+	release := make(chan error)
+	b, merged := newPipelineTestBundler(release)
+
+	handled := make(chan error)
+	go func() {
+		for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102, block105Final103, block106Final104} {
+			if err := b.HandleBlockFile(obf); err != nil {
+				handled <- err
+				return
+			}
+		}
+		handled <- nil
+	}()
+	select {
+	case err := <-handled:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing the second bundle waited for the merge of the first one")
+	}
+	assert.EqualValues(t, 100, b.BaseBlockNum(), "the oldest bundle of the pipeline, without waiting for its merge")
+
+	release <- nil
+	release <- nil
+	b.inProcess.Lock()
+	b.inProcess.Unlock()
+	assert.Equal(t, []uint64{100, 102}, merged())
+	assert.EqualValues(t, 104, b.BaseBlockNum())
+}
+
+func TestBundler_MergePipelineFailure(t *testing.T) {
+	release := make(chan error)
+	b, merged := newPipelineTestBundler(release)
+
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102, block105Final103, block106Final104} {
+		require.NoError(t, b.HandleBlockFile(obf))
+	}
+	release <- fmt.Errorf("upload failed")
+	b.inProcess.Lock()
+	b.inProcess.Unlock()
+
+	assert.Empty(t, merged(), "the bundle queued after the failed one is dropped")
+	assert.EqualError(t, b.closeBundle(nil), "merging bundle 100: upload failed")
+	assert.False(t, b.pipeline.failed)
+}
+
+func TestMerger_MergePipelineFailureKeepsOneBlockFiles(t *testing.T) {
+	release := make(chan error)
+	io := &TestMergerIO{
+		MergeAndStoreFunc: func(_ context.Context, _ uint64, _ []*bstream.OneBlockFile) error {
+			return <-release
+		},
+	}
+	m := NewMerger(testLogger, "", io, 100, 2, 2, time.Second, time.Second, 0, WithMergePipelineDepth(2))
+	blocks := []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102, block105Final103, block106Final104}
+	for _, obf := range blocks {
+		require.NoError(t, m.bundler.HandleBlockFile(obf))
+	}
+	release <- fmt.Errorf("upload failed")
+	m.bundler.inProcess.Lock()
+	m.bundler.inProcess.Unlock()
+
+	assert.Error(t, m.bundler.closeBundle(nil))
+	assert.EqualValues(t, 100, m.bundler.BaseBlockNum(), "the failed bundle, until the bundler is reset")
+	for _, obf := range blocks {
+		assert.GreaterOrEqual(t, obf.Num, m.oldFilesPruningTarget(), "one-block file %s pruned", obf.CanonicalName)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+)
+
+var DefaultMergedFilesCacheSize = 4
+
+// mergedFile is the parsed content of a merged bundle, without the block payloads
+type mergedFile struct {
+	baseBlock     uint64
+	oneBlockFiles []*bstream.OneBlockFile
+	lastBlockTime time.Time
+}
+
+// WithMergedFilesCacheSize sets how many parsed merged files are kept in memory, 0 disables the cache
+func WithMergedFilesCacheSize(size int) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.mergedFilesCache = newMergedFilesCache(size)
+	}
+}
+
+// mergedFilesCache is a small LRU of parsed merged files, keyed by their base block.
+// Merged files are large and immutable once written, so re-reading them on every bootstrap is wasteful.
+type mergedFilesCache struct {
+	sync.Mutex
+	size    int
+	entries map[uint64]*list.Element
+	order   *list.List
+}
+
+func newMergedFilesCache(size int) *mergedFilesCache {
+	return &mergedFilesCache{
+		size:    size,
+		entries: make(map[uint64]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *mergedFilesCache) get(baseBlock uint64) *mergedFile {
+	c.Lock()
+	defer c.Unlock()
+	elem, found := c.entries[baseBlock]
+	if !found {
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*mergedFile)
+}
+
+func (c *mergedFilesCache) put(f *mergedFile) {
+	c.Lock()
+	defer c.Unlock()
+	if c.size <= 0 {
+		return
+	}
+	if elem, found := c.entries[f.baseBlock]; found {
+		elem.Value = f
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[f.baseBlock] = c.order.PushFront(f)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*mergedFile).baseBlock)
+	}
+}
+
+func (c *mergedFilesCache) remove(baseBlock uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if elem, found := c.entries[baseBlock]; found {
+		c.order.Remove(elem)
+		delete(c.entries, baseBlock)
+	}
+}
+
+// FetchMergedOneBlockFiles returns the blocks contained in the merged file starting at baseBlock, as oneBlockFiles without data.
+// Results are cached, the returned oneBlockFiles must not be modified.
+func (s *DStoreIO) FetchMergedOneBlockFiles(ctx context.Context, baseBlock uint64) ([]*bstream.OneBlockFile, error) {
+	f, err := s.fetchMergedFile(ctx, baseBlock)
+	if err != nil {
+		return nil, err
+	}
+	return f.oneBlockFiles, nil
+}
+
+func (s *DStoreIO) fetchMergedFile(ctx context.Context, baseBlock uint64) (*mergedFile, error) {
+	if f := s.mergedFilesCache.get(baseBlock); f != nil {
+		return f, nil
+	}
+	if err := s.waitForPressure(ctx, "fetch_merged"); err != nil {
+		return nil, err
+	}
+
+	f := &mergedFile{baseBlock: baseBlock}
+	err := s.retryDownload(ctx, StoreDomainDestination, func(ctx context.Context) error {
+		f.oneBlockFiles = nil
+		release, err := s.downloads.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+		reader, err := s.mergedStoreFor(baseBlock).OpenObject(ctx, s.mergedFileName(baseBlock))
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		err = s.bundleFormat().ReadBundle(reader, func(block *bstream.Block) error {
+			f.oneBlockFiles = append(f.oneBlockFiles, oneBlockFileFromMergedBlock(block))
+			f.lastBlockTime = block.Timestamp
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(f.oneBlockFiles) == 0 {
+			return &permanentError{err: fmt.Errorf("merged file %s contains no block", fileNameForBlocksBundle(baseBlock))}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mergedFilesCache.put(f)
+	return f, nil
+}
+
+// oneBlockFileFromMergedBlock creates a oneBlockFile matching what we would have parsed from the filename.
+// It has no Filenames since there is no such file in the one-block store.
+func oneBlockFileFromMergedBlock(block *bstream.Block) *bstream.OneBlockFile {
+	// we truncate the block ID to have the short version that we get on oneBlockFiles
+	id := bstream.TruncateBlockID(block.Id)
+	previousID := bstream.TruncateBlockID(block.PreviousId)
+	return &bstream.OneBlockFile{
+		CanonicalName: fmt.Sprintf("%010d-%s-%s-%d", block.Number, id, previousID, block.LibNum),
+		Filenames:     map[string]bool{},
+		ID:            id,
+		Num:           block.Number,
+		PreviousID:    previousID,
+		LibNum:        block.LibNum,
+	}
+}
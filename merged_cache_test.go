@@ -0,0 +1,60 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergedFilesCache_Eviction(t *testing.T) {
+	c := newMergedFilesCache(2)
+	c.put(&mergedFile{baseBlock: 100})
+	c.put(&mergedFile{baseBlock: 200})
+	require.NotNil(t, c.get(100)) // 100 is now the most recently used
+	c.put(&mergedFile{baseBlock: 300})
+
+	assert.NotNil(t, c.get(100))
+	assert.Nil(t, c.get(200))
+	assert.NotNil(t, c.get(300))
+
+	c.remove(300)
+	assert.Nil(t, c.get(300))
+}
+
+func TestMergedFilesCache_Disabled(t *testing.T) {
+	c := newMergedFilesCache(0)
+	c.put(&mergedFile{baseBlock: 100})
+	assert.Nil(t, c.get(100))
+}
+
+func TestDStoreIO_FetchMergedOneBlockFilesCached(t *testing.T) {
+	content := []byte(
+		`{"id":"0000000000000100a","prev":"0000000000000099a","num":100,"libnum":98}` + "\n" +
+			`{"id":"0000000000000101a","prev":"0000000000000100a","num":101,"libnum":99}` + "\n",
+	)
+	var opened int
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	mergedBlocksStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		opened++
+		return ioutil.NopCloser(bytes.NewReader(content)), nil
+	}
+
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedBlocksStore, nil, 0, 0, 100).(*DStoreIO)
+
+	files, err := mio.FetchMergedOneBlockFiles(context.Background(), 100)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "0000000100-000000000000100a-000000000000099a-98", files[0].CanonicalName)
+	assert.Equal(t, "0000000101-000000000000101a-000000000000100a-99", files[1].CanonicalName)
+	assert.Empty(t, files[0].Filenames)
+
+	_, err = mio.FetchMergedOneBlockFiles(context.Background(), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, opened)
+}
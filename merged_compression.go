@@ -0,0 +1,49 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// WithMergedCompressionLevel makes the merger zstd-compress merged files itself, at the given zstd level (1 to 22,
+// mapped to the closest level supported by the encoder), instead of leaving it to the store at its default level.
+// The merged blocks stores must then be created without compression (`dstore.NewStore(url, "dbin.zst", "", false)`),
+// readers keep decompressing them as usual.
+func WithMergedCompressionLevel(level int) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.compressionLevel = level
+	}
+}
+
+// compressedReader streams the zstd-compressed content of `in`
+func compressedReader(in io.Reader, level int) (io.Reader, error) {
+	pr, pw := io.Pipe()
+	encoder, err := zstd.NewWriter(pw, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if _, err := io.Copy(encoder, in); err != nil {
+			encoder.Close()
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(encoder.Close())
+	}()
+	return pr, nil
+}
@@ -0,0 +1,30 @@
+package merger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressedReader(t *testing.T) {
+	content := bytes.Repeat([]byte("some repetitive block payload "), 1000)
+
+	for _, level := range []int{1, 3, 19} {
+		reader, err := compressedReader(bytes.NewReader(content), level)
+		require.NoError(t, err)
+		compressed, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Less(t, len(compressed), len(content))
+
+		decoder, err := zstd.NewReader(bytes.NewReader(compressed))
+		require.NoError(t, err)
+		decompressed, err := ioutil.ReadAll(decoder)
+		require.NoError(t, err)
+		assert.Equal(t, content, decompressed)
+		decoder.Close()
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WithMergedFileNamesCompatibility makes NextBundle recognize the merged files named with older naming schemes during
+// a migration: any zero-padding of the base block (ex: `00000100` instead of `0000000100`) and any extension left
+// in the name. Those names do not sort like the current ones, so the whole merged blocks store is listed and ordered
+// by base block on every NextBundle call: only enable it while old and new names are mixed.
+// Merged files are always written with the current naming scheme.
+func WithMergedFileNamesCompatibility() DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.mergedFileNames = &mergedFileNames{names: make(map[uint64]string)}
+	}
+}
+
+// mergedFileNames remembers the actual names of the merged files found in compatibility mode, by base block
+type mergedFileNames struct {
+	sync.Mutex
+	names map[uint64]string
+}
+
+// parseMergedFileName accepts a base block of any padding, optionally followed by an extension
+func parseMergedFileName(filename string) (uint64, bool) {
+	if idx := strings.IndexByte(filename, '.'); idx != -1 {
+		filename = filename[:idx]
+	}
+	if filename == "" || strings.Trim(filename, "0123456789") != "" {
+		return 0, false
+	}
+	num, err := strconv.ParseUint(filename, 10, 64)
+	return num, err == nil
+}
+
+// walkMergedFiles calls f with the base block of the merged files of the segment, from `lowBaseBlock` in order
+func (s *DStoreIO) walkMergedFiles(ctx context.Context, segment *MergedBlocksStoreRange, lowBaseBlock uint64, f func(baseBlock uint64) error) error {
+	if s.mergedFileNames == nil {
+		walk := func(ctx context.Context, f func(filename string) error) error {
+			return segment.Store.WalkFrom(ctx, "", fileNameForBlocksBundle(lowBaseBlock), f)
+		}
+		return s.retryList(ctx, StoreDomainDestination, walk, func(filename string) error {
+			if strings.HasPrefix(filename, ".") {
+				return nil // probes and cursor
+			}
+			num, err := strconv.ParseUint(filename, 10, 64)
+			if err != nil {
+				return err
+			}
+			return f(num)
+		})
+	}
+
+	var baseBlocks []uint64
+	found := make(map[uint64]string)
+	walk := func(ctx context.Context, f func(filename string) error) error {
+		return segment.Store.Walk(ctx, "", f)
+	}
+	err := s.retryList(ctx, StoreDomainDestination, walk, func(filename string) error {
+		num, ok := parseMergedFileName(filename)
+		if !ok || num < lowBaseBlock {
+			return nil
+		}
+		existing, ok := found[num]
+		if !ok {
+			baseBlocks = append(baseBlocks, num)
+		} else if existing == fileNameForBlocksBundle(num) {
+			return nil // the current naming scheme wins
+		}
+		found[num] = filename
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(baseBlocks, func(i, j int) bool { return baseBlocks[i] < baseBlocks[j] })
+
+	s.mergedFileNames.Lock()
+	for num, filename := range found {
+		s.mergedFileNames.names[num] = filename
+	}
+	s.mergedFileNames.Unlock()
+
+	for _, num := range baseBlocks {
+		if err := f(num); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergedFileName returns the name of the merged file at `baseBlock`, as found in the store in compatibility mode
+func (s *DStoreIO) mergedFileName(baseBlock uint64) string {
+	if s.mergedFileNames != nil {
+		s.mergedFileNames.Lock()
+		defer s.mergedFileNames.Unlock()
+		if filename, ok := s.mergedFileNames.names[baseBlock]; ok {
+			return filename
+		}
+	}
+	return fileNameForBlocksBundle(baseBlock)
+}
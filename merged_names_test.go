@@ -0,0 +1,59 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMergedFileName(t *testing.T) {
+	for _, c := range []struct {
+		in     string
+		num    uint64
+		parsed bool
+	}{
+		{"0000000100", 100, true},
+		{"00000100", 100, true},
+		{"0000000100.dbin.zst", 100, true},
+		{"100", 100, true},
+		{"0000000100-suffix", 0, false},
+		{".dbin", 0, false},
+	} {
+		num, ok := parseMergedFileName(c.in)
+		assert.Equal(t, c.parsed, ok, c.in)
+		assert.Equal(t, c.num, num, c.in)
+	}
+}
+
+func TestDStoreIO_NextBundleWithLegacyNames(t *testing.T) {
+	var opened []string
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	for _, name := range []string{"00000100", "0000000200", "00000200", "00000300.dbin"} {
+		mergedBlocksStore.SetFile(name, nil)
+	}
+	mergedBlocksStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		opened = append(opened, name)
+		content := `{"id":"0000000000000399a","prev":"0000000000000398a","num":399,"libnum":398}` + "\n"
+		return ioutil.NopCloser(bytes.NewReader([]byte(content))), nil
+	}
+
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedBlocksStore, nil, 0, 0, 100).(*DStoreIO)
+	_, _, err := mio.NextBundle(context.Background(), 100)
+	assert.ErrorIs(t, err, ErrHoleFound, "legacy names are not recognized by default")
+
+	mio = NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedBlocksStore, nil, 0, 0, 100,
+		WithMergedFileNamesCompatibility(),
+	).(*DStoreIO)
+	base, lib, err := mio.NextBundle(context.Background(), 100)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(400), base)
+	assert.Equal(t, uint64(399), lib.Num())
+	assert.Equal(t, []string{"00000300.dbin"}, opened)
+	assert.Equal(t, "0000000200", mio.mergedFileName(200), "current naming scheme preferred")
+}
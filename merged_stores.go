@@ -0,0 +1,85 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sort"
+
+	"github.com/streamingfast/dstore"
+)
+
+type DStoreIOOption func(s *DStoreIO)
+
+// MergedBlocksStoreRange routes the merged bundles in [InclusiveLowBlock, ExclusiveHighBlock) to Store.
+// An ExclusiveHighBlock of 0 means the range is unbounded.
+type MergedBlocksStoreRange struct {
+	InclusiveLowBlock  uint64
+	ExclusiveHighBlock uint64
+	Store              dstore.Store
+}
+
+func (r *MergedBlocksStoreRange) contains(blockNum uint64) bool {
+	if blockNum < r.InclusiveLowBlock {
+		return false
+	}
+	return r.ExclusiveHighBlock == 0 || blockNum < r.ExclusiveHighBlock
+}
+
+// WithMergedBlocksStoreRange makes bundles within the given block range go to (and be read from) `store`
+// instead of the default merged blocks store. Ranges must not overlap.
+func WithMergedBlocksStoreRange(inclusiveLowBlock, exclusiveHighBlock uint64, store dstore.Store) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.mergedBlocksStoreRanges = append(s.mergedBlocksStoreRanges, &MergedBlocksStoreRange{
+			InclusiveLowBlock:  inclusiveLowBlock,
+			ExclusiveHighBlock: exclusiveHighBlock,
+			Store:              store,
+		})
+		sort.Slice(s.mergedBlocksStoreRanges, func(i, j int) bool {
+			return s.mergedBlocksStoreRanges[i].InclusiveLowBlock < s.mergedBlocksStoreRanges[j].InclusiveLowBlock
+		})
+	}
+}
+
+// mergedStoreFor returns the store in which the bundle starting at baseBlock lives
+func (s *DStoreIO) mergedStoreFor(baseBlock uint64) dstore.Store {
+	for _, r := range s.mergedBlocksStoreRanges {
+		if r.contains(baseBlock) {
+			return r.Store
+		}
+	}
+	return s.mergedBlocksStore
+}
+
+// mergedStoreSegments splits [lowBlock, infinity) into consecutive segments, each served by a single store,
+// filling the gaps between configured ranges with the default merged blocks store.
+func (s *DStoreIO) mergedStoreSegments(lowBlock uint64) (out []*MergedBlocksStoreRange) {
+	next := lowBlock
+	for _, r := range s.mergedBlocksStoreRanges {
+		if r.ExclusiveHighBlock != 0 && r.ExclusiveHighBlock <= next {
+			continue
+		}
+		if r.InclusiveLowBlock > next {
+			out = append(out, &MergedBlocksStoreRange{InclusiveLowBlock: next, ExclusiveHighBlock: r.InclusiveLowBlock, Store: s.mergedBlocksStore})
+			next = r.InclusiveLowBlock
+		}
+		out = append(out, &MergedBlocksStoreRange{InclusiveLowBlock: next, ExclusiveHighBlock: r.ExclusiveHighBlock, Store: r.Store})
+		if r.ExclusiveHighBlock == 0 {
+			return
+		}
+		next = r.ExclusiveHighBlock
+	}
+	out = append(out, &MergedBlocksStoreRange{InclusiveLowBlock: next, Store: s.mergedBlocksStore})
+	return
+}
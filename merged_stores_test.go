@@ -0,0 +1,90 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMergedBundle(lastNum uint64) []byte {
+	return []byte(fmt.Sprintf(`{"id":"%da","prev":"%da","num":%d}`+"\n", lastNum, lastNum-1, lastNum))
+}
+
+func TestDStoreIO_MergedStoreSegments(t *testing.T) {
+	defaultStore := dstore.NewMockStore(nil)
+	archive := dstore.NewMockStore(nil)
+	hot := dstore.NewMockStore(nil)
+
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), defaultStore, nil, 0, 0, 100,
+		WithMergedBlocksStoreRange(500, 0, hot),
+		WithMergedBlocksStoreRange(0, 300, archive),
+	).(*DStoreIO)
+
+	assert.Equal(t, archive, mio.mergedStoreFor(0))
+	assert.Equal(t, archive, mio.mergedStoreFor(200))
+	assert.Equal(t, defaultStore, mio.mergedStoreFor(300))
+	assert.Equal(t, defaultStore, mio.mergedStoreFor(400))
+	assert.Equal(t, hot, mio.mergedStoreFor(500))
+
+	segments := mio.mergedStoreSegments(100)
+	require.Len(t, segments, 3)
+	assert.Equal(t, &MergedBlocksStoreRange{InclusiveLowBlock: 100, ExclusiveHighBlock: 300, Store: archive}, segments[0])
+	assert.Equal(t, &MergedBlocksStoreRange{InclusiveLowBlock: 300, ExclusiveHighBlock: 500, Store: defaultStore}, segments[1])
+	assert.Equal(t, &MergedBlocksStoreRange{InclusiveLowBlock: 500, Store: hot}, segments[2])
+
+	segments = mio.mergedStoreSegments(600)
+	require.Len(t, segments, 1)
+	assert.Equal(t, &MergedBlocksStoreRange{InclusiveLowBlock: 600, Store: hot}, segments[0])
+}
+
+func TestDStoreIO_NextBundleAcrossStores(t *testing.T) {
+	archive := dstore.NewMockStore(nil)
+	archive.SetFile("0000000000", testMergedBundle(99))
+	archive.SetFile("0000000100", testMergedBundle(199))
+	hot := dstore.NewMockStore(nil)
+	hot.SetFile("0000000200", testMergedBundle(299))
+
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), dstore.NewMockStore(nil), nil, 0, 0, 100,
+		WithMergedBlocksStoreRange(0, 200, archive),
+		WithMergedBlocksStoreRange(200, 0, hot),
+	)
+
+	base, lib, err := mio.NextBundle(context.Background(), 0)
+	require.NoError(t, err)
+	assert.EqualValues(t, 300, base)
+	require.NotNil(t, lib)
+	assert.EqualValues(t, 299, lib.Num())
+}
+
+func TestDStoreIO_MergeAndStoreRouted(t *testing.T) {
+	var written []string
+	archive := dstore.NewMockStore(func(base string, f io.Reader) error {
+		written = append(written, "archive/"+base)
+		return nil
+	})
+	defaultStore := dstore.NewMockStore(func(base string, f io.Reader) error {
+		written = append(written, "default/"+base)
+		return nil
+	})
+
+	oneBlockStore := dstore.NewMockStore(nil)
+	oneBlockStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+
+	mio := NewDStoreIO(testLogger, testTracer, oneBlockStore, defaultStore, nil, 0, 0, 100,
+		WithMergedBlocksStoreRange(0, 100, archive),
+	)
+
+	require.NoError(t, mio.MergeAndStore(context.Background(), 0, []*bstream.OneBlockFile{block99}))
+	require.NoError(t, mio.MergeAndStore(context.Background(), 100, []*bstream.OneBlockFile{block100}))
+	assert.Equal(t, []string{"archive/0000000000", "default/0000000100"}, written)
+}
@@ -17,12 +17,15 @@ package merger
 import (
 	"context"
 	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/sadiq1971/merger/metrics"
 	"github.com/streamingfast/bstream"
+	dgrpcserver "github.com/streamingfast/dgrpc/server"
 	"github.com/streamingfast/shutter"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
 type Merger struct {
@@ -32,13 +35,104 @@ type Merger struct {
 	io                   IOInterface
 	firstStreamableBlock uint64
 	logger               *zap.Logger
+	componentLoggers     componentLoggers
+	spanTracer           SpanTracer
 
 	timeBetweenPolling time.Duration
 
 	timeBetweenPruning   time.Duration
 	pruningDistanceToLIB uint64
 
-	bundler *Bundler
+	settings    mergerSettings
+	settingsErr error // set by applySettings when the settings are inconsistent, Run shuts the merger down with it
+	bundler     *Bundler
+
+	stats                *runStats
+	completionReportPath string
+
+	errorClasses   []*ErrorClass
+	errorCallbacks []func(err error)
+
+	mergedCallbacks []func(lowBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile)
+
+	degradedLock   sync.Mutex
+	degradedReason string
+
+	comparator *bundleComparator
+	counters   *counters
+
+	adminListenAddr string
+	paused          uint32 // atomic
+	reloadRequested uint32 // atomic
+	pauseAt         uint64 // atomic, PauseAt block + 1, 0 when unset
+	triggerCh       chan struct{}
+
+	progressListenAddr string
+
+	readers   *readersLiveness
+	progress  *progressTracker
+	activity  *activityTracker
+	chainHalt *chainHaltDetector
+	autoFlush *autoFlusher
+	holes     *holeDetector
+	watermark *watermarkPublisher
+
+	adaptivePolling *adaptivePoller
+
+	walkResume *walkResumer
+
+	belowLowestBlockPolicy   BelowLowestBlockPolicy
+	reportedBelowLowestBlock map[string]bool
+
+	grpcServerOptions []dgrpcserver.Option
+
+	forkDBDiffs *forkDBDiffer
+
+	clock        clock
+	boundaryWait time.Duration
+
+	lifecycle *lifecycle
+
+	diagnostics *diagnostics
+
+	walkBudget time.Duration
+
+	deleteValve *deleteValve
+
+	deletionDeferral *deletionDeferral
+	deleter          OneBlockFilesDeleter // see WithDeleter, the IO when nil
+
+	notifications *notifications
+
+	arrival *arrivalMonitor
+
+	coverage *coverageVerifier
+
+	libNumEncoding LibNumEncoding
+	libNums        *libNumInterpreter // nil for absolute LIB numbers
+
+	shutdownGracePeriod time.Duration
+
+	retention *mergedRetention
+
+	poison *poisonRanges
+
+	identity InstanceIdentity
+
+	startBlockProbing bool
+	// forceStart ignores the merged files, see WithForceStartBlock, skipBootstrap starts the forkdb without LIB
+	forceStart    bool
+	skipBootstrap bool
+
+	leftoverBootstrap bool // see WithLeftoverBootstrap
+
+	// stopBlockReached is set by run when it returns because every bundle below the stop block is merged
+	stopBlockReached bool
+
+	readinessMaxDrift   time.Duration
+	livenessTimeout     time.Duration
+	lastMergedBlockTime int64 // atomic, unix nanoseconds, 0 while unknown
+	lastCycleAt         int64 // atomic, unix nanoseconds, 0 until the main loop runs
 }
 
 func NewMerger(
@@ -52,38 +146,190 @@ func NewMerger(
 	timeBetweenPruning time.Duration,
 	timeBetweenPolling time.Duration,
 	stopBlock uint64,
+	opts ...Option,
 ) *Merger {
+	return NewMergerWithOptions(io, append([]Option{
+		WithLogger(logger),
+		WithGRPC(grpcListenAddr),
+		WithFirstStreamableBlock(firstStreamableBlock),
+		WithBundleSize(bundleSize),
+		WithPruning(pruningDistanceToLIB, timeBetweenPruning),
+		WithPollInterval(timeBetweenPolling),
+		WithStopBlock(stopBlock),
+	}, opts...)...)
+}
+
+// NewMergerWithOptions creates a merger reading and writing through `io`, configured by `opts`: the settings of
+// NewMerger have their own options (WithLogger, WithBundleSize, ...), which can come in any order, and default to a no-op logger, no gRPC server, the protocol first streamable block, bundles of DefaultBundleSize blocks,
+// DefaultPruningDistanceToLIB, DefaultTimeBetweenPruning, DefaultTimeBetweenPolling and no stop block.
+func NewMergerWithOptions(io IOInterface, opts ...Option) *Merger {
+	firstStreamableBlock := bstream.GetProtocolFirstStreamableBlock
 	m := &Merger{
-		Shutter:              shutter.New(),
-		bundler:              NewBundler(firstStreamableBlock, stopBlock, firstStreamableBlock, bundleSize, io),
-		grpcListenAddr:       grpcListenAddr,
-		io:                   io,
-		firstStreamableBlock: firstStreamableBlock,
-		pruningDistanceToLIB: pruningDistanceToLIB,
-		timeBetweenPolling:   timeBetweenPolling,
-		timeBetweenPruning:   timeBetweenPruning,
-		logger:               logger,
+		Shutter:                  shutter.New(),
+		settings:                 mergerSettings{firstStreamableBlock: firstStreamableBlock, bundleSize: DefaultBundleSize},
+		bundler:                  NewBundler(firstStreamableBlock, 0, firstStreamableBlock, DefaultBundleSize, io),
+		io:                       io,
+		firstStreamableBlock:     firstStreamableBlock,
+		pruningDistanceToLIB:     DefaultPruningDistanceToLIB,
+		timeBetweenPolling:       DefaultTimeBetweenPolling,
+		timeBetweenPruning:       DefaultTimeBetweenPruning,
+		logger:                   zap.NewNop(),
+		componentLoggers:         make(componentLoggers),
+		spanTracer:               noopTracer{},
+		stats:                    &runStats{startBlock: firstStreamableBlock},
+		counters:                 newCounters(io),
+		triggerCh:                make(chan struct{}, 1),
+		readers:                  newReadersLiveness(),
+		progress:                 newProgressTracker(DefaultBundleSize),
+		activity:                 &activityTracker{},
+		walkResume:               &walkResumer{},
+		reportedBelowLowestBlock: make(map[string]bool),
+		errorClasses:             DefaultErrorClasses,
+		clock:                    realClock{},
+		lifecycle:                &lifecycle{state: StateStarting},
+		shutdownGracePeriod:      DefaultShutdownGracePeriod,
+		identity:                 newInstanceIdentity(),
+	}
+	m.counters.walkResumeName = m.WalkResumeName
+	m.bundler.degradedProbeInterval = DefaultDegradedProbeInterval
+	m.bundler.onDegraded = m.setDegraded
+	m.bundler.beforeMerge = m.replaceFlushedBundle
+	m.bundler.terminating = m.Terminating()
+	m.bundler.now = func() time.Time { return m.clock.Now() }
+	m.bundler.onMerged = func(lowBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile) {
+		m.stats.addMerged(lowBlockNum, m.bundler.bundleSize)
+		m.setMergedHeadline(oneBlockFiles)
+		m.activity.merged(m.clock.Now())
+		m.clearFlushedBundle(lowBlockNum)
+		if m.watermark != nil && !m.bundler.mergedAboveFailure(lowBlockNum) {
+			m.watermark.publish(lowBlockNum+m.bundler.bundleSize, m.clock.Now(), m.logger)
+		}
+		m.counters.addMerged()
+		if err := m.counters.save(); err != nil {
+			m.logger.Warn("cannot save state file", zap.Error(err))
+		}
+		for _, f := range m.mergedCallbacks {
+			f(lowBlockNum, oneBlockFiles)
+		}
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+	m.applySettings()
 	m.OnTerminating(func(_ error) { m.bundler.inProcess.Lock(); m.bundler.inProcess.Unlock() }) // finish bundle that may be merging async
+	m.OnTerminating(func(_ error) {
+		if closer, ok := m.io.(interface{ Close() error }); ok {
+			closer.Close() // waits for the deletions in progress
+		}
+	})
+	m.OnTerminating(func(_ error) { metrics.AppReadiness.SetNotReady() })
 
 	return m
 }
 
+// OnBundleMerged registers a callback that is called after each bundle is stored by MergeAndStore, before its forked
+// blocks are moved and its one-block files pruned. It is called from the merging goroutine and must be registered
+// before Run.
+func (m *Merger) OnBundleMerged(f func(lowBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile)) {
+	m.mergedCallbacks = append(m.mergedCallbacks, f)
+}
+
+// Run merges until the merger is shut down. With a stop block (exclusive, aligned on the bundle size), it merges every
+// bundle below it then shuts the merger down with ErrStopBlockReached.
 func (m *Merger) Run() {
+	if m.settingsErr != nil {
+		m.logger.Error("invalid merger settings", zap.Error(m.settingsErr))
+		m.Shutdown(m.settingsErr)
+		return
+	}
 	m.logger.Info("starting merger")
+	m.stats.startTime = time.Now()
+	metrics.StartTime.SetFloat64(float64(m.stats.startTime.Unix()))
+	m.setBuildInfoMetric()
+	if err := m.counters.load(); err != nil {
+		m.logger.Warn("cannot load state file, all-time counters restart from zero", zap.Error(err))
+	}
+	if last := m.LastShutdown(); last != nil {
+		m.logger.Info("previous run was interrupted while walking, continuing from the first bundle not merged",
+			zap.Time("time", last.Time),
+			zap.Uint64("base_block_num", last.BaseBlockNum),
+			zap.Uint64("highest_walked", last.HighestWalked),
+		)
+		m.counters.setLastShutdown(nil)
+	}
 
 	m.startGRPCServer()
+	m.startAdminServer()
+	m.startProgressServer()
 
 	m.startOldFilesPruner()
 	m.startForkedBlocksPruner()
+	m.startBelowLowestBlockHandler()
+	m.startDiagnosticsDumper()
+	m.startNotifier()
+	m.startCoverageVerifier()
+	m.startMergedBlocksRetention()
+
+	if resolver, ok := m.io.(MergeIntentsIOInterface); ok {
+		if err := resolver.ResolveStaleMergeIntents(context.Background()); err != nil {
+			m.logger.Warn("cannot resolve stale merge intents", zap.Error(err))
+		}
+	}
+
+	if m.poison != nil {
+		if err := m.poison.load(context.Background()); err != nil {
+			m.logger.Warn("cannot load poison ranges, the isolated bundles are attempted again", zap.Error(err))
+		}
+	}
 
-	err := m.run()
+	m.skipPrunedBundles()
+	if m.forceStart {
+		m.bootstrapForcedStart(context.Background())
+	} else {
+		m.skipMergedBundles(context.Background())
+	}
+	if m.watermark != nil {
+		m.watermark.publish(m.bundler.BaseBlockNum(), m.clock.Now(), m.logger)
+	}
+
+	ctx, cancel := m.shutdownContext()
+	defer cancel()
+	m.bundler.ctx = ctx
+	err := m.run(ctx)
+	m.setState(StateStopped)
 	if err != nil {
 		m.logger.Error("merger returned error", zap.Error(err))
+		if dumpErr := m.dumpDiagnostics(context.Background(), "fatal", err); dumpErr != nil {
+			m.logger.Warn("cannot dump diagnostics", zap.Error(dumpErr))
+		}
+	}
+	if m.bundler.stopBlock != 0 {
+		m.bundler.inProcess.Lock() // wait for the last bundle to be merged
+		m.bundler.inProcess.Unlock()
+		select {
+		case mergeErr := <-m.bundler.bundleError:
+			m.logger.Error("last bundle before the stop block not merged", zap.Error(mergeErr))
+			if err == nil {
+				err = mergeErr
+			}
+		default:
+		}
+		if reportErr := m.writeCompletionReport(m.completionReport(err)); reportErr != nil {
+			m.logger.Error("cannot write completion report", zap.Error(reportErr))
+		}
+	}
+	if err == nil && m.stopBlockReached {
+		err = ErrStopBlockReached // a clean end, told apart from an external shutdown
 	}
 	m.Shutdown(err)
 }
 
+// reachedStopBlock is called from run once every bundle below the stop block is merged
+func (m *Merger) reachedStopBlock() {
+	m.logger.Info("stop block reached", zap.Uint64("stop_block", m.bundler.stopBlock))
+	m.stopBlockReached = true
+}
+
 func (m *Merger) startForkedBlocksPruner() {
 	forkableIO, ok := m.io.(ForkAwareIOInterface)
 	if !ok {
@@ -130,15 +376,17 @@ func (m *Merger) startOldFilesPruner() {
 
 			var toDelete []*bstream.OneBlockFile
 
-			pruningTarget := m.pruningTarget(m.bundler.bundleSize)
+			m.lifecycle.resetLock.RLock() // the bundler must not be reset while we prune below its base
+			pruningTarget := m.oldFilesPruningTarget()
 			if pruningTarget == 0 {
+				m.lifecycle.resetLock.RUnlock()
 				m.logger.Debug("skipping file deletion until we have a pruning target")
 				continue
 			}
 
 			delay = m.timeBetweenPruning
 			err := m.io.WalkOneBlockFiles(ctx, m.firstStreamableBlock, func(obf *bstream.OneBlockFile) error {
-				if obf.Num < pruningTarget {
+				if obf.Num < pruningTarget && !m.poison.keeps(obf.Num) && !m.bundler.forkedAge.holds(obf) {
 					toDelete = append(toDelete, obf)
 				}
 				if len(toDelete) >= DefaultFilesDeleteBatchSize {
@@ -151,7 +399,14 @@ func (m *Merger) startOldFilesPruner() {
 				m.logger.Warn("error while walking oneBlockFiles", zap.Error(err))
 			}
 
-			m.io.DeleteAsync(toDelete)
+			toDelete = m.allowDeletion(ctx, "old_files", toDelete)
+			m.deleteOneBlockFiles(toDelete)
+			m.lifecycle.resetLock.RUnlock()
+			m.stats.addDeleted(toDelete)
+			m.counters.addDeleted(toDelete)
+			if err := m.counters.save(); err != nil {
+				m.logger.Warn("cannot save state file", zap.Error(err))
+			}
 		}
 	}()
 }
@@ -165,62 +420,202 @@ func (m *Merger) pruningTarget(distance uint64) uint64 {
 	return bundlerBase - distance
 }
 
-func (m *Merger) run() error {
-
-	ctx := context.Background()
-
+func (m *Merger) run(ctx context.Context) error {
 	var holeFoundLogged bool
 	for {
-		now := time.Now()
+		now := m.clock.Now()
+		m.cycleStarted(now)
 		if m.IsTerminating() {
 			return nil
 		}
+		if m.pauseAtReached() || m.IsPaused() {
+			m.sleepUntilNextPoll(now, m.timeBetweenPolling)
+			continue
+		}
 
-		base, lib, err := m.io.NextBundle(ctx, m.bundler.baseBlockNum)
+		m.setState(StateCheckingMerged)
+		var base uint64
+		var lib bstream.BlockRef
+		var err error
+		if m.forceStart {
+			base = m.bundler.baseBlockNum // the merged files are not trusted, see WithForceStartBlock
+		} else {
+			base, lib, err = m.io.NextBundle(ctx, m.bundler.baseBlockNum)
+			base, lib, err = m.skipPoisonRanges(ctx, base, lib, err)
+		}
+		if m.skipBootstrap {
+			lib = nil
+		}
 		if err != nil {
 			if errors.Is(err, ErrHoleFound) {
 				if holeFoundLogged {
-					m.logger.Debug("found hole in merged files. this is not normal behavior unless reprocessing batches", zap.Error(err))
+					m.componentLoggers.get(LogComponentWalk, m.logger).Debug("found hole in merged files. this is not normal behavior unless reprocessing batches", zap.Error(err))
 				} else {
 					holeFoundLogged = true
-					m.logger.Warn("found hole in merged files (next occurence will show up as Debug)", zap.Error(err))
+					m.componentLoggers.get(LogComponentWalk, m.logger).Warn("found hole in merged files (next occurence will show up as Debug)", zap.Error(err))
 				}
-			} else {
+			} else if IsDestinationOutage(err) {
+				// the walk and the linking of the one-block files go on from the bundler, merges are held meanwhile
+				m.componentLoggers.get(LogComponentWalk, m.logger).Debug("cannot check merged files, merged blocks store unavailable", zap.Error(err))
+				base, lib, err = m.bundler.baseBlockNum, nil, nil
+			} else if m.handleError(&StoreError{Op: "next_bundle", Err: err}) {
 				return err
+			} else {
+				m.sleepUntilNextPoll(now, m.timeBetweenPolling)
+				continue
 			}
 		}
-		if m.bundler.stopBlock != 0 && base > m.bundler.stopBlock {
-			if err == ErrStopBlockReached {
-				m.logger.Info("stop block reached")
-				return nil
-			}
+		if flushed, ok := m.skipsFlushedBundle(base); ok {
+			base, lib = flushed, nil // the flushed bundle is partial, it is merged again once complete
+		}
+		if m.bundler.stopBlock != 0 && base >= m.bundler.stopBlock {
+			m.reachedStopBlock() // merged by a previous run
+			return nil
 		}
 
-		if base > m.bundler.baseBlockNum {
-			logFields := []zapcore.Field{
-				zap.Uint64("previous_base_block_num", m.bundler.baseBlockNum),
-				zap.Uint64("new_base_block_num", base),
-			}
-			if lib != nil {
-				logFields = append(logFields, zap.Stringer("lib", lib))
-			}
-			m.logger.Info("resetting bundler base block num", logFields...)
-			m.bundler.Reset(base, lib)
+		if atomic.CompareAndSwapUint32(&m.reloadRequested, 1, 0) {
+			m.resetBundler(base, lib, "reload requested")
+		} else if base > m.bundler.baseBlockNum {
+			m.resetBundler(base, lib, "merged bundles found ahead of the bundler")
 		}
 
-		err = m.io.WalkOneBlockFiles(ctx, m.bundler.baseBlockNum, func(obf *bstream.OneBlockFile) error {
-			return m.bundler.HandleBlockFile(obf)
+		m.setState(StateWalking)
+		var handlerErr error
+		var highestWalked uint64
+		var filesWalked int
+		var overBudget bool
+		var newFiles int
+		var pausing bool
+		walkStart := m.clock.Now()
+		walkCtx, span := m.spanTracer.Start(ctx, SpanWalk, SpanAttribute{Key: AttributeLowBlockNum, Value: m.bundler.baseBlockNum})
+		walk := m.walkOneBlockFiles
+		if !m.walkDue(now) {
+			walk = m.handleNotifiedOneBlockFiles
+		}
+		err = walk(walkCtx, func(obf *bstream.OneBlockFile) error {
+			filesWalked++
+			if m.bundler.handled(obf) {
+				metrics.WalkSkippedFiles.Inc()
+				return nil // listed again while waiting for its bundle
+			}
+			m.libNums.interpret(obf)
+			if obf.Num > highestWalked {
+				highestWalked = obf.Num
+			}
+			m.readers.observe(obf, now)
+			m.progress.observeBlock(obf)
+			if _, seen := m.bundler.seenBlockFiles[obf.CanonicalName]; !seen {
+				newFiles++
+			}
+			handlerErr = m.bundler.HandleBlockFile(obf)
+			if m.comparator != nil {
+				m.comparator.handleBlockFile(obf)
+			}
+			if handlerErr == nil && m.IsTerminating() {
+				return errShuttingDown
+			}
+			if handlerErr == nil && m.pauseAtReached() {
+				pausing = true
+				return errPausing
+			}
+			if handlerErr == nil && (m.overWalkBudget(walkStart) || m.arrival.throttled(newFiles)) {
+				overBudget = true
+				return errWalkBudgetExceeded
+			}
+			return handlerErr
 		})
+		metrics.WalkDuration.ObserveDuration(m.clock.Now().Sub(walkStart))
+		metrics.BundlerOneBlockFiles.SetUint64(uint64(len(m.bundler.seenBlockFiles)))
+		if overBudget && err == errWalkBudgetExceeded {
+			m.logWalkBudgetExceeded(filesWalked, highestWalked)
+			err = nil
+		}
+		if pausing && err == errPausing {
+			err = nil
+		}
+		span.SetAttributes(SpanAttribute{Key: AttributeFileCount, Value: filesWalked})
+		span.End(err)
+		cycle := &CycleLogEntry{
+			Start:          now,
+			Duration:       m.clock.Now().Sub(now),
+			BaseBlockNum:   m.bundler.baseBlockNum,
+			FilesWalked:    filesWalked,
+			HighestWalked:  highestWalked,
+			SeenBlockFiles: len(m.bundler.seenBlockFiles),
+			OverBudget:     overBudget,
+		}
+		if err != nil {
+			cycle.Error = err.Error()
+		}
+		m.recordCycle(cycle)
+		m.activity.cycleDone(filesWalked)
+		if err == errShuttingDown || (err != nil && handlerErr == nil && m.IsTerminating()) {
+			m.recordShutdownProgress(filesWalked, highestWalked)
+			return nil
+		}
 		if err != nil {
 			if err == ErrStopBlockReached {
-				m.logger.Info("stop block reached")
+				m.reachedStopBlock()
+				return nil
+			}
+			if handlerErr == nil {
+				err = &StoreError{Op: "walk_one_block_files", Err: err}
+			}
+			if m.handleError(err) {
+				return err
+			}
+		}
+		if err := m.bundler.evaluateCompletion(); err != nil {
+			if err == ErrStopBlockReached {
+				m.reachedStopBlock()
 				return nil
 			}
-			return err
+			if m.handleError(err) {
+				return err
+			}
+		}
+		m.bundler.flushDueMerges()
+		m.bundler.checkForkDBMemory()
+		if m.forkDBDiffs != nil {
+			m.forkDBDiffs.record(m.forkDBDiffs.diff(time.Now(), m.bundler.seenBlockFiles, m.bundler.BaseBlockNum()), m.logger)
+		}
+		m.readers.updateAges(time.Now())
+		if m.arrival != nil {
+			m.arrival.observeCycle(now, newFiles, m.logger)
+		}
+		if m.notifications != nil {
+			m.notifications.observeReconciliation(newFiles, m.logger)
+		}
+		m.progress.sample(time.Now(), m.bundler.BaseBlockNum())
+		if m.chainHalt != nil {
+			m.chainHalt.evaluate(m.ReadersLiveness(), time.Now(), m.logger)
+		}
+		if m.holes != nil {
+			m.holes.evaluate(m.bundler, m.clock.Now(), m.logger)
+		}
+		if m.autoFlush != nil {
+			m.evaluateAutoFlush(ctx)
+		}
+		if m.adaptivePolling != nil {
+			drift, driftKnown := m.HeadDrift()
+			m.adaptivePolling.observe(newFiles, drift, driftKnown, m.timeBetweenPolling)
 		}
 
-		if spentTime := time.Since(now); spentTime < m.timeBetweenPolling {
-			time.Sleep(m.timeBetweenPolling - spentTime)
+		if overBudget || pausing {
+			continue // there are more files to walk, no need to wait for them
 		}
+		m.sleepUntilNextPoll(now, m.pollDelay(highestWalked))
+	}
+}
+
+// setMergedHeadline sets the last merged block headline metrics and the head drift, see oneBlockFileTime
+func (m *Merger) setMergedHeadline(oneBlockFiles []*bstream.OneBlockFile) {
+	if len(oneBlockFiles) == 0 {
+		return
+	}
+	last := oneBlockFiles[len(oneBlockFiles)-1]
+	metrics.LastMergedBlockNumber.SetUint64(last.Num)
+	if blockTime, ok := m.mergedBlockTime(last); ok {
+		metrics.LastMergedBlockTime.SetFloat64(float64(blockTime.Unix()))
 	}
 }
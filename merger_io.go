@@ -7,9 +7,9 @@ import (
 	"io"
 	"io/ioutil"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sadiq1971/merger/metrics"
@@ -65,10 +65,85 @@ type DStoreIO struct {
 
 	bundleSize uint64
 
-	logger *zap.Logger
-	tracer logging.Tracer
-	od     *oneBlockFilesDeleter
-	forkOd *oneBlockFilesDeleter
+	mergedBlocksStoreRanges []*MergedBlocksStoreRange
+	mergedFilesCache        *mergedFilesCache
+
+	bytesWritten uint64 // atomic
+
+	deletionRate float64
+
+	blockTimeAnalysis *blockTimeAnalysis
+
+	prefetchConcurrency int
+	prefetchByteBudget  uint64
+	maxBundleMemory     uint64
+	readAhead           int
+	downloads           *downloadLimiter // nil unless WithMaxConcurrentDownloads
+	oneBlockCache       *oneBlockCache   // nil unless WithOneBlockCacheBytes
+	checksumStore       dstore.Store     // nil unless WithChecksums
+	checksumPolicy      ChecksumPolicy
+
+	existingBundlePolicy ExistingBundlePolicy
+	prefixBoundedListing bool
+
+	validationPolicies ValidationPolicies
+	lastMerged         lastMergedBlock // for the continuity check
+
+	intentsStore     dstore.Store
+	instanceID       string
+	intentStaleAfter time.Duration
+
+	pressureSignal   PressureSignal
+	pressureMaxDefer time.Duration
+
+	compressionLevel int
+	bundleWriter     BundleWriter // nil writes dbin, see WithBundleWriter
+
+	archiveStore dstore.Store
+
+	hubHandoff *HubHandoff
+
+	blockTransformer BlockTransformer
+	blockRewriter    BlockRewriter
+
+	canonicalizeSuffix bool
+	canonicalSuffix    string
+
+	verifyAfterMerge bool
+
+	replicas []*mergedReplica
+
+	bundleStats      bool
+	bundleStatsStore dstore.Store
+
+	protocolUpgrades    []ProtocolUpgrade // sorted by height
+	protocolUpgradeTags dstore.Store
+
+	cursor *mergedCursorWriter // nil unless the merged cursor is enabled
+
+	retryPolicy *RetryPolicy                    // nil for the fixed upload retries
+	breakers    map[StoreDomain]*circuitBreaker // nil unless the retry policy has circuit breakers
+
+	bulkDeleter BulkDeleter // of the one-block files store, nil to delete them one by one
+
+	downloadDeadLetters *downloadDeadLetters // nil retries failing downloads forever
+
+	payloadDedup bool
+	objectHasher ObjectHasher // of the one-block files store, nil to hash the payloads once downloaded
+
+	bootstrapBundles     int
+	bootstrapConcurrency int
+	bootstrapped         uint32 // atomic
+
+	mergedFileNames *mergedFileNames // only in compatibility mode
+
+	logger           *zap.Logger
+	componentLoggers componentLoggers
+	tracer           logging.Tracer
+	spanTracer       SpanTracer
+	od               *oneBlockFilesDeleter
+	forkOd           *oneBlockFilesDeleter
+	deleterPool      *DeleterPool
 }
 
 func NewDStoreIO(
@@ -80,10 +155,8 @@ func NewDStoreIO(
 	retryAttempts int,
 	retryCooldown time.Duration,
 	bundleSize uint64,
+	opts ...DStoreIOOption,
 ) IOInterface {
-
-	od := &oneBlockFilesDeleter{store: oneBlocksStore, logger: logger}
-	od.Start(DefaultFilesDeleteThreads, DefaultFilesDeleteBatchSize*2)
 	dstoreIO := &DStoreIO{
 		oneBlocksStore:    oneBlocksStore,
 		mergedBlocksStore: mergedBlocksStore,
@@ -91,16 +164,32 @@ func NewDStoreIO(
 		retryCooldown:     retryCooldown,
 		bundleSize:        bundleSize,
 		logger:            logger,
+		componentLoggers:  make(componentLoggers),
 		tracer:            tracer,
-		od:                od,
+		spanTracer:        noopTracer{},
+		mergedFilesCache:  newMergedFilesCache(DefaultMergedFilesCacheSize),
+
+		prefetchConcurrency: ParallelOneBlockDownload,
+		prefetchByteBudget:  DefaultPrefetchByteBudget,
+		readAhead:           ParallelOneBlockDownload,
+	}
+	for _, opt := range opts {
+		opt(dstoreIO)
+	}
+	for _, r := range dstoreIO.replicas {
+		go dstoreIO.replicate(r)
 	}
 
+	dstoreIO.od = dstoreIO.newOneBlockFilesDeleter("one_block", oneBlocksStore, dstoreIO.breakers[StoreDomainSource])
+	dstoreIO.od.bulk = dstoreIO.bulkDeleter
+	dstoreIO.od.Start(DefaultFilesDeleteThreads, DefaultFilesDeleteBatchSize*2)
+
 	forkAware := forkedBlocksStore != nil
 	if !forkAware {
 		return dstoreIO
 	}
 
-	forkOd := &oneBlockFilesDeleter{store: forkedBlocksStore, logger: logger}
+	forkOd := dstoreIO.newOneBlockFilesDeleter("forked", forkedBlocksStore, nil)
 	forkOd.Start(DefaultFilesDeleteThreads, DefaultFilesDeleteBatchSize*2)
 
 	return &ForkAwareDStoreIO{
@@ -111,6 +200,21 @@ func NewDStoreIO(
 }
 
 func (s *DStoreIO) MergeAndStore(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) (err error) {
+	ctx, span := s.spanTracer.Start(ctx, SpanMergeAndStore,
+		SpanAttribute{Key: AttributeLowBlockNum, Value: inclusiveLowerBlock},
+		SpanAttribute{Key: AttributeFileCount, Value: len(oneBlockFiles)},
+	)
+	defer func() { span.End(err) }()
+
+	if upgrades := s.upgradesWithin(inclusiveLowerBlock); len(upgrades) != 0 {
+		return s.mergeAcrossUpgrades(ctx, inclusiveLowerBlock, upgrades, oneBlockFiles)
+	}
+	return s.mergeAndStoreRange(ctx, inclusiveLowerBlock, inclusiveLowerBlock+s.bundleSize, oneBlockFiles)
+}
+
+// mergeAndStoreRange writes the merged file of the blocks in [inclusiveLowerBlock, exclusiveHigherBlock), named
+// after inclusiveLowerBlock. It is the whole bundle unless the bundle is split at a protocol upgrade.
+func (s *DStoreIO) mergeAndStoreRange(ctx context.Context, inclusiveLowerBlock, exclusiveHigherBlock uint64, oneBlockFiles []*bstream.OneBlockFile) (err error) {
 	// since we keep the last block from previous merged bundle for future deleting,
 	// we want to make sure that it does not end up in this merged bundle too
 	var filteredOBF []*bstream.OneBlockFile
@@ -119,40 +223,182 @@ func (s *DStoreIO) MergeAndStore(ctx context.Context, inclusiveLowerBlock uint64
 			filteredOBF = append(filteredOBF, obf)
 		}
 	}
+	if s.downloadDeadLetters != nil {
+		if filteredOBF, err = s.downloadDeadLetters.skip(oneBlockFiles, filteredOBF); err != nil {
+			return err
+		}
+	}
 	if len(filteredOBF) == 0 {
 		return
 	}
+	if s.validationPolicies.policy(ValidationLinkage) != ValidationOff {
+		if linkageErr := validateLinkage(filteredOBF); linkageErr != nil {
+			if err := validationFailed(s.logger, s.validationPolicies, ValidationLinkage, linkageErr); err != nil {
+				return err
+			}
+		}
+	}
+	if s.validationPolicies.policy(ValidationContinuity) != ValidationOff {
+		if continuityErr := s.checkContinuity(ctx, inclusiveLowerBlock, filteredOBF[0]); continuityErr != nil {
+			if err := validationFailed(s.logger, s.validationPolicies, ValidationContinuity, continuityErr); err != nil {
+				return err
+			}
+		}
+		defer func() {
+			if err == nil {
+				s.lastMerged.set(exclusiveHigherBlock, filteredOBF[len(filteredOBF)-1])
+			}
+		}()
+	}
+	if s.intentsStore != nil {
+		complete, err := s.acquireMergeIntent(ctx, inclusiveLowerBlock)
+		if err != nil {
+			return err
+		}
+		if complete {
+			return nil
+		}
+		defer s.deleteMergeIntent(inclusiveLowerBlock)
+	}
+	if skip, err := s.checkExistingBundle(ctx, inclusiveLowerBlock, filteredOBF); err != nil || skip {
+		return err
+	}
+	if err := s.waitForPressure(ctx, "merge"); err != nil {
+		return err
+	}
 	t0 := time.Now()
 
 	bundleFilename := fileNameForBlocksBundle(inclusiveLowerBlock)
-	s.logger.Info("about to write merged blocks to storage location",
+	s.componentLoggers.get(LogComponentMerge, s.logger).Info("about to write merged blocks to storage location",
 		zap.String("filename", bundleFilename),
 		zap.Duration("write_timeout", WriteObjectTimeout),
 		zap.Uint64("lower_block_num", filteredOBF[0].Num),
 		zap.Uint64("highest_block_num", filteredOBF[len(filteredOBF)-1].Num),
 	)
 
-	err = Retry(s.logger, s.retryAttempts, s.retryCooldown, func() error {
-		inCtx, cancel := context.WithTimeout(ctx, WriteObjectTimeout)
-		defer cancel()
-		return s.mergedBlocksStore.WriteObject(inCtx, bundleFilename, NewBundleReader(ctx, s.logger, s.tracer, filteredOBF, s.DownloadOneBlockFile))
+	var bundleReader *BundleReader
+	var statsCollector *bundleStatsCollector
+	var stored *countingReader
+	err = s.retryUpload(ctx, func(inCtx context.Context) error {
+		if s.maxBundleMemory != 0 {
+			bundleReader = NewStreamingBundleReader(inCtx, s.logger, s.tracer, filteredOBF, s.DownloadOneBlockFile, s.prefetchConcurrency, s.maxBundleMemory)
+		} else {
+			prefetchCtx, span := s.spanTracer.Start(inCtx, SpanPrefetch, SpanAttribute{Key: AttributeFileCount, Value: len(filteredOBF)})
+			err := PrefetchData(prefetchCtx, filteredOBF, s.DownloadOneBlockFile, s.prefetchConcurrency, s.prefetchByteBudget)
+			span.End(err)
+			if err != nil {
+				return fmt.Errorf("prefetching one-block files: %w", err)
+			}
+			bundleReader = NewParallelBundleReader(inCtx, s.logger, s.tracer, filteredOBF, s.DownloadOneBlockFile, s.readAhead)
+		}
+		bundleReader.transformer = s.blockTransformer
+		bundleReader.rewriter = s.blockRewriter
+		bundleReader.canonicalizeSuffix = s.canonicalizeSuffix
+		bundleReader.canonicalSuffix = s.canonicalSuffix
+		if s.bundleStats {
+			statsCollector = &bundleStatsCollector{hashPayloads: s.payloadDedup}
+			bundleReader.stats = statsCollector
+		}
+		bundleReader.validationPolicies = s.validationPolicies
+		bundleReader.collectBlockTimes = s.blockTimeAnalysis != nil
+		var content io.Reader = bundleReader
+		if s.bundleWriter != nil {
+			content = bundleContentReader(content, s.bundleWriter)
+		}
+		if s.compressionLevel != 0 {
+			compressed, err := compressedReader(content, s.compressionLevel)
+			if err != nil {
+				return err
+			}
+			content = compressed
+		}
+		if statsCollector != nil {
+			stored = &countingReader{Reader: content}
+			content = stored
+		}
+		uploadCtx, span := s.spanTracer.Start(inCtx, SpanUpload, SpanAttribute{Key: AttributeLowBlockNum, Value: inclusiveLowerBlock})
+		err := s.mergedStoreFor(inclusiveLowerBlock).WriteObject(uploadCtx, bundleFilename, content)
+		span.End(err)
+		bundleReader.Close()
+		return err
 	})
 	if err != nil {
-		return fmt.Errorf("write object error: %s", err)
+		return fmt.Errorf("write object error: %w", err)
+	}
+	atomic.AddUint64(&s.bytesWritten, bundleReader.totalRead)
+	metrics.BundleBytes.SetUint64(bundleReader.totalRead)
+	s.mergedFilesCache.remove(inclusiveLowerBlock)
+	if s.verifyAfterMerge {
+		if err := s.verifyMergedFile(ctx, inclusiveLowerBlock, filteredOBF); err != nil {
+			if ctx.Err() == nil {
+				s.rejectMergedFile(inclusiveLowerBlock, err)
+			}
+			return err
+		}
+	}
+	for _, r := range s.replicas {
+		r.enqueue(inclusiveLowerBlock)
+	}
+	if statsCollector != nil {
+		s.exportBundleStats(ctx, statsCollector.result(inclusiveLowerBlock, atomic.LoadUint64(&stored.count)))
+	}
+	if s.hubHandoff != nil {
+		s.hubHandoff.prune(exclusiveHigherBlock)
+	}
+	s.tagUpgradeBoundary(ctx, inclusiveLowerBlock, exclusiveHigherBlock)
+	if s.cursor != nil {
+		s.updateCursor(ctx, inclusiveLowerBlock, filteredOBF[len(filteredOBF)-1], bundleReader.lastBlockTimestamp())
+	}
+	if s.blockTimeAnalysis != nil {
+		if bundleReader.blockTimesErr != nil {
+			s.logger.Debug("cannot analyze block times", zap.Error(bundleReader.blockTimesErr))
+		} else {
+			s.analyzeBlockTimes(inclusiveLowerBlock, bundleReader.blockNums, bundleReader.blockTimes)
+		}
 	}
 
-	s.logger.Info("merged and uploaded", zap.String("filename", fileNameForBlocksBundle(inclusiveLowerBlock)), zap.Duration("merge_time", time.Since(t0)))
+	if bundleReader.transformed != 0 {
+		metrics.TransformedBlocks.AddInt(bundleReader.transformed)
+	}
+	if bundleReader.rewritten != 0 {
+		metrics.RewrittenBlocks.AddInt(bundleReader.rewritten)
+	}
+
+	metrics.MergeDuration.ObserveSince(t0)
+	logFields := []zap.Field{zap.String("filename", fileNameForBlocksBundle(inclusiveLowerBlock)), zap.Duration("merge_time", time.Since(t0))}
+	if bundleReader.codec != nil {
+		logFields = append(logFields, zap.Stringer("codec", bundleReader.codec), zap.Int("transformed_blocks", bundleReader.transformed))
+	}
+	s.componentLoggers.get(LogComponentMerge, s.logger).Info("merged and uploaded", logFields...)
 
 	return
 }
 
 func (s *DStoreIO) WalkOneBlockFiles(ctx context.Context, lowestBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+	return s.WalkOneBlockFilesFrom(ctx, fileNameForBlocksBundle(lowestBlock), callback)
+}
 
-	return s.oneBlocksStore.WalkFrom(ctx, "", fileNameForBlocksBundle(lowestBlock), func(filename string) error {
+// WalkOneBlockFilesFrom implements ResumableWalkIOInterface
+func (s *DStoreIO) WalkOneBlockFilesFrom(ctx context.Context, startName string, callback func(*bstream.OneBlockFile) error) (err error) {
+	var listed int
+	ctx, span := s.spanTracer.Start(ctx, SpanListOneBlocks)
+	defer func() {
+		span.SetAttributes(SpanAttribute{Key: AttributeFileCount, Value: listed})
+		span.End(err)
+	}()
+
+	walk := func(ctx context.Context, f func(filename string) error) error {
+		if s.prefixBoundedListing {
+			return walkFromByPrefixes(ctx, s.oneBlocksStore, startName, f)
+		}
+		return s.oneBlocksStore.WalkFrom(ctx, "", startName, f)
+	}
+	return s.retryList(ctx, StoreDomainSource, walk, func(filename string) error {
 		if strings.HasSuffix(filename, ".tmp") {
 			return nil
 		}
-		oneBlockFile := bstream.MustNewOneBlockFile(filename)
+		oneBlockFile := mustNewOneBlockFile(filename)
+		listed++
 
 		if err := callback(oneBlockFile); err != nil {
 			return err
@@ -163,25 +409,77 @@ func (s *DStoreIO) WalkOneBlockFiles(ctx context.Context, lowestBlock uint64, ca
 }
 
 func (s *DStoreIO) DownloadOneBlockFile(ctx context.Context, oneBlockFile *bstream.OneBlockFile) (data []byte, err error) {
+	ctx, span := s.spanTracer.Start(ctx, SpanDownloadOneBlock, SpanAttribute{Key: AttributeBlockNum, Value: oneBlockFile.Num})
+	defer func() { span.End(err) }()
+
+	if s.hubHandoff != nil {
+		if data, ok := s.hubHandoff.get(oneBlockFile.CanonicalName); ok {
+			return data, nil
+		}
+	}
+	if data, ok := s.oneBlockCache.get(oneBlockFile.CanonicalName); ok {
+		return data, nil
+	}
+	if s.downloadDeadLetters != nil {
+		defer func() { s.recordDownload(ctx, oneBlockFile, err) }()
+	}
+	defer func() {
+		if err == nil {
+			s.oneBlockCache.put(oneBlockFile.CanonicalName, data)
+		}
+	}()
+	if s.retryPolicy == nil {
+		return s.downloadOneBlockFile(ctx, oneBlockFile)
+	}
+	var attempts int
+	err = s.retry(ctx, StoreDomainSource, "download", s.retryPolicy.DownloadTimeout, func(ctx context.Context) (err error) {
+		if attempts++; attempts > 1 {
+			metrics.DownloadRetries.Inc()
+		}
+		data, err = s.downloadOneBlockFile(ctx, oneBlockFile)
+		return err
+	})
+	return
+}
+
+func (s *DStoreIO) downloadOneBlockFile(ctx context.Context, oneBlockFile *bstream.OneBlockFile) (data []byte, err error) {
 	for filename := range oneBlockFile.Filenames { // will try to get MemoizeData from any of those files
+		if err != nil {
+			metrics.DownloadRetries.Inc() // the previous copy failed
+		}
+		var release func()
+		if release, err = s.downloads.acquire(ctx); err != nil {
+			return nil, err
+		}
 		var out io.ReadCloser
 		out, err = s.oneBlocksStore.OpenObject(ctx, filename)
-		s.logger.Debug("downloading one block", zap.String("file_name", filename))
+		s.componentLoggers.get(LogComponentMerge, s.logger).Debug("downloading one block", zap.String("file_name", filename))
 		if err != nil {
+			release()
 			continue
 		}
 		defer out.Close()
 
 		select {
 		case <-ctx.Done():
+			release()
 			return nil, ctx.Err()
 		default:
 		}
 
 		data, err = ioutil.ReadAll(out)
-		if err == nil {
-			return data, nil
+		release()
+		if err != nil {
+			continue
 		}
+		if err = s.verifyChecksum(ctx, filename, data); err != nil {
+			var mismatch *ChecksumMismatchError
+			if errors.As(err, &mismatch) && s.checksumPolicy == ChecksumFail {
+				return nil, err
+			}
+			continue // another copy may be fine
+		}
+		return data, nil
 	}
 
 	return
@@ -191,21 +489,36 @@ func (s *DStoreIO) NextBundle(ctx context.Context, lowestBaseBlock uint64) (outB
 
 	var lastFound *uint64
 	outBaseBlock = lowestBaseBlock
-	err = s.mergedBlocksStore.WalkFrom(ctx, "", fileNameForBlocksBundle(lowestBaseBlock), func(filename string) error {
-		num, err := strconv.ParseUint(filename, 10, 64)
-		if err != nil {
-			return err
+	for _, segment := range s.mergedStoreSegments(lowestBaseBlock) {
+		if outBaseBlock < segment.InclusiveLowBlock {
+			break // the previous segment was not complete, the next bundle is there
 		}
+		err = s.walkMergedFiles(ctx, segment, outBaseBlock, func(num uint64) error {
+			if segment.ExclusiveHighBlock != 0 && num >= segment.ExclusiveHighBlock {
+				return dstore.StopIteration
+			}
 
-		if num != outBaseBlock {
-			return fmt.Errorf("%w: merged blocks skip from %d to %d, you need to fill this hole, set firstStreamableBlock above this hole or set merger option to ignore holes", ErrHoleFound, outBaseBlock, num)
+			if num < outBaseBlock && s.isUpgradeSplit(num) {
+				lastFound = &num // the bundle was split at a protocol upgrade, its last block is in this merged file
+				return nil
+			}
+			if num != outBaseBlock {
+				return fmt.Errorf("%w: merged blocks skip from %d to %d, you need to fill this hole, set firstStreamableBlock above this hole or set merger option to ignore holes", ErrHoleFound, outBaseBlock, num)
+			}
+			outBaseBlock += s.bundleSize
+			lastFound = &num
+			return nil
+		})
+		if err != nil && !errors.Is(err, dstore.StopIteration) {
+			break
 		}
-		outBaseBlock += s.bundleSize
-		lastFound = &num
-		return nil
-	})
+		err = nil
+	}
 
 	if lastFound != nil {
+		if s.bootstrapBundles > 0 && atomic.CompareAndSwapUint32(&s.bootstrapped, 0, 1) {
+			s.fetchBootstrapBundles(ctx, *lastFound)
+		}
 		last, lastTime, err := s.readLastBlockFromMerged(ctx, *lastFound)
 		if err != nil {
 			return 0, nil, err
@@ -219,21 +532,42 @@ func (s *DStoreIO) NextBundle(ctx context.Context, lowestBaseBlock uint64) (outB
 }
 
 func (s *DStoreIO) readLastBlockFromMerged(ctx context.Context, baseBlock uint64) (bstream.BlockRef, *time.Time, error) {
-	subCtx, cancel := context.WithTimeout(ctx, GetObjectTimeout)
-	defer cancel()
-	reader, err := s.mergedBlocksStore.OpenObject(subCtx, fileNameForBlocksBundle(baseBlock))
+	f, err := s.fetchMergedFile(ctx, baseBlock)
 	if err != nil {
 		return nil, nil, err
 	}
-	last, err := lastBlock(reader)
-	if err != nil {
-		return nil, nil, err
+	last := f.oneBlockFiles[len(f.oneBlockFiles)-1]
+	return bstream.NewBlockRef(last.ID, last.Num), &f.lastBlockTime, nil
+}
+
+// WithDeletionRate limits the deletion of one-block files to `filesPerSecond`, trickling them continuously
+// between merges instead of in a burst after each of them. 0 means no limit.
+func WithDeletionRate(filesPerSecond float64) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.deletionRate = filesPerSecond
 	}
-	// we truncate the block ID to have the short version that we get on oneBlockFiles
-	return bstream.NewBlockRef(bstream.TruncateBlockID(last.Id), last.Number), &last.Timestamp, nil
 }
 
-func (s *DStoreIO) DeleteAsync(oneBlockFiles []*bstream.OneBlockFile) error {
+// BytesWritten returns the total number of bytes of merged files written by this instance
+func (s *DStoreIO) BytesWritten() uint64 {
+	return atomic.LoadUint64(&s.bytesWritten)
+}
+
+// Close stops the deletions of the one-block files, waiting for the ones in progress
+func (s *DStoreIO) Close() error {
+	s.od.Close()
+	return nil
+}
+
+// Close also stops the deletions of the forked blocks
+func (s *ForkAwareDStoreIO) Close() error {
+	s.forkOd.Close()
+	return s.DStoreIO.Close()
+}
+
+func (s *DStoreIO) DeleteAsync(oneBlockFiles []*bstream.OneBlockFile) (err error) {
+	_, span := s.spanTracer.Start(context.Background(), SpanDeleteOneBlocks, SpanAttribute{Key: AttributeFileCount, Value: len(oneBlockFiles)})
+	defer func() { span.End(err) }()
 	return s.od.Delete(oneBlockFiles)
 }
 
@@ -263,7 +597,7 @@ func (s *ForkAwareDStoreIO) DeleteForkedBlocksAsync(inclusiveLowBoundary, inclus
 		if strings.HasSuffix(filename, ".tmp") {
 			return nil
 		}
-		obf := bstream.MustNewOneBlockFile(filename)
+		obf := mustNewOneBlockFile(filename)
 		if obf.Num > inclusiveHighBoundary {
 			return io.EOF
 		}
@@ -285,17 +619,76 @@ func (s *ForkAwareDStoreIO) DeleteForkedBlocksAsync(inclusiveLowBoundary, inclus
 type oneBlockFilesDeleter struct {
 	sync.Mutex
 	toProcess     chan string
+	queue         string // label of the queue depth metric
 	retryAttempts int
 	retryCooldown time.Duration
+	deleteTimeout time.Duration
+	breaker       *circuitBreaker
 	store         dstore.Store
+	bulk          BulkDeleter
 	logger        *zap.Logger
+
+	// deletionRate, in files per second, spreads the deletions evenly over time instead of
+	// deleting as fast as possible right after a merge. 0 means no limit.
+	deletionRate float64
+	throttle     <-chan time.Time
+
+	pool *DeleterPool // nil for the deleter's own workers
+
+	closing chan struct{} // closed by Close, guarded by the deleter lock
+	closed  bool
+	workers sync.WaitGroup
+
+	failuresLock sync.Mutex
+	failures     map[string]*DeletionFailure // waiting to be retried
+	deadLetters  []*DeletionFailure          // gave up after retryAttempts
+}
+
+// newOneBlockFilesDeleter reports the deletions to `breaker`, if not nil, and its queue depth labelled `queue`
+func (s *DStoreIO) newOneBlockFilesDeleter(queue string, store dstore.Store, breaker *circuitBreaker) *oneBlockFilesDeleter {
+	od := &oneBlockFilesDeleter{queue: queue, store: store, logger: s.logger, deletionRate: s.deletionRate, retryAttempts: DeletionAttempts, retryCooldown: DeletionRetryInterval, deleteTimeout: DeleteObjectTimeout}
+	if s.retryPolicy != nil {
+		od.retryAttempts = s.retryPolicy.MaxAttempts
+		od.deleteTimeout = s.retryPolicy.DeleteTimeout
+		od.breaker = breaker
+	}
+	od.pool = s.deleterPool
+	return od
 }
 
 func (od *oneBlockFilesDeleter) Start(threads int, maxDeletions int) {
 	od.toProcess = make(chan string, maxDeletions)
+	od.closing = make(chan struct{})
+	if od.deletionRate > 0 {
+		od.throttle = time.NewTicker(time.Duration(float64(time.Second) / od.deletionRate)).C
+	}
+	process := od.processDeletions
+	switch {
+	case od.bulk != nil && od.deletionRate == 0:
+		threads = 1 // one bulk request at a time, each deleting up to BulkDeleteBatchSize files
+		process = od.processBulkDeletions
+	case od.pool != nil:
+		threads = 1 // only feeds the pool, in order and throttled
+	}
+	od.workers.Add(threads)
 	for i := 0; i < threads; i++ {
-		go od.processDeletions()
+		go process()
+	}
+	go od.processRetries()
+}
+
+// Close stops the deletions: it waits for the ones in progress, the queued ones are dropped and Delete fails from then
+// on. The deletions handed to a DeleterPool are not waited for.
+func (od *oneBlockFilesDeleter) Close() {
+	od.Lock()
+	if od.closed {
+		od.Unlock()
+		return
 	}
+	od.closed = true
+	close(od.closing)
+	od.Unlock()
+	od.workers.Wait()
 }
 
 func (od *oneBlockFilesDeleter) Delete(oneBlockFiles []*bstream.OneBlockFile) error {
@@ -305,6 +698,9 @@ func (od *oneBlockFilesDeleter) Delete(oneBlockFiles []*bstream.OneBlockFile) er
 	if len(oneBlockFiles) == 0 {
 		return nil
 	}
+	if od.closed {
+		return fmt.Errorf("deleter is closed")
+	}
 
 	var fileNames []string
 	for _, oneBlockFile := range oneBlockFiles {
@@ -345,47 +741,44 @@ func (od *oneBlockFilesDeleter) Delete(oneBlockFiles []*bstream.OneBlockFile) er
 		}
 		od.toProcess <- file
 	}
+	metrics.DeletionQueueDepth.SetInt(len(od.toProcess), od.queue)
 	return err
 }
 
 func (od *oneBlockFilesDeleter) processDeletions() {
+	defer od.workers.Done()
 	for {
-		file := <-od.toProcess
-		err := Retry(od.logger, od.retryAttempts, od.retryCooldown, func() error {
-			ctx, cancel := context.WithTimeout(context.Background(), DeleteObjectTimeout)
-			defer cancel()
-			err := od.store.DeleteObject(ctx, file)
-			if errors.Is(err, dstore.ErrNotFound) {
-				return nil
+		var file string
+		select {
+		case file = <-od.toProcess:
+		case <-od.closing:
+			return
+		}
+		metrics.DeletionQueueDepth.SetInt(len(od.toProcess), od.queue)
+		if od.throttle != nil {
+			select {
+			case <-od.throttle:
+			case <-od.closing:
+				return
 			}
-			return err
-		})
-		if err != nil {
-			od.logger.Warn("cannot delete oneblock file after a few retries", zap.String("file", file), zap.Error(err))
 		}
+		if od.pool != nil {
+			od.pool.jobs <- pooledDeletion{od: od, file: file}
+			continue
+		}
+		od.deleteObject(file)
 	}
 }
 
-func lastBlock(mergeFileReader io.ReadCloser) (out *bstream.Block, err error) {
-	defer mergeFileReader.Close()
-
-	blkReader, err := bstream.GetBlockReaderFactory.New(mergeFileReader)
-	if err != nil {
-		return nil, err
-	}
-
-	for {
-		block, err := blkReader.Read()
-		if block != nil {
-			out = block
-		}
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
+// deleteObject makes a single attempt, failures go through the retry queue so that one bad object does not hold
+// the worker
+func (od *oneBlockFilesDeleter) deleteObject(file string) {
+	ctx, cancel := context.WithTimeout(context.Background(), od.deleteTimeout)
+	err := od.store.DeleteObject(ctx, file)
+	cancel()
+	if err != nil && !errors.Is(err, dstore.ErrNotFound) {
+		od.recordFailure(file, err)
+		return
 	}
-
-	return out, nil
+	od.clearFailure(file)
 }
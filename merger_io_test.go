@@ -16,9 +16,11 @@ package merger
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -77,7 +79,10 @@ func TestMergerIO_MergeUploadPerfect(t *testing.T) {
 	done := make(chan struct{})
 
 	oneBlockStore := dstore.NewMockStore(nil)
+	var filesReadLock sync.Mutex
 	oneBlockStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		filesReadLock.Lock()
+		defer filesReadLock.Unlock()
 		filesRead = append(filesRead, name)
 		if len(filesRead) == 2 {
 			close(done)
@@ -109,7 +114,10 @@ func TestMergerIO_MergeUploadPerfect(t *testing.T) {
 		t.Error("timeout waiting for read")
 	case <-done:
 	}
-	assert.Equal(t, expectFilenames, filesRead)
+	filesReadLock.Lock()
+	defer filesReadLock.Unlock()
+	// the one-block files are prefetched in parallel before merging, they are not opened in order
+	assert.ElementsMatch(t, expectFilenames, filesRead)
 }
 
 func TestMergerIO_MergeUploadFiltered(t *testing.T) {
@@ -124,7 +132,10 @@ func TestMergerIO_MergeUploadFiltered(t *testing.T) {
 	done := make(chan struct{})
 
 	oneBlockStore := dstore.NewMockStore(nil)
+	var filesReadLock sync.Mutex
 	oneBlockStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		filesReadLock.Lock()
+		defer filesReadLock.Unlock()
 		filesRead = append(filesRead, name)
 		if len(filesRead) == 2 {
 			close(done)
@@ -156,7 +167,10 @@ func TestMergerIO_MergeUploadFiltered(t *testing.T) {
 		t.Error("timeout waiting for read")
 	case <-done:
 	}
-	assert.Equal(t, expectFilenames, filesRead)
+	filesReadLock.Lock()
+	defer filesReadLock.Unlock()
+	// the one-block files are prefetched in parallel before merging, they are not opened in order
+	assert.ElementsMatch(t, expectFilenames, filesRead)
 }
 
 func TestMergerIO_MergeUploadNoFiles(t *testing.T) {
@@ -181,3 +195,63 @@ func TestMergerIO_MergeUploadFilteredToZero(t *testing.T) {
 	err := mio.MergeAndStore(context.Background(), 114, files)
 	require.NoError(t, err)
 }
+
+func TestOneBlockFilesDeleter_DeletionRate(t *testing.T) {
+	deleted := make(chan string, 10)
+	store := dstore.NewMockStore(nil)
+	store.DeleteObjectFunc = func(_ context.Context, base string) error {
+		deleted <- base
+		return nil
+	}
+
+	od := &oneBlockFilesDeleter{store: store, logger: testLogger, deletionRate: 50}
+	od.Start(4, 10)
+
+	start := time.Now()
+	require.NoError(t, od.Delete([]*bstream.OneBlockFile{block100, block101, block102Final100}))
+	for i := 0; i < 3; i++ {
+		select {
+		case <-deleted:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for deletion")
+		}
+	}
+	// 3 ticks at 50 per second, whatever the number of threads
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestOneBlockFilesDeleter_RetryQueue(t *testing.T) {
+	var lock sync.Mutex
+	attempts := map[string]int{}
+	store := dstore.NewMockStore(nil)
+	store.DeleteObjectFunc = func(_ context.Context, base string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts[base]++
+		switch {
+		case base == block100.CanonicalName+"-suffix":
+			return fmt.Errorf("always failing")
+		case base == block101.CanonicalName+"-suffix" && attempts[base] == 1:
+			return fmt.Errorf("failing once")
+		}
+		return nil
+	}
+
+	od := &oneBlockFilesDeleter{store: store, logger: testLogger, retryAttempts: 3, retryCooldown: 10 * time.Millisecond}
+	od.Start(1, 10)
+	require.NoError(t, od.Delete([]*bstream.OneBlockFile{block100, block101, block102Final100}))
+
+	require.Eventually(t, func() bool { return len(od.DeadLetters()) == 1 }, time.Second, 5*time.Millisecond)
+	deadLetter := od.DeadLetters()[0]
+	assert.Equal(t, block100.CanonicalName+"-suffix", deadLetter.File)
+	assert.Equal(t, 3, deadLetter.Attempts)
+	assert.Equal(t, "always failing", deadLetter.LastError)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.GreaterOrEqual(t, attempts[block101.CanonicalName+"-suffix"], 2)
+	assert.Equal(t, 1, attempts[block102Final100.CanonicalName+"-suffix"])
+	od.failuresLock.Lock()
+	defer od.failuresLock.Unlock()
+	assert.Empty(t, od.failures)
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mergertest provides utilities to load-test merger deployments and reproduce issues
+package mergertest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+)
+
+// ContentType and ContentVersion are written in the dbin header of the generated one-block files
+var ContentType = "TST"
+var ContentVersion = 1
+
+// LIBDistance is the distance between a generated block and its LIB
+var LIBDistance uint64 = 2
+
+// BlockInterval is the time between two generated blocks, the first block being at StartTime
+var BlockInterval = time.Second
+var StartTime = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Suffix is appended to the generated filenames, as a reader would do
+var Suffix = "mergertest"
+
+// GenerateChain writes `count` one-block files starting at block `start` into `store`, each with a pseudo-random
+// payload of `blockSize` bytes. Every `forkEvery` blocks (0 means never), a forked sibling of the canonical block is
+// also written: it links to the canonical parent but never gets a child, like a fork lost by its producer.
+func GenerateChain(ctx context.Context, store dstore.Store, start, count, forkEvery uint64, blockSize int) error {
+	for num := start; num < start+count; num++ {
+		if err := writeBlock(ctx, store, generateBlock(num, 'a', blockSize)); err != nil {
+			return err
+		}
+
+		if forkEvery != 0 && num != start && num%forkEvery == 0 {
+			if err := writeBlock(ctx, store, generateBlock(num, 'b', blockSize)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BlockID returns the ID of generated block `num`, branch being 'a' for the canonical chain and 'b' for forks
+func BlockID(num uint64, branch byte) string {
+	return fmt.Sprintf("%015x%c", num, branch)
+}
+
+func generateBlock(num uint64, branch byte, blockSize int) *bstream.Block {
+	var previousID string
+	if num > 0 {
+		previousID = BlockID(num-1, 'a')
+	}
+	var libNum uint64
+	if num > LIBDistance {
+		libNum = num - LIBDistance
+	}
+
+	payload := make([]byte, blockSize)
+	rand.New(rand.NewSource(int64(num)<<1 | int64(branch-'a'))).Read(payload)
+
+	block := &bstream.Block{
+		Id:         BlockID(num, branch),
+		Number:     num,
+		PreviousId: previousID,
+		Timestamp:  StartTime.Add(time.Duration(num) * BlockInterval),
+		LibNum:     libNum,
+	}
+	block, _ = bstream.MemoryBlockPayloadSetter(block, payload)
+	return block
+}
+
+func writeBlock(ctx context.Context, store dstore.Store, block *bstream.Block) error {
+	buffer := bytes.NewBuffer(nil)
+	writer, err := bstream.NewDBinBlockWriter(buffer, ContentType, ContentVersion)
+	if err != nil {
+		return err
+	}
+	if err := writer.Write(block); err != nil {
+		return fmt.Errorf("writing block %d: %w", block.Number, err)
+	}
+
+	filename := bstream.BlockFileNameWithSuffix(block, Suffix)
+	if err := store.WriteObject(ctx, filename, buffer); err != nil {
+		return fmt.Errorf("writing one-block file %q: %w", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package mergertest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateChain(t *testing.T) {
+	bstream.GetBlockPayloadSetter = bstream.MemoryBlockPayloadSetter
+
+	store := dstore.NewMockStore(nil)
+	require.NoError(t, GenerateChain(context.Background(), store, 100, 10, 4, 64))
+
+	var files []string
+	require.NoError(t, store.Walk(context.Background(), "", func(filename string) error {
+		files = append(files, filename)
+		return nil
+	}))
+	require.Len(t, files, 12) // 10 blocks + forks at 104 and 108
+	assert.Equal(t, "0000000100-000000000000064a-000000000000063a-98-mergertest", files[0])
+	assert.Contains(t, files, "0000000104-000000000000068b-000000000000067a-102-mergertest")
+
+	reader, err := store.OpenObject(context.Background(), files[0])
+	require.NoError(t, err)
+	blockReader, err := bstream.NewDBinBlockReader(reader, nil)
+	require.NoError(t, err)
+	block, err := blockReader.Read()
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, block.Number)
+	assert.True(t, StartTime.Add(100*BlockInterval).Equal(block.Timestamp))
+
+	payload, err := block.Payload.Get()
+	require.NoError(t, err)
+	assert.Len(t, payload, 64)
+}
@@ -21,3 +21,139 @@ var MetricSet = dmetrics.NewSet()
 var HeadBlockTimeDrift = MetricSet.NewHeadTimeDrift("merger")
 var HeadBlockNumber = MetricSet.NewHeadBlockNumber("merger")
 var AppReadiness = MetricSet.NewAppReadiness("merger")
+
+var ForkDBMemoryBytes = MetricSet.NewGauge("merger_forkdb_memory_bytes", "approximate memory used by the blocks held in the bundler's forkdb")
+var ForkDBPurgedBlocks = MetricSet.NewCounter("merger_forkdb_purged_blocks", "number of forked blocks purged early because the forkdb went above its soft memory limit")
+var ErrorCount = MetricSet.NewCounterVec("merger_errors", []string{"severity"}, "number of errors encountered by the merger, by severity")
+var BundlerDivergences = MetricSet.NewCounter("merger_bundler_divergences", "number of bundles on which the shadow bundler disagreed with the bundler")
+
+var BundlesMerged = MetricSet.NewCounter("merger_bundles_merged", "number of bundles merged by this process")
+var BytesWritten = MetricSet.NewCounter("merger_bytes_written", "number of bytes of merged files written by this process")
+var FilesDeleted = MetricSet.NewCounter("merger_files_deleted", "number of one-block files deleted by this process")
+var BundlesMergedAllTime = MetricSet.NewGauge("merger_bundles_merged_all_time", "number of bundles merged, persisted across restarts in the state file")
+var BytesWrittenAllTime = MetricSet.NewGauge("merger_bytes_written_all_time", "number of bytes of merged files written, persisted across restarts in the state file")
+var FilesDeletedAllTime = MetricSet.NewGauge("merger_files_deleted_all_time", "number of one-block files deleted, persisted across restarts in the state file")
+
+var DeletionRetries = MetricSet.NewCounter("merger_deletion_retries", "number of failed file deletions queued for a retry")
+var DeletionDeadLetters = MetricSet.NewCounter("merger_deletion_dead_letters", "number of files given up on after failing to delete them too many times")
+
+var InterBlockTime = MetricSet.NewHistogram("merger_inter_block_time_seconds", "time between consecutive blocks of the merged bundles")
+var BlockTimeAnomalies = MetricSet.NewCounterVec("merger_block_time_anomalies", []string{"kind"}, "number of gaps and bursts in block times found while merging")
+
+var ReaderHeadBlockNumber = MetricSet.NewGaugeVec("merger_reader_head_block_number", []string{"suffix"}, "highest block number seen in one-block files, by file suffix (reader)")
+var ReaderHeadAge = MetricSet.NewGaugeVec("merger_reader_head_age_seconds", []string{"suffix"}, "time since the merger first saw the highest block of each file suffix (reader)")
+
+// headline metrics, shared with the other streamingfast apps dashboards
+var LastMergedBlockNumber = MetricSet.NewGauge("merger_last_merged_block_num", "highest block number written in a merged file")
+var LastMergedBlockTime = MetricSet.NewGauge("merger_last_merged_block_time", "timestamp (unix seconds) of the highest block written in a merged file")
+var StartTime = MetricSet.NewGauge("merger_start_time", "timestamp (unix seconds) when the merger started, for uptime")
+
+var BundleETA = MetricSet.NewGauge("merger_bundle_eta_seconds", "estimated time until the current bundle can be merged, -1 if unknown")
+var CatchUpETA = MetricSet.NewGauge("merger_catch_up_eta_seconds", "estimated time until the merger catches up with the head, -1 if unknown")
+
+var ChainIdle = MetricSet.NewGauge("merger_chain_idle", "1 when the readers agree on a head that stopped moving (chain halted), staleness alerts should be silenced")
+var ReadersStalled = MetricSet.NewGauge("merger_readers_stalled", "1 when the head stopped moving and the readers disagree on it")
+
+var BelowLowestBlockFiles = MetricSet.NewCounterVec("merger_below_lowest_block_files", []string{"action"}, "number of one-block files found below the first streamable block, by action (ignore, delete, archive)")
+var WalkBudgetExceeded = MetricSet.NewCounter("merger_walk_budget_exceeded", "number of walks stopped early because they went over their processing-time budget")
+var TransformedBlocks = MetricSet.NewCounter("merger_transformed_blocks", "number of one-block files transcoded because their codec differed from the first block of their bundle")
+var DeletionsAwaitingConfirmation = MetricSet.NewGauge("merger_deletions_awaiting_confirmation", "number of one-block files held by the delete safety valve until the operator confirms their deletion")
+
+var OneBlockArrivalRate = MetricSet.NewGauge("merger_one_block_arrival_rate", "number of new one-block files per second found on the last cycle")
+var OneBlockArrivalBaseline = MetricSet.NewGauge("merger_one_block_arrival_baseline", "usual number of new one-block files per second")
+var ArrivalAnomalies = MetricSet.NewCounterVec("merger_arrival_anomalies", []string{"kind"}, "number of one-block files arrival anomalies, by kind (drop, flood)")
+
+var MissingMergedBundles = MetricSet.NewGauge("merger_missing_merged_bundles", "number of bundles of the coverage manifest missing from the merged files on the last check")
+var CoverageRegressions = MetricSet.NewCounter("merger_coverage_regressions", "number of gaps that appeared in the merged files covering the coverage manifest")
+
+var PressureDeferrals = MetricSet.NewCounterVec("merger_pressure_deferrals", []string{"phase"}, "number of times work was deferred because the co-located serving stack was under pressure, by phase")
+
+var ValidationFailures = MetricSet.NewCounterVec("merger_validation_failures", []string{"check", "policy"}, "number of bundle validation failures, by check and policy (warn or enforce)")
+
+var MergeVerificationFailures = MetricSet.NewCounter("merger_merge_verification_failures", "number of merged files that did not hold their merged blocks when read back after upload")
+
+var ReplicatedBundles = MetricSet.NewCounterVec("merger_replicated_bundles", []string{"replica"}, "number of merged files copied to each replica of the merged blocks store")
+var ReplicationFailures = MetricSet.NewCounterVec("merger_replication_failures", []string{"replica"}, "number of failed copies of merged files to each replica, they are retried")
+var ReplicationPending = MetricSet.NewGaugeVec("merger_replication_pending", []string{"replica"}, "number of merged files waiting to be copied to each replica")
+
+var OneBlockNotifications = MetricSet.NewCounter("merger_one_block_notifications", "number of one-block files notifications received")
+var MissedOneBlockNotifications = MetricSet.NewCounter("merger_missed_one_block_notifications", "number of new one-block files found by the reconciliation walks, that were not notified")
+
+var BundleBlockSize = MetricSet.NewGaugeVec("merger_bundle_block_size_bytes", []string{"stat"}, "size of the blocks of the last merged bundle, by stat (min, max, avg)")
+var BundleCompressionRatio = MetricSet.NewGauge("merger_bundle_compression_ratio", "size of the last merged file divided by the size of its blocks")
+var BundleTransactions = MetricSet.NewGauge("merger_bundle_transactions", "number of transactions in the last merged bundle, when the chain registered a transaction counter")
+var MergedTransactions = MetricSet.NewCounter("merger_merged_transactions", "number of transactions merged, when the chain registered a transaction counter")
+
+var PrunedMergedBundles = MetricSet.NewCounter("merger_pruned_merged_bundles", "number of merged bundles deleted by the merged blocks retention")
+
+var BuildInfo = MetricSet.NewGaugeVec("merger_build_info", []string{"instance_id", "hostname", "version", "commit", "config_hash"}, "always 1, labelled with the identity, build and configuration hash of the merger instance")
+
+var StoreRetries = MetricSet.NewCounterVec("merger_store_retries", []string{"operation"}, "number of store operations retried by the retry policy, by operation (download, upload, list)")
+var StoreCircuitOpen = MetricSet.NewGaugeVec("merger_store_circuit_open", []string{"store"}, "1 while the circuit breaker of the store retry policy is open, by store (source, destination)")
+
+var BulkDeletedFiles = MetricSet.NewCounter("merger_bulk_deleted_files", "number of one-block files deleted by bulk delete requests")
+
+var DedupedPayloads = MetricSet.NewCounter("merger_deduped_payloads", "number of one-block file copies not downloaded because their content hash matched a copy already downloaded")
+
+var BlockHole = MetricSet.NewGauge("merger_block_hole", "1 while a hole in the chain holds the bundler, starting at merger_block_hole_low_block")
+var BlockHoleLowBlock = MetricSet.NewGauge("merger_block_hole_low_block", "first missing block of the last hole detected in the chain")
+var BlockHoles = MetricSet.NewCounter("merger_block_holes", "number of holes detected in the chain being bundled")
+var SkippedBlockHoles = MetricSet.NewCounter("merger_skipped_block_holes", "number of holes in the chain skipped by the bundler")
+
+var MergeBatchSize = MetricSet.NewGauge("merger_merge_batch_size", "number of complete bundles merged in the last batch, see the merge batching")
+
+var MergeDuration = MetricSet.NewHistogram("merger_merge_duration_seconds", "time taken to download, merge and upload each merged file")
+var BundleBytes = MetricSet.NewGauge("merger_bundle_bytes", "number of bytes of blocks in the last merged file, before compression")
+var WalkDuration = MetricSet.NewHistogram("merger_walk_duration_seconds", "time taken by each walk of the one-block files, including handling them in the bundler")
+var BundlerOneBlockFiles = MetricSet.NewGauge("merger_bundler_one_block_files", "number of one-block files seen by the bundler and waiting for their bundle to be merged")
+var DeletionQueueDepth = MetricSet.NewGaugeVec("merger_deletion_queue_depth", []string{"store"}, "number of files queued for deletion, by store (one_block, forked)")
+var DownloadRetries = MetricSet.NewCounter("merger_download_retries", "number of one-block file downloads retried, from another copy of the file or by the store retry policy")
+
+var ObservedBundles = MetricSet.NewCounter("merger_observed_bundles", "number of bundles compared by the observer with the merged file of the active merger")
+var ObservedDivergences = MetricSet.NewCounterVec("merger_observed_divergences", []string{"kind"}, "number of bundles on which the merged file of the active merger diverged from the observer, by kind (missing, blocks)")
+
+var PoisonRanges = MetricSet.NewGauge("merger_poison_ranges", "number of bundles isolated as poison ranges after repeated data errors, skipped until retried")
+
+var DownloadQueueWait = MetricSet.NewHistogram("merger_download_queue_wait_seconds", "time spent by each download waiting for a slot of the shared download concurrency limit")
+var DownloadsInFlight = MetricSet.NewGauge("merger_downloads_in_flight", "number of downloads from the stores holding a slot of the shared download concurrency limit")
+
+var ChainMergedBundles = MetricSet.NewCounterVec("merger_chain_merged_bundles", []string{"chain"}, "number of bundles merged by each chain of a multi-chain merger")
+var ChainLastMergedBlockNumber = MetricSet.NewGaugeVec("merger_chain_last_merged_block_number", []string{"chain"}, "number of the last merged block of each chain of a multi-chain merger")
+var ChainHeadDrift = MetricSet.NewGaugeVec("merger_chain_head_drift_seconds", []string{"chain"}, "time between the last merge and the block time of the last merged block of each chain of a multi-chain merger")
+var ChainHeadBlockNumber = MetricSet.NewGaugeVec("merger_chain_head_block_number", []string{"chain"}, "highest block number seen in the one-block files of each chain of a multi-chain merger")
+var ChainLagBlocks = MetricSet.NewGaugeVec("merger_chain_lag_blocks", []string{"chain"}, "number of blocks between the head and the bundle being collected of each chain of a multi-chain merger")
+var ChainCatchUpETA = MetricSet.NewGaugeVec("merger_chain_catch_up_eta_seconds", []string{"chain"}, "estimated time until each chain of a multi-chain merger catches up with its head, -1 if unknown")
+var ChainReady = MetricSet.NewGaugeVec("merger_chain_ready", []string{"chain"}, "1 while each chain of a multi-chain merger is serving, see its health check")
+var ChainDegraded = MetricSet.NewGaugeVec("merger_chain_degraded", []string{"chain"}, "1 while each chain of a multi-chain merger is degraded, its merged blocks store being unavailable")
+var ChainDownloadDeadLetters = MetricSet.NewGaugeVec("merger_chain_download_dead_letters", []string{"chain"}, "number of one-block files given up on after failing to download them, listed by each chain of a multi-chain merger")
+var ChainMergePipelineQueued = MetricSet.NewGaugeVec("merger_chain_merge_pipeline_queued", []string{"chain"}, "number of closed bundles merging or waiting for their merge of each chain of a multi-chain merger")
+var ChainPoisonRanges = MetricSet.NewGaugeVec("merger_chain_poison_ranges", []string{"chain"}, "number of bundles isolated as poison ranges of each chain of a multi-chain merger")
+var ChainBlockHole = MetricSet.NewGaugeVec("merger_chain_block_hole", []string{"chain"}, "1 while a hole holds the bundler of each chain of a multi-chain merger")
+
+var OneBlockCacheHits = MetricSet.NewCounter("merger_one_block_cache_hits", "number of one-block files served from the one-block data cache instead of the one-block store")
+var OneBlockCacheMisses = MetricSet.NewCounter("merger_one_block_cache_misses", "number of one-block files not found in the one-block data cache")
+var OneBlockCacheBytes = MetricSet.NewGauge("merger_one_block_cache_bytes", "size of the one-block file data held by the one-block data cache")
+
+var ExistingBundles = MetricSet.NewCounterVec("merger_existing_bundles", []string{"action"}, "number of bundles whose merged file already existed before writing it, by action (skip, fail)")
+
+var WalkSkippedFiles = MetricSet.NewCounter("merger_walk_skipped_files", "number of one-block files listed again by a walk while already held by the bundler, skipped")
+
+var DuplicateOneBlockFiles = MetricSet.NewCounter("merger_duplicate_one_block_files", "number of one-block files found for a block already held by the bundler under another filename, another producer copy")
+
+var RewrittenBlocks = MetricSet.NewCounter("merger_rewritten_blocks", "number of blocks rewritten by the block rewriter before being written in their bundle")
+
+var BundleIndexErrors = MetricSet.NewCounter("merger_bundle_index_errors", "number of bundle indexes that could not be written")
+
+var AutoFlushedBundles = MetricSet.NewCounter("merger_auto_flushed_bundles", "number of partial bundles flushed because the current bundle stayed open longer than the max bundle open duration")
+
+var PollingInterval = MetricSet.NewGauge("merger_polling_interval_seconds", "interval before the next walk of the one-block files chosen by adaptive polling")
+
+var OneBlockChecksums = MetricSet.NewCounterVec("merger_one_block_checksums", []string{"result"}, "number of downloaded one-block files verified against their checksum, by result (valid, mismatch, missing)")
+
+var MergePipelineQueued = MetricSet.NewGauge("merger_merge_pipeline_queued", "number of closed bundles merging or waiting for their merge, see the merge pipeline")
+
+var KeptForkedBlocks = MetricSet.NewGauge("merger_kept_forked_blocks", "number of old blocks that are not irreversible kept by the bundler as they may belong to a late-arriving canonical chain, see the max forked block age")
+
+var CatchUpParallelMerges = MetricSet.NewGauge("merger_catch_up_parallel_merges", "number of bundles being merged at the same time by the catch-up merges, 0 when merging one bundle at a time")
+
+var DownloadDeadLetters = MetricSet.NewCounter("merger_download_dead_letters", "number of one-block files given up on after failing to download them too many times")
@@ -0,0 +1,212 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	dgrpcserver "github.com/streamingfast/dgrpc/server"
+	dgrpcfactory "github.com/streamingfast/dgrpc/server/factory"
+	"github.com/streamingfast/shutter"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// MultiMerger runs the independent mergers of several chains in one process, each with its own bundler and IO. They
+// are created without gRPC listen address and share the gRPC health server of the MultiMerger; the deletions can be
+// shared too, see DeleterPool. The state of the chains (head, lag, readiness, degraded, dead letters, merge pipeline,
+// poison ranges, holes) is tracked by the `merger_chain_*` metrics, labelled by chain name, sampled every
+// DefaultChainMetricsInterval. The other metrics are not labelled and add up or mix the chains.
+//
+// A chain shutting down shuts the others down, as a merger shutting down stops its deployment.
+type MultiMerger struct {
+	*shutter.Shutter
+	grpcListenAddr    string
+	grpcServerOptions []dgrpcserver.Option
+	logger            *zap.Logger
+
+	names   []string // sorted
+	mergers map[string]*Merger
+}
+
+// NewMultiMerger runs the mergers of `chains`, by chain name, none of them must be running. The health of the chains
+// is served on `grpcListenAddr`, see Check.
+func NewMultiMerger(logger *zap.Logger, grpcListenAddr string, chains map[string]*Merger, grpcServerOptions ...dgrpcserver.Option) *MultiMerger {
+	mm := &MultiMerger{
+		Shutter:           shutter.New(),
+		grpcListenAddr:    grpcListenAddr,
+		grpcServerOptions: grpcServerOptions,
+		logger:            logger,
+		mergers:           chains,
+	}
+	for name, m := range chains {
+		mm.names = append(mm.names, name)
+		name, m := name, m
+		m.OnBundleMerged(func(lowBlockNum uint64, oneBlockFiles []*bstream.OneBlockFile) {
+			setChainMetrics(name, m, oneBlockFiles)
+		})
+	}
+	sort.Strings(mm.names)
+	return mm
+}
+
+func setChainMetrics(chain string, m *Merger, oneBlockFiles []*bstream.OneBlockFile) {
+	metrics.ChainMergedBundles.Inc(chain)
+	if len(oneBlockFiles) != 0 {
+		metrics.ChainLastMergedBlockNumber.SetUint64(oneBlockFiles[len(oneBlockFiles)-1].Num, chain)
+	}
+	if drift, ok := m.HeadDrift(); ok {
+		metrics.ChainHeadDrift.SetFloat64(drift.Seconds(), chain)
+	}
+}
+
+// DefaultChainMetricsInterval is how often the MultiMerger samples the state of the chains into the metrics
+var DefaultChainMetricsInterval = 5 * time.Second
+
+// sampleChainMetrics sets the `merger_chain_*` metrics of the state of `m`
+func sampleChainMetrics(chain string, m *Merger) {
+	if eta := m.ETA(); eta != nil {
+		metrics.ChainHeadBlockNumber.SetUint64(eta.HeadBlockNum, chain)
+		var lag uint64
+		if eta.HeadBlockNum > eta.BaseBlockNum {
+			lag = eta.HeadBlockNum - eta.BaseBlockNum
+		}
+		metrics.ChainLagBlocks.SetUint64(lag, chain)
+		metrics.ChainCatchUpETA.SetFloat64(eta.CatchUp.Seconds(), chain)
+	}
+	if drift, ok := m.HeadDrift(); ok {
+		metrics.ChainHeadDrift.SetFloat64(drift.Seconds(), chain)
+	}
+	metrics.ChainReady.SetFloat64(boolToFloat(m.healthStatus() == pbhealth.HealthCheckResponse_SERVING), chain)
+	metrics.ChainDegraded.SetFloat64(boolToFloat(m.DegradedReason() != ""), chain)
+	if downloader, ok := m.io.(interface{ DownloadDeadLetters() []*DownloadFailure }); ok {
+		metrics.ChainDownloadDeadLetters.SetInt(len(downloader.DownloadDeadLetters()), chain)
+	}
+	metrics.ChainMergePipelineQueued.SetInt(m.bundler.pipelineQueued(), chain)
+	metrics.ChainPoisonRanges.SetInt(len(m.PoisonRanges()), chain)
+	metrics.ChainBlockHole.SetFloat64(boolToFloat(m.BlockHole() != nil), chain)
+}
+
+// Chains returns the names of the chains, sorted
+func (mm *MultiMerger) Chains() []string {
+	return append([]string(nil), mm.names...)
+}
+
+// Merger returns the merger of `chain`, nil if unknown
+func (mm *MultiMerger) Merger(chain string) *Merger {
+	return mm.mergers[chain]
+}
+
+// Run starts the shared gRPC server and runs every chain until one of them, or the MultiMerger, is shut down
+func (mm *MultiMerger) Run() {
+	mm.logger.Info("starting multi-chain merger", zap.Strings("chains", mm.names))
+	mm.startGRPCServer()
+	for _, name := range mm.names {
+		m, name := mm.mergers[name], name
+		mm.OnTerminating(m.Shutdown)
+		m.OnTerminated(func(err error) {
+			if !mm.IsTerminating() {
+				mm.logger.Info("chain merger terminated, shutting down the other chains", zap.String("chain", name), zap.Error(err))
+			}
+			mm.Shutdown(err)
+		})
+		go m.Run()
+	}
+	go mm.sampleChainMetrics()
+}
+
+func (mm *MultiMerger) sampleChainMetrics() {
+	ticker := time.NewTicker(DefaultChainMetricsInterval)
+	defer ticker.Stop()
+	for {
+		for _, name := range mm.names {
+			sampleChainMetrics(name, mm.mergers[name])
+		}
+		select {
+		case <-mm.Terminating():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (mm *MultiMerger) startGRPCServer() {
+	if mm.grpcListenAddr == "" {
+		return
+	}
+	gs := dgrpcfactory.ServerFromOptions(mm.grpcServerOptions...)
+	gs.OnTerminated(mm.Shutdown)
+	mm.OnTerminated(func(_ error) {
+		gs.Shutdown(0)
+	})
+	pbhealth.RegisterHealthServer(gs.ServiceRegistrar(), mm)
+	go gs.Launch(mm.grpcListenAddr)
+}
+
+// Check is the GRPC Healthcheck of the chains: the service of the request is a chain name, whose merger is checked
+// (see Merger.Check), or empty, SERVING only if every chain is SERVING
+func (mm *MultiMerger) Check(ctx context.Context, in *pbhealth.HealthCheckRequest) (*pbhealth.HealthCheckResponse, error) {
+	serving, err := mm.healthStatus(in.Service)
+	if err != nil {
+		return nil, err
+	}
+	return &pbhealth.HealthCheckResponse{Status: serving}, nil
+}
+
+// Watch is basic GRPC Healthcheck as a stream, see Check
+func (mm *MultiMerger) Watch(req *pbhealth.HealthCheckRequest, stream pbhealth.Health_WatchServer) error {
+	serving, err := mm.healthStatus(req.Service)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&pbhealth.HealthCheckResponse{Status: serving}); err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func (mm *MultiMerger) healthStatus(chain string) (pbhealth.HealthCheckResponse_ServingStatus, error) {
+	if chain != "" {
+		m, ok := mm.mergers[chain]
+		if !ok {
+			return pbhealth.HealthCheckResponse_SERVICE_UNKNOWN, status.Error(codes.NotFound, fmt.Sprintf("unknown chain %q", chain))
+		}
+		return m.healthStatus(), nil
+	}
+	for _, name := range mm.names {
+		if mm.mergers[name].healthStatus() != pbhealth.HealthCheckResponse_SERVING {
+			return pbhealth.HealthCheckResponse_NOT_SERVING, nil
+		}
+	}
+	return pbhealth.HealthCheckResponse_SERVING, nil
+}
+
+// IsLive returns false once the main loop of any chain stopped making progress, see Merger.IsLive
+func (mm *MultiMerger) IsLive() bool {
+	for _, name := range mm.names {
+		if !mm.mergers[name].IsLive() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package merger
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestMultiMerger_Check(t *testing.T) {
+	ctx := context.Background()
+	eth := NewMerger(testLogger, "", &TestMergerIO{}, 1, 100, 100, time.Second, time.Second, 0)
+	bsc := NewMerger(testLogger, "", &TestMergerIO{}, 1, 100, 100, time.Second, time.Second, 0)
+	mm := NewMultiMerger(testLogger, "", map[string]*Merger{"eth": eth, "bsc": bsc})
+	assert.Equal(t, []string{"bsc", "eth"}, mm.Chains())
+	assert.Equal(t, eth, mm.Merger("eth"))
+
+	resp, err := mm.Check(ctx, &pbhealth.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, pbhealth.HealthCheckResponse_SERVING, resp.Status)
+
+	bsc.setDegraded("testing")
+	resp, err = mm.Check(ctx, &pbhealth.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, resp.Status, "a chain is not serving")
+
+	resp, err = mm.Check(ctx, &pbhealth.HealthCheckRequest{Service: "eth"})
+	require.NoError(t, err)
+	assert.Equal(t, pbhealth.HealthCheckResponse_SERVING, resp.Status)
+
+	_, err = mm.Check(ctx, &pbhealth.HealthCheckRequest{Service: "sol"})
+	assert.Error(t, err)
+}
+
+func TestMultiMerger_ChainMetrics(t *testing.T) {
+	eth := NewMerger(testLogger, "", &TestMergerIO{}, 1, 100, 100, time.Second, time.Second, 0)
+	bsc := NewMerger(testLogger, "", &TestMergerIO{}, 1, 100, 100, time.Second, time.Second, 0)
+	eth.progress.observeBlock(bstream.MustNewOneBlockFile("0000000250-0000000000000250a-0000000000000249a-248-suffix"))
+	eth.progress.sample(time.Now(), 100)
+	bsc.setDegraded("testing")
+
+	sampleChainMetrics("eth", eth)
+	sampleChainMetrics("bsc", bsc)
+	assert.Equal(t, float64(250), testutil.ToFloat64(metrics.ChainHeadBlockNumber.Native().WithLabelValues("eth")))
+	assert.Equal(t, float64(150), testutil.ToFloat64(metrics.ChainLagBlocks.Native().WithLabelValues("eth")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ChainReady.Native().WithLabelValues("eth")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ChainDegraded.Native().WithLabelValues("eth")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ChainReady.Native().WithLabelValues("bsc")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ChainDegraded.Native().WithLabelValues("bsc")))
+}
+
+func TestDeleterPool_SharedWorkers(t *testing.T) {
+	var running, maxRunning, deleted int32
+	newStore := func() *dstore.MockStore {
+		store := dstore.NewMockStore(nil)
+		store.DeleteObjectFunc = func(_ context.Context, _ string) error {
+			if current := atomic.AddInt32(&running, 1); current > atomic.LoadInt32(&maxRunning) {
+				atomic.StoreInt32(&maxRunning, current)
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&deleted, 1)
+			return nil
+		}
+		return store
+	}
+
+	pool := NewDeleterPool(1)
+	for i := 0; i < 2; i++ {
+		od := &oneBlockFilesDeleter{store: newStore(), logger: testLogger, pool: pool}
+		od.Start(4, 10)
+		require.NoError(t, od.Delete([]*bstream.OneBlockFile{block100, block101, block102Final100}))
+	}
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&deleted) == 6 }, time.Second, 5*time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&maxRunning), "both stores share the single worker of the pool")
+}
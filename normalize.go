@@ -0,0 +1,178 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+var DefaultNormalizedSuffix = "normalized"
+
+type OneBlockFileRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Duplicate is true when a file with the canonical name already exists, the non-conforming one is then only deleted
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// NormalizationReport lists what NormalizeOneBlockFiles did, or would do in dry-run mode
+type NormalizationReport struct {
+	DryRun      bool                  `json:"dry_run"`
+	Conforming  int                   `json:"conforming"`
+	Renames     []*OneBlockFileRename `json:"renames"`
+	Unparseable []string              `json:"unparseable"`
+	Errors      []string              `json:"errors"`
+}
+
+type NormalizeOptions struct {
+	DryRun bool
+	// Precision of the timestamp in normalized names, block data is read when the original name has no timestamp
+	Precision TimestampPrecision
+	// Suffix given to files that have none (DefaultNormalizedSuffix if empty)
+	Suffix string
+}
+
+// NormalizeOneBlockFiles renames the one-block files that the merger cannot consume as-is (block number
+// without 10 digits padding, timestamp of another precision, missing suffix) to their canonical name.
+// Files that cannot be understood at all are only reported.
+func NormalizeOneBlockFiles(ctx context.Context, logger *zap.Logger, store dstore.Store, opts NormalizeOptions) (*NormalizationReport, error) {
+	if opts.Suffix == "" {
+		opts.Suffix = DefaultNormalizedSuffix
+	}
+	report := &NormalizationReport{DryRun: opts.DryRun, Renames: []*OneBlockFileRename{}, Unparseable: []string{}, Errors: []string{}}
+
+	var filenames []string
+	if err := store.Walk(ctx, "", func(filename string) error {
+		filenames = append(filenames, filename)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("walking one-block files: %w", err)
+	}
+
+	for _, filename := range filenames {
+		canonical, err := normalizedFilename(ctx, store, filename, opts)
+		if err != nil {
+			report.Unparseable = append(report.Unparseable, filename)
+			logger.Debug("cannot normalize one-block filename", zap.String("filename", filename), zap.Error(err))
+			continue
+		}
+		if canonical == filename {
+			report.Conforming++
+			continue
+		}
+
+		rename := &OneBlockFileRename{From: filename, To: canonical}
+		exists, err := store.FileExists(ctx, canonical)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("checking %q: %s", canonical, err))
+			continue
+		}
+		rename.Duplicate = exists
+		report.Renames = append(report.Renames, rename)
+		if opts.DryRun {
+			continue
+		}
+
+		if !exists {
+			if err := store.CopyObject(ctx, filename, canonical); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("copying %q to %q: %s", filename, canonical, err))
+				continue
+			}
+		}
+		if err := store.DeleteObject(ctx, filename); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("deleting %q: %s", filename, err))
+		}
+	}
+
+	logger.Info("one-block files normalization done",
+		zap.Bool("dry_run", opts.DryRun),
+		zap.Int("conforming", report.Conforming),
+		zap.Int("renames", len(report.Renames)),
+		zap.Int("unparseable", len(report.Unparseable)),
+		zap.Int("errors", len(report.Errors)),
+	)
+	return report, nil
+}
+
+// normalizedFilename leniently parses `<num>[-<timestamp>]-<id>-<previous_id>-<lib_num>[-<suffix>]`
+func normalizedFilename(ctx context.Context, store dstore.Store, filename string, opts NormalizeOptions) (string, error) {
+	parts := strings.Split(filename, "-")
+	blockNum, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid block number: %w", err)
+	}
+	parts = parts[1:]
+
+	var blockTime time.Time
+	hasTime := false
+	if len(parts) > 0 {
+		if blockTime, hasTime = parseFilenameTimestamp(parts[0]); hasTime {
+			parts = parts[1:]
+		}
+	}
+
+	suffix := opts.Suffix
+	switch len(parts) {
+	case 3:
+	case 4:
+		suffix = parts[3]
+	default:
+		return "", fmt.Errorf("wrong filename format")
+	}
+	if parts[0] == "" || parts[1] == "" || suffix == "" {
+		return "", fmt.Errorf("wrong filename format")
+	}
+	libNum, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid lib number: %w", err)
+	}
+
+	if opts.Precision != TimestampPrecisionNone && !hasTime {
+		if blockTime, err = readOneBlockFileTime(ctx, store, filename); err != nil {
+			return "", err
+		}
+	}
+
+	obf := &bstream.OneBlockFile{
+		CanonicalName: fmt.Sprintf("%010d-%s-%s-%d", blockNum, parts[0], parts[1], libNum),
+		Num:           blockNum,
+		ID:            parts[0],
+		PreviousID:    parts[1],
+		LibNum:        libNum,
+	}
+	return oneBlockFilename(obf, blockTime, suffix, opts.Precision), nil
+}
+
+func readOneBlockFileTime(ctx context.Context, store dstore.Store, filename string) (time.Time, error) {
+	reader, err := store.OpenObject(ctx, filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return readBlockTime(data)
+}
@@ -0,0 +1,68 @@
+package merger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeOneBlockFiles(t *testing.T) {
+	newStore := func() *dstore.MockStore {
+		store := dstore.NewMockStore(nil)
+		store.SetFile("0000000100-0000000000000100a-0000000000000099a-98-suffix", []byte("100"))
+		store.SetFile("101-0000000000000101a-0000000000000100a-99-suffix", []byte("101"))
+		store.SetFile("0000000102-20170701T122141.5-0000000000000102a-0000000000000101a-100-suffix", []byte("102"))
+		store.SetFile("0000000103-0000000000000103a-0000000000000102a-101", []byte("103"))
+		store.SetFile("0000000104-0000000000000104a-0000000000000103a-102-suffix", []byte("104"))
+		store.SetFile("00000000104-0000000000000104a-0000000000000103a-102-suffix", []byte("104"))
+		store.SetFile("garbage", []byte("?"))
+		return store
+	}
+
+	expectRenames := []*OneBlockFileRename{
+		{From: "00000000104-0000000000000104a-0000000000000103a-102-suffix", To: "0000000104-0000000000000104a-0000000000000103a-102-suffix", Duplicate: true},
+		{From: "0000000102-20170701T122141.5-0000000000000102a-0000000000000101a-100-suffix", To: "0000000102-0000000000000102a-0000000000000101a-100-suffix"},
+		{From: "0000000103-0000000000000103a-0000000000000102a-101", To: "0000000103-0000000000000103a-0000000000000102a-101-fixed"},
+		{From: "101-0000000000000101a-0000000000000100a-99-suffix", To: "0000000101-0000000000000101a-0000000000000100a-99-suffix"},
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		store := newStore()
+		report, err := NormalizeOneBlockFiles(context.Background(), testLogger, store, NormalizeOptions{DryRun: true, Suffix: "fixed"})
+		require.NoError(t, err)
+		assert.True(t, report.DryRun)
+		assert.Equal(t, 2, report.Conforming)
+		assert.Equal(t, expectRenames, report.Renames)
+		assert.Equal(t, []string{"garbage"}, report.Unparseable)
+		assert.Empty(t, report.Errors)
+
+		exists, err := store.FileExists(context.Background(), "101-0000000000000101a-0000000000000100a-99-suffix")
+		require.NoError(t, err)
+		assert.True(t, exists, "dry run must not touch files")
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		store := newStore()
+		report, err := NormalizeOneBlockFiles(context.Background(), testLogger, store, NormalizeOptions{Suffix: "fixed"})
+		require.NoError(t, err)
+		assert.Equal(t, expectRenames, report.Renames)
+		assert.Empty(t, report.Errors)
+
+		var files []string
+		require.NoError(t, store.Walk(context.Background(), "", func(filename string) error {
+			files = append(files, filename)
+			return nil
+		}))
+		assert.Equal(t, []string{
+			"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+			"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+			"0000000102-0000000000000102a-0000000000000101a-100-suffix",
+			"0000000103-0000000000000103a-0000000000000102a-101-fixed",
+			"0000000104-0000000000000104a-0000000000000103a-102-suffix",
+			"garbage",
+		}, files)
+	})
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// OneBlockNotifier pushes the names of the one-block files as they are written to the one-block store,
+// from bucket notifications for example (see the notifier package)
+type OneBlockNotifier interface {
+	// Run calls notify with the name of each new one-block file, relative to the one-block store, until ctx is done
+	Run(ctx context.Context, notify func(filename string)) error
+}
+
+// NotificationsQueueSize is how many notified one-block files can wait for the next cycle. When the queue is full,
+// notifications are dropped and the next cycle walks the one-block files instead.
+var NotificationsQueueSize = 10000
+
+// WithOneBlockNotifier makes the merger handle the one-block files pushed by the notifier as soon as they are written,
+// instead of finding them by walking the one-block store. The walk only runs every `reconciliationInterval`, to pick up
+// the files whose notification was lost, and on every cycle if the notifier stops.
+func WithOneBlockNotifier(notifier OneBlockNotifier, reconciliationInterval time.Duration) Option {
+	return func(m *Merger) {
+		m.notifications = &notifications{
+			notifier:               notifier,
+			reconciliationInterval: reconciliationInterval,
+			queue:                  make(chan string, NotificationsQueueSize),
+		}
+	}
+}
+
+type notifications struct {
+	notifier               OneBlockNotifier
+	reconciliationInterval time.Duration
+	queue                  chan string
+
+	overflowed uint32 // atomic, notifications were dropped
+	stopped    uint32 // atomic, the notifier returned
+
+	lastWalk time.Time // only used by the main loop
+	// reconciling is set when the cycle walks only because the reconciliation interval elapsed, the new files it
+	// finds were then missed by the notifier (only used by the main loop)
+	reconciling bool
+}
+
+func (m *Merger) startNotifier() {
+	n := m.notifications
+	if n == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.OnTerminating(func(_ error) { cancel() })
+
+	m.logger.Info("receiving one-block files notifications", zap.Duration("reconciliation_interval", n.reconciliationInterval))
+	go func() {
+		err := n.notifier.Run(ctx, m.notify)
+		if ctx.Err() != nil {
+			return
+		}
+		m.logger.Warn("one-block files notifier stopped, walking the one-block files on every cycle", zap.Error(err))
+		atomic.StoreUint32(&m.notifications.stopped, 1)
+		m.Trigger()
+	}()
+}
+
+func (m *Merger) notify(filename string) {
+	metrics.OneBlockNotifications.Inc()
+	select {
+	case m.notifications.queue <- filename:
+	default:
+		if atomic.CompareAndSwapUint32(&m.notifications.overflowed, 0, 1) {
+			m.logger.Warn("one-block files notifications queue is full, walking the one-block files on next cycle")
+		}
+	}
+	m.Trigger()
+}
+
+// walkDue tells if the cycle must walk the one-block files, instead of only handling the notified ones
+func (m *Merger) walkDue(now time.Time) bool {
+	n := m.notifications
+	if n == nil {
+		return true
+	}
+	n.reconciling = false
+	if atomic.LoadUint32(&n.stopped) == 1 ||
+		atomic.CompareAndSwapUint32(&n.overflowed, 1, 0) {
+		n.lastWalk = now
+		return true
+	}
+	if now.Sub(n.lastWalk) >= n.reconciliationInterval {
+		n.reconciling = !n.lastWalk.IsZero()
+		n.lastWalk = now
+		return true
+	}
+	return false
+}
+
+// observeReconciliation counts the new one-block files found by a reconciliation walk, that the notifier missed
+func (n *notifications) observeReconciliation(newFiles int, logger *zap.Logger) {
+	if !n.reconciling || newFiles == 0 {
+		return
+	}
+	metrics.MissedOneBlockNotifications.AddInt(newFiles)
+	logger.Info("reconciliation walk found one-block files that were not notified", zap.Int("new_files", newFiles))
+}
+
+// handleNotifiedOneBlockFiles calls the callback on the notified one-block files, in block order
+func (m *Merger) handleNotifiedOneBlockFiles(ctx context.Context, callback func(*bstream.OneBlockFile) error) error {
+	var files []*bstream.OneBlockFile
+	for len(files) < NotificationsQueueSize {
+		var filename string
+		select {
+		case filename = <-m.notifications.queue:
+		default:
+		}
+		if filename == "" {
+			break
+		}
+		if strings.HasSuffix(filename, ".tmp") {
+			continue
+		}
+		obf, err := fastNewOneBlockFile(filename)
+		if err != nil {
+			m.componentLoggers.get(LogComponentWalk, m.logger).Debug("ignoring notified file that is not a one-block file", zap.String("filename", filename), zap.Error(err))
+			continue
+		}
+		files = append(files, obf)
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Num < files[j].Num })
+
+	for _, obf := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := callback(obf); err != nil {
+			if err == errWalkBudgetExceeded {
+				atomic.StoreUint32(&m.notifications.overflowed, 1) // the files left are picked up by the walk
+			}
+			return err
+		}
+	}
+	return nil
+}
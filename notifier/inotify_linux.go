@@ -0,0 +1,106 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"go.uber.org/zap"
+)
+
+// Inotify watches the directory of a local one-block store with inotify. The one-block files are notified once
+// renamed into the directory (dstore writes a `.tmp` file first) or closed after being written in place.
+type Inotify struct {
+	dir    string
+	keys   Keys
+	logger *zap.Logger
+}
+
+// NewInotify watches `dir`, `keys.Prefix` is ignored: the filenames are relative to `dir`
+func NewInotify(logger *zap.Logger, dir string, keys Keys) (*Inotify, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("watching %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("watching %s: not a directory", dir)
+	}
+	return &Inotify{
+		dir:    dir,
+		keys:   Keys{Extension: keys.Extension},
+		logger: logger,
+	}, nil
+}
+
+// Run implements merger.OneBlockNotifier
+func (n *Inotify) Run(ctx context.Context, notify func(filename string)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("initializing inotify: %w", err)
+	}
+	// non-blocking, the file goes through the runtime poller and closing it unblocks Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	defer file.Close()
+
+	if _, err := syscall.InotifyAddWatch(fd, n.dir, syscall.IN_MOVED_TO|syscall.IN_CLOSE_WRITE); err != nil {
+		return fmt.Errorf("watching %s: %w", n.dir, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		read, err := file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("reading inotify events of %s: %w", n.dir, err)
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= read; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				n.logger.Warn("inotify queue overflow, missed one-block files are left to the reconciliation walk", zap.String("dir", n.dir))
+				continue
+			}
+			if event.Mask&syscall.IN_ISDIR != 0 || event.Len == 0 || offset > read {
+				continue
+			}
+			name := string(bytes.TrimRight(buf[nameStart:offset], "\x00"))
+			if strings.HasSuffix(name, ".tmp") {
+				continue
+			}
+			if filename, ok := n.keys.filename(name); ok {
+				notify(filename)
+			}
+		}
+	}
+}
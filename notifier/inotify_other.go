@@ -0,0 +1,37 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"go.uber.org/zap"
+)
+
+// Inotify is only available on linux
+type Inotify struct{}
+
+func NewInotify(logger *zap.Logger, dir string, keys Keys) (*Inotify, error) {
+	return nil, fmt.Errorf("inotify is not available on %s", runtime.GOOS)
+}
+
+// Run implements merger.OneBlockNotifier
+func (n *Inotify) Run(ctx context.Context, notify func(filename string)) error {
+	return fmt.Errorf("inotify is not available on %s", runtime.GOOS)
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notifier implements merger.OneBlockNotifier from bucket notifications:
+// S3 event notifications sent to an SQS queue, GCS notifications sent to a Pub/Sub subscription, and inotify
+// events of local one-block stores.
+package notifier
+
+import (
+	"strings"
+)
+
+// Keys turns the object keys of the bucket notifications into one-block filenames, relative to the one-block store
+type Keys struct {
+	// Prefix is the path of the one-block store in its bucket, without leading slash (ex: `eth/one-blocks`)
+	Prefix string
+	// Extension of the one-block files, that dstore adds to the filenames (ex: `dbin.zst`)
+	Extension string
+}
+
+// filename returns false for the objects outside of the one-block store
+func (k Keys) filename(key string) (string, bool) {
+	prefix := strings.Trim(k.Prefix, "/")
+	if prefix != "" {
+		if !strings.HasPrefix(key, prefix+"/") {
+			return "", false
+		}
+		key = key[len(prefix)+1:]
+	}
+	if strings.Contains(key, "/") {
+		return "", false
+	}
+	if k.Extension != "" {
+		key = strings.TrimSuffix(key, "."+k.Extension)
+	}
+	return key, key != ""
+}
@@ -0,0 +1,40 @@
+package notifier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestKeysFilename(t *testing.T) {
+	keys := Keys{Prefix: "/eth/one-blocks/", Extension: "dbin.zst"}
+
+	filename, ok := keys.filename("eth/one-blocks/0000000100-20220101T000000.5-0000000000000100a-0000000000000099a-98-suffix.dbin.zst")
+	assert.True(t, ok)
+	assert.Equal(t, "0000000100-20220101T000000.5-0000000000000100a-0000000000000099a-98-suffix", filename)
+
+	_, ok = keys.filename("eth/merged-blocks/0000000100.dbin.zst")
+	assert.False(t, ok)
+	_, ok = keys.filename("eth/one-blocks/sub/0000000100.dbin.zst")
+	assert.False(t, ok)
+
+	filename, ok = Keys{}.filename("0000000100-0000000000000100a-0000000000000099a-98-suffix")
+	assert.True(t, ok)
+	assert.Equal(t, "0000000100-0000000000000100a-0000000000000099a-98-suffix", filename)
+}
+
+func TestSQSFilenames(t *testing.T) {
+	n := &SQS{keys: Keys{Prefix: "one-blocks", Extension: "dbin.zst"}, logger: zap.NewNop()}
+
+	body := `{"Records":[
+		{"eventName":"ObjectCreated:Put","s3":{"object":{"key":"one-blocks/0000000100-0000000000000100a-0000000000000099a-98-suffix.dbin.zst"}}},
+		{"eventName":"ObjectRemoved:Delete","s3":{"object":{"key":"one-blocks/0000000099-0000000000000099a-0000000000000098a-97-suffix.dbin.zst"}}},
+		{"eventName":"ObjectCreated:Put","s3":{"object":{"key":"one-blocks/0000000101-20220101T000000%3A5-a-b-99-suffix.dbin.zst"}}}
+	]}`
+	assert.Equal(t, []string{
+		"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+		"0000000101-20220101T000000:5-a-b-99-suffix",
+	}, n.filenames(body))
+	assert.Empty(t, n.filenames("not json"))
+}
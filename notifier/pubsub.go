@@ -0,0 +1,129 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2/google"
+)
+
+var pubSubEndpoint = "https://pubsub.googleapis.com/v1/"
+
+// PubSubEmptyPullDelay is how long to wait before pulling again when a pull returned no message
+var PubSubEmptyPullDelay = time.Second
+
+// PubSub pulls the GCS notifications (`OBJECT_FINALIZE` events) of the one-block store bucket from a Pub/Sub subscription,
+// through the Pub/Sub REST API with the application default credentials. Messages are acknowledged once handed to the merger.
+type PubSub struct {
+	client       *http.Client
+	subscription string // projects/<project>/subscriptions/<subscription>
+	keys         Keys
+	logger       *zap.Logger
+}
+
+func NewPubSub(ctx context.Context, logger *zap.Logger, subscription string, keys Keys) (*PubSub, error) {
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/pubsub")
+	if err != nil {
+		return nil, fmt.Errorf("creating pubsub client: %w", err)
+	}
+	return &PubSub{
+		client:       client,
+		subscription: subscription,
+		keys:         keys,
+		logger:       logger,
+	}, nil
+}
+
+type pubSubPullResponse struct {
+	ReceivedMessages []struct {
+		AckID   string `json:"ackId"`
+		Message struct {
+			Attributes map[string]string `json:"attributes"`
+		} `json:"message"`
+	} `json:"receivedMessages"`
+}
+
+// Run implements merger.OneBlockNotifier
+func (n *PubSub) Run(ctx context.Context, notify func(filename string)) error {
+	for {
+		var resp pubSubPullResponse
+		if err := n.call(ctx, "pull", map[string]interface{}{"maxMessages": 1000}, &resp); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if len(resp.ReceivedMessages) == 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(PubSubEmptyPullDelay):
+			}
+			continue
+		}
+
+		ackIDs := make([]string, 0, len(resp.ReceivedMessages))
+		for _, received := range resp.ReceivedMessages {
+			ackIDs = append(ackIDs, received.AckID)
+			if received.Message.Attributes["eventType"] != "OBJECT_FINALIZE" {
+				continue
+			}
+			if filename, ok := n.keys.filename(received.Message.Attributes["objectId"]); ok {
+				notify(filename)
+			}
+		}
+		if err := n.call(ctx, "acknowledge", map[string]interface{}{"ackIds": ackIDs}, nil); err != nil {
+			n.logger.Warn("cannot acknowledge messages", zap.String("subscription", n.subscription), zap.Error(err))
+		}
+	}
+}
+
+func (n *PubSub) call(ctx context.Context, method string, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s%s:%s", pubSubEndpoint, n.subscription, method), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pubsub %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("pubsub %s: %w", method, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pubsub %s: %s: %s", method, resp.Status, content)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(content, out)
+}
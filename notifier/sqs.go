@@ -0,0 +1,111 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"go.uber.org/zap"
+)
+
+// SQS receives the S3 event notifications (`s3:ObjectCreated:*`) of the one-block store bucket from an SQS queue.
+// Messages are deleted from the queue once handed to the merger.
+type SQS struct {
+	client   *sqs.SQS
+	queueURL string
+	keys     Keys
+	logger   *zap.Logger
+}
+
+func NewSQS(logger *zap.Logger, queueURL, region string, keys Keys) (*SQS, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("creating aws session: %w", err)
+	}
+	return &SQS{
+		client:   sqs.New(sess),
+		queueURL: queueURL,
+		keys:     keys,
+		logger:   logger,
+	}, nil
+}
+
+type s3Event struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// Run implements merger.OneBlockNotifier
+func (n *SQS) Run(ctx context.Context, notify func(filename string)) error {
+	for {
+		out, err := n.client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(n.queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("receiving from %s: %w", n.queueURL, err)
+		}
+
+		for _, msg := range out.Messages {
+			for _, filename := range n.filenames(aws.StringValue(msg.Body)) {
+				notify(filename)
+			}
+			if _, err := n.client.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(n.queueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			}); err != nil {
+				n.logger.Warn("cannot delete message from queue", zap.String("queue_url", n.queueURL), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (n *SQS) filenames(body string) (out []string) {
+	var event s3Event
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		n.logger.Debug("ignoring message that is not an s3 event", zap.Error(err))
+		return nil
+	}
+	for _, record := range event.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+		key, err := url.QueryUnescape(record.S3.Object.Key) // keys are url-encoded in s3 events
+		if err != nil {
+			continue
+		}
+		if filename, ok := n.keys.filename(key); ok {
+			out = append(out, filename)
+		}
+	}
+	return
+}
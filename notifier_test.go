@@ -0,0 +1,57 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifiedOneBlockFiles(t *testing.T) {
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithOneBlockNotifier(nil, time.Minute))
+
+	assert.True(t, m.walkDue(t0), "first cycle walks")
+	assert.False(t, m.notifications.reconciling, "the first walk is not a reconciliation")
+	assert.False(t, m.walkDue(t0.Add(time.Second)))
+
+	m.notify("0000000101-0000000000000101a-0000000000000100a-99-suffix")
+	m.notify("0000000100-0000000000000100a-0000000000000099a-98-suffix")
+	m.notify("0000000102-0000000000000102a-0000000000000101a-100-suffix.tmp")
+	m.notify("not-a-one-block-file")
+
+	var handled []uint64
+	require.NoError(t, m.handleNotifiedOneBlockFiles(context.Background(), func(obf *bstream.OneBlockFile) error {
+		handled = append(handled, obf.Num)
+		return nil
+	}))
+	assert.Equal(t, []uint64{100, 101}, handled, "handled in block order")
+
+	assert.True(t, m.walkDue(t0.Add(time.Minute)), "reconciliation walk")
+	assert.True(t, m.notifications.reconciling)
+	assert.False(t, m.walkDue(t0.Add(time.Minute+time.Second)))
+	assert.False(t, m.notifications.reconciling)
+}
+
+func TestNotificationsOverflow(t *testing.T) {
+	defer func(prev int) { NotificationsQueueSize = prev }(NotificationsQueueSize)
+	NotificationsQueueSize = 1
+
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithOneBlockNotifier(nil, time.Minute))
+	assert.True(t, m.walkDue(t0))
+
+	m.notify("0000000100-0000000000000100a-0000000000000099a-98-suffix")
+	m.notify("0000000101-0000000000000101a-0000000000000100a-99-suffix")
+	assert.True(t, m.walkDue(t0.Add(time.Second)), "notifications were dropped, walking")
+	assert.False(t, m.notifications.reconciling, "dropped notifications are not missed by the notifier")
+	assert.False(t, m.walkDue(t0.Add(2*time.Second)))
+}
+
+func TestWalkDueWithoutNotifier(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	assert.True(t, m.walkDue(time.Now()))
+}
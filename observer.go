@@ -0,0 +1,221 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// DefaultObserverPollInterval is how often the observer looks for a merged file not written yet by the active merger
+var DefaultObserverPollInterval = 5 * time.Second
+
+// maxObservedDivergences bounds the divergences kept for the admin status, the oldest are dropped first
+const maxObservedDivergences = 100
+
+const (
+	ObservedDivergenceMissing = "missing" // the active merger did not write the merged file in time
+	ObservedDivergenceBlocks  = "blocks"  // the merged file does not hold the blocks the observer bundled
+)
+
+// MergedFilesReader reads the blocks of the merged files back, DStoreIO implements it
+type MergedFilesReader interface {
+	FetchMergedOneBlockFiles(ctx context.Context, baseBlock uint64) ([]*bstream.OneBlockFile, error)
+}
+
+// ObservedDivergence is a bundle on which the merged file written by the active merger differs from what the observer
+// would have merged
+type ObservedDivergence struct {
+	BaseBlock uint64    `json:"base_block"`
+	Kind      string    `json:"kind"`
+	Reason    string    `json:"reason"`
+	Time      time.Time `json:"time"`
+}
+
+// WithObserverMode turns the merger into an observer of the active merger writing to the same stores: it walks the
+// one-block files and completes the bundles on its own, but instead of merging each bundle it compares it with the
+// merged file read back with `merged`, waiting up to `mergedWait` for the active merger to write it. It never writes
+// nor deletes anything in the stores, the divergences are logged, counted in `merger_observed_divergences` and listed
+// in the admin status. The optional IO capabilities (resumable walks, retention, merge intents...) are not available.
+func WithObserverMode(merged MergedFilesReader, mergedWait time.Duration) Option {
+	return func(m *Merger) {
+		observer := &observerIO{
+			io:           m.io,
+			merged:       merged,
+			mergedWait:   mergedWait,
+			pollInterval: DefaultObserverPollInterval,
+			now:          func() time.Time { return m.clock.Now() },
+		}
+		m.io = observer
+		m.bundler.io = observer
+	}
+}
+
+// WithShadowMode is a read-only mode to validate a new merger version, or a new filename parser, against the merged
+// files already in production before cutover. Like WithObserverMode, it never writes nor deletes anything and reports
+// the divergences the same way, but it starts at its own start block (see WithStartBlock) instead of after the last
+// merged file, does not wait for the merged files, a missing one being a divergence, and only compares the sets of
+// block IDs of each bundle, regardless of their order in the merged file.
+func WithShadowMode(merged MergedFilesReader) Option {
+	return func(m *Merger) {
+		WithObserverMode(merged, 0)(m)
+		observer := m.io.(*observerIO)
+		observer.shadow = true
+		observer.started = true
+	}
+}
+
+// observerIO hides every write of the IO it wraps, MergeAndStore compares the bundle with the merged file instead
+type observerIO struct {
+	io           IOInterface
+	merged       MergedFilesReader
+	mergedWait   time.Duration
+	pollInterval time.Duration
+	now          func() time.Time
+	logger       *zap.Logger // set by applySettings
+
+	started bool // set on the first NextBundle, from the main loop
+	shadow  bool // see WithShadowMode
+
+	lock        sync.Mutex
+	divergences []*ObservedDivergence
+}
+
+// NextBundle starts the observer at the next bundle the active merger has to merge, then keeps it on its own bundles
+// so that each one is compared, however far ahead the active merger is
+func (o *observerIO) NextBundle(ctx context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+	if o.started {
+		return lowestBaseBlock, nil, nil
+	}
+	base, lib, err := o.io.NextBundle(ctx, lowestBaseBlock)
+	if err != nil {
+		return 0, nil, err
+	}
+	o.started = true
+	return base, lib, nil
+}
+
+func (o *observerIO) WalkOneBlockFiles(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+	return o.io.WalkOneBlockFiles(ctx, inclusiveLowerBlock, callback)
+}
+
+func (o *observerIO) DownloadOneBlockFile(ctx context.Context, oneBlockFile *bstream.OneBlockFile) ([]byte, error) {
+	return o.io.DownloadOneBlockFile(ctx, oneBlockFile)
+}
+
+func (o *observerIO) DeleteAsync(_ []*bstream.OneBlockFile) error {
+	return nil
+}
+
+func (o *observerIO) MergeAndStore(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+	var expected []*bstream.OneBlockFile
+	for _, obf := range oneBlockFiles {
+		if obf.Num >= inclusiveLowerBlock { // the last block of the previous bundle is kept with this one
+			expected = append(expected, obf)
+		}
+	}
+
+	deadline := o.now().Add(o.mergedWait)
+	for {
+		merged, err := o.merged.FetchMergedOneBlockFiles(ctx, inclusiveLowerBlock)
+		if err == nil {
+			o.compare(inclusiveLowerBlock, expected, merged)
+			return nil
+		}
+		if !errors.Is(err, dstore.ErrNotFound) {
+			return err
+		}
+		if !o.now().Before(deadline) {
+			o.diverged(inclusiveLowerBlock, ObservedDivergenceMissing, fmt.Sprintf("no merged file after %s", o.mergedWait))
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(o.pollInterval):
+		}
+	}
+}
+
+func (o *observerIO) compare(baseBlock uint64, expected, merged []*bstream.OneBlockFile) {
+	metrics.ObservedBundles.Inc()
+	if o.shadow {
+		o.compareIDs(baseBlock, expected, merged)
+		return
+	}
+	if len(merged) != len(expected) {
+		o.diverged(baseBlock, ObservedDivergenceBlocks, fmt.Sprintf("merged file holds %d blocks, expected %d", len(merged), len(expected)))
+		return
+	}
+	for i, obf := range merged {
+		if bstream.TruncateBlockID(obf.ID) != bstream.TruncateBlockID(expected[i].ID) || obf.Num != expected[i].Num {
+			o.diverged(baseBlock, ObservedDivergenceBlocks, fmt.Sprintf("merged file holds block #%d (%s) at position %d, expected %s", obf.Num, obf.ID, i, expected[i]))
+			return
+		}
+	}
+	o.logger.Debug("merged file matches the observed bundle", zap.Uint64("base_block", baseBlock), zap.Int("blocks", len(merged)))
+}
+
+// compareIDs reports the block IDs only in the bundle or only in the merged file
+func (o *observerIO) compareIDs(baseBlock uint64, expected, merged []*bstream.OneBlockFile) {
+	mergedIDs := make(map[string]bool)
+	for _, obf := range merged {
+		mergedIDs[bstream.TruncateBlockID(obf.ID)] = true
+	}
+	var missing, extra []string
+	for _, obf := range expected {
+		if !mergedIDs[bstream.TruncateBlockID(obf.ID)] {
+			missing = append(missing, fmt.Sprintf("#%d (%s)", obf.Num, obf.ID))
+		}
+		delete(mergedIDs, bstream.TruncateBlockID(obf.ID))
+	}
+	for _, obf := range merged {
+		if mergedIDs[bstream.TruncateBlockID(obf.ID)] {
+			extra = append(extra, fmt.Sprintf("#%d (%s)", obf.Num, obf.ID))
+		}
+	}
+	if len(missing) != 0 || len(extra) != 0 {
+		o.diverged(baseBlock, ObservedDivergenceBlocks, fmt.Sprintf("blocks not in the merged file: %v, blocks only in the merged file: %v", missing, extra))
+		return
+	}
+	o.logger.Debug("merged file holds the blocks of the shadow bundle", zap.Uint64("base_block", baseBlock), zap.Int("blocks", len(merged)))
+}
+
+func (o *observerIO) diverged(baseBlock uint64, kind, reason string) {
+	metrics.ObservedDivergences.Inc(kind)
+	o.logger.Error("merged file diverges from the observed bundle", zap.Uint64("base_block", baseBlock), zap.String("kind", kind), zap.String("reason", reason))
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.divergences = append(o.divergences, &ObservedDivergence{BaseBlock: baseBlock, Kind: kind, Reason: reason, Time: o.now()})
+	if len(o.divergences) > maxObservedDivergences {
+		o.divergences = o.divergences[len(o.divergences)-maxObservedDivergences:]
+	}
+}
+
+// ObservedDivergences returns the last divergences found by the observer
+func (o *observerIO) ObservedDivergences() []*ObservedDivergence {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return append([]*ObservedDivergence(nil), o.divergences...)
+}
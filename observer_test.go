@@ -0,0 +1,96 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testMergedFilesReader map[uint64][]*bstream.OneBlockFile
+
+func (r testMergedFilesReader) FetchMergedOneBlockFiles(_ context.Context, baseBlock uint64) ([]*bstream.OneBlockFile, error) {
+	if files, ok := r[baseBlock]; ok {
+		return files, nil
+	}
+	return nil, dstore.ErrNotFound
+}
+
+func TestObserverMode(t *testing.T) {
+	var written, deleted bool
+	mio := &TestMergerIO{
+		NextBundleFunc: func(_ context.Context, _ uint64) (uint64, bstream.BlockRef, error) {
+			return 100, nil, nil
+		},
+		MergeAndStoreFunc: func(_ context.Context, _ uint64, _ []*bstream.OneBlockFile) error {
+			written = true
+			return nil
+		},
+		DeleteAsyncFunc: func(_ []*bstream.OneBlockFile) error {
+			deleted = true
+			return nil
+		},
+	}
+	merged := testMergedFilesReader{
+		100: {block100}, // missing block 101, and no merged file for 102
+	}
+	m := NewMerger(testLogger, "", mio, 100, 2, 100, time.Second, time.Second, 0, WithObserverMode(merged, 0))
+
+	base, _, err := m.io.NextBundle(context.Background(), 0)
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, base, "started where the active merger is")
+	base, _, err = m.io.NextBundle(context.Background(), 102)
+	require.NoError(t, err)
+	assert.EqualValues(t, 102, base, "then on its own bundles")
+
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102, block105Final103, block106Final104} {
+		require.NoError(t, m.bundler.HandleBlockFile(obf))
+	}
+	m.bundler.inProcess.Lock()
+	m.bundler.inProcess.Unlock()
+	require.NoError(t, m.io.DeleteAsync([]*bstream.OneBlockFile{block100}))
+	assert.False(t, written)
+	assert.False(t, deleted)
+
+	divergences := m.collectAdminStatus().Divergences
+	require.Len(t, divergences, 2)
+	assert.EqualValues(t, 100, divergences[0].BaseBlock)
+	assert.Equal(t, ObservedDivergenceBlocks, divergences[0].Kind)
+	assert.EqualValues(t, 102, divergences[1].BaseBlock)
+	assert.Equal(t, ObservedDivergenceMissing, divergences[1].Kind)
+}
+
+func TestShadowMode(t *testing.T) {
+	mio := &TestMergerIO{
+		NextBundleFunc: func(_ context.Context, _ uint64) (uint64, bstream.BlockRef, error) {
+			return 200, nil, nil
+		},
+	}
+	merged := testMergedFilesReader{
+		100: {block101, block100},         // same blocks, another order
+		102: {block102Final100, block100}, // block 103 missing, block 100 extra
+	}
+	m := NewMerger(testLogger, "", mio, 100, 2, 100, time.Second, time.Second, 0, WithShadowMode(merged))
+
+	base, _, err := m.io.NextBundle(context.Background(), 100)
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, base, "starts at its own start block")
+
+	ctx := context.Background()
+	require.NoError(t, m.io.MergeAndStore(ctx, 100, []*bstream.OneBlockFile{block100, block101}))
+	require.NoError(t, m.io.MergeAndStore(ctx, 102, []*bstream.OneBlockFile{block101, block102Final100, block103Final101}))
+	require.NoError(t, m.io.MergeAndStore(ctx, 104, []*bstream.OneBlockFile{block104Final102, block105Final103}))
+
+	divergences := m.collectAdminStatus().Divergences
+	require.Len(t, divergences, 2)
+	assert.EqualValues(t, 102, divergences[0].BaseBlock)
+	assert.Equal(t, ObservedDivergenceBlocks, divergences[0].Kind)
+	assert.Contains(t, divergences[0].Reason, "#103")
+	assert.Contains(t, divergences[0].Reason, "only in the merged file: [#100")
+	assert.EqualValues(t, 104, divergences[1].BaseBlock)
+	assert.Equal(t, ObservedDivergenceMissing, divergences[1].Kind, "not waited for")
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/sadiq1971/merger/metrics"
+)
+
+// WithOneBlockCacheBytes keeps the data of the last downloaded one-block files in memory, up to `bytes`, so that
+// downloading them again within a cycle (bootstrap, merge retries, verification, archiving) does not hit the one-block
+// store. The files are keyed by canonical name, whatever copy (suffix) was downloaded. 0 (the default) disables the
+// cache.
+func WithOneBlockCacheBytes(bytes uint64) DStoreIOOption {
+	return func(s *DStoreIO) {
+		if bytes > 0 {
+			s.oneBlockCache = newOneBlockCache(bytes)
+		}
+	}
+}
+
+// oneBlockCache is an LRU of one-block file data bounded in bytes, nil-safe
+type oneBlockCache struct {
+	sync.Mutex
+	maxBytes uint64
+	bytes    uint64
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type oneBlockCacheEntry struct {
+	canonicalName string
+	data          []byte
+}
+
+func newOneBlockCache(maxBytes uint64) *oneBlockCache {
+	return &oneBlockCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the cached data, which must not be modified
+func (c *oneBlockCache) get(canonicalName string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	elem, found := c.entries[canonicalName]
+	if !found {
+		metrics.OneBlockCacheMisses.Inc()
+		return nil, false
+	}
+	metrics.OneBlockCacheHits.Inc()
+	c.order.MoveToFront(elem)
+	return elem.Value.(*oneBlockCacheEntry).data, true
+}
+
+// put caches `data`, unless larger than the whole cache
+func (c *oneBlockCache) put(canonicalName string, data []byte) {
+	if c == nil || uint64(len(data)) > c.maxBytes {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if elem, found := c.entries[canonicalName]; found {
+		c.bytes -= uint64(len(elem.Value.(*oneBlockCacheEntry).data))
+		elem.Value = &oneBlockCacheEntry{canonicalName: canonicalName, data: data}
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[canonicalName] = c.order.PushFront(&oneBlockCacheEntry{canonicalName: canonicalName, data: data})
+	}
+	c.bytes += uint64(len(data))
+	for c.bytes > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*oneBlockCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.canonicalName)
+		c.bytes -= uint64(len(entry.data))
+	}
+	metrics.OneBlockCacheBytes.SetUint64(c.bytes)
+}
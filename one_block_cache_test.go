@@ -0,0 +1,43 @@
+package merger
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDStoreIO_OneBlockCache(t *testing.T) {
+	files := testPrefetchFiles(3)
+
+	downloads := 0
+	oneBlocksStore := dstore.NewMockStore(nil)
+	oneBlocksStore.OpenObjectFunc = func(_ context.Context, _ string) (io.ReadCloser, error) {
+		downloads++
+		return ioutil.NopCloser(strings.NewReader("0123456789")), nil
+	}
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithOneBlockCacheBytes(25)).(*DStoreIO)
+
+	for i := 0; i < 2; i++ {
+		data, err := mio.DownloadOneBlockFile(context.Background(), files[0])
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", string(data))
+	}
+	assert.Equal(t, 1, downloads, "served from the cache the second time")
+
+	for _, f := range files[1:] {
+		_, err := mio.DownloadOneBlockFile(context.Background(), f)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, downloads)
+	assert.EqualValues(t, 20, mio.oneBlockCache.bytes, "the least recently used file is evicted")
+
+	_, err := mio.DownloadOneBlockFile(context.Background(), files[0])
+	require.NoError(t, err)
+	assert.Equal(t, 4, downloads)
+}
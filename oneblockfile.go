@@ -0,0 +1,200 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/streamingfast/bstream"
+)
+
+// parseOneBlockFilename is an allocation-free equivalent of bstream.ParseFilename.
+// It parses file names formatted like:
+// * 0000000101-dbda3f44afee24dd-24a072678473e4ad-100-mindread1
+// * 0000000101-20170701T122141.500-dbda3f44afee24dd-24a072678473e4ad-100-mindread1
+//
+// The optional timestamp can have second, millisecond, nanosecond (or legacy tenth of a second) precision,
+// it is not part of the canonical name so that the same block written with different precisions is deduplicated.
+// Returned strings are sub-slices of filename, nothing is copied unless a timestamp is present.
+func parseOneBlockFilename(filename string) (blockNum uint64, blockTime time.Time, blockIDSuffix string, previousBlockIDSuffix string, libNum uint64, canonicalName string, err error) {
+	var dashes [5]int
+	count := 0
+	for i := 0; i < len(filename); i++ {
+		if filename[i] != '-' {
+			continue
+		}
+		if count == len(dashes) {
+			err = fmt.Errorf("wrong filename format: %q", filename)
+			return
+		}
+		dashes[count] = i
+		count++
+	}
+
+	var timestamp string
+	switch count {
+	case 4:
+	case 5:
+		timestamp = filename[dashes[0]+1 : dashes[1]]
+		copy(dashes[1:], dashes[2:])
+	default:
+		err = fmt.Errorf("wrong filename format: %q", filename)
+		return
+	}
+
+	var ok bool
+	if blockNum, ok = parseUint32(filename[:dashes[0]]); !ok {
+		err = fmt.Errorf("failed parsing %q: invalid block number", filename[:dashes[0]])
+		return
+	}
+	if libNum, ok = parseUint32(filename[dashes[2]+1 : dashes[3]]); !ok {
+		err = fmt.Errorf("failed parsing lib num %q: invalid lib number", filename[dashes[2]+1:dashes[3]])
+		return
+	}
+
+	blockIDSuffix = filename[dashes[0]+1 : dashes[1]]
+	previousBlockIDSuffix = filename[dashes[1]+1 : dashes[2]]
+	canonicalName = filename[:dashes[3]]
+
+	if timestamp != "" {
+		if blockTime, ok = parseFilenameTimestamp(timestamp); !ok {
+			err = fmt.Errorf("failed parsing timestamp %q", timestamp)
+			return
+		}
+		blockIDSuffix = filename[dashes[0]+len(timestamp)+2 : dashes[1]]
+		canonicalName = filename[:dashes[0]] + filename[dashes[0]+len(timestamp)+1:dashes[3]]
+	}
+	return
+}
+
+// parseFilenameTimestamp parses `20060102T150405` followed by an optional fraction of a second of 1 to 9 digits
+func parseFilenameTimestamp(in string) (out time.Time, ok bool) {
+	if len(in) < 15 || in[8] != 'T' {
+		return
+	}
+	var parts [6]int
+	for i, bounds := range [6][2]int{{0, 4}, {4, 6}, {6, 8}, {9, 11}, {11, 13}, {13, 15}} {
+		val, valid := parseUint32(in[bounds[0]:bounds[1]])
+		if !valid {
+			return
+		}
+		parts[i] = int(val)
+	}
+
+	nanos := 0
+	if fraction := in[15:]; fraction != "" {
+		if fraction[0] != '.' || len(fraction) < 2 || len(fraction) > 10 {
+			return
+		}
+		val, valid := parseUint32(fraction[1:])
+		if !valid {
+			return
+		}
+		nanos = int(val)
+		for i := len(fraction) - 1; i < 9; i++ {
+			nanos *= 10
+		}
+	}
+	return time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], nanos, time.UTC), true
+}
+
+// parseUint32 mirrors strconv.ParseUint(in, 10, 32) without allocating an error value
+func parseUint32(in string) (out uint64, ok bool) {
+	if len(in) == 0 {
+		return 0, false
+	}
+	for i := 0; i < len(in); i++ {
+		c := in[i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		out = out*10 + uint64(c-'0')
+		if out > math.MaxUint32 {
+			return 0, false
+		}
+	}
+	return out, true
+}
+
+func fastNewOneBlockFile(fileName string) (*bstream.OneBlockFile, error) {
+	blockNum, _, blockID, previousBlockID, libNum, canonicalName, err := parseOneBlockFilename(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &bstream.OneBlockFile{
+		CanonicalName: canonicalName,
+		Filenames: map[string]bool{
+			fileName: true,
+		},
+		ID:         blockID,
+		Num:        blockNum,
+		PreviousID: previousBlockID,
+		LibNum:     libNum,
+	}, nil
+}
+
+func mustNewOneBlockFile(fileName string) *bstream.OneBlockFile {
+	out, err := newOneBlockFile(fileName)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// TimestampPrecision is the precision of the optional timestamp written in one-block filenames
+type TimestampPrecision int
+
+const (
+	TimestampPrecisionNone TimestampPrecision = iota
+	TimestampPrecisionSecond
+	TimestampPrecisionMillisecond
+	TimestampPrecisionNanosecond
+)
+
+func ParseTimestampPrecision(in string) (TimestampPrecision, error) {
+	switch in {
+	case "", "none":
+		return TimestampPrecisionNone, nil
+	case "s", "second":
+		return TimestampPrecisionSecond, nil
+	case "ms", "millisecond":
+		return TimestampPrecisionMillisecond, nil
+	case "ns", "nanosecond":
+		return TimestampPrecisionNanosecond, nil
+	}
+	return TimestampPrecisionNone, fmt.Errorf("invalid timestamp precision %q, expected one of none, second, millisecond or nanosecond", in)
+}
+
+func (p TimestampPrecision) layout() string {
+	switch p {
+	case TimestampPrecisionSecond:
+		return "20060102T150405"
+	case TimestampPrecisionMillisecond:
+		return "20060102T150405.000"
+	case TimestampPrecisionNanosecond:
+		return "20060102T150405.000000000"
+	}
+	return ""
+}
+
+// oneBlockFilename builds the name of a one-block file, with a timestamp of the given precision
+func oneBlockFilename(obf *bstream.OneBlockFile, blockTime time.Time, suffix string, precision TimestampPrecision) string {
+	if precision == TimestampPrecisionNone {
+		return fmt.Sprintf("%s-%s", obf.CanonicalName, suffix)
+	}
+	return fmt.Sprintf("%010d-%s-%s-%s-%d-%s", obf.Num, blockTime.UTC().Format(precision.layout()), obf.ID, obf.PreviousID, obf.LibNum, suffix)
+}
@@ -0,0 +1,7 @@
+//go:build !legacy_filename_parser
+
+package merger
+
+// newOneBlockFile uses the allocation-free parser. Build with `-tags legacy_filename_parser`
+// to fall back to bstream.NewOneBlockFile for comparison.
+var newOneBlockFile = fastNewOneBlockFile
@@ -0,0 +1,20 @@
+//go:build legacy_filename_parser
+
+package merger
+
+import (
+	"strings"
+
+	"github.com/streamingfast/bstream"
+)
+
+var newOneBlockFile = legacyNewOneBlockFile
+
+// legacyNewOneBlockFile parses with bstream.NewOneBlockFile, which does not support timestamps in filenames: the
+// timestamped ones go through parseOneBlockFilename, as without the tag, instead of being misparsed
+func legacyNewOneBlockFile(fileName string) (*bstream.OneBlockFile, error) {
+	if strings.Count(fileName, "-") != 5 {
+		return bstream.NewOneBlockFile(fileName)
+	}
+	return fastNewOneBlockFile(fileName)
+}
@@ -0,0 +1,39 @@
+//go:build legacy_filename_parser
+
+package merger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLegacyNewOneBlockFile_MatchesParser(t *testing.T) {
+	for _, filename := range []string{
+		"0000000101-dbda3f44afee24dd-24a072678473e4ad-100-mindread1",
+		"0000000101-20170701T122141-dbda3f44afee24dd-24a072678473e4ad-100-mindread1",
+		"0000000101-20170701T122141.5-dbda3f44afee24dd-24a072678473e4ad-100-mindread1",
+		"0000000101-20170701T122141.123456789-dbda3f44afee24dd-24a072678473e4ad-100-mindread1",
+		"0000000101-2017070XT122141-dbda3f44afee24dd-24a072678473e4ad-100-mindread1",
+		"0000000101-20170701T122141.1234567890-dbda3f44afee24dd-24a072678473e4ad-100-mindread1",
+		"0000000101-20170701T122141-dbda3f44afee24dd-24a072678473e4ad-100-mind-read1",
+		"0000000101-dbda3f44afee24dd-24a072678473e4ad-mindread1",
+	} {
+		t.Run(filename, func(t *testing.T) {
+			expected, expectedErr := fastNewOneBlockFile(filename)
+			obf, err := legacyNewOneBlockFile(filename)
+			if expectedErr != nil {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, expected.CanonicalName, obf.CanonicalName)
+			assert.Equal(t, expected.Filenames, obf.Filenames)
+			assert.Equal(t, expected.ID, obf.ID)
+			assert.Equal(t, expected.PreviousID, obf.PreviousID)
+			assert.Equal(t, expected.Num, obf.Num)
+			assert.Equal(t, expected.LibNum, obf.LibNum)
+		})
+	}
+}
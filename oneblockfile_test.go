@@ -0,0 +1,142 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOneBlockFilename(t *testing.T) {
+	tests := []struct {
+		name      string
+		filename  string
+		expectErr bool
+	}{
+		{"vanilla", "0000000101-dbda3f44afee24dd-24a072678473e4ad-100-mindread1", false},
+		{"short ids", "0000000100-0000000000000100a-0000000000000099a-98-suffix", false},
+		{"zero lib", "0000000001-aa-bb-0-s", false},
+		{"too few parts", "0000000101-dbda3f44afee24dd-24a072678473e4ad-100", true},
+		{"too many parts", "0000000101-dbda3f44afee24dd-24a072678473e4ad-100-mind-read1", true},
+		{"bad block num", "00000001x1-dbda3f44afee24dd-24a072678473e4ad-100-mindread1", true},
+		{"empty block num", "-dbda3f44afee24dd-24a072678473e4ad-100-mindread1", true},
+		{"bad lib num", "0000000101-dbda3f44afee24dd-24a072678473e4ad-x-mindread1", true},
+		{"overflow", "9999999999-dbda3f44afee24dd-24a072678473e4ad-100-mindread1", true},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			num, _, id, prevID, lib, canonical, err := parseOneBlockFilename(c.filename)
+			expNum, expID, expPrevID, expLib, expCanonical, expErr := bstream.ParseFilename(c.filename)
+			if c.expectErr {
+				require.Error(t, err)
+				require.Error(t, expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, expErr)
+			assert.Equal(t, expNum, num)
+			assert.Equal(t, expID, id)
+			assert.Equal(t, expPrevID, prevID)
+			assert.Equal(t, expLib, lib)
+			assert.Equal(t, expCanonical, canonical)
+		})
+	}
+}
+
+func TestParseOneBlockFilename_NoAlloc(t *testing.T) {
+	filename := "0000000101-dbda3f44afee24dd-24a072678473e4ad-100-mindread1"
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _, _, _, _, _, _ = parseOneBlockFilename(filename)
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestParseOneBlockFilename_Timestamps(t *testing.T) {
+	tests := []struct {
+		name       string
+		filename   string
+		expectTime time.Time
+		expectErr  bool
+	}{
+		{"second", "0000000101-20170701T122141-dbda3f44afee24dd-24a072678473e4ad-100-mindread1", time.Date(2017, 7, 1, 12, 21, 41, 0, time.UTC), false},
+		{"legacy tenth", "0000000101-20170701T122141.5-dbda3f44afee24dd-24a072678473e4ad-100-mindread1", time.Date(2017, 7, 1, 12, 21, 41, 500000000, time.UTC), false},
+		{"millisecond", "0000000101-20170701T122141.123-dbda3f44afee24dd-24a072678473e4ad-100-mindread1", time.Date(2017, 7, 1, 12, 21, 41, 123000000, time.UTC), false},
+		{"nanosecond", "0000000101-20170701T122141.123456789-dbda3f44afee24dd-24a072678473e4ad-100-mindread1", time.Date(2017, 7, 1, 12, 21, 41, 123456789, time.UTC), false},
+		{"bad timestamp", "0000000101-2017070XT122141-dbda3f44afee24dd-24a072678473e4ad-100-mindread1", time.Time{}, true},
+		{"too precise", "0000000101-20170701T122141.1234567890-dbda3f44afee24dd-24a072678473e4ad-100-mindread1", time.Time{}, true},
+	}
+
+	for _, c := range tests {
+		t.Run(c.name, func(t *testing.T) {
+			num, blockTime, id, prevID, lib, canonical, err := parseOneBlockFilename(c.filename)
+			if c.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.EqualValues(t, 101, num)
+			assert.Equal(t, c.expectTime, blockTime)
+			assert.Equal(t, "dbda3f44afee24dd", id)
+			assert.Equal(t, "24a072678473e4ad", prevID)
+			assert.EqualValues(t, 100, lib)
+			assert.Equal(t, "0000000101-dbda3f44afee24dd-24a072678473e4ad-100", canonical)
+		})
+	}
+}
+
+func TestNewOneBlockFile_Timestamp(t *testing.T) {
+	filename := "0000000101-20170701T122141.5-dbda3f44afee24dd-24a072678473e4ad-100-mindread1"
+	obf, err := newOneBlockFile(filename)
+	require.NoError(t, err)
+	assert.EqualValues(t, 101, obf.Num)
+	assert.Equal(t, "dbda3f44afee24dd", obf.ID)
+	assert.Equal(t, "0000000101-dbda3f44afee24dd-24a072678473e4ad-100", obf.CanonicalName)
+	assert.Equal(t, map[string]bool{filename: true}, obf.Filenames)
+
+	_, err = newOneBlockFile("0000000101-2017070XT122141-dbda3f44afee24dd-24a072678473e4ad-100-mindread1")
+	assert.Error(t, err)
+}
+
+func TestOneBlockFilename(t *testing.T) {
+	obf := mustNewOneBlockFile("0000000101-dbda3f44afee24dd-24a072678473e4ad-100-mindread1")
+	blockTime := time.Date(2017, 7, 1, 12, 21, 41, 123456789, time.UTC)
+
+	assert.Equal(t, "0000000101-dbda3f44afee24dd-24a072678473e4ad-100-out", oneBlockFilename(obf, blockTime, "out", TimestampPrecisionNone))
+	assert.Equal(t, "0000000101-20170701T122141-dbda3f44afee24dd-24a072678473e4ad-100-out", oneBlockFilename(obf, blockTime, "out", TimestampPrecisionSecond))
+	assert.Equal(t, "0000000101-20170701T122141.123-dbda3f44afee24dd-24a072678473e4ad-100-out", oneBlockFilename(obf, blockTime, "out", TimestampPrecisionMillisecond))
+
+	name := oneBlockFilename(obf, blockTime, "out", TimestampPrecisionNanosecond)
+	assert.Equal(t, "0000000101-20170701T122141.123456789-dbda3f44afee24dd-24a072678473e4ad-100-out", name)
+	_, parsedTime, _, _, _, canonical, err := parseOneBlockFilename(name)
+	require.NoError(t, err)
+	assert.Equal(t, blockTime, parsedTime)
+	assert.Equal(t, obf.CanonicalName, canonical)
+}
+
+var benchFilename = "0000000101-dbda3f44afee24dd-24a072678473e4ad-100-mindread1"
+
+func BenchmarkParseOneBlockFilename(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _, _, _, _, _, _ = parseOneBlockFilename(benchFilename)
+	}
+}
+
+func BenchmarkBstreamParseFilename(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _, _, _, _, _ = bstream.ParseFilename(benchFilename)
+	}
+}
+
+// BenchmarkNewOneBlockFile measures the parser selected at build time, run it
+// with `-tags legacy_filename_parser` to compare against bstream.NewOneBlockFile
+func BenchmarkNewOneBlockFile(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = newOneBlockFile(benchFilename)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import "time"
+
+type Option func(m *Merger)
+
+// WithForkDBSoftLimit makes the bundler purge definitely-forked blocks early when the approximate memory
+// used by its forkdb goes above `bytes`. 0 disables the limit.
+func WithForkDBSoftLimit(bytes uint64) Option {
+	return func(m *Merger) {
+		m.bundler.forkDBSoftLimit = bytes
+	}
+}
+
+// WithCompletionReportPath sets where the JSON completion report of a bounded run (with a stop block) is written.
+// The report goes to stdout when no path is set.
+func WithCompletionReportPath(path string) Option {
+	return func(m *Merger) {
+		m.completionReportPath = path
+	}
+}
+
+// WithErrorClasses replaces DefaultErrorClasses: the first class matching an error gives its severity,
+// errors matching no class are fatal.
+func WithErrorClasses(classes ...*ErrorClass) Option {
+	return func(m *Merger) {
+		m.errorClasses = classes
+	}
+}
+
+// WithDegradedProbeInterval sets how often a read-only merged blocks store is probed before retrying the
+// pending bundle. 0 disables the degraded mode: read-only errors then shut the merger down.
+func WithDegradedProbeInterval(interval time.Duration) Option {
+	return func(m *Merger) {
+		m.bundler.degradedProbeInterval = interval
+	}
+}
+
+// WithIrreversibleConfirmations only closes a bundle once the LIB is at least `confirmations` blocks above
+// its boundary, as a safety margin on chains where the LIB has been seen rolling back. It must be lower than the bundle size.
+func WithIrreversibleConfirmations(confirmations uint64) Option {
+	return func(m *Merger) {
+		m.bundler.irreversibleConfirmations = confirmations
+	}
+}
+
+// WithStartBlock starts merging at startBlock instead of the first streamable block. If startBlock is not aligned
+// on the bundle size, the first bundle is a shorter alignment bundle, from startBlock to the next boundary, still
+// named after its aligned base block. The following bundles are on canonical boundaries.
+func WithStartBlock(startBlock uint64) Option {
+	return func(m *Merger) {
+		m.settings.startBlock = &startBlock
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// ObjectHasher returns a hash of the content of an object from its metadata, without downloading it. Hashes of
+// different hashers cannot be compared.
+type ObjectHasher interface {
+	ObjectHash(ctx context.Context, name string) (string, error)
+}
+
+// WithPayloadDedup detects the one-block files of the same block uploaded byte-identical by several producers (under
+// different suffixes): the copies whose hash from `hasher` matches are not downloaded again when archiving them (see
+// WithArchiveStore). Without a hasher, the copies are still downloaded. The sha256 of the payload of each
+// merged block is added to the bundle stats (see WithBundleStats), for downstream dedup.
+func WithPayloadDedup(hasher ObjectHasher) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.payloadDedup = true
+		s.objectHasher = hasher
+	}
+}
+
+// payloadHash is the hash of a one-block file payload written to the bundle stats
+func payloadHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// archiveDedupedOneBlockFile archives the copies of `obf`, downloading only the first one and the copies whose hash
+// differs from it
+func (s *DStoreIO) archiveDedupedOneBlockFile(ctx context.Context, obf *bstream.OneBlockFile) error {
+	var names []string
+	for name := range obf.Filenames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var first []byte
+	var firstHash string
+	for i, name := range names {
+		if i != 0 && firstHash != "" {
+			if hash, err := s.objectHasher.ObjectHash(ctx, name); err == nil && hash == firstHash {
+				if err := s.archiveStore.WriteObject(ctx, name, bytes.NewReader(first)); err != nil {
+					return fmt.Errorf("archiving %q: %w", name, err)
+				}
+				metrics.DedupedPayloads.Inc()
+				continue
+			}
+		}
+
+		release, err := s.downloads.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		reader, err := s.oneBlocksStore.OpenObject(ctx, name)
+		if err != nil {
+			release()
+			return fmt.Errorf("archiving %q: %w", name, err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		reader.Close()
+		release()
+		if err != nil {
+			return fmt.Errorf("archiving %q: %w", name, err)
+		}
+		if err := s.archiveStore.WriteObject(ctx, name, bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("archiving %q: %w", name, err)
+		}
+		if i == 0 {
+			first = data
+			if firstHash, err = s.objectHasher.ObjectHash(ctx, name); err != nil {
+				s.logger.Debug("cannot hash one-block file, its copies are downloaded", zap.String("file", name), zap.Error(err))
+				firstHash = ""
+			}
+		}
+	}
+	return nil
+}
+
+// S3ObjectHasher hashes the objects of an S3 store with their ETag, the MD5 of their content when uploaded in one
+// part without KMS encryption, as one-block files are
+type S3ObjectHasher struct {
+	service *s3.S3
+	bucket  string
+	store   dstore.Store
+}
+
+// NewS3ObjectHasher creates an ObjectHasher for `store`, opened from `storeURL` (`s3://bucket/path?region=...`)
+func NewS3ObjectHasher(storeURL string, store dstore.Store) (*S3ObjectHasher, error) {
+	service, bucket, err := newS3Service(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("object hashes: %w", err)
+	}
+	return &S3ObjectHasher{service: service, bucket: bucket, store: store}, nil
+}
+
+func (h *S3ObjectHasher) ObjectHash(ctx context.Context, name string) (string, error) {
+	out, err := h.service.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(h.bucket),
+		Key:    aws.String(h.store.ObjectPath(name)),
+	})
+	if err != nil {
+		return "", err
+	}
+	etag := strings.Trim(aws.StringValue(out.ETag), `"`)
+	if etag == "" || strings.Contains(etag, "-") {
+		return "", fmt.Errorf("etag %q of a multipart upload is not a content hash", etag)
+	}
+	return etag, nil
+}
@@ -0,0 +1,54 @@
+package merger
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testObjectHasher map[string]string
+
+func (h testObjectHasher) ObjectHash(_ context.Context, name string) (string, error) {
+	return h[name], nil
+}
+
+func TestDStoreIO_ArchiveDedupedOneBlockFile(t *testing.T) {
+	files := map[string]string{
+		"0000000100-0000000000000100a-0000000000000099a-98-a": "payload",
+		"0000000100-0000000000000100a-0000000000000099a-98-b": "payload",
+		"0000000100-0000000000000100a-0000000000000099a-98-c": "other",
+	}
+	oneBlocksStore := dstore.NewMockStore(nil)
+	var opened []string
+	oneBlocksStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		opened = append(opened, name)
+		return ioutil.NopCloser(strings.NewReader(files[name])), nil
+	}
+	archiveStore := dstore.NewMockStore(nil)
+	hasher := testObjectHasher{
+		"0000000100-0000000000000100a-0000000000000099a-98-a": "h1",
+		"0000000100-0000000000000100a-0000000000000099a-98-b": "h1",
+		"0000000100-0000000000000100a-0000000000000099a-98-c": "h2",
+	}
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithArchiveStore(archiveStore), WithPayloadDedup(hasher)).(*DStoreIO)
+
+	obf := mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-a")
+	obf.Filenames["0000000100-0000000000000100a-0000000000000099a-98-b"] = true
+	obf.Filenames["0000000100-0000000000000100a-0000000000000099a-98-c"] = true
+	require.NoError(t, mio.archiveOneBlockFile(context.Background(), obf))
+
+	assert.NotContains(t, opened, "0000000100-0000000000000100a-0000000000000099a-98-b", "identical copy not downloaded")
+	for name, expected := range files {
+		reader, err := archiveStore.OpenObject(context.Background(), name)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(data), name)
+	}
+}
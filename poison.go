@@ -0,0 +1,279 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// DefaultPoisonRangeTimeout bounds each read and write of the poison range markers
+var DefaultPoisonRangeTimeout = 10 * time.Second
+
+// ErrNotPoisoned is returned by RetryPoisonRange for a bundle that is not a poison range
+var ErrNotPoisoned = errors.New("bundle is not a poison range")
+
+// PoisonRange is a bundle whose merge failed with a data error, recorded in the poison ranges store. It is isolated
+// once it failed MaxAttempts times.
+type PoisonRange struct {
+	BaseBlock          uint64    `json:"base_block"`
+	ExclusiveHighBlock uint64    `json:"exclusive_high_block"`
+	Attempts           int       `json:"attempts"`
+	LastError          string    `json:"last_error"`
+	Isolated           bool      `json:"isolated"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// BundleDataError is the error of a bundle that failed with a data error but is not isolated yet: the merger shuts
+// down on it, the bundle is attempted again on the next start
+type BundleDataError struct {
+	BaseBlock   uint64
+	Attempts    int
+	MaxAttempts int
+	Err         error
+}
+
+func (e *BundleDataError) Error() string {
+	return fmt.Sprintf("bundle %d failed with a data error (attempt %d of %d before isolating it): %s", e.BaseBlock, e.Attempts, e.MaxAttempts, e.Err)
+}
+
+func (e *BundleDataError) Unwrap() error {
+	return e.Err
+}
+
+// isDataError tells if the merge failed on the content of the bundle rather than on the stores being unavailable
+func isDataError(err error) bool {
+	if _, ok := FailedStoreDomain(err); ok {
+		return false
+	}
+	return !IsReadOnlyError(err) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded) &&
+		!errors.Is(err, ErrCircuitOpen) &&
+		!errors.Is(err, ErrBundleExists)
+}
+
+// WithPoisonRanges isolates a bundle whose merge failed with a data error (corrupt or undecodable one-block files)
+// `maxAttempts` times, across restarts, instead of blocking all progress: the merger shuts down deterministically with
+// a BundleDataError on each failed attempt, then skips the bundle once isolated, leaving a hole in the merged files.
+// The attempts are recorded in `store`, which must not be walked by the merger. The one-block files of an isolated
+// bundle and of the next one are never pruned, so that it can be merged again with RetryPoisonRange once the operator
+// fixed them. The merged watermark moves past an isolated bundle.
+func WithPoisonRanges(store dstore.Store, maxAttempts int) Option {
+	return func(m *Merger) {
+		m.poison = &poisonRanges{
+			store:       store,
+			maxAttempts: maxAttempts,
+			ranges:      make(map[uint64]*PoisonRange),
+			now:         func() time.Time { return m.clock.Now() },
+		}
+		m.bundler.poison = m.poison
+	}
+}
+
+type poisonRanges struct {
+	store       dstore.Store
+	maxAttempts int
+	bundleSize  uint64 // set by applySettings
+	now         func() time.Time
+	logger      *zap.Logger // set by applySettings
+
+	lock   sync.Mutex
+	ranges map[uint64]*PoisonRange
+}
+
+func poisonRangeFilename(baseBlock uint64) string {
+	return fileNameForBlocksBundle(baseBlock) + ".json"
+}
+
+// load reads the poison ranges recorded by the previous runs
+func (p *poisonRanges) load(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultPoisonRangeTimeout)
+	defer cancel()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var isolated uint64
+	defer func() { metrics.PoisonRanges.SetUint64(isolated) }()
+	return p.store.Walk(ctx, "", func(filename string) error {
+		if !strings.HasSuffix(filename, ".json") {
+			return nil
+		}
+		reader, err := p.store.OpenObject(ctx, filename)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		poison := &PoisonRange{}
+		if err := json.Unmarshal(data, poison); err != nil {
+			return fmt.Errorf("decoding poison range %q: %w", filename, err)
+		}
+		p.ranges[poison.BaseBlock] = poison
+		if poison.Isolated {
+			isolated++
+			p.logger.Warn("bundle isolated as a poison range, skipping it", zap.Uint64("base_block", poison.BaseBlock), zap.String("last_error", poison.LastError))
+		}
+		return nil
+	})
+}
+
+// isolated tells if the bundle at baseBlock is skipped, nil-safe
+func (p *poisonRanges) isolated(baseBlock uint64) bool {
+	if p == nil {
+		return false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	poison, ok := p.ranges[baseBlock]
+	return ok && poison.Isolated
+}
+
+// keeps tells if the one-block file of block `num` must not be pruned: it belongs to an isolated bundle, or to the
+// bundle after it whose blocks complete it, nil-safe
+func (p *poisonRanges) keeps(num uint64) bool {
+	if p == nil {
+		return false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, poison := range p.ranges {
+		if poison.Isolated && num >= poison.BaseBlock && num < poison.ExclusiveHighBlock+p.bundleSize {
+			return true
+		}
+	}
+	return false
+}
+
+// failed records a failed merge of the bundle at baseBlock. It returns nil once the bundle is isolated, the error to
+// shut down on otherwise.
+func (p *poisonRanges) failed(baseBlock uint64, err error) error {
+	if p == nil || !isDataError(err) {
+		return err
+	}
+
+	p.lock.Lock()
+	poison, ok := p.ranges[baseBlock]
+	if !ok {
+		poison = &PoisonRange{BaseBlock: baseBlock, ExclusiveHighBlock: baseBlock + p.bundleSize}
+		p.ranges[baseBlock] = poison
+	}
+	poison.Attempts++
+	poison.LastError = err.Error()
+	poison.UpdatedAt = p.now()
+	poison.Isolated = poison.Attempts >= p.maxAttempts
+	record := *poison
+	p.lock.Unlock()
+
+	if writeErr := p.write(&record); writeErr != nil {
+		// without the record, the attempts would restart from zero on the next start
+		return fmt.Errorf("recording failed attempt of bundle %d: %w (merge error: %s)", baseBlock, writeErr, err)
+	}
+	if !record.Isolated {
+		return &BundleDataError{BaseBlock: baseBlock, Attempts: record.Attempts, MaxAttempts: p.maxAttempts, Err: err}
+	}
+	metrics.PoisonRanges.Inc()
+	p.logger.Error("bundle isolated as a poison range after repeated data errors, skipping it until it is retried",
+		zap.Uint64("base_block", baseBlock),
+		zap.Int("attempts", record.Attempts),
+		zap.Error(err),
+	)
+	return nil
+}
+
+func (p *poisonRanges) write(poison *PoisonRange) error {
+	data, err := json.Marshal(poison)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultPoisonRangeTimeout)
+	defer cancel()
+	return p.store.WriteObject(ctx, poisonRangeFilename(poison.BaseBlock), bytes.NewReader(data))
+}
+
+// list returns the recorded poison ranges by base block, nil-safe
+func (p *poisonRanges) list() (out []*PoisonRange) {
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, poison := range p.ranges {
+		copied := *poison
+		out = append(out, &copied)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].BaseBlock < out[j].BaseBlock })
+	return out
+}
+
+// PoisonRanges returns the bundles that failed with a data error, isolated or not
+func (m *Merger) PoisonRanges() []*PoisonRange {
+	return m.poison.list()
+}
+
+// RetryPoisonRange forgets the failed attempts of the bundle at baseBlock and reloads the bundler, so that the bundle
+// is merged again: to call once the operator fixed its one-block files.
+func (m *Merger) RetryPoisonRange(ctx context.Context, baseBlock uint64) error {
+	if m.poison == nil {
+		return ErrNotPoisoned
+	}
+	m.poison.lock.Lock()
+	poison, ok := m.poison.ranges[baseBlock]
+	m.poison.lock.Unlock()
+	if !ok {
+		return ErrNotPoisoned
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultPoisonRangeTimeout)
+	defer cancel()
+	if err := m.poison.store.DeleteObject(ctx, poisonRangeFilename(baseBlock)); err != nil && !errors.Is(err, dstore.ErrNotFound) {
+		return fmt.Errorf("deleting poison range %d: %w", baseBlock, err)
+	}
+
+	m.poison.lock.Lock()
+	delete(m.poison.ranges, baseBlock)
+	m.poison.lock.Unlock()
+	if poison.Isolated {
+		metrics.PoisonRanges.Dec()
+	}
+	m.logger.Info("retrying poison range", zap.Uint64("base_block", baseBlock), zap.Int("attempts", poison.Attempts))
+	m.Reload()
+	return nil
+}
+
+// skipPoisonRanges moves `base`, returned by NextBundle, past the isolated bundles: the hole they leave in the merged
+// files is expected
+func (m *Merger) skipPoisonRanges(ctx context.Context, base uint64, lib bstream.BlockRef, err error) (uint64, bstream.BlockRef, error) {
+	for m.poison.isolated(base) && (err == nil || errors.Is(err, ErrHoleFound)) {
+		base, lib, err = m.io.NextBundle(ctx, base+m.bundler.bundleSize)
+	}
+	return base, lib, err
+}
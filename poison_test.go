@@ -0,0 +1,63 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoisonRanges(t *testing.T) {
+	store, err := dstore.NewSimpleStore(t.TempDir())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var mergeAttempts int
+	start := func() *Merger {
+		m := NewMerger(testLogger, "", &TestMergerIO{
+			NextBundleFunc: func(_ context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+				return lowestBaseBlock, nil, nil
+			},
+			MergeAndStoreFunc: func(_ context.Context, _ uint64, _ []*bstream.OneBlockFile) error {
+				mergeAttempts++
+				return errors.New("cannot decode block")
+			},
+		}, 100, 2, 100, time.Second, time.Second, 0, WithPoisonRanges(store, 2))
+		require.NoError(t, m.poison.load(ctx))
+		return m
+	}
+	bundle := &pendingBundle{baseBlockNum: 100, blocks: []*bstream.OneBlockFile{block100, block101}}
+
+	m := start()
+	var dataErr *BundleDataError
+	require.ErrorAs(t, m.bundler.mergeBundle(bundle), &dataErr, "shuts down on the first attempt")
+	assert.Equal(t, 1, dataErr.Attempts)
+
+	m = start()
+	require.NoError(t, m.bundler.mergeBundle(bundle), "isolated on the last attempt")
+	require.Len(t, m.PoisonRanges(), 1)
+	assert.True(t, m.PoisonRanges()[0].Isolated)
+	assert.Equal(t, "cannot decode block", m.PoisonRanges()[0].LastError)
+
+	m = start()
+	require.NoError(t, m.bundler.mergeBundle(bundle))
+	assert.Equal(t, 2, mergeAttempts, "skipped once isolated")
+	assert.True(t, m.poison.keeps(103), "the next bundle completes it")
+	assert.False(t, m.poison.keeps(104))
+	base, _, err := m.skipPoisonRanges(ctx, 100, nil, ErrHoleFound)
+	require.NoError(t, err)
+	assert.EqualValues(t, 102, base)
+
+	storeErr := &StoreDomainError{Domain: StoreDomainDestination, Err: errors.New("unavailable")}
+	assert.Equal(t, storeErr, m.poison.failed(102, storeErr), "store outages are not data errors")
+
+	require.NoError(t, m.RetryPoisonRange(ctx, 100))
+	assert.Empty(t, m.PoisonRanges())
+	assert.ErrorIs(t, m.RetryPoisonRange(ctx, 100), ErrNotPoisoned)
+	assert.Empty(t, start().PoisonRanges(), "forgotten across restarts")
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"sync"
+
+	"github.com/streamingfast/bstream"
+)
+
+var DefaultPrefetchByteBudget uint64 = 512 * 1024 * 1024
+
+// WithPrefetch sets how many one-block files are downloaded concurrently before merging a bundle,
+// and how many bytes of block data may be held in memory by that prefetch (0 means no limit).
+func WithPrefetch(concurrency int, byteBudget uint64) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.prefetchConcurrency = concurrency
+		s.prefetchByteBudget = byteBudget
+	}
+}
+
+// WithBundleReadAhead sets how many one-block files are downloaded ahead while writing a merged file, for the ones
+// left out of the prefetch (ParallelOneBlockDownload by default)
+func WithBundleReadAhead(concurrency int) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.readAhead = concurrency
+	}
+}
+
+// PrefetchData memoizes the data of the oneBlockFiles (see OneBlockFile.Data), downloading up to `concurrency`
+// of them at once. Once `byteBudget` bytes of data are memoized (0 means no limit), the remaining files are left
+// to be downloaded lazily. It stops at the first download error or when ctx is done.
+func PrefetchData(ctx context.Context, oneBlockFiles []*bstream.OneBlockFile, downloader bstream.OneBlockDownloaderFunc, concurrency int, byteBudget uint64) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lock sync.Mutex
+	var memoized uint64
+	var firstErr error
+	overBudget := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return byteBudget != 0 && memoized >= byteBudget
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, obf := range oneBlockFiles {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if overBudget() {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(obf *bstream.OneBlockFile) {
+			defer func() { <-sem; wg.Done() }()
+			data, err := obf.Data(ctx, downloader)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			memoized += uint64(len(data))
+		}(obf)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,80 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPrefetchFiles(count int) (out []*bstream.OneBlockFile) {
+	for i := 0; i < count; i++ {
+		out = append(out, mustNewOneBlockFile(fmt.Sprintf("%010d-%016x-%016x-%d-suffix", 100+i, 100+i, 99+i, 98+i)))
+	}
+	return
+}
+
+func TestPrefetchData(t *testing.T) {
+	files := testPrefetchFiles(10)
+
+	var running, maxRunning int32
+	downloader := func(ctx context.Context, obf *bstream.OneBlockFile) ([]byte, error) {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return []byte("0123456789"), nil
+	}
+
+	require.NoError(t, PrefetchData(context.Background(), files, downloader, 3, 0))
+	assert.EqualValues(t, 3, maxRunning)
+	for _, obf := range files {
+		assert.Len(t, obf.MemoizeData, 10)
+	}
+}
+
+func TestPrefetchData_ByteBudget(t *testing.T) {
+	files := testPrefetchFiles(10)
+	downloader := func(ctx context.Context, obf *bstream.OneBlockFile) ([]byte, error) {
+		return []byte("0123456789"), nil
+	}
+
+	require.NoError(t, PrefetchData(context.Background(), files, downloader, 1, 35))
+	var memoized int
+	for _, obf := range files {
+		if obf.MemoizeData != nil {
+			memoized++
+		}
+	}
+	assert.Equal(t, 4, memoized)
+}
+
+func TestPrefetchData_Error(t *testing.T) {
+	files := testPrefetchFiles(10)
+	var lock sync.Mutex
+	var downloaded int
+	downloader := func(ctx context.Context, obf *bstream.OneBlockFile) ([]byte, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		downloaded++
+		if obf.Num == 102 {
+			return nil, fmt.Errorf("download failed")
+		}
+		return []byte("data"), nil
+	}
+
+	err := PrefetchData(context.Background(), files, downloader, 1, 0)
+	require.EqualError(t, err, "download failed")
+	assert.Less(t, downloaded, 10)
+}
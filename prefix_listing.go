@@ -0,0 +1,80 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+
+	"github.com/streamingfast/dstore"
+)
+
+// WithPrefixBoundedListing lists the one-block files at or above the start of each walk through block-number prefixes,
+// instead of listing the store from its first file and skipping the files below the start, as stores without native
+// start offset do (S3, local). A deletion backlog below the current bundle is then never listed. Each walk makes up
+// to 82 prefix listings, most of them empty, so it only pays off on stores holding many files below the bundle.
+func WithPrefixBoundedListing() DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.prefixBoundedListing = true
+	}
+}
+
+// walkFromByPrefixes calls `f` with the files of `store` at or above `startName`, in order. `startName` must start
+// with a 10-digit block number, as one-block filenames, or the store is walked from `startName` as usual. The files
+// sharing the first 9 digits of the start are listed and filtered, then every greater prefix of each length, from the
+// longest: `000012346` to `000012349`, then `00001235` to `00001239`, up to `1` to `9`.
+func walkFromByPrefixes(ctx context.Context, store dstore.Store, startName string, f func(filename string) error) error {
+	if !isBlockNumPrefix(startName) {
+		return store.WalkFrom(ctx, "", startName, f)
+	}
+
+	var stopped bool
+	walk := func(prefix string, filter bool) error {
+		return store.Walk(ctx, prefix, func(filename string) error {
+			if filter && filename < startName {
+				return nil
+			}
+			err := f(filename)
+			if err == dstore.StopIteration {
+				stopped = true // swallowed by the store, the next prefixes must not be walked
+			}
+			return err
+		})
+	}
+
+	if err := walk(startName[:9], true); err != nil || stopped {
+		return err
+	}
+	for length := 9; length >= 1; length-- {
+		parent := startName[:length-1]
+		for digit := startName[length-1] + 1; digit <= '9'; digit++ {
+			if err := walk(parent+string(digit), false); err != nil || stopped {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isBlockNumPrefix(name string) bool {
+	if len(name) < 10 {
+		return false
+	}
+	for i := 0; i < 10; i++ {
+		if name[i] < '0' || name[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
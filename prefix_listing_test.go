@@ -0,0 +1,72 @@
+package merger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkFromByPrefixes(t *testing.T) {
+	files := dstore.NewMockStore(nil)
+	for _, name := range []string{
+		"0000000099-99a-98a-97-suffix",
+		"0000012344-12344a-12343a-12342-suffix",
+		"0000012345-12345a-12344a-12343-suffix",
+		"0000012350-12350a-12349a-12348-suffix",
+		"0000013000-13000a-12999a-12998-suffix",
+		"0001000000-1000000a-999999a-999998-suffix",
+	} {
+		files.SetFile(name, []byte{})
+	}
+	var prefixes []string
+	store := dstore.NewMockStore(nil)
+	store.WalkFunc = func(ctx context.Context, prefix string, f func(filename string) error) error {
+		prefixes = append(prefixes, prefix)
+		return files.Walk(ctx, prefix, f)
+	}
+
+	var walked []string
+	collect := func(filename string) error {
+		walked = append(walked, filename)
+		return nil
+	}
+	require.NoError(t, walkFromByPrefixes(context.Background(), store, "0000012345", collect))
+	assert.Equal(t, []string{
+		"0000012345-12345a-12344a-12343-suffix",
+		"0000012350-12350a-12349a-12348-suffix",
+		"0000013000-13000a-12999a-12998-suffix",
+		"0001000000-1000000a-999999a-999998-suffix",
+	}, walked)
+	assert.Equal(t, []string{"000001234", "000001235", "000001236"}, prefixes[:3])
+	assert.Equal(t, "9", prefixes[len(prefixes)-1])
+	assert.Len(t, prefixes, 1+5+6+7+8+9*5)
+
+	walked = nil
+	require.NoError(t, walkFromByPrefixes(context.Background(), store, "0000012345", func(filename string) error {
+		walked = append(walked, filename)
+		return dstore.StopIteration
+	}))
+	assert.Len(t, walked, 1, "stopped by the callback")
+
+	walked = nil
+	require.NoError(t, walkFromByPrefixes(context.Background(), store, "", collect))
+	assert.Len(t, walked, 6, "no block number, walked as usual")
+}
+
+func TestDStoreIO_PrefixBoundedListing(t *testing.T) {
+	oneBlocksStore := dstore.NewMockStore(nil)
+	oneBlocksStore.SetFile("0000000099-0000000000000099a-0000000000000098a-97-suffix", []byte{})
+	oneBlocksStore.SetFile("0000000100-0000000000000100a-0000000000000099a-98-suffix", []byte{})
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, dstore.NewMockStore(nil), nil, 0, 0, 100, WithPrefixBoundedListing()).(*DStoreIO)
+
+	var walked []string
+	require.NoError(t, mio.WalkOneBlockFiles(context.Background(), 100, func(obf *bstream.OneBlockFile) error {
+		walked = append(walked, obf.CanonicalName)
+		return nil
+	}))
+	assert.Equal(t, []string{"0000000100-0000000000000100a-0000000000000099a-98"}, walked)
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// PressureCheckInterval is how often the pressure signal is checked again while work is deferred
+var PressureCheckInterval = time.Second
+
+// PressureSignal tells if the serving stack co-located with the merger is saturated
+type PressureSignal interface {
+	UnderPressure(ctx context.Context) (bool, error)
+}
+
+// HTTPPressureSignal is under pressure when its URL does not answer with a 2xx status
+type HTTPPressureSignal struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewHTTPPressureSignal(url string, timeout time.Duration) *HTTPPressureSignal {
+	return &HTTPPressureSignal{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPPressureSignal) UnderPressure(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 200 || resp.StatusCode > 299, nil
+}
+
+// FilePressureSignal is under pressure while its file exists
+type FilePressureSignal struct {
+	Path string
+}
+
+func (s *FilePressureSignal) UnderPressure(_ context.Context) (bool, error) {
+	_, err := os.Stat(s.Path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("checking pressure file: %w", err)
+	}
+	return true, nil
+}
+
+// WithPressureSignal defers the download and upload of each bundle (and the download of merged files) while `signal` reports the co-located serving stack
+// as saturated, for at most `maxDefer` (0 waits as long as needed). A signal that cannot be checked does not defer the work.
+func WithPressureSignal(signal PressureSignal, maxDefer time.Duration) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.pressureSignal = signal
+		s.pressureMaxDefer = maxDefer
+	}
+}
+
+// waitForPressure is called before a heavy phase, it returns once the signal is clear
+func (s *DStoreIO) waitForPressure(ctx context.Context, phase string) error {
+	if s.pressureSignal == nil {
+		return nil
+	}
+	start := time.Now()
+	deferred := false
+	for {
+		underPressure, err := s.pressureSignal.UnderPressure(ctx)
+		if err != nil {
+			s.logger.Debug("cannot check pressure signal, not deferring", zap.String("phase", phase), zap.Error(err))
+			underPressure = false
+		}
+		if !underPressure {
+			break
+		}
+		if s.pressureMaxDefer != 0 && time.Since(start) >= s.pressureMaxDefer {
+			s.logger.Warn("serving stack still under pressure, not deferring any longer", zap.String("phase", phase), zap.Duration("deferred", time.Since(start)))
+			break
+		}
+		if !deferred {
+			deferred = true
+			metrics.PressureDeferrals.Inc(phase)
+			s.logger.Info("serving stack under pressure, deferring", zap.String("phase", phase))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(PressureCheckInterval):
+		}
+	}
+	if deferred {
+		s.logger.Info("resuming deferred work", zap.String("phase", phase), zap.Duration("deferred", time.Since(start)))
+	}
+	return nil
+}
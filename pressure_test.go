@@ -0,0 +1,77 @@
+package merger
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPPressureSignal(t *testing.T) {
+	var status int32 = http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	signal := NewHTTPPressureSignal(srv.URL, time.Second)
+	underPressure, err := signal.UnderPressure(context.Background())
+	require.NoError(t, err)
+	assert.True(t, underPressure)
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	underPressure, err = signal.UnderPressure(context.Background())
+	require.NoError(t, err)
+	assert.False(t, underPressure)
+}
+
+func TestFilePressureSignal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saturated")
+	signal := &FilePressureSignal{Path: path}
+
+	underPressure, err := signal.UnderPressure(context.Background())
+	require.NoError(t, err)
+	assert.False(t, underPressure)
+
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	underPressure, err = signal.UnderPressure(context.Background())
+	require.NoError(t, err)
+	assert.True(t, underPressure)
+}
+
+type countingPressureSignal struct {
+	checks   int
+	pressure int // number of checks reporting pressure
+}
+
+func (s *countingPressureSignal) UnderPressure(_ context.Context) (bool, error) {
+	s.checks++
+	return s.checks <= s.pressure, nil
+}
+
+func TestDStoreIO_WaitForPressure(t *testing.T) {
+	defer func(v time.Duration) { PressureCheckInterval = v }(PressureCheckInterval)
+	PressureCheckInterval = time.Millisecond
+
+	signal := &countingPressureSignal{pressure: 2}
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), dstore.NewMockStore(nil), nil, 0, 0, 100, WithPressureSignal(signal, 0)).(*DStoreIO)
+	require.NoError(t, mio.waitForPressure(context.Background(), "merge"))
+	assert.Equal(t, 3, signal.checks)
+
+	signal = &countingPressureSignal{pressure: 1000}
+	mio = NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), dstore.NewMockStore(nil), nil, 0, 0, 100, WithPressureSignal(signal, 20*time.Millisecond)).(*DStoreIO)
+	require.NoError(t, mio.waitForPressure(context.Background(), "merge"), "gives up deferring after max defer")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mio = NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), dstore.NewMockStore(nil), nil, 0, 0, 100, WithPressureSignal(&countingPressureSignal{pressure: 1000}, 0)).(*DStoreIO)
+	assert.ErrorIs(t, mio.waitForPressure(ctx, "merge"), context.Canceled)
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/bstream/forkable"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ProgressServiceName is the gRPC progress service, described in proto/merger/progress/v1/progress.proto. Its
+// Progress method takes a google.protobuf.Empty and returns the Progress (same as the HTTP `/progress`) as a
+// google.protobuf.Struct. It is served with the other gRPC services.
+const ProgressServiceName = "merger.progress.v1.Progress"
+
+// Progress is where the merger is at, for dashboards
+type Progress struct {
+	State MergerState `json:"state"`
+	// InclusiveLowBlock and ExclusiveHighBlock are the bounds of the bundle being collected
+	InclusiveLowBlock  uint64 `json:"inclusive_low_block"`
+	ExclusiveHighBlock uint64 `json:"exclusive_high_block"`
+	// LongestChainLength is the number of blocks of the longest chain of the forkdb, from the last irreversible block
+	LongestChainLength int `json:"longest_chain_length"`
+	// FilesSeen is the number of one-block files walked by the last cycle
+	FilesSeen     int        `json:"files_seen"`
+	LastMergeTime *time.Time `json:"last_merge_time,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// activityTracker keeps the outcome of the last cycle, merge and error for the Progress
+type activityTracker struct {
+	sync.Mutex
+	filesSeen   int
+	lastMergeAt time.Time
+	lastError   error
+	lastErrorAt time.Time
+}
+
+func (a *activityTracker) cycleDone(filesSeen int) {
+	a.Lock()
+	defer a.Unlock()
+	a.filesSeen = filesSeen
+}
+
+func (a *activityTracker) merged(now time.Time) {
+	a.Lock()
+	defer a.Unlock()
+	a.lastMergeAt = now
+}
+
+func (a *activityTracker) failed(err error, now time.Time) {
+	a.Lock()
+	defer a.Unlock()
+	a.lastError = err
+	a.lastErrorAt = now
+}
+
+// WithProgressListenAddr serves the Progress as JSON on `/progress` of its own HTTP listener, read-only unlike the
+// admin API, so that it can be exposed to the dashboards. Disabled if empty.
+func WithProgressListenAddr(addr string) Option {
+	return func(m *Merger) {
+		m.progressListenAddr = addr
+	}
+}
+
+// Progress returns where the merger is at
+func (m *Merger) Progress() *Progress {
+	b := m.bundler
+	b.Lock()
+	progress := &Progress{
+		State:              m.State(),
+		InclusiveLowBlock:  b.baseBlockNum,
+		ExclusiveHighBlock: b.baseBlockNum + b.bundleSize,
+	}
+	fork := b.forkable
+	b.Unlock()
+	progress.LongestChainLength = longestChainLength(fork)
+
+	m.activity.Lock()
+	defer m.activity.Unlock()
+	progress.FilesSeen = m.activity.filesSeen
+	if !m.activity.lastMergeAt.IsZero() {
+		lastMergeAt := m.activity.lastMergeAt
+		progress.LastMergeTime = &lastMergeAt
+	}
+	if m.activity.lastError != nil {
+		lastErrorAt := m.activity.lastErrorAt
+		progress.LastError = m.activity.lastError.Error()
+		progress.LastErrorTime = &lastErrorAt
+	}
+	return progress
+}
+
+// longestChainLength is 0 until the forkdb has a last irreversible block
+func longestChainLength(fork *forkable.Forkable) (length int) {
+	fork.CallWithBlocksFromNum(fork.LowestBlockNum(), func(blocks []*bstream.PreprocessedBlock) {
+		length = len(blocks)
+	}, false)
+	return length
+}
+
+func (m *Merger) progressHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Progress())
+}
+
+func (m *Merger) startProgressServer() {
+	if m.progressListenAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/progress", m.progressHandler)
+	srv := &http.Server{Addr: m.progressListenAddr, Handler: mux}
+	m.OnTerminated(func(_ error) {
+		srv.Shutdown(context.Background())
+	})
+
+	m.logger.Info("starting progress server", zap.String("listen_addr", m.progressListenAddr))
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.Shutdown(err)
+		}
+	}()
+}
+
+var progressServiceDesc = grpc.ServiceDesc{
+	ServiceName: ProgressServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Progress",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &emptypb.Empty{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				m := srv.(*Merger)
+				if interceptor == nil {
+					return m.progressStruct()
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ProgressServiceName + "/Progress"}
+				return interceptor(ctx, in, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+					return m.progressStruct()
+				})
+			},
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "merger/progress/v1/progress.proto",
+}
+
+func (m *Merger) progressStruct() (*structpb.Struct, error) {
+	data, err := json.Marshal(m.Progress())
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
+
+// ProgressClient calls the gRPC progress service of a merger
+type ProgressClient struct {
+	conn grpc.ClientConnInterface
+}
+
+func NewProgressClient(conn grpc.ClientConnInterface) *ProgressClient {
+	return &ProgressClient{conn: conn}
+}
+
+func (c *ProgressClient) Progress(ctx context.Context, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	out := &structpb.Struct{}
+	if err := c.conn.Invoke(ctx, "/"+ProgressServiceName+"/Progress", &emptypb.Empty{}, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
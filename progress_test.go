@@ -0,0 +1,50 @@
+package merger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerger_Progress(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m.clock = clock
+
+	progress := m.Progress()
+	assert.EqualValues(t, 100, progress.InclusiveLowBlock)
+	assert.EqualValues(t, 200, progress.ExclusiveHighBlock)
+	assert.Zero(t, progress.LongestChainLength)
+	assert.Nil(t, progress.LastMergeTime)
+	assert.Empty(t, progress.LastError)
+
+	for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102} {
+		require.NoError(t, m.bundler.HandleBlockFile(obf))
+	}
+	m.activity.cycleDone(5)
+	m.bundler.onMerged(0, nil)
+	m.handleError(fmt.Errorf("walking: timeout"))
+
+	rec := httptest.NewRecorder()
+	m.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/progress", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	progress = &Progress{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), progress))
+	assert.NotZero(t, progress.LongestChainLength)
+	assert.Equal(t, 5, progress.FilesSeen)
+	require.NotNil(t, progress.LastMergeTime)
+	assert.True(t, clock.now.Equal(*progress.LastMergeTime))
+	assert.Equal(t, "walking: timeout", progress.LastError)
+
+	fields, err := m.progressStruct()
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, fields.Fields["inclusive_low_block"].GetNumberValue())
+	assert.Equal(t, "walking: timeout", fields.Fields["last_error"].GetStringValue())
+}
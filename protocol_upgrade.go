@@ -0,0 +1,168 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// ProtocolUpgrade is a block height from which the chain encodes its blocks differently
+type ProtocolUpgrade struct {
+	Name   string
+	Height uint64
+}
+
+// ParseProtocolUpgrade parses a `<name>:<height>` protocol upgrade
+func ParseProtocolUpgrade(spec string) (ProtocolUpgrade, error) {
+	idx := strings.LastIndexByte(spec, ':')
+	if idx <= 0 {
+		return ProtocolUpgrade{}, fmt.Errorf("invalid protocol upgrade %q, expected <name>:<height>", spec)
+	}
+	height, err := strconv.ParseUint(spec[idx+1:], 10, 64)
+	if err != nil {
+		return ProtocolUpgrade{}, fmt.Errorf("invalid protocol upgrade height in %q: %w", spec, err)
+	}
+	return ProtocolUpgrade{Name: spec[:idx], Height: height}, nil
+}
+
+// Side of a protocol upgrade, in a ProtocolUpgradeTag
+const (
+	ProtocolUpgradeBefore = "before"
+	ProtocolUpgradeAfter  = "after"
+)
+
+// ProtocolUpgradeTag is written next to the merged files that end right before, or start at, a protocol upgrade, so
+// that their decoders can select the schema of the blocks
+type ProtocolUpgradeTag struct {
+	Upgrade            string `json:"upgrade"`
+	Height             uint64 `json:"height"`
+	Side               string `json:"side"`
+	InclusiveLowBlock  uint64 `json:"inclusive_low_block"`
+	ExclusiveHighBlock uint64 `json:"exclusive_high_block"`
+}
+
+// WithProtocolUpgrades forces a merged file boundary at the height of each protocol upgrade. The bundle holding an
+// upgrade not aligned on the bundle size is split: `<base block>` holds the blocks before the upgrade and
+// `<upgrade height>` the blocks from it, so that each merged file has a single codec. The merged files on
+// either side of an upgrade are tagged with their ProtocolUpgradeTags in `<merged file name>.json` in `tagsStore` when
+// it is not nil. The tags store must not be inside the merged blocks store, whose listing only expects merged files.
+func WithProtocolUpgrades(tagsStore dstore.Store, upgrades ...ProtocolUpgrade) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.protocolUpgrades = append(s.protocolUpgrades, upgrades...)
+		sort.Slice(s.protocolUpgrades, func(i, j int) bool { return s.protocolUpgrades[i].Height < s.protocolUpgrades[j].Height })
+		s.protocolUpgradeTags = tagsStore
+	}
+}
+
+// upgradesWithin returns the protocol upgrades splitting the bundle at `baseBlock`, in order
+func (s *DStoreIO) upgradesWithin(baseBlock uint64) (out []ProtocolUpgrade) {
+	for _, upgrade := range s.protocolUpgrades {
+		if upgrade.Height > baseBlock && upgrade.Height < baseBlock+s.bundleSize {
+			out = append(out, upgrade)
+		}
+	}
+	return
+}
+
+// isUpgradeSplit tells if `num` is the base of a merged file following a protocol upgrade inside its bundle
+func (s *DStoreIO) isUpgradeSplit(num uint64) bool {
+	if num%s.bundleSize == 0 {
+		return false
+	}
+	for _, upgrade := range s.protocolUpgrades {
+		if upgrade.Height == num {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeAcrossUpgrades merges the bundle at `baseBlock` as one merged file per side of each of the `upgrades`
+func (s *DStoreIO) mergeAcrossUpgrades(ctx context.Context, baseBlock uint64, upgrades []ProtocolUpgrade, oneBlockFiles []*bstream.OneBlockFile) error {
+	low := baseBlock
+	for i := 0; i <= len(upgrades); i++ {
+		high := baseBlock + s.bundleSize
+		if i < len(upgrades) {
+			high = upgrades[i].Height
+			s.logger.Info("splitting bundle at protocol upgrade",
+				zap.Uint64("base_block", baseBlock),
+				zap.String("upgrade", upgrades[i].Name),
+				zap.Uint64("upgrade_height", high),
+			)
+		}
+		var part []*bstream.OneBlockFile
+		for _, obf := range oneBlockFiles {
+			if obf.Num < high {
+				part = append(part, obf)
+			}
+		}
+		if err := s.mergeAndStoreRange(ctx, low, high, part); err != nil {
+			return err
+		}
+		low = high
+	}
+	return nil
+}
+
+// tagUpgradeBoundary writes the ProtocolUpgradeTags of the merged file holding [inclusiveLowBlock, exclusiveHighBlock)
+// when it ends right before, or starts at, protocol upgrades
+func (s *DStoreIO) tagUpgradeBoundary(ctx context.Context, inclusiveLowBlock, exclusiveHighBlock uint64) {
+	if s.protocolUpgradeTags == nil {
+		return
+	}
+	var tags []*ProtocolUpgradeTag
+	for _, upgrade := range s.protocolUpgrades {
+		tag := &ProtocolUpgradeTag{
+			Upgrade:            upgrade.Name,
+			Height:             upgrade.Height,
+			InclusiveLowBlock:  inclusiveLowBlock,
+			ExclusiveHighBlock: exclusiveHighBlock,
+		}
+		switch upgrade.Height {
+		case exclusiveHighBlock:
+			tag.Side = ProtocolUpgradeBefore
+		case inclusiveLowBlock:
+			tag.Side = ProtocolUpgradeAfter
+		default:
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return
+	}
+
+	filename := fileNameForBlocksBundle(inclusiveLowBlock) + ".json"
+	data, err := json.Marshal(tags)
+	if err != nil {
+		s.logger.Warn("cannot encode protocol upgrade tags", zap.String("filename", filename), zap.Error(err))
+		return
+	}
+	writeCtx, cancel := context.WithTimeout(ctx, WriteObjectTimeout)
+	defer cancel()
+	if err := s.protocolUpgradeTags.WriteObject(writeCtx, filename, bytes.NewReader(data)); err != nil {
+		s.logger.Warn("cannot write protocol upgrade tags", zap.String("filename", filename), zap.Error(err))
+	}
+}
@@ -0,0 +1,103 @@
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProtocolUpgrade(t *testing.T) {
+	upgrade, err := ParseProtocolUpgrade("shanghai:150")
+	require.NoError(t, err)
+	assert.Equal(t, ProtocolUpgrade{Name: "shanghai", Height: 150}, upgrade)
+
+	_, err = ParseProtocolUpgrade("150")
+	assert.Error(t, err)
+	_, err = ParseProtocolUpgrade("shanghai:abc")
+	assert.Error(t, err)
+}
+
+func readUpgradeTags(t *testing.T, store dstore.Store, filename string) []*ProtocolUpgradeTag {
+	t.Helper()
+	reader, err := store.OpenObject(context.Background(), filename)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	var tags []*ProtocolUpgradeTag
+	require.NoError(t, json.Unmarshal(data, &tags))
+	return tags
+}
+
+func TestMergerIO_SplitAtProtocolUpgrade(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = dbinHeaderLen
+	oneBlocksStore := dstore.NewMockStore(nil)
+	oneBlocksStore.SetFile("0000000100-0000000000000100a-0000000000000099a-98-suffix", dbinData("ETH", "01", 0x1))
+	oneBlocksStore.SetFile("0000000101-0000000000000101a-0000000000000100a-99-suffix", dbinData("ETH", "01", 0x2))
+	oneBlocksStore.SetFile("0000000102-0000000000000102a-0000000000000101a-100-suffix", dbinData("ETH", "02", 0x3))
+	var written []string
+	mergedBlocksStore := dstore.NewMockStore(func(base string, f io.Reader) error {
+		written = append(written, base)
+		return nil
+	})
+	tagsStore := dstore.NewMockStore(nil)
+
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, mergedBlocksStore, nil, 0, 0, 100,
+		WithProtocolUpgrades(tagsStore, ProtocolUpgrade{Name: "v2", Height: 102}),
+	)
+	require.NoError(t, mio.MergeAndStore(context.Background(), 100, []*bstream.OneBlockFile{
+		mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-suffix"),
+		mustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-suffix"),
+		mustNewOneBlockFile("0000000102-0000000000000102a-0000000000000101a-100-suffix"),
+	}), "each merged file has a single codec")
+	assert.Equal(t, []string{"0000000100", "0000000102"}, written)
+
+	assert.Equal(t, []*ProtocolUpgradeTag{
+		{Upgrade: "v2", Height: 102, Side: ProtocolUpgradeBefore, InclusiveLowBlock: 100, ExclusiveHighBlock: 102},
+	}, readUpgradeTags(t, tagsStore, "0000000100.json"))
+	assert.Equal(t, []*ProtocolUpgradeTag{
+		{Upgrade: "v2", Height: 102, Side: ProtocolUpgradeAfter, InclusiveLowBlock: 102, ExclusiveHighBlock: 200},
+	}, readUpgradeTags(t, tagsStore, "0000000102.json"))
+}
+
+func TestMergerIO_TagAlignedProtocolUpgrade(t *testing.T) {
+	tagsStore := dstore.NewMockStore(nil)
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), dstore.NewMockStore(nil), nil, 0, 0, 100,
+		WithProtocolUpgrades(tagsStore, ProtocolUpgrade{Name: "v2", Height: 200}),
+	).(*DStoreIO)
+	assert.Empty(t, mio.upgradesWithin(100))
+	assert.Empty(t, mio.upgradesWithin(200))
+
+	mio.tagUpgradeBoundary(context.Background(), 100, 200)
+	mio.tagUpgradeBoundary(context.Background(), 200, 300)
+	mio.tagUpgradeBoundary(context.Background(), 300, 400)
+
+	assert.Equal(t, ProtocolUpgradeBefore, readUpgradeTags(t, tagsStore, "0000000100.json")[0].Side)
+	assert.Equal(t, ProtocolUpgradeAfter, readUpgradeTags(t, tagsStore, "0000000200.json")[0].Side)
+	exists, err := tagsStore.FileExists(context.Background(), "0000000300.json")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestDStoreIO_NextBundleAcrossProtocolUpgrade(t *testing.T) {
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	mergedBlocksStore.SetFile("0000000000", testMergedBundle(99))
+	mergedBlocksStore.SetFile("0000000100", testMergedBundle(149))
+	mergedBlocksStore.SetFile("0000000150", testMergedBundle(199))
+
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedBlocksStore, nil, 0, 0, 100,
+		WithProtocolUpgrades(nil, ProtocolUpgrade{Name: "v2", Height: 150}),
+	)
+
+	base, lib, err := mio.NextBundle(context.Background(), 0)
+	require.NoError(t, err)
+	assert.EqualValues(t, 200, base)
+	require.NotNil(t, lib)
+	assert.EqualValues(t, 199, lib.Num(), "the LIB is the last block of the bundle, in its second merged file")
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+)
+
+// ReaderLiveness is what the merger knows about the reader uploading one-block files with a given suffix
+type ReaderLiveness struct {
+	Suffix       string    `json:"suffix"`
+	HeadBlockNum uint64    `json:"head_block_num"`
+	HeadSeenAt   time.Time `json:"head_seen_at"`
+}
+
+// readersLiveness tracks the highest block uploaded by each reader (one-block file suffix), and when the merger first saw it.
+// A reader that stopped uploading shows up as a growing `merger_reader_head_age_seconds`.
+type readersLiveness struct {
+	sync.Mutex
+	readers map[string]*ReaderLiveness
+}
+
+func newReadersLiveness() *readersLiveness {
+	return &readersLiveness{readers: make(map[string]*ReaderLiveness)}
+}
+
+func (r *readersLiveness) observe(obf *bstream.OneBlockFile, now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	for filename := range obf.Filenames {
+		suffix := filename[strings.LastIndexByte(filename, '-')+1:]
+		reader, found := r.readers[suffix]
+		if !found {
+			reader = &ReaderLiveness{Suffix: suffix}
+			r.readers[suffix] = reader
+		}
+		if obf.Num > reader.HeadBlockNum {
+			reader.HeadBlockNum = obf.Num
+			reader.HeadSeenAt = now
+			metrics.ReaderHeadBlockNumber.SetUint64(obf.Num, suffix)
+		}
+	}
+}
+
+// updateAges is called after each walk of the one-block files
+func (r *readersLiveness) updateAges(now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	for suffix, reader := range r.readers {
+		metrics.ReaderHeadAge.SetFloat64(now.Sub(reader.HeadSeenAt).Seconds(), suffix)
+	}
+}
+
+// ReadersLiveness returns the head block seen for each one-block file suffix, sorted by suffix
+func (m *Merger) ReadersLiveness() (out []ReaderLiveness) {
+	m.readers.Lock()
+	defer m.readers.Unlock()
+	for _, reader := range m.readers.readers {
+		out = append(out, *reader)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Suffix < out[j].Suffix })
+	return
+}
@@ -0,0 +1,23 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadersLiveness(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m.readers.observe(mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-reader1"), t0)
+	m.readers.observe(mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-reader2"), t0)
+	m.readers.observe(mustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-reader1"), t0.Add(time.Second))
+	m.readers.observe(mustNewOneBlockFile("0000000099-0000000000000099a-0000000000000098a-97-reader2"), t0.Add(time.Second))
+
+	assert.Equal(t, []ReaderLiveness{
+		{Suffix: "reader1", HeadBlockNum: 101, HeadSeenAt: t0.Add(time.Second)},
+		{Suffix: "reader2", HeadBlockNum: 100, HeadSeenAt: t0},
+	}, m.ReadersLiveness())
+}
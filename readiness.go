@@ -0,0 +1,112 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/streamingfast/bstream"
+)
+
+// WithReadinessMaxDrift reports the merger NOT_SERVING (see Check) while its head drift, the time elapsed since the
+// block time of the last merged block, exceeds `maxDrift`, so that a merger falling behind is taken out of rotation.
+// The drift is unknown, and not enforced, until a bundle is merged with its block time known: from the timestamp in
+// the one-block filenames, or from the block data when it was downloaded before merging. A halted chain (see
+// ChainStateIdle) is not considered drifting.
+func WithReadinessMaxDrift(maxDrift time.Duration) Option {
+	return func(m *Merger) {
+		m.readinessMaxDrift = maxDrift
+	}
+}
+
+// WithLivenessTimeout reports the merger as not live (see IsLive and `/livez` on the admin server) when its main
+// loop did not start a cycle for `timeout`, e.g. waiting forever on a wedged merge, so that it can be restarted. It
+// must exceed the polling interval and the longest walk of the one-block files.
+func WithLivenessTimeout(timeout time.Duration) Option {
+	return func(m *Merger) {
+		m.livenessTimeout = timeout
+	}
+}
+
+// HeadDrift returns the time elapsed since the block time of the last merged block, false while unknown
+func (m *Merger) HeadDrift() (time.Duration, bool) {
+	blockTime := atomic.LoadInt64(&m.lastMergedBlockTime)
+	if blockTime == 0 {
+		return 0, false
+	}
+	return m.clock.Now().Sub(time.Unix(0, blockTime)), true
+}
+
+// drifting tells if the head drift exceeds the readiness threshold
+func (m *Merger) drifting() bool {
+	if m.readinessMaxDrift == 0 || m.ChainState() == ChainStateIdle {
+		return false
+	}
+	drift, ok := m.HeadDrift()
+	return ok && drift > m.readinessMaxDrift
+}
+
+// IsLive returns false when the main loop did not start a cycle within the liveness timeout, always true without
+// WithLivenessTimeout or before the merger runs
+func (m *Merger) IsLive() bool {
+	if m.livenessTimeout == 0 {
+		return true
+	}
+	lastCycle := atomic.LoadInt64(&m.lastCycleAt)
+	if lastCycle == 0 {
+		return true
+	}
+	return m.clock.Now().Sub(time.Unix(0, lastCycle)) <= m.livenessTimeout
+}
+
+func (m *Merger) cycleStarted(now time.Time) {
+	atomic.StoreInt64(&m.lastCycleAt, now.UnixNano())
+}
+
+// mergedBlockTime records the block time of the last merged block, if known
+func (m *Merger) mergedBlockTime(last *bstream.OneBlockFile) (time.Time, bool) {
+	blockTime, ok := oneBlockFileTime(last)
+	if ok {
+		atomic.StoreInt64(&m.lastMergedBlockTime, blockTime.UnixNano())
+	}
+	return blockTime, ok
+}
+
+// oneBlockFileTime reads the block time from the filename timestamp, or from the block data if memoized. It locks
+// `obf`, its data is memoized by the download goroutines
+func oneBlockFileTime(obf *bstream.OneBlockFile) (time.Time, bool) {
+	obf.Lock()
+	defer obf.Unlock()
+	for filename := range obf.Filenames {
+		if _, blockTime, _, _, _, _, err := parseOneBlockFilename(filename); err == nil && !blockTime.IsZero() {
+			return blockTime, true
+		}
+	}
+	if len(obf.MemoizeData) == 0 {
+		return time.Time{}, false
+	}
+	blockTime, err := readBlockTime(obf.MemoizeData)
+	return blockTime, err == nil
+}
+
+func (m *Merger) livezHandler(w http.ResponseWriter, _ *http.Request) {
+	if !m.IsLive() {
+		http.Error(w, "main loop stopped making progress", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
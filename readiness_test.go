@@ -0,0 +1,56 @@
+package merger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestMerger_ReadinessMaxDrift(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 10, 0, 0, time.UTC)}
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithReadinessMaxDrift(time.Minute))
+	m.clock = clock
+
+	_, ok := m.HeadDrift()
+	assert.False(t, ok)
+	assert.Equal(t, pbhealth.HealthCheckResponse_SERVING, m.healthStatus(), "drift unknown before the first merge")
+
+	m.setMergedHeadline([]*bstream.OneBlockFile{mustNewOneBlockFile("0000000199-20220101T000930-199a-198a-197-suffix")})
+	drift, ok := m.HeadDrift()
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, drift)
+	assert.Equal(t, pbhealth.HealthCheckResponse_SERVING, m.healthStatus())
+
+	clock.now = clock.now.Add(time.Minute)
+	assert.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, m.healthStatus())
+
+	m.setMergedHeadline([]*bstream.OneBlockFile{mustNewOneBlockFile("0000000299-299a-298a-297-suffix")})
+	assert.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, m.healthStatus(), "block time unknown, the last known one is kept")
+}
+
+func TestMerger_LivenessTimeout(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithLivenessTimeout(time.Minute))
+	m.clock = clock
+	assert.True(t, m.IsLive(), "not running yet")
+
+	m.cycleStarted(clock.Now())
+	clock.now = clock.now.Add(time.Minute)
+	assert.True(t, m.IsLive())
+
+	clock.now = clock.now.Add(time.Second)
+	assert.False(t, m.IsLive())
+	rec := httptest.NewRecorder()
+	m.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	m.cycleStarted(clock.Now())
+	rec = httptest.NewRecorder()
+	m.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,271 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// replayedErrors are returned as themselves when replayed, the other store errors are replayed as plain errors
+var replayedErrors = []error{ErrHoleFound, ErrStopBlockReached, ErrCircuitOpen}
+
+// ReplayReport compares the decisions of the merger replaying a capture with the captured ones: the merges and the
+// forked blocks moves. The deletions of the pruners are not replayed.
+type ReplayReport struct {
+	Cycles      int
+	Captured    []*CaptureEvent
+	Replayed    []*CaptureEvent
+	Divergences []string // empty when the replay took the same decisions from the same inputs
+}
+
+// Replay runs the merger cycles of a capture (see WithCapture and ReadCapture) against the captured store listings,
+// with the clock set to the captured times, then reports the decisions that diverged. `opts` must match the options
+// of the captured merger for the replay to be faithful. The pruners, servers and background handlers are not started.
+func Replay(logger *zap.Logger, events []*CaptureEvent, firstStreamableBlock, bundleSize uint64, opts ...Option) (*ReplayReport, error) {
+	rio := newReplayIO(events)
+	m := NewMerger(logger, "", rio, firstStreamableBlock, bundleSize, bundleSize, time.Hour, time.Second, 0, opts...)
+	m.clock = rio
+	rio.onExhausted = func() { m.Shutdown(nil) }
+
+	ctx := context.Background()
+	m.bundler.ctx = ctx
+	if err := m.run(ctx); err != nil {
+		return nil, fmt.Errorf("replaying cycle %d: %w", rio.cycle, err)
+	}
+	m.bundler.inProcess.Lock() // wait for the last bundle to be merged
+	m.bundler.inProcess.Unlock()
+
+	return rio.report(), nil
+}
+
+// replayIO serves the captured store listings in order, and records the decisions. It is the clock of the replaying
+// merger: the time is the one of the last captured event served, waits return right away.
+type replayIO struct {
+	sync.Mutex
+	cycles      map[int][]*CaptureEvent
+	lastCycle   int
+	cycle       int
+	now         time.Time
+	consumed    map[*CaptureEvent]bool
+	captured    []*CaptureEvent
+	replayed    []*CaptureEvent
+	divergences []string
+	onExhausted func()
+}
+
+func newReplayIO(events []*CaptureEvent) *replayIO {
+	rio := &replayIO{
+		cycles:   make(map[int][]*CaptureEvent),
+		consumed: make(map[*CaptureEvent]bool),
+	}
+	for _, event := range events {
+		rio.cycles[event.Cycle] = append(rio.cycles[event.Cycle], event)
+		if event.Cycle > rio.lastCycle {
+			rio.lastCycle = event.Cycle
+		}
+		if isReplayedDecision(event.Op) {
+			rio.captured = append(rio.captured, event)
+		}
+	}
+	if len(events) != 0 {
+		rio.now = events[0].Time
+	}
+	return rio
+}
+
+func isReplayedDecision(op string) bool {
+	return op == CaptureOpMerge || op == CaptureOpMoveForked
+}
+
+// next returns the first event of the current cycle for `op` not served yet, preferring the ones matching `block`
+func (r *replayIO) next(op string, block uint64) *CaptureEvent {
+	var found *CaptureEvent
+	for _, event := range r.cycles[r.cycle] {
+		if event.Op != op || r.consumed[event] {
+			continue
+		}
+		if event.Block == block {
+			found = event
+			break
+		}
+		if found == nil {
+			found = event
+		}
+	}
+	if found != nil {
+		r.consumed[found] = true
+		r.now = found.Time
+	}
+	return found
+}
+
+func (r *replayIO) diverge(format string, args ...interface{}) {
+	r.divergences = append(r.divergences, fmt.Sprintf("cycle %d: ", r.cycle)+fmt.Sprintf(format, args...))
+}
+
+func (r *replayIO) NextBundle(ctx context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+	r.Lock()
+	r.cycle++
+	if r.cycle > r.lastCycle {
+		r.Unlock()
+		r.onExhausted()
+		return lowestBaseBlock, nil, nil
+	}
+	event := r.next(CaptureOpNextBundle, lowestBaseBlock)
+	defer r.Unlock()
+	if event == nil {
+		r.diverge("no next bundle captured")
+		return lowestBaseBlock, nil, nil
+	}
+	if event.Block != lowestBaseBlock {
+		r.diverge("next bundle from block %d, captured from block %d", lowestBaseBlock, event.Block)
+	}
+	var lib bstream.BlockRef
+	if event.LIBID != "" {
+		lib = bstream.NewBlockRef(event.LIBID, event.LIBNum)
+	}
+	return event.BaseBlock, lib, replayedError(event.Error)
+}
+
+func (r *replayIO) WalkOneBlockFiles(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+	r.Lock()
+	if r.cycle > r.lastCycle {
+		r.Unlock()
+		return nil
+	}
+	event := r.next(CaptureOpWalk, inclusiveLowerBlock)
+	if event == nil {
+		r.diverge("no walk captured")
+		r.Unlock()
+		return nil
+	}
+	if event.Block != inclusiveLowerBlock {
+		r.diverge("walk from block %d, captured from block %d", inclusiveLowerBlock, event.Block)
+	}
+	r.Unlock()
+
+	for _, name := range event.Files {
+		obf, err := newOneBlockFile(name)
+		if err != nil {
+			return fmt.Errorf("replaying walk: %w", err)
+		}
+		if err := callback(obf); err != nil {
+			return err
+		}
+	}
+	return replayedError(event.Error)
+}
+
+func (r *replayIO) MergeAndStore(ctx context.Context, inclusiveLowerBlock uint64, oneBlockFiles []*bstream.OneBlockFile) error {
+	r.Lock()
+	defer r.Unlock()
+	replayed := &CaptureEvent{Cycle: r.cycle, Time: r.now, Op: CaptureOpMerge, Block: inclusiveLowerBlock, Files: captureFileNames(oneBlockFiles)}
+	captured := r.decision(replayed)
+	if captured == nil {
+		return nil
+	}
+	replayed.Error = captured.Error
+	return replayedError(captured.Error)
+}
+
+func (r *replayIO) MoveForkedBlocks(ctx context.Context, oneBlockFiles []*bstream.OneBlockFile) {
+	r.Lock()
+	defer r.Unlock()
+	r.decision(&CaptureEvent{Cycle: r.cycle, Time: r.now, Op: CaptureOpMoveForked, Files: captureFileNames(oneBlockFiles)})
+}
+
+// decision records a replayed decision and compares it with the captured one at the same position
+func (r *replayIO) decision(replayed *CaptureEvent) (captured *CaptureEvent) {
+	index := len(r.replayed)
+	r.replayed = append(r.replayed, replayed)
+	if index >= len(r.captured) {
+		r.diverge("%s of block %d not captured", replayed.Op, replayed.Block)
+		return nil
+	}
+	captured = r.captured[index]
+	if captured.Op != replayed.Op || captured.Block != replayed.Block || !sameFiles(captured.Files, replayed.Files) {
+		r.diverge("%s of block %d with %d files, captured %s of block %d with %d files", replayed.Op, replayed.Block, len(replayed.Files), captured.Op, captured.Block, len(captured.Files))
+	}
+	return captured
+}
+
+// DownloadOneBlockFile fails, the payloads are not captured
+func (r *replayIO) DownloadOneBlockFile(ctx context.Context, oneBlockFile *bstream.OneBlockFile) ([]byte, error) {
+	return nil, fmt.Errorf("one-block file %s not captured", oneBlockFile.CanonicalName)
+}
+
+func (r *replayIO) DeleteAsync(oneBlockFiles []*bstream.OneBlockFile) error { return nil }
+
+func (r *replayIO) DeleteForkedBlocksAsync(inclusiveLowBoundary, inclusiveHighBoundary uint64) {}
+
+func (r *replayIO) Now() time.Time {
+	r.Lock()
+	defer r.Unlock()
+	return r.now
+}
+
+func (r *replayIO) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- r.Now()
+	return ch
+}
+
+func (r *replayIO) report() *ReplayReport {
+	r.Lock()
+	defer r.Unlock()
+	report := &ReplayReport{
+		Cycles:      r.lastCycle,
+		Captured:    r.captured,
+		Replayed:    r.replayed,
+		Divergences: r.divergences,
+	}
+	for i := len(r.replayed); i < len(r.captured); i++ {
+		captured := r.captured[i]
+		report.Divergences = append(report.Divergences, fmt.Sprintf("cycle %d: captured %s of block %d not replayed", captured.Cycle, captured.Op, captured.Block))
+	}
+	return report
+}
+
+// sameFiles compares the files of decisions, no file is captured as none
+func sameFiles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func replayedError(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	for _, err := range replayedErrors {
+		if err.Error() == msg {
+			return err
+		}
+	}
+	return errors.New(msg)
+}
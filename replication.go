@@ -0,0 +1,167 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// ReplicationRetryInterval is how long a replica waits before copying a merged file again after a failure
+var ReplicationRetryInterval = 30 * time.Second
+
+// WithMergedBlocksReplicas mirrors each merged file to the `replicas` stores once it is written to the merged
+// blocks store. Each replica copies the merged files in order, from its own queue: a failing replica retries
+// every ReplicationRetryInterval without slowing down the merges nor the other replicas.
+func WithMergedBlocksReplicas(replicas ...dstore.Store) DStoreIOOption {
+	return func(s *DStoreIO) {
+		for _, store := range replicas {
+			s.replicas = append(s.replicas, &mergedReplica{
+				name:  store.BaseURL().Redacted(),
+				store: store,
+				wake:  make(chan struct{}, 1),
+			})
+		}
+	}
+}
+
+// ReplicaStatus is the replication state of a replica of the merged blocks store
+type ReplicaStatus struct {
+	Store     string `json:"store"`
+	Pending   int    `json:"pending"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+type mergedReplica struct {
+	sync.Mutex
+	name      string
+	store     dstore.Store
+	queue     []uint64 // base blocks of the merged files to copy, in merge order
+	lastError error
+	wake      chan struct{}
+}
+
+func (r *mergedReplica) enqueue(baseBlock uint64) {
+	r.Lock()
+	r.queue = append(r.queue, baseBlock)
+	metrics.ReplicationPending.SetInt(len(r.queue), r.name)
+	r.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *mergedReplica) next() (uint64, bool) {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.queue) == 0 {
+		return 0, false
+	}
+	return r.queue[0], true
+}
+
+func (r *mergedReplica) done(baseBlock uint64, err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.lastError = err
+	if err != nil {
+		return
+	}
+	if len(r.queue) != 0 && r.queue[0] == baseBlock {
+		r.queue = r.queue[1:]
+	}
+	metrics.ReplicationPending.SetInt(len(r.queue), r.name)
+}
+
+func (r *mergedReplica) status() *ReplicaStatus {
+	r.Lock()
+	defer r.Unlock()
+	status := &ReplicaStatus{Store: r.name, Pending: len(r.queue)}
+	if r.lastError != nil {
+		status.LastError = r.lastError.Error()
+	}
+	return status
+}
+
+// replicate copies the queued merged files to the replica, forever
+func (s *DStoreIO) replicate(r *mergedReplica) {
+	for {
+		baseBlock, ok := r.next()
+		if !ok {
+			<-r.wake
+			continue
+		}
+		err := s.copyMergedFile(context.Background(), baseBlock, r.store)
+		r.done(baseBlock, err)
+		if err == nil {
+			metrics.ReplicatedBundles.Inc(r.name)
+			continue
+		}
+		metrics.ReplicationFailures.Inc(r.name)
+		s.logger.Warn("cannot replicate merged file, will retry",
+			zap.String("replica", r.name),
+			zap.String("filename", fileNameForBlocksBundle(baseBlock)),
+			zap.Duration("retry_interval", ReplicationRetryInterval),
+			zap.Error(err),
+		)
+		time.Sleep(ReplicationRetryInterval)
+	}
+}
+
+func (s *DStoreIO) copyMergedFile(ctx context.Context, baseBlock uint64, dest dstore.Store) error {
+	filename := fileNameForBlocksBundle(baseBlock)
+	readCtx, cancelRead := context.WithTimeout(ctx, GetObjectTimeout)
+	defer cancelRead()
+	release, err := s.downloads.acquire(readCtx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	reader, err := s.mergedStoreFor(baseBlock).OpenObject(readCtx, filename)
+	if err != nil {
+		return fmt.Errorf("opening merged file: %w", err)
+	}
+	defer reader.Close()
+
+	writeCtx, cancelWrite := context.WithTimeout(ctx, WriteObjectTimeout)
+	defer cancelWrite()
+	// a previous copy is replaced, stores without overwrite would keep a flushed partial bundle
+	if exists, err := dest.FileExists(writeCtx, filename); err != nil {
+		return fmt.Errorf("checking merged file: %w", err)
+	} else if exists {
+		if err := dest.DeleteObject(writeCtx, filename); err != nil {
+			return fmt.Errorf("deleting previous merged file: %w", err)
+		}
+	}
+	if err := dest.WriteObject(writeCtx, filename, reader); err != nil {
+		return fmt.Errorf("writing merged file: %w", err)
+	}
+	return nil
+}
+
+// ReplicaStatuses returns the replication state of each replica of the merged blocks store
+func (s *DStoreIO) ReplicaStatuses() (out []*ReplicaStatus) {
+	for _, r := range s.replicas {
+		out = append(out, r.status())
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDStoreIO_ReplicatesMergedFiles(t *testing.T) {
+	defer func(interval time.Duration) { ReplicationRetryInterval = interval }(ReplicationRetryInterval)
+	ReplicationRetryInterval = time.Millisecond
+
+	mergedStore := dstore.NewMockStore(nil)
+	mergedStore.SetFile("0000000100", []byte("bundle 100"))
+	mergedStore.SetFile("0000000200", []byte("bundle 200"))
+
+	type copied struct {
+		name    string
+		content string
+	}
+	copies := make(chan copied, 10)
+	var attempts int
+	replica := dstore.NewMockStore(nil)
+	replica.WriteObjectFunc = func(_ context.Context, base string, f io.Reader) error {
+		attempts++
+		if attempts == 1 {
+			return fmt.Errorf("replica unavailable")
+		}
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		copies <- copied{base, string(data)}
+		return nil
+	}
+
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedStore, nil, 0, 0, 100,
+		WithMergedBlocksReplicas(replica),
+	).(*DStoreIO)
+	mio.replicas[0].enqueue(100)
+	mio.replicas[0].enqueue(200)
+
+	for _, expected := range []copied{{"0000000100", "bundle 100"}, {"0000000200", "bundle 200"}} {
+		select {
+		case c := <-copies:
+			assert.Equal(t, expected, c, "merged files are replicated in order, after retrying")
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %s to be replicated", expected.name)
+		}
+	}
+	require.Eventually(t, func() bool {
+		statuses := mio.ReplicaStatuses()
+		return len(statuses) == 1 && statuses[0].Pending == 0 && statuses[0].LastError == ""
+	}, time.Second, time.Millisecond)
+}
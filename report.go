@@ -0,0 +1,106 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/bstream"
+)
+
+type BlockRange struct {
+	InclusiveLowBlock  uint64 `json:"inclusive_low_block"`
+	ExclusiveHighBlock uint64 `json:"exclusive_high_block"`
+}
+
+// CompletionReport is written at the end of a bounded run (with a stop block) so that
+// orchestration can assert its success without parsing logs.
+type CompletionReport struct {
+	Success         bool          `json:"success"`
+	StartBlock      uint64        `json:"start_block"`
+	StopBlock       uint64        `json:"stop_block"`
+	MergedRanges    []*BlockRange `json:"merged_ranges"`
+	FilesDeleted    uint64        `json:"files_deleted"`
+	BytesWritten    uint64        `json:"bytes_written"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	Errors          []string      `json:"errors"`
+}
+
+// runStats accumulates what a merger run did, to build the CompletionReport
+type runStats struct {
+	sync.Mutex
+	startTime    time.Time
+	startBlock   uint64
+	mergedRanges []*BlockRange
+	filesDeleted uint64
+}
+
+func (s *runStats) addMerged(lowBlockNum, bundleSize uint64) {
+	s.Lock()
+	defer s.Unlock()
+	s.mergedRanges = append(s.mergedRanges, &BlockRange{InclusiveLowBlock: lowBlockNum, ExclusiveHighBlock: lowBlockNum + bundleSize})
+}
+
+func (s *runStats) addDeleted(oneBlockFiles []*bstream.OneBlockFile) {
+	s.Lock()
+	defer s.Unlock()
+	for _, obf := range oneBlockFiles {
+		s.filesDeleted += uint64(len(obf.Filenames))
+	}
+}
+
+func (m *Merger) completionReport(runErr error) *CompletionReport {
+	m.stats.Lock()
+	defer m.stats.Unlock()
+
+	report := &CompletionReport{
+		Success:         runErr == nil,
+		StartBlock:      m.stats.startBlock,
+		StopBlock:       m.bundler.stopBlock,
+		MergedRanges:    append([]*BlockRange{}, m.stats.mergedRanges...),
+		FilesDeleted:    m.stats.filesDeleted,
+		DurationSeconds: time.Since(m.stats.startTime).Seconds(),
+		Errors:          []string{},
+	}
+	if runErr != nil {
+		report.Errors = append(report.Errors, runErr.Error())
+	}
+	if counter, ok := m.io.(interface{ BytesWritten() uint64 }); ok {
+		report.BytesWritten = counter.BytesWritten()
+	}
+	return report
+}
+
+// writeCompletionReport writes the report as JSON to m.completionReportPath, or to stdout if it is empty
+func (m *Merger) writeCompletionReport(report *CompletionReport) error {
+	var out io.Writer = os.Stdout
+	if m.completionReportPath != "" {
+		f, err := os.Create(m.completionReportPath)
+		if err != nil {
+			return fmt.Errorf("creating completion report file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,74 @@
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerger_CompletionReport(t *testing.T) {
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 300, WithCompletionReportPath(reportPath))
+	m.stats.startTime = time.Now()
+
+	m.bundler.onMerged(100, nil)
+	m.bundler.onMerged(200, nil)
+	m.stats.addDeleted([]*bstream.OneBlockFile{block100, block101})
+
+	require.NoError(t, m.writeCompletionReport(m.completionReport(fmt.Errorf("boom"))))
+
+	data, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+
+	report := &CompletionReport{}
+	require.NoError(t, json.Unmarshal(data, report))
+	assert.False(t, report.Success)
+	assert.EqualValues(t, 100, report.StartBlock)
+	assert.EqualValues(t, 300, report.StopBlock)
+	assert.Equal(t, []*BlockRange{{100, 200}, {200, 300}}, report.MergedRanges)
+	assert.EqualValues(t, 2, report.FilesDeleted)
+	assert.Equal(t, []string{"boom"}, report.Errors)
+}
+
+func TestMerger_StopBlock(t *testing.T) {
+	var merged []uint64
+	var walks int
+	mio := &TestMergerIO{
+		WalkOneBlockFilesFunc: func(_ context.Context, _ uint64, callback func(*bstream.OneBlockFile) error) error {
+			walks++
+			for _, obf := range []*bstream.OneBlockFile{block100, block101, block102Final100, block103Final101, block104Final102} {
+				if err := callback(obf); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		MergeAndStoreFunc: func(_ context.Context, inclusiveLowerBlock uint64, _ []*bstream.OneBlockFile) error {
+			merged = append(merged, inclusiveLowerBlock)
+			return nil
+		},
+	}
+	m := NewMerger(testLogger, "", mio, 100, 2, 100, time.Second, time.Second, 102)
+	require.NoError(t, m.run(context.Background()))
+	m.bundler.inProcess.Lock()
+	m.bundler.inProcess.Unlock()
+	assert.True(t, m.stopBlockReached)
+	assert.Equal(t, []uint64{100}, merged)
+	assert.Equal(t, 1, walks)
+
+	mio.NextBundleFunc = func(_ context.Context, _ uint64) (uint64, bstream.BlockRef, error) {
+		return 102, nil, nil // merged by the previous run
+	}
+	m = NewMerger(testLogger, "", mio, 100, 2, 100, time.Second, time.Second, 102)
+	require.NoError(t, m.run(context.Background()))
+	assert.True(t, m.stopBlockReached)
+	assert.Equal(t, 1, walks, "nothing left to walk")
+}
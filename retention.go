@@ -0,0 +1,201 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// MergedRetentionIOInterface is implemented by the IOs able to list and delete merged bundles, for their retention
+type MergedRetentionIOInterface interface {
+	MergedFileDeleterIOInterface
+	WalkMergedBundles(ctx context.Context, lowBaseBlock uint64, f func(baseBlock uint64) error) error
+	MergedBundleTime(ctx context.Context, baseBlock uint64) (time.Time, error)
+}
+
+// WalkMergedBundles calls f with the base block of the merged bundles from `lowBaseBlock`, in order, across all
+// the merged blocks stores. f returns dstore.StopIteration to end the walk.
+func (s *DStoreIO) WalkMergedBundles(ctx context.Context, lowBaseBlock uint64, f func(baseBlock uint64) error) error {
+	var stopped bool
+	for _, segment := range s.mergedStoreSegments(toBaseNum(lowBaseBlock, s.bundleSize)) {
+		err := s.walkMergedFiles(ctx, segment, segment.InclusiveLowBlock, func(num uint64) error {
+			if segment.ExclusiveHighBlock != 0 && num >= segment.ExclusiveHighBlock {
+				return dstore.StopIteration
+			}
+			if s.isUpgradeSplit(num) {
+				return nil // part of the bundle split at a protocol upgrade, deleted with it
+			}
+			if err := f(num); err != nil {
+				stopped = true
+				return err
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, dstore.StopIteration) {
+			return err
+		}
+		if stopped {
+			return nil
+		}
+	}
+	return nil
+}
+
+// MergedBundleTime returns the time of the last block of the merged bundle at `baseBlock`
+func (s *DStoreIO) MergedBundleTime(ctx context.Context, baseBlock uint64) (time.Time, error) {
+	_, lastTime, err := s.readLastBlockFromMerged(ctx, baseBlock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return *lastTime, nil
+}
+
+// WithMergedBlocksRetention deletes, every timeBetweenPruning, the merged bundles whose last block is older than
+// `maxAge` or that lie entirely more than `maxBlocks` blocks below the bundle being filled (0 disables either
+// limit). The last merged bundle is always kept, it gives the LIB to the next one. Replicas are not pruned.
+// The lowest bundle kept is recorded in the state file, so that a restarted merger does not start in the pruned
+// range: without a state file, firstStreamableBlock must be raised above it. The io must implement
+// MergedRetentionIOInterface.
+func WithMergedBlocksRetention(maxAge time.Duration, maxBlocks uint64) Option {
+	return func(m *Merger) {
+		if maxAge == 0 && maxBlocks == 0 {
+			return
+		}
+		m.retention = &mergedRetention{
+			maxAge:    maxAge,
+			maxBlocks: maxBlocks,
+		}
+	}
+}
+
+type mergedRetention struct {
+	maxAge    time.Duration
+	maxBlocks uint64
+}
+
+func (m *Merger) startMergedBlocksRetention() {
+	if m.retention == nil {
+		return
+	}
+	if _, ok := m.io.(MergedRetentionIOInterface); !ok {
+		m.logger.Warn("io cannot list and delete merged bundles, merged blocks retention is disabled")
+		return
+	}
+	m.logger.Info("pruning merged bundles beyond retention",
+		zap.Duration("max_age", m.retention.maxAge),
+		zap.Uint64("max_blocks", m.retention.maxBlocks),
+	)
+	go func() {
+		for {
+			select {
+			case <-m.Terminating():
+				return
+			case <-time.After(m.timeBetweenPruning):
+			}
+			if err := m.pruneMergedBundles(context.Background()); err != nil {
+				m.logger.Warn("cannot prune merged bundles", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// pruneMergedBundles deletes the lowest merged bundles, up to the first one within retention
+func (m *Merger) pruneMergedBundles(ctx context.Context) error {
+	pruner := m.io.(MergedRetentionIOInterface)
+	bundleSize := m.bundler.bundleSize
+	current := m.bundler.BaseBlockNum()
+	if current < 2*bundleSize {
+		return nil
+	}
+	keepFrom := current - bundleSize
+
+	var depthCutoff uint64
+	if m.retention.maxBlocks != 0 && current > m.retention.maxBlocks {
+		depthCutoff = current - m.retention.maxBlocks
+	}
+	ageCutoff := m.clock.Now().Add(-m.retention.maxAge)
+
+	var expired []uint64
+	err := pruner.WalkMergedBundles(ctx, 0, func(baseBlock uint64) error {
+		if baseBlock >= keepFrom {
+			return dstore.StopIteration
+		}
+		if baseBlock+bundleSize <= depthCutoff {
+			expired = append(expired, baseBlock)
+			return nil
+		}
+		if m.retention.maxAge == 0 {
+			return dstore.StopIteration
+		}
+		bundleTime, err := pruner.MergedBundleTime(ctx, baseBlock)
+		if err != nil {
+			return err
+		}
+		if !bundleTime.Before(ageCutoff) {
+			return dstore.StopIteration
+		}
+		expired = append(expired, baseBlock)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	var pruned int
+	for _, baseBlock := range expired {
+		if err = pruner.DeleteMergedFile(ctx, baseBlock); err != nil {
+			break
+		}
+		metrics.PrunedMergedBundles.Inc()
+		m.counters.setRetainedFrom(baseBlock + bundleSize)
+		pruned++
+	}
+	if pruned > 0 {
+		m.logger.Info("pruned merged bundles beyond retention",
+			zap.Int("count", pruned),
+			zap.Uint64("low_base_block", expired[0]),
+			zap.Uint64("high_base_block", expired[pruned-1]),
+		)
+		if saveErr := m.counters.save(); saveErr != nil {
+			m.logger.Warn("cannot save state file", zap.Error(saveErr))
+		}
+	}
+	return err
+}
+
+// skipPrunedBundles moves the bundler above the merged bundles pruned by a previous run
+func (m *Merger) skipPrunedBundles() {
+	retainedFrom := m.RetainedFrom()
+	if m.retention == nil || retainedFrom <= m.bundler.BaseBlockNum() {
+		return
+	}
+	m.resetBundler(retainedFrom, nil, "merged bundles below were pruned by retention")
+}
+
+// RetainedFrom returns the base of the lowest merged bundle kept by retention, 0 when none was pruned
+func (m *Merger) RetainedFrom() uint64 {
+	m.counters.Lock()
+	defer m.counters.Unlock()
+	return m.counters.retainedFrom
+}
@@ -0,0 +1,130 @@
+package merger
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDStoreIO_WalkMergedBundles(t *testing.T) {
+	defaultStore := dstore.NewMockStore(nil)
+	archive := dstore.NewMockStore(nil)
+	for _, name := range []string{"0000000000", "0000000100", "0000000200"} {
+		archive.SetFile(name, nil)
+	}
+	for _, name := range []string{"0000000300", "0000000400"} {
+		defaultStore.SetFile(name, nil)
+	}
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), defaultStore, nil, 0, 0, 100,
+		WithMergedBlocksStoreRange(0, 300, archive),
+	).(*DStoreIO)
+
+	var walked []uint64
+	require.NoError(t, mio.WalkMergedBundles(context.Background(), 100, func(baseBlock uint64) error {
+		walked = append(walked, baseBlock)
+		return nil
+	}))
+	assert.Equal(t, []uint64{100, 200, 300, 400}, walked)
+
+	walked = nil
+	require.NoError(t, mio.WalkMergedBundles(context.Background(), 0, func(baseBlock uint64) error {
+		if baseBlock >= 200 {
+			return dstore.StopIteration
+		}
+		walked = append(walked, baseBlock)
+		return nil
+	}))
+	assert.Equal(t, []uint64{0, 100}, walked)
+}
+
+type retentionTestMergerIO struct {
+	TestMergerIO
+	bundleTimes map[uint64]time.Time
+	deleted     []uint64
+}
+
+func (io *retentionTestMergerIO) WalkMergedBundles(_ context.Context, lowBaseBlock uint64, f func(baseBlock uint64) error) error {
+	for base := lowBaseBlock; ; base += 100 {
+		if _, ok := io.bundleTimes[base]; !ok {
+			return nil
+		}
+		if err := f(base); err != nil {
+			if err == dstore.StopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (io *retentionTestMergerIO) MergedBundleTime(_ context.Context, baseBlock uint64) (time.Time, error) {
+	return io.bundleTimes[baseBlock], nil
+}
+
+func (io *retentionTestMergerIO) DeleteMergedFile(_ context.Context, baseBlock uint64) error {
+	delete(io.bundleTimes, baseBlock)
+	io.deleted = append(io.deleted, baseBlock)
+	return nil
+}
+
+func newRetentionTestMergerIO(now time.Time, count int) *retentionTestMergerIO {
+	io := &retentionTestMergerIO{bundleTimes: make(map[uint64]time.Time)}
+	for i := 0; i < count; i++ {
+		io.bundleTimes[uint64(i)*100] = now.Add(time.Duration(i-count) * time.Hour)
+	}
+	return io
+}
+
+func TestMerger_PruneMergedBundles(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("by age", func(t *testing.T) {
+		mio := newRetentionTestMergerIO(now, 10) // bundle 0 is 10 hours old, bundle 900 one hour old
+		m := NewMerger(testLogger, "", mio, 1000, 100, 100, time.Second, time.Second, 0,
+			WithMergedBlocksRetention(7*time.Hour+time.Minute, 0),
+			WithStateFile(filepath.Join(t.TempDir(), "state.json")),
+		)
+		m.clock = &fakeClock{now: now}
+
+		require.NoError(t, m.pruneMergedBundles(context.Background()))
+		assert.Equal(t, []uint64{0, 100, 200}, mio.deleted)
+		assert.Equal(t, uint64(300), m.RetainedFrom())
+
+		require.NoError(t, m.counters.load())
+		assert.Equal(t, uint64(300), m.RetainedFrom())
+	})
+
+	t.Run("by depth", func(t *testing.T) {
+		mio := newRetentionTestMergerIO(now, 10)
+		m := NewMerger(testLogger, "", mio, 1000, 100, 100, time.Second, time.Second, 0, WithMergedBlocksRetention(0, 450))
+		m.clock = &fakeClock{now: now}
+
+		require.NoError(t, m.pruneMergedBundles(context.Background()))
+		assert.Equal(t, []uint64{0, 100, 200, 300, 400}, mio.deleted)
+	})
+
+	t.Run("last merged bundle is kept", func(t *testing.T) {
+		mio := newRetentionTestMergerIO(now, 10)
+		m := NewMerger(testLogger, "", mio, 1000, 100, 100, time.Second, time.Second, 0, WithMergedBlocksRetention(time.Minute, 0))
+		m.clock = &fakeClock{now: now.Add(24 * time.Hour)}
+
+		require.NoError(t, m.pruneMergedBundles(context.Background()))
+		assert.Len(t, mio.deleted, 9)
+		assert.Contains(t, mio.bundleTimes, uint64(900))
+	})
+}
+
+func TestMerger_SkipPrunedBundles(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithMergedBlocksRetention(time.Hour, 0))
+	m.skipPrunedBundles()
+	assert.Equal(t, uint64(100), m.bundler.BaseBlockNum())
+
+	m.counters.setRetainedFrom(500)
+	m.skipPrunedBundles()
+	assert.Equal(t, uint64(500), m.bundler.BaseBlockNum())
+}
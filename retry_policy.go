@@ -0,0 +1,328 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned by the store operations while the circuit breaker of the RetryPolicy is open
+var ErrCircuitOpen = errors.New("store circuit breaker is open")
+
+// StoreDomain tells which side of the merge a store failure comes from, each side has its own circuit breaker so that
+// an outage of one does not stop the work depending only on the other
+type StoreDomain string
+
+const (
+	StoreDomainSource      StoreDomain = "source"      // the one-block files store
+	StoreDomainDestination StoreDomain = "destination" // the merged blocks stores
+)
+
+// StoreDomainError is returned by the store operations of a RetryPolicy that failed, or were suspended by the circuit
+// breaker of their domain
+type StoreDomainError struct {
+	Domain StoreDomain
+	Err    error
+}
+
+func (e *StoreDomainError) Error() string { return fmt.Sprintf("%s store: %s", e.Domain, e.Err) }
+func (e *StoreDomainError) Unwrap() error { return e.Err }
+
+// FailedStoreDomain returns the store domain that caused `err`, if known
+func FailedStoreDomain(err error) (StoreDomain, bool) {
+	var domainErr *StoreDomainError
+	if errors.As(err, &domainErr) {
+		return domainErr.Domain, true
+	}
+	return "", false
+}
+
+// IsDestinationOutage tells if `err` comes from the merged blocks stores being unavailable
+func IsDestinationOutage(err error) bool {
+	domain, ok := FailedStoreDomain(err)
+	return ok && domain == StoreDomainDestination
+}
+
+// CircuitBreakerIOInterface is implemented by the ios whose store operations can be suspended by a circuit breaker
+type CircuitBreakerIOInterface interface {
+	// CircuitOpen tells if the store operations are suspended, the merger is not ready meanwhile
+	CircuitOpen() bool
+}
+
+// RetryPolicy tells how the store operations of the DStoreIO (downloads, uploads, listings and deletes) are retried.
+// Zero values take the defaults of DefaultRetryPolicy.
+type RetryPolicy struct {
+	MaxAttempts int
+	// InitialBackoff doubles after each failed attempt, up to MaxBackoff. Jitter (0 to 1) is the fraction of each
+	// backoff that is randomized, so that instances failing together do not retry together.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Jitter         float64
+
+	// DownloadTimeout, UploadTimeout, ListTimeout and DeleteTimeout bound each attempt. Listings are not bounded
+	// unless ListTimeout is set, walking a large store takes time.
+	DownloadTimeout time.Duration
+	UploadTimeout   time.Duration
+	ListTimeout     time.Duration
+	DeleteTimeout   time.Duration
+
+	// CircuitBreakerThreshold consecutive failed operations (all their attempts failed) on the source or the
+	// destination stores open the circuit of that domain: its store operations fail right away with ErrCircuitOpen and
+	// the merger is not ready, until an operation succeeds again. One operation is let through every
+	// CircuitBreakerCooldown to probe the stores. 0 disables the circuit breakers.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+}
+
+// DefaultRetryPolicy matches the fixed retries of the uploads without a policy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:            5,
+	InitialBackoff:         500 * time.Millisecond,
+	MaxBackoff:             5 * time.Second,
+	CircuitBreakerCooldown: time.Minute,
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	if p.DownloadTimeout == 0 {
+		p.DownloadTimeout = GetObjectTimeout
+	}
+	if p.UploadTimeout == 0 {
+		p.UploadTimeout = WriteObjectTimeout
+	}
+	if p.DeleteTimeout == 0 {
+		p.DeleteTimeout = DeleteObjectTimeout
+	}
+	if p.CircuitBreakerCooldown == 0 {
+		p.CircuitBreakerCooldown = DefaultRetryPolicy.CircuitBreakerCooldown
+	}
+	return p
+}
+
+// backoff returns the delay after the failed attempt number `attempt` (starting at 1)
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		spread := time.Duration(float64(delay) * p.Jitter)
+		delay = delay - spread + time.Duration(rand.Int63n(int64(spread)+1))
+	}
+	return delay
+}
+
+// WithRetryPolicy replaces the fixed retries of the uploads with `policy`, also applied to the one-block file and
+// merged file downloads, the listings and the one-block file deletes
+func WithRetryPolicy(policy RetryPolicy) DStoreIOOption {
+	return func(s *DStoreIO) {
+		p := policy.withDefaults()
+		s.retryPolicy = &p
+		if p.CircuitBreakerThreshold > 0 {
+			s.breakers = make(map[StoreDomain]*circuitBreaker)
+			for _, domain := range []StoreDomain{StoreDomainSource, StoreDomainDestination} {
+				s.breakers[domain] = &circuitBreaker{domain: domain, threshold: p.CircuitBreakerThreshold, cooldown: p.CircuitBreakerCooldown, logger: s.logger}
+			}
+		}
+	}
+}
+
+// permanentError is not retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// retry runs `f`, an operation on the stores of `domain`, until it succeeds, up to MaxAttempts times, each attempt
+// bounded by `timeout` (0 for none). Objects not found, permanentErrors and failures of the other domain (a download
+// while uploading) are returned right away, the latter without counting against the circuit breaker of `domain`.
+func (s *DStoreIO) retry(ctx context.Context, domain StoreDomain, op string, timeout time.Duration, f func(ctx context.Context) error) error {
+	breaker := s.breakers[domain]
+	if err := breaker.allow(); err != nil {
+		return &StoreDomainError{Domain: domain, Err: err}
+	}
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		err = s.attempt(ctx, timeout, f)
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			breaker.record(true)
+			return permanent.err
+		}
+		if err == nil || errors.Is(err, dstore.ErrNotFound) {
+			breaker.record(true)
+			return err
+		}
+		if failed, ok := FailedStoreDomain(err); ok && failed != domain {
+			return err
+		}
+		if attempt >= s.retryPolicy.MaxAttempts || ctx.Err() != nil {
+			break
+		}
+		metrics.StoreRetries.Inc(op)
+		delay := s.retryPolicy.backoff(attempt)
+		s.logger.Warn("retrying store operation after error", zap.String("store", string(domain)), zap.String("operation", op), zap.Int("attempt", attempt), zap.Duration("backoff", delay), zap.Error(err))
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+	breaker.record(false)
+	return &StoreDomainError{Domain: domain, Err: fmt.Errorf("%s after %d attempts: %w", op, attempt, err)}
+}
+
+func (s *DStoreIO) attempt(ctx context.Context, timeout time.Duration, f func(ctx context.Context) error) error {
+	if timeout == 0 {
+		return f(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return f(ctx)
+}
+
+// retryList runs the listing `walk` with the retry policy, if any. A listing is only retried until it yielded a
+// first file: its callback `f` is not idempotent.
+func (s *DStoreIO) retryList(ctx context.Context, domain StoreDomain, walk func(ctx context.Context, f func(filename string) error) error, f func(filename string) error) error {
+	if s.retryPolicy == nil {
+		return walk(ctx, f)
+	}
+	var yielded bool
+	var callbackErr error
+	return s.retry(ctx, domain, "list", s.retryPolicy.ListTimeout, func(ctx context.Context) error {
+		err := walk(ctx, func(filename string) error {
+			yielded = true
+			callbackErr = f(filename)
+			return callbackErr
+		})
+		if err != nil && (yielded || err == callbackErr) {
+			return &permanentError{err: err}
+		}
+		return err
+	})
+}
+
+// retryDownload runs the download `f` with the retry policy, or once bounded by GetObjectTimeout without one
+func (s *DStoreIO) retryDownload(ctx context.Context, domain StoreDomain, f func(ctx context.Context) error) error {
+	if s.retryPolicy == nil {
+		return s.attempt(ctx, GetObjectTimeout, f)
+	}
+	return s.retry(ctx, domain, "download", s.retryPolicy.DownloadTimeout, f)
+}
+
+// retryUpload runs the upload `f` with the retry policy, or with the fixed retries of NewDStoreIO without one
+func (s *DStoreIO) retryUpload(ctx context.Context, f func(ctx context.Context) error) error {
+	if s.retryPolicy == nil {
+		return Retry(s.logger, s.retryAttempts, s.retryCooldown, func() error {
+			return s.attempt(ctx, WriteObjectTimeout, f)
+		})
+	}
+	return s.retry(ctx, StoreDomainDestination, "upload", s.retryPolicy.UploadTimeout, f)
+}
+
+// CircuitOpen tells if the circuit breaker of the source or the destination stores is open
+func (s *DStoreIO) CircuitOpen() bool {
+	for _, breaker := range s.breakers {
+		if breaker.isOpen() {
+			return true
+		}
+	}
+	return false
+}
+
+// circuitBreaker counts the consecutive failed operations on the stores of a domain, a nil circuitBreaker is always
+// closed
+type circuitBreaker struct {
+	sync.Mutex
+	domain    StoreDomain
+	threshold int
+	cooldown  time.Duration
+	logger    *zap.Logger
+
+	failures int
+	openedAt time.Time // zero while closed
+}
+
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if time.Since(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+	b.openedAt = time.Now() // let this operation probe the store, the others still fail until it completes
+	return nil
+}
+
+func (b *circuitBreaker) record(success bool) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	if success {
+		if !b.openedAt.IsZero() {
+			b.logger.Info("store circuit breaker closed, store operations succeed again", zap.String("store", string(b.domain)))
+			metrics.StoreCircuitOpen.SetUint64(0, string(b.domain))
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.openedAt.IsZero() {
+			b.logger.Warn("store circuit breaker opened", zap.String("store", string(b.domain)), zap.Int("consecutive_failures", b.failures))
+			metrics.StoreCircuitOpen.SetUint64(1, string(b.domain))
+		}
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+	return !b.openedAt.IsZero()
+}
@@ -0,0 +1,104 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}.withDefaults()
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 400*time.Millisecond, p.backoff(3))
+	assert.Equal(t, time.Second, p.backoff(10))
+
+	p.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		delay := p.backoff(2)
+		assert.True(t, delay >= 100*time.Millisecond && delay <= 200*time.Millisecond, "got %s", delay)
+	}
+}
+
+func TestDStoreIO_RetryPolicy(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = dbinHeaderLen
+	oneBlocksStore := dstore.NewMockStore(nil)
+	oneBlocksStore.SetFile("0000000100-0000000000000100a-0000000000000099a-98-suffix", dbinData("ETH", "01", 0x1))
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	var writes int
+	mergedBlocksStore.WriteObjectFunc = func(_ context.Context, _ string, _ io.Reader) error {
+		writes++
+		if writes < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}
+
+	mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, mergedBlocksStore, nil, 0, 0, 100, WithRetryPolicy(RetryPolicy{
+		MaxAttempts:             3,
+		InitialBackoff:          time.Millisecond,
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  time.Hour,
+	})).(*DStoreIO)
+	require.NoError(t, mio.MergeAndStore(context.Background(), 100, []*bstream.OneBlockFile{
+		mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-suffix"),
+	}))
+	assert.Equal(t, 3, writes)
+
+	var walks int
+	oneBlocksStore.WalkFunc = func(_ context.Context, _ string, f func(filename string) error) error {
+		walks++
+		if walks == 1 {
+			return errors.New("unavailable")
+		}
+		if err := f("0000000100-0000000000000100a-0000000000000099a-98-suffix"); err != nil {
+			return err
+		}
+		return errors.New("unavailable")
+	}
+	var walked int
+	err := mio.WalkOneBlockFiles(context.Background(), 0, func(*bstream.OneBlockFile) error {
+		walked++
+		return nil
+	})
+	require.Error(t, err)
+	assert.Equal(t, 2, walks, "a listing is not retried once it yielded files")
+	assert.Equal(t, 1, walked)
+	assert.False(t, mio.CircuitOpen())
+
+	walks = 0
+	oneBlocksStore.WalkFunc = func(_ context.Context, _ string, _ func(filename string) error) error {
+		walks++
+		return errors.New("unavailable")
+	}
+	for i := 0; i < 2; i++ {
+		require.Error(t, mio.WalkOneBlockFiles(context.Background(), 0, func(*bstream.OneBlockFile) error { return nil }))
+	}
+	assert.Equal(t, 6, walks)
+	assert.True(t, mio.CircuitOpen())
+
+	err = mio.WalkOneBlockFiles(context.Background(), 0, func(*bstream.OneBlockFile) error { return nil })
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 6, walks, "the store is not called while the circuit is open")
+	domain, ok := FailedStoreDomain(err)
+	require.True(t, ok)
+	assert.Equal(t, StoreDomainSource, domain)
+
+	require.NoError(t, mio.walkMergedFiles(context.Background(), mio.mergedStoreSegments(0)[0], 0, func(uint64) error { return nil }), "the merged blocks store has its own circuit")
+	err = mio.MergeAndStore(context.Background(), 200, []*bstream.OneBlockFile{
+		mustNewOneBlockFile("0000000200-0000000000000200a-0000000000000199a-198-suffix"),
+	})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.False(t, IsDestinationOutage(err), "the upload failed on its downloads")
+	assert.False(t, mio.breakers[StoreDomainDestination].isOpen())
+
+	m := NewMerger(testLogger, "", mio, 100, 100, 100, time.Second, time.Second, 0)
+	assert.Equal(t, pbhealth.HealthCheckResponse_NOT_SERVING, m.healthStatus())
+}
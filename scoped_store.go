@@ -0,0 +1,144 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/streamingfast/dstore"
+)
+
+// ErrOutOfScope is returned by a scoped store for any write, copy or deletion of an object outside of its scope
+var ErrOutOfScope = errors.New("object outside of the store scope")
+
+// NewScopedStore roots `store` at `prefix` (when not empty) and guarantees that walks, writes, copies and
+// deletions only ever see or touch objects directly under it, never in sub-folders nor in parent folders.
+// It makes it safe to share a bucket with reader or relayer outputs, even when the store URL is over-broad.
+func NewScopedStore(store dstore.Store, prefix string) (dstore.Store, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		if !inScope(prefix, true) {
+			return nil, fmt.Errorf("invalid scope prefix %q", prefix)
+		}
+		sub, err := store.SubStore(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("scoping store to %q: %w", prefix, err)
+		}
+		store = sub
+	}
+	return &scopedStore{Store: store}, nil
+}
+
+type scopedStore struct {
+	dstore.Store
+}
+
+func inScope(name string, allowSubFolders bool) bool {
+	if name == "" || strings.Contains(name, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return allowSubFolders || !strings.Contains(name, "/")
+}
+
+func checkScope(names ...string) error {
+	for _, name := range names {
+		if !inScope(name, false) {
+			return fmt.Errorf("%w: %q", ErrOutOfScope, name)
+		}
+	}
+	return nil
+}
+
+func (s *scopedStore) WriteObject(ctx context.Context, base string, f io.Reader) error {
+	if err := checkScope(base); err != nil {
+		return err
+	}
+	return s.Store.WriteObject(ctx, base, f)
+}
+
+func (s *scopedStore) PushLocalFile(ctx context.Context, localFile, toBaseName string) error {
+	if err := checkScope(toBaseName); err != nil {
+		return err
+	}
+	return s.Store.PushLocalFile(ctx, localFile, toBaseName)
+}
+
+func (s *scopedStore) CopyObject(ctx context.Context, src, dest string) error {
+	if err := checkScope(src, dest); err != nil {
+		return err
+	}
+	return s.Store.CopyObject(ctx, src, dest)
+}
+
+func (s *scopedStore) DeleteObject(ctx context.Context, base string) error {
+	if err := checkScope(base); err != nil {
+		return err
+	}
+	return s.Store.DeleteObject(ctx, base)
+}
+
+func (s *scopedStore) Walk(ctx context.Context, prefix string, f func(filename string) error) error {
+	if strings.Contains(prefix, "/") {
+		return fmt.Errorf("%w: walk prefix %q", ErrOutOfScope, prefix)
+	}
+	return s.Store.Walk(ctx, prefix, scopedWalkFunc(f))
+}
+
+func (s *scopedStore) WalkFrom(ctx context.Context, prefix, startingPoint string, f func(filename string) error) error {
+	if strings.Contains(prefix, "/") {
+		return fmt.Errorf("%w: walk prefix %q", ErrOutOfScope, prefix)
+	}
+	return s.Store.WalkFrom(ctx, prefix, startingPoint, scopedWalkFunc(f))
+}
+
+func (s *scopedStore) ListFiles(ctx context.Context, prefix string, max int) ([]string, error) {
+	if strings.Contains(prefix, "/") {
+		return nil, fmt.Errorf("%w: list prefix %q", ErrOutOfScope, prefix)
+	}
+	files, err := s.Store.ListFiles(ctx, prefix, max)
+	if err != nil {
+		return nil, err
+	}
+	out := files[:0]
+	for _, file := range files {
+		if inScope(file, false) {
+			out = append(out, file)
+		}
+	}
+	return out, nil
+}
+
+func (s *scopedStore) SubStore(subFolder string) (dstore.Store, error) {
+	return NewScopedStore(s.Store, subFolder)
+}
+
+// scopedWalkFunc hides the objects of sub-folders, like the outputs of other apps sharing the store
+func scopedWalkFunc(f func(filename string) error) func(filename string) error {
+	return func(filename string) error {
+		if !inScope(filename, false) {
+			return nil
+		}
+		return f(filename)
+	}
+}
@@ -0,0 +1,44 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopedStore(t *testing.T) {
+	ctx := context.Background()
+	mock := dstore.NewMockStore(nil)
+	mock.SetFile("0000000100-0000000000000100a-0000000000000099a-98-suffix", nil)
+	mock.SetFile("relayer/0000000100-0000000000000100a-0000000000000099a-98-suffix", nil)
+
+	store, err := NewScopedStore(mock, "")
+	require.NoError(t, err)
+
+	var walked []string
+	require.NoError(t, store.Walk(ctx, "", func(filename string) error {
+		walked = append(walked, filename)
+		return nil
+	}))
+	assert.Equal(t, []string{"0000000100-0000000000000100a-0000000000000099a-98-suffix"}, walked)
+
+	assert.ErrorIs(t, store.DeleteObject(ctx, "relayer/0000000100-0000000000000100a-0000000000000099a-98-suffix"), ErrOutOfScope)
+	assert.ErrorIs(t, store.WriteObject(ctx, "../0000000100", bytes.NewReader(nil)), ErrOutOfScope)
+	assert.ErrorIs(t, store.CopyObject(ctx, "0000000100-0000000000000100a-0000000000000099a-98-suffix", "relayer/copy"), ErrOutOfScope)
+	assert.ErrorIs(t, store.Walk(ctx, "relayer/", func(string) error { return nil }), ErrOutOfScope)
+
+	exists, err := mock.FileExists(ctx, "relayer/0000000100-0000000000000100a-0000000000000099a-98-suffix")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	require.NoError(t, store.DeleteObject(ctx, "0000000100-0000000000000100a-0000000000000099a-98-suffix"))
+}
+
+func TestNewScopedStoreInvalidPrefix(t *testing.T) {
+	_, err := NewScopedStore(dstore.NewMockStore(nil), "one-blocks/../merged-blocks")
+	assert.Error(t, err)
+}
@@ -5,8 +5,13 @@ import (
 	pbhealth "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// startGRPCServer is a no-op without listen address, e.g. for the chains of a MultiMerger which serve their health
+// from a shared server
 func (m *Merger) startGRPCServer() {
-	gs := dgrpcfactory.ServerFromOptions()
+	if m.grpcListenAddr == "" {
+		return
+	}
+	gs := dgrpcfactory.ServerFromOptions(m.grpcServerOptions...)
 	gs.OnTerminated(m.Shutdown)
 	m.logger.Info("grpc server created")
 
@@ -14,6 +19,8 @@ func (m *Merger) startGRPCServer() {
 		gs.Shutdown(0)
 	})
 	pbhealth.RegisterHealthServer(gs.ServiceRegistrar(), m)
+	gs.ServiceRegistrar().RegisterService(&adminServiceDesc, m)
+	gs.ServiceRegistrar().RegisterService(&progressServiceDesc, m)
 	m.logger.Info("server registered")
 
 	go gs.Launch(m.grpcListenAddr)
@@ -0,0 +1,95 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultShutdownGracePeriod is how long the bundle being merged gets to complete once the merger is shutting down
+var DefaultShutdownGracePeriod = 30 * time.Second
+
+// errShuttingDown stops the walk as soon as the merger is shutting down
+var errShuttingDown = errors.New("shutting down")
+
+// ShutdownProgress records what an interrupted cycle completed, it is kept in the state file
+type ShutdownProgress struct {
+	Time time.Time `json:"time"`
+	// BaseBlockNum is the base of the bundle being filled, every bundle below it is merged
+	BaseBlockNum   uint64 `json:"base_block_num"`
+	FilesWalked    int    `json:"files_walked"`
+	HighestWalked  uint64 `json:"highest_walked"`
+	WalkResumeName string `json:"walk_resume_name,omitempty"`
+}
+
+// WithShutdownGracePeriod bounds the time the merger takes to stop: the walk stops right away, and the merge
+// (upload included) of the bundle in progress is cancelled if it does not complete within `gracePeriod`.
+// Merged bundles are never lost, the next start continues from the first bundle that is not merged.
+func WithShutdownGracePeriod(gracePeriod time.Duration) Option {
+	return func(m *Merger) {
+		m.shutdownGracePeriod = gracePeriod
+	}
+}
+
+// shutdownContext is cancelled once the grace period has elapsed after the merger started shutting down
+func (m *Merger) shutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.Terminating():
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(m.shutdownGracePeriod):
+			m.logger.Warn("shutdown grace period elapsed, cancelling the bundle in progress", zap.Duration("grace_period", m.shutdownGracePeriod))
+			cancel()
+		}
+	}()
+	return ctx, cancel
+}
+
+// recordShutdownProgress logs and persists what the walk interrupted by the shutdown completed
+func (m *Merger) recordShutdownProgress(filesWalked int, highestWalked uint64) {
+	progress := &ShutdownProgress{
+		Time:           m.clock.Now(),
+		BaseBlockNum:   m.bundler.baseBlockNum,
+		FilesWalked:    filesWalked,
+		HighestWalked:  highestWalked,
+		WalkResumeName: m.WalkResumeName(),
+	}
+	m.logger.Info("walk interrupted by shutdown",
+		zap.Uint64("base_block_num", progress.BaseBlockNum),
+		zap.Int("files_walked", filesWalked),
+		zap.Uint64("highest_walked", highestWalked),
+		zap.String("walk_resume_name", progress.WalkResumeName),
+	)
+	m.counters.setLastShutdown(progress)
+	if err := m.counters.save(); err != nil {
+		m.logger.Warn("cannot save state file", zap.Error(err))
+	}
+}
+
+// LastShutdown returns the progress of the cycle interrupted by the last shutdown, from the state file, nil if none was
+func (m *Merger) LastShutdown() *ShutdownProgress {
+	m.counters.Lock()
+	defer m.counters.Unlock()
+	return m.counters.lastShutdown
+}
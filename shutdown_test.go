@@ -0,0 +1,68 @@
+package merger
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerger_ShutdownInterruptsWalk(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	var m *Merger
+	var walkErr error
+	io := &TestMergerIO{
+		NextBundleFunc: func(ctx context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+			return 100, nil, nil
+		},
+		WalkOneBlockFilesFunc: func(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+			if err := callback(block100); err != nil {
+				return err
+			}
+			m.Shutdown(nil)
+			walkErr = callback(block101)
+			if walkErr != nil {
+				return walkErr
+			}
+			t.Error("the walk should stop once the merger is shutting down")
+			return callback(block102Final100)
+		},
+	}
+	m = NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Millisecond, 0, WithStateFile(statePath))
+
+	require.NoError(t, m.run(context.Background()))
+	assert.Equal(t, errShuttingDown, walkErr)
+
+	progress := m.LastShutdown()
+	require.NotNil(t, progress)
+	assert.Equal(t, 2, progress.FilesWalked)
+	assert.Equal(t, uint64(101), progress.HighestWalked)
+	assert.Equal(t, uint64(100), progress.BaseBlockNum)
+
+	restarted := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Millisecond, 0, WithStateFile(statePath))
+	require.NoError(t, restarted.counters.load())
+	assert.Equal(t, progress.HighestWalked, restarted.LastShutdown().HighestWalked)
+}
+
+func TestMerger_ShutdownContext(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Millisecond, 0, WithShutdownGracePeriod(10*time.Millisecond))
+	ctx, cancel := m.shutdownContext()
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("the context is only cancelled after the merger starts shutting down")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	m.Shutdown(nil)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the context should be cancelled once the grace period elapsed")
+	}
+}
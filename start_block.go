@@ -0,0 +1,114 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// StartBlockFinderIOInterface is implemented by the IOs able to find the first bundle to merge without listing the
+// merged files
+type StartBlockFinderIOInterface interface {
+	FindStartBlock(ctx context.Context, hintLowBlock uint64) (uint64, error)
+}
+
+// FindStartBlock returns the base of the first missing merged bundle from `hintLowBlock`, probing the merged files
+// at exponentially growing distances then by bisection, in a number of requests logarithmic in the number of
+// bundles. A cursor higher than the hint (see WithMergedCursor) is used as the starting point. The merged files are
+// assumed contiguous from the hint: a hole between two probes is not seen. In compatibility mode, where merged
+// files may be named with any padding, the hint bundle is returned.
+func (s *DStoreIO) FindStartBlock(ctx context.Context, hintLowBlock uint64) (uint64, error) {
+	low := toBaseNum(hintLowBlock, s.bundleSize)
+	if s.mergedFileNames != nil {
+		return low, nil
+	}
+	exists, err := s.mergedFileExists(ctx, low)
+	if err != nil || !exists {
+		return low, err
+	}
+	if cursor, err := s.ReadCursor(ctx); err == nil && cursor != nil && cursor.BaseBlock > low {
+		if exists, err := s.mergedFileExists(ctx, cursor.BaseBlock); err == nil && exists {
+			low = cursor.BaseBlock
+		}
+	}
+
+	// low exists, find a missing high one
+	step := s.bundleSize
+	high := low + step
+	for {
+		exists, err := s.mergedFileExists(ctx, high)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			break
+		}
+		low = high
+		step *= 2
+		high = low + step
+	}
+
+	for high-low > s.bundleSize {
+		mid := low + (high-low)/s.bundleSize/2*s.bundleSize
+		exists, err := s.mergedFileExists(ctx, mid)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return high, nil
+}
+
+func (s *DStoreIO) mergedFileExists(ctx context.Context, baseBlock uint64) (bool, error) {
+	return s.mergedStoreFor(baseBlock).FileExists(ctx, fileNameForBlocksBundle(baseBlock))
+}
+
+// WithStartBlockProbing finds the last merged bundle on startup with FindStartBlock, instead of listing all the
+// merged files from the first streamable block on the first walk. Holes in the merged files are then not reported.
+// The io must implement StartBlockFinderIOInterface.
+func WithStartBlockProbing() Option {
+	return func(m *Merger) {
+		m.startBlockProbing = true
+	}
+}
+
+// skipMergedBundles moves the bundler to the last merged bundle, found by probing
+func (m *Merger) skipMergedBundles(ctx context.Context) {
+	if !m.startBlockProbing {
+		return
+	}
+	finder, ok := m.io.(StartBlockFinderIOInterface)
+	if !ok {
+		m.logger.Warn("io cannot probe merged files, start block probing is disabled")
+		return
+	}
+	base := m.bundler.BaseBlockNum()
+	start, err := finder.FindStartBlock(ctx, base)
+	if err != nil {
+		m.logger.Warn("cannot probe merged files for the start block", zap.Error(err))
+		return
+	}
+	if start < base+2*m.bundler.bundleSize {
+		return // the first walk is short anyway
+	}
+	// the last merged bundle gives the LIB to the next one
+	m.resetBundler(start-m.bundler.bundleSize, nil, "merged bundles found by probing")
+}
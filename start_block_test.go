@@ -0,0 +1,76 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDStoreIO_FindStartBlock(t *testing.T) {
+	mergedBlocksStore := dstore.NewMockStore(nil)
+	for base := uint64(0); base < 2400; base += 100 {
+		mergedBlocksStore.SetFile(fileNameForBlocksBundle(base), nil)
+	}
+	var probes int
+	var hasCursor bool
+	mergedBlocksStore.FileExistsFunc = func(_ context.Context, base string) (bool, error) {
+		if base == MergedCursorFilename {
+			return hasCursor, nil
+		}
+		probes++
+		var num uint64
+		fmt.Sscanf(base, "%d", &num)
+		return num < 2400, nil
+	}
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), mergedBlocksStore, nil, 0, 0, 100).(*DStoreIO)
+
+	start, err := mio.FindStartBlock(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2400), start)
+	assert.Less(t, probes, 12, "the merged files are not probed one by one")
+
+	start, err = mio.FindStartBlock(context.Background(), 250)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2400), start)
+
+	start, err = mio.FindStartBlock(context.Background(), 5000)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5000), start, "the hint bundle is missing")
+
+	probes = 0
+	mergedBlocksStore.SetFile(MergedCursorFilename, []byte(`{"base_block":2300,"last_block_num":2399}`))
+	hasCursor = true
+	start, err = mio.FindStartBlock(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2400), start)
+	assert.Equal(t, 3, probes, "hint, cursor and the bundle after it")
+}
+
+type startBlockTestMergerIO struct {
+	TestMergerIO
+	start uint64
+}
+
+func (io *startBlockTestMergerIO) FindStartBlock(_ context.Context, _ uint64) (uint64, error) {
+	return io.start, nil
+}
+
+func TestMerger_SkipMergedBundles(t *testing.T) {
+	mio := &startBlockTestMergerIO{start: 200}
+	m := NewMerger(testLogger, "", mio, 100, 100, 100, time.Second, time.Second, 0, WithStartBlockProbing())
+	m.skipMergedBundles(context.Background())
+	assert.Equal(t, uint64(100), m.bundler.BaseBlockNum(), "a single merged bundle is walked anyway")
+
+	mio.start = 5000
+	m.skipMergedBundles(context.Background())
+	assert.Equal(t, uint64(4900), m.bundler.BaseBlockNum(), "the last merged bundle gives the LIB to the next one")
+
+	m = NewMerger(testLogger, "", mio, 100, 100, 100, time.Second, time.Second, 0)
+	m.skipMergedBundles(context.Background())
+	assert.Equal(t, uint64(100), m.bundler.BaseBlockNum(), "probing is opt-in")
+}
@@ -0,0 +1,190 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+)
+
+// CumulativeCounters are the totals kept across restarts in the state file
+type CumulativeCounters struct {
+	BundlesMerged uint64 `json:"bundles_merged"`
+	BytesWritten  uint64 `json:"bytes_written"`
+	FilesDeleted  uint64 `json:"files_deleted"`
+}
+
+type mergerState struct {
+	Counters       CumulativeCounters `json:"counters"`
+	WalkResumeName string             `json:"walk_resume_name,omitempty"`
+	LastShutdown   *ShutdownProgress  `json:"last_shutdown,omitempty"`
+	FlushedBundle  *uint64            `json:"flushed_bundle,omitempty"`
+	RetainedFrom   uint64             `json:"retained_from,omitempty"`
+}
+
+// counters tracks cumulative counters, both for this process (metrics counters) and all-time
+// (metrics gauges, restored from the state file when there is one)
+type counters struct {
+	sync.Mutex
+	stateFilePath string
+	allTime       CumulativeCounters
+
+	bytesWrittenSource func() uint64
+	lastBytesWritten   uint64
+
+	walkResumeName func() string
+	lastShutdown   *ShutdownProgress
+	flushedBundle  *uint64
+	retainedFrom   uint64
+}
+
+// WithStateFile persists the all-time cumulative counters to `path`, so that totals survive restarts
+func WithStateFile(path string) Option {
+	return func(m *Merger) {
+		m.counters.stateFilePath = path
+	}
+}
+
+func newCounters(io IOInterface) *counters {
+	c := &counters{}
+	if counter, ok := io.(interface{ BytesWritten() uint64 }); ok {
+		c.bytesWrittenSource = counter.BytesWritten
+	}
+	return c
+}
+
+// load restores the all-time counters from the state file, a missing file means we start from zero
+func (c *counters) load() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.stateFilePath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.stateFilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("reading state file: %w", err)
+	}
+	state := &mergerState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("decoding state file %q: %w", c.stateFilePath, err)
+	}
+	c.allTime = state.Counters
+	c.lastShutdown = state.LastShutdown
+	c.flushedBundle = state.FlushedBundle
+	c.retainedFrom = state.RetainedFrom
+	c.setAllTimeMetrics()
+	return nil
+}
+
+// save writes the state file atomically (write then rename)
+func (c *counters) save() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.stateFilePath == "" {
+		return nil
+	}
+
+	state := &mergerState{Counters: c.allTime, LastShutdown: c.lastShutdown, FlushedBundle: c.flushedBundle, RetainedFrom: c.retainedFrom}
+	if c.walkResumeName != nil {
+		state.WalkResumeName = c.walkResumeName()
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.stateFilePath), ".merger-state-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	return os.Rename(tmp.Name(), c.stateFilePath)
+}
+
+func (c *counters) setLastShutdown(progress *ShutdownProgress) {
+	c.Lock()
+	defer c.Unlock()
+	c.lastShutdown = progress
+}
+
+func (c *counters) setFlushedBundle(baseBlock *uint64) {
+	c.Lock()
+	defer c.Unlock()
+	c.flushedBundle = baseBlock
+}
+
+func (c *counters) setRetainedFrom(baseBlock uint64) {
+	c.Lock()
+	defer c.Unlock()
+	c.retainedFrom = baseBlock
+}
+
+func (c *counters) addMerged() {
+	c.Lock()
+	defer c.Unlock()
+	c.allTime.BundlesMerged++
+	metrics.BundlesMerged.Inc()
+
+	if c.bytesWrittenSource != nil {
+		written := c.bytesWrittenSource()
+		delta := written - c.lastBytesWritten
+		c.lastBytesWritten = written
+		c.allTime.BytesWritten += delta
+		metrics.BytesWritten.AddUint64(delta)
+	}
+	c.setAllTimeMetrics()
+}
+
+func (c *counters) addDeleted(oneBlockFiles []*bstream.OneBlockFile) {
+	c.Lock()
+	defer c.Unlock()
+	var count uint64
+	for _, obf := range oneBlockFiles {
+		count += uint64(len(obf.Filenames))
+	}
+	c.allTime.FilesDeleted += count
+	metrics.FilesDeleted.AddUint64(count)
+	c.setAllTimeMetrics()
+}
+
+func (c *counters) setAllTimeMetrics() {
+	metrics.BundlesMergedAllTime.SetUint64(c.allTime.BundlesMerged)
+	metrics.BytesWrittenAllTime.SetUint64(c.allTime.BytesWritten)
+	metrics.FilesDeletedAllTime.SetUint64(c.allTime.FilesDeleted)
+}
+
+// AllTimeCounters returns the cumulative counters, including the ones restored from the state file
+func (m *Merger) AllTimeCounters() CumulativeCounters {
+	m.counters.Lock()
+	defer m.counters.Unlock()
+	return m.counters.allTime
+}
@@ -0,0 +1,60 @@
+package merger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBytesWrittenIO struct {
+	TestMergerIO
+	written uint64
+}
+
+func (io *testBytesWrittenIO) BytesWritten() uint64 { return io.written }
+
+func TestMerger_StateFileCounters(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	mio := &testBytesWrittenIO{}
+	m := NewMerger(testLogger, "", mio, 100, 100, 100, time.Second, time.Second, 0, WithStateFile(statePath))
+	require.NoError(t, m.counters.load())
+
+	mio.written = 1000
+	m.bundler.onMerged(100, nil)
+	mio.written = 1500
+	m.bundler.onMerged(200, nil)
+	m.counters.addDeleted([]*bstream.OneBlockFile{block100, block101})
+	require.NoError(t, m.counters.save())
+
+	assert.Equal(t, CumulativeCounters{BundlesMerged: 2, BytesWritten: 1500, FilesDeleted: 2}, m.AllTimeCounters())
+
+	// restarted process
+	mio = &testBytesWrittenIO{}
+	m = NewMerger(testLogger, "", mio, 300, 100, 100, time.Second, time.Second, 0, WithStateFile(statePath))
+	require.NoError(t, m.counters.load())
+	assert.Equal(t, CumulativeCounters{BundlesMerged: 2, BytesWritten: 1500, FilesDeleted: 2}, m.AllTimeCounters())
+
+	mio.written = 200
+	m.bundler.onMerged(300, nil)
+	assert.Equal(t, CumulativeCounters{BundlesMerged: 3, BytesWritten: 1700, FilesDeleted: 2}, m.AllTimeCounters())
+}
+
+func TestCounters_MissingOrCorruptStateFile(t *testing.T) {
+	dir := t.TempDir()
+
+	c := newCounters(&TestMergerIO{})
+	c.stateFilePath = filepath.Join(dir, "missing.json")
+	require.NoError(t, c.load())
+	assert.Equal(t, CumulativeCounters{}, c.allTime)
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	require.NoError(t, os.WriteFile(corrupt, []byte("{not json"), 0644))
+	c.stateFilePath = corrupt
+	require.Error(t, c.load())
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"sync"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/logging"
+	"go.uber.org/zap"
+)
+
+// WithMaxBundleMemory streams the one-block files into the merged file instead of memoizing all of them before writing:
+// blocks are downloaded ahead (up to the prefetch concurrency) only while less than `maxBytes` of downloaded data is
+// waiting to be written, and their data is not kept once written. The bundler then does not pre-download the
+// irreversible blocks either. Meant for chains with large blocks, 0 (the default) memoizes whole bundles.
+func WithMaxBundleMemory(maxBytes uint64) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.maxBundleMemory = maxBytes
+	}
+}
+
+// streamsBundles tells the bundler not to memoize the one-block files data ahead of merging
+func (s *DStoreIO) streamsBundles() bool {
+	return s.maxBundleMemory != 0
+}
+
+type streamingIO interface {
+	streamsBundles() bool
+}
+
+// byteBudget bounds the bytes of data held between download and write
+type byteBudget struct {
+	sync.Mutex
+	max      uint64
+	used     uint64
+	released chan struct{}
+}
+
+func newByteBudget(max uint64) *byteBudget {
+	return &byteBudget{max: max, released: make(chan struct{}, 1)}
+}
+
+// wait blocks until some budget is left (a single block can always go through when nothing is held)
+func (b *byteBudget) wait(ctx context.Context) error {
+	for {
+		b.Lock()
+		full := b.used != 0 && b.used >= b.max
+		b.Unlock()
+		if !full {
+			return nil
+		}
+		select {
+		case <-b.released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *byteBudget) acquire(size int) {
+	b.Lock()
+	defer b.Unlock()
+	b.used += uint64(size)
+}
+
+func (b *byteBudget) release(size int) {
+	b.Lock()
+	b.used -= uint64(size)
+	b.Unlock()
+	select {
+	case b.released <- struct{}{}:
+	default:
+	}
+}
+
+// NewStreamingBundleReader reads the one-block files like NewBundleReader, downloading up to `concurrency` of them
+// ahead while less than `maxBytes` are waiting to be read, without memoizing their data
+func NewStreamingBundleReader(ctx context.Context, logger *zap.Logger, tracer logging.Tracer, oneBlockFiles []*bstream.OneBlockFile, oneBlockDownloader bstream.OneBlockDownloaderFunc, concurrency int, maxBytes uint64) *BundleReader {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &BundleReader{
+		ctx:              ctx,
+		cancel:           cancel,
+		logger:           logger,
+		oneBlockDataChan: make(chan *oneBlockData, 1),
+		errChan:          make(chan error, 1),
+		budget:           newByteBudget(maxBytes),
+	}
+	go r.readAhead(oneBlockFiles, func(ctx context.Context, obf *bstream.OneBlockFile) ([]byte, error) {
+		return streamedData(ctx, obf, oneBlockDownloader)
+	}, concurrency)
+	return r
+}
+
+// streamedData uses the memoized data if there is any, but does not memoize what it downloads
+func streamedData(ctx context.Context, obf *bstream.OneBlockFile, oneBlockDownloader bstream.OneBlockDownloaderFunc) ([]byte, error) {
+	obf.Lock()
+	data := obf.MemoizeData
+	obf.Unlock()
+	if len(data) != 0 {
+		return data, nil
+	}
+	return oneBlockDownloader(ctx, obf)
+}
@@ -0,0 +1,60 @@
+package merger
+
+import (
+	"context"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamingBundleReader(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 0
+	bundle := NewDownloadBundle()
+	content := map[string][]byte{
+		"o1": {0x1, 0x2},
+		"o2": {0x3, 0x4},
+		"o3": {0x5, 0x6},
+	}
+
+	var downloads int32
+	downloadOneBlockFile := func(ctx context.Context, oneBlockFile *bstream.OneBlockFile) ([]byte, error) {
+		atomic.AddInt32(&downloads, 1)
+		return content[oneBlockFile.CanonicalName], nil
+	}
+
+	r := NewStreamingBundleReader(context.Background(), testLogger, testTracer, bundle, downloadOneBlockFile, 1, 2)
+	time.Sleep(20 * time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&downloads), "the first block fills the budget until it is read")
+
+	out, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x1, 0x2, 0x3, 0x4, 0x5, 0x6}, out)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&downloads))
+	for _, obf := range bundle {
+		assert.Empty(t, obf.MemoizeData, "streamed data is not memoized")
+	}
+	assert.EqualValues(t, 0, r.budget.used)
+}
+
+func TestStreamingBundleReader_UsesMemoizedData(t *testing.T) {
+	bundle := NewTestBundle()
+	r := NewStreamingBundleReader(context.Background(), testLogger, testTracer, bundle, nil, 2, 1024)
+	out, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x1, 0x2, 0x3, 0x4, 0x5, 0x6}, out)
+}
+
+func TestBundler_StreamingSkipsPreDownload(t *testing.T) {
+	mio := NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), dstore.NewMockStore(nil), nil, 0, 0, 100, WithMaxBundleMemory(1024))
+	b := NewBundler(100, 0, 100, 100, mio)
+	assert.True(t, b.streaming)
+
+	b = NewBundler(100, 0, 100, 100, NewDStoreIO(testLogger, testTracer, dstore.NewMockStore(nil), dstore.NewMockStore(nil), nil, 0, 0, 100))
+	assert.False(t, b.streaming)
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// names of the spans of the merge cycle
+const (
+	SpanWalk             = "merger.walk"                 // a cycle walking the one-block files and handling them in the bundler
+	SpanListOneBlocks    = "merger.list_one_block_files" // the listing of the one-block store
+	SpanMergeAndStore    = "merger.merge_and_store"      // a merged file downloaded, merged and uploaded
+	SpanPrefetch         = "merger.prefetch"             // the one-block files of a bundle downloaded before merging
+	SpanUpload           = "merger.upload"               // the merged file written, the one-block files are downloaded meanwhile when streaming
+	SpanDownloadOneBlock = "merger.download_one_block_file"
+	SpanDeleteOneBlocks  = "merger.delete_one_block_files"
+)
+
+// attributes of the spans
+const (
+	AttributeLowBlockNum = "merger.low_block_num"
+	AttributeBlockNum    = "merger.block_num"
+	AttributeFileCount   = "merger.file_count"
+)
+
+// SpanAttribute is a key and value attached to a span
+type SpanAttribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanTracer starts the spans of the merge cycle, see WithSpanTracer. Its shape follows the OpenTelemetry trace.Tracer, which
+// adapts to it by converting the attributes and recording the error given to End.
+type SpanTracer interface {
+	Start(ctx context.Context, name string, attributes ...SpanAttribute) (context.Context, Span)
+}
+
+// Span is ended once with the error of the operation it covers, nil on success
+type Span interface {
+	SetAttributes(attributes ...SpanAttribute)
+	End(err error)
+}
+
+// WithSpanTracer traces the walks of the merger, see WithIOSpanTracer for the merges, downloads and deletions
+func WithSpanTracer(tracer SpanTracer) Option {
+	return func(m *Merger) {
+		m.spanTracer = tracer
+	}
+}
+
+// WithIOSpanTracer traces the listings, merges, downloads and deletions of the DStoreIO
+func WithIOSpanTracer(tracer SpanTracer) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.spanTracer = tracer
+	}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...SpanAttribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(_ ...SpanAttribute) {}
+func (noopSpan) End(_ error)                      {}
+
+// NewLogSpanTracer logs each span once ended with its duration and attributes, at debug level unless it failed. It needs
+// no collector, to find where the time of a slow cycle goes.
+func NewLogSpanTracer(logger *zap.Logger) SpanTracer {
+	return &logTracer{logger: logger}
+}
+
+type logTracer struct {
+	logger *zap.Logger
+}
+
+func (t *logTracer) Start(ctx context.Context, name string, attributes ...SpanAttribute) (context.Context, Span) {
+	return ctx, &logSpan{logger: t.logger, name: name, start: time.Now(), attributes: attributes}
+}
+
+type logSpan struct {
+	logger     *zap.Logger
+	name       string
+	start      time.Time
+	attributes []SpanAttribute
+}
+
+func (s *logSpan) SetAttributes(attributes ...SpanAttribute) {
+	s.attributes = append(s.attributes, attributes...)
+}
+
+func (s *logSpan) End(err error) {
+	fields := []zap.Field{zap.String("span", s.name), zap.Duration("duration", time.Since(s.start))}
+	for _, attribute := range s.attributes {
+		fields = append(fields, zap.Any(attribute.Key, attribute.Value))
+	}
+	if err != nil {
+		s.logger.Warn("span failed", append(fields, zap.Error(err))...)
+		return
+	}
+	s.logger.Debug("span ended", fields...)
+}
+
+// ParseTracingExporter returns the tracer of the exporter: none (or empty) or log
+func ParseTracingExporter(in string, logger *zap.Logger) (SpanTracer, error) {
+	switch in {
+	case "", "none":
+		return noopTracer{}, nil
+	case "log":
+		return NewLogSpanTracer(logger), nil
+	}
+	return nil, fmt.Errorf("invalid tracing exporter %q, expected none or log", in)
+}
@@ -0,0 +1,109 @@
+package merger
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedSpan struct {
+	name       string
+	attributes map[string]interface{}
+	err        error
+}
+
+type recordingTracer struct {
+	lock  sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attributes ...SpanAttribute) (context.Context, Span) {
+	return ctx, &recordingSpan{tracer: t, span: &recordedSpan{name: name, attributes: make(map[string]interface{})}, attributes: attributes}
+}
+
+func (t *recordingTracer) ended(name string) (out []*recordedSpan) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, span := range t.spans {
+		if span.name == name {
+			out = append(out, span)
+		}
+	}
+	return out
+}
+
+type recordingSpan struct {
+	tracer     *recordingTracer
+	span       *recordedSpan
+	attributes []SpanAttribute
+}
+
+func (s *recordingSpan) SetAttributes(attributes ...SpanAttribute) {
+	s.attributes = append(s.attributes, attributes...)
+}
+
+func (s *recordingSpan) End(err error) {
+	for _, attribute := range s.attributes {
+		s.span.attributes[attribute.Key] = attribute.Value
+	}
+	s.span.err = err
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s.span)
+}
+
+func TestParseTracingExporter(t *testing.T) {
+	for _, in := range []string{"", "none", "log"} {
+		_, err := ParseTracingExporter(in, testLogger)
+		assert.NoError(t, err, in)
+	}
+	_, err := ParseTracingExporter("otlp", testLogger)
+	assert.Error(t, err)
+}
+
+func TestDStoreIO_SpanTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	oneBlockStore := dstore.NewMockStore(nil)
+	oneBlockStore.OpenObjectFunc = func(_ context.Context, name string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	oneBlockStore.SetFile("0000000100-0000000000000100a-0000000000000099a-98-suffix", nil)
+	mergedBlocksStore := dstore.NewMockStore(func(base string, f io.Reader) error { return nil })
+	mio := NewDStoreIO(testLogger, testTracer, oneBlockStore, mergedBlocksStore, nil, 0, 0, 100, WithIOSpanTracer(tracer))
+
+	require.NoError(t, mio.MergeAndStore(context.Background(), 100, []*bstream.OneBlockFile{block100, block101}))
+
+	merges := tracer.ended(SpanMergeAndStore)
+	require.Len(t, merges, 1)
+	assert.NoError(t, merges[0].err)
+	assert.Equal(t, uint64(100), merges[0].attributes[AttributeLowBlockNum])
+	assert.Equal(t, 2, merges[0].attributes[AttributeFileCount])
+	assert.Len(t, tracer.ended(SpanPrefetch), 1)
+	assert.Len(t, tracer.ended(SpanUpload), 1)
+
+	require.NoError(t, mio.WalkOneBlockFiles(context.Background(), 100, func(_ *bstream.OneBlockFile) error { return nil }))
+	walks := tracer.ended(SpanListOneBlocks)
+	require.Len(t, walks, 1)
+	assert.Equal(t, 1, walks[0].attributes[AttributeFileCount])
+
+	_, err := mio.DownloadOneBlockFile(context.Background(), block101)
+	require.NoError(t, err)
+	var downloaded bool
+	for _, span := range tracer.ended(SpanDownloadOneBlock) {
+		downloaded = downloaded || span.attributes[AttributeBlockNum] == uint64(101)
+	}
+	assert.True(t, downloaded)
+
+	require.NoError(t, mio.DeleteAsync([]*bstream.OneBlockFile{block100}))
+	deletions := tracer.ended(SpanDeleteOneBlocks)
+	require.Len(t, deletions, 1)
+	assert.Equal(t, 1, deletions[0].attributes[AttributeFileCount])
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// ValidationPolicy is what happens when a bundle fails a validation check
+type ValidationPolicy int
+
+const (
+	ValidationOff     ValidationPolicy = iota // the check is not run
+	ValidationWarn                            // the failure is logged and counted, the bundle is merged anyway
+	ValidationEnforce                         // the merge of the bundle fails
+)
+
+func (p ValidationPolicy) String() string {
+	switch p {
+	case ValidationWarn:
+		return "warn"
+	case ValidationEnforce:
+		return "enforce"
+	}
+	return "off"
+}
+
+// Validation checks run on the blocks of each bundle before it is written
+const (
+	// ValidationCodec checks that all the one-block files of a bundle use the same codec (see BlockCodec)
+	ValidationCodec = "codec"
+	// ValidationLinkage checks that each block of a bundle has the previous one as parent
+	ValidationLinkage = "linkage"
+	// ValidationTimestampMonotonicity checks that the block times of a bundle never go backward
+	ValidationTimestampMonotonicity = "timestamp_monotonicity"
+	// ValidationContinuity checks that the first block of a bundle has the last block of the previous merged file as parent
+	ValidationContinuity = "continuity"
+)
+
+// ValidationPolicies maps the validation checks to their policy, a check missing from the map uses its default
+type ValidationPolicies map[string]ValidationPolicy
+
+// DefaultValidationPolicies keeps the behavior of the merger before validation policies existed
+var DefaultValidationPolicies = ValidationPolicies{
+	ValidationCodec:                 ValidationEnforce,
+	ValidationLinkage:               ValidationOff,
+	ValidationTimestampMonotonicity: ValidationOff,
+	ValidationContinuity:            ValidationOff,
+}
+
+func (p ValidationPolicies) policy(check string) ValidationPolicy {
+	if policy, ok := p[check]; ok {
+		return policy
+	}
+	return DefaultValidationPolicies[check]
+}
+
+// ParseValidationPolicies parses `<check>=<policy>` entries, policies being one of off, warn or enforce
+func ParseValidationPolicies(specs []string) (ValidationPolicies, error) {
+	out := make(ValidationPolicies, len(specs))
+	for _, spec := range specs {
+		check, policyStr, found := strings.Cut(spec, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid validation policy %q, expected <check>=<policy>", spec)
+		}
+		if _, known := DefaultValidationPolicies[check]; !known {
+			return nil, fmt.Errorf("unknown validation check %q in %q", check, spec)
+		}
+		switch policyStr {
+		case "off":
+			out[check] = ValidationOff
+		case "warn":
+			out[check] = ValidationWarn
+		case "enforce":
+			out[check] = ValidationEnforce
+		default:
+			return nil, fmt.Errorf("invalid validation policy %q in %q, expected one of off, warn or enforce", policyStr, spec)
+		}
+	}
+	return out, nil
+}
+
+// WithValidationPolicies sets the policy of the validation checks, so that they can be observed (warn) before being enforced
+func WithValidationPolicies(policies ValidationPolicies) DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.validationPolicies = policies
+	}
+}
+
+// validationFailed applies the policy of `check` to its failure: the error is returned only if the check is enforced
+func validationFailed(logger *zap.Logger, policies ValidationPolicies, check string, err error) error {
+	policy := policies.policy(check)
+	if policy == ValidationOff {
+		return nil
+	}
+	metrics.ValidationFailures.Inc(check, policy.String())
+	if policy == ValidationEnforce {
+		return err
+	}
+	logger.Warn("bundle validation failed, continuing", zap.String("check", check), zap.Error(err))
+	return nil
+}
+
+// validateLinkage checks that the one-block files, in block order, are each the parent of the next one
+func validateLinkage(oneBlockFiles []*bstream.OneBlockFile) error {
+	for i := 1; i < len(oneBlockFiles); i++ {
+		if oneBlockFiles[i].PreviousID != oneBlockFiles[i-1].ID {
+			return fmt.Errorf("block %s does not link to previous block %s", oneBlockFiles[i], oneBlockFiles[i-1])
+		}
+	}
+	return nil
+}
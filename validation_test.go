@@ -0,0 +1,70 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseValidationPolicies(t *testing.T) {
+	policies, err := ParseValidationPolicies([]string{"codec=warn", "linkage=enforce"})
+	require.NoError(t, err)
+	assert.Equal(t, ValidationWarn, policies.policy(ValidationCodec))
+	assert.Equal(t, ValidationEnforce, policies.policy(ValidationLinkage))
+	assert.Equal(t, ValidationOff, policies.policy(ValidationTimestampMonotonicity))
+
+	var none ValidationPolicies
+	assert.Equal(t, ValidationEnforce, none.policy(ValidationCodec))
+
+	for _, spec := range []string{"codec", "checksum=warn", "codec=maybe"} {
+		_, err = ParseValidationPolicies([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestValidationFailed(t *testing.T) {
+	failure := errors.New("failed")
+	policies := ValidationPolicies{ValidationCodec: ValidationWarn, ValidationLinkage: ValidationEnforce}
+	assert.NoError(t, validationFailed(testLogger, policies, ValidationCodec, failure))
+	assert.Equal(t, failure, validationFailed(testLogger, policies, ValidationLinkage, failure))
+	assert.NoError(t, validationFailed(testLogger, policies, ValidationTimestampMonotonicity, failure))
+}
+
+func TestValidateLinkage(t *testing.T) {
+	assert.NoError(t, validateLinkage([]*bstream.OneBlockFile{block100, block101, block102Final100}))
+	assert.Error(t, validateLinkage([]*bstream.OneBlockFile{block100, block102Final100}))
+}
+
+func TestBundleReader_WarnsOnMixedCodecs(t *testing.T) {
+	r := NewBundleReader(context.Background(), testLogger, testTracer, codecTestBundle(), nil)
+	r.validationPolicies = ValidationPolicies{ValidationCodec: ValidationWarn}
+
+	content, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, dbinData("ETH", "02", 0x1, 0x2), content)
+}
+
+func TestBundleReader_TimestampMonotonicity(t *testing.T) {
+	bundle := func() []*bstream.OneBlockFile {
+		bstream.GetBlockWriterHeaderLen = 0
+		return []*bstream.OneBlockFile{
+			{CanonicalName: "o1", Num: 1, MemoizeData: []byte(`{"id":"00000001a","time":"2022-01-01T00:00:02.000"}` + "\n")},
+			{CanonicalName: "o2", Num: 2, MemoizeData: []byte(`{"id":"00000002a","time":"2022-01-01T00:00:01.000"}` + "\n")},
+		}
+	}
+
+	r := NewBundleReader(context.Background(), testLogger, testTracer, bundle(), nil)
+	r.validationPolicies = ValidationPolicies{ValidationTimestampMonotonicity: ValidationEnforce}
+	_, err := ioutil.ReadAll(r)
+	assert.Error(t, err)
+
+	r = NewBundleReader(context.Background(), testLogger, testTracer, bundle(), nil)
+	r.validationPolicies = ValidationPolicies{ValidationTimestampMonotonicity: ValidationWarn}
+	_, err = ioutil.ReadAll(r)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sadiq1971/merger/metrics"
+	"github.com/streamingfast/bstream"
+	"go.uber.org/zap"
+)
+
+// WithVerifyAfterMerge reads each merged file back after uploading it, and fails the merge unless it contains
+// every merged block, decodable and linked to the previous one. The one-block files are only deleted once
+// their merged file was verified, a truncated upload is deleted and merged again on the next cycle.
+func WithVerifyAfterMerge() DStoreIOOption {
+	return func(s *DStoreIO) {
+		s.verifyAfterMerge = true
+	}
+}
+
+// MergeVerificationError is returned by MergeAndStore when the merged file read back does not hold the merged blocks
+type MergeVerificationError struct {
+	Filename string
+	Reason   string
+}
+
+func (e *MergeVerificationError) Error() string {
+	return fmt.Sprintf("merged file %s failed verification: %s", e.Filename, e.Reason)
+}
+
+// verifyMergedFile reads the merged file of the bundle at baseBlock back and compares it with `expected`
+func (s *DStoreIO) verifyMergedFile(ctx context.Context, baseBlock uint64, expected []*bstream.OneBlockFile) error {
+	filename := fileNameForBlocksBundle(baseBlock)
+	s.mergedFilesCache.remove(baseBlock)
+	f, err := s.fetchMergedFile(ctx, baseBlock)
+	if err != nil {
+		return &MergeVerificationError{Filename: filename, Reason: fmt.Sprintf("reading back: %s", err)}
+	}
+	// the merged file is only needed here, it will be fetched again by whoever needs it
+	s.mergedFilesCache.remove(baseBlock)
+
+	if len(f.oneBlockFiles) != len(expected) {
+		return &MergeVerificationError{Filename: filename, Reason: fmt.Sprintf("found %d blocks, expected %d", len(f.oneBlockFiles), len(expected))}
+	}
+	for i, obf := range f.oneBlockFiles {
+		if bstream.TruncateBlockID(obf.ID) != bstream.TruncateBlockID(expected[i].ID) || obf.Num != expected[i].Num {
+			return &MergeVerificationError{Filename: filename, Reason: fmt.Sprintf("found block #%d (%s) at position %d, expected %s", obf.Num, obf.ID, i, expected[i])}
+		}
+		if i > 0 && bstream.TruncateBlockID(obf.PreviousID) != bstream.TruncateBlockID(f.oneBlockFiles[i-1].ID) {
+			return &MergeVerificationError{Filename: filename, Reason: fmt.Sprintf("block #%d (%s) does not link to previous block %s", obf.Num, obf.ID, f.oneBlockFiles[i-1].ID)}
+		}
+	}
+	return nil
+}
+
+func (s *DStoreIO) rejectMergedFile(baseBlock uint64, verifyErr error) {
+	metrics.MergeVerificationFailures.Inc()
+	s.logger.Error("merged file failed verification, deleting it, its one-block files are kept", zap.Uint64("base_block", baseBlock), zap.Error(verifyErr))
+	s.mergedFilesCache.remove(baseBlock)
+	if err := s.mergedStoreFor(baseBlock).DeleteObject(context.Background(), fileNameForBlocksBundle(baseBlock)); err != nil {
+		s.logger.Warn("cannot delete merged file that failed verification", zap.Uint64("base_block", baseBlock), zap.Error(err))
+	}
+}
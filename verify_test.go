@@ -0,0 +1,62 @@
+package merger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/streamingfast/bstream"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergerIO_VerifyAfterMerge(t *testing.T) {
+	bstream.GetBlockWriterHeaderLen = 0
+	oneBlockData := func(num uint64) []byte {
+		return []byte(fmt.Sprintf(`{"id":"%016da","prev":"%016da","num":%d}`+"\n", num, num-1, num))
+	}
+	newFiles := func() []*bstream.OneBlockFile {
+		return []*bstream.OneBlockFile{
+			mustNewOneBlockFile("0000000100-0000000000000100a-0000000000000099a-98-suffix"),
+			mustNewOneBlockFile("0000000101-0000000000000101a-0000000000000100a-99-suffix"),
+		}
+	}
+	oneBlocksStore := dstore.NewMockStore(nil)
+	oneBlocksStore.SetFile("0000000100-0000000000000100a-0000000000000099a-98-suffix", oneBlockData(100))
+	oneBlocksStore.SetFile("0000000101-0000000000000101a-0000000000000100a-99-suffix", oneBlockData(101))
+
+	t.Run("complete upload", func(t *testing.T) {
+		mergedStore := dstore.NewMockStore(nil)
+		mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, mergedStore, nil, 0, 0, 100, WithVerifyAfterMerge())
+
+		require.NoError(t, mio.MergeAndStore(context.Background(), 100, newFiles()))
+		exists, err := mergedStore.FileExists(context.Background(), "0000000100")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("truncated upload", func(t *testing.T) {
+		mergedStore := dstore.NewMockStore(nil)
+		mergedStore.WriteObjectFunc = func(_ context.Context, base string, f io.Reader) error {
+			data, err := ioutil.ReadAll(f)
+			if err != nil {
+				return err
+			}
+			mergedStore.SetFile(base, data[:len(oneBlockData(100))])
+			return nil
+		}
+		mio := NewDStoreIO(testLogger, testTracer, oneBlocksStore, mergedStore, nil, 0, 0, 100, WithVerifyAfterMerge())
+
+		err := mio.MergeAndStore(context.Background(), 100, newFiles())
+		var verifyErr *MergeVerificationError
+		require.ErrorAs(t, err, &verifyErr)
+		assert.Equal(t, "0000000100", verifyErr.Filename)
+
+		exists, err := mergedStore.FileExists(context.Background(), "0000000100")
+		require.NoError(t, err)
+		assert.False(t, exists, "the truncated merged file is deleted")
+	})
+}
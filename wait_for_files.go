@@ -0,0 +1,62 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import "time"
+
+// clock is injected in the merger so that the polling waits can be tested deterministically
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// WithBoundaryWait sets how long the merger waits before walking the one-block files again when the last walk
+// reached the boundary of the current bundle (a block at or above the next base block) without closing it:
+// the bundle only waits for its boundary to become irreversible, so the files it needs are about to be uploaded.
+// Otherwise it waits for the polling interval. 0 (the default) always waits for the polling interval.
+func WithBoundaryWait(wait time.Duration) Option {
+	return func(m *Merger) {
+		m.boundaryWait = wait
+	}
+}
+
+// pollDelay is how long to wait, from the start of the cycle, before the next one. `highestWalked` is the highest
+// block seen during the walk of the cycle.
+func (m *Merger) pollDelay(highestWalked uint64) time.Duration {
+	delay := m.timeBetweenPolling
+	if m.adaptivePolling != nil {
+		delay = m.adaptivePolling.current()
+	}
+	if m.boundaryWait != 0 && m.boundaryWait < delay && highestWalked >= m.bundler.BaseBlockNum()+m.bundler.bundleSize {
+		return m.boundaryWait
+	}
+	return delay
+}
+
+// sleepUntilNextPoll returns early if a cycle is triggered through the admin API
+func (m *Merger) sleepUntilNextPoll(cycleStart time.Time, delay time.Duration) {
+	m.setState(StatePolling)
+	if spentTime := m.clock.Now().Sub(cycleStart); spentTime < delay {
+		select {
+		case <-m.clock.After(delay - spentTime):
+		case <-m.triggerCh:
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package merger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now    time.Time
+	waited []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waited = append(c.waited, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestWaitForFiles(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, 10*time.Second, 0, WithBoundaryWait(time.Second))
+	m.clock = clock
+
+	assert.Equal(t, 10*time.Second, m.pollDelay(150), "boundary not reached, waiting for more files")
+	assert.Equal(t, time.Second, m.pollDelay(200), "boundary reached, waiting for it to become irreversible")
+
+	cycleStart := clock.Now()
+	clock.now = clock.now.Add(3 * time.Second) // the cycle took 3 seconds
+	m.sleepUntilNextPoll(cycleStart, m.pollDelay(150))
+	m.sleepUntilNextPoll(clock.Now(), m.pollDelay(200))
+
+	cycleStart = clock.Now()
+	clock.now = clock.now.Add(2 * time.Second) // longer than the boundary wait, no sleep
+	m.sleepUntilNextPoll(cycleStart, m.pollDelay(200))
+
+	assert.Equal(t, []time.Duration{7 * time.Second, time.Second}, clock.waited)
+}
+
+func TestWaitForFilesDefault(t *testing.T) {
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, 10*time.Second, 0)
+	assert.Equal(t, 10*time.Second, m.pollDelay(200))
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sadiq1971/merger/metrics"
+	"go.uber.org/zap"
+)
+
+// errWalkBudgetExceeded stops the walk of a cycle that went over its processing-time budget
+var errWalkBudgetExceeded = errors.New("walk budget exceeded")
+
+// WithWalkBudget caps the time spent handling one-block files in a single walk. Once over the budget, the walk stops
+// after the current file: the blocks handled so far stay linked in the bundler (and complete bundles are merged),
+// and the next cycle starts right away, checking the merged files before continuing the walk from the resume token
+// (see ResumableWalkIOInterface). Without it, a large backlog of one-block files holds back the merge checks
+// until it is entirely listed. 0 (the default) never stops a walk.
+func WithWalkBudget(budget time.Duration) Option {
+	return func(m *Merger) {
+		m.walkBudget = budget
+	}
+}
+
+// overWalkBudget is called after each one-block file handled during the walk started at `walkStart`
+func (m *Merger) overWalkBudget(walkStart time.Time) bool {
+	return m.walkBudget != 0 && m.clock.Now().Sub(walkStart) >= m.walkBudget
+}
+
+func (m *Merger) logWalkBudgetExceeded(filesWalked int, highestWalked uint64) {
+	metrics.WalkBudgetExceeded.Inc()
+	m.componentLoggers.get(LogComponentWalk, m.logger).Info("walk went over its processing budget, continuing on next cycle",
+		zap.Duration("walk_budget", m.walkBudget),
+		zap.Int("files_walked", filesWalked),
+		zap.Uint64("highest_walked", highestWalked),
+		zap.String("resume_name", m.WalkResumeName()),
+	)
+}
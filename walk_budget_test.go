@@ -0,0 +1,65 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkBudget(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var m *Merger
+	var walks []string
+	var nextBundleChecks int
+	io := &resumableTestMergerIO{
+		TestMergerIO: &TestMergerIO{
+			NextBundleFunc: func(ctx context.Context, lowestBaseBlock uint64) (uint64, bstream.BlockRef, error) {
+				nextBundleChecks++
+				return 100, nil, nil
+			},
+			WalkOneBlockFilesFunc: func(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+				walks = append(walks, "full")
+				for _, name := range []string{
+					"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+					"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+					"0000000102-0000000000000102a-0000000000000101a-100-suffix",
+				} {
+					clock.now = clock.now.Add(time.Second) // each file takes a second to handle
+					if err := callback(mustNewOneBlockFile(name)); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		walkFromFunc: func(ctx context.Context, startName string, callback func(*bstream.OneBlockFile) error) error {
+			walks = append(walks, startName)
+			m.Shutdown(nil)
+			return nil
+		},
+	}
+	m = NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Minute, 0, WithWalkBudget(2*time.Second))
+	m.clock = clock
+
+	require.NoError(t, m.run(context.Background()))
+	assert.Equal(t, []string{
+		"full",
+		"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+	}, walks, "second walk resumes after the last file handled")
+	assert.Equal(t, 2, nextBundleChecks)
+	assert.Len(t, clock.waited, 1, "no wait after a walk over its budget")
+}
+
+func TestWalkBudgetDisabled(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0)
+	m.clock = clock
+
+	walkStart := clock.Now()
+	clock.now = clock.now.Add(time.Hour)
+	assert.False(t, m.overWalkBudget(walkStart))
+}
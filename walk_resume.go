@@ -0,0 +1,97 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"context"
+	"sync"
+
+	"github.com/streamingfast/bstream"
+)
+
+// ResumableWalkIOInterface is implemented by the IOs able to list one-block files starting at a given filename
+// (inclusive), so that each cycle resumes from the last one-block file processed instead of the bundle base.
+type ResumableWalkIOInterface interface {
+	WalkOneBlockFilesFrom(ctx context.Context, startName string, callback func(*bstream.OneBlockFile) error) error
+}
+
+// FullWalkEvery makes one walk out of FullWalkEvery start at the bundle base anyway, to pick up
+// the one-block files uploaded late with a name lower than the resume token (ex: forked blocks)
+var FullWalkEvery = 10
+
+// walkResumer tracks the resume token: the highest one-block filename handled by the bundler.
+// The token is only valid as long as the bundler keeps the blocks before it, so it is dropped on every
+// bundler reset. It is persisted in the state file, but a restarted merger always starts with a full walk.
+type walkResumer struct {
+	sync.Mutex
+	name           string
+	walksSinceFull int
+}
+
+func (w *walkResumer) reset() {
+	w.Lock()
+	defer w.Unlock()
+	w.name = ""
+	w.walksSinceFull = 0
+}
+
+// startName returns the filename to resume from, or an empty string when a full walk is due
+func (w *walkResumer) startName() string {
+	w.Lock()
+	defer w.Unlock()
+	if w.name == "" || w.walksSinceFull+1 >= FullWalkEvery {
+		w.walksSinceFull = 0
+		return ""
+	}
+	w.walksSinceFull++
+	return w.name
+}
+
+func (w *walkResumer) observe(obf *bstream.OneBlockFile) {
+	w.Lock()
+	defer w.Unlock()
+	for filename := range obf.Filenames {
+		if filename > w.name {
+			w.name = filename
+		}
+	}
+}
+
+// WalkResumeName returns the one-block filename the next walk resumes from, empty when the IO cannot resume walks
+func (m *Merger) WalkResumeName() string {
+	m.walkResume.Lock()
+	defer m.walkResume.Unlock()
+	return m.walkResume.name
+}
+
+// walkOneBlockFiles resumes from the last one-block file processed when the IO supports it
+func (m *Merger) walkOneBlockFiles(ctx context.Context, callback func(*bstream.OneBlockFile) error) error {
+	resumable, ok := m.io.(ResumableWalkIOInterface)
+	if !ok {
+		return m.io.WalkOneBlockFiles(ctx, m.bundler.baseBlockNum, callback)
+	}
+
+	handle := func(obf *bstream.OneBlockFile) error {
+		err := callback(obf)
+		if err == nil || err == errWalkBudgetExceeded || err == errPausing || err == errShuttingDown { // the file was handled before the walk stopped
+			m.walkResume.observe(obf)
+		}
+		return err
+	}
+	if startName := m.walkResume.startName(); startName != "" {
+		return resumable.WalkOneBlockFilesFrom(ctx, startName, handle)
+	}
+	return m.io.WalkOneBlockFiles(ctx, m.bundler.baseBlockNum, handle)
+}
@@ -0,0 +1,64 @@
+package merger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/bstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resumableTestMergerIO struct {
+	*TestMergerIO
+	walkFromFunc func(ctx context.Context, startName string, callback func(*bstream.OneBlockFile) error) error
+}
+
+func (io *resumableTestMergerIO) WalkOneBlockFilesFrom(ctx context.Context, startName string, callback func(*bstream.OneBlockFile) error) error {
+	return io.walkFromFunc(ctx, startName, callback)
+}
+
+func TestWalkResume(t *testing.T) {
+	defer func(prev int) { FullWalkEvery = prev }(FullWalkEvery)
+	FullWalkEvery = 3
+
+	var walks []string
+	io := &resumableTestMergerIO{
+		TestMergerIO: &TestMergerIO{
+			WalkOneBlockFilesFunc: func(ctx context.Context, inclusiveLowerBlock uint64, callback func(*bstream.OneBlockFile) error) error {
+				walks = append(walks, "full")
+				for _, name := range []string{
+					"0000000100-0000000000000100a-0000000000000099a-98-suffix",
+					"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+				} {
+					if err := callback(mustNewOneBlockFile(name)); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		walkFromFunc: func(ctx context.Context, startName string, callback func(*bstream.OneBlockFile) error) error {
+			walks = append(walks, startName)
+			return callback(mustNewOneBlockFile("0000000102-0000000000000102a-0000000000000101a-100-suffix"))
+		},
+	}
+	m := NewMerger(testLogger, "", io, 100, 100, 100, time.Second, time.Second, 0)
+	noop := func(*bstream.OneBlockFile) error { return nil }
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, m.walkOneBlockFiles(context.Background(), noop))
+	}
+	m.walkResume.reset()
+	require.NoError(t, m.walkOneBlockFiles(context.Background(), noop))
+
+	assert.Equal(t, []string{
+		"full",
+		"0000000101-0000000000000101a-0000000000000100a-99-suffix",
+		"0000000102-0000000000000102a-0000000000000101a-100-suffix",
+		"full",
+		"full",
+	}, walks)
+	assert.Equal(t, "0000000101-0000000000000101a-0000000000000100a-99-suffix", m.WalkResumeName())
+}
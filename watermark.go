@@ -0,0 +1,107 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package merger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"go.uber.org/zap"
+)
+
+// MergedWatermarkFilename is the object written by WithMergedWatermark
+const MergedWatermarkFilename = "merged-watermark.json"
+
+// DefaultWatermarkWriteTimeout bounds the write of the watermark after each merge
+var DefaultWatermarkWriteTimeout = 10 * time.Second
+
+// MergedWatermark tells the readers that every block below ExclusiveHighBlock is merged: they can stop retaining their
+// local copies of these blocks
+type MergedWatermark struct {
+	ExclusiveHighBlock uint64    `json:"exclusive_high_block"`
+	Time               time.Time `json:"time"`
+}
+
+// WithMergedWatermark publishes the merged watermark as MergedWatermarkFilename in `store` on start and after each
+// merged bundle, for the readers to read with ReadMergedWatermark. The last one published is also in the admin status.
+// `store` must not be walked by the merger, the one-block files and merged blocks stores would see a foreign file.
+func WithMergedWatermark(store dstore.Store) Option {
+	return func(m *Merger) {
+		m.watermark = &watermarkPublisher{store: store}
+	}
+}
+
+type watermarkPublisher struct {
+	sync.Mutex
+	store     dstore.Store
+	published *MergedWatermark
+}
+
+// publish never lowers the watermark, a reset of the bundler below it does not unmerge the bundles
+func (p *watermarkPublisher) publish(exclusiveHighBlock uint64, now time.Time, logger *zap.Logger) {
+	p.Lock()
+	defer p.Unlock()
+	if p.published != nil && p.published.ExclusiveHighBlock >= exclusiveHighBlock {
+		return
+	}
+
+	watermark := &MergedWatermark{ExclusiveHighBlock: exclusiveHighBlock, Time: now}
+	data, err := json.Marshal(watermark)
+	if err != nil {
+		logger.Warn("cannot encode merged watermark", zap.Error(err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultWatermarkWriteTimeout)
+	defer cancel()
+	if err := p.store.WriteObject(ctx, MergedWatermarkFilename, bytes.NewReader(data)); err != nil {
+		logger.Warn("cannot publish merged watermark, readers keep the previous one", zap.Uint64("exclusive_high_block", exclusiveHighBlock), zap.Error(err))
+		return
+	}
+	p.published = watermark
+}
+
+// MergedWatermark returns the last watermark published, nil if none was
+func (m *Merger) MergedWatermark() *MergedWatermark {
+	if m.watermark == nil {
+		return nil
+	}
+	m.watermark.Lock()
+	defer m.watermark.Unlock()
+	return m.watermark.published
+}
+
+// ReadMergedWatermark reads the watermark published by a merger in `store`, for the readers
+func ReadMergedWatermark(ctx context.Context, store dstore.Store) (*MergedWatermark, error) {
+	reader, err := store.OpenObject(ctx, MergedWatermarkFilename)
+	if err != nil {
+		return nil, fmt.Errorf("opening merged watermark: %w", err)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading merged watermark: %w", err)
+	}
+	watermark := &MergedWatermark{}
+	if err := json.Unmarshal(data, watermark); err != nil {
+		return nil, fmt.Errorf("decoding merged watermark: %w", err)
+	}
+	return watermark, nil
+}
@@ -0,0 +1,37 @@
+package merger
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergedWatermark(t *testing.T) {
+	store := dstore.NewMockStore(nil)
+	m := NewMerger(testLogger, "", &TestMergerIO{}, 100, 100, 100, time.Second, time.Second, 0, WithMergedWatermark(store))
+	assert.Nil(t, m.MergedWatermark())
+
+	m.bundler.onMerged(100, nil)
+	require.NotNil(t, m.MergedWatermark())
+	assert.Equal(t, uint64(200), m.MergedWatermark().ExclusiveHighBlock)
+	assert.Equal(t, m.MergedWatermark(), m.collectAdminStatus().MergedWatermark)
+
+	watermark, err := ReadMergedWatermark(context.Background(), store)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(200), watermark.ExclusiveHighBlock)
+
+	m.watermark.publish(100, time.Now(), testLogger)
+	assert.Equal(t, uint64(200), m.MergedWatermark().ExclusiveHighBlock, "never lowered")
+
+	store.WriteObjectFunc = func(ctx context.Context, base string, f io.Reader) error {
+		return errors.New("AccessDenied")
+	}
+	m.watermark.publish(300, time.Now(), testLogger)
+	assert.Equal(t, uint64(200), m.MergedWatermark().ExclusiveHighBlock, "failed write not reported as published")
+}